@@ -0,0 +1,71 @@
+package v1
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/enix/kube-image-keeper/controllers"
+	"github.com/enix/kube-image-keeper/internal/registry"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+//+kubebuilder:webhook:path=/validate-core-v1-pod,mutating=false,failurePolicy=ignore,sideEffects=None,groups=core,resources=pods;pods/ephemeralcontainers,verbs=create;update,versions=v1,name=vpod.kb.io,admissionReviewVersions=v1
+
+// CachedImagesValidator rejects pods using images that are not in cache in air-gapped mode, where they can't be pulled
+// from their upstream registry, and allows every pod otherwise. Only images rewritten by the ImageRewriter are checked.
+type CachedImagesValidator struct {
+	Client  client.Client
+	decoder *admission.Decoder
+}
+
+func (v *CachedImagesValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	// Images of existing pods can only change by adding ephemeral containers
+	if !registry.Airgapped || req.Operation != admissionv1.Create && req.SubResource != "ephemeralcontainers" {
+		return admission.Allowed("")
+	}
+
+	pod := &corev1.Pod{}
+	if err := v.decoder.Decode(req, pod); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	uncachedImages, err := v.UncachedImages(ctx, pod)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if len(uncachedImages) > 0 {
+		log.FromContext(ctx).WithName("webhook.pod").Info("rejecting pod using images that are not in cache", "uncachedImages", uncachedImages)
+		return admission.Denied(fmt.Sprintf("images %s are not in cache: %s", strings.Join(uncachedImages, ", "), registry.ErrAirgapped))
+	}
+
+	return admission.Allowed("")
+}
+
+// UncachedImages returns the source images of a pod that are not in cache
+func (v *CachedImagesValidator) UncachedImages(ctx context.Context, pod *corev1.Pod) ([]string, error) {
+	uncachedImages := []string{}
+	for _, cachedImage := range controllers.DesiredCachedImages(ctx, pod) {
+		sourceImage := cachedImage.Spec.SourceImage
+		if err := v.Client.Get(ctx, client.ObjectKeyFromObject(&cachedImage), &cachedImage); apierrors.IsNotFound(err) {
+			uncachedImages = append(uncachedImages, sourceImage)
+		} else if err != nil {
+			return nil, err
+		} else if !cachedImage.Status.IsCached {
+			uncachedImages = append(uncachedImages, sourceImage)
+		}
+	}
+	return uncachedImages, nil
+}
+
+// InjectDecoder injects the decoder
+func (v *CachedImagesValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
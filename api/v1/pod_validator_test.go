@@ -0,0 +1,73 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/controllers"
+	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestCachedImagesValidator(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	cachedImage := func(sourceImage string, isCached bool) *kuikv1alpha1.CachedImage {
+		cachedImage, err := controllers.CachedImageFromSourceImage(sourceImage)
+		g.Expect(err).To(BeNil())
+		cachedImage.Status.IsCached = isCached
+		return cachedImage
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(
+		cachedImage("nginx:1.25", true),
+		cachedImage("alpine:3.19", false),
+	).Build()
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "init", Image: "alpine:3.19"}},
+			Containers: []corev1.Container{
+				{Name: "web", Image: "nginx:1.25"},
+				{Name: "cache", Image: "redis:7"},
+			},
+		},
+	}
+	(&ImageRewriter{ProxyPort: 7439}).RewriteImages(pod, true)
+
+	validator := &CachedImagesValidator{Client: k8sClient}
+	uncachedImages, err := validator.UncachedImages(ctx, pod)
+	g.Expect(err).To(BeNil())
+	g.Expect(uncachedImages).To(ConsistOf("alpine:3.19", "redis:7"))
+
+	decoder, err := admission.NewDecoder(runtime.NewScheme())
+	g.Expect(err).To(BeNil())
+	g.Expect(validator.InjectDecoder(decoder)).To(Succeed())
+	raw, err := json.Marshal(pod)
+	g.Expect(err).To(BeNil())
+	request := func(operation admissionv1.Operation) admission.Request {
+		return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{Operation: operation, Object: runtime.RawExtension{Raw: raw}}}
+	}
+
+	// Pods are only validated in air-gapped mode
+	g.Expect(validator.Handle(ctx, request(admissionv1.Create)).Allowed).To(BeTrue())
+
+	defer func() { registry.Airgapped = false }()
+	registry.Airgapped = true
+
+	// New pods using images that are not in cache are rejected, updates of existing pods are not checked
+	response := validator.Handle(ctx, request(admissionv1.Create))
+	g.Expect(response.Allowed).To(BeFalse())
+	g.Expect(string(response.Result.Reason)).To(Equal("images redis:7, alpine:3.19 are not in cache: upstream registries are not reachable in air-gapped mode"))
+	g.Expect(validator.Handle(ctx, request(admissionv1.Update)).Allowed).To(BeTrue())
+}
@@ -8,32 +8,73 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 
 	_ "crypto/sha256"
 
 	"github.com/enix/kube-image-keeper/controllers"
+	"github.com/enix/kube-image-keeper/internal/proxy"
 	"github.com/enix/kube-image-keeper/internal/registry"
 	"github.com/google/go-containerregistry/pkg/name"
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
+	nodev1 "k8s.io/api/node/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/strings/slices"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
-//+kubebuilder:webhook:path=/mutate-core-v1-pod,mutating=true,failurePolicy=fail,sideEffects=None,groups=core,resources=pods,verbs=create;update,versions=v1,name=mpod.kb.io,admissionReviewVersions=v1
+//+kubebuilder:webhook:path=/mutate-core-v1-pod,mutating=true,failurePolicy=fail,sideEffects=None,groups=core,resources=pods;pods/ephemeralcontainers,verbs=create;update,versions=v1,name=mpod.kb.io,admissionReviewVersions=v1
 
-var (
-	errImageContainsDigests = errors.New("image contains a digest")
-)
+var errImageNotIncluded = errors.New("image doesn't match any included image")
+
+// sandboxedRuntimeHandler matches the handlers of the runtime classes of gVisor and Kata Containers
+var sandboxedRuntimeHandler = regexp.MustCompile(`^(runsc|gvisor|kata(-.+)?)$`)
+
+// podInitializerRetryInterval is how often the PodInitializer tries again to patch pods, e.g. until the webhook is
+// reachable
+const podInitializerRetryInterval = 10 * time.Second
+
+// platformsLookupBudget bounds the total time spent looking up the platforms of the images of a pod, below the 10
+// seconds the API server waits for the webhook by default
+const platformsLookupBudget = 5 * time.Second
 
 type ImageRewriter struct {
 	Client       client.Client
 	IgnoreImages []*regexp.Regexp
-	ProxyPort    int
-	decoder      *admission.Decoder
+	// IncludeImages restricts rewritten images to the ones matching at least one of them, every image is rewritten when
+	// it is empty. IgnoreImages still apply to included images.
+	IncludeImages []*regexp.Regexp
+	ProxyPort     int
+	// ProxyHost is the loopback literal used to reach the proxy in rewritten images, registry.DefaultProxyHost is used
+	// when it is empty
+	ProxyHost string
+	// ProxyPorts resolves the port of the proxy when it had to fall back to another port on some nodes, ProxyPort is
+	// used when it is nil
+	ProxyPorts *proxy.PortResolver
+	// ShortNames resolve short image names like the container runtime of nodes does, e.g. CRI-O, instead of resolving
+	// them to docker.io
+	ShortNames registry.ShortNames
+	// Architectures are the architectures put in cache, images only providing other architectures are not rewritten.
+	// Every architecture is put in cache when it is empty.
+	Architectures []string
+	// RewriteEphemeralContainers tells whether images of ephemeral containers, e.g. added by kubectl debug, are rewritten
+	// and cached as well
+	RewriteEphemeralContainers bool
+	// ImagePlatforms returns the platforms provided by an image used by a pod as os/architecture, nil if it is not a
+	// multi-arch image. Images are rewritten when their platforms can't be looked up, e.g. once the lookups of the pod
+	// have taken platformsLookupBudget. Platforms are not checked if it is nil.
+	ImagePlatforms func(ctx context.Context, image string, pod *corev1.Pod) ([]string, error)
+	// DropImagePullSecrets removes the image pull secrets of new pods whose images are all rewritten, since they are
+	// pulled through the proxy, so that the kubelet doesn't authenticate to it with the credentials of upstream
+	// registries. They are kept in the controllers.AnnotationDroppedImagePullSecretsName annotation.
+	DropImagePullSecrets bool
+	decoder              *admission.Decoder
 }
 
 type PodInitializer struct {
@@ -46,6 +87,8 @@ type RewrittenImage struct {
 	NotRewrittenBecause string
 }
 
+//+kubebuilder:rbac:groups=node.k8s.io,resources=runtimeclasses,verbs=get;list;watch
+
 func (a *ImageRewriter) Handle(ctx context.Context, req admission.Request) admission.Response {
 	log := log.
 		FromContext(ctx).
@@ -58,6 +101,9 @@ func (a *ImageRewriter) Handle(ctx context.Context, req admission.Request) admis
 	}
 
 	rewrittenImages := a.RewriteImages(pod, req.Operation == admissionv1.Create)
+	if req.Operation == admissionv1.Create {
+		pod.Annotations[controllers.AnnotationAdmittedAtName] = time.Now().UTC().Format(time.RFC3339Nano)
+	}
 
 	log.Info("rewriting pod images", "rewrittenImages", rewrittenImages)
 
@@ -83,97 +129,408 @@ func (a *ImageRewriter) RewriteImages(pod *corev1.Pod, isNewPod bool) []Rewritte
 	pod.Labels[controllers.LabelManagedName] = "true"
 	pod.Annotations[controllers.AnnotationRewriteImagesName] = fmt.Sprintf("%t", rewriteImages)
 
+	// Looking up the platforms of images must not make the admission of the pod time out
+	ctx, cancel := context.WithTimeout(context.Background(), platformsLookupBudget)
+	defer cancel()
+
 	rewrittenImages := []RewrittenImage{}
+	handledImages := map[string]handledImage{}
+	proxyPort := a.proxyPort(pod)
+	skippedContainers := skippedContainers(pod)
+	if rewriteImages {
+		delete(pod.Annotations, controllers.AnnotationArchitecturesNotCachedName)
+	}
 
 	// Handle Containers
 	for i := range pod.Spec.Containers {
 		container := &pod.Spec.Containers[i]
-		rewrittenImage := a.handleContainer(pod, container, registry.ContainerAnnotationKey(container.Name, false), rewriteImages)
+		if skippedContainers[container.Name] {
+			rewrittenImages = append(rewrittenImages, skippedContainer(container))
+			continue
+		}
+		rewrittenImage := a.handleContainerOnce(ctx, pod, container, registry.ContainerAnnotationKey(container.Name, false), rewriteImages, proxyPort, handledImages)
 		rewrittenImages = append(rewrittenImages, rewrittenImage)
 	}
 
 	// Handle init containers
 	for i := range pod.Spec.InitContainers {
 		container := &pod.Spec.InitContainers[i]
-		rewrittenImage := a.handleContainer(pod, container, registry.ContainerAnnotationKey(container.Name, true), rewriteImages)
+		if skippedContainers[container.Name] {
+			rewrittenImages = append(rewrittenImages, skippedContainer(container))
+			continue
+		}
+		rewrittenImage := a.handleContainerOnce(ctx, pod, container, registry.ContainerAnnotationKey(container.Name, true), rewriteImages, proxyPort, handledImages)
+		rewrittenImages = append(rewrittenImages, rewrittenImage)
+	}
+
+	// Handle ephemeral containers
+	if a.RewriteEphemeralContainers {
+		for i := range pod.Spec.EphemeralContainers {
+			ephemeralContainer := &pod.Spec.EphemeralContainers[i]
+			container := corev1.Container{Name: ephemeralContainer.Name, Image: ephemeralContainer.Image}
+			if skippedContainers[container.Name] {
+				rewrittenImages = append(rewrittenImages, skippedContainer(&container))
+				continue
+			}
+			rewrittenImage := a.handleContainerOnce(ctx, pod, &container, registry.EphemeralContainerAnnotationKey(container.Name), rewriteImages, proxyPort, handledImages)
+			ephemeralContainer.Image = container.Image
+			rewrittenImages = append(rewrittenImages, rewrittenImage)
+		}
+	}
+
+	// Images held by environment variables or other fields of containers are not pulled for the pod itself, they don't
+	// prevent dropping its image pull secrets
+	envImages := []RewrittenImage{}
+	if envNames := controllers.ImageEnvVarNames(pod); len(envNames) > 0 {
+		for i := range pod.Spec.Containers {
+			container := &pod.Spec.Containers[i]
+			if !skippedContainers[container.Name] {
+				envImages = append(envImages, a.handleEnvVars(ctx, pod, container, false, envNames, rewriteImages, proxyPort, handledImages)...)
+			}
+		}
+		for i := range pod.Spec.InitContainers {
+			container := &pod.Spec.InitContainers[i]
+			if !skippedContainers[container.Name] {
+				envImages = append(envImages, a.handleEnvVars(ctx, pod, container, true, envNames, rewriteImages, proxyPort, handledImages)...)
+			}
+		}
+	}
+	if paths := pod.Annotations[controllers.AnnotationImagePathsName]; paths != "" {
+		for i := range pod.Spec.Containers {
+			container := &pod.Spec.Containers[i]
+			if !skippedContainers[container.Name] {
+				envImages = append(envImages, a.handleFields(ctx, pod, container, false, paths, rewriteImages, proxyPort, handledImages)...)
+			}
+		}
+		for i := range pod.Spec.InitContainers {
+			container := &pod.Spec.InitContainers[i]
+			if !skippedContainers[container.Name] {
+				envImages = append(envImages, a.handleFields(ctx, pod, container, true, paths, rewriteImages, proxyPort, handledImages)...)
+			}
+		}
+	}
+
+	// Image pull secrets can't be changed once the pod is created
+	if a.DropImagePullSecrets && isNewPod {
+		dropImagePullSecrets(pod, rewrittenImages)
+	}
+
+	return append(rewrittenImages, envImages...)
+}
+
+// handleEnvVars rewrites the images held by the environment variables of a container listed by the image env vars
+// annotation of its pod. Environment variables set from a ConfigMap or a Secret are left untouched.
+func (a *ImageRewriter) handleEnvVars(ctx context.Context, pod *corev1.Pod, container *corev1.Container, initContainer bool, envNames map[string]bool, rewriteImages bool, proxyPort int, handledImages map[string]handledImage) []RewrittenImage {
+	rewrittenImages := []RewrittenImage{}
+	for i := range container.Env {
+		env := &container.Env[i]
+		if !envNames[env.Name] || env.Value == "" {
+			continue
+		}
+
+		envContainer := corev1.Container{Name: container.Name, Image: env.Value}
+		rewrittenImage := a.handleContainerOnce(ctx, pod, &envContainer, registry.EnvAnnotationKey(container.Name, env.Name, initContainer), rewriteImages, proxyPort, handledImages)
+		env.Value = envContainer.Image
 		rewrittenImages = append(rewrittenImages, rewrittenImage)
 	}
+	return rewrittenImages
+}
+
+// handleFields rewrites the images held by the fields of a container selected by paths, the image paths annotation of
+// its pod, e.g. its args. Containers are left untouched if paths is not a valid JSONPath template.
+func (a *ImageRewriter) handleFields(ctx context.Context, pod *corev1.Pod, container *corev1.Container, initContainer bool, paths string, rewriteImages bool, proxyPort int, handledImages map[string]handledImage) []RewrittenImage {
+	fields, err := controllers.ContainerImageFields(container, paths)
+	if err != nil {
+		log.Log.WithName("webhook.pod").Info("could not read the fields holding images, ignoring", "container", container.Name, "error", err.Error())
+		return nil
+	}
 
+	rewrittenImages := []RewrittenImage{}
+	values := map[string]string{}
+	for _, field := range fields {
+		if field.Value == "" {
+			continue
+		}
+
+		fieldContainer := corev1.Container{Name: container.Name, Image: field.Value}
+		rewrittenImage := a.handleContainerOnce(ctx, pod, &fieldContainer, registry.FieldAnnotationKey(container.Name, field.Location, initContainer), rewriteImages, proxyPort, handledImages)
+		values[field.Location] = fieldContainer.Image
+		rewrittenImages = append(rewrittenImages, rewrittenImage)
+	}
+
+	controllers.SetContainerFields(container, values)
 	return rewrittenImages
 }
 
+// dropImagePullSecrets removes the image pull secrets of a pod whose images are all rewritten and stores their names in
+// an annotation, so that images are still cached with them and they can be restored
+func dropImagePullSecrets(pod *corev1.Pod, rewrittenImages []RewrittenImage) {
+	if len(pod.Spec.ImagePullSecrets) == 0 {
+		return
+	}
+	for _, rewrittenImage := range rewrittenImages {
+		if rewrittenImage.Rewritten == "" {
+			return
+		}
+	}
+
+	names := make([]string, len(pod.Spec.ImagePullSecrets))
+	for i, imagePullSecret := range pod.Spec.ImagePullSecrets {
+		names[i] = imagePullSecret.Name
+	}
+	pod.Annotations[controllers.AnnotationDroppedImagePullSecretsName] = strings.Join(names, ",")
+	pod.Spec.ImagePullSecrets = nil
+}
+
+// skippedContainers returns the names of the containers of a pod listed by the skip containers annotation
+func skippedContainers(pod *corev1.Pod) map[string]bool {
+	skipped := map[string]bool{}
+	for _, name := range strings.Split(pod.Annotations[controllers.AnnotationSkipContainersName], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			skipped[name] = true
+		}
+	}
+	return skipped
+}
+
+func skippedContainer(container *corev1.Container) RewrittenImage {
+	return RewrittenImage{
+		Original:            container.Image,
+		NotRewrittenBecause: fmt.Sprintf("container is listed by the %s annotation", controllers.AnnotationSkipContainersName),
+	}
+}
+
+// proxyPort returns the port of the proxy to rewrite images of a pod with
+func (a *ImageRewriter) proxyPort(pod *corev1.Pod) int {
+	if port := a.ProxyPorts.Port(pod.Spec.NodeName); port > 0 {
+		return port
+	}
+	return a.ProxyPort
+}
+
+func (a *ImageRewriter) proxyHost() string {
+	if a.ProxyHost == "" {
+		return registry.DefaultProxyHost
+	}
+	return a.ProxyHost
+}
+
 // InjectDecoder injects the decoder
 func (a *ImageRewriter) InjectDecoder(d *admission.Decoder) error {
 	a.decoder = d
 	return nil
 }
 
-func (a *ImageRewriter) handleContainer(pod *corev1.Pod, container *corev1.Container, annotationKey string, rewriteImage bool) RewrittenImage {
+type handledImage struct {
+	rewrittenImage         RewrittenImage
+	sourceImage            string
+	architecturesNotCached bool
+}
+
+// handleContainerOnce handles containers using an image that has already been handled for another container of the
+// same pod (e.g. injected sidecars) without parsing it again. The original image is still stored for every container
+// since it is needed to restore it.
+func (a *ImageRewriter) handleContainerOnce(ctx context.Context, pod *corev1.Pod, container *corev1.Container, annotationKey string, rewriteImage bool, proxyPort int, handledImages map[string]handledImage) RewrittenImage {
+	handled, ok := handledImages[container.Image]
+	if !ok {
+		handled.rewrittenImage, handled.sourceImage, handled.architecturesNotCached = a.handleContainer(ctx, pod, container, rewriteImage, proxyPort)
+		handledImages[container.Image] = handled
+		if handled.architecturesNotCached {
+			notCached := pod.Annotations[controllers.AnnotationArchitecturesNotCachedName]
+			if notCached != "" {
+				notCached += ","
+			}
+			pod.Annotations[controllers.AnnotationArchitecturesNotCachedName] = notCached + container.Image
+		}
+	}
+
+	if handled.sourceImage != "" {
+		pod.Annotations[annotationKey] = handled.sourceImage
+	}
+	if handled.rewrittenImage.Rewritten != "" {
+		container.Image = handled.rewrittenImage.Rewritten
+	}
+
+	return handled.rewrittenImage
+}
+
+// handleContainer computes the rewritten image of a container, it also returns the source image to store in the pod
+// annotations, which is empty if the image can't be cached, and whether the image is not rewritten because none of its
+// platforms matching the pod is put in cache
+func (a *ImageRewriter) handleContainer(ctx context.Context, pod *corev1.Pod, container *corev1.Container, rewriteImage bool, proxyPort int) (RewrittenImage, string, bool) {
 	if err := a.isImageRewritable(container); err != nil {
 		return RewrittenImage{
 			Original:            container.Image,
 			NotRewrittenBecause: err.Error(),
-		}
+		}, "", false
 	}
 
-	re := regexp.MustCompile(`localhost:[0-9]+/`)
-	image := re.ReplaceAllString(container.Image, "")
+	image := registry.ProxiedImage(container.Image)
+	image = a.ShortNames.Resolve(image)
 
 	sourceRef, err := name.ParseReference(image, name.Insecure)
 	if err != nil {
 		return RewrittenImage{
 			Original:            container.Image,
 			NotRewrittenBecause: err.Error(),
-		} // ignore rewriting invalid images
+		}, "", false // ignore rewriting invalid images
 	}
 
-	pod.Annotations[annotationKey] = image
+	// Images referenced by digest are cached and pulled by digest, whatever their tag
+	sourceImage := registry.TrimDigestedTag(image)
 
 	if !rewriteImage {
 		return RewrittenImage{
 			Original:            container.Image,
 			NotRewrittenBecause: "pod doesn't allow to rewrite its images",
-		}
+		}, sourceImage, false
+	}
+
+	// Caching an image without the variant the pod runs would break pulls, it is not cached at all instead
+	if err := a.checkPlatforms(ctx, sourceImage, pod); err != nil {
+		return RewrittenImage{
+			Original:            container.Image,
+			NotRewrittenBecause: err.Error(),
+		}, "", true
 	}
 
 	sanitizedRegistryName := strings.ReplaceAll(sourceRef.Context().RegistryStr(), ":", "-")
 	image = strings.ReplaceAll(image, sourceRef.Context().RegistryStr(), sanitizedRegistryName)
 
-	originalImage := container.Image
-	container.Image = fmt.Sprintf("localhost:%d/%s", a.ProxyPort, image)
-
 	return RewrittenImage{
-		Original:  originalImage,
-		Rewritten: container.Image,
+		Original:  container.Image,
+		Rewritten: fmt.Sprintf("%s:%d/%s", a.proxyHost(), proxyPort, image),
+	}, sourceImage, false
+}
+
+// checkPlatforms returns an error if an image doesn't provide a variant for the platform of a pod among the
+// architectures put in cache
+func (a *ImageRewriter) checkPlatforms(ctx context.Context, image string, pod *corev1.Pod) error {
+	os, architecture := a.podPlatform(pod)
+	architectures := a.Architectures
+	architectureNotCached := false
+	if architecture != "" {
+		architectureNotCached = len(architectures) > 0 && !slices.Contains(architectures, architecture)
+		architectures = []string{architecture}
+	}
+	if (os == "" && len(architectures) == 0) || a.ImagePlatforms == nil {
+		return nil
+	}
+
+	platforms, err := a.ImagePlatforms(ctx, image, pod)
+	if err != nil {
+		log.Log.WithName("webhook.pod").Info("could not look up platforms of image, rewriting it anyway", "image", image, "error", err.Error())
+		return nil
+	}
+	if platforms == nil {
+		return nil
 	}
+	if architectureNotCached {
+		return fmt.Errorf("architecture %s of the pod is not put in cache (%s)", architecture, strings.Join(a.Architectures, ", "))
+	}
+	if registry.ProvidesPlatform(platforms, os, architectures) {
+		return nil
+	}
+
+	if os == "" {
+		return fmt.Errorf("image only provides architectures %s, none of which is put in cache (%s)", strings.Join(registry.PlatformArchitectures(platforms), ", "), strings.Join(architectures, ", "))
+	}
+	wanted := []string{os}
+	if len(architectures) > 0 {
+		wanted = []string{}
+		for _, architecture := range architectures {
+			wanted = append(wanted, os+"/"+architecture)
+		}
+	}
+	return fmt.Errorf("image only provides platforms %s, none of which is %s", strings.Join(platforms, ", "), strings.Join(wanted, " or "))
 }
 
-func (a *ImageRewriter) isImageRewritable(container *corev1.Container) error {
-	if strings.Contains(container.Image, "@") {
-		return errImageContainsDigests
+// podPlatform returns the operating system and the architecture the images of a pod are pulled for, from its spec.os,
+// its node selector and the scheduling constraints of its runtime class, empty when they are not constrained.
+// Sandboxed runtimes such as gVisor and Kata Containers only run Linux images.
+func (a *ImageRewriter) podPlatform(pod *corev1.Pod) (string, string) {
+	os, architecture := "", ""
+
+	nodeSelectors := []map[string]string{pod.Spec.NodeSelector}
+	if pod.Spec.RuntimeClassName != nil && a.Client != nil {
+		var runtimeClass nodev1.RuntimeClass
+		if err := a.Client.Get(context.Background(), types.NamespacedName{Name: *pod.Spec.RuntimeClassName}, &runtimeClass); err != nil {
+			log.Log.WithName("webhook.pod").Info("could not get runtime class of pod", "runtimeClass", *pod.Spec.RuntimeClassName, "error", err.Error())
+		} else {
+			if runtimeClass.Scheduling != nil {
+				nodeSelectors = append(nodeSelectors, runtimeClass.Scheduling.NodeSelector)
+			}
+			if sandboxedRuntimeHandler.MatchString(runtimeClass.Handler) {
+				os = "linux"
+			}
+		}
 	}
 
+	for _, nodeSelector := range nodeSelectors {
+		if value := nodeSelector[corev1.LabelOSStable]; value != "" {
+			os = value
+		}
+		if value := nodeSelector[corev1.LabelArchStable]; value != "" {
+			architecture = value
+		}
+	}
+	if pod.Spec.OS != nil {
+		os = string(pod.Spec.OS.Name)
+	}
+
+	return os, architecture
+}
+
+func (a *ImageRewriter) isImageRewritable(container *corev1.Container) error {
 	for _, r := range a.IgnoreImages {
 		if r.MatchString(container.Image) {
 			return fmt.Errorf("image matches %s", r.String())
 		}
 	}
 
-	return nil
+	if len(a.IncludeImages) == 0 {
+		return nil
+	}
+	for _, r := range a.IncludeImages {
+		if r.MatchString(container.Image) {
+			return nil
+		}
+	}
+
+	return errImageNotIncluded
 }
 
+// Start patches every pod so that they go through the webhook. Since the webhook may not be reachable yet, e.g. while
+// its endpoints are not ready, it tries again until every pod has been patched instead of stopping the manager.
 func (p *PodInitializer) Start(ctx context.Context) error {
 	setupLog := ctrl.Log.WithName("setup.pods")
+
+	err := wait.PollImmediateUntilWithContext(ctx, podInitializerRetryInterval, func(ctx context.Context) (bool, error) {
+		if err := p.patchPods(ctx); err != nil {
+			setupLog.Error(err, "could not patch pods, retrying", "retryIn", podInitializerRetryInterval)
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil && ctx.Err() != nil {
+		return nil
+	}
+
+	return err
+}
+
+func (p *PodInitializer) patchPods(ctx context.Context) error {
+	setupLog := ctrl.Log.WithName("setup.pods")
 	pods := corev1.PodList{}
-	err := p.Client.List(context.TODO(), &pods)
+	err := p.Client.List(ctx, &pods)
 	if err != nil {
 		return err
 	}
 
 	for _, pod := range pods.Items {
 		setupLog.Info("patching " + pod.Namespace + "/" + pod.Name)
-		err := p.Client.Patch(context.Background(), &pod, client.RawPatch(types.JSONPatchType, []byte("[]")))
-		if err != nil {
+		err := p.Client.Patch(ctx, &pod, client.RawPatch(types.JSONPatchType, []byte("[]")))
+		if err != nil && !apierrors.IsNotFound(err) {
 			return err
 		}
 	}
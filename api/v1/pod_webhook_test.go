@@ -1,16 +1,25 @@
 package v1
 
 import (
+	"context"
 	_ "crypto/sha256"
 	"errors"
+	"fmt"
 	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/enix/kube-image-keeper/controllers"
 	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/enix/kube-image-keeper/internal/scheme"
 	. "github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
+	nodev1 "k8s.io/api/node/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
@@ -116,6 +125,279 @@ func TestRewriteImagesWithIgnore(t *testing.T) {
 	})
 }
 
+func TestRewriteImagesWithIdenticalImages(t *testing.T) {
+	pod := corev1.Pod{}
+	for i := 0; i < 25; i++ {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: fmt.Sprintf("app-%d", i), Image: "nginx:1.25"})
+	}
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: "sidecar", Image: "localhost:1313/envoyproxy/envoy:v1.28.0"})
+	pod.Spec.InitContainers = []corev1.Container{{Name: "init", Image: "nginx:1.25"}}
+
+	g := NewWithT(t)
+	ir := ImageRewriter{
+		ProxyPort: 4242,
+	}
+	rewrittenImages := ir.RewriteImages(&pod, true)
+	g.Expect(rewrittenImages).To(HaveLen(27))
+
+	for _, container := range pod.Spec.Containers[:25] {
+		g.Expect(container.Image).To(Equal("localhost:4242/nginx:1.25"))
+	}
+	g.Expect(pod.Spec.InitContainers[0].Image).To(Equal("localhost:4242/nginx:1.25"))
+	g.Expect(pod.Spec.Containers[25].Image).To(Equal("localhost:4242/envoyproxy/envoy:v1.28.0"))
+
+	// the original image of every container must be kept to be able to restore it
+	for i := 0; i < 25; i++ {
+		g.Expect(pod.Annotations[registry.ContainerAnnotationKey(fmt.Sprintf("app-%d", i), false)]).To(Equal("nginx:1.25"))
+	}
+	g.Expect(pod.Annotations[registry.ContainerAnnotationKey("init", true)]).To(Equal("nginx:1.25"))
+	g.Expect(pod.Annotations[registry.ContainerAnnotationKey("sidecar", false)]).To(Equal("envoyproxy/envoy:v1.28.0"))
+}
+
+func TestRewriteImagesWithShortNameAliases(t *testing.T) {
+	pod := corev1.Pod{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "a", Image: "ubi8:8.9"},
+				{Name: "b", Image: "localhost:1313/ubi8:8.9"},
+				{Name: "c", Image: "nginx:1.25"},
+			},
+		},
+	}
+
+	g := NewWithT(t)
+	ir := ImageRewriter{
+		ProxyPort:  4242,
+		ShortNames: registry.ShortNames{Aliases: registry.ShortNameAliases{"ubi8": "registry.access.redhat.com/ubi8"}},
+	}
+	ir.RewriteImages(&pod, true)
+
+	g.Expect(pod.Spec.Containers[0].Image).To(Equal("localhost:4242/registry.access.redhat.com/ubi8:8.9"))
+	g.Expect(pod.Spec.Containers[1].Image).To(Equal("localhost:4242/registry.access.redhat.com/ubi8:8.9"))
+	g.Expect(pod.Spec.Containers[2].Image).To(Equal("localhost:4242/nginx:1.25"))
+	g.Expect(pod.Annotations[registry.ContainerAnnotationKey("a", false)]).To(Equal("registry.access.redhat.com/ubi8:8.9"))
+}
+
+func TestRewriteImagesWithDigest(t *testing.T) {
+	digest := "sha256:5b161f051d017e55d358435f295f5e9a297e66158f136321d9b04520ec6c48a3"
+	pod := corev1.Pod{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "a", Image: "alpine@" + digest},
+				{Name: "b", Image: "quay.io/prometheus/prometheus:v2.48.0@" + digest},
+			},
+		},
+	}
+
+	g := NewWithT(t)
+	ir := ImageRewriter{ProxyPort: 4242}
+	ir.RewriteImages(&pod, true)
+
+	g.Expect(pod.Spec.Containers[0].Image).To(Equal("localhost:4242/alpine@" + digest))
+	g.Expect(pod.Spec.Containers[1].Image).To(Equal("localhost:4242/quay.io/prometheus/prometheus:v2.48.0@" + digest))
+	g.Expect(pod.Annotations[registry.ContainerAnnotationKey("a", false)]).To(Equal("alpine@" + digest))
+	// The tag is ignored by runtimes pulling images by digest
+	g.Expect(pod.Annotations[registry.ContainerAnnotationKey("b", false)]).To(Equal("quay.io/prometheus/prometheus@" + digest))
+}
+
+func TestRewriteImagesOfEphemeralContainers(t *testing.T) {
+	newPod := func() corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{controllers.AnnotationRewriteImagesName: "true"},
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{Name: "a", Image: "localhost:4242/nginx"},
+				},
+				EphemeralContainers: []corev1.EphemeralContainer{
+					{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger", Image: "busybox:1.36"}},
+				},
+			},
+		}
+	}
+
+	t.Run("Enabled", func(t *testing.T) {
+		g := NewWithT(t)
+		pod := newPod()
+		ir := ImageRewriter{ProxyPort: 4242, RewriteEphemeralContainers: true}
+		ir.RewriteImages(&pod, false)
+
+		g.Expect(pod.Spec.Containers[0].Image).To(Equal("localhost:4242/nginx"))
+		g.Expect(pod.Spec.EphemeralContainers[0].Image).To(Equal("localhost:4242/busybox:1.36"))
+		g.Expect(pod.Annotations[registry.EphemeralContainerAnnotationKey("debugger")]).To(Equal("busybox:1.36"))
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		g := NewWithT(t)
+		pod := newPod()
+		ir := ImageRewriter{ProxyPort: 4242}
+		ir.RewriteImages(&pod, false)
+
+		g.Expect(pod.Spec.EphemeralContainers[0].Image).To(Equal("busybox:1.36"))
+		g.Expect(pod.Annotations).ToNot(HaveKey(registry.EphemeralContainerAnnotationKey("debugger")))
+	})
+}
+
+func TestRewriteImagesWithSkippedContainers(t *testing.T) {
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{controllers.AnnotationSkipContainersName: "b, c"},
+		},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{
+				{Name: "c", Image: "busybox"},
+			},
+			Containers: []corev1.Container{
+				{Name: "a", Image: "nginx"},
+				{Name: "b", Image: "nginx"},
+			},
+		},
+	}
+
+	g := NewWithT(t)
+	ir := ImageRewriter{ProxyPort: 4242}
+	rewrittenImages := ir.RewriteImages(&pod, true)
+
+	g.Expect(pod.Spec.Containers[0].Image).To(Equal("localhost:4242/nginx"))
+	g.Expect(pod.Spec.Containers[1].Image).To(Equal("nginx"))
+	g.Expect(pod.Spec.InitContainers[0].Image).To(Equal("busybox"))
+	g.Expect(rewrittenImages[1].NotRewrittenBecause).To(Equal("container is listed by the kuik.enix.io/skip-containers annotation"))
+	g.Expect(pod.Annotations).To(HaveKey(registry.ContainerAnnotationKey("a", false)))
+	g.Expect(pod.Annotations).ToNot(HaveKey(registry.ContainerAnnotationKey("b", false)))
+	g.Expect(pod.Annotations).ToNot(HaveKey(registry.ContainerAnnotationKey("c", true)))
+}
+
+func TestRewriteImagesOfEnvVars(t *testing.T) {
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{controllers.AnnotationImageEnvVarsName: "HELPER_IMAGE, BUILD_IMAGE"},
+		},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{
+				{Name: "init", Image: "busybox", Env: []corev1.EnvVar{{Name: "BUILD_IMAGE", Value: "golang:1.21"}}},
+			},
+			Containers: []corev1.Container{
+				{Name: "runner", Image: "gitlab/gitlab-runner", Env: []corev1.EnvVar{
+					{Name: "HELPER_IMAGE", Value: "gitlab/gitlab-runner-helper:x86_64-latest"},
+					{Name: "OTHER_IMAGE", Value: "alpine"},
+					{Name: "BUILD_IMAGE", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{Key: "image"}}},
+				}},
+			},
+		},
+	}
+
+	g := NewWithT(t)
+	ir := ImageRewriter{ProxyPort: 4242}
+	rewrittenImages := ir.RewriteImages(&pod, true)
+
+	g.Expect(rewrittenImages).To(HaveLen(4))
+	g.Expect(pod.Spec.Containers[0].Env[0].Value).To(Equal("localhost:4242/gitlab/gitlab-runner-helper:x86_64-latest"))
+	g.Expect(pod.Spec.Containers[0].Env[1].Value).To(Equal("alpine"))
+	g.Expect(pod.Spec.InitContainers[0].Env[0].Value).To(Equal("localhost:4242/golang:1.21"))
+	g.Expect(pod.Annotations[registry.EnvAnnotationKey("runner", "HELPER_IMAGE", false)]).To(Equal("gitlab/gitlab-runner-helper:x86_64-latest"))
+	g.Expect(pod.Annotations[registry.EnvAnnotationKey("init", "BUILD_IMAGE", true)]).To(Equal("golang:1.21"))
+	g.Expect(pod.Annotations).ToNot(HaveKey(registry.EnvAnnotationKey("runner", "OTHER_IMAGE", false)))
+	g.Expect(pod.Annotations).ToNot(HaveKey(registry.EnvAnnotationKey("runner", "BUILD_IMAGE", false)))
+}
+
+func TestRewriteImagesOfFields(t *testing.T) {
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{controllers.AnnotationImagePathsName: `{.args[1]}{.command[0]}`},
+		},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{
+				{Name: "init", Image: "busybox", Args: []string{"--image", "golang:1.21"}},
+			},
+			Containers: []corev1.Container{
+				{Name: "runner", Image: "gitlab/gitlab-runner", Args: []string{"--helper-image", "gitlab/gitlab-runner-helper:x86_64-latest"}},
+			},
+		},
+	}
+
+	g := NewWithT(t)
+	ir := ImageRewriter{ProxyPort: 4242}
+	rewrittenImages := ir.RewriteImages(&pod, true)
+
+	g.Expect(rewrittenImages).To(HaveLen(4))
+	g.Expect(pod.Spec.Containers[0].Args).To(Equal([]string{"--helper-image", "localhost:4242/gitlab/gitlab-runner-helper:x86_64-latest"}))
+	g.Expect(pod.Spec.InitContainers[0].Args).To(Equal([]string{"--image", "localhost:4242/golang:1.21"}))
+	g.Expect(pod.Annotations[registry.FieldAnnotationKey("runner", "args.1", false)]).To(Equal("gitlab/gitlab-runner-helper:x86_64-latest"))
+	g.Expect(pod.Annotations[registry.FieldAnnotationKey("init", "args.1", true)]).To(Equal("golang:1.21"))
+	g.Expect(pod.Annotations).ToNot(HaveKey(registry.FieldAnnotationKey("runner", "args.0", false)))
+
+	// Containers are left untouched when the paths are invalid
+	pod.Annotations[controllers.AnnotationImagePathsName] = "{.args["
+	pod.Spec.Containers[0].Args[1] = "alpine"
+	g.Expect(ir.RewriteImages(&pod, true)).To(HaveLen(2))
+	g.Expect(pod.Spec.Containers[0].Args[1]).To(Equal("alpine"))
+}
+
+func TestRewriteImagesDroppingImagePullSecrets(t *testing.T) {
+	tests := []struct {
+		name                 string
+		dropImagePullSecrets bool
+		isNewPod             bool
+		skipContainers       string
+		dropped              bool
+	}{
+		{
+			name:                 "Every image rewritten",
+			dropImagePullSecrets: true,
+			isNewPod:             true,
+			dropped:              true,
+		},
+		{
+			name:     "Disabled",
+			isNewPod: true,
+		},
+		{
+			name:                 "Existing pod",
+			dropImagePullSecrets: true,
+		},
+		{
+			name:                 "Image not rewritten",
+			dropImagePullSecrets: true,
+			isNewPod:             true,
+			skipContainers:       "b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						controllers.AnnotationRewriteImagesName:  "true",
+						controllers.AnnotationSkipContainersName: tt.skipContainers,
+					},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{Name: "a", Image: "nginx"},
+						{Name: "b", Image: "registry.example.com/app"},
+					},
+					ImagePullSecrets: []corev1.LocalObjectReference{{Name: "docker-hub"}, {Name: "example"}},
+				},
+			}
+
+			ir := ImageRewriter{ProxyPort: 4242, DropImagePullSecrets: tt.dropImagePullSecrets}
+			ir.RewriteImages(&pod, tt.isNewPod)
+
+			if tt.dropped {
+				g.Expect(pod.Spec.ImagePullSecrets).To(BeEmpty())
+				g.Expect(controllers.DroppedImagePullSecretNames(&pod)).To(Equal([]string{"docker-hub", "example"}))
+			} else {
+				g.Expect(pod.Spec.ImagePullSecrets).To(HaveLen(2))
+				g.Expect(pod.Annotations).ToNot(HaveKey(controllers.AnnotationDroppedImagePullSecretsName))
+			}
+		})
+	}
+}
+
 func TestInjectDecoder(t *testing.T) {
 	g := NewWithT(t)
 	t.Run("Inject decoder", func(t *testing.T) {
@@ -138,10 +420,11 @@ func Test_isImageRewritable(t *testing.T) {
 	}
 
 	tests := []struct {
-		name    string
-		image   string
-		regexps []*regexp.Regexp
-		err     error
+		name     string
+		image    string
+		regexps  []*regexp.Regexp
+		includes []*regexp.Regexp
+		err      error
 	}{
 		{
 			name:    "No regex",
@@ -153,7 +436,7 @@ func Test_isImageRewritable(t *testing.T) {
 			name:    "No regex with digest",
 			image:   "alpine:latest@sha256:5b161f051d017e55d358435f295f5e9a297e66158f136321d9b04520ec6c48a3",
 			regexps: emptyRegexps,
-			err:     errImageContainsDigests,
+			err:     nil,
 		},
 		{
 			name:    "Match first regex",
@@ -173,13 +456,33 @@ func Test_isImageRewritable(t *testing.T) {
 			regexps: someRegexps,
 			err:     nil,
 		},
+		{
+			name:     "Match included regex",
+			image:    "quay.io/prometheus/prometheus",
+			includes: []*regexp.Regexp{regexp.MustCompile(`^ghcr\.io/`), regexp.MustCompile(`^quay\.io/`)},
+			err:      nil,
+		},
+		{
+			name:     "Match no included regex",
+			image:    "nginx",
+			includes: []*regexp.Regexp{regexp.MustCompile(`^quay\.io/`)},
+			err:      errImageNotIncluded,
+		},
+		{
+			name:     "Match included and ignored regex",
+			image:    "quay.io/prometheus/alpine",
+			regexps:  someRegexps,
+			includes: []*regexp.Regexp{regexp.MustCompile(`^quay\.io/`)},
+			err:      errors.New("image matches alpine"),
+		},
 	}
 
 	g := NewWithT(t)
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			imageRewriter := ImageRewriter{
-				IgnoreImages: tt.regexps,
+				IgnoreImages:  tt.regexps,
+				IncludeImages: tt.includes,
 			}
 
 			err := imageRewriter.isImageRewritable(&corev1.Container{
@@ -195,3 +498,210 @@ func Test_isImageRewritable(t *testing.T) {
 		})
 	}
 }
+
+func TestRewriteImagesWithProxyHost(t *testing.T) {
+	pod := corev1.Pod{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "a", Image: "nginx:1.25"},
+				{Name: "b", Image: "localhost:4242/alpine"},
+				{Name: "c", Image: "127.0.0.1:4242/busybox"},
+				{Name: "d", Image: "[::1]:4242/redis"},
+			},
+		},
+	}
+
+	g := NewWithT(t)
+	ir := ImageRewriter{
+		ProxyPort: 4242,
+		ProxyHost: "[::1]",
+	}
+	ir.RewriteImages(&pod, true)
+
+	g.Expect(pod.Spec.Containers).To(Equal([]corev1.Container{
+		{Name: "a", Image: "[::1]:4242/nginx:1.25"},
+		{Name: "b", Image: "[::1]:4242/alpine"},
+		{Name: "c", Image: "[::1]:4242/busybox"},
+		{Name: "d", Image: "[::1]:4242/redis"},
+	}))
+	g.Expect(pod.Annotations[registry.ContainerAnnotationKey("d", false)]).To(Equal("redis"))
+}
+
+func TestRewriteImagesWithArchitectures(t *testing.T) {
+	pod := corev1.Pod{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "a", Image: "nginx:1.25"},
+				{Name: "b", Image: "arm64-only:1.0"},
+				{Name: "c", Image: "single-arch:1.0"},
+				{Name: "d", Image: "unreachable:1.0"},
+			},
+		},
+	}
+
+	g := NewWithT(t)
+	ir := ImageRewriter{
+		ProxyPort:     4242,
+		Architectures: []string{"amd64"},
+		ImagePlatforms: func(ctx context.Context, image string, pod *corev1.Pod) ([]string, error) {
+			switch image {
+			case "nginx:1.25":
+				return []string{"linux/amd64", "linux/arm64"}, nil
+			case "arm64-only:1.0":
+				return []string{"linux/arm64"}, nil
+			case "unreachable:1.0":
+				return nil, errors.New("connection refused")
+			}
+			return nil, nil
+		},
+	}
+	rewrittenImages := ir.RewriteImages(&pod, true)
+
+	g.Expect(pod.Spec.Containers).To(Equal([]corev1.Container{
+		{Name: "a", Image: "localhost:4242/nginx:1.25"},
+		{Name: "b", Image: "arm64-only:1.0"},
+		{Name: "c", Image: "localhost:4242/single-arch:1.0"},
+		{Name: "d", Image: "localhost:4242/unreachable:1.0"},
+	}))
+	g.Expect(rewrittenImages[1].NotRewrittenBecause).To(Equal("image only provides architectures arm64, none of which is put in cache (amd64)"))
+	g.Expect(pod.Annotations).ToNot(HaveKey(registry.ContainerAnnotationKey("b", false)))
+	g.Expect(pod.Annotations[controllers.AnnotationArchitecturesNotCachedName]).To(Equal("arm64-only:1.0"))
+}
+
+func TestRewriteImagesWithPlatforms(t *testing.T) {
+	platforms := map[string][]string{
+		"nanoserver:ltsc2022": {"windows/amd64"},
+		"nginx:1.25":          {"linux/amd64", "linux/arm64"},
+		"amd64-only:1.0":      {"linux/amd64"},
+		"pause:3.9":           {"linux/amd64", "linux/arm64", "windows/amd64"},
+	}
+	gvisor := "gvisor"
+	ir := ImageRewriter{
+		Client: fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(&nodev1.RuntimeClass{
+			ObjectMeta: metav1.ObjectMeta{Name: "gvisor"},
+			Handler:    "runsc",
+			Scheduling: &nodev1.Scheduling{NodeSelector: map[string]string{corev1.LabelArchStable: "arm64"}},
+		}).Build(),
+		ProxyPort:     4242,
+		Architectures: []string{"amd64", "arm64"},
+		ImagePlatforms: func(ctx context.Context, image string, pod *corev1.Pod) ([]string, error) {
+			return platforms[image], nil
+		},
+	}
+
+	tests := []struct {
+		name                string
+		spec                corev1.PodSpec
+		rewritten           []bool
+		notRewrittenBecause string
+	}{
+		{
+			name:      "Windows pod",
+			spec:      corev1.PodSpec{OS: &corev1.PodOS{Name: corev1.Windows}},
+			rewritten: []bool{true, false, false, true},
+		},
+		{
+			name:      "Windows node selector",
+			spec:      corev1.PodSpec{NodeSelector: map[string]string{corev1.LabelOSStable: "windows"}},
+			rewritten: []bool{true, false, false, true},
+		},
+		{
+			name:      "Linux pod",
+			spec:      corev1.PodSpec{OS: &corev1.PodOS{Name: corev1.Linux}},
+			rewritten: []bool{false, true, true, true},
+		},
+		{
+			name:      "Unconstrained pod",
+			spec:      corev1.PodSpec{},
+			rewritten: []bool{true, true, true, true},
+		},
+		{
+			name:      "Sandboxed runtime on arm64 nodes",
+			spec:      corev1.PodSpec{RuntimeClassName: &gvisor},
+			rewritten: []bool{false, true, false, true},
+		},
+		{
+			name:      "Architecture not cached",
+			spec:      corev1.PodSpec{NodeSelector: map[string]string{corev1.LabelArchStable: "s390x"}},
+			rewritten: []bool{false, false, false, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			pod := corev1.Pod{Spec: tt.spec}
+			pod.Spec.Containers = []corev1.Container{
+				{Name: "a", Image: "nanoserver:ltsc2022"},
+				{Name: "b", Image: "nginx:1.25"},
+				{Name: "c", Image: "amd64-only:1.0"},
+				{Name: "d", Image: "pause:3.9"},
+			}
+			rewrittenImages := ir.RewriteImages(&pod, true)
+
+			notCached := []string{}
+			for i, rewrittenImage := range rewrittenImages {
+				g.Expect(rewrittenImage.Rewritten != "").To(Equal(tt.rewritten[i]), rewrittenImage.Original)
+				if !tt.rewritten[i] {
+					notCached = append(notCached, rewrittenImage.Original)
+				}
+			}
+			g.Expect(pod.Annotations[controllers.AnnotationArchitecturesNotCachedName]).To(Equal(strings.Join(notCached, ",")))
+		})
+	}
+
+	g := NewWithT(t)
+	pod := corev1.Pod{Spec: corev1.PodSpec{OS: &corev1.PodOS{Name: corev1.Windows}, Containers: []corev1.Container{{Name: "a", Image: "nginx:1.25"}}}}
+	g.Expect(ir.RewriteImages(&pod, true)[0].NotRewrittenBecause).To(Equal("image only provides platforms linux/amd64, linux/arm64, none of which is windows/amd64 or windows/arm64"))
+	pod = corev1.Pod{Spec: corev1.PodSpec{NodeSelector: map[string]string{corev1.LabelArchStable: "s390x"}, Containers: []corev1.Container{{Name: "a", Image: "nginx:1.25"}}}}
+	g.Expect(ir.RewriteImages(&pod, true)[0].NotRewrittenBecause).To(Equal("architecture s390x of the pod is not put in cache (amd64, arm64)"))
+}
+
+func TestRewriteImagesConcurrently(t *testing.T) {
+	g := NewWithT(t)
+
+	var lookups int32
+	architectures := registry.NewArchitectureCache(time.Minute)
+	ir := ImageRewriter{
+		ProxyPort:     4242,
+		Architectures: []string{"amd64"},
+		ImagePlatforms: func(ctx context.Context, image string, pod *corev1.Pod) ([]string, error) {
+			return architectures.Get(image, func() ([]string, error) {
+				atomic.AddInt32(&lookups, 1)
+				time.Sleep(10 * time.Millisecond)
+				if image == "arm64-only:1.0" {
+					return []string{"linux/arm64"}, nil
+				}
+				return []string{"linux/amd64", "linux/arm64"}, nil
+			})
+		},
+	}
+
+	pods := make([]corev1.Pod, 50)
+	wg := sync.WaitGroup{}
+	for i := range pods {
+		pods[i] = corev1.Pod{
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{Name: "a", Image: "nginx:1.25"},
+					{Name: "b", Image: "arm64-only:1.0"},
+				},
+			},
+		}
+		wg.Add(1)
+		go func(pod *corev1.Pod) {
+			defer wg.Done()
+			ir.RewriteImages(pod, true)
+		}(&pods[i])
+	}
+	wg.Wait()
+
+	// Every admission gets the same decision and each image is only looked up once
+	for _, pod := range pods {
+		g.Expect(pod.Spec.Containers).To(Equal(pods[0].Spec.Containers))
+		g.Expect(pod.Annotations).To(Equal(pods[0].Annotations))
+	}
+	g.Expect(pods[0].Spec.Containers[0].Image).To(Equal("localhost:4242/nginx:1.25"))
+	g.Expect(pods[0].Spec.Containers[1].Image).To(Equal("arm64-only:1.0"))
+	g.Expect(atomic.LoadInt32(&lookups)).To(Equal(int32(2)))
+}
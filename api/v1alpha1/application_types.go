@@ -0,0 +1,72 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ApplicationSpec defines the desired state of Application
+type ApplicationSpec struct {
+	// Selector selects the CachedImages of the application by labels
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// Images are put in cache and belong to the application, in addition to the CachedImages matching Selector
+	// +optional
+	Images []string `json:"images,omitempty"`
+	// PinnedUntil pins every image of the application until the given time
+	// +optional
+	PinnedUntil *metav1.Time `json:"pinnedUntil,omitempty"`
+	// PrefetchRequestedAt requests every image of the application that has not been pulled from upstream since the
+	// given time to be pulled again
+	// +optional
+	PrefetchRequestedAt *metav1.Time `json:"prefetchRequestedAt,omitempty"`
+	// ExpireRequestedAt requests every image of the application created before the given time and neither used by a
+	// pod, retained nor pinned to be removed from the cache
+	// +optional
+	ExpireRequestedAt *metav1.Time `json:"expireRequestedAt,omitempty"`
+}
+
+// ApplicationStatus defines the observed state of Application
+type ApplicationStatus struct {
+	// Images is the number of CachedImages of the application
+	Images int `json:"images,omitempty"`
+	// CachedImages is the number of images of the application present in cache
+	CachedImages int    `json:"cachedImages,omitempty"`
+	Phase        string `json:"phase,omitempty"`
+	//+listType=map
+	//+listMapKey=type
+	//+patchStrategy=merge
+	//+patchMergeKey=type
+	//+optional
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster,shortName=kuikapp,categories=kuik
+//+kubebuilder:printcolumn:name="Status",type="string",JSONPath=".status.phase"
+//+kubebuilder:printcolumn:name="Images",type="integer",JSONPath=".status.images"
+//+kubebuilder:printcolumn:name="Cached",type="integer",JSONPath=".status.cachedImages"
+//+kubebuilder:printcolumn:name="Pinned until",type="string",JSONPath=".spec.pinnedUntil",priority=1
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// Application groups CachedImages, e.g. the images of a release, to operate on them together
+type Application struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ApplicationSpec   `json:"spec,omitempty"`
+	Status ApplicationStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ApplicationList contains a list of Application
+type ApplicationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Application `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Application{}, &ApplicationList{})
+}
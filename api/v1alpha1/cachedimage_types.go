@@ -6,6 +6,52 @@ import (
 
 var RepositoryLabelName = "kuik.enix.io/repository"
 
+// SyncedTagsLabelName is the label of the CachedImages created for the tags of a Repository by its spec.tags, holding
+// the name of the Repository
+var SyncedTagsLabelName = "kuik.enix.io/synced-tags-of"
+
+// PrefetchedByLabelName is the label of the CachedImages created for an ImagePrefetch, holding the name of the
+// ImagePrefetch retaining them
+var PrefetchedByLabelName = "kuik.enix.io/prefetched-by"
+
+// RegistryLabelName is the label of CachedImages and Repositories telling the registry of their images, e.g. to list
+// them with kubectl get cachedimages -l kuik.enix.io/registry=quay.io
+var RegistryLabelName = "kuik.enix.io/registry"
+
+// ConditionReady is the type of the condition telling whether the images of a resource are available from the cache,
+// following the conventions GitOps tools rely on to assess the health of resources
+const ConditionReady = "Ready"
+
+// Types of the other conditions of CachedImages
+const (
+	// ConditionCaching tells whether the image is being put in cache or refreshed
+	ConditionCaching = "Caching"
+	// ConditionUpstreamUnreachable tells whether the registry of the image could not be reached the last time the image
+	// was put in cache or refreshed
+	ConditionUpstreamUnreachable = "UpstreamUnreachable"
+	// ConditionExpired tells whether the CachedImage has expired and is about to be deleted
+	ConditionExpired = "Expired"
+	// ConditionSignatureVerified tells whether the signature of the image has been verified the last time it was put in
+	// cache or refreshed, for images of registries requiring signatures
+	ConditionSignatureVerified = "SignatureVerified"
+)
+
+// Reasons of a false Ready condition when an image could not be put in cache, by cause of the failure
+const (
+	ReasonCacheFailed         = "CacheFailed"
+	ReasonUnauthorized        = "Unauthorized"
+	ReasonImageNotFound       = "ImageNotFound"
+	ReasonRateLimited         = "RateLimited"
+	ReasonUpstreamUnreachable = "UpstreamUnreachable"
+	ReasonStorageFull         = "StorageFull"
+	ReasonLimitExceeded       = "LimitExceeded"
+	ReasonPolicyViolation     = "PolicyViolation"
+	ReasonSignatureInvalid    = "SignatureInvalid"
+	// ReasonVulnerable is the reason of a false Ready condition when the proxy refuses to serve the image, its scan
+	// having found vulnerabilities above the severity threshold
+	ReasonVulnerable = "Vulnerable"
+)
+
 // CachedImageSpec defines the desired state of CachedImage
 type CachedImageSpec struct {
 	SourceImage string `json:"sourceImage"`
@@ -13,6 +59,21 @@ type CachedImageSpec struct {
 	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
 	// +optional
 	Retain bool `json:"retain,omitempty"`
+	// PinnedUntil prevents the CachedImage from expiring until the given time, after which it expires as usual
+	// +optional
+	PinnedUntil *metav1.Time `json:"pinnedUntil,omitempty"`
+	// Priority orders the CachedImages waiting to be put in cache, the ones with the highest priority being cached first
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+	// PrePullNodeSelector selects the nodes the image is pulled on once it is in cache, so that pods start right away
+	// on these nodes, when pre-pulling is enabled
+	// +optional
+	PrePullNodeSelector *metav1.LabelSelector `json:"prePullNodeSelector,omitempty"`
+	// ResyncInterval is how often the digest of the tag of the image is checked upstream, the image being pulled again
+	// if it has moved. It overrides the resync interval of the controllers, 0 disabling resyncs. Images referenced by
+	// digest or by an immutable tag are never resynced.
+	// +optional
+	ResyncInterval *metav1.Duration `json:"resyncInterval,omitempty"`
 }
 
 type PodReference struct {
@@ -24,21 +85,161 @@ type UsedBy struct {
 	// jsonpath function .length() is not implemented, so the count field is required to display pods count in additionalPrinterColumns
 	// see https://github.com/kubernetes-sigs/controller-tools/issues/447
 	Count int `json:"count,omitempty"`
+	// AlwaysPulled tells whether some of the pods pull the image with imagePullPolicy Always
+	AlwaysPulled bool `json:"alwaysPulled,omitempty"`
+}
+
+type Prefetch struct {
+	// History counts the pods that requested the image by hour of the week (UTC), e.g. "Mon 08:00"
+	History map[string]int `json:"history,omitempty"`
+	// LastRecordedAt is the creation date of the last pod taken into account in the history
+	LastRecordedAt *metav1.Time `json:"lastRecordedAt,omitempty"`
+	// LastRecordedPods are the UIDs of the pods created at LastRecordedAt taken into account in the history, creation
+	// dates having a precision of a second
+	LastRecordedPods []string `json:"lastRecordedPods,omitempty"`
+	// LastPrefetchAt is the last time the image has been refreshed ahead of a predicted request
+	LastPrefetchAt *metav1.Time `json:"lastPrefetchAt,omitempty"`
+	// NextPrefetchAt is the next time the image will be refreshed
+	NextPrefetchAt *metav1.Time `json:"nextPrefetchAt,omitempty"`
+	// Reason explains why the image will be refreshed at NextPrefetchAt
+	Reason string `json:"reason,omitempty"`
+}
+
+type Usage struct {
+	// PullCount is the number of times the image has been pulled through the proxy
+	PullCount int64 `json:"pullCount,omitempty"`
+	// LastPulledAt is the last time the image has been pulled through the proxy
+	LastPulledAt *metav1.Time `json:"lastPulledAt,omitempty"`
+	// RecentPulls keeps the dates of the most recent pulls, oldest first
+	RecentPulls []metav1.Time `json:"recentPulls,omitempty"`
+	// Nodes are the existing nodes that pulled the image through their proxy, as attributed by the proxy, up to the
+	// 100 that pulled it the most recently
+	Nodes []NodePull `json:"nodes,omitempty"`
+}
+
+// NodePull is the last pull of an image by a node
+type NodePull struct {
+	// Name is the name of the node
+	Name string `json:"name"`
+	// LastPulledAt is the last time the node pulled the image through its proxy
+	LastPulledAt metav1.Time `json:"lastPulledAt"`
+}
+
+type Nodes struct {
+	// Count is the number of nodes having the image in their local store
+	Count int `json:"count,omitempty"`
+	// MissingSince is the time since which the image is missing from the local store of every node
+	MissingSince *metav1.Time `json:"missingSince,omitempty"`
+}
+
+// CachingProgress is the progress of an image being put in cache
+type CachingProgress struct {
+	// CompletedLayers are the digests of the layers already put in cache, which are not pulled again
+	CompletedLayers []string `json:"completedLayers,omitempty"`
+}
+
+// ImageScan is the summary of the last vulnerability scan of the image in cache
+type ImageScan struct {
+	// Digest is the digest of the image that has been scanned
+	Digest string `json:"digest"`
+	// ScannedAt is the time the image has been scanned
+	ScannedAt metav1.Time `json:"scannedAt"`
+	// Critical, High, Medium, Low and Unknown count the vulnerabilities found by severity
+	// +optional
+	Critical int `json:"critical,omitempty"`
+	// +optional
+	High int `json:"high,omitempty"`
+	// +optional
+	Medium int `json:"medium,omitempty"`
+	// +optional
+	Low int `json:"low,omitempty"`
+	// +optional
+	Unknown int `json:"unknown,omitempty"`
+	// Blocked tells whether the proxy refuses to serve the image, vulnerabilities of the severity threshold or above
+	// having been found
+	// +optional
+	Blocked bool `json:"blocked,omitempty"`
 }
 
 // CachedImageStatus defines the observed state of CachedImage
 type CachedImageStatus struct {
 	IsCached bool   `json:"isCached,omitempty"`
 	UsedBy   UsedBy `json:"usedBy,omitempty"`
+	// +optional
+	Prefetch *Prefetch `json:"prefetch,omitempty"`
+	// +optional
+	Usage Usage `json:"usage,omitempty"`
+	// +optional
+	Nodes *Nodes `json:"nodes,omitempty"`
+	// LastUsedAt is the last time the image has been pulled through the proxy or has stopped being used by pods, unused
+	// images expire once the expiry delay has elapsed since then
+	// +optional
+	LastUsedAt *metav1.Time `json:"lastUsedAt,omitempty"`
+	// RefreshedAt is the last time the image has been pulled from its upstream registry, or the first time it has been
+	// found in cache if it was cached before this field was introduced
+	// +optional
+	RefreshedAt *metav1.Time `json:"refreshedAt,omitempty"`
+	// ResyncedAt is the last time the digest of the tag of the image has been checked upstream
+	// +optional
+	ResyncedAt *metav1.Time `json:"resyncedAt,omitempty"`
+	// UpstreamDigest is the digest of the manifest of the image upstream when it has last been pulled. It differs from
+	// Digest when only some architectures of the image are cached.
+	// +optional
+	UpstreamDigest string `json:"upstreamDigest,omitempty"`
+	// Progress is the progress of the image being put in cache, so that caching resumes from it after a restart of the
+	// controllers
+	// +optional
+	Progress *CachingProgress `json:"progress,omitempty"`
+	// QueuedAt is the time the image has been queued to be put in cache, so that images are cached in the same order
+	// after a restart of the controllers
+	// +optional
+	QueuedAt *metav1.Time `json:"queuedAt,omitempty"`
+	// Size is the size in bytes of the image in cache, including every cached platform
+	// +optional
+	Size int64 `json:"size,omitempty"`
+	// LayerCount is the number of distinct layers of the image in cache, including every cached platform
+	// +optional
+	LayerCount int `json:"layerCount,omitempty"`
+	// Digest is the digest of the manifest of the image in cache, the one of its index for multi-arch images
+	// +optional
+	Digest string `json:"digest,omitempty"`
+	// Scan is the summary of the last vulnerability scan of the image, when scanning is enabled
+	// +optional
+	Scan *ImageScan `json:"scan,omitempty"`
+	// Summary is a one-line summary of the status for human operators, e.g. "cached at 2024-01-02T15:04:05Z, 812MiB,
+	// used by 14 pods"
+	// +optional
+	Summary string `json:"summary,omitempty"`
+	// ObservedGeneration is the generation of the CachedImage the Ready condition has last been set for
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	//+listType=map
+	//+listMapKey=type
+	//+patchStrategy=merge
+	//+patchMergeKey=type
+	//+optional
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
 }
 
+//+genclient
+//+genclient:nonNamespaced
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
-//+kubebuilder:resource:scope=Cluster,shortName=ci
+//+kubebuilder:resource:scope=Cluster,shortName=ci,categories=kuik
+//+kubebuilder:printcolumn:name="Image",type="string",JSONPath=".spec.sourceImage"
 //+kubebuilder:printcolumn:name="Cached",type="boolean",JSONPath=".status.isCached"
 //+kubebuilder:printcolumn:name="Retain",type="boolean",JSONPath=".spec.retain"
+//+kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status",priority=1
+//+kubebuilder:printcolumn:name="Pinned until",type="string",JSONPath=".spec.pinnedUntil",priority=1
 //+kubebuilder:printcolumn:name="Expires at",type="string",JSONPath=".spec.expiresAt"
 //+kubebuilder:printcolumn:name="Pods count",type="integer",JSONPath=".status.usedBy.count"
+//+kubebuilder:printcolumn:name="Size",type="integer",JSONPath=".status.size"
+//+kubebuilder:printcolumn:name="Layers",type="integer",JSONPath=".status.layerCount"
+//+kubebuilder:printcolumn:name="Digest",type="string",JSONPath=".status.digest",priority=1
+//+kubebuilder:printcolumn:name="Critical",type="integer",JSONPath=".status.scan.critical",priority=1
+//+kubebuilder:printcolumn:name="Cached at",type="date",JSONPath=".status.refreshedAt",priority=1
+//+kubebuilder:printcolumn:name="Last used",type="date",JSONPath=".status.lastUsedAt",priority=1
+//+kubebuilder:printcolumn:name="Summary",type="string",JSONPath=".status.summary",priority=1
 //+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // CachedImage is the Schema for the cachedimages API
@@ -2,11 +2,14 @@ package v1alpha1
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/distribution/reference"
 	"github.com/enix/kube-image-keeper/internal/registry"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -20,6 +23,78 @@ func (r *CachedImage) Repository() (reference.Named, error) {
 	return named, nil
 }
 
+// RegistryLabel returns the value of the RegistryLabelName label of resources whose images come from the registry of
+// named
+func RegistryLabel(named reference.Named) string {
+	return registry.RepositoryLabel(reference.Domain(named))
+}
+
+// IsPinned tells whether the CachedImage is pinned at the given time
+func (r *CachedImage) IsPinned(now time.Time) bool {
+	return r.Spec.PinnedUntil != nil && now.Before(r.Spec.PinnedUntil.Time)
+}
+
+// RefreshRequestedAtAnnotationName requests a CachedImage to be pulled again from upstream if it has not been since
+// the given RFC 3339 time
+var RefreshRequestedAtAnnotationName = "kuik.enix.io/refresh-requested-at"
+
+// IsRefreshRequested tells whether the CachedImage has been requested to be pulled again from upstream since it has
+// last been
+func (r *CachedImage) IsRefreshRequested() bool {
+	requestedAt, err := time.Parse(time.RFC3339, r.Annotations[RefreshRequestedAtAnnotationName])
+	if err != nil {
+		return false
+	}
+
+	return r.Status.RefreshedAt == nil || r.Status.RefreshedAt.Time.Before(requestedAt)
+}
+
+// RecacheAnnotationName requests a CachedImage, when set to "true", to be deleted from the cache and pulled again from
+// upstream from scratch, e.g. when a mutable tag has moved upstream. The annotation is removed once the image has been
+// deleted from the cache.
+var RecacheAnnotationName = "kuik.enix.io/recache"
+
+// IsRecacheRequested tells whether the CachedImage has been requested to be deleted from the cache and pulled again
+func (r *CachedImage) IsRecacheRequested() bool {
+	return r.Annotations[RecacheAnnotationName] == "true"
+}
+
+// PullTimeoutAnnotationName overrides how long pulling the image of a CachedImage from upstream may take, e.g. "6h",
+// "0" removing the limit. The longest one among the pods using the image is copied.
+var PullTimeoutAnnotationName = "kuik.enix.io/pull-timeout"
+
+// ExpectedSizeAnnotationName hints the size of the image of a CachedImage before it is pulled, e.g. "40Gi", so that its
+// pull timeout leaves enough time to pull it. The largest one among the pods using the image is copied.
+var ExpectedSizeAnnotationName = "kuik.enix.io/expected-size"
+
+// PullTimeout returns the pull timeout set by the PullTimeoutAnnotationName annotation, and false if it is not set
+func (r *CachedImage) PullTimeout() (time.Duration, bool, error) {
+	value, ok := r.Annotations[PullTimeoutAnnotationName]
+	if !ok {
+		return 0, false, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, false, fmt.Errorf("invalid %s annotation %q", PullTimeoutAnnotationName, value)
+	}
+	return timeout, true, nil
+}
+
+// ExpectedSize returns the size in bytes hinted by the ExpectedSizeAnnotationName annotation, 0 if it is not set
+func (r *CachedImage) ExpectedSize() (int64, error) {
+	value, ok := r.Annotations[ExpectedSizeAnnotationName]
+	if !ok {
+		return 0, nil
+	}
+
+	size, err := resource.ParseQuantity(value)
+	if err != nil || size.Sign() < 0 {
+		return 0, fmt.Errorf("invalid %s annotation %q", ExpectedSizeAnnotationName, value)
+	}
+	return size.Value(), nil
+}
+
 func (r *CachedImage) GetPullSecrets(apiReader client.Reader) ([]corev1.Secret, error) {
 	named, err := r.Repository()
 	if err != nil {
@@ -39,3 +114,37 @@ func (r *CachedImage) GetPullSecrets(apiReader client.Reader) ([]corev1.Secret,
 
 	return pullSecrets, nil
 }
+
+// UpstreamPausedBy returns the ClusterPolicy or the Repository pausing pulls of the image from its upstream registry,
+// or the air-gapped mode, or an empty string if they are not paused
+func (r *CachedImage) UpstreamPausedBy(ctx context.Context, apiReader client.Reader) (string, error) {
+	if registry.Airgapped {
+		return "the air-gapped mode", nil
+	}
+
+	var clusterPolicies ClusterPolicyList
+	if err := apiReader.List(ctx, &clusterPolicies); err != nil {
+		return "", err
+	}
+	for _, clusterPolicy := range clusterPolicies.Items {
+		if clusterPolicy.Spec.Paused {
+			return "ClusterPolicy " + clusterPolicy.Name, nil
+		}
+	}
+
+	named, err := r.Repository()
+	if err != nil {
+		return "", err
+	}
+
+	repository := Repository{}
+	err = apiReader.Get(ctx, types.NamespacedName{Name: registry.SanitizeName(named.Name())}, &repository)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", err
+	}
+	if repository.Spec.Paused {
+		return "Repository " + repository.Name, nil
+	}
+
+	return "", nil
+}
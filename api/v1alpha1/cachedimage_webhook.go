@@ -36,6 +36,7 @@ func (r *CachedImage) Default(ctx context.Context, obj runtime.Object) error {
 	}
 
 	cachedImage.Labels[RepositoryLabelName] = registry.RepositoryLabel(named.Name())
+	cachedImage.Labels[RegistryLabelName] = RegistryLabel(named)
 
 	return nil
 }
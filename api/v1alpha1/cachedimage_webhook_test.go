@@ -16,17 +16,26 @@ func TestDefault(t *testing.T) {
 		name                    string
 		sourceImage             string
 		expectedRepositoryLabel string
+		expectedRegistryLabel   string
 		wantErr                 error
 	}{
 		{
 			name:                    "Simple image name",
 			sourceImage:             "alpine",
 			expectedRepositoryLabel: "docker.io-library-alpine",
+			expectedRegistryLabel:   "docker.io",
 		},
 		{
 			name:                    "Advanced image name",
 			sourceImage:             "quay.io/jetstack/cert-manager-controller:v1.13.2",
 			expectedRepositoryLabel: "quay.io-jetstack-cert-manager-controller",
+			expectedRegistryLabel:   "quay.io",
+		},
+		{
+			name:                    "Registry with a port",
+			sourceImage:             "localhost:5000/app:v1",
+			expectedRepositoryLabel: "localhost-5000-app",
+			expectedRegistryLabel:   "localhost-5000",
 		},
 		{
 			name:        "Invalid image name",
@@ -45,6 +54,7 @@ func TestDefault(t *testing.T) {
 			if tt.wantErr == nil {
 				g.Expect(cachedImage.Labels).ToNot(BeNil())
 				g.Expect(cachedImage.Labels[RepositoryLabelName]).To(Equal(tt.expectedRepositoryLabel))
+				g.Expect(cachedImage.Labels[RegistryLabelName]).To(Equal(tt.expectedRegistryLabel))
 			} else {
 				g.Expect(err).To(Equal(tt.wantErr))
 			}
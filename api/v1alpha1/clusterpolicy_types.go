@@ -0,0 +1,39 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterPolicySpec defines the desired state of ClusterPolicy
+type ClusterPolicySpec struct {
+	// Paused stops every pull from upstream registries, e.g. during an upstream compromise, images already in cache
+	// being still served
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster,shortName=kuikpolicy,categories=kuik
+//+kubebuilder:printcolumn:name="Paused",type="boolean",JSONPath=".spec.paused"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// ClusterPolicy configures kuik for the whole cluster, upstream registries being paused if any ClusterPolicy is
+type ClusterPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ClusterPolicySpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ClusterPolicyList contains a list of ClusterPolicy
+type ClusterPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterPolicy{}, &ClusterPolicyList{})
+}
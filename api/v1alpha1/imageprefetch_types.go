@@ -0,0 +1,121 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ImagePrefetchSpec defines the desired state of ImagePrefetch
+type ImagePrefetchSpec struct {
+	// Images are the references of the images to put in cache
+	// +optional
+	Images []string `json:"images,omitempty"`
+	// Repositories are image references whose tag is a glob pattern, e.g. ghcr.io/enix/shop:v2.*, every tag of the
+	// repository matching the pattern in its upstream registry is put in cache
+	// +optional
+	Repositories []string `json:"repositories,omitempty"`
+	// Schedule is a cron expression in UTC (e.g. "0 6 * * 1-5" or "@daily") telling when images are put in cache again,
+	// mutable tags being pulled again from upstream and repositories listed again. Images are only put in cache when
+	// the ImagePrefetch is created or updated if empty.
+	// +optional
+	Schedule string `json:"schedule,omitempty"`
+	// PrePullNodeSelector selects the nodes the images are pulled on once they are in cache, when pre-pulling is enabled
+	// +optional
+	PrePullNodeSelector *metav1.LabelSelector `json:"prePullNodeSelector,omitempty"`
+	// Planned schedules the caching of the images missing from the cache in batches, so that they don't exhaust the
+	// upstream budget nor the rate limits of upstream registries. The plan is reported in status at each run, before
+	// images are put in cache. Every image is put in cache at once if false.
+	// +optional
+	Planned bool `json:"planned,omitempty"`
+}
+
+// CachingPlan estimates what caching the images missing from the cache pulls from upstream registries, and schedules it
+// in batches fitting in what is left of the upstream budget and of the rate limits of upstream registries
+type CachingPlan struct {
+	// PlannedAt is when the plan has been made
+	PlannedAt metav1.Time `json:"plannedAt"`
+	// EstimatedManifests is the estimated number of manifests pulled from upstream registries, an image index being
+	// pulled along with the manifest of each cached platform
+	EstimatedManifests int64 `json:"estimatedManifests"`
+	// EstimatedBytes is the estimated amount of bytes pulled from upstream registries, based on the size of the images
+	// of the same repositories in cache
+	EstimatedBytes int64 `json:"estimatedBytes"`
+	// Quotas are what was left of the upstream budget and of the rate limits of upstream registries when the plan was
+	// made
+	// +optional
+	Quotas []CachingQuota `json:"quotas,omitempty"`
+	// Batches are the images put in cache together, in order
+	// +optional
+	Batches []CachingBatch `json:"batches,omitempty"`
+}
+
+// CachingQuota is what is left of an amount of manifests or bytes allowed per time window
+type CachingQuota struct {
+	// Name tells what is limited, e.g. "budget/manifests" or "docker.io"
+	Name      string      `json:"name"`
+	Limit     int64       `json:"limit"`
+	Remaining int64       `json:"remaining"`
+	ResetAt   metav1.Time `json:"resetAt"`
+}
+
+// CachingBatch is a set of images put in cache together once StartAt is reached
+type CachingBatch struct {
+	StartAt            metav1.Time `json:"startAt"`
+	Images             []string    `json:"images"`
+	EstimatedManifests int64       `json:"estimatedManifests"`
+	EstimatedBytes     int64       `json:"estimatedBytes"`
+}
+
+// ImagePrefetchStatus defines the observed state of ImagePrefetch
+type ImagePrefetchStatus struct {
+	// Images are the images listed or found in repositories at the last run
+	Images []string `json:"images,omitempty"`
+	// CachedImages is the number of images present in cache
+	CachedImages int          `json:"cachedImages,omitempty"`
+	LastRunAt    *metav1.Time `json:"lastRunAt,omitempty"`
+	NextRunAt    *metav1.Time `json:"nextRunAt,omitempty"`
+	// Plan is the schedule of the caching of the images missing from the cache at the last run, when planned
+	// +optional
+	Plan *CachingPlan `json:"plan,omitempty"`
+	// ObservedGeneration is the generation of the spec images have last been put in cache for
+	ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+	Phase              string `json:"phase,omitempty"`
+	//+listType=map
+	//+listMapKey=type
+	//+patchStrategy=merge
+	//+patchMergeKey=type
+	//+optional
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster,shortName=ipf,categories=kuik
+//+kubebuilder:printcolumn:name="Status",type="string",JSONPath=".status.phase"
+//+kubebuilder:printcolumn:name="Schedule",type="string",JSONPath=".spec.schedule"
+//+kubebuilder:printcolumn:name="Cached",type="integer",JSONPath=".status.cachedImages"
+//+kubebuilder:printcolumn:name="Last run",type="date",JSONPath=".status.lastRunAt"
+//+kubebuilder:printcolumn:name="Next run",type="string",JSONPath=".status.nextRunAt",priority=1
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// ImagePrefetch lists images and repositories to put in cache ahead of time, on a schedule, so that deployments and
+// new nodes don't wait for upstream registries
+type ImagePrefetch struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ImagePrefetchSpec   `json:"spec,omitempty"`
+	Status ImagePrefetchStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ImagePrefetchList contains a list of ImagePrefetch
+type ImagePrefetchList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ImagePrefetch `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ImagePrefetch{}, &ImagePrefetchList{})
+}
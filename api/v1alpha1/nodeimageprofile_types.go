@@ -0,0 +1,40 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NodeImageProfileSpec defines the desired state of NodeImageProfile
+type NodeImageProfileSpec struct {
+	// NodeSelector selects the nodes to pull the images on when they join the cluster, an empty selector selects every
+	// node
+	NodeSelector metav1.LabelSelector `json:"nodeSelector"`
+	// Images are put in cache and pulled on the selected nodes when they join the cluster, before the most pulled images
+	Images []string `json:"images"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster,shortName=nip,categories=kuik
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// NodeImageProfile describes images to pull on nodes of a given kind when they join the cluster, e.g. CUDA base images
+// on GPU nodes, which requires node warm-up to be enabled
+type NodeImageProfile struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec NodeImageProfileSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// NodeImageProfileList contains a list of NodeImageProfile
+type NodeImageProfileList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NodeImageProfile `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NodeImageProfile{}, &NodeImageProfileList{})
+}
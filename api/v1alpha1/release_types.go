@@ -0,0 +1,73 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ReleaseSource describes where to find the images of a release. Exactly one of its fields must be set.
+type ReleaseSource struct {
+	// Index is the reference of an OCI image index listing the images of the release in the
+	// org.opencontainers.image.ref.name annotation of its manifests
+	// +optional
+	Index string `json:"index,omitempty"`
+	// URL is the HTTP(S) URL of Kubernetes manifests or of a kustomization referencing the images of the release, e.g.
+	// the raw URL of a file in a Git repository
+	// +optional
+	URL string `json:"url,omitempty"`
+}
+
+// ReleaseSpec defines the desired state of Release
+type ReleaseSpec struct {
+	Source ReleaseSource `json:"source"`
+	// Interval between two syncs of the source, 5 minutes by default
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+// ReleaseStatus defines the observed state of Release
+type ReleaseStatus struct {
+	// Images are the images of the release found in its source at the last successful sync
+	Images []string `json:"images,omitempty"`
+	// CachedImages is the number of images of the release present in cache
+	CachedImages int          `json:"cachedImages,omitempty"`
+	LastSyncedAt *metav1.Time `json:"lastSyncedAt,omitempty"`
+	// ObservedGeneration is the generation of the spec the images have last been synced for
+	ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+	Phase              string `json:"phase,omitempty"`
+	//+listType=map
+	//+listMapKey=type
+	//+patchStrategy=merge
+	//+patchMergeKey=type
+	//+optional
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster,shortName=rel,categories=kuik
+//+kubebuilder:printcolumn:name="Status",type="string",JSONPath=".status.phase"
+//+kubebuilder:printcolumn:name="Cached",type="integer",JSONPath=".status.cachedImages"
+//+kubebuilder:printcolumn:name="Last synced",type="date",JSONPath=".status.lastSyncedAt"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// Release describes the images of an upcoming release, which are put in cache ahead of its rollout
+type Release struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ReleaseSpec   `json:"spec,omitempty"`
+	Status ReleaseStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ReleaseList contains a list of Release
+type ReleaseList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Release `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Release{}, &ReleaseList{})
+}
@@ -9,12 +9,75 @@ type RepositorySpec struct {
 	Name                 string   `json:"name"`
 	PullSecretNames      []string `json:"pullSecretNames,omitempty"`
 	PullSecretsNamespace string   `json:"pullSecretsNamespace,omitempty"`
+	// Paused stops pulls of the images of the repository from their upstream registry, images already in cache being
+	// still served
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+	// UpstreamAddress is the address, an IP or a host name optionally followed by a port, the upstream registry of the
+	// repository is reached at instead of the one its name resolves to, e.g. in split-horizon DNS environments. TLS
+	// certificates are still verified against the name of the registry.
+	// +optional
+	UpstreamAddress string `json:"upstreamAddress,omitempty"`
+	// UpstreamCIDRs are the CIDRs the upstream registry of the repository, and the storage its blobs are redirected to,
+	// are reached at. They are allowed by the NetworkPolicies of the components of kuik when the controllers manage them.
+	// +optional
+	UpstreamCIDRs []string `json:"upstreamCIDRs,omitempty"`
+	// Tags puts the tags of the repository in cache as soon as they appear upstream, with a retained CachedImage for
+	// each of them. CachedImages of tags that disappear upstream, or no longer match, are released and expire as usual.
+	// +optional
+	Tags *RepositoryTags `json:"tags,omitempty"`
+}
+
+// RepositoryTags selects the tags of a repository put in cache
+type RepositoryTags struct {
+	// Pattern is a regular expression the tags put in cache must match, e.g. "^v[0-9]+" or "-alpine$". Either pattern
+	// or latest must be set.
+	// +optional
+	Pattern string `json:"pattern,omitempty"`
+	// Latest only puts in cache the given number of latest tags matching the pattern, tags being ordered like versions
+	// (e.g. v1.10 comes after v1.9). Either pattern or latest must be set.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Latest int `json:"latest,omitempty"`
+	// Interval is how often the tags of the repository are listed upstream, every hour if not set
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+// ImageFailure is a CachedImage that failed to be cached
+type ImageFailure struct {
+	CachedImage string `json:"cachedImage"`
+	SourceImage string `json:"sourceImage"`
+	// Reason is the reason of the false Ready condition of the CachedImage
+	Reason string `json:"reason"`
+	// Since is the time the CachedImage has been failing since
+	Since metav1.Time `json:"since"`
 }
 
 // RepositoryStatus defines the observed state of Repository
 type RepositoryStatus struct {
 	Images int    `json:"images,omitempty"`
 	Phase  string `json:"phase,omitempty"`
+	// CachedImages, PendingImages and FailedImages count the CachedImages of the repository that are cached, that are
+	// not cached yet and that failed to be cached respectively
+	CachedImages  int `json:"cachedImages,omitempty"`
+	PendingImages int `json:"pendingImages,omitempty"`
+	FailedImages  int `json:"failedImages,omitempty"`
+	// TotalSize is the size in bytes of the images of the repository in cache, blobs shared between images being
+	// counted for each of them
+	TotalSize int64 `json:"totalSize,omitempty"`
+	// OldestFailure is the CachedImage of the repository failing for the longest time
+	// +optional
+	OldestFailure *ImageFailure `json:"oldestFailure,omitempty"`
+	// SyncedTags is the number of tags of the repository put in cache by spec.tags when they have last been listed
+	// +optional
+	SyncedTags int `json:"syncedTags,omitempty"`
+	// TagsSyncedAt is the last time the tags of the repository have been listed upstream
+	// +optional
+	TagsSyncedAt *metav1.Time `json:"tagsSyncedAt,omitempty"`
+	// ObservedGeneration is the generation of the Repository the conditions have last been set for
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
 	//+listType=map
 	//+listMapKey=type
 	//+patchStrategy=merge
@@ -23,11 +86,19 @@ type RepositoryStatus struct {
 	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
 }
 
+//+genclient
+//+genclient:nonNamespaced
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
-//+kubebuilder:resource:scope=Cluster,shortName=repo
+//+kubebuilder:resource:scope=Cluster,shortName=repo,categories=kuik
 //+kubebuilder:printcolumn:name="Status",type="string",JSONPath=".status.phase"
 //+kubebuilder:printcolumn:name="Images",type="string",JSONPath=".status.images"
+//+kubebuilder:printcolumn:name="Cached",type="integer",JSONPath=".status.cachedImages"
+//+kubebuilder:printcolumn:name="Failed",type="integer",JSONPath=".status.failedImages"
+//+kubebuilder:printcolumn:name="Paused",type="boolean",JSONPath=".spec.paused",priority=1
+//+kubebuilder:printcolumn:name="Synced tags",type="integer",JSONPath=".status.syncedTags",priority=1
+//+kubebuilder:printcolumn:name="Upstream address",type="string",JSONPath=".spec.upstreamAddress",priority=1
+//+kubebuilder:printcolumn:name="Images ready",type="string",JSONPath=".status.conditions[?(@.type==\"ImagesReady\")].status",priority=1
 //+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // Repository is the Schema for the repositories API
@@ -0,0 +1,6 @@
+//go:build boringcrypto
+
+package main
+
+// Restrict TLS to FIPS-approved settings when built with GOEXPERIMENT=boringcrypto
+import _ "crypto/tls/fipsonly"
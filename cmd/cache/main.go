@@ -1,27 +1,46 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"regexp"
+	"strings"
 	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	_ "go.uber.org/automaxprocs"
 	"go.uber.org/zap/zapcore"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	kuikenixiov1 "github.com/enix/kube-image-keeper/api/v1"
 	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
 	"github.com/enix/kube-image-keeper/controllers"
 	"github.com/enix/kube-image-keeper/internal"
+	"github.com/enix/kube-image-keeper/internal/admin"
+	"github.com/enix/kube-image-keeper/internal/bundle"
+	"github.com/enix/kube-image-keeper/internal/events"
+	"github.com/enix/kube-image-keeper/internal/featuregate"
+	"github.com/enix/kube-image-keeper/internal/proxy"
+	"github.com/enix/kube-image-keeper/internal/pulltoken"
 	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/enix/kube-image-keeper/internal/scan"
 	"github.com/enix/kube-image-keeper/internal/scheme"
+	"github.com/enix/kube-image-keeper/internal/snapshot"
+	"github.com/enix/kube-image-keeper/internal/tlsconfig"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -30,29 +49,186 @@ var (
 )
 
 func main() {
+	// Subcommands are run instead of the controllers, e.g. from a Job exporting or importing cached images
+	if len(os.Args) > 1 {
+		if run, ok := bundle.Commands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %s\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var adminAddr string
+	var singlePortAddr string
+	var singlePortHtpasswdPath string
 	var expiryDelay uint
+	var nodeImagesExpiryDelay time.Duration
+	var scaledWorkloadsExpiryProtection bool
+	var maxCacheSize string
+	var cacheQuotaCheckInterval time.Duration
 	var proxyPort int
+	var proxyPortsConfigMap string
+	var proxyHost string
 	var ignoreImages internal.RegexpArrayFlags
+	var includeImages internal.RegexpArrayFlags
+	var rewriteEphemeralContainers bool
+	var dropImagePullSecrets bool
+	var checkImagePlatforms bool
 	var architectures internal.ArrayFlags
 	var maxConcurrentCachedImageReconciles int
 	var insecureRegistries internal.ArrayFlags
 	var rootCAPaths internal.ArrayFlags
+	var upstreamDNSServers internal.ArrayFlags
+	var upstreamAddressOverrides bool
+	var enablePrefetch bool
+	var prefetchLeadTime time.Duration
+	var prefetchMinRequests int
+	var gcCronJobName string
+	var gcAfterDeletions int
+	var tlsMinVersion string
+	var tlsCipherSuites string
+	var registryTLSSecret string
+	var registryTLSDir string
+	var registryTLSValidity time.Duration
+	var registryMigrationConfigMap string
+	var registryMigrationInterval time.Duration
+	var upstreamManifestsBudget string
+	var mutableTagsExpiryDelay time.Duration
+	var immutableTagsExpiryDelay time.Duration
+	var mutableTagsRefreshInterval time.Duration
+	var mutableTagsResyncInterval time.Duration
+	var pullTimeout time.Duration
+	var immutableTags string
+	var upstreamBytesBudget string
+	var cacheSandboxImages bool
+	var warmupNodes bool
+	var warmupNodeSelector string
+	var warmupTopImages int
+	var warmupMaxNodeAge time.Duration
+	var prePullImages bool
+	var prePullInterval time.Duration
+	var sandboxImages string
+	var pullTokenKeyPath string
+	var pullTokenMaxTTL time.Duration
+	var pullTokenIssuersPath string
+	var snapshotKeyPath string
+	var registryStorage string
+	var registryHealthCheckInterval time.Duration
+	var registryStorageCapacity string
+	var registryStorageHighWatermark float64
+	var registryMaxLatency time.Duration
+	var maxManifestSize string
+	var allowedBaseRegistries internal.ArrayFlags
+	var signaturePolicyPath string
+	var trivyPath string
+	var scannerWebhookURL string
+	var blockSeverity string
+	var maxConcurrentScans int
+	var maxSyncedTags int
+	var shortNameAliasesPaths internal.ArrayFlags
+	var proxyDaemonSet string
+	var proxyRolloutProgressDeadline time.Duration
+	var networkPoliciesPrefix string
+	var networkPoliciesSelector string
+	var networkPoliciesUpstreamCIDRs internal.ArrayFlags
+	var networkPoliciesProxyHostNetwork bool
+	var upgradeUnschedulableNodesRatio float64
+	var upgradeCooldown time.Duration
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	flag.StringVar(&adminAddr, "admin-bind-address", ":8083", "The address the admin API endpoint binds to. Set it to \"0\" to disable the admin API.")
+	flag.StringVar(&singlePortAddr, "single-port-bind-address", "0", "The address serving the admin API, the metrics and the registry API behind a single port, e.g. for kubectl port-forward, which must be a loopback address without -single-port-htpasswd. Set it to \"0\" to disable it.")
+	flag.StringVar(&singlePortHtpasswdPath, "single-port-htpasswd", "", "Path of an htpasswd file with bcrypt passwords of the users allowed to use the single port, which requires no authentication if empty and is then only served on a loopback address.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
 	flag.UintVar(&expiryDelay, "expiry-delay", 30, "The delay in days before deleting an unused CachedImage.")
+	flag.DurationVar(&mutableTagsExpiryDelay, "mutable-tags-expiry-delay", 0, "The delay before deleting an unused CachedImage whose tag is mutable, e.g. latest (0 to use -expiry-delay).")
+	flag.DurationVar(&immutableTagsExpiryDelay, "immutable-tags-expiry-delay", 0, "The delay before deleting an unused CachedImage whose tag is immutable or that is referenced by digest (0 to use -expiry-delay).")
+	flag.DurationVar(&mutableTagsRefreshInterval, "mutable-tags-refresh-interval", 0, "How often images with a mutable tag are pulled again from upstream (0 to disable).")
+	flag.DurationVar(&mutableTagsResyncInterval, "mutable-tags-resync-interval", 0, "How often the upstream digest of images with a mutable tag is checked, images being pulled again if their tag has moved (0 to disable, overridden by the resyncInterval of CachedImages).")
+	flag.StringVar(&immutableTags, "immutable-tags", controllers.DefaultImmutableTags.String(), "Regex matching tags that are not expected to change upstream, other tags being considered mutable.")
+	flag.Float64Var(&upgradeUnschedulableNodesRatio, "upgrade-unschedulable-nodes-ratio", 0.2, "Ratio of unschedulable nodes from which a cluster upgrade is considered in progress, pausing expiry of images and registry garbage collections (0 to disable).")
+	flag.DurationVar(&upgradeCooldown, "upgrade-cooldown", 30*time.Minute, "How long expiry of images and registry garbage collections stay paused once nodes are schedulable again after a cluster upgrade.")
+	flag.DurationVar(&nodeImagesExpiryDelay, "node-images-expiry-delay", 0, "The delay before deleting an unused CachedImage once its image is missing from every node, unused images present on a node don't expire (0 to disable).")
+	flag.StringVar(&maxCacheSize, "max-cache-size", "0", "Size of the cache above which the least recently used images that are not in use, retained nor pinned are evicted, e.g. 100Gi (0 to disable).")
+	flag.DurationVar(&cacheQuotaCheckInterval, "cache-quota-check-interval", 5*time.Minute, "How often the size of the cache is checked against -max-cache-size.")
+	flag.BoolVar(&scaledWorkloadsExpiryProtection, "scaled-workloads-expiry-protection", false, "Don't delete unused CachedImages of Deployments and StatefulSets scaled by a HorizontalPodAutoscaler, which may scale up at any time.")
 	flag.IntVar(&proxyPort, "proxy-port", 8082, "The port on which the registry proxy accepts connections on each host.")
+	flag.StringVar(&proxyHost, "proxy-host", registry.DefaultProxyHost, "The loopback host used in rewritten images to reach the registry proxy, e.g. \"127.0.0.1\" or \"::1\" on IPv6-only clusters.")
+	flag.StringVar(&proxyPortsConfigMap, "proxy-ports-configmap", "", "Name of the ConfigMap, in the namespace of the controller, where proxies record the ports they listen on when they may fall back to other ports than -proxy-port.")
 	flag.Var(&ignoreImages, "ignore-images", "Regex that represents images to be excluded (this flag can be used multiple times).")
+	flag.Var(&includeImages, "include-images", "Regex that represents images to be included, other images being excluded (this flag can be used multiple times, every image is included by default).")
+	flag.BoolVar(&rewriteEphemeralContainers, "rewrite-ephemeral-containers", true, "Rewrite and cache images of ephemeral containers, e.g. added by kubectl debug.")
+	flag.BoolVar(&dropImagePullSecrets, "drop-image-pull-secrets", false, "Remove the image pull secrets of new pods whose images are all rewritten, keeping them in an annotation.")
+	flag.BoolVar(&checkImagePlatforms, "check-image-platforms", false, "Don't rewrite images that provide none of the architectures put in cache or not the platform of their pod, looking up their platforms while admitting pods.")
 	flag.Var(&architectures, "arch", "Architecture of image to put in cache (this flag can be used multiple times).")
 	flag.StringVar(&registry.Endpoint, "registry-endpoint", "kube-image-keeper-registry:5000", "The address of the registry where cached images are stored.")
+	flag.StringVar(&registryStorage, "registry-storage", "", "Storage backend of the registry: ephemeral, persistent-volume, minio, s3, azure or gcs, as reported by the admin API.")
+	flag.StringVar(&registryTLSSecret, "registry-tls-secret", "", "Name of the Secret, in the namespace of the controllers, where the certificates securing the connections to the registry with mutual TLS are issued and rotated.")
+	flag.StringVar(&registryTLSDir, "registry-tls-dir", "", "Directory where the Secret of -registry-tls-secret is mounted, the registry is reached over plain HTTP if empty.")
+	flag.StringVar(&registry.PreviousEndpoint, "previous-registry-endpoint", "", "The address of the registry cached images are migrated from. Until every cached image is in -registry-endpoint, missing images are copied from it and images put in cache are written to both registries.")
+	flag.StringVar(&registryMigrationConfigMap, "registry-migration-configmap", "", "Name of the ConfigMap, in the namespace of the controllers, where the migration from -previous-registry-endpoint is reported.")
+	flag.DurationVar(&registryMigrationInterval, "registry-migration-interval", 5*time.Minute, "How often cached images missing from -registry-endpoint are migrated from -previous-registry-endpoint.")
+	flag.DurationVar(&registryTLSValidity, "registry-tls-validity", 30*24*time.Hour, "Validity of the certificates of the registry and of its clients, renewed once two thirds of it have elapsed.")
+	flag.DurationVar(&registryHealthCheckInterval, "registry-health-check-interval", 30*time.Second, "How often the controllers check that the registry and its storage backend answer (0 to disable).")
+	flag.StringVar(&registryStorageCapacity, "registry-storage-capacity", "0", "Size of the storage of the registry, e.g. 100Gi, the caching of new images being paused once it is used above -registry-storage-high-watermark (0 to disable).")
+	flag.Float64Var(&registryStorageHighWatermark, "registry-storage-high-watermark", 0.9, "Ratio of -registry-storage-capacity used from which the caching of new images is paused.")
+	flag.DurationVar(&registryMaxLatency, "registry-max-latency", 0, "Response time of the registry from which the caching of new images is paused, its storage being likely saturated (0 to disable).")
+	flag.DurationVar(&registry.UpstreamDigests.TTL, "upstream-digest-cache-ttl", registry.UpstreamDigests.TTL, "How long digests of upstream images are memoized, so that many reconciles of the same tag resolve it only once upstream (0 to disable).")
+	flag.StringVar(&upstreamManifestsBudget, "upstream-manifests-budget", "", "Maximum number of manifests pulled from upstream registries per time window, e.g. 500/1h (unlimited by default).")
+	flag.StringVar(&maxManifestSize, "max-manifest-size", "4Mi", "Maximum size of manifests pulled from upstream registries (0 to disable).")
+	flag.IntVar(&registry.UpstreamLimits.MaxLayers, "max-layers", registry.UpstreamLimits.MaxLayers, "Maximum number of layers of manifests pulled from upstream registries (0 to disable).")
+	flag.IntVar(&registry.UpstreamLimits.MaxTagLength, "max-tag-length", registry.UpstreamLimits.MaxTagLength, "Maximum length of tags of images pulled from upstream registries (0 to disable).")
+	flag.Var(featuregate.Gates, "feature-gates", featuregate.Gates.Usage())
+	flag.Var(&shortNameAliasesPaths, "short-name-aliases", "Path of a containers-registries.conf file or directory whose [aliases] tables and unqualified-search-registries resolve short image names like CRI-O does, e.g. /etc/containers/registries.conf.d (this flag can be used multiple times).")
+	flag.Var(&allowedBaseRegistries, "allowed-base-registries", "Registries the base images declared in the provenance attestations of images may come from, images with base images from other registries are not cached (this flag can be used multiple times, every registry is allowed by default).")
+	flag.StringVar(&signaturePolicyPath, "signature-policy", "", "Path of a JSON file listing the registries whose images must have a cosign signature verified by their keys or keyless identities before being cached (signatures are not verified by default).")
+	flag.StringVar(&trivyPath, "scan-trivy-path", "", "Path of the trivy binary scanning images for vulnerabilities once they are put in cache (images are not scanned by default).")
+	flag.StringVar(&scannerWebhookURL, "scan-webhook-url", "", "URL of a scanner webhook images are sent to once they are put in cache, answering with the number of vulnerabilities found by severity, instead of -scan-trivy-path.")
+	flag.IntVar(&maxConcurrentScans, "scan-max-concurrent", 1, "Maximum number of images scanned for vulnerabilities at the same time, apart from the images being put in cache.")
+	flag.StringVar(&blockSeverity, "scan-block-severity", "", "Severity (UNKNOWN, LOW, MEDIUM, HIGH or CRITICAL) from which vulnerabilities found by scans block images, which the proxy refuses to serve (images are never blocked by default).")
+	flag.BoolVar(&registry.Airgapped, "airgapped", registry.Airgapped, "Never contact upstream registries, for disconnected clusters: images are not pulled anymore and pods using images that are not in cache are rejected by the validating webhook.")
+	flag.BoolVar(&registry.CacheArtifacts, "cache-artifacts", registry.CacheArtifacts, "Cache the cosign signatures, attestations and SBOMs of images along with them, as well as the manifests referring to them through the OCI Referrers API.")
+	flag.IntVar(&registry.BaseImagesPolicy.MaxDepth, "base-images-policy-depth", registry.BaseImagesPolicy.MaxDepth, "How many levels of base images with provenance attestations are checked against -allowed-base-registries.")
+	flag.StringVar(&upstreamBytesBudget, "upstream-bytes-budget", "", "Maximum amount of bytes pulled from upstream registries per time window, e.g. 50Gi/24h (unlimited by default).")
+	flag.DurationVar(&pullTimeout, "pull-timeout", 0, "Maximum duration of a pull from upstream, raised for images whose expected size can't be pulled in time at 1MiB/s (0 to disable, overridden by the kuik.enix.io/pull-timeout annotation).")
+	flag.IntVar(&maxSyncedTags, "repository-max-synced-tags", 100, "Maximum number of tags of a Repository put in cache by its spec.tags, the latest ones being kept (0 to disable).")
 	flag.IntVar(&maxConcurrentCachedImageReconciles, "max-concurrent-cached-image-reconciles", 3, "Maximum number of CachedImages that can be handled and reconciled at the same time (put or removed from cache).")
 	flag.Var(&insecureRegistries, "insecure-registries", "Insecure registries to allow to cache and proxify images from (this flag can be used multiple times).")
+	flag.Var(&upstreamDNSServers, "upstream-dns-servers", "DNS server, as host[:port], resolving the names of upstream registries instead of the resolver of the system (this flag can be used multiple times).")
+	flag.BoolVar(&upstreamAddressOverrides, "upstream-address-overrides", false, "Reach upstream registries at the spec.upstreamAddress of their Repository, watching Repositories on every replica.")
 	flag.Var(&rootCAPaths, "root-certificate-authorities", "Root certificate authorities to trust.")
+	flag.StringVar(&gcCronJobName, "gc-cronjob", "", "Name of the registry garbage collection CronJob, in the namespace of the controller, to run after images are removed from the cache.")
+	flag.IntVar(&gcAfterDeletions, "gc-after-deletions", 0, "Number of images removed from the cache that triggers a registry garbage collection (0 to only rely on the CronJob schedule).")
+	flag.StringVar(&tlsMinVersion, "tls-min-version", "", "Minimum TLS version of the webhook server and of clients of upstream registries, e.g. VersionTLS12 (defaults to the Go default).")
+	flag.StringVar(&tlsCipherSuites, "tls-cipher-suites", "", "Comma separated list of TLS 1.0-1.2 cipher suites allowed by the webhook server and by clients of upstream registries, using IANA names (defaults to the Go default).")
+	flag.BoolVar(&enablePrefetch, "prefetch", false, "Enable predictive prefetch: learn when images are requested and refresh them shortly before.")
+	flag.DurationVar(&prefetchLeadTime, "prefetch-lead-time", 30*time.Minute, "How long before a predicted request images are refreshed.")
+	flag.IntVar(&prefetchMinRequests, "prefetch-min-requests", 2, "Minimum number of requests recorded during the same hour of the week to predict a request.")
+	flag.BoolVar(&cacheSandboxImages, "cache-sandbox-images", false, "Cache and retain the sandbox (pause) images found on nodes, which are pulled by container runtimes without going through pods.")
+	flag.StringVar(&proxyDaemonSet, "proxy-rollout-daemonset", "", "Name of the proxy DaemonSet, in the namespace of the controllers, to roll out one node at a time once its proxy serves no image pull. Its update strategy must be OnDelete.")
+	flag.DurationVar(&proxyRolloutProgressDeadline, "proxy-rollout-progress-deadline", 10*time.Minute, "How long a proxy replaced by the rollout of -proxy-rollout-daemonset may take to be ready before the rollout is halted (0 to wait indefinitely).")
+	flag.StringVar(&networkPoliciesPrefix, "network-policies-prefix", "", "Prefix of the names of the NetworkPolicies of the registry, the controllers and the proxy, created and kept in sync by the controllers in their namespace (disabled if empty).")
+	flag.StringVar(&networkPoliciesSelector, "network-policies-selector", "", "Labels of every pod of kuik, e.g. app.kubernetes.io/instance=kuik, components being told apart by their app.kubernetes.io/component label.")
+	flag.Var(&networkPoliciesUpstreamCIDRs, "network-policies-upstream-cidrs", "CIDR every upstream registry may be reached at, along with the upstream CIDRs of Repositories (this flag can be used multiple times).")
+	flag.BoolVar(&networkPoliciesProxyHostNetwork, "network-policies-proxy-host-network", false, "Allow connections to the registry from the addresses of nodes, the proxy running in the host network.")
+	flag.BoolVar(&warmupNodes, "warmup-nodes", false, "Pull the most used cached images on nodes joining the cluster, e.g. when the cluster autoscaler scales up.")
+	flag.StringVar(&warmupNodeSelector, "warmup-node-selector", "", "Label selector of the nodes to warm up (every node by default).")
+	flag.IntVar(&warmupTopImages, "warmup-top-images", 10, "Number of most pulled cached images to warm up nodes with.")
+	flag.DurationVar(&warmupMaxNodeAge, "warmup-max-node-age", time.Hour, "Nodes that have joined the cluster for longer are not warmed up, e.g. when the controllers start (0 to warm up every node).")
+	flag.BoolVar(&prePullImages, "pre-pull-images", false, "Pull cached images on the nodes selected by their prePullNodeSelector, or by the one of an ImagePrefetch listing them, as soon as they are in cache.")
+	flag.DurationVar(&prePullInterval, "pre-pull-interval", 10*time.Minute, "Minimum delay between two pre-pulls on a node.")
+	flag.StringVar(&pullTokenKeyPath, "pull-token-key", "", "Path of the key signing pull tokens, enabling their issuance by the admin API. The proxy must be given the same key.")
+	flag.DurationVar(&pullTokenMaxTTL, "pull-token-max-ttl", 24*time.Hour, "Maximum validity of pull tokens issued by the admin API.")
+	flag.StringVar(&pullTokenIssuersPath, "pull-token-issuers-htpasswd", "", "Path of an htpasswd file with bcrypt passwords of the users allowed to issue pull tokens, required with -pull-token-key.")
+	flag.StringVar(&snapshotKeyPath, "snapshot-signing-key", "", "Path of the PEM encoded Ed25519 key signing snapshots of the images in use, enabling their export by the admin API.")
+	flag.StringVar(&sandboxImages, "sandbox-images", controllers.DefaultSandboxImages.String(), "Regex matching sandbox images among the images present on nodes.")
 
 	opts := zap.Options{
 		Development:     true,
@@ -64,6 +240,101 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if err := tlsconfig.SetMinVersion(tlsMinVersion); err != nil {
+		setupLog.Error(err, "invalid TLS minimum version")
+		os.Exit(1)
+	}
+	if err := tlsconfig.SetCipherSuites(tlsCipherSuites); err != nil {
+		setupLog.Error(err, "invalid TLS cipher suites")
+		os.Exit(1)
+	}
+	if registryTLSDir != "" {
+		registry.ConfigureClientTLS(registryTLSDir)
+	}
+	if len(upstreamDNSServers) > 0 {
+		registry.UpstreamResolver = registry.NewResolver(upstreamDNSServers)
+	}
+	manifestsLimit, err := registry.ParseLimit(upstreamManifestsBudget, false)
+	if err != nil {
+		setupLog.Error(err, "invalid upstream manifests budget")
+		os.Exit(1)
+	}
+	bytesLimit, err := registry.ParseLimit(upstreamBytesBudget, true)
+	if err != nil {
+		setupLog.Error(err, "invalid upstream bytes budget")
+		os.Exit(1)
+	}
+	registry.UpstreamBudget.Manifests = manifestsLimit
+	registry.UpstreamBudget.Bytes = bytesLimit
+	if registry.UpstreamLimits.MaxManifestSize, err = registry.ParseSize(maxManifestSize); err != nil {
+		setupLog.Error(err, "invalid maximum manifest size")
+		os.Exit(1)
+	}
+	maxCacheSizeBytes, err := registry.ParseSize(maxCacheSize)
+	if err != nil {
+		setupLog.Error(err, "invalid maximum cache size")
+		os.Exit(1)
+	}
+	registryStorageCapacityBytes, err := registry.ParseSize(registryStorageCapacity)
+	if err != nil {
+		setupLog.Error(err, "invalid registry storage capacity")
+		os.Exit(1)
+	}
+	registry.BaseImagesPolicy.AllowedRegistries = allowedBaseRegistries
+	if signaturePolicyPath != "" {
+		if registry.ImageSignaturePolicy, err = registry.LoadSignaturePolicy(signaturePolicyPath); err != nil {
+			setupLog.Error(err, "invalid signature policy")
+			os.Exit(1)
+		}
+	}
+	var scanner scan.Scanner
+	if scannerWebhookURL != "" {
+		scanner = &scan.Webhook{URL: scannerWebhookURL}
+	} else if trivyPath != "" {
+		trivy := &scan.Trivy{Path: trivyPath, Insecure: registry.CacheIsInsecure()}
+		// Trivy can't present the client certificates required by the registry, images are exported for it instead
+		if registryTLSDir != "" {
+			trivy.Export = func(ctx context.Context, image scan.Image, path string) error {
+				return registry.ExportCachedImage(ctx, image.SourceImage, image.Digest, path)
+			}
+		}
+		scanner = trivy
+	}
+	var blockSeverityThreshold *scan.Severity
+	if blockSeverity != "" {
+		severity, err := scan.ParseSeverity(blockSeverity)
+		if err != nil {
+			setupLog.Error(err, "invalid scan block severity")
+			os.Exit(1)
+		}
+		blockSeverityThreshold = &severity
+	}
+	immutableTagsRegexp, err := regexp.Compile(immutableTags)
+	if err != nil {
+		setupLog.Error(err, "invalid immutable tags regex")
+		os.Exit(1)
+	}
+	sandboxImagesRegexp, err := regexp.Compile(sandboxImages)
+	if err != nil {
+		setupLog.Error(err, "invalid sandbox images regex")
+		os.Exit(1)
+	}
+	registryStorageBackend, err := registry.ParseStorageBackend(registryStorage)
+	if err != nil {
+		setupLog.Error(err, "invalid registry storage backend")
+		os.Exit(1)
+	}
+	warmupNodeSelectorParsed, err := labels.Parse(warmupNodeSelector)
+	if err != nil {
+		setupLog.Error(err, "invalid warm-up node selector")
+		os.Exit(1)
+	}
+	networkPoliciesLabels, err := labels.ConvertSelectorToLabelsMap(networkPoliciesSelector)
+	if err != nil {
+		setupLog.Error(err, "invalid network policies selector")
+		os.Exit(1)
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme.NewScheme(),
 		MetricsBindAddress:     metricsAddr,
@@ -77,49 +348,297 @@ func main() {
 		os.Exit(1)
 	}
 
+	mgr.GetWebhookServer().TLSOpts = append(mgr.GetWebhookServer().TLSOpts, tlsconfig.Apply)
+
 	rootCAs, err := registry.LoadRootCAPoolFromFiles(rootCAPaths)
 	if err != nil {
 		setupLog.Error(err, "could not load root certificate authorities")
 		os.Exit(1)
 	}
 
+	// Upstream addresses are used by the webhook as well as by the controllers, so every replica watches them
+	if upstreamAddressOverrides {
+		if err := proxy.WatchUpstreamHosts(context.Background(), mgr.GetCache()); err != nil {
+			setupLog.Error(err, "unable to watch the upstream addresses of repositories")
+			os.Exit(1)
+		}
+	}
+
+	// Cache lifecycle events are streamed by the admin API of every replica, from the Kubernetes events recorded by the
+	// controllers
+	eventBroker := events.NewBroker()
+	if err := mgr.Add(events.NewWatcher(mgr, eventBroker)); err != nil {
+		setupLog.Error(err, "unable to setup cache lifecycle events watcher")
+		os.Exit(1)
+	}
+
+	if registryHealthChecker := controllers.NewRegistryHealthChecker(registryHealthCheckInterval, registryStorageBackend); registryHealthChecker != nil {
+		if err := mgr.Add(registryHealthChecker); err != nil {
+			setupLog.Error(err, "unable to setup registry health checker")
+			os.Exit(1)
+		}
+	}
+
+	cachingBackpressure := controllers.NewCachingBackpressure(mgr.GetClient(), registryHealthCheckInterval, registryStorageCapacityBytes, registryStorageHighWatermark, registryMaxLatency)
+	if cachingBackpressure != nil {
+		if err := mgr.Add(cachingBackpressure); err != nil {
+			setupLog.Error(err, "unable to setup caching backpressure")
+			os.Exit(1)
+		}
+	}
+
+	registryHost := strings.Split(registry.Endpoint, ":")[0]
+	registryCertificates := controllers.NewRegistryCertificates(mgr.GetClient(), mgr.GetEventRecorderFor("registry-certificates"), os.Getenv("POD_NAMESPACE"), registryTLSSecret, strings.Split(registryHost, ".")[0], controllers.RegistryDNSNames(registryHost, os.Getenv("POD_NAMESPACE")), registryTLSValidity)
+	if registryCertificates != nil {
+		if err := mgr.Add(registryCertificates); err != nil {
+			setupLog.Error(err, "unable to setup registry certificates")
+			os.Exit(1)
+		}
+	}
+
+	if registryMigration := controllers.NewRegistryMigration(mgr.GetClient(), mgr.GetEventRecorderFor("registry-migration"), os.Getenv("POD_NAMESPACE"), registryMigrationConfigMap, registryMigrationInterval); registryMigration != nil {
+		if err := mgr.Add(registryMigration); err != nil {
+			setupLog.Error(err, "unable to setup registry migration")
+			os.Exit(1)
+		}
+	}
+
+	upgradeDetector := controllers.NewUpgradeDetector(mgr.GetClient(), upgradeUnschedulableNodesRatio, upgradeCooldown)
+	garbageCollector := controllers.NewGarbageCollector(mgr.GetClient(), mgr.GetAPIReader(), mgr.GetEventRecorderFor("garbage-collector"), os.Getenv("POD_NAMESPACE"), gcCronJobName, gcAfterDeletions)
+	if garbageCollector != nil {
+		garbageCollector.UpgradeDetector = upgradeDetector
+		if err := mgr.Add(garbageCollector); err != nil {
+			setupLog.Error(err, "unable to setup garbage collector")
+			os.Exit(1)
+		}
+	}
+	if cacheQuota := controllers.NewCacheQuota(mgr.GetClient(), events.NewRecorder(mgr.GetEventRecorderFor("cache-quota")), maxCacheSizeBytes, cacheQuotaCheckInterval); cacheQuota != nil {
+		cacheQuota.UpgradeDetector = upgradeDetector
+		if err := mgr.Add(cacheQuota); err != nil {
+			setupLog.Error(err, "unable to setup cache quota")
+			os.Exit(1)
+		}
+	}
+
 	if err = (&controllers.CachedImageReconciler{
-		Client:             mgr.GetClient(),
-		Scheme:             mgr.GetScheme(),
-		Recorder:           mgr.GetEventRecorderFor("cachedimage-controller"),
-		ApiReader:          mgr.GetAPIReader(),
-		ExpiryDelay:        time.Duration(expiryDelay*24) * time.Hour,
-		Architectures:      []string(architectures),
-		InsecureRegistries: []string(insecureRegistries),
-		RootCAs:            rootCAs,
+		Client:                     mgr.GetClient(),
+		Scheme:                     mgr.GetScheme(),
+		Recorder:                   events.NewRecorder(mgr.GetEventRecorderFor("cachedimage-controller")),
+		ApiReader:                  mgr.GetAPIReader(),
+		ExpiryDelay:                time.Duration(expiryDelay*24) * time.Hour,
+		Architectures:              []string(architectures),
+		InsecureRegistries:         []string(insecureRegistries),
+		RootCAs:                    rootCAs,
+		GarbageCollector:           garbageCollector,
+		UpgradeDetector:            upgradeDetector,
+		CachingQueue:               controllers.NewCachingQueue(mgr.GetClient(), maxConcurrentCachedImageReconciles),
+		NodeImagesExpiryDelay:      nodeImagesExpiryDelay,
+		ScaledWorkloads:            controllers.NewScaledWorkloads(mgr.GetClient(), scaledWorkloadsExpiryProtection),
+		MutableTagsExpiryDelay:     mutableTagsExpiryDelay,
+		ImmutableTagsExpiryDelay:   immutableTagsExpiryDelay,
+		MutableTagsRefreshInterval: mutableTagsRefreshInterval,
+		MutableTagsResyncInterval:  mutableTagsResyncInterval,
+		ImmutableTags:              immutableTagsRegexp,
+		PullTimeout:                pullTimeout,
+		Backpressure:               cachingBackpressure,
 	}).SetupWithManager(mgr, maxConcurrentCachedImageReconciles); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "CachedImage")
 		os.Exit(1)
 	}
+	if scanner != nil {
+		if err = (&controllers.ImageScanReconciler{
+			Client:        mgr.GetClient(),
+			Recorder:      mgr.GetEventRecorderFor("image-scan"),
+			Scanner:       scanner,
+			BlockSeverity: blockSeverityThreshold,
+		}).SetupWithManager(mgr, maxConcurrentScans); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "ImageScan")
+			os.Exit(1)
+		}
+	}
 	if err = (&controllers.PodReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("pod-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Pod")
 		os.Exit(1)
 	}
+	proxyHost, err = registry.ParseProxyHost(proxyHost)
+	if err != nil {
+		setupLog.Error(err, "invalid proxy host")
+		os.Exit(1)
+	}
+	shortNames, err := registry.LoadShortNames(shortNameAliasesPaths...)
+	if err != nil {
+		setupLog.Error(err, "unable to load short name aliases")
+		os.Exit(1)
+	}
 	imageRewriter := kuikenixiov1.ImageRewriter{
-		Client:       mgr.GetClient(),
-		IgnoreImages: ignoreImages,
-		ProxyPort:    proxyPort,
+		Client:                     mgr.GetClient(),
+		IgnoreImages:               ignoreImages,
+		IncludeImages:              includeImages,
+		ProxyPort:                  proxyPort,
+		ProxyHost:                  proxyHost,
+		ShortNames:                 shortNames,
+		Architectures:              []string(architectures),
+		RewriteEphemeralContainers: rewriteEphemeralContainers,
+		DropImagePullSecrets:       dropImagePullSecrets,
+	}
+	if checkImagePlatforms {
+		imageRewriter.ImagePlatforms = func(ctx context.Context, image string, pod *corev1.Pod) ([]string, error) {
+			pullSecretNames := []string{}
+			for _, pullSecret := range pod.Spec.ImagePullSecrets {
+				pullSecretNames = append(pullSecretNames, pullSecret.Name)
+			}
+			pullSecrets, err := registry.GetPullSecrets(mgr.GetAPIReader(), pod.Namespace, pullSecretNames)
+			if err != nil {
+				return nil, err
+			}
+			return registry.ImagePlatforms(ctx, image, pullSecrets, []string(insecureRegistries), rootCAs)
+		}
+	}
+	if proxyPortsConfigMap != "" {
+		imageRewriter.ProxyPorts = &proxy.PortResolver{
+			Reader:      mgr.GetAPIReader(),
+			Namespace:   os.Getenv("POD_NAMESPACE"),
+			Name:        proxyPortsConfigMap,
+			DefaultPort: proxyPort,
+		}
+		if err := mgr.Add(imageRewriter.ProxyPorts); err != nil {
+			setupLog.Error(err, "unable to setup proxy ports resolver")
+			os.Exit(1)
+		}
 	}
 	mgr.GetWebhookServer().Register("/mutate-core-v1-pod", &webhook.Admission{Handler: &imageRewriter})
+	mgr.GetWebhookServer().Register("/validate-core-v1-pod", &webhook.Admission{Handler: &kuikenixiov1.CachedImagesValidator{Client: mgr.GetClient()}})
 	if err = (&kuikv1alpha1.CachedImage{}).SetupWebhookWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create webhook", "webhook", "CachedImage")
 		os.Exit(1)
 	}
 	if err = (&controllers.RepositoryReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:             mgr.GetClient(),
+		Scheme:             mgr.GetScheme(),
+		ApiReader:          mgr.GetAPIReader(),
+		InsecureRegistries: []string(insecureRegistries),
+		RootCAs:            rootCAs,
+		MaxSyncedTags:      maxSyncedTags,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Repository")
 		os.Exit(1)
 	}
+	if err = (&controllers.ApplicationReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: events.NewRecorder(mgr.GetEventRecorderFor("application-controller")),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Application")
+		os.Exit(1)
+	}
+	if err = (&controllers.ReleaseReconciler{
+		Client:             mgr.GetClient(),
+		Scheme:             mgr.GetScheme(),
+		Recorder:           events.NewRecorder(mgr.GetEventRecorderFor("release-controller")),
+		InsecureRegistries: []string(insecureRegistries),
+		RootCAs:            rootCAs,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Release")
+		os.Exit(1)
+	}
+	if err = (&controllers.ImagePrefetchReconciler{
+		Client:             mgr.GetClient(),
+		Scheme:             mgr.GetScheme(),
+		Recorder:           events.NewRecorder(mgr.GetEventRecorderFor("imageprefetch-controller")),
+		ImmutableTags:      immutableTagsRegexp,
+		InsecureRegistries: []string(insecureRegistries),
+		RootCAs:            rootCAs,
+		Architectures:      []string(architectures),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ImagePrefetch")
+		os.Exit(1)
+	}
+	if enablePrefetch {
+		if err = (&controllers.PrefetchReconciler{
+			Client:             mgr.GetClient(),
+			Scheme:             mgr.GetScheme(),
+			Recorder:           events.NewRecorder(mgr.GetEventRecorderFor("prefetch-controller")),
+			ApiReader:          mgr.GetAPIReader(),
+			LeadTime:           prefetchLeadTime,
+			MinRequests:        prefetchMinRequests,
+			Architectures:      []string(architectures),
+			InsecureRegistries: []string(insecureRegistries),
+			RootCAs:            rootCAs,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Prefetch")
+			os.Exit(1)
+		}
+	}
+	if cacheSandboxImages {
+		if err = (&controllers.SandboxImageReconciler{
+			Client:        mgr.GetClient(),
+			SandboxImages: sandboxImagesRegexp,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "SandboxImage")
+			os.Exit(1)
+		}
+	}
+	if warmupNodes {
+		if err = (&controllers.NodeWarmupReconciler{
+			Client:       mgr.GetClient(),
+			Recorder:     mgr.GetEventRecorderFor("node-warmup-controller"),
+			Namespace:    os.Getenv("POD_NAMESPACE"),
+			NodeSelector: warmupNodeSelectorParsed,
+			TopImages:    warmupTopImages,
+			MaxNodeAge:   warmupMaxNodeAge,
+			ProxyHost:    proxyHost,
+			ProxyPort:    proxyPort,
+			ProxyPorts:   imageRewriter.ProxyPorts,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "NodeWarmup")
+			os.Exit(1)
+		}
+	}
+	if prePullImages {
+		if err = (&controllers.NodePrePullReconciler{
+			Client:     mgr.GetClient(),
+			Recorder:   mgr.GetEventRecorderFor("node-prepull-controller"),
+			Namespace:  os.Getenv("POD_NAMESPACE"),
+			Interval:   prePullInterval,
+			ProxyHost:  proxyHost,
+			ProxyPort:  proxyPort,
+			ProxyPorts: imageRewriter.ProxyPorts,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "NodePrePull")
+			os.Exit(1)
+		}
+	}
+	if networkPoliciesPrefix != "" {
+		if err = (&controllers.NetworkPolicyReconciler{
+			Client:           mgr.GetClient(),
+			ApiReader:        mgr.GetAPIReader(),
+			Namespace:        os.Getenv("POD_NAMESPACE"),
+			Prefix:           networkPoliciesPrefix,
+			Selector:         networkPoliciesLabels,
+			UpstreamCIDRs:    networkPoliciesUpstreamCIDRs,
+			ProxyHostNetwork: networkPoliciesProxyHostNetwork,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "NetworkPolicy")
+			os.Exit(1)
+		}
+	}
+	if proxyDaemonSet != "" {
+		if err = (&controllers.ProxyRolloutReconciler{
+			Client:           mgr.GetClient(),
+			Recorder:         mgr.GetEventRecorderFor("proxy-rollout-controller"),
+			Namespace:        os.Getenv("POD_NAMESPACE"),
+			Name:             proxyDaemonSet,
+			ProgressDeadline: proxyRolloutProgressDeadline,
+			InFlightRequests: proxy.InFlightRequests,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "ProxyRollout")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	err = mgr.Add(&kuikenixiov1.PodInitializer{Client: mgr.GetClient()})
@@ -128,6 +647,69 @@ func main() {
 		os.Exit(1)
 	}
 
+	adminServer := admin.New(mgr.GetClient(), eventBroker, adminAddr)
+	adminServer.WithRuntimeConfig(admin.NewRuntimeConfig(flag.CommandLine, featuregate.Gates, string(registryStorageBackend)))
+	if pullTokenKeyPath != "" {
+		pullTokens, err := pulltoken.LoadSigner(pullTokenKeyPath)
+		if err != nil {
+			setupLog.Error(err, "unable to load pull token key")
+			os.Exit(1)
+		}
+		if pullTokenIssuersPath == "" {
+			setupLog.Error(fmt.Errorf("-pull-token-issuers-htpasswd is required with -pull-token-key"), "unable to enable pull tokens")
+			os.Exit(1)
+		}
+		issuers, err := proxy.LoadHtpasswd(pullTokenIssuersPath)
+		if err != nil {
+			setupLog.Error(err, "unable to load pull token issuers htpasswd file")
+			os.Exit(1)
+		}
+		adminServer.WithPullTokens(pullTokens, pullTokenMaxTTL, issuers)
+	}
+	if snapshotKeyPath != "" {
+		snapshots, err := snapshot.LoadSigner(snapshotKeyPath)
+		if err != nil {
+			setupLog.Error(err, "unable to load snapshot signing key")
+			os.Exit(1)
+		}
+		adminServer.WithSnapshots(snapshots)
+	}
+	if adminAddr != "0" {
+		if err := mgr.Add(adminServer); err != nil {
+			setupLog.Error(err, "unable to setup admin server")
+			os.Exit(1)
+		}
+	}
+
+	if singlePortAddr != "0" {
+		registryURL, err := url.Parse(registry.Protocol + registry.Endpoint)
+		if err != nil {
+			setupLog.Error(err, "invalid registry endpoint")
+			os.Exit(1)
+		}
+		var authenticate func(http.Handler) http.Handler
+		if singlePortHtpasswdPath != "" {
+			htpasswd, err := proxy.LoadHtpasswd(singlePortHtpasswdPath)
+			if err != nil {
+				setupLog.Error(err, "unable to load single port htpasswd file")
+				os.Exit(1)
+			}
+			authenticate = func(handler http.Handler) http.Handler {
+				return proxy.BasicAuth(htpasswd, nil, handler)
+			}
+		}
+		metricsHandler := promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{})
+		singlePort, err := admin.NewSinglePort(singlePortAddr, adminServer, metricsHandler, registryURL, authenticate)
+		if err != nil {
+			setupLog.Error(err, "invalid single port configuration")
+			os.Exit(1)
+		}
+		if err := mgr.Add(singlePort); err != nil {
+			setupLog.Error(err, "unable to setup single port server")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", controllers.MakeChecker(controllers.Healthz)); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
@@ -136,6 +718,11 @@ func main() {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	// Pods are only sent to the webhook once it serves, so that they aren't rejected while the controllers start
+	if err := mgr.AddReadyzCheck("webhook", mgr.GetWebhookServer().StartedChecker()); err != nil {
+		setupLog.Error(err, "unable to set up webhook ready check")
+		os.Exit(1)
+	}
 
 	controllers.SetLeader(false)
 	go func() {
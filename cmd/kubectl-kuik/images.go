@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/controllers"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const listUsage = `List cached images with their size, the last time they have been used and the number of pods using them.
+
+Usage:
+  kubectl kuik list [flags]
+
+Flags:
+`
+
+const podsUsage = `List the pods using images.
+
+Usage:
+  kubectl kuik pods <image>...
+`
+
+const cacheUsage = `Put images in cache, or pull them again from their upstream registry if they are already cached.
+
+Usage:
+  kubectl kuik cache <image>... [flags]
+
+Flags:
+`
+
+const evictUsage = `Evict images from the cache. Images used by pods are only evicted with -force, and are put in cache again as
+soon as a pod using them is created.
+
+Usage:
+  kubectl kuik evict <image>... [flags]
+
+Flags:
+`
+
+func listCommand(args []string) error {
+	var sortBy string
+
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, listUsage)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&sortBy, "sort", "name", "Order of the images: name, size (largest first) or last-used (most recent first).")
+
+	if _, err := parseInterspersed(flags, args); err != nil {
+		return err
+	}
+	if sortBy != "name" && sortBy != "size" && sortBy != "last-used" {
+		return fmt.Errorf("invalid sort order %q, use name, size or last-used", sortBy)
+	}
+
+	k8sClient, _, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	var cachedImages kuikv1alpha1.CachedImageList
+	if err := k8sClient.List(context.Background(), &cachedImages); err != nil {
+		return err
+	}
+
+	sortCachedImages(cachedImages.Items, sortBy)
+	return printCachedImages(os.Stdout, cachedImages.Items, time.Now())
+}
+
+// sortCachedImages sorts CachedImages by name, by size (largest first) or by last use (most recent first)
+func sortCachedImages(cachedImages []kuikv1alpha1.CachedImage, sortBy string) {
+	sort.SliceStable(cachedImages, func(i, j int) bool {
+		switch sortBy {
+		case "size":
+			if cachedImages[i].Status.Size != cachedImages[j].Status.Size {
+				return cachedImages[i].Status.Size > cachedImages[j].Status.Size
+			}
+		case "last-used":
+			lastUsedI, lastUsedJ := lastUsedAt(&cachedImages[i]), lastUsedAt(&cachedImages[j])
+			if !lastUsedI.Equal(lastUsedJ) {
+				return lastUsedI.After(lastUsedJ)
+			}
+		}
+		return cachedImages[i].Spec.SourceImage < cachedImages[j].Spec.SourceImage
+	})
+}
+
+func printCachedImages(w io.Writer, cachedImages []kuikv1alpha1.CachedImage, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(tw, "IMAGE\tSTATUS\tSIZE\tLAST USED\tPODS")
+	for i := range cachedImages {
+		cachedImage := &cachedImages[i]
+
+		size := "-"
+		if cachedImage.Status.Size > 0 {
+			size = controllers.FormatBytes(cachedImage.Status.Size)
+		}
+		lastUsed := "-"
+		if cachedImage.Status.UsedBy.Count > 0 {
+			lastUsed = "in use"
+		} else if t := lastUsedAt(cachedImage); !t.IsZero() {
+			lastUsed = duration.HumanDuration(now.Sub(t)) + " ago"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\n", cachedImage.Spec.SourceImage, cachedImageStatus(cachedImage), size, lastUsed, cachedImage.Status.UsedBy.Count)
+	}
+	return tw.Flush()
+}
+
+// lastUsedAt returns the last time an image has been pulled through the proxy or has stopped being used by pods
+func lastUsedAt(cachedImage *kuikv1alpha1.CachedImage) time.Time {
+	lastUsed := time.Time{}
+	if cachedImage.Status.LastUsedAt != nil {
+		lastUsed = cachedImage.Status.LastUsedAt.Time
+	}
+	if lastPulled := cachedImage.Status.Usage.LastPulledAt; lastPulled != nil && lastPulled.After(lastUsed) {
+		lastUsed = lastPulled.Time
+	}
+	return lastUsed
+}
+
+// cachedImageStatus returns a short status of a CachedImage: Cached, Caching, Failed with the reason of the failure, or
+// Pending
+func cachedImageStatus(cachedImage *kuikv1alpha1.CachedImage) string {
+	switch {
+	case meta.IsStatusConditionTrue(cachedImage.Status.Conditions, kuikv1alpha1.ConditionCaching):
+		return "Caching"
+	case cachedImage.Status.IsCached:
+		return "Cached"
+	}
+	if ready := meta.FindStatusCondition(cachedImage.Status.Conditions, kuikv1alpha1.ConditionReady); ready != nil && ready.Status == "False" && ready.Reason != "" {
+		return "Failed (" + ready.Reason + ")"
+	}
+	return "Pending"
+}
+
+func podsCommand(args []string) error {
+	flags := flag.NewFlagSet("pods", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, podsUsage)
+	}
+
+	images, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(images) == 0 {
+		flags.Usage()
+		return errors.New("images must be given")
+	}
+
+	k8sClient, _, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(tw, "IMAGE\tNAMESPACE\tPOD")
+
+	var errs []error
+	for _, image := range images {
+		cachedImage, err := getCachedImage(ctx, k8sClient, image)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", image, err))
+			continue
+		}
+		for _, pod := range cachedImage.Status.UsedBy.Pods {
+			namespace, name, _ := strings.Cut(pod.NamespacedName, "/")
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", image, namespace, name)
+		}
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+func cacheCommand(args []string) error {
+	var recache bool
+
+	flags := flag.NewFlagSet("cache", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, cacheUsage)
+		flags.PrintDefaults()
+	}
+	flags.BoolVar(&recache, "recache", false, "Delete cached images from the cache before pulling them again, instead of overwriting them.")
+
+	images, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(images) == 0 {
+		flags.Usage()
+		return errors.New("images must be given")
+	}
+
+	k8sClient, _, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var errs []error
+	for _, image := range images {
+		name, created, err := cacheImage(ctx, k8sClient, image, time.Now(), recache)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", image, err))
+		} else if created {
+			fmt.Printf("cachedimage/%s: created\n", name)
+		} else if recache {
+			fmt.Printf("cachedimage/%s: re-caching requested\n", name)
+		} else {
+			fmt.Printf("cachedimage/%s: refresh requested\n", name)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// cacheImage creates the CachedImage of an image, or requests its refresh, or its deletion from the cache before it is
+// pulled again if recache is true, if it already exists, and tells whether it has been created
+func cacheImage(ctx context.Context, k8sClient client.Client, image string, now time.Time, recache bool) (string, bool, error) {
+	cachedImage, err := controllers.CachedImageFromSourceImage(image)
+	if err != nil {
+		return "", false, err
+	}
+
+	annotations := map[string]string{kuikv1alpha1.RefreshRequestedAtAnnotationName: now.UTC().Format(time.RFC3339)}
+	if recache {
+		annotations = map[string]string{kuikv1alpha1.RecacheAnnotationName: "true"}
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return "", false, err
+	}
+
+	err = k8sClient.Patch(ctx, cachedImage, client.RawPatch(types.MergePatchType, patch))
+	if apierrors.IsNotFound(err) {
+		return cachedImage.Name, true, k8sClient.Create(ctx, cachedImage)
+	}
+
+	return cachedImage.Name, false, err
+}
+
+func evictCommand(args []string) error {
+	var force bool
+
+	flags := flag.NewFlagSet("evict", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, evictUsage)
+		flags.PrintDefaults()
+	}
+	flags.BoolVar(&force, "force", false, "Evict images even if they are used by pods.")
+
+	images, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(images) == 0 {
+		flags.Usage()
+		return errors.New("images must be given")
+	}
+
+	k8sClient, _, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var errs []error
+	for _, image := range images {
+		name, err := evictImage(ctx, k8sClient, image, force)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", image, err))
+		} else {
+			fmt.Printf("cachedimage/%s: evicted\n", name)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// evictImage deletes the CachedImage of an image, the controllers removing it from the cache registry, unless it is
+// used by pods and force is false
+func evictImage(ctx context.Context, k8sClient client.Client, image string, force bool) (string, error) {
+	cachedImage, err := getCachedImage(ctx, k8sClient, image)
+	if err != nil {
+		return "", err
+	}
+	if count := cachedImage.Status.UsedBy.Count; count > 0 && !force {
+		return "", errors.New("used by " + strconv.Itoa(count) + " pods, use -force to evict it anyway")
+	}
+
+	return cachedImage.Name, k8sClient.Delete(ctx, cachedImage)
+}
+
+// getCachedImage returns the CachedImage of an image
+func getCachedImage(ctx context.Context, k8sClient client.Client, image string) (*kuikv1alpha1.CachedImage, error) {
+	cachedImage, err := controllers.CachedImageFromSourceImage(image)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := k8sClient.Get(ctx, types.NamespacedName{Name: cachedImage.Name}, cachedImage); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, errors.New("image is not cached")
+		}
+		return nil, err
+	}
+
+	return cachedImage, nil
+}
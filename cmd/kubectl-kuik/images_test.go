@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestPrintCachedImages(t *testing.T) {
+	g := NewWithT(t)
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	cachedImages := []kuikv1alpha1.CachedImage{
+		{
+			Spec: kuikv1alpha1.CachedImageSpec{SourceImage: "nginx:1.25"},
+			Status: kuikv1alpha1.CachedImageStatus{
+				IsCached:   true,
+				Size:       64 << 20,
+				LastUsedAt: &metav1.Time{Time: now.Add(-3 * time.Hour)},
+			},
+		},
+		{
+			Spec: kuikv1alpha1.CachedImageSpec{SourceImage: "alpine"},
+			Status: kuikv1alpha1.CachedImageStatus{
+				IsCached: true,
+				Size:     3 << 20,
+				UsedBy:   kuikv1alpha1.UsedBy{Count: 2},
+			},
+		},
+		{
+			Spec: kuikv1alpha1.CachedImageSpec{SourceImage: "private/app"},
+			Status: kuikv1alpha1.CachedImageStatus{
+				Conditions: []metav1.Condition{{Type: kuikv1alpha1.ConditionReady, Status: metav1.ConditionFalse, Reason: "Unauthorized"}},
+			},
+		},
+	}
+
+	sortCachedImages(cachedImages, "size")
+	g.Expect(cachedImages[0].Spec.SourceImage).To(Equal("nginx:1.25"))
+	sortCachedImages(cachedImages, "name")
+	g.Expect(cachedImages[0].Spec.SourceImage).To(Equal("alpine"))
+
+	var output bytes.Buffer
+	g.Expect(printCachedImages(&output, cachedImages, now)).To(Succeed())
+	g.Expect(output.String()).To(Equal(
+		"IMAGE         STATUS                  SIZE     LAST USED   PODS\n" +
+			"alpine        Cached                  3.0MiB   in use      2\n" +
+			"nginx:1.25    Cached                  64MiB    3h ago      0\n" +
+			"private/app   Failed (Unauthorized)   -        -           0\n"))
+}
+
+func TestCacheImage(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(
+		&kuikv1alpha1.CachedImage{
+			ObjectMeta: metav1.ObjectMeta{Name: "docker.io-library-nginx-1.25"},
+			Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: "nginx:1.25"},
+		},
+	).Build()
+
+	name, created, err := cacheImage(ctx, k8sClient, "nginx:1.25", now, false)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(created).To(BeFalse())
+
+	var cachedImage kuikv1alpha1.CachedImage
+	g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name}, &cachedImage)).To(Succeed())
+	g.Expect(cachedImage.Annotations).To(HaveKeyWithValue(kuikv1alpha1.RefreshRequestedAtAnnotationName, "2024-01-15T12:00:00Z"))
+	g.Expect(cachedImage.IsRefreshRequested()).To(BeTrue())
+	g.Expect(cachedImage.IsRecacheRequested()).To(BeFalse())
+
+	_, _, err = cacheImage(ctx, k8sClient, "nginx:1.25", now, true)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name}, &cachedImage)).To(Succeed())
+	g.Expect(cachedImage.IsRecacheRequested()).To(BeTrue())
+
+	name, created, err = cacheImage(ctx, k8sClient, "alpine", now, false)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(created).To(BeTrue())
+	g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name}, &cachedImage)).To(Succeed())
+	g.Expect(cachedImage.Spec.SourceImage).To(Equal("alpine"))
+}
+
+func TestEvictImage(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(
+		&kuikv1alpha1.CachedImage{
+			ObjectMeta: metav1.ObjectMeta{Name: "docker.io-library-nginx-1.25"},
+			Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: "nginx:1.25"},
+			Status:     kuikv1alpha1.CachedImageStatus{UsedBy: kuikv1alpha1.UsedBy{Count: 1}},
+		},
+	).Build()
+
+	_, err := evictImage(ctx, k8sClient, "nginx:1.25", false)
+	g.Expect(err).To(MatchError(ContainSubstring("used by 1 pods")))
+
+	name, err := evictImage(ctx, k8sClient, "nginx:1.25", true)
+	g.Expect(err).ToNot(HaveOccurred())
+	var cachedImage kuikv1alpha1.CachedImage
+	g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name}, &cachedImage)).ToNot(Succeed())
+
+	_, err = evictImage(ctx, k8sClient, "alpine", false)
+	g.Expect(err).To(MatchError("image is not cached"))
+}
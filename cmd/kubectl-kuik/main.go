@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const usage = `kubectl-kuik is a kubectl plugin to operate kube-image-keeper.
+
+Usage:
+  kubectl kuik <command> [arguments]
+
+Commands:
+  cache      Put images in cache, or pull them again from their upstream registry
+  evict      Evict images from the cache
+  list       List cached images with their size and last use
+  pin        Prevent images from expiring from the cache until a given time
+  pods       List the pods using images
+  progress   Follow the images being put in cache
+  restore    Restore the original images of pods rewritten by kube-image-keeper
+  simulate-retention
+             Simulate a retention policy and project the size of the cache
+  snapshot   Export a signed snapshot of the cached images and of the pods using them
+  unpin      Let pinned images expire as usual
+
+Use "kubectl kuik <command> -h" for more information about a command.
+`
+
+type command func(args []string) error
+
+var commands = map[string]command{
+	"cache":              cacheCommand,
+	"evict":              evictCommand,
+	"list":               listCommand,
+	"pin":                pinCommand,
+	"pods":               podsCommand,
+	"progress":           progressCommand,
+	"restore":            restoreCommand,
+	"simulate-retention": simulateRetentionCommand,
+	"snapshot":           snapshotCommand,
+	"unpin":              unpinCommand,
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" || os.Args[1] == "help" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err := run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// parseInterspersed parses flags that may appear before, between or after positional arguments and returns the
+// positional arguments
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// kubeClientConfig loads the kubeconfig the same way kubectl does
+func kubeClientConfig() clientcmd.ClientConfig {
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{},
+	)
+}
+
+// newClient returns a Kubernetes client and the namespace of the current context
+func newClient() (client.Client, string, error) {
+	clientConfig := kubeClientConfig()
+
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, "", err
+	}
+
+	namespace, _, err := clientConfig.Namespace()
+	if err != nil {
+		return nil, "", err
+	}
+
+	k8sClient, err := client.New(config, client.Options{
+		Scheme: scheme.NewScheme(),
+	})
+
+	return k8sClient, namespace, err
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/enix/kube-image-keeper/controllers"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const pinUsage = `Pin images so that they don't expire from the cache until the given time, for instance during a freeze period
+around a release. Once this time has passed, images expire as usual. Images that are not cached yet are put in cache.
+
+Usage:
+  kubectl kuik pin <image>... -until <time>
+
+Flags:
+`
+
+const unpinUsage = `Unpin images, letting them expire as usual.
+
+Usage:
+  kubectl kuik unpin <image>...
+`
+
+func pinCommand(args []string) error {
+	var until string
+
+	flags := flag.NewFlagSet("pin", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, pinUsage)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&until, "until", "", "Date (e.g. 2025-08-01, at midnight UTC) or time (RFC 3339, e.g. 2025-08-01T18:00:00+02:00) until which images are pinned.")
+
+	images, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(images) == 0 || until == "" {
+		flags.Usage()
+		return errors.New("images and -until must be given")
+	}
+
+	pinnedUntil, err := parseUntil(until)
+	if err != nil {
+		return err
+	}
+	if !pinnedUntil.After(time.Now()) {
+		return fmt.Errorf("%s is in the past", pinnedUntil.Format(time.RFC3339))
+	}
+
+	return pinImages(images, &metav1.Time{Time: pinnedUntil})
+}
+
+func unpinCommand(args []string) error {
+	flags := flag.NewFlagSet("unpin", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, unpinUsage)
+	}
+
+	images, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(images) == 0 {
+		flags.Usage()
+		return errors.New("images must be given")
+	}
+
+	return pinImages(images, nil)
+}
+
+// parseUntil parses a date, at midnight UTC, or an RFC 3339 time
+func parseUntil(until string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, until); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, until); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use a date (2025-08-01) or an RFC 3339 time (2025-08-01T18:00:00+02:00)", until)
+}
+
+// pinImages sets the pinnedUntil field of the CachedImages of the given images, creating them if needed, or removes it
+// when pinnedUntil is nil
+func pinImages(images []string, pinnedUntil *metav1.Time) error {
+	k8sClient, _, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	var errs []error
+	for _, image := range images {
+		name, err := pinImage(ctx, k8sClient, image, pinnedUntil)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", image, err))
+		} else if pinnedUntil != nil {
+			fmt.Printf("cachedimage/%s: pinned until %s\n", name, pinnedUntil.Format(time.RFC3339))
+		} else {
+			fmt.Printf("cachedimage/%s: unpinned\n", name)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func pinImage(ctx context.Context, k8sClient client.Client, image string, pinnedUntil *metav1.Time) (string, error) {
+	cachedImage, err := controllers.CachedImageFromSourceImage(image)
+	if err != nil {
+		return "", err
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{"pinnedUntil": pinnedUntil},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = k8sClient.Patch(ctx, cachedImage, client.RawPatch(types.MergePatchType, patch))
+	if apierrors.IsNotFound(err) && pinnedUntil != nil {
+		cachedImage.Spec.PinnedUntil = pinnedUntil
+		err = k8sClient.Create(ctx, cachedImage)
+	}
+
+	return cachedImage.Name, err
+}
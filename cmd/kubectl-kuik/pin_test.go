@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestParseUntil(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(parseUntil("2025-08-01")).To(Equal(time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC)))
+	g.Expect(parseUntil("2025-08-01T18:00:00Z")).To(Equal(time.Date(2025, 8, 1, 18, 0, 0, 0, time.UTC)))
+
+	_, err := parseUntil("01/08/2025")
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestPinImage(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(
+		&kuikv1alpha1.CachedImage{
+			ObjectMeta: metav1.ObjectMeta{Name: "docker.io-library-nginx-1.25"},
+			Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: "nginx:1.25"},
+		},
+	).Build()
+
+	pinnedUntil := metav1.NewTime(time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC))
+
+	name, err := pinImage(ctx, k8sClient, "nginx:1.25", &pinnedUntil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(name).To(Equal("docker.io-library-nginx-1.25"))
+
+	var cachedImage kuikv1alpha1.CachedImage
+	g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name}, &cachedImage)).To(Succeed())
+	g.Expect(cachedImage.Spec.PinnedUntil.Equal(&pinnedUntil)).To(BeTrue())
+	g.Expect(cachedImage.Spec.SourceImage).To(Equal("nginx:1.25"))
+
+	_, err = pinImage(ctx, k8sClient, "nginx:1.25", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name}, &cachedImage)).To(Succeed())
+	g.Expect(cachedImage.Spec.PinnedUntil).To(BeNil())
+
+	// Images that are not cached yet are put in cache
+	name, err = pinImage(ctx, k8sClient, "alpine", &pinnedUntil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name}, &cachedImage)).To(Succeed())
+	g.Expect(cachedImage.Spec.SourceImage).To(Equal("alpine"))
+	g.Expect(cachedImage.IsPinned(pinnedUntil.Add(-time.Hour))).To(BeTrue())
+	g.Expect(cachedImage.IsPinned(pinnedUntil.Time)).To(BeFalse())
+
+	_, err = pinImage(ctx, k8sClient, "busybox", nil)
+	g.Expect(err).To(HaveOccurred())
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/events"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const progressUsage = `Show the images being put in cache, then follow the images put in cache and the caching failures as they happen.
+
+Events are streamed by the admin API of the controllers holding the leader election lease, reached through the API
+server.
+
+Usage:
+  kubectl kuik progress [flags]
+
+Flags:
+`
+
+// leaderElectionID is the name of the Lease held by the controllers running the reconcilers
+const leaderElectionID = "a046788b.kuik.enix.io"
+
+func progressCommand(args []string) error {
+	var namespace string
+	var adminPort int
+
+	flags := flag.NewFlagSet("progress", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, progressUsage)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&namespace, "namespace", "kuik-system", "Namespace kube-image-keeper is installed in.")
+	flags.StringVar(&namespace, "n", "kuik-system", "Shorthand for -namespace.")
+	flags.IntVar(&adminPort, "admin-port", 8083, "Port of the admin API of the controllers.")
+
+	if _, err := parseInterspersed(flags, args); err != nil {
+		return err
+	}
+
+	config, err := kubeClientConfig().ClientConfig()
+	if err != nil {
+		return err
+	}
+	k8sClient, _, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var cachedImages kuikv1alpha1.CachedImageList
+	if err := k8sClient.List(ctx, &cachedImages); err != nil {
+		return err
+	}
+	for _, line := range cachingImages(cachedImages.Items) {
+		fmt.Println(line)
+	}
+
+	pod, err := leaderPod(ctx, k8sClient, namespace)
+	if err != nil {
+		return err
+	}
+	response, err := streamAdminAPI(config, pod, adminPort, "api/v1/events", map[string]string{"type": "cached,failed"})
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	return readEvents(response.Body, func(event events.Event) {
+		fmt.Println(formatEvent(event))
+	})
+}
+
+// cachingImages describes the images being put in cache
+func cachingImages(cachedImages []kuikv1alpha1.CachedImage) []string {
+	lines := []string{}
+	for _, cachedImage := range cachedImages {
+		if !meta.IsStatusConditionTrue(cachedImage.Status.Conditions, kuikv1alpha1.ConditionCaching) {
+			continue
+		}
+		line := "caching " + cachedImage.Spec.SourceImage
+		if progress := cachedImage.Status.Progress; progress != nil && len(progress.CompletedLayers) > 0 {
+			line += fmt.Sprintf(" (%d layers done)", len(progress.CompletedLayers))
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func formatEvent(event events.Event) string {
+	image := event.SourceImage
+	if image == "" {
+		image = event.CachedImage
+	}
+	line := fmt.Sprintf("%s %-6s %s", event.Time.Local().Format(time.TimeOnly), event.Type, image)
+	if event.Type == events.Failed && event.Message != "" {
+		line += ": " + event.Message
+	}
+	return line
+}
+
+// readEvents reads server-sent events with JSON data until the stream ends
+func readEvents(stream io.Reader, handle func(events.Event)) error {
+	scanner := bufio.NewScanner(stream)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		var event events.Event
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return fmt.Errorf("invalid event: %w", err)
+		}
+		handle(event)
+	}
+	return scanner.Err()
+}
+
+// leaderPod returns the pod of the controllers holding the leader election lease, which is the only one producing
+// events
+func leaderPod(ctx context.Context, k8sClient client.Client, namespace string) (*corev1.Pod, error) {
+	var lease coordinationv1.Lease
+	if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: leaderElectionID}, &lease); err != nil {
+		return nil, err
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == "" {
+		return nil, fmt.Errorf("no controllers pod holds the leader election lease in namespace %s", namespace)
+	}
+
+	// The identity of the holder is the name of its pod followed by a unique suffix
+	podName, _, _ := strings.Cut(*lease.Spec.HolderIdentity, "_")
+	var pod corev1.Pod
+	if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: podName}, &pod); err != nil {
+		return nil, err
+	}
+	return &pod, nil
+}
+
+// streamAdminAPI gets path from the admin API of a pod through the API server proxy and returns the response, whose
+// body is read by the caller as it is streamed
+func streamAdminAPI(config *rest.Config, pod *corev1.Pod, port int, path string, params map[string]string) (*http.Response, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := rest.HTTPClientFor(config)
+	if err != nil {
+		return nil, err
+	}
+
+	request := clientset.CoreV1().RESTClient().Get().
+		Namespace(pod.Namespace).
+		Resource("pods").
+		Name(pod.Name + ":" + strconv.Itoa(port)).
+		SubResource("proxy").
+		Suffix(path)
+	for key, value := range params {
+		request.Param(key, value)
+	}
+
+	response, err := httpClient.Get(request.URL().String())
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+		return nil, errors.New(string(body))
+	}
+
+	return response, nil
+}
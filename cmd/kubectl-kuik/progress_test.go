@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/enix/kube-image-keeper/internal/events"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReadEvents(t *testing.T) {
+	g := NewWithT(t)
+
+	stream := strings.NewReader(`: heartbeat
+
+event:cached
+data:{"type":"cached","time":"2024-01-15T08:03:00Z","cachedImage":"docker.io-library-nginx-1.25","sourceImage":"nginx:1.25"}
+
+event:failed
+data:{"type":"failed","time":"2024-01-15T08:04:12Z","cachedImage":"docker.io-library-alpine-latest","message":"unauthorized"}
+
+`)
+
+	received := []events.Event{}
+	g.Expect(readEvents(stream, func(event events.Event) {
+		received = append(received, event)
+	})).To(Succeed())
+
+	g.Expect(received).To(HaveLen(2))
+	g.Expect(received[0].Type).To(Equal(events.Cached))
+	g.Expect(received[0].SourceImage).To(Equal("nginx:1.25"))
+	g.Expect(formatEvent(received[1])).To(HaveSuffix("failed docker.io-library-alpine-latest: unauthorized"))
+}
+
+func TestLeaderPod(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	holder := "kuik-controllers-7c9f_0f5e1c2a-3b1d-4a44-9d0e-1f2a3b4c5d6e"
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(
+		&coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kuik-system", Name: leaderElectionID},
+			Spec:       coordinationv1.LeaseSpec{HolderIdentity: &holder},
+		},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "kuik-system", Name: "kuik-controllers-7c9f"}},
+	).Build()
+
+	pod, err := leaderPod(ctx, k8sClient, "kuik-system")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(pod.Name).To(Equal("kuik-controllers-7c9f"))
+
+	_, err = leaderPod(ctx, k8sClient, "default")
+	g.Expect(err).To(HaveOccurred())
+}
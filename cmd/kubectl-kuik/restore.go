@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/enix/kube-image-keeper/controllers"
+	"github.com/enix/kube-image-keeper/internal/restore"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const restoreUsage = `Restore the original images of pods rewritten by kube-image-keeper, as well as the pod templates of their
+owners (Deployments, StatefulSets, DaemonSets and ReplicaSets).
+
+Restored pods are annotated so that kube-image-keeper doesn't rewrite them again.
+
+Usage:
+  kubectl kuik restore pod <name> [flags]
+  kubectl kuik restore pod --all [flags]
+
+Flags:
+`
+
+func restoreCommand(args []string) error {
+	var namespace string
+	var allNamespaces, all, dryRun bool
+
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, restoreUsage)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&namespace, "namespace", "", "Namespace of the pods, defaults to the namespace of the current context.")
+	flags.StringVar(&namespace, "n", "", "Shorthand for -namespace.")
+	flags.BoolVar(&allNamespaces, "all-namespaces", false, "Restore pods of all namespaces, only with -all.")
+	flags.BoolVar(&allNamespaces, "A", false, "Shorthand for -all-namespaces.")
+	flags.BoolVar(&all, "all", false, "Restore every pod whose images have been rewritten.")
+	flags.BoolVar(&dryRun, "dry-run", false, "Only print what would be restored.")
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if len(positional) == 0 || (positional[0] != "pod" && positional[0] != "pods") {
+		flags.Usage()
+		return errors.New("only pods can be restored")
+	}
+	names := positional[1:]
+	if all == (len(names) > 0) {
+		flags.Usage()
+		return errors.New("either a pod name or -all must be given")
+	}
+	if allNamespaces && !all {
+		return errors.New("-all-namespaces can only be used with -all")
+	}
+
+	k8sClient, defaultNamespace, err := newClient()
+	if err != nil {
+		return err
+	}
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+
+	ctx := context.Background()
+	pods := []corev1.Pod{}
+
+	if all {
+		var podList corev1.PodList
+		opts := []client.ListOption{client.HasLabels{controllers.LabelManagedName}}
+		if !allNamespaces {
+			opts = append(opts, client.InNamespace(namespace))
+		}
+		if err := k8sClient.List(ctx, &podList, opts...); err != nil {
+			return err
+		}
+		pods = podList.Items
+	} else {
+		for _, name := range names {
+			var pod corev1.Pod
+			if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &pod); err != nil {
+				return err
+			}
+			pods = append(pods, pod)
+		}
+	}
+
+	suffix := ""
+	if dryRun {
+		suffix = " (dry run)"
+	}
+
+	var errs []error
+	for i := range pods {
+		pod := &pods[i]
+		result, err := restore.Pod(ctx, k8sClient, pod, dryRun)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s/pod/%s: %w", pod.Namespace, pod.Name, err))
+			continue
+		}
+
+		if len(result.Pod) == 0 {
+			fmt.Printf("%s/pod/%s: nothing to restore\n", pod.Namespace, pod.Name)
+		} else {
+			fmt.Printf("%s/pod/%s: restored containers %s%s\n", pod.Namespace, pod.Name, strings.Join(result.Pod, ", "), suffix)
+		}
+		if len(result.DroppedImagePullSecrets) > 0 {
+			fmt.Printf("%s/pod/%s: image pull secrets %s have been removed from the pod, recreate it to use them again\n", pod.Namespace, pod.Name, strings.Join(result.DroppedImagePullSecrets, ", "))
+		}
+		if len(result.OwnerContainers) > 0 {
+			fmt.Printf("%s/%s: restored containers %s%s\n", pod.Namespace, result.Owner, strings.Join(result.OwnerContainers, ", "), suffix)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"text/tabwriter"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/controllers"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const simulateRetentionUsage = `Simulate a retention policy against the current usage of cached images, listing the images it would remove from
+the cache and projecting the size of the cache, so that a policy change can be evaluated before being applied.
+
+Usage is assumed not to change during the simulation: images used by pods stay used and unused images are not pulled
+anymore. Nothing is removed from the cache.
+
+Usage:
+  kubectl kuik simulate-retention [flags]
+
+Flags:
+`
+
+func simulateRetentionCommand(args []string) error {
+	var policy controllers.RetentionPolicy
+	var immutableTags, maxSize, output string
+	var horizon, step time.Duration
+
+	flags := flag.NewFlagSet("simulate-retention", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, simulateRetentionUsage)
+		flags.PrintDefaults()
+	}
+	flags.DurationVar(&policy.ExpiryDelay, "expiry-delay", 30*24*time.Hour, "The delay before an unused image expires.")
+	flags.DurationVar(&policy.MutableTagsExpiryDelay, "mutable-tags-expiry-delay", 0, "The delay before an unused image whose tag is mutable expires (0 to use -expiry-delay).")
+	flags.DurationVar(&policy.ImmutableTagsExpiryDelay, "immutable-tags-expiry-delay", 0, "The delay before an unused image whose tag is immutable expires (0 to use -expiry-delay).")
+	flags.StringVar(&immutableTags, "immutable-tags", controllers.DefaultImmutableTags.String(), "Regex matching tags that are not expected to change upstream.")
+	flags.DurationVar(&policy.NodeImagesExpiryDelay, "node-images-expiry-delay", 0, "The delay before an unused image expires once it is missing from every node (0 to disable).")
+	flags.StringVar(&maxSize, "max-size", "", "Size of the cache above which the least recently used images are evicted, e.g. 100Gi.")
+	flags.DurationVar(&horizon, "horizon", 30*24*time.Hour, "How far in the future the policy is simulated.")
+	flags.DurationVar(&step, "step", 24*time.Hour, "Interval between points of the projected size of the cache.")
+	flags.StringVar(&output, "o", "table", "Output format, table or json.")
+
+	if _, err := parseInterspersed(flags, args); err != nil {
+		return err
+	}
+	if output != "table" && output != "json" {
+		return fmt.Errorf("invalid output format %q, use table or json", output)
+	}
+	if policy.ExpiryDelay <= 0 || step <= 0 || horizon < 0 {
+		return fmt.Errorf("-expiry-delay and -step must be positive, -horizon must not be negative")
+	}
+
+	var err error
+	if policy.ImmutableTags, err = regexp.Compile(immutableTags); err != nil {
+		return fmt.Errorf("invalid -immutable-tags: %w", err)
+	}
+	if maxSize != "" {
+		quantity, err := resource.ParseQuantity(maxSize)
+		if err != nil {
+			return fmt.Errorf("invalid -max-size %q: %w", maxSize, err)
+		}
+		policy.MaxSize = quantity.Value()
+	}
+
+	k8sClient, _, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	var cachedImages kuikv1alpha1.CachedImageList
+	if err := k8sClient.List(context.Background(), &cachedImages); err != nil {
+		return err
+	}
+
+	simulation := controllers.SimulateRetention(cachedImages.Items, policy, time.Now(), horizon, step)
+	if output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(simulation)
+	}
+	return printRetentionSimulation(os.Stdout, simulation)
+}
+
+// printRetentionSimulation prints the images removed by a simulated retention policy, then the projected size of the
+// cache
+func printRetentionSimulation(w io.Writer, simulation *controllers.RetentionSimulation) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(tw, "REMOVED AT\tIMAGE\tSIZE\tREASON")
+	for _, removal := range simulation.Removed {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", removal.At.Format(time.DateTime), removal.SourceImage, controllers.FormatBytes(removal.Size), removal.Reason)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(tw, "AT\tIMAGES\tSIZE")
+	for _, projection := range simulation.Storage {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", projection.At.Format(time.DateTime), projection.Images, controllers.FormatBytes(projection.Bytes))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/enix/kube-image-keeper/controllers"
+	. "github.com/onsi/gomega"
+)
+
+func TestPrintRetentionSimulation(t *testing.T) {
+	g := NewWithT(t)
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	simulation := &controllers.RetentionSimulation{
+		Removed: []controllers.SimulatedRemoval{
+			{SourceImage: "nginx:1.25", Size: 64 << 20, At: now, Reason: "Evicted"},
+			{SourceImage: "alpine", Size: 3 << 20, At: now.Add(36 * time.Hour), Reason: "Expired"},
+		},
+		Storage: []controllers.StorageProjection{
+			{At: now, Images: 2, Bytes: 67 << 20},
+			{At: now.Add(24 * time.Hour), Images: 1, Bytes: 3 << 20},
+			{At: now.Add(48 * time.Hour), Images: 0, Bytes: 0},
+		},
+	}
+
+	var output bytes.Buffer
+	g.Expect(printRetentionSimulation(&output, simulation)).To(Succeed())
+	g.Expect(output.String()).To(Equal(
+		"REMOVED AT            IMAGE        SIZE     REASON\n" +
+			"2024-01-15 12:00:00   nginx:1.25   64MiB    Evicted\n" +
+			"2024-01-17 00:00:00   alpine       3.0MiB   Expired\n" +
+			"\n" +
+			"AT                    IMAGES   SIZE\n" +
+			"2024-01-15 12:00:00   2        67MiB\n" +
+			"2024-01-16 12:00:00   1        3.0MiB\n" +
+			"2024-01-17 12:00:00   0        0B\n"))
+}
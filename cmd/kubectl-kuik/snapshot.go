@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/enix/kube-image-keeper/internal/snapshot"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const snapshotUsage = `Export a signed snapshot of every image stored in cache, with its digest and the pods using it, as an SPDX or a
+CycloneDX JSON document, e.g. for compliance audits.
+
+The snapshot is exported by the admin API of the controllers, reached through the API server, and is signed with the
+key given to the controllers. The signature is written next to the document, and the public key verifying it can be
+exported with -public-key.
+
+Usage:
+  kubectl kuik snapshot [flags]
+
+Flags:
+`
+
+// controllersSelector selects the pods of the controllers, serving the admin API
+var controllersSelector = client.MatchingLabels{"app.kubernetes.io/component": "controllers"}
+
+func snapshotCommand(args []string) error {
+	var namespace, format, output, signatureOutput string
+	var adminPort int
+	var publicKey bool
+
+	flags := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, snapshotUsage)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&namespace, "namespace", "kuik-system", "Namespace kube-image-keeper is installed in.")
+	flags.StringVar(&namespace, "n", "kuik-system", "Shorthand for -namespace.")
+	flags.IntVar(&adminPort, "admin-port", 8083, "Port of the admin API of the controllers.")
+	flags.StringVar(&format, "format", string(snapshot.SPDX), "Format of the document, spdx or cyclonedx.")
+	flags.StringVar(&output, "o", "", "File the document is written to, standard output by default.")
+	flags.StringVar(&signatureOutput, "signature", "", "File the raw signature is written to, <o>.sig by default. Without -o, the base64 encoded signature is printed on standard error.")
+	flags.BoolVar(&publicKey, "public-key", false, "Export the PEM encoded public key verifying signatures instead of a snapshot.")
+
+	if _, err := parseInterspersed(flags, args); err != nil {
+		return err
+	}
+	if _, err := snapshot.ParseFormat(format); err != nil {
+		return err
+	}
+
+	config, err := kubeClientConfig().ClientConfig()
+	if err != nil {
+		return err
+	}
+	k8sClient, _, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	pod, err := adminPod(ctx, k8sClient, namespace)
+	if err != nil {
+		return err
+	}
+
+	path, params := "api/v1/snapshot", map[string]string{"format": format}
+	if publicKey {
+		path, params = "api/v1/snapshot/public-key", nil
+	}
+	body, signature, err := getAdminAPI(config, pod, adminPort, path, params)
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		if _, err := os.Stdout.Write(body); err != nil {
+			return err
+		}
+		if signature != "" && signatureOutput == "" {
+			fmt.Fprintf(os.Stderr, "signature: %s\n", signature)
+			return nil
+		}
+	} else if err := os.WriteFile(output, body, 0644); err != nil {
+		return err
+	}
+	if signature == "" {
+		return nil
+	}
+
+	rawSignature, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	if signatureOutput == "" {
+		signatureOutput = output + ".sig"
+	}
+	return os.WriteFile(signatureOutput, rawSignature, 0644)
+}
+
+// adminPod returns a ready pod of the controllers
+func adminPod(ctx context.Context, k8sClient client.Client, namespace string) (*corev1.Pod, error) {
+	var pods corev1.PodList
+	if err := k8sClient.List(ctx, &pods, client.InNamespace(namespace), controllersSelector); err != nil {
+		return nil, err
+	}
+
+	for i := range pods.Items {
+		for _, condition := range pods.Items[i].Status.Conditions {
+			if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+				return &pods.Items[i], nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no ready controllers pod found in namespace %s", namespace)
+}
+
+// getAdminAPI gets path from the admin API of a pod through the API server proxy and returns the body of the response
+// along with its signature header
+func getAdminAPI(config *rest.Config, pod *corev1.Pod, port int, path string, params map[string]string) ([]byte, string, error) {
+	response, err := streamAdminAPI(config, pod, port, path, params)
+	if err != nil {
+		return nil, "", err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return body, response.Header.Get(snapshot.SignatureHeader), nil
+}
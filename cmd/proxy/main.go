@@ -1,32 +1,62 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"net"
 	"os"
+	"time"
 
 	_ "go.uber.org/automaxprocs"
 
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
 	"github.com/enix/kube-image-keeper/internal"
+	"github.com/enix/kube-image-keeper/internal/distro"
+	"github.com/enix/kube-image-keeper/internal/featuregate"
 	"github.com/enix/kube-image-keeper/internal/proxy"
+	"github.com/enix/kube-image-keeper/internal/pulltoken"
 	"github.com/enix/kube-image-keeper/internal/registry"
 	"github.com/enix/kube-image-keeper/internal/scheme"
+	"github.com/enix/kube-image-keeper/internal/tlsconfig"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/flowcontrol"
 	klog "k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 )
 
+// recordPortsRetryInterval is how often recording the ports of the proxy is tried again until it succeeds
+const recordPortsRetryInterval = 10 * time.Second
+
 var (
-	kubeconfig         string
-	proxyAddr          string
-	metricsAddr        string
-	rateLimitQPS       int
-	rateLimitBurst     int
-	insecureRegistries internal.ArrayFlags
-	rootCAPaths        internal.ArrayFlags
+	kubeconfig               string
+	proxyAddr                string
+	metricsAddr              string
+	rateLimitQPS             int
+	rateLimitBurst           int
+	insecureRegistries       internal.ArrayFlags
+	rootCAPaths              internal.ArrayFlags
+	fallbackPorts            string
+	portsConfigMap           string
+	tlsMinVersion            string
+	tlsCipherSuites          string
+	basicAuthAddr            string
+	basicAuthTLSCert         string
+	basicAuthTLSKey          string
+	htpasswdPath             string
+	pullTokenKeyPath         string
+	maxManifestSize          string
+	registryTLSDir           string
+	migrationConfigMap       string
+	upstreamDNSServers       internal.ArrayFlags
+	readReplicas             internal.ArrayFlags
+	upstreamAddressOverrides bool
 )
 
 func initFlags() {
@@ -41,8 +71,54 @@ func initFlags() {
 	flag.IntVar(&rateLimitBurst, "kube-api-rate-limit-burst", 0, "Kubernetes API request burst")
 	flag.Var(&insecureRegistries, "insecure-registries", "Insecure registries to allow to cache and proxify images from (this flag can be used multiple times).")
 	flag.Var(&rootCAPaths, "root-certificate-authorities", "Root certificate authorities to trust.")
+	flag.StringVar(&fallbackPorts, "fallback-ports", "", "Comma separated list of ports to listen on when the port of -bind-address is already in use.")
+	flag.StringVar(&tlsMinVersion, "tls-min-version", "", "Minimum TLS version of clients of upstream registries, e.g. VersionTLS12 (defaults to the Go default).")
+	flag.StringVar(&tlsCipherSuites, "tls-cipher-suites", "", "Comma separated list of TLS 1.0-1.2 cipher suites allowed by clients of upstream registries, using IANA names (defaults to the Go default).")
+	flag.BoolVar(&proxy.VerifyBlobs, "verify-blobs", proxy.VerifyBlobs, "Verify the digest of blobs served from the cache while streaming them, cutting corrupted blobs short and serving them from their origin registry from then on.")
+	flag.StringVar(&htpasswdPath, "htpasswd", "", "Path of an htpasswd file with bcrypt passwords, enabling an additional endpoint on -basic-auth-bind-address that requires clients to authenticate as one of its users.")
+	flag.StringVar(&pullTokenKeyPath, "pull-token-key", "", "Path of the key signing pull tokens issued by the controllers, enabling an additional endpoint on -basic-auth-bind-address that accepts them.")
+	flag.StringVar(&basicAuthAddr, "basic-auth-bind-address", ":8084", "The address the proxy registry endpoint requiring authentication binds to, only with -htpasswd or -pull-token-key.")
+	flag.StringVar(&basicAuthTLSCert, "basic-auth-tls-cert", "", "Path of the PEM encoded certificate serving -basic-auth-bind-address over TLS, required unless it is a loopback address.")
+	flag.StringVar(&basicAuthTLSKey, "basic-auth-tls-key", "", "Path of the PEM encoded key of -basic-auth-tls-cert.")
+	flag.StringVar(&portsConfigMap, "ports-configmap", "", "Name of the ConfigMap, in the namespace of the proxy, where ports the proxy listens on are recorded for the webhook.")
+	flag.StringVar(&maxManifestSize, "max-manifest-size", "4Mi", "Maximum size of manifests proxied from upstream registries (0 to disable).")
+	flag.IntVar(&registry.UpstreamLimits.MaxLayers, "max-layers", registry.UpstreamLimits.MaxLayers, "Maximum number of layers of manifests proxied from upstream registries (0 to disable).")
+	flag.IntVar(&registry.UpstreamLimits.MaxTagLength, "max-tag-length", registry.UpstreamLimits.MaxTagLength, "Maximum length of tags proxied from upstream registries (0 to disable).")
+	flag.BoolVar(&registry.Airgapped, "airgapped", registry.Airgapped, "Never contact upstream registries, for disconnected clusters: only images already in cache are served.")
+	flag.BoolVar(&proxy.StreamBlobs, "stream-blobs", proxy.StreamBlobs, "Push blobs served from their origin registry to the cache registry while streaming them, following redirects of origin registries instead of redirecting clients.")
+	flag.BoolVar(&proxy.VerifyAlwaysPulled, "verify-always-pulled", proxy.VerifyAlwaysPulled, "Only serve cached manifests of images pulled with imagePullPolicy Always if their digest matches the upstream one, checked with a HEAD request, serving the upstream manifest and requesting a refresh of the image otherwise.")
+	flag.BoolVar(&proxy.RefuseBlockedImages, "refuse-blocked-images", proxy.RefuseBlockedImages, "Refuse to serve the manifests of images blocked by their vulnerability scan, neither from the cache nor from their origin registry.")
+	flag.BoolVar(&proxy.CacheOnFirstPull, "cache-on-first-pull", proxy.CacheOnFirstPull, "Create the CachedImage of images pulled through the proxy that have none, serving them from their origin registry with anonymous credentials while the controllers cache them.")
+	flag.DurationVar(&proxy.ManifestHeadTTL, "manifest-head-cache-ttl", proxy.ManifestHeadTTL, "How long HEAD requests of manifests served from the cache are answered from memory (0 to disable).")
+	flag.DurationVar(&proxy.LookupTTL, "api-lookup-ttl", proxy.LookupTTL, "How long CachedImages and pull secrets looked up in the Kubernetes API are kept, the last known ones being used while the API is unreachable.")
+	flag.StringVar(&registry.PreviousEndpoint, "previous-registry-endpoint", "", "The address of the registry cached images are migrated from, images missing from -registry-endpoint are served from it until the migration is completed.")
+	flag.StringVar(&migrationConfigMap, "registry-migration-configmap", "", "Name of the ConfigMap, in the namespace of the proxy, where the controllers report the migration from -previous-registry-endpoint.")
+	flag.StringVar(&registryTLSDir, "registry-tls-dir", "", "Directory of the certificates issued by the controllers to connect to the registry with mutual TLS (ca.crt, client.crt and client.key), the registry is reached over plain HTTP if empty.")
+	flag.Var(&upstreamDNSServers, "upstream-dns-servers", "DNS server, as host[:port], resolving the names of upstream registries instead of the resolver of the system (this flag can be used multiple times).")
+	flag.BoolVar(&upstreamAddressOverrides, "upstream-address-overrides", false, "Reach upstream registries at the spec.upstreamAddress of their Repository, watching Repositories.")
+	flag.Var(&readReplicas, "registry-read-replicas", "Read replica of the registry, as endpoint or endpoint=zone, cached images are read from before -registry-endpoint, preferring the replicas in the zone of the node (this flag can be used multiple times).")
+	flag.Var(featuregate.Gates, "feature-gates", featuregate.Gates.Usage())
 
 	flag.Parse()
+
+	if len(upstreamDNSServers) > 0 {
+		registry.UpstreamResolver = registry.NewResolver(upstreamDNSServers)
+	}
+
+	if err := tlsconfig.SetMinVersion(tlsMinVersion); err != nil {
+		panic(err)
+	}
+	if err := tlsconfig.SetCipherSuites(tlsCipherSuites); err != nil {
+		panic(err)
+	}
+	if registryTLSDir != "" {
+		registry.ConfigureClientTLS(registryTLSDir)
+	}
+	size, err := registry.ParseSize(maxManifestSize)
+	if err != nil {
+		panic(err)
+	}
+	registry.UpstreamLimits.MaxManifestSize = size
 }
 
 func main() {
@@ -84,10 +160,138 @@ func main() {
 		panic(err)
 	}
 
+	// CachedImages are read on every manifest request, they are served from the cache of an informer
+	informers, err := cache.New(config, cache.Options{Scheme: scheme.NewScheme(), Mapper: restMapper})
+	if err != nil {
+		panic(err)
+	}
+	if _, err := informers.GetInformer(context.Background(), &kuikv1alpha1.CachedImage{}); err != nil {
+		panic(err)
+	}
+	if upstreamAddressOverrides {
+		if err := proxy.WatchUpstreamHosts(context.Background(), informers); err != nil {
+			panic(err)
+		}
+	}
+	go func() {
+		if err := informers.Start(context.Background()); err != nil {
+			panic(err)
+		}
+	}()
+
 	rootCAs, err := registry.LoadRootCAPoolFromFiles(rootCAPaths)
 	if err != nil {
 		panic(fmt.Errorf("could not load root certificate authorities: %s", err))
 	}
 
-	<-proxy.New(k8sClient, metricsAddr, []string(insecureRegistries), rootCAs).Run(proxyAddr)
+	if len(readReplicas) > 0 {
+		replicas, err := registry.ParseReadReplicas(readReplicas)
+		if err != nil {
+			panic(err)
+		}
+		zone := nodeZone(k8sClient, os.Getenv("NODE_NAME"))
+		klog.Infof("reading cached images from %d read replicas of the registry, preferring those in zone %q", len(replicas), zone)
+		registry.ReadReplicas = registry.NewReplicaRouter(replicas, zone)
+	}
+
+	if registry.PreviousEndpoint != "" && migrationConfigMap != "" {
+		go proxy.WatchMigration(context.Background(), k8sClient, os.Getenv("POD_NAMESPACE"), migrationConfigMap)
+	}
+
+	p := proxy.New(k8sClient, metricsAddr, []string(insecureRegistries), rootCAs).WithNodeName(os.Getenv("NODE_NAME")).WithCache(informers)
+	if htpasswdPath != "" || pullTokenKeyPath != "" {
+		var htpasswd *proxy.Htpasswd
+		if htpasswdPath != "" {
+			if htpasswd, err = proxy.LoadHtpasswd(htpasswdPath); err != nil {
+				panic(fmt.Errorf("could not load htpasswd file: %s", err))
+			}
+		}
+		var pullTokens *pulltoken.Signer
+		if pullTokenKeyPath != "" {
+			if pullTokens, err = pulltoken.LoadSigner(pullTokenKeyPath); err != nil {
+				panic(fmt.Errorf("could not load pull token key: %s", err))
+			}
+		}
+		if _, err := p.WithBasicAuth(basicAuthAddr, htpasswd, pullTokens, basicAuthTLSCert, basicAuthTLSKey); err != nil {
+			panic(err)
+		}
+	}
+	if fallbackPorts == "" && portsConfigMap == "" {
+		<-p.Run(proxyAddr)
+		return
+	}
+
+	host, port, err := net.SplitHostPort(proxyAddr)
+	if err != nil {
+		panic(err)
+	}
+	ports, err := proxy.ParsePorts(port + "," + fallbackPorts)
+	if err != nil {
+		panic(err)
+	}
+	if nodeName := os.Getenv("NODE_NAME"); nodeName != "" {
+		ports = excludeReservedPorts(k8sClient, nodeName, ports)
+	}
+
+	listeners, boundPorts, err := proxy.Listen(host, ports)
+	if err != nil {
+		panic(err)
+	}
+	klog.Infof("listening on ports %v", boundPorts)
+
+	if portsConfigMap != "" {
+		// The API server may not be reachable yet, retry in background so that the proxy serves in the meantime
+		go func() {
+			namespace, nodeName := os.Getenv("POD_NAMESPACE"), os.Getenv("NODE_NAME")
+			_ = wait.PollImmediateInfinite(recordPortsRetryInterval, func() (bool, error) {
+				if err := proxy.RecordPorts(context.Background(), k8sClient, namespace, portsConfigMap, nodeName, boundPorts); err != nil {
+					klog.Errorf("could not record ports in ConfigMap %s/%s, retrying in %s: %s", namespace, portsConfigMap, recordPortsRetryInterval, err)
+					return false, nil
+				}
+				return true, nil
+			})
+		}()
+	}
+
+	<-p.RunListeners(listeners)
+}
+
+// nodeZone returns the zone of the node of the proxy, or an empty string if it can't be found
+func nodeZone(k8sClient client.Client, nodeName string) string {
+	if nodeName == "" {
+		return ""
+	}
+	node := &corev1.Node{}
+	if err := k8sClient.Get(context.Background(), types.NamespacedName{Name: nodeName}, node); err != nil {
+		klog.Errorf("could not get node %s to find its zone: %s", nodeName, err)
+		return ""
+	}
+	return node.Labels[corev1.LabelTopologyZone]
+}
+
+// excludeReservedPorts removes from ports those that are reserved on the node of the proxy without being bound, which
+// depends on the distribution of the node. Ports are kept as is if the node can't be inspected.
+func excludeReservedPorts(k8sClient client.Client, nodeName string, ports []int) []int {
+	node := &corev1.Node{}
+	if err := k8sClient.Get(context.Background(), types.NamespacedName{Name: nodeName}, node); err != nil {
+		klog.Errorf("could not get node %s to detect its distribution: %s", nodeName, err)
+		return ports
+	}
+
+	distribution := distro.Detect(node)
+	klog.Infof("node %s runs %s", nodeName, distribution)
+	if template := distribution.ContainerdConfigTemplate(); template != "" {
+		klog.Infof("containerd configuration of %s is generated from %s", distribution, template)
+	}
+
+	reserved, err := distro.ReservedHostPorts(context.Background(), k8sClient, node)
+	if err != nil {
+		klog.Errorf("could not list host ports reserved on node %s: %s", nodeName, err)
+		return ports
+	}
+	kept := proxy.ExcludePorts(ports, reserved)
+	if len(kept) < len(ports) {
+		klog.Warningf("ports %v are reserved by %s on node %s, falling back to ports %v", reserved, distribution, nodeName, kept)
+	}
+	return kept
 }
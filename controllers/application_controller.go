@@ -0,0 +1,248 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+)
+
+const typeReadyApplication = "Ready"
+
+// ApplicationReconciler reconciles an Application object
+type ApplicationReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=kuik.enix.io,resources=applications,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=kuik.enix.io,resources=applications/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=kuik.enix.io,resources=cachedimages,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=list;watch
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// Reconcile applies the group-level operations requested on an Application to its CachedImages and reports how many
+// of them are cached
+func (r *ApplicationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var application kuikv1alpha1.Application
+	if err := r.Get(ctx, req.NamespacedName, &application); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	log.Info("reconciling application")
+
+	// Put listed images in cache
+	for _, sourceImage := range application.Spec.Images {
+		cachedImage, err := CachedImageFromSourceImage(sourceImage)
+		if err != nil {
+			r.Recorder.Eventf(&application, "Warning", "InvalidImage", "Image %s is invalid: %s", sourceImage, err)
+			continue
+		}
+		if err := r.Create(ctx, cachedImage); err == nil {
+			log.Info("caching image", "sourceImage", sourceImage)
+		} else if !apierrors.IsAlreadyExists(err) {
+			return ctrl.Result{}, err
+		}
+	}
+
+	cachedImages, err := r.applicationCachedImages(ctx, &application)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	now := time.Now()
+	expired := 0
+	application.Status.Images = len(cachedImages)
+	application.Status.CachedImages = 0
+
+	for i := range cachedImages {
+		cachedImage := &cachedImages[i]
+		if cachedImage.Status.IsCached {
+			application.Status.CachedImages++
+		}
+
+		if expireRequestedAt := application.Spec.ExpireRequestedAt; expireRequestedAt != nil && cachedImage.CreationTimestamp.Before(expireRequestedAt) &&
+			cachedImage.Status.UsedBy.Count == 0 && !cachedImage.Spec.Retain && !cachedImage.IsPinned(now) {
+			// UsedBy may not have been updated yet for pods that have just been created
+			used, err := r.isUsed(ctx, cachedImage)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			if used {
+				continue
+			}
+			log.Info("expiring cachedimage", "cachedImage", cachedImage.Name)
+			if err := r.Delete(ctx, cachedImage); err != nil && !apierrors.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
+			expired++
+			continue
+		}
+
+		if patch := applicationPatch(&application, cachedImage, now); patch != nil {
+			data, err := json.Marshal(patch)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			log.Info("patching cachedimage", "cachedImage", cachedImage.Name, "patch", string(data))
+			if err := r.Patch(ctx, cachedImage, client.RawPatch(types.MergePatchType, data)); err != nil && !apierrors.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
+		}
+	}
+
+	if expired > 0 {
+		r.Recorder.Eventf(&application, "Normal", "Expired", "%d images removed from cache", expired)
+	}
+
+	condition := metav1.Condition{
+		Type:    typeReadyApplication,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Cached",
+		Message: "Every image of the application is cached",
+	}
+	application.Status.Phase = "Ready"
+	if application.Status.CachedImages < application.Status.Images {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Caching"
+		condition.Message = fmt.Sprintf("%d/%d images of the application are cached", application.Status.CachedImages, application.Status.Images)
+		application.Status.Phase = "Caching"
+	}
+	meta.SetStatusCondition(&application.Status.Conditions, condition)
+
+	if err := r.Status().Update(ctx, &application); err != nil {
+		if apierrors.IsConflict(err) {
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// isUsed tells whether a CachedImage is used by pods that are not being deleted
+func (r *ApplicationReconciler) isUsed(ctx context.Context, cachedImage *kuikv1alpha1.CachedImage) (bool, error) {
+	var podList corev1.PodList
+	if err := r.List(ctx, &podList, client.MatchingFields{cachedImageOwnerKey: cachedImage.Name}); err != nil {
+		return false, err
+	}
+	for _, pod := range podList.Items {
+		if pod.DeletionTimestamp.IsZero() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// applicationCachedImages returns the CachedImages matching the selector of an Application or listed in its images
+func (r *ApplicationReconciler) applicationCachedImages(ctx context.Context, application *kuikv1alpha1.Application) ([]kuikv1alpha1.CachedImage, error) {
+	var cachedImageList kuikv1alpha1.CachedImageList
+	if err := r.List(ctx, &cachedImageList); err != nil {
+		return nil, err
+	}
+
+	cachedImages := []kuikv1alpha1.CachedImage{}
+	for _, cachedImage := range cachedImageList.Items {
+		if ok, err := applicationIncludes(application, &cachedImage); err != nil {
+			return nil, err
+		} else if ok {
+			cachedImages = append(cachedImages, cachedImage)
+		}
+	}
+
+	return cachedImages, nil
+}
+
+// applicationIncludes tells whether a CachedImage belongs to an Application
+func applicationIncludes(application *kuikv1alpha1.Application, cachedImage *kuikv1alpha1.CachedImage) (bool, error) {
+	for _, sourceImage := range application.Spec.Images {
+		if listed, err := CachedImageFromSourceImage(sourceImage); err == nil && listed.Name == cachedImage.Name {
+			return true, nil
+		}
+	}
+
+	if application.Spec.Selector == nil {
+		return false, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(application.Spec.Selector)
+	if err != nil {
+		return false, err
+	}
+
+	return !selector.Empty() && selector.Matches(labels.Set(cachedImage.Labels)), nil
+}
+
+// applicationPatch returns a merge patch pinning and requesting the refresh of a CachedImage as requested by an
+// Application, or nil if the CachedImage is up to date
+func applicationPatch(application *kuikv1alpha1.Application, cachedImage *kuikv1alpha1.CachedImage, now time.Time) map[string]interface{} {
+	patch := map[string]interface{}{}
+
+	// Pin images until the application is pinned, without shortening longer pins
+	if pinnedUntil := application.Spec.PinnedUntil; pinnedUntil != nil && now.Before(pinnedUntil.Time) &&
+		(cachedImage.Spec.PinnedUntil == nil || cachedImage.Spec.PinnedUntil.Before(pinnedUntil)) {
+		patch["spec"] = map[string]interface{}{"pinnedUntil": pinnedUntil}
+	}
+
+	if prefetchRequestedAt := application.Spec.PrefetchRequestedAt; prefetchRequestedAt != nil {
+		requestedAt := prefetchRequestedAt.UTC().Format(time.RFC3339)
+		if cachedImage.Annotations[kuikv1alpha1.RefreshRequestedAtAnnotationName] != requestedAt &&
+			(cachedImage.Status.RefreshedAt == nil || cachedImage.Status.RefreshedAt.Before(prefetchRequestedAt)) {
+			patch["metadata"] = map[string]interface{}{
+				"annotations": map[string]string{kuikv1alpha1.RefreshRequestedAtAnnotationName: requestedAt},
+			}
+		}
+	}
+
+	if len(patch) == 0 {
+		return nil
+	}
+	return patch
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ApplicationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&kuikv1alpha1.Application{}).
+		Watches(
+			&source.Kind{Type: &kuikv1alpha1.CachedImage{}},
+			handler.EnqueueRequestsFromMapFunc(r.applicationsFromCachedImage),
+		).
+		Complete(r)
+}
+
+func (r *ApplicationReconciler) applicationsFromCachedImage(obj client.Object) []ctrl.Request {
+	cachedImage := obj.(*kuikv1alpha1.CachedImage)
+
+	var applicationList kuikv1alpha1.ApplicationList
+	if err := r.List(context.Background(), &applicationList); err != nil {
+		return nil
+	}
+
+	requests := []ctrl.Request{}
+	for _, application := range applicationList.Items {
+		if ok, _ := applicationIncludes(&application, cachedImage); ok {
+			requests = append(requests, ctrl.Request{NamespacedName: types.NamespacedName{Name: application.Name}})
+		}
+	}
+
+	return requests
+}
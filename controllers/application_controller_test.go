@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestApplicationIncludes(t *testing.T) {
+	application := &kuikv1alpha1.Application{
+		Spec: kuikv1alpha1.ApplicationSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "shop"}},
+			Images:   []string{"nginx:1.25"},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		application *kuikv1alpha1.Application
+		cachedImage *kuikv1alpha1.CachedImage
+		expected    bool
+	}{
+		{
+			name:        "Matching selector",
+			application: application,
+			cachedImage: &kuikv1alpha1.CachedImage{ObjectMeta: metav1.ObjectMeta{Name: "docker.io-library-redis-7", Labels: map[string]string{"app": "shop"}}},
+			expected:    true,
+		},
+		{
+			name:        "Listed image",
+			application: application,
+			cachedImage: &kuikv1alpha1.CachedImage{ObjectMeta: metav1.ObjectMeta{Name: "docker.io-library-nginx-1.25"}},
+			expected:    true,
+		},
+		{
+			name:        "Other image",
+			application: application,
+			cachedImage: &kuikv1alpha1.CachedImage{ObjectMeta: metav1.ObjectMeta{Name: "docker.io-library-redis-7", Labels: map[string]string{"app": "blog"}}},
+			expected:    false,
+		},
+		{
+			name:        "Empty selector",
+			application: &kuikv1alpha1.Application{Spec: kuikv1alpha1.ApplicationSpec{Selector: &metav1.LabelSelector{}}},
+			cachedImage: &kuikv1alpha1.CachedImage{ObjectMeta: metav1.ObjectMeta{Name: "docker.io-library-redis-7"}},
+			expected:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(applicationIncludes(tt.application, tt.cachedImage)).To(Equal(tt.expected))
+		})
+	}
+}
+
+func TestApplicationPatch(t *testing.T) {
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	later := metav1.NewTime(now.Add(24 * time.Hour))
+	muchLater := metav1.NewTime(now.Add(48 * time.Hour))
+	before := metav1.NewTime(now.Add(-time.Hour))
+
+	tests := []struct {
+		name        string
+		spec        kuikv1alpha1.ApplicationSpec
+		cachedImage kuikv1alpha1.CachedImage
+		expected    map[string]interface{}
+	}{
+		{
+			name:     "Nothing requested",
+			expected: nil,
+		},
+		{
+			name: "Pin",
+			spec: kuikv1alpha1.ApplicationSpec{PinnedUntil: &later},
+			expected: map[string]interface{}{
+				"spec": map[string]interface{}{"pinnedUntil": &later},
+			},
+		},
+		{
+			name:        "Already pinned for longer",
+			spec:        kuikv1alpha1.ApplicationSpec{PinnedUntil: &later},
+			cachedImage: kuikv1alpha1.CachedImage{Spec: kuikv1alpha1.CachedImageSpec{PinnedUntil: &muchLater}},
+			expected:    nil,
+		},
+		{
+			name:     "Expired pin",
+			spec:     kuikv1alpha1.ApplicationSpec{PinnedUntil: &before},
+			expected: nil,
+		},
+		{
+			name: "Prefetch",
+			spec: kuikv1alpha1.ApplicationSpec{PrefetchRequestedAt: &before},
+			expected: map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{kuikv1alpha1.RefreshRequestedAtAnnotationName: "2024-01-01T07:00:00Z"},
+				},
+			},
+		},
+		{
+			name:        "Refreshed since prefetch request",
+			spec:        kuikv1alpha1.ApplicationSpec{PrefetchRequestedAt: &before},
+			cachedImage: kuikv1alpha1.CachedImage{Status: kuikv1alpha1.CachedImageStatus{RefreshedAt: &later}},
+			expected:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			application := &kuikv1alpha1.Application{Spec: tt.spec}
+			g.Expect(applicationPatch(application, &tt.cachedImage, now)).To(Equal(tt.expected))
+		})
+	}
+}
+
+func TestApplicationReconcile(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	now := metav1.Now()
+	pinnedUntil := metav1.NewTime(now.Add(24 * time.Hour))
+	labels := map[string]string{"app": "shop"}
+
+	application := &kuikv1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop"},
+		Spec: kuikv1alpha1.ApplicationSpec{
+			Selector:    &metav1.LabelSelector{MatchLabels: labels},
+			Images:      []string{"nginx:1.25"},
+			PinnedUntil: &pinnedUntil,
+		},
+	}
+	cached := &kuikv1alpha1.CachedImage{
+		ObjectMeta: metav1.ObjectMeta{Name: "docker.io-library-redis-7", Labels: labels},
+		Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: "redis:7"},
+		Status:     kuikv1alpha1.CachedImageStatus{IsCached: true},
+	}
+	other := &kuikv1alpha1.CachedImage{
+		ObjectMeta: metav1.ObjectMeta{Name: "docker.io-library-postgres-16"},
+		Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: "postgres:16"},
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "postgres",
+			Namespace:   "default",
+			Annotations: map[string]string{registry.ContainerAnnotationKey("postgres", false): "postgres:16"},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "postgres", Image: "localhost:7439/postgres:16"}}},
+	}
+
+	reconciler := &ApplicationReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(application, cached, other, pod).
+			WithIndex(&corev1.Pod{}, cachedImageOwnerKey, func(obj client.Object) []string {
+				names := []string{}
+				for _, cachedImage := range DesiredCachedImages(ctx, obj.(*corev1.Pod)) {
+					names = append(names, cachedImage.Name)
+				}
+				return names
+			}).Build(),
+		Recorder: record.NewFakeRecorder(10),
+	}
+	request := ctrl.Request{NamespacedName: types.NamespacedName{Name: "shop"}}
+	_, err := reconciler.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Listed images are put in cache and every image of the application is pinned
+	var nginx kuikv1alpha1.CachedImage
+	g.Expect(reconciler.Get(ctx, types.NamespacedName{Name: "docker.io-library-nginx-1.25"}, &nginx)).To(Succeed())
+	g.Expect(nginx.Spec.PinnedUntil).ToNot(BeNil())
+	g.Expect(reconciler.Get(ctx, types.NamespacedName{Name: cached.Name}, cached)).To(Succeed())
+	g.Expect(cached.Spec.PinnedUntil).ToNot(BeNil())
+	g.Expect(reconciler.Get(ctx, types.NamespacedName{Name: other.Name}, other)).To(Succeed())
+	g.Expect(other.Spec.PinnedUntil).To(BeNil())
+
+	g.Expect(reconciler.Get(ctx, request.NamespacedName, application)).To(Succeed())
+	g.Expect(application.Status.Images).To(Equal(2))
+	g.Expect(application.Status.CachedImages).To(Equal(1))
+	g.Expect(application.Status.Phase).To(Equal("Caching"))
+	g.Expect(meta.IsStatusConditionFalse(application.Status.Conditions, typeReadyApplication)).To(BeTrue())
+
+	// Expiring removes unused images that are neither retained nor pinned, even if UsedBy hasn't been updated yet for
+	// the pods using them
+	expireRequestedAt := metav1.NewTime(now.Add(time.Hour))
+	application.Spec.PinnedUntil = nil
+	application.Spec.ExpireRequestedAt = &expireRequestedAt
+	g.Expect(reconciler.Update(ctx, application)).To(Succeed())
+	cached.Spec.PinnedUntil = nil
+	g.Expect(reconciler.Update(ctx, cached)).To(Succeed())
+	other.Labels = labels
+	g.Expect(reconciler.Update(ctx, other)).To(Succeed())
+
+	_, err = reconciler.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	err = reconciler.Get(ctx, types.NamespacedName{Name: cached.Name}, cached)
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	g.Expect(reconciler.Get(ctx, types.NamespacedName{Name: other.Name}, other)).To(Succeed())
+	g.Expect(reconciler.Get(ctx, types.NamespacedName{Name: "docker.io-library-nginx-1.25"}, &nginx)).To(Succeed())
+}
@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/registry"
+)
+
+// failureReasons are the reasons of the Ready condition of CachedImages that could not be cached, by failure class
+var failureReasons = map[registry.FailureClass]string{
+	registry.FailureAuth:          kuikv1alpha1.ReasonUnauthorized,
+	registry.FailureNotFound:      kuikv1alpha1.ReasonImageNotFound,
+	registry.FailureRateLimit:     kuikv1alpha1.ReasonRateLimited,
+	registry.FailureNetwork:       kuikv1alpha1.ReasonUpstreamUnreachable,
+	registry.FailureStorageFull:   kuikv1alpha1.ReasonStorageFull,
+	registry.FailureLimitExceeded: kuikv1alpha1.ReasonLimitExceeded,
+	registry.FailurePolicy:        kuikv1alpha1.ReasonPolicyViolation,
+	registry.FailureSignature:     kuikv1alpha1.ReasonSignatureInvalid,
+}
+
+// failureRetryDelays are how long to wait before caching an image again after a failure of a given class. Missing
+// images, images exceeding limits, violating policies or whose signature is invalid and rate limits won't go away within the exponential backoff of
+// the controller, which would only make things worse by hammering upstream registries. Failures of other classes are
+// retried with this backoff.
+var failureRetryDelays = map[registry.FailureClass]time.Duration{
+	registry.FailureAuth:          10 * time.Minute,
+	registry.FailureNotFound:      time.Hour,
+	registry.FailureRateLimit:     30 * time.Minute,
+	registry.FailureStorageFull:   5 * time.Minute,
+	registry.FailureLimitExceeded: time.Hour,
+	registry.FailurePolicy:        time.Hour,
+	registry.FailureSignature:     time.Hour,
+}
+
+func failureReason(class registry.FailureClass) string {
+	if reason, ok := failureReasons[class]; ok {
+		return reason
+	}
+	return kuikv1alpha1.ReasonCacheFailed
+}
+
+// retryAfterFailure returns the result of a reconcile that failed to cache or refresh an image, according to the retry
+// policy of the class of the failure
+func retryAfterFailure(class registry.FailureClass, err error) (ctrl.Result, error) {
+	if delay, ok := failureRetryDelays[class]; ok {
+		return ctrl.Result{RequeueAfter: delay}, nil
+	}
+	return ctrl.Result{}, err
+}
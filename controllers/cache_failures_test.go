@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"errors"
+	"testing"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/registry"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSetCachingFailedConditions(t *testing.T) {
+	tests := []struct {
+		name                string
+		class               registry.FailureClass
+		cachingReason       string
+		upstreamUnreachable metav1.ConditionStatus
+	}{
+		{
+			name:                "Network failure",
+			class:               registry.FailureNetwork,
+			cachingReason:       kuikv1alpha1.ReasonUpstreamUnreachable,
+			upstreamUnreachable: metav1.ConditionTrue,
+		},
+		{
+			name:                "Image not found",
+			class:               registry.FailureNotFound,
+			cachingReason:       kuikv1alpha1.ReasonImageNotFound,
+			upstreamUnreachable: metav1.ConditionFalse,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			cachedImage := &kuikv1alpha1.CachedImage{}
+
+			setCachingFailedConditions(cachedImage, tt.class, errors.New("failure"))
+
+			caching := meta.FindStatusCondition(cachedImage.Status.Conditions, kuikv1alpha1.ConditionCaching)
+			g.Expect(caching).ToNot(BeNil())
+			g.Expect(caching.Status).To(Equal(metav1.ConditionFalse))
+			g.Expect(caching.Reason).To(Equal(tt.cachingReason))
+			g.Expect(caching.Message).To(Equal("failure"))
+
+			upstreamUnreachable := meta.FindStatusCondition(cachedImage.Status.Conditions, kuikv1alpha1.ConditionUpstreamUnreachable)
+			g.Expect(upstreamUnreachable).ToNot(BeNil())
+			g.Expect(upstreamUnreachable.Status).To(Equal(tt.upstreamUnreachable))
+
+			setCachedConditions(cachedImage)
+			g.Expect(meta.IsStatusConditionFalse(cachedImage.Status.Conditions, kuikv1alpha1.ConditionCaching)).To(BeTrue())
+			g.Expect(meta.IsStatusConditionFalse(cachedImage.Status.Conditions, kuikv1alpha1.ConditionUpstreamUnreachable)).To(BeTrue())
+			g.Expect(meta.FindStatusCondition(cachedImage.Status.Conditions, kuikv1alpha1.ConditionCaching).Reason).To(Equal("Cached"))
+		})
+	}
+}
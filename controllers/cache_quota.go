@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// CacheQuota evicts the least recently used CachedImages once the size of the cache exceeds MaxSize. The size of the
+// cache is the sum of the sizes of cached images, layers shared by several images being counted for each of them.
+type CacheQuota struct {
+	client.Client
+	Recorder record.EventRecorder
+	// MaxSize is the size in bytes above which images are evicted
+	MaxSize int64
+	// Interval is how often the size of the cache is checked
+	Interval time.Duration
+	// UpgradeDetector pauses evictions during cluster upgrades, they are never paused if nil
+	UpgradeDetector *UpgradeDetector
+
+	now func() time.Time
+}
+
+// NewCacheQuota returns a CacheQuota, or nil if maxSize or interval is not positive
+func NewCacheQuota(k8sClient client.Client, recorder record.EventRecorder, maxSize int64, interval time.Duration) *CacheQuota {
+	if maxSize <= 0 || interval <= 0 {
+		return nil
+	}
+
+	return &CacheQuota{
+		Client:   k8sClient,
+		Recorder: recorder,
+		MaxSize:  maxSize,
+		Interval: interval,
+		now:      time.Now,
+	}
+}
+
+// Start implements manager.Runnable
+func (q *CacheQuota) Start(ctx context.Context) error {
+	log := log.FromContext(ctx).WithName("cache-quota")
+
+	ticker := time.NewTicker(q.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := q.enforce(ctx); err != nil {
+			log.Error(err, "could not enforce cache quota")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable
+func (q *CacheQuota) NeedLeaderElection() bool {
+	return true
+}
+
+// enforce deletes the least recently used evictable CachedImages until the size of the cache is below MaxSize
+func (q *CacheQuota) enforce(ctx context.Context) error {
+	log := log.FromContext(ctx).WithName("cache-quota")
+
+	var cachedImages kuikv1alpha1.CachedImageList
+	if err := q.List(ctx, &cachedImages); err != nil {
+		return err
+	}
+
+	size := int64(0)
+	evictable := []*kuikv1alpha1.CachedImage{}
+	now := q.now()
+	for i := range cachedImages.Items {
+		cachedImage := &cachedImages.Items[i]
+		if !cachedImage.Status.IsCached || !cachedImage.DeletionTimestamp.IsZero() {
+			continue
+		}
+		size += cachedImage.Status.Size
+		if isEvictable(cachedImage, now) {
+			evictable = append(evictable, cachedImage)
+		}
+	}
+	cacheSizeBytes.Set(float64(size))
+
+	if size <= q.MaxSize {
+		return nil
+	}
+
+	if upgrading, err := q.UpgradeDetector.InProgress(ctx); err != nil {
+		return err
+	} else if upgrading {
+		log.Info("cache quota exceeded during a cluster upgrade, delaying evictions", "size", size, "maxSize", q.MaxSize)
+		return nil
+	}
+
+	sort.SliceStable(evictable, func(i, j int) bool {
+		return lastUsedAt(evictable[i]).Before(lastUsedAt(evictable[j]))
+	})
+
+	for _, cachedImage := range evictable {
+		if size <= q.MaxSize {
+			break
+		}
+
+		log.Info("cache quota exceeded, evicting least recently used image", "cachedImage", klog.KObj(cachedImage), "size", size, "maxSize", q.MaxSize, "lastUsedAt", lastUsedAt(cachedImage))
+		if err := q.Delete(ctx, cachedImage); client.IgnoreNotFound(err) != nil {
+			q.Recorder.Eventf(cachedImage, "Warning", "EvictionFailed", "Image %s could not be evicted: %s", cachedImage.Spec.SourceImage, err)
+			return err
+		}
+		q.Recorder.Eventf(cachedImage, "Normal", "Evicted", "Image %s has been evicted since the cache exceeds its quota of %s", cachedImage.Spec.SourceImage, resource.NewQuantity(q.MaxSize, resource.BinarySI))
+		imageEvicted.Inc()
+		size -= cachedImage.Status.Size
+	}
+
+	if size > q.MaxSize {
+		log.Info("cache quota still exceeded, remaining images are in use, retained or pinned", "size", size, "maxSize", q.MaxSize)
+	}
+
+	return nil
+}
+
+// isEvictable tells whether a CachedImage may be evicted, i.e. whether it would expire if it was not used anymore
+func isEvictable(cachedImage *kuikv1alpha1.CachedImage, now time.Time) bool {
+	return len(cachedImage.Status.UsedBy.Pods) == 0 && !cachedImage.Spec.Retain && !cachedImage.IsPinned(now)
+}
+
+// lastUsedAt returns the last time an image has been used, or when it has been put in cache if it has never been
+func lastUsedAt(cachedImage *kuikv1alpha1.CachedImage) time.Time {
+	if cachedImage.Status.LastUsedAt != nil {
+		return cachedImage.Status.LastUsedAt.Time
+	}
+	if cachedImage.Status.Usage.LastPulledAt != nil {
+		return cachedImage.Status.Usage.LastPulledAt.Time
+	}
+	if cachedImage.Status.RefreshedAt != nil {
+		return cachedImage.Status.RefreshedAt.Time
+	}
+	return cachedImage.CreationTimestamp.Time
+}
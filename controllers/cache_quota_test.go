@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCacheQuota(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	cachedImage := func(name string, size int64, lastPulledAt time.Time) *kuikv1alpha1.CachedImage {
+		return &kuikv1alpha1.CachedImage{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: name},
+			Status: kuikv1alpha1.CachedImageStatus{
+				IsCached: true,
+				Size:     size,
+				Usage:    kuikv1alpha1.Usage{LastPulledAt: &metav1.Time{Time: lastPulledAt}},
+			},
+		}
+	}
+
+	used := cachedImage("used", 200, now.Add(-48*time.Hour))
+	used.Status.UsedBy = kuikv1alpha1.UsedBy{Pods: []kuikv1alpha1.PodReference{{NamespacedName: "default/pod"}}, Count: 1}
+	retained := cachedImage("retained", 100, now.Add(-48*time.Hour))
+	retained.Spec.Retain = true
+	notCached := cachedImage("not-cached", 100, now.Add(-48*time.Hour))
+	notCached.Status.IsCached = false
+
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(
+		used,
+		retained,
+		notCached,
+		cachedImage("oldest", 100, now.Add(-2*time.Hour)),
+		cachedImage("older", 100, now.Add(-time.Hour)),
+		cachedImage("recent", 100, now),
+	).Build()
+
+	recorder := record.NewFakeRecorder(10)
+	quota := NewCacheQuota(k8sClient, recorder, 400, time.Minute)
+	quota.now = func() time.Time { return now }
+	g.Expect(quota.enforce(ctx)).To(Succeed())
+
+	var cachedImages kuikv1alpha1.CachedImageList
+	g.Expect(k8sClient.List(ctx, &cachedImages)).To(Succeed())
+	names := []string{}
+	for _, cachedImage := range cachedImages.Items {
+		names = append(names, cachedImage.Name)
+	}
+	g.Expect(names).To(ConsistOf("used", "retained", "not-cached", "recent"))
+	g.Expect(recorder.Events).To(HaveLen(2))
+
+	// Nothing is evicted below the quota
+	g.Expect(quota.enforce(ctx)).To(Succeed())
+	g.Expect(k8sClient.Get(ctx, client.ObjectKey{Name: "recent"}, &kuikv1alpha1.CachedImage{})).To(Succeed())
+	g.Expect(recorder.Events).To(HaveLen(2))
+}
+
+func TestNewCacheQuota(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(NewCacheQuota(nil, nil, 0, time.Minute)).To(BeNil())
+	g.Expect(NewCacheQuota(nil, nil, 100, 0)).To(BeNil())
+}
@@ -3,15 +3,19 @@ package controllers
 import (
 	"context"
 	"crypto/x509"
+	"fmt"
 	"net/http"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/distribution/reference"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
@@ -36,6 +40,14 @@ import (
 const (
 	cachedImageFinalizerName = "cachedimage.kuik.enix.io/finalizer"
 	repositoryOwnerKey       = ".metadata.repositoryOwner"
+
+	// registryUnavailableRetryDelay is the delay before trying again to reconcile a CachedImage when the registry is not
+	// reachable, e.g. while it starts after the controllers, instead of backing off exponentially
+	registryUnavailableRetryDelay = 15 * time.Second
+
+	// upstreamPauseRecheckInterval is how often CachedImages waiting for their upstream registry to be resumed are
+	// reconciled again
+	upstreamPauseRecheckInterval = time.Minute
 )
 
 // CachedImageReconciler reconciles a CachedImage object
@@ -48,12 +60,44 @@ type CachedImageReconciler struct {
 	Architectures      []string
 	InsecureRegistries []string
 	RootCAs            *x509.CertPool
+	GarbageCollector   *GarbageCollector
+	// NodeImagesExpiryDelay enables node aware expiry when positive: unused images present on a node don't expire,
+	// and expire after this delay once they are missing from every node
+	NodeImagesExpiryDelay time.Duration
+	// MutableTagsExpiryDelay and ImmutableTagsExpiryDelay override ExpiryDelay for images with a mutable tag or with an
+	// immutable tag (or referenced by digest) respectively, when positive
+	MutableTagsExpiryDelay   time.Duration
+	ImmutableTagsExpiryDelay time.Duration
+	// MutableTagsRefreshInterval is how often images with a mutable tag are pulled again from upstream, never if 0
+	MutableTagsRefreshInterval time.Duration
+	// MutableTagsResyncInterval is how often the upstream digest of images with a mutable tag is checked, the image
+	// being pulled again if it has moved, never if 0. It is overridden by the resyncInterval of CachedImages.
+	MutableTagsResyncInterval time.Duration
+	// ImmutableTags matches tags that are not expected to change upstream, DefaultImmutableTags if nil
+	ImmutableTags *regexp.Regexp
+	// PullTimeout is how long pulling an image from upstream may take before it is aborted, never if 0. It is overridden
+	// by the pull-timeout annotation of CachedImages and extended for the ones with an expected-size annotation.
+	PullTimeout time.Duration
+	// UpgradeDetector pauses the expiry of CachedImages during cluster upgrades, expiry is never paused if nil
+	UpgradeDetector *UpgradeDetector
+	// CachingQueue orders the images waiting to be put in cache across restarts, images are cached in any order if nil
+	CachingQueue *CachingQueue
+	// Backpressure pauses the caching of new images while the cache registry can't take more of them, caching is never
+	// paused if nil
+	Backpressure *CachingBackpressure
+	// ScaledWorkloads keeps images of workloads scaled by an autoscaler from expiring, e.g. scaled to zero by KEDA,
+	// they expire like other images if nil
+	ScaledWorkloads *ScaledWorkloads
 }
 
 //+kubebuilder:rbac:groups=kuik.enix.io,resources=cachedimages,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=kuik.enix.io,resources=cachedimages/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=kuik.enix.io,resources=cachedimages/finalizers,verbs=update
+//+kubebuilder:rbac:groups=kuik.enix.io,resources=clusterpolicies,verbs=get;list;watch
+//+kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps,resources=deployments;statefulsets,verbs=get;list;watch
 //+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
 //+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
@@ -124,6 +168,7 @@ func (r *CachedImageReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	repository := kuikv1alpha1.Repository{ObjectMeta: metav1.ObjectMeta{Name: registry.SanitizeName(repositoryName)}}
 	operation, err := controllerutil.CreateOrPatch(ctx, r.Client, &repository, func() error {
 		repository.Spec.Name = repositoryName
+		metav1.SetMetaDataLabel(&repository.ObjectMeta, kuikv1alpha1.RegistryLabelName, kuikv1alpha1.RegistryLabel(named))
 		return nil
 	})
 
@@ -156,6 +201,7 @@ func (r *CachedImageReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			}
 			r.Recorder.Eventf(&cachedImage, "Normal", "CleanedUp", "Image %s successfully removed from cache", cachedImage.Spec.SourceImage)
 			imageRemovedFromCache.Inc()
+			r.GarbageCollector.ImageRemoved()
 
 			log.Info("removing finalizer")
 			controllerutil.RemoveFinalizer(&cachedImage, cachedImageFinalizerName)
@@ -178,6 +224,16 @@ func (r *CachedImageReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 
 	log = log.WithValues("sourceImage", cachedImage.Spec.SourceImage)
 
+	// Update CachedImage Nodes status, it is saved along with the UsedBy status
+	if r.NodeImagesExpiryDelay > 0 {
+		if err := r.updateNodeCount(ctx, &cachedImage); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if err := r.pruneNodePulls(ctx, &cachedImage); err != nil {
+		return ctrl.Result{}, err
+	}
+
 	// Update CachedImage UsedBy status
 	if requeue, err := r.updatePodCount(ctx, &cachedImage); requeue {
 		return ctrl.Result{Requeue: true}, nil
@@ -187,19 +243,50 @@ func (r *CachedImageReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 
 	// Set an expiration date for unused CachedImage
 	expiresAt := cachedImage.Spec.ExpiresAt
-	if len(cachedImage.Status.UsedBy.Pods) == 0 && !cachedImage.Spec.Retain {
+	isOnNodes := r.isOnNodes(&cachedImage)
+	isPinned := cachedImage.IsPinned(time.Now())
+	var scaledWorkloads []string
+	if len(cachedImage.Status.UsedBy.Pods) == 0 && !cachedImage.Spec.Retain && !isOnNodes && !isPinned {
+		if scaledWorkloads, err = r.ScaledWorkloads.Using(ctx, cachedImage.Spec.SourceImage); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	isScaled := len(scaledWorkloads) > 0
+	if len(cachedImage.Status.UsedBy.Pods) == 0 && !cachedImage.Spec.Retain && !isOnNodes && !isPinned && !isScaled {
 		if cachedImage.Spec.ExpiresAt.IsZero() {
-			expiresAt := metav1.NewTime(time.Now().Add(r.ExpiryDelay))
+			expiresAt := metav1.NewTime(r.nodeAwareExpiry(&cachedImage, time.Now().Add(r.expiryDelay(&cachedImage))))
 			log.Info("cachedimage is no longer used, setting an expiry date", "cachedImage", klog.KObj(&cachedImage), "expiresAt", expiresAt)
+			patch := client.MergeFrom(cachedImage.DeepCopy())
 			cachedImage.Spec.ExpiresAt = &expiresAt
 
-			err := r.Patch(ctx, &cachedImage, client.Merge)
+			err := r.Patch(ctx, &cachedImage, patch)
+			if err != nil && !apierrors.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
+			r.Recorder.Eventf(&cachedImage, "Normal", "ExpirationScheduled", "Image %s is no longer used, it expires at %s", cachedImage.Spec.SourceImage, expiresAt.UTC().Format(time.RFC3339))
+		} else if accelerated := r.usageAwareExpiry(&cachedImage, r.nodeAwareExpiry(&cachedImage, expiresAt.Time)); accelerated.Before(expiresAt.Time) {
+			expiresAt = &metav1.Time{Time: accelerated}
+			log.Info("cachedimage is missing from every node, bringing its expiry date forward", "cachedImage", klog.KObj(&cachedImage), "expiresAt", expiresAt, "missingSince", cachedImage.Status.Nodes.MissingSince)
+			patch := client.MergeFrom(cachedImage.DeepCopy())
+			cachedImage.Spec.ExpiresAt = expiresAt
+
+			err := r.Patch(ctx, &cachedImage, patch)
+			if err != nil && !apierrors.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
+		} else if postponed := r.usageAwareExpiry(&cachedImage, expiresAt.Time); postponed.After(expiresAt.Time) {
+			expiresAt = &metav1.Time{Time: postponed}
+			log.Info("cachedimage has been pulled since it is unused, postponing its expiry date", "cachedImage", klog.KObj(&cachedImage), "expiresAt", expiresAt, "lastUsedAt", cachedImage.Status.LastUsedAt)
+			patch := client.MergeFrom(cachedImage.DeepCopy())
+			cachedImage.Spec.ExpiresAt = expiresAt
+
+			err := r.Patch(ctx, &cachedImage, patch)
 			if err != nil && !apierrors.IsNotFound(err) {
 				return ctrl.Result{}, err
 			}
 		}
 	} else {
-		log.Info("cachedimage is used or retained", "cachedImage", klog.KObj(&cachedImage), "expiresAt", expiresAt, "retain", cachedImage.Spec.Retain)
+		log.Info("cachedimage is used, retained, present on nodes, pinned or used by scaled workloads", "cachedImage", klog.KObj(&cachedImage), "expiresAt", expiresAt, "retain", cachedImage.Spec.Retain, "onNodes", isOnNodes, "pinnedUntil", cachedImage.Spec.PinnedUntil, "scaledWorkloads", scaledWorkloads)
 		patch := client.MergeFrom(cachedImage.DeepCopy())
 		cachedImage.Spec.ExpiresAt = nil
 		err := r.Patch(ctx, &cachedImage, patch)
@@ -214,7 +301,17 @@ func (r *CachedImageReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	// Delete expired CachedImage and schedule deletion for expiring ones
 	if !expiresAt.IsZero() {
 		if time.Now().After(expiresAt.Time) {
+			if upgrading, err := r.UpgradeDetector.InProgress(ctx); err != nil {
+				return ctrl.Result{}, err
+			} else if upgrading {
+				log.Info("cachedimage expired during a cluster upgrade, delaying its deletion", "expiresAt", expiresAt, "retryAfter", clusterUpgradeRecheckInterval)
+				setCondition(&cachedImage, kuikv1alpha1.ConditionExpired, metav1.ConditionTrue, "DeletionDelayed", "Image has expired, its deletion is delayed by a cluster upgrade")
+				r.updateConditions(ctx, &cachedImage)
+				return ctrl.Result{RequeueAfter: clusterUpgradeRecheckInterval}, nil
+			}
 			log.Info("cachedimage expired, deleting it", "now", time.Now(), "expiresAt", expiresAt)
+			setCondition(&cachedImage, kuikv1alpha1.ConditionExpired, metav1.ConditionTrue, "Expired", "Image has expired at "+expiresAt.UTC().Format(time.RFC3339))
+			r.updateConditions(ctx, &cachedImage)
 			r.Recorder.Eventf(&cachedImage, "Normal", "Expiring", "Image %s has expired, deleting it", cachedImage.Spec.SourceImage)
 			err := r.Delete(ctx, &cachedImage)
 			if err != nil {
@@ -224,36 +321,185 @@ func (r *CachedImageReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			r.Recorder.Eventf(&cachedImage, "Normal", "Expired", "Image %s successfully expired", cachedImage.Spec.SourceImage)
 			return ctrl.Result{}, nil
 		} else {
+			setCondition(&cachedImage, kuikv1alpha1.ConditionExpired, metav1.ConditionFalse, "Expiring", "Image is no longer used and expires at "+expiresAt.UTC().Format(time.RFC3339))
+			r.updateConditions(ctx, &cachedImage)
 			return ctrl.Result{RequeueAfter: time.Until(expiresAt.Time)}, nil
 		}
 	}
 
+	// Delete the image from the cache when requested so that it is pulled again from upstream below
+	if cachedImage.IsRecacheRequested() {
+		if pausedBy, err := cachedImage.UpstreamPausedBy(ctx, r); err != nil {
+			return ctrl.Result{}, err
+		} else if pausedBy != "" {
+			// The image is kept in cache until it can be pulled again
+			log.Info("upstream registry is paused, delaying re-caching", "pausedBy", pausedBy)
+			return upstreamPausedResult(), nil
+		}
+		if pausedBy := r.Backpressure.PausedBy(); pausedBy != "" {
+			log.Info("caching is paused, delaying re-caching", "pausedBy", pausedBy, "retryAfter", backpressureRecheckInterval)
+			return ctrl.Result{RequeueAfter: backpressureRecheckInterval}, nil
+		}
+		if err := r.recache(ctx, &cachedImage); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
 	// Adding image to registry
 	log.Info("caching image")
 	isCached, err := registry.ImageIsCached(cachedImage.Spec.SourceImage)
 	if err != nil {
-		log.Error(err, "could not determine if the image present in cache")
-		return ctrl.Result{}, err
+		log.Error(err, "could not determine if the image present in cache, retrying", "retryAfter", registryUnavailableRetryDelay)
+		return ctrl.Result{RequeueAfter: registryUnavailableRetryDelay}, nil
+	}
+	// Images of the previous registry are copied instead of being pulled again from upstream
+	if !isCached && registry.MigrationInProgress() {
+		if isCached, err = registry.MigrateImage(cachedImage.Spec.SourceImage); err != nil {
+			log.Error(err, "could not migrate image from the previous registry, caching it from upstream")
+		} else if isCached {
+			log.Info("image migrated from the previous registry")
+		}
 	}
 
 	if !isCached {
-		r.Recorder.Eventf(&cachedImage, "Normal", "Caching", "Start caching image %s", cachedImage.Spec.SourceImage)
-		if err := r.cacheImage(&cachedImage); err != nil {
-			log.Error(err, "failed to cache image")
-			r.Recorder.Eventf(&cachedImage, "Warning", "CacheFailed", "Failed to cache image %s, reason: %s", cachedImage.Spec.SourceImage, err)
+		if pausedBy, err := cachedImage.UpstreamPausedBy(ctx, r); err != nil {
+			return ctrl.Result{}, err
+		} else if pausedBy != "" {
+			// Images waiting for their upstream registry to resume leave the queue so that they don't hold back the
+			// images that can be cached, and the status is only written when it changes
+			paused := cachedImage.DeepCopy()
+			paused.Status.QueuedAt = nil
+			reason, message := "UpstreamPaused", "Pulls from the upstream registry are paused by "+pausedBy
+			if registry.Airgapped {
+				reason, message = "NotCacheable", "Image is not in cache and can't be pulled in air-gapped mode"
+			}
+			setCondition(paused, kuikv1alpha1.ConditionCaching, metav1.ConditionFalse, reason, message)
+			setReadyCondition(paused, metav1.ConditionFalse, reason, message)
+			if !equality.Semantic.DeepEqual(paused.Status, cachedImage.Status) {
+				r.updateConditions(ctx, paused)
+			}
+			if registry.Airgapped {
+				log.Info("image is not cacheable in air-gapped mode")
+				return ctrl.Result{}, nil
+			}
+			log.Info("upstream registry is paused, delaying caching", "pausedBy", pausedBy, "retryAfter", upstreamPauseRecheckInterval)
+			return ctrl.Result{RequeueAfter: upstreamPauseRecheckInterval}, nil
+		}
+		// Record when the image has been queued so that it keeps its place in the queue after a restart
+		if cachedImage.Status.QueuedAt == nil {
+			cachedImage.Status.QueuedAt = &metav1.Time{Time: time.Now()}
+			if err := r.Status().Update(ctx, &cachedImage); err != nil {
+				return ctrl.Result{}, client.IgnoreNotFound(err)
+			}
+		}
+		// The image keeps its place in the queue until caching resumes
+		if pausedBy := r.Backpressure.PausedBy(); pausedBy != "" {
+			log.Info("caching is paused, delaying caching", "pausedBy", pausedBy, "retryAfter", backpressureRecheckInterval)
+			setCondition(&cachedImage, kuikv1alpha1.ConditionCaching, metav1.ConditionFalse, "Backpressure", "Caching is paused by "+pausedBy)
+			r.updateConditions(ctx, &cachedImage)
+			return ctrl.Result{RequeueAfter: backpressureRecheckInterval}, nil
+		}
+		if turn, ahead, err := r.CachingQueue.Turn(ctx, &cachedImage); err != nil {
 			return ctrl.Result{}, err
+		} else if !turn {
+			log.Info("waiting for images queued before to be cached", "ahead", ahead, "priority", cachedImage.Spec.Priority, "queuedAt", cachedImage.Status.QueuedAt)
+			setCondition(&cachedImage, kuikv1alpha1.ConditionCaching, metav1.ConditionFalse, "Queued", fmt.Sprintf("Image is queued behind %d images", ahead))
+			r.updateConditions(ctx, &cachedImage)
+			return ctrl.Result{RequeueAfter: cachingQueueRecheckInterval}, nil
+		}
+		if delay, ok := admissionToCachingStartDelay(&cachedImage, time.Now()); ok {
+			log.Info("caching started after pod admission", "delay", delay)
+			admissionToCachingStart.Observe(delay.Seconds())
+		}
+		r.recordCachingEvent(ctx, &cachedImage, "Normal", "CacheStarted", "Start caching image %s", cachedImage.Spec.SourceImage)
+		setCondition(&cachedImage, kuikv1alpha1.ConditionCaching, metav1.ConditionTrue, "Caching", "Image is being put in cache")
+		r.updateConditions(ctx, &cachedImage)
+		if err := r.cacheImage(ctx, &cachedImage); err != nil {
+			if budgetErr, ok := err.(*registry.BudgetExceededError); ok {
+				log.Info("upstream budget exhausted, delaying caching", "retryAfter", budgetErr.RetryAfter)
+				r.Recorder.Eventf(&cachedImage, "Normal", "CacheDelayed", "Delaying caching of image %s: %s", cachedImage.Spec.SourceImage, err)
+				upstreamBudgetExceeded.Inc()
+				r.reportNotReady(ctx, &cachedImage, "CacheDelayed", err.Error())
+				return ctrl.Result{RequeueAfter: budgetErr.RetryAfter}, nil
+			}
+			class := registry.ClassifyError(err)
+			log.Error(err, "failed to cache image", "class", class)
+			r.recordCachingEvent(ctx, &cachedImage, "Warning", "CacheFailed", "Failed to cache image %s, reason: %s", cachedImage.Spec.SourceImage, err)
+			imageCacheFailures.WithLabelValues(string(class), "cache").Inc()
+			// Failed images are queued again when retried, so that they don't hold back the queue in the meantime
+			cachedImage.Status.QueuedAt = nil
+			setCachingFailedConditions(&cachedImage, class, err)
+			r.reportNotReady(ctx, &cachedImage, failureReason(class), err.Error())
+			return retryAfterFailure(class, err)
 		} else {
+			setCachedConditions(&cachedImage)
 			log.Info("image cached")
-			r.Recorder.Eventf(&cachedImage, "Normal", "Cached", "Successfully cached image %s", cachedImage.Spec.SourceImage)
+			r.recordCachingEvent(ctx, &cachedImage, "Normal", "CacheSucceeded", "Successfully cached image %s", cachedImage.Spec.SourceImage)
 			imagePutInCache.Inc()
+			cachedImage.Status.RefreshedAt = &metav1.Time{Time: time.Now()}
+			r.recordUpstreamDigest(ctx, &cachedImage)
+			updateImageStats(ctx, &cachedImage)
+		}
+	} else if refreshIn, ok := r.refreshIn(&cachedImage, time.Now()); (ok && refreshIn <= 0) || cachedImage.IsRefreshRequested() || r.tagMoved(ctx, &cachedImage) {
+		// Pull images with a mutable tag again so that they follow upstream changes, or when requested or when their tag
+		// has moved upstream
+		if pausedBy, err := cachedImage.UpstreamPausedBy(ctx, r); err != nil {
+			return ctrl.Result{}, err
+		} else if pausedBy != "" {
+			// The previous image is still available from the cache
+			log.Info("upstream registry is paused, delaying refresh", "pausedBy", pausedBy)
+			return upstreamPausedResult(), nil
+		}
+		if pausedBy := r.Backpressure.PausedBy(); pausedBy != "" {
+			log.Info("caching is paused, delaying refresh", "pausedBy", pausedBy, "retryAfter", backpressureRecheckInterval)
+			return ctrl.Result{RequeueAfter: backpressureRecheckInterval}, nil
 		}
+		log.Info("refreshing image", "mutable", ok, "requested", cachedImage.IsRefreshRequested())
+		r.Recorder.Eventf(&cachedImage, "Normal", "Refreshing", "Refreshing image %s", cachedImage.Spec.SourceImage)
+		setCondition(&cachedImage, kuikv1alpha1.ConditionCaching, metav1.ConditionTrue, "Refreshing", "Image is being pulled again from its registry")
+		r.updateConditions(ctx, &cachedImage)
+		if err := r.cacheImage(ctx, &cachedImage); err != nil {
+			if budgetErr, ok := err.(*registry.BudgetExceededError); ok {
+				log.Info("upstream budget exhausted, delaying refresh", "retryAfter", budgetErr.RetryAfter)
+				r.Recorder.Eventf(&cachedImage, "Normal", "RefreshDelayed", "Delaying refresh of image %s: %s", cachedImage.Spec.SourceImage, err)
+				upstreamBudgetExceeded.Inc()
+				return ctrl.Result{RequeueAfter: budgetErr.RetryAfter}, nil
+			}
+			class := registry.ClassifyError(err)
+			log.Error(err, "failed to refresh image", "class", class)
+			r.Recorder.Eventf(&cachedImage, "Warning", "RefreshFailed", "Failed to refresh image %s, reason: %s", cachedImage.Spec.SourceImage, err)
+			imageCacheFailures.WithLabelValues(string(class), "refresh").Inc()
+			// The previous image is still available from the cache
+			setCachingFailedConditions(&cachedImage, class, err)
+			r.updateConditions(ctx, &cachedImage)
+			return retryAfterFailure(class, err)
+		}
+		setCachedConditions(&cachedImage)
+		log.Info("image refreshed")
+		r.Recorder.Eventf(&cachedImage, "Normal", "Refreshed", "Successfully refreshed image %s", cachedImage.Spec.SourceImage)
+		cachedImage.Status.RefreshedAt = &metav1.Time{Time: time.Now()}
+		r.recordUpstreamDigest(ctx, &cachedImage)
+		updateImageStats(ctx, &cachedImage)
 	} else {
 		log.Info("image already present in cache, ignoring")
+		// Images cached before their refresh date was recorded are considered up to date
+		if cachedImage.Status.RefreshedAt == nil {
+			cachedImage.Status.RefreshedAt = &metav1.Time{Time: time.Now()}
+		}
+		// Images cached before their size, number of layers and digest were recorded
+		if cachedImage.Status.Size == 0 || cachedImage.Status.Digest == "" {
+			updateImageStats(ctx, &cachedImage)
+		}
 	}
 
 	// Update CachedImage IsCached status
 	log.Info("updating CachedImage status")
 	cachedImage.Status.IsCached = true
+	cachedImage.Status.Progress = nil
+	cachedImage.Status.QueuedAt = nil
+	setCachedReadyCondition(&cachedImage)
+	setCondition(&cachedImage, kuikv1alpha1.ConditionExpired, metav1.ConditionFalse, "NotExpiring", "Image is used, retained, present on nodes, pinned or used by scaled workloads")
+	cachedImage.Status.Summary = cachedImageSummary(&cachedImage)
 	err = r.Status().Update(context.Background(), &cachedImage)
 	if err != nil {
 		if statusErr, ok := err.(*errors.StatusError); ok && statusErr.Status().Code == http.StatusConflict {
@@ -263,7 +509,41 @@ func (r *CachedImageReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	}
 
 	log.Info("cachedimage reconciled")
-	return ctrl.Result{}, nil
+
+	result := ctrl.Result{}
+
+	// Check again later whether unused images are still present on nodes
+	if isOnNodes && len(cachedImage.Status.UsedBy.Pods) == 0 {
+		result.RequeueAfter = nodeImagesResyncPeriod
+	}
+
+	// Check again later whether unused images are still used by scaled workloads
+	if isScaled && (result.RequeueAfter == 0 || scaledWorkloadsResyncPeriod < result.RequeueAfter) {
+		result.RequeueAfter = scaledWorkloadsResyncPeriod
+	}
+
+	// Set an expiration date once the pin is over
+	if isPinned {
+		if untilUnpinned := time.Until(cachedImage.Spec.PinnedUntil.Time); result.RequeueAfter == 0 || untilUnpinned < result.RequeueAfter {
+			result.RequeueAfter = untilUnpinned
+		}
+	}
+
+	// Refresh images with a mutable tag periodically
+	if refreshIn, ok := r.refreshIn(&cachedImage, time.Now()); ok {
+		if result.RequeueAfter == 0 || refreshIn < result.RequeueAfter {
+			result.RequeueAfter = refreshIn
+		}
+	}
+
+	// Check periodically whether the tag of images with a mutable tag has moved upstream
+	if resyncIn, ok := r.resyncIn(&cachedImage, time.Now()); ok {
+		if result.RequeueAfter == 0 || resyncIn < result.RequeueAfter {
+			result.RequeueAfter = resyncIn
+		}
+	}
+
+	return result, nil
 }
 
 func getSanitizedName(cachedImage *kuikv1alpha1.CachedImage) (string, error) {
@@ -280,13 +560,203 @@ func getSanitizedName(cachedImage *kuikv1alpha1.CachedImage) (string, error) {
 	return sanitizedName, nil
 }
 
-func (r *CachedImageReconciler) cacheImage(cachedImage *kuikv1alpha1.CachedImage) error {
+// setReadyCondition sets the Ready condition of a CachedImage, telling GitOps tools whether the image is available
+// from the cache, along with the generation it has been observed for
+func setReadyCondition(cachedImage *kuikv1alpha1.CachedImage, status metav1.ConditionStatus, reason string, message string) {
+	setCondition(cachedImage, kuikv1alpha1.ConditionReady, status, reason, message)
+	cachedImage.Status.ObservedGeneration = cachedImage.Generation
+}
+
+func setCondition(cachedImage *kuikv1alpha1.CachedImage, conditionType string, status metav1.ConditionStatus, reason string, message string) {
+	meta.SetStatusCondition(&cachedImage.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: cachedImage.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// setCachedConditions sets the Caching and UpstreamUnreachable conditions of a CachedImage once it has been put in
+// cache or refreshed, as well as the SignatureVerified condition if its signature has been verified
+func setCachedConditions(cachedImage *kuikv1alpha1.CachedImage) {
+	setCondition(cachedImage, kuikv1alpha1.ConditionCaching, metav1.ConditionFalse, "Cached", "Image has been put in cache")
+	setCondition(cachedImage, kuikv1alpha1.ConditionUpstreamUnreachable, metav1.ConditionFalse, "UpstreamReachable", "Image has been pulled from its registry")
+	if registry.ImageSignaturePolicy.Applies(cachedImage.Spec.SourceImage) {
+		setCondition(cachedImage, kuikv1alpha1.ConditionSignatureVerified, metav1.ConditionTrue, "Verified", "Signature of the image has been verified by the policy of its registry")
+	}
+}
+
+// setCachingFailedConditions sets the Caching and UpstreamUnreachable conditions of a CachedImage that could not be put
+// in cache or refreshed. Failures of other classes than network ones mean that the registry answered.
+func setCachingFailedConditions(cachedImage *kuikv1alpha1.CachedImage, class registry.FailureClass, err error) {
+	setCondition(cachedImage, kuikv1alpha1.ConditionCaching, metav1.ConditionFalse, failureReason(class), err.Error())
+	if class == registry.FailureNetwork {
+		setCondition(cachedImage, kuikv1alpha1.ConditionUpstreamUnreachable, metav1.ConditionTrue, kuikv1alpha1.ReasonUpstreamUnreachable, err.Error())
+	} else {
+		setCondition(cachedImage, kuikv1alpha1.ConditionUpstreamUnreachable, metav1.ConditionFalse, "UpstreamReachable", "Registry of the image answered")
+	}
+	if class == registry.FailureSignature {
+		setCondition(cachedImage, kuikv1alpha1.ConditionSignatureVerified, metav1.ConditionFalse, kuikv1alpha1.ReasonSignatureInvalid, err.Error())
+	}
+}
+
+// updateConditions persists the conditions of a CachedImage while it is reconciled, e.g. before a long caching, a
+// failure to do so is only logged since the status is updated again at the end of the reconcile
+func (r *CachedImageReconciler) updateConditions(ctx context.Context, cachedImage *kuikv1alpha1.CachedImage) {
+	cachedImage.Status.Summary = cachedImageSummary(cachedImage)
+	if err := r.Status().Update(ctx, cachedImage); err != nil {
+		log.FromContext(ctx).Error(err, "could not update CachedImage status")
+	}
+}
+
+// reportNotReady records in the status of a CachedImage why it could not be cached, the error has already been
+// reported otherwise so a failure to update the status is only logged
+func (r *CachedImageReconciler) reportNotReady(ctx context.Context, cachedImage *kuikv1alpha1.CachedImage, reason string, message string) {
+	setReadyCondition(cachedImage, metav1.ConditionFalse, reason, message)
+	r.updateConditions(ctx, cachedImage)
+}
+
+// tagMoved resyncs a cached image with a mutable tag when it is due, telling whether its tag has moved upstream since
+// it has last been pulled. Images whose upstream registry is paused or whose upstream digest can't be resolved are
+// considered up to date until the next resync. Images cached before their upstream digest was recorded can't be
+// compared with the digest in cache, which differs from the upstream one when only some architectures are cached, so
+// the upstream digest is recorded for the next resyncs instead of pulling them again.
+func (r *CachedImageReconciler) tagMoved(ctx context.Context, cachedImage *kuikv1alpha1.CachedImage) bool {
+	if resyncIn, ok := r.resyncIn(cachedImage, time.Now()); !ok || resyncIn > 0 {
+		return false
+	}
+
+	log := log.FromContext(ctx)
+	cachedImage.Status.ResyncedAt = &metav1.Time{Time: time.Now()}
+	if pausedBy, err := cachedImage.UpstreamPausedBy(ctx, r); err != nil || pausedBy != "" {
+		return false
+	}
+
+	upstreamDigest, err := r.upstreamDigest(cachedImage)
+	if err != nil {
+		log.Error(err, "could not resolve upstream digest, keeping cached image until the next resync")
+		return false
+	}
+
+	pulledDigest := cachedImage.Status.UpstreamDigest
+	if pulledDigest == "" {
+		log.Info("recording upstream digest of image cached before it was recorded", "upstreamDigest", upstreamDigest)
+		cachedImage.Status.UpstreamDigest = upstreamDigest
+		return false
+	}
+	if upstreamDigest == pulledDigest {
+		return false
+	}
+
+	log.Info("tag moved upstream", "pulledDigest", pulledDigest, "upstreamDigest", upstreamDigest)
+	r.Recorder.Eventf(cachedImage, "Normal", "TagMoved", "Tag of image %s moved upstream from %s to %s", cachedImage.Spec.SourceImage, pulledDigest, upstreamDigest)
+	return true
+}
+
+// recordUpstreamDigest records the upstream digest of an image that has just been pulled, which is memoized since it
+// has been resolved to pull the image. It is compared with the upstream digest of its tag by resyncs and by the proxy,
+// the digest in cache differing from it when only some architectures of the image are cached.
+func (r *CachedImageReconciler) recordUpstreamDigest(ctx context.Context, cachedImage *kuikv1alpha1.CachedImage) {
+	upstreamDigest, err := r.upstreamDigest(cachedImage)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "could not resolve upstream digest")
+		return
+	}
+	cachedImage.Status.UpstreamDigest = upstreamDigest
+}
+
+// upstreamDigest resolves the digest of an image in its upstream registry
+func (r *CachedImageReconciler) upstreamDigest(cachedImage *kuikv1alpha1.CachedImage) (string, error) {
+	pullSecrets, err := cachedImage.GetPullSecrets(r.ApiReader)
+	if err != nil {
+		return "", err
+	}
+
+	digest, err := registry.UpstreamImageDigest(cachedImage.Spec.SourceImage, pullSecrets, r.InsecureRegistries, r.RootCAs)
+	if err != nil {
+		return "", err
+	}
+	return digest.String(), nil
+}
+
+// recache deletes an image from the cache so that it is pulled again from upstream, then removes the annotation
+// requesting it
+func (r *CachedImageReconciler) recache(ctx context.Context, cachedImage *kuikv1alpha1.CachedImage) error {
+	log.FromContext(ctx).Info("re-caching requested, deleting image from cache")
+	r.Recorder.Eventf(cachedImage, "Normal", "Recaching", "Removing image %s from cache to pull it again from upstream", cachedImage.Spec.SourceImage)
+	if err := registry.DeleteImage(cachedImage.Spec.SourceImage); err != nil {
+		r.Recorder.Eventf(cachedImage, "Warning", "RecacheFailed", "Image %s could not be removed from cache: %s", cachedImage.Spec.SourceImage, err)
+		return err
+	}
+	imageRemovedFromCache.Inc()
+	r.GarbageCollector.ImageRemoved()
+
+	patch := client.MergeFrom(cachedImage.DeepCopy())
+	delete(cachedImage.Annotations, kuikv1alpha1.RecacheAnnotationName)
+	if err := r.Patch(ctx, cachedImage, patch); err != nil {
+		return err
+	}
+
+	// Layers completed by a previous caching are not trusted either
+	cachedImage.Status.Progress = nil
+	return nil
+}
+
+// cacheImage puts an image in cache, resuming from the layers completed by a previous attempt, e.g. interrupted by a
+// restart of the controllers
+func (r *CachedImageReconciler) cacheImage(ctx context.Context, cachedImage *kuikv1alpha1.CachedImage) error {
 	pullSecrets, err := cachedImage.GetPullSecrets(r.ApiReader)
 	if err != nil {
 		return err
 	}
 
-	return registry.CacheImage(cachedImage.Spec.SourceImage, pullSecrets, r.Architectures, r.InsecureRegistries, r.RootCAs)
+	progress := &registry.CacheProgress{OnLayerCompleted: func(digest string) {
+		r.recordLayerCompleted(ctx, cachedImage, digest)
+	}}
+	if cachedImage.Status.Progress != nil {
+		progress.CompletedLayers = cachedImage.Status.Progress.CompletedLayers
+	}
+
+	pullCtx := ctx
+	timeout := r.pullTimeout(ctx, cachedImage)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		pullCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if err := registry.CacheImage(pullCtx, cachedImage.Spec.SourceImage, pullSecrets, r.Architectures, r.InsecureRegistries, r.RootCAs, progress); err != nil {
+		if pullCtx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("pull timed out after %s: %w", timeout, err)
+		}
+		return err
+	}
+	copyToPreviousRegistry(ctx, cachedImage.Spec.SourceImage)
+	return nil
+}
+
+// recordLayerCompleted persists in the status of a CachedImage that one of its layers has been put in cache, a failure
+// to do so only makes a restarted caching pull the layer again so it is only logged
+func (r *CachedImageReconciler) recordLayerCompleted(ctx context.Context, cachedImage *kuikv1alpha1.CachedImage, digest string) {
+	patch := client.MergeFrom(cachedImage.DeepCopy())
+	if cachedImage.Status.Progress == nil {
+		cachedImage.Status.Progress = &kuikv1alpha1.CachingProgress{}
+	}
+	cachedImage.Status.Progress.CompletedLayers = append(cachedImage.Status.Progress.CompletedLayers, digest)
+	cachedImage.Status.Summary = cachedImageSummary(cachedImage)
+	if err := r.Status().Patch(ctx, cachedImage, patch); err != nil {
+		log.FromContext(ctx).Error(err, "could not record caching progress", "layer", digest)
+	}
+}
+
+// upstreamPausedResult is the result of a reconcile delayed until the upstream registry of the image is resumed. Images
+// are not checked again in air-gapped mode, which lasts until the controllers are restarted.
+func upstreamPausedResult() ctrl.Result {
+	if registry.Airgapped {
+		return ctrl.Result{}
+	}
+	return ctrl.Result{RequeueAfter: upstreamPauseRecheckInterval}
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -303,9 +773,9 @@ func (r *CachedImageReconciler) SetupWithManager(mgr ctrl.Manager, maxConcurrent
 			WithValues("pod", klog.KObj(pod))
 		ctx := logr.NewContext(context.Background(), logger)
 
-		cachedImages := desiredCachedImages(ctx, pod)
+		cachedImages := DesiredCachedImages(ctx, pod)
 
-		cachedImageNames := make([]string, len(cachedImages))
+		cachedImageNames := make([]string, 0, len(cachedImages))
 		for _, cachedImage := range cachedImages {
 			cachedImageNames = append(cachedImageNames, cachedImage.Name)
 		}
@@ -315,11 +785,20 @@ func (r *CachedImageReconciler) SetupWithManager(mgr ctrl.Manager, maxConcurrent
 		return err
 	}
 
-	return ctrl.NewControllerManagedBy(mgr).
+	// Create an index to list Nodes by CachedImage present in their local store
+	if r.NodeImagesExpiryDelay > 0 {
+		if err := mgr.GetFieldIndexer().IndexField(context.Background(), &corev1.Node{}, cachedImageNodeKey, func(rawObj client.Object) []string {
+			return CachedImageNamesFromNode(rawObj.(*corev1.Node))
+		}); err != nil {
+			return err
+		}
+	}
+
+	controllerBuilder := ctrl.NewControllerManagedBy(mgr).
 		For(&kuikv1alpha1.CachedImage{}).
 		Watches(
 			&source.Kind{Type: &corev1.Pod{}},
-			handler.EnqueueRequestsFromMapFunc(r.cachedImagesRequestFromPod),
+			handler.EnqueueRequestsFromMapFunc(cachedImagesRequestFromPod),
 			builder.WithPredicates(predicate.Funcs{
 				// GenericFunc: func(e event.GenericEvent) bool {
 				// 	return true
@@ -335,13 +814,24 @@ func (r *CachedImageReconciler) SetupWithManager(mgr ctrl.Manager, maxConcurrent
 		).
 		Watches(
 			&source.Kind{Type: &corev1.Pod{}},
-			handler.EnqueueRequestsFromMapFunc(r.cachedImagesRequestFromPod),
+			handler.EnqueueRequestsFromMapFunc(cachedImagesRequestFromPod),
 			builder.WithPredicates(predicate.Funcs{
 				CreateFunc: func(e event.CreateEvent) bool {
 					return true
 				},
 			}),
-		).
+		)
+
+	// Start caching the next queued images as soon as an image leaves the caching queue
+	if r.CachingQueue != nil {
+		controllerBuilder = controllerBuilder.Watches(
+			&source.Kind{Type: &kuikv1alpha1.CachedImage{}},
+			handler.EnqueueRequestsFromMapFunc(r.CachingQueue.Next),
+			builder.WithPredicates(dequeuedPredicate),
+		)
+	}
+
+	return controllerBuilder.
 		WithOptions(controller.Options{
 			MaxConcurrentReconciles: maxConcurrentReconciles,
 		}).
@@ -356,17 +846,25 @@ func (r *CachedImageReconciler) updatePodCount(ctx context.Context, cachedImage
 	}
 
 	pods := []v1alpha1.PodReference{}
+	alwaysPulled := false
 	for _, pod := range podsList.Items {
 		if !pod.DeletionTimestamp.IsZero() {
 			continue
 		}
 		pods = append(pods, v1alpha1.PodReference{NamespacedName: pod.Namespace + "/" + pod.Name})
+		alwaysPulled = alwaysPulled || pullsAlways(&pod, cachedImage.Name)
 	}
 
+	if len(cachedImage.Status.UsedBy.Pods) > 0 && len(pods) == 0 {
+		lastUsedAt := metav1.Now()
+		cachedImage.Status.LastUsedAt = &lastUsedAt
+	}
 	cachedImage.Status.UsedBy = v1alpha1.UsedBy{
-		Pods:  pods,
-		Count: len(pods),
+		Pods:         pods,
+		Count:        len(pods),
+		AlwaysPulled: alwaysPulled,
 	}
+	cachedImage.Status.Summary = cachedImageSummary(cachedImage)
 
 	err = r.Status().Update(context.Background(), cachedImage)
 	if err != nil {
@@ -379,7 +877,7 @@ func (r *CachedImageReconciler) updatePodCount(ctx context.Context, cachedImage
 	return
 }
 
-func (r *CachedImageReconciler) cachedImagesRequestFromPod(obj client.Object) []ctrl.Request {
+func cachedImagesRequestFromPod(obj client.Object) []ctrl.Request {
 	log := log.
 		FromContext(context.Background()).
 		WithName("controller-runtime.manager.controller.cachedImage.deletingPods").
@@ -387,20 +885,15 @@ func (r *CachedImageReconciler) cachedImagesRequestFromPod(obj client.Object) []
 
 	pod := obj.(*corev1.Pod)
 	ctx := logr.NewContext(context.Background(), log)
-	cachedImages := desiredCachedImages(ctx, pod)
+	cachedImages := DesiredCachedImages(ctx, pod)
 
 	res := []ctrl.Request{}
 	for _, cachedImage := range cachedImages {
-		for _, value := range pod.GetAnnotations() {
-			// TODO check key format is "original-image-%s" or "original-init-image-%s"
-			if cachedImage.Spec.SourceImage == value {
-				res = append(res, ctrl.Request{
-					NamespacedName: types.NamespacedName{
-						Name: cachedImage.Name,
-					},
-				})
-			}
-		}
+		res = append(res, ctrl.Request{
+			NamespacedName: types.NamespacedName{
+				Name: cachedImage.Name,
+			},
+		})
 	}
 
 	return res
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/registry"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// cachedImageSummary returns a one-line summary of the status of a CachedImage for human operators, e.g.
+// "cached at 2024-01-02T15:04:05Z, 812MiB, used by 14 pods". It only relies on the status so that it costs nothing to
+// compute on every status update.
+func cachedImageSummary(cachedImage *kuikv1alpha1.CachedImage) string {
+	status := &cachedImage.Status
+	parts := []string{}
+
+	switch {
+	case status.IsCached && status.RefreshedAt != nil:
+		parts = append(parts, "cached at "+status.RefreshedAt.UTC().Format(time.RFC3339))
+	case status.IsCached:
+		parts = append(parts, "cached")
+	case status.Progress != nil:
+		parts = append(parts, fmt.Sprintf("caching, %d layers done", len(status.Progress.CompletedLayers)))
+	default:
+		condition := meta.FindStatusCondition(status.Conditions, kuikv1alpha1.ConditionReady)
+		if condition != nil && condition.Reason != "" {
+			parts = append(parts, "not cached: "+condition.Reason)
+		} else {
+			parts = append(parts, "not cached")
+		}
+	}
+
+	if status.Size > 0 {
+		parts = append(parts, FormatBytes(status.Size))
+	}
+
+	if status.Scan != nil && status.Scan.Blocked {
+		parts = append(parts, fmt.Sprintf("blocked: %d critical, %d high vulnerabilities", status.Scan.Critical, status.Scan.High))
+	}
+
+	if status.UsedBy.Count == 1 {
+		parts = append(parts, "used by 1 pod")
+	} else {
+		parts = append(parts, fmt.Sprintf("used by %d pods", status.UsedBy.Count))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// FormatBytes formats a size in bytes with a binary unit, e.g. 812MiB
+func FormatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%dB", bytes)
+	}
+
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	value := float64(bytes) / float64(div)
+	if value < 10 {
+		return fmt.Sprintf("%.1f%ciB", value, "KMGTPE"[exp])
+	}
+	return fmt.Sprintf("%.0f%ciB", value, "KMGTPE"[exp])
+}
+
+// updateImageStats records the size, the number of layers and the digest of an image in cache in the status of its
+// CachedImage, a failure to read them from the registry only leaves the previous ones so it is only logged
+func updateImageStats(ctx context.Context, cachedImage *kuikv1alpha1.CachedImage) {
+	stats, err := registry.GetImageStats(cachedImage.Spec.SourceImage)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "could not compute the size of the image in cache")
+		return
+	}
+
+	cachedImage.Status.Size = stats.Size
+	cachedImage.Status.LayerCount = stats.Layers
+	cachedImage.Status.Digest = stats.Digest.String()
+}
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCachedImageSummary(t *testing.T) {
+	refreshedAt := metav1.NewTime(time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC))
+
+	tests := []struct {
+		name     string
+		status   kuikv1alpha1.CachedImageStatus
+		expected string
+	}{
+		{
+			name: "Cached",
+			status: kuikv1alpha1.CachedImageStatus{
+				IsCached:    true,
+				RefreshedAt: &refreshedAt,
+				Size:        812 << 20,
+				UsedBy:      kuikv1alpha1.UsedBy{Count: 14},
+			},
+			expected: "cached at 2024-01-02T15:04:05Z, 812MiB, used by 14 pods",
+		},
+		{
+			name: "Blocked",
+			status: kuikv1alpha1.CachedImageStatus{
+				IsCached:    true,
+				RefreshedAt: &refreshedAt,
+				Scan:        &kuikv1alpha1.ImageScan{Critical: 2, High: 5, Blocked: true},
+				UsedBy:      kuikv1alpha1.UsedBy{Count: 14},
+			},
+			expected: "cached at 2024-01-02T15:04:05Z, blocked: 2 critical, 5 high vulnerabilities, used by 14 pods",
+		},
+		{
+			name: "Caching",
+			status: kuikv1alpha1.CachedImageStatus{
+				Progress: &kuikv1alpha1.CachingProgress{CompletedLayers: []string{"sha256:a", "sha256:b"}},
+				UsedBy:   kuikv1alpha1.UsedBy{Count: 1},
+			},
+			expected: "caching, 2 layers done, used by 1 pod",
+		},
+		{
+			name: "Failed",
+			status: kuikv1alpha1.CachedImageStatus{
+				Conditions: []metav1.Condition{{Type: kuikv1alpha1.ConditionReady, Status: metav1.ConditionFalse, Reason: kuikv1alpha1.ReasonImageNotFound}},
+			},
+			expected: "not cached: ImageNotFound, used by 0 pods",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(cachedImageSummary(&kuikv1alpha1.CachedImage{Status: tt.status})).To(Equal(tt.expected))
+		})
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	g := NewWithT(t)
+	g.Expect(FormatBytes(512)).To(Equal("512B"))
+	g.Expect(FormatBytes(1536)).To(Equal("1.5KiB"))
+	g.Expect(FormatBytes(812 << 20)).To(Equal("812MiB"))
+	g.Expect(FormatBytes(20 << 30)).To(Equal("20GiB"))
+}
@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/registry"
+)
+
+// backpressureRecheckInterval is how often CachedImages held by the backpressure of the cache registry check whether
+// caching has resumed
+const backpressureRecheckInterval = time.Minute
+
+// CachingBackpressure pauses the caching of new images while the cache registry can't take more of them: when its
+// storage is nearly full, or when it answers so slowly that its storage is likely saturated. Images keep their place in
+// the caching queue meanwhile, instead of failing in the middle of their upload.
+type CachingBackpressure struct {
+	client.Reader
+	// Interval is how often the registry is checked
+	Interval time.Duration
+	// Capacity is the size in bytes of the storage of the registry, its usage is not checked if 0. The usage is the size
+	// of the blobs of cached images, blobs shared by several images being counted once.
+	Capacity int64
+	// HighWatermark is the ratio of Capacity used from which caching is paused
+	HighWatermark float64
+	// MaxLatency is the response time of the registry from which caching is paused, its latency is not checked if 0
+	MaxLatency time.Duration
+
+	mu       sync.RWMutex
+	pausedBy string
+	// check is registry.CheckHealth, replaced in tests
+	check func() error
+	// imageBlobs is registry.ImageBlobs, replaced in tests
+	imageBlobs func(string) (map[v1.Hash]int64, error)
+	// blobs memoizes the blobs of cached images by manifest digest, which never change
+	blobs map[string]map[v1.Hash]int64
+}
+
+// NewCachingBackpressure returns a CachingBackpressure, or nil if interval is not positive or if neither the usage of
+// the storage nor the latency of the registry is checked
+func NewCachingBackpressure(reader client.Reader, interval time.Duration, capacity int64, highWatermark float64, maxLatency time.Duration) *CachingBackpressure {
+	if interval <= 0 || (capacity <= 0 || highWatermark <= 0) && maxLatency <= 0 {
+		return nil
+	}
+
+	return &CachingBackpressure{
+		Reader:        reader,
+		Interval:      interval,
+		Capacity:      capacity,
+		HighWatermark: highWatermark,
+		MaxLatency:    maxLatency,
+		check:         registry.CheckHealth,
+		imageBlobs:    registry.ImageBlobs,
+		blobs:         map[string]map[v1.Hash]int64{},
+	}
+}
+
+// Start implements manager.Runnable
+func (b *CachingBackpressure) Start(ctx context.Context) error {
+	log := log.FromContext(ctx).WithName("caching-backpressure")
+
+	ticker := time.NewTicker(b.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := b.checkOnce(ctx); err != nil {
+			log.Error(err, "could not check the backpressure of the cache registry")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, every replica checks the registry so that a new leader
+// knows right away whether caching is paused
+func (b *CachingBackpressure) NeedLeaderElection() bool {
+	return false
+}
+
+// PausedBy returns what pauses the caching of new images, or an empty string if images can be put in cache
+func (b *CachingBackpressure) PausedBy() string {
+	if b == nil {
+		return ""
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.pausedBy
+}
+
+// checkOnce checks the usage of the storage of the registry and its latency, pausing or resuming caching accordingly
+// and reporting it in the cachingPaused metric
+func (b *CachingBackpressure) checkOnce(ctx context.Context) error {
+	log := log.FromContext(ctx).WithName("caching-backpressure")
+
+	storagePausedBy, err := b.checkStorage(ctx)
+	if err != nil {
+		return err
+	}
+	latencyPausedBy := b.checkLatency()
+
+	cachingPaused.WithLabelValues("storage").Set(boolToFloat(storagePausedBy != ""))
+	cachingPaused.WithLabelValues("latency").Set(boolToFloat(latencyPausedBy != ""))
+
+	pausedBy := storagePausedBy
+	if pausedBy == "" {
+		pausedBy = latencyPausedBy
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if pausedBy != "" && b.pausedBy == "" {
+		log.Info("pausing caching of new images", "pausedBy", pausedBy)
+	} else if pausedBy == "" && b.pausedBy != "" {
+		log.Info("resuming caching of new images")
+	}
+	b.pausedBy = pausedBy
+
+	return nil
+}
+
+// checkStorage tells whether the storage of the registry is used above its high watermark
+func (b *CachingBackpressure) checkStorage(ctx context.Context) (string, error) {
+	if b.Capacity <= 0 || b.HighWatermark <= 0 {
+		return "", nil
+	}
+
+	var cachedImages kuikv1alpha1.CachedImageList
+	if err := b.List(ctx, &cachedImages); err != nil {
+		return "", err
+	}
+
+	size := b.storedSize(cachedImages.Items)
+
+	usage := float64(size) / float64(b.Capacity)
+	if usage < b.HighWatermark {
+		return "", nil
+	}
+	return fmt.Sprintf("the storage of the cache registry being %.0f%% full (%s of %s)", usage*100, FormatBytes(size), FormatBytes(b.Capacity)), nil
+}
+
+// storedSize returns the size of the blobs of cached images, blobs shared by several images being counted once. Images
+// whose blobs can't be read from the registry count for their whole size.
+func (b *CachingBackpressure) storedSize(cachedImages []kuikv1alpha1.CachedImage) int64 {
+	size := int64(0)
+	sizes := map[v1.Hash]int64{}
+	seen := map[string]bool{}
+	for _, cachedImage := range cachedImages {
+		if !cachedImage.Status.IsCached || !cachedImage.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		digest := cachedImage.Status.Digest
+		blobs, ok := b.blobs[digest]
+		if !ok || digest == "" {
+			var err error
+			if blobs, err = b.imageBlobs(cachedImage.Spec.SourceImage); err != nil {
+				size += cachedImage.Status.Size
+				continue
+			}
+			if digest != "" {
+				b.blobs[digest] = blobs
+			}
+		}
+		seen[digest] = true
+		for blob, blobSize := range blobs {
+			sizes[blob] = blobSize
+		}
+	}
+
+	// Images removed from the cache are forgotten
+	for digest := range b.blobs {
+		if !seen[digest] {
+			delete(b.blobs, digest)
+		}
+	}
+
+	for _, blobSize := range sizes {
+		size += blobSize
+	}
+	return size
+}
+
+// checkLatency tells whether the registry answers slower than MaxLatency. An unhealthy registry doesn't pause caching,
+// images failing to be cached while it can't be reached.
+func (b *CachingBackpressure) checkLatency() string {
+	if b.MaxLatency <= 0 {
+		return ""
+	}
+
+	start := time.Now()
+	if err := b.check(); err != nil {
+		return ""
+	}
+	if latency := time.Since(start); latency >= b.MaxLatency {
+		return fmt.Sprintf("the cache registry answering in %s", latency.Round(time.Millisecond))
+	}
+	return ""
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCachingBackpressure(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	g.Expect(NewCachingBackpressure(nil, 0, 100, 0.9, time.Second)).To(BeNil())
+	g.Expect(NewCachingBackpressure(nil, time.Minute, 0, 0.9, 0)).To(BeNil())
+	g.Expect((*CachingBackpressure)(nil).PausedBy()).To(BeEmpty())
+
+	cachedImage := func(name string, size int64, isCached bool) *kuikv1alpha1.CachedImage {
+		return &kuikv1alpha1.CachedImage{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: name},
+			Status:     kuikv1alpha1.CachedImageStatus{IsCached: isCached, Size: size, Digest: "sha256:" + name},
+		}
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(
+		cachedImage("nginx", 800<<20, true),
+		cachedImage("redis", 200<<20, true),
+		cachedImage("postgres", 500<<20, false),
+	).Build()
+	base := v1.Hash{Algorithm: "sha256", Hex: "base"}
+	blobs := map[string]map[v1.Hash]int64{
+		"nginx": {base: 100 << 20, {Algorithm: "sha256", Hex: "nginx"}: 700 << 20},
+		"redis": {base: 100 << 20, {Algorithm: "sha256", Hex: "redis"}: 100 << 20},
+	}
+	lookups := 0
+
+	// Images not in cache don't use storage, and blobs shared by several images are counted once
+	backpressure := NewCachingBackpressure(k8sClient, time.Minute, 1<<30, 0.9, 0)
+	backpressure.imageBlobs = func(imageName string) (map[v1.Hash]int64, error) {
+		lookups++
+		if imageBlobs, ok := blobs[imageName]; ok {
+			return imageBlobs, nil
+		}
+		return nil, errors.New("not found")
+	}
+	g.Expect(backpressure.checkOnce(ctx)).To(Succeed())
+	g.Expect(backpressure.PausedBy()).To(BeEmpty())
+
+	// Blobs of cached images are only read once
+	backpressure.HighWatermark = 0.8
+	g.Expect(backpressure.checkOnce(ctx)).To(Succeed())
+	g.Expect(backpressure.PausedBy()).To(Equal("the storage of the cache registry being 88% full (900MiB of 1.0GiB)"))
+	g.Expect(lookups).To(Equal(2))
+
+	// Images whose blobs can't be read count for their whole size
+	delete(blobs, "redis")
+	backpressure.blobs = map[string]map[v1.Hash]int64{}
+	g.Expect(backpressure.checkOnce(ctx)).To(Succeed())
+	g.Expect(backpressure.PausedBy()).To(Equal("the storage of the cache registry being 98% full (1000MiB of 1.0GiB)"))
+
+	// Caching is paused while the registry answers too slowly, but not while it doesn't answer at all
+	backpressure = NewCachingBackpressure(k8sClient, time.Minute, 0, 0.9, 10*time.Millisecond)
+	latency := time.Duration(0)
+	var checkErr error
+	backpressure.check = func() error {
+		time.Sleep(latency)
+		return checkErr
+	}
+	g.Expect(backpressure.checkOnce(ctx)).To(Succeed())
+	g.Expect(backpressure.PausedBy()).To(BeEmpty())
+
+	latency = 20 * time.Millisecond
+	g.Expect(backpressure.checkOnce(ctx)).To(Succeed())
+	g.Expect(backpressure.PausedBy()).To(HavePrefix("the cache registry answering in "))
+
+	checkErr = errors.New("registry answered with status 503 Service Unavailable")
+	g.Expect(backpressure.checkOnce(ctx)).To(Succeed())
+	g.Expect(backpressure.PausedBy()).To(BeEmpty())
+}
@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+)
+
+// cachingEventMaxPods bounds the number of pods an event about the caching of their image is recorded on, so that
+// images used by large workloads don't flood the API server with events
+const cachingEventMaxPods = 10
+
+// recordCachingEvent records an event about the caching of an image on its CachedImage and on the pods using it, so
+// that kubectl describe tells their users why their image isn't cached yet. Pods that are gone are skipped.
+func (r *CachedImageReconciler) recordCachingEvent(ctx context.Context, cachedImage *kuikv1alpha1.CachedImage, eventtype string, reason string, messageFmt string, args ...interface{}) {
+	r.Recorder.Eventf(cachedImage, eventtype, reason, messageFmt, args...)
+
+	for i, podReference := range cachedImage.Status.UsedBy.Pods {
+		if i == cachingEventMaxPods {
+			break
+		}
+		namespace, name, found := strings.Cut(podReference.NamespacedName, "/")
+		if !found {
+			continue
+		}
+		var pod corev1.Pod
+		if err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &pod); err != nil {
+			continue
+		}
+		r.Recorder.Eventf(&pod, eventtype, reason, messageFmt, args...)
+	}
+}
@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRecordCachingEvent(t *testing.T) {
+	g := NewWithT(t)
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "api-0"}}
+	recorder := record.NewFakeRecorder(10)
+	reconciler := &CachedImageReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(pod).Build(),
+		Recorder: recorder,
+	}
+	cachedImage := &kuikv1alpha1.CachedImage{
+		ObjectMeta: metav1.ObjectMeta{Name: "docker.io-library-nginx-1.25"},
+		Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: "nginx:1.25"},
+		Status: kuikv1alpha1.CachedImageStatus{UsedBy: kuikv1alpha1.UsedBy{
+			Pods:  []kuikv1alpha1.PodReference{{NamespacedName: "shop/api-0"}, {NamespacedName: "shop/api-1"}},
+			Count: 2,
+		}},
+	}
+
+	// Events are recorded on the CachedImage and on the pods using it that still exist
+	reconciler.recordCachingEvent(context.Background(), cachedImage, "Warning", "CacheFailed", "Failed to cache image %s, reason: %s", "nginx:1.25", "unauthorized")
+	g.Expect(recorder.Events).To(HaveLen(2))
+	g.Expect(<-recorder.Events).To(Equal("Warning CacheFailed Failed to cache image nginx:1.25, reason: unauthorized"))
+	g.Expect(<-recorder.Events).To(Equal("Warning CacheFailed Failed to cache image nginx:1.25, reason: unauthorized"))
+}
@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+)
+
+// admissionToCachingStartDelay returns the delay between the admission of the pod a CachedImage has been created for
+// and now, when its caching starts for the first time. It returns false if the admission time of the pod is unknown,
+// e.g. for CachedImages created by an Application, or if caching has already been attempted, e.g. when it is retried
+// after a failure.
+func admissionToCachingStartDelay(cachedImage *kuikv1alpha1.CachedImage, now time.Time) (time.Duration, bool) {
+	admittedAt, err := time.Parse(time.RFC3339Nano, cachedImage.Annotations[AnnotationAdmittedAtName])
+	if err != nil {
+		return 0, false
+	}
+
+	// Images waiting for their turn, for their upstream registry to be resumed or for the air-gapped mode to be disabled
+	// have not been attempted yet
+	if condition := meta.FindStatusCondition(cachedImage.Status.Conditions, kuikv1alpha1.ConditionCaching); condition != nil &&
+		condition.Reason != "Queued" && condition.Reason != "UpstreamPaused" && condition.Reason != "NotCacheable" {
+		return 0, false
+	}
+
+	return now.Sub(admittedAt), true
+}
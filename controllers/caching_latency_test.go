@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAdmissionToCachingStartDelay(t *testing.T) {
+	now := time.Date(2024, 1, 15, 8, 4, 13, 0, time.UTC)
+	admittedAt := map[string]string{AnnotationAdmittedAtName: "2024-01-15T08:04:12.75Z"}
+
+	tests := []struct {
+		name            string
+		annotations     map[string]string
+		cachingReason   string
+		expectedDelay   time.Duration
+		expectedMeasure bool
+	}{
+		{
+			name:            "First attempt",
+			annotations:     admittedAt,
+			expectedDelay:   250 * time.Millisecond,
+			expectedMeasure: true,
+		},
+		{
+			name:            "Queued",
+			annotations:     admittedAt,
+			cachingReason:   "Queued",
+			expectedDelay:   250 * time.Millisecond,
+			expectedMeasure: true,
+		},
+		{
+			name:          "Retried after a failure",
+			annotations:   admittedAt,
+			cachingReason: kuikv1alpha1.ReasonCacheFailed,
+		},
+		{
+			name: "Unknown admission time",
+		},
+		{
+			name:        "Invalid admission time",
+			annotations: map[string]string{AnnotationAdmittedAtName: "yesterday"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			cachedImage := &kuikv1alpha1.CachedImage{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}}
+			if tt.cachingReason != "" {
+				setCondition(cachedImage, kuikv1alpha1.ConditionCaching, metav1.ConditionFalse, tt.cachingReason, "")
+			}
+
+			delay, ok := admissionToCachingStartDelay(cachedImage, now)
+			g.Expect(ok).To(Equal(tt.expectedMeasure))
+			g.Expect(delay).To(Equal(tt.expectedDelay))
+		})
+	}
+}
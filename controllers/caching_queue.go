@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+)
+
+// cachingQueueRecheckInterval is how often CachedImages waiting in the caching queue check whether their turn has come.
+// They are reconciled as soon as an image leaves the queue, this is only a safety net in case such an event is missed.
+const cachingQueueRecheckInterval = time.Minute
+
+// CachingQueue orders the CachedImages waiting to be put in cache by priority, then by the time they have been queued
+// at. Both are recorded in the CachedImages themselves, so that caching resumes in the same order after a restart of
+// the controllers, e.g. in the middle of a large prefetch, instead of the arbitrary order of the initial list.
+type CachingQueue struct {
+	client.Reader
+	// Slots is the number of images put in cache at the same time, i.e. the maximum number of concurrent reconciles
+	Slots int
+}
+
+// NewCachingQueue returns a CachingQueue, or nil if slots is not positive
+func NewCachingQueue(reader client.Reader, slots int) *CachingQueue {
+	if slots <= 0 {
+		return nil
+	}
+
+	return &CachingQueue{Reader: reader, Slots: slots}
+}
+
+// Turn tells whether cachedImage may be put in cache now, i.e. whether fewer than Slots queued CachedImages are to be
+// put in cache before it, along with the number of such images
+func (q *CachingQueue) Turn(ctx context.Context, cachedImage *kuikv1alpha1.CachedImage) (bool, int, error) {
+	if q == nil || cachedImage.Status.QueuedAt == nil {
+		return true, 0, nil
+	}
+
+	var cachedImages kuikv1alpha1.CachedImageList
+	if err := q.List(ctx, &cachedImages); err != nil {
+		return false, 0, err
+	}
+
+	ahead := 0
+	for i := range cachedImages.Items {
+		other := &cachedImages.Items[i]
+		if other.Name != cachedImage.Name && isQueued(other) && !waitsForUpstream(other) && queuedBefore(other, cachedImage) {
+			ahead++
+		}
+	}
+
+	return ahead < q.Slots, ahead, nil
+}
+
+// isQueued tells whether a CachedImage is waiting to be put in cache. Expiring images are not cached until they are
+// used again and images being deleted won't be cached at all, they must not hold back the queue.
+func isQueued(cachedImage *kuikv1alpha1.CachedImage) bool {
+	return cachedImage.Status.QueuedAt != nil && cachedImage.Spec.ExpiresAt == nil && cachedImage.DeletionTimestamp.IsZero()
+}
+
+// waitsForUpstream tells whether a queued CachedImage waits for its upstream registry to resume, e.g. an image queued
+// before the pause. It can't be cached meanwhile, it must not take a slot.
+func waitsForUpstream(cachedImage *kuikv1alpha1.CachedImage) bool {
+	caching := meta.FindStatusCondition(cachedImage.Status.Conditions, kuikv1alpha1.ConditionCaching)
+	return caching != nil && caching.Reason == "UpstreamPaused"
+}
+
+// queuedBefore tells whether a must be put in cache before b
+func queuedBefore(a *kuikv1alpha1.CachedImage, b *kuikv1alpha1.CachedImage) bool {
+	if a.Spec.Priority != b.Spec.Priority {
+		return a.Spec.Priority > b.Spec.Priority
+	}
+	if !a.Status.QueuedAt.Equal(b.Status.QueuedAt) {
+		return a.Status.QueuedAt.Before(b.Status.QueuedAt)
+	}
+	return a.Name < b.Name
+}
+
+// Next returns the requests of the first Slots CachedImages waiting in the queue, whose turn may have come. It is
+// called when a CachedImage leaves the queue, so that the next ones start caching right away. Images waiting for their
+// upstream registry to resume are skipped, they would leave the queue stalled.
+func (q *CachingQueue) Next(obj client.Object) []ctrl.Request {
+	var cachedImages kuikv1alpha1.CachedImageList
+	if err := q.List(context.Background(), &cachedImages); err != nil {
+		log.FromContext(context.Background()).Error(err, "could not list queued cachedimages")
+		return nil
+	}
+
+	queued := []*kuikv1alpha1.CachedImage{}
+	for i := range cachedImages.Items {
+		cachedImage := &cachedImages.Items[i]
+		if cachedImage.Name != obj.GetName() && isQueued(cachedImage) && !waitsForUpstream(cachedImage) {
+			queued = append(queued, cachedImage)
+		}
+	}
+	sort.Slice(queued, func(i, j int) bool {
+		return queuedBefore(queued[i], queued[j])
+	})
+
+	requests := []ctrl.Request{}
+	for i := 0; i < len(queued) && i < q.Slots; i++ {
+		requests = append(requests, ctrl.Request{NamespacedName: types.NamespacedName{Name: queued[i].Name}})
+	}
+
+	return requests
+}
+
+// dequeuedPredicate matches CachedImages leaving the caching queue, i.e. put in cache, failed, expiring, deleted or
+// waiting for their upstream registry to resume
+var dequeuedPredicate = predicate.Funcs{
+	CreateFunc: func(e event.CreateEvent) bool {
+		return false
+	},
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		before, after := e.ObjectOld.(*kuikv1alpha1.CachedImage), e.ObjectNew.(*kuikv1alpha1.CachedImage)
+		return isQueued(before) && !waitsForUpstream(before) && (!isQueued(after) || waitsForUpstream(after))
+	},
+	DeleteFunc: func(e event.DeleteEvent) bool {
+		return isQueued(e.Object.(*kuikv1alpha1.CachedImage))
+	},
+	GenericFunc: func(e event.GenericEvent) bool {
+		return false
+	},
+}
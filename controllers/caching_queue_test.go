@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestCachingQueueTurn(t *testing.T) {
+	now := time.Now()
+	queuedAt := func(minutes int) *metav1.Time {
+		return &metav1.Time{Time: now.Add(time.Duration(minutes) * time.Minute)}
+	}
+	cachedImage := func(name string, priority int32, queuedAt *metav1.Time) *kuikv1alpha1.CachedImage {
+		return &kuikv1alpha1.CachedImage{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       kuikv1alpha1.CachedImageSpec{Priority: priority},
+			Status:     kuikv1alpha1.CachedImageStatus{QueuedAt: queuedAt},
+		}
+	}
+	expiring := cachedImage("expiring", 0, queuedAt(0))
+	expiring.Spec.ExpiresAt = queuedAt(60)
+	paused := cachedImage("paused", 0, queuedAt(0))
+	paused.Status.Conditions = []metav1.Condition{{Type: kuikv1alpha1.ConditionCaching, Status: metav1.ConditionFalse, Reason: "UpstreamPaused"}}
+
+	cachedImages := []*kuikv1alpha1.CachedImage{
+		cachedImage("first", 0, queuedAt(1)),
+		cachedImage("second", 0, queuedAt(2)),
+		cachedImage("third", 0, queuedAt(3)),
+		cachedImage("urgent", 10, queuedAt(4)),
+		cachedImage("cached", 0, nil),
+		expiring,
+		paused,
+	}
+	objects := []client.Object{}
+	for _, cachedImage := range cachedImages {
+		objects = append(objects, cachedImage)
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(objects...).Build()
+
+	tests := []struct {
+		name          string
+		cachedImage   *kuikv1alpha1.CachedImage
+		expectedTurn  bool
+		expectedAhead int
+	}{
+		{name: "Highest priority", cachedImage: cachedImages[3], expectedTurn: true, expectedAhead: 0},
+		{name: "Queued first", cachedImage: cachedImages[0], expectedTurn: true, expectedAhead: 1},
+		{name: "Queued second", cachedImage: cachedImages[1], expectedTurn: false, expectedAhead: 2},
+		{name: "Queued third", cachedImage: cachedImages[2], expectedTurn: false, expectedAhead: 3},
+		{name: "Not queued", cachedImage: cachedImages[4], expectedTurn: true, expectedAhead: 0},
+	}
+
+	queue := NewCachingQueue(k8sClient, 2)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			turn, ahead, err := queue.Turn(context.Background(), tt.cachedImage)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(turn).To(Equal(tt.expectedTurn))
+			g.Expect(ahead).To(Equal(tt.expectedAhead))
+		})
+	}
+
+	g := NewWithT(t)
+	var disabled *CachingQueue
+	g.Expect(NewCachingQueue(k8sClient, 0)).To(BeNil())
+	turn, _, err := disabled.Turn(context.Background(), cachedImages[2])
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(turn).To(BeTrue())
+}
+
+func TestCachingQueueNext(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Now()
+	cachedImage := func(name string, priority int32, queuedAt *metav1.Time) *kuikv1alpha1.CachedImage {
+		return &kuikv1alpha1.CachedImage{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       kuikv1alpha1.CachedImageSpec{Priority: priority},
+			Status:     kuikv1alpha1.CachedImageStatus{QueuedAt: queuedAt},
+		}
+	}
+	first := cachedImage("first", 0, &metav1.Time{Time: now})
+	paused := cachedImage("paused", 20, &metav1.Time{Time: now})
+	paused.Status.Conditions = []metav1.Condition{{Type: kuikv1alpha1.ConditionCaching, Status: metav1.ConditionFalse, Reason: "UpstreamPaused"}}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(
+		first,
+		paused,
+		cachedImage("second", 0, &metav1.Time{Time: now.Add(time.Minute)}),
+		cachedImage("third", 0, &metav1.Time{Time: now.Add(2 * time.Minute)}),
+		cachedImage("urgent", 10, &metav1.Time{Time: now.Add(3 * time.Minute)}),
+		cachedImage("cached", 0, nil),
+	).Build()
+
+	// The images whose turn may have come are reconciled once an image leaves the queue, skipping the ones waiting for
+	// their upstream registry
+	queue := NewCachingQueue(k8sClient, 2)
+	g.Expect(queue.Next(first)).To(Equal([]ctrl.Request{
+		{NamespacedName: types.NamespacedName{Name: "urgent"}},
+		{NamespacedName: types.NamespacedName{Name: "second"}},
+	}))
+
+	cached := first.DeepCopy()
+	cached.Status.QueuedAt = nil
+	g.Expect(dequeuedPredicate.Update(event.UpdateEvent{ObjectOld: first, ObjectNew: cached})).To(BeTrue())
+	g.Expect(dequeuedPredicate.Update(event.UpdateEvent{ObjectOld: first, ObjectNew: first})).To(BeFalse())
+	pausedFirst := first.DeepCopy()
+	pausedFirst.Status.Conditions = paused.Status.Conditions
+	g.Expect(dequeuedPredicate.Update(event.UpdateEvent{ObjectOld: first, ObjectNew: pausedFirst})).To(BeTrue())
+	g.Expect(dequeuedPredicate.Update(event.UpdateEvent{ObjectOld: paused, ObjectNew: cached})).To(BeFalse())
+	g.Expect(dequeuedPredicate.Delete(event.DeleteEvent{Object: first})).To(BeTrue())
+	g.Expect(dequeuedPredicate.Delete(event.DeleteEvent{Object: cached})).To(BeFalse())
+}
@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// clusterUpgradeRecheckInterval is how often the expiry of CachedImages paused by a cluster upgrade is checked again
+const clusterUpgradeRecheckInterval = time.Minute
+
+var errClusterUpgrading = errors.New("cluster upgrade in progress")
+
+// UpgradeDetector detects cluster upgrades, during which many nodes are cordoned and drained at once. Since pods
+// rescheduled on other nodes pull their images again, the cache must be complete at that time: expiry of CachedImages
+// and registry garbage collections are paused while an upgrade is in progress.
+type UpgradeDetector struct {
+	client.Reader
+	// Threshold is the ratio of unschedulable nodes from which an upgrade is considered in progress
+	Threshold float64
+	// Cooldown is how long an upgrade is still considered in progress once nodes are schedulable again, while pods are
+	// still being rescheduled
+	Cooldown time.Duration
+
+	mutex          sync.Mutex
+	lastDetectedAt time.Time
+	now            func() time.Time
+}
+
+// NewUpgradeDetector returns an UpgradeDetector, or nil if the threshold is not positive
+func NewUpgradeDetector(reader client.Reader, threshold float64, cooldown time.Duration) *UpgradeDetector {
+	if threshold <= 0 {
+		return nil
+	}
+
+	return &UpgradeDetector{
+		Reader:    reader,
+		Threshold: threshold,
+		Cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// InProgress tells whether a cluster upgrade is in progress, i.e. whether the ratio of unschedulable nodes reaches the
+// threshold or did so less than Cooldown ago
+func (d *UpgradeDetector) InProgress(ctx context.Context) (bool, error) {
+	if d == nil {
+		return false, nil
+	}
+
+	var nodes corev1.NodeList
+	if err := d.List(ctx, &nodes); err != nil {
+		return false, err
+	}
+
+	unschedulable := 0
+	for _, node := range nodes.Items {
+		if isUnschedulable(&node) {
+			unschedulable++
+		}
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := d.now()
+	detected := unschedulable > 0 && float64(unschedulable) >= d.Threshold*float64(len(nodes.Items))
+	if detected {
+		d.lastDetectedAt = now
+	}
+
+	inProgress := detected || (!d.lastDetectedAt.IsZero() && now.Before(d.lastDetectedAt.Add(d.Cooldown)))
+	if inProgress {
+		clusterUpgradeInProgress.Set(1)
+	} else {
+		clusterUpgradeInProgress.Set(0)
+	}
+
+	return inProgress, nil
+}
+
+// isUnschedulable tells whether a node is cordoned, either by kubectl or by a cluster upgrade tool tainting it
+func isUnschedulable(node *corev1.Node) bool {
+	if node.Spec.Unschedulable {
+		return true
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == corev1.TaintNodeUnschedulable {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestUpgradeDetector(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	nodes := []client.Object{}
+	for i := 0; i < 10; i++ {
+		nodes = append(nodes, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("node-%d", i)}})
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(nodes...).Build()
+
+	now := time.Now()
+	detector := NewUpgradeDetector(k8sClient, 0.2, 30*time.Minute)
+	detector.now = func() time.Time { return now }
+
+	setUnschedulable := func(name string, unschedulable bool) {
+		var node corev1.Node
+		g.Expect(k8sClient.Get(ctx, client.ObjectKey{Name: name}, &node)).To(Succeed())
+		node.Spec.Unschedulable = unschedulable
+		g.Expect(k8sClient.Update(ctx, &node)).To(Succeed())
+	}
+
+	g.Expect(detector.InProgress(ctx)).To(BeFalse())
+
+	// A single cordoned node is regular maintenance
+	setUnschedulable("node-0", true)
+	g.Expect(detector.InProgress(ctx)).To(BeFalse())
+
+	setUnschedulable("node-1", true)
+	g.Expect(detector.InProgress(ctx)).To(BeTrue())
+
+	// Pods are still being rescheduled once nodes are schedulable again
+	setUnschedulable("node-0", false)
+	setUnschedulable("node-1", false)
+	now = now.Add(10 * time.Minute)
+	g.Expect(detector.InProgress(ctx)).To(BeTrue())
+
+	now = now.Add(30 * time.Minute)
+	g.Expect(detector.InProgress(ctx)).To(BeFalse())
+
+	// Nodes tainted by upgrade tools are unschedulable too
+	for _, name := range []string{"node-2", "node-3"} {
+		var node corev1.Node
+		g.Expect(k8sClient.Get(ctx, client.ObjectKey{Name: name}, &node)).To(Succeed())
+		node.Spec.Taints = []corev1.Taint{{Key: corev1.TaintNodeUnschedulable, Effect: corev1.TaintEffectNoSchedule}}
+		g.Expect(k8sClient.Update(ctx, &node)).To(Succeed())
+	}
+	g.Expect(detector.InProgress(ctx)).To(BeTrue())
+
+	// Upgrade detection is disabled without a threshold
+	g.Expect(NewUpgradeDetector(k8sClient, 0, time.Minute)).To(BeNil())
+	var disabled *UpgradeDetector
+	g.Expect(disabled.InProgress(ctx)).To(BeFalse())
+}
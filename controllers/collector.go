@@ -6,6 +6,7 @@ import (
 
 	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
 	kuikMetrics "github.com/enix/kube-image-keeper/internal/metrics"
+	"github.com/enix/kube-image-keeper/internal/registry"
 	"github.com/prometheus/client_golang/prometheus"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
@@ -33,6 +34,110 @@ var (
 			Help:      "Number of images removed from cache successfully",
 		},
 	)
+	imageCacheFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: kuikMetrics.Namespace,
+			Subsystem: subsystem,
+			Name:      "image_cache_failures_total",
+			Help:      "Number of failures to cache or refresh an image, by failure class (auth, not-found, rate-limit, network, limit-exceeded, policy, storage-full or unknown)",
+		},
+		[]string{"class", "operation"},
+	)
+	garbageCollectionPendingDeletions = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: kuikMetrics.Namespace,
+		Subsystem: subsystem,
+		Name:      "registry_garbage_collection_pending_deletions",
+		Help:      "Number of images removed from cache since the last registry garbage collection triggered by the controller",
+	})
+	garbageCollections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: kuikMetrics.Namespace,
+			Subsystem: subsystem,
+			Name:      "registry_garbage_collections_total",
+			Help:      "Number of registry garbage collections triggered by the controller",
+		},
+		[]string{"result"},
+	)
+	upstreamBudgetExceeded = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: kuikMetrics.Namespace,
+			Subsystem: subsystem,
+			Name:      "upstream_budget_exceeded_total",
+			Help:      "Number of times caching or refreshing an image has been delayed because the upstream pull budget was exhausted",
+		},
+	)
+	upstreamBudgetManifestsUsed = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: kuikMetrics.Namespace,
+		Subsystem: subsystem,
+		Name:      "upstream_budget_manifests_used",
+		Help:      "Number of manifests pulled from upstream registries during the current window of the manifests budget",
+	}, func() float64 {
+		manifests, _ := registry.UpstreamBudget.Used()
+		return float64(manifests)
+	})
+	upstreamBudgetBytesUsed = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: kuikMetrics.Namespace,
+		Subsystem: subsystem,
+		Name:      "upstream_budget_bytes_used",
+		Help:      "Number of bytes pulled from upstream registries during the current window of the bytes budget",
+	}, func() float64 {
+		_, bytes := registry.UpstreamBudget.Used()
+		return float64(bytes)
+	})
+	cacheSizeBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: kuikMetrics.Namespace,
+		Subsystem: subsystem,
+		Name:      "cache_size_bytes",
+		Help:      "Sum of the sizes of cached images, as counted against the cache quota",
+	})
+	imageEvicted = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: kuikMetrics.Namespace,
+			Subsystem: subsystem,
+			Name:      "image_evicted_total",
+			Help:      "Number of least recently used images evicted from cache because the cache exceeded its quota",
+		},
+	)
+	imageScans = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: kuikMetrics.Namespace,
+			Subsystem: subsystem,
+			Name:      "image_scans_total",
+			Help:      "Number of vulnerability scans of images put in cache, by result (clean, vulnerable, blocked or failed)",
+		},
+		[]string{"result"},
+	)
+	admissionToCachingStart = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: kuikMetrics.Namespace,
+		Subsystem: subsystem,
+		Name:      "admission_to_caching_start_seconds",
+		Help:      "Delay between the admission of a pod by the webhook and the start of the caching of the images it requested",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300},
+	})
+	registryMigrationMissingImages = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: kuikMetrics.Namespace,
+		Subsystem: subsystem,
+		Name:      "registry_migration_missing_images",
+		Help:      "Number of cached images not yet present in the current cache registry while migrating from the previous one",
+	})
+	clusterUpgradeInProgress = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: kuikMetrics.Namespace,
+		Subsystem: subsystem,
+		Name:      "cluster_upgrade_in_progress",
+		Help:      "Whether or not a cluster upgrade is detected, pausing expiry of images and registry garbage collections. 1 if it is, 0 otherwise.",
+	})
+	registryHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: kuikMetrics.Namespace,
+		Subsystem: subsystem,
+		Name:      "registry_healthy",
+		Help:      "Whether or not the cache registry and its storage backend answer. 1 if they do, 0 otherwise.",
+	}, []string{"storage"})
+	cachingPaused = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: kuikMetrics.Namespace,
+		Subsystem: subsystem,
+		Name:      "caching_paused",
+		Help:      "Whether or not the caching of new images is paused by the backpressure of the cache registry, by reason (storage or latency). 1 if it is, 0 otherwise.",
+	}, []string{"reason"})
 	isLeader = prometheus.NewGauge(prometheus.GaugeOpts{
 		Namespace: kuikMetrics.Namespace,
 		Subsystem: subsystem,
@@ -61,6 +166,20 @@ func RegisterMetrics(client client.Client) {
 	metrics.Registry.MustRegister(
 		imagePutInCache,
 		imageRemovedFromCache,
+		imageCacheFailures,
+		garbageCollectionPendingDeletions,
+		garbageCollections,
+		upstreamBudgetExceeded,
+		upstreamBudgetManifestsUsed,
+		upstreamBudgetBytesUsed,
+		cacheSizeBytes,
+		imageEvicted,
+		imageScans,
+		admissionToCachingStart,
+		registryMigrationMissingImages,
+		clusterUpgradeInProgress,
+		registryHealthy,
+		cachingPaused,
 		kuikMetrics.NewInfo(subsystem),
 		isLeader,
 		up,
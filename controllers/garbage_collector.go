@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const garbageCollectionPollInterval = 10 * time.Second
+
+var errGarbageCollectionRunning = errors.New("registry garbage collection already running")
+
+// GarbageCollector runs a Job from the registry garbage collection CronJob once enough images have been
+// removed from the cache, so that deleting CachedImages actually reclaims disk space without waiting for
+// the next scheduled run.
+type GarbageCollector struct {
+	client.Client
+	ApiReader   client.Reader
+	Recorder    record.EventRecorder
+	Namespace   string
+	CronJobName string
+	// Threshold is the number of images removed from the cache that triggers a garbage collection
+	Threshold int
+	// UpgradeDetector delays garbage collections during cluster upgrades, they are never delayed if nil
+	UpgradeDetector *UpgradeDetector
+
+	mutex        sync.Mutex
+	pending      int
+	trigger      chan struct{}
+	pollInterval time.Duration
+}
+
+// NewGarbageCollector returns a GarbageCollector, or nil if the threshold is not positive or no CronJob is given
+func NewGarbageCollector(k8sClient client.Client, apiReader client.Reader, recorder record.EventRecorder, namespace string, cronJobName string, threshold int) *GarbageCollector {
+	if threshold <= 0 || cronJobName == "" {
+		return nil
+	}
+
+	return &GarbageCollector{
+		Client:       k8sClient,
+		ApiReader:    apiReader,
+		Recorder:     recorder,
+		Namespace:    namespace,
+		CronJobName:  cronJobName,
+		Threshold:    threshold,
+		trigger:      make(chan struct{}, 1),
+		pollInterval: garbageCollectionPollInterval,
+	}
+}
+
+//+kubebuilder:rbac:groups=batch,resources=cronjobs,verbs=get;list;watch
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create
+
+// ImageRemoved records the removal of an image from the cache
+func (g *GarbageCollector) ImageRemoved() {
+	if g == nil {
+		return
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.pending++
+	garbageCollectionPendingDeletions.Set(float64(g.pending))
+
+	if g.pending >= g.Threshold {
+		g.triggerNow()
+	}
+}
+
+func (g *GarbageCollector) triggerNow() {
+	select {
+	case g.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Start implements manager.Runnable
+func (g *GarbageCollector) Start(ctx context.Context) error {
+	log := log.FromContext(ctx).WithName("garbage-collector")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-g.trigger:
+		}
+
+		g.mutex.Lock()
+		pending := g.pending
+		g.pending = 0
+		garbageCollectionPendingDeletions.Set(0)
+		g.mutex.Unlock()
+
+		err := g.collect(ctx, pending)
+		if errors.Is(err, errGarbageCollectionRunning) || errors.Is(err, errClusterUpgrading) {
+			// A garbage collection started before these removals may not reclaim them, retry once it is over. The
+			// registry is read-only while garbage collecting, which must not happen while pods are rescheduled.
+			log.Info("registry garbage collection delayed, retrying later", "reason", err.Error())
+			g.mutex.Lock()
+			g.pending += pending
+			garbageCollectionPendingDeletions.Set(float64(g.pending))
+			g.mutex.Unlock()
+			time.AfterFunc(g.pollInterval, g.triggerNow)
+		} else if err != nil {
+			log.Error(err, "registry garbage collection failed", "removedImages", pending)
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable
+func (g *GarbageCollector) NeedLeaderElection() bool {
+	return true
+}
+
+func (g *GarbageCollector) collect(ctx context.Context, removedImages int) error {
+	log := log.FromContext(ctx).WithName("garbage-collector")
+
+	if upgrading, err := g.UpgradeDetector.InProgress(ctx); err != nil {
+		return err
+	} else if upgrading {
+		return errClusterUpgrading
+	}
+
+	var cronJob batchv1.CronJob
+	if err := g.ApiReader.Get(ctx, types.NamespacedName{Namespace: g.Namespace, Name: g.CronJobName}, &cronJob); err != nil {
+		return err
+	}
+
+	// The CronJob forbids concurrency and so do we
+	if len(cronJob.Status.Active) > 0 {
+		return errGarbageCollectionRunning
+	}
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: cronJob.Name + "-",
+			Namespace:    cronJob.Namespace,
+			Labels:       cronJob.Spec.JobTemplate.Labels,
+			Annotations: map[string]string{
+				"cronjob.kubernetes.io/instantiate": "manual",
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(&cronJob, batchv1.SchemeGroupVersion.WithKind("CronJob")),
+			},
+		},
+		Spec: cronJob.Spec.JobTemplate.Spec,
+	}
+	if err := g.Create(ctx, job); err != nil {
+		return err
+	}
+
+	log.Info("registry garbage collection started", "job", klog.KObj(job), "removedImages", removedImages)
+	g.Recorder.Eventf(&cronJob, "Normal", "GarbageCollecting", "Started job %s after %d images were removed from cache", job.Name, removedImages)
+
+	start := time.Now()
+	ticker := time.NewTicker(g.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		if err := g.ApiReader.Get(ctx, client.ObjectKeyFromObject(job), job); err != nil {
+			return err
+		}
+
+		for _, condition := range job.Status.Conditions {
+			if condition.Status != "True" {
+				continue
+			}
+			switch condition.Type {
+			case batchv1.JobComplete:
+				log.Info("registry garbage collection succeeded", "job", klog.KObj(job), "duration", time.Since(start))
+				g.Recorder.Eventf(&cronJob, "Normal", "GarbageCollected", "Job %s succeeded in %s", job.Name, time.Since(start).Round(time.Second))
+				garbageCollections.WithLabelValues("success").Inc()
+				return nil
+			case batchv1.JobFailed:
+				g.Recorder.Eventf(&cronJob, "Warning", "GarbageCollectionFailed", "Job %s failed: %s", job.Name, condition.Message)
+				garbageCollections.WithLabelValues("failure").Inc()
+				return fmt.Errorf("job %s failed: %s", job.Name, condition.Message)
+			}
+		}
+
+		log.Info("registry garbage collection in progress", "job", klog.KObj(job), "active", job.Status.Active, "failed", job.Status.Failed, "elapsed", time.Since(start).Round(time.Second))
+	}
+}
@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newGarbageCollectionCronJob() *batchv1.CronJob {
+	return &batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-image-keeper-registry-garbage-collection", Namespace: "kuik-system"},
+		Spec: batchv1.CronJobSpec{
+			Schedule: "0 0 * * 0",
+			JobTemplate: batchv1.JobTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "kube-image-keeper-registry-gc"}},
+				Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers:    []corev1.Container{{Name: "garbage-collect", Image: "registry:2"}},
+				}}},
+			},
+		},
+	}
+}
+
+func newTestGarbageCollector(objects ...client.Object) (*GarbageCollector, *record.FakeRecorder) {
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(objects...).Build()
+	recorder := record.NewFakeRecorder(10)
+	garbageCollector := NewGarbageCollector(k8sClient, k8sClient, recorder, "kuik-system", "kube-image-keeper-registry-garbage-collection", 3)
+	garbageCollector.pollInterval = 10 * time.Millisecond
+	return garbageCollector, recorder
+}
+
+// finishGarbageCollectionJob waits for the garbage collector to start a Job and sets the given condition on it
+func finishGarbageCollectionJob(g *WithT, k8sClient client.Client, condition batchv1.JobCondition) *batchv1.Job {
+	var jobs batchv1.JobList
+	g.Eventually(func() []batchv1.Job {
+		g.Expect(k8sClient.List(context.Background(), &jobs, client.InNamespace("kuik-system"))).To(Succeed())
+		return jobs.Items
+	}).Should(HaveLen(1))
+
+	job := &jobs.Items[0]
+	condition.Status = corev1.ConditionTrue
+	job.Status.Conditions = append(job.Status.Conditions, condition)
+	g.Expect(k8sClient.Update(context.Background(), job)).To(Succeed())
+	return job
+}
+
+func TestGarbageCollectorThreshold(t *testing.T) {
+	g := NewWithT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cronJob := newGarbageCollectionCronJob()
+	garbageCollector, recorder := newTestGarbageCollector(cronJob)
+
+	garbageCollector.ImageRemoved()
+	garbageCollector.ImageRemoved()
+	g.Expect(garbageCollector.trigger).To(BeEmpty())
+	garbageCollector.ImageRemoved()
+	g.Expect(garbageCollector.trigger).To(HaveLen(1))
+
+	go garbageCollector.Start(ctx)
+
+	job := finishGarbageCollectionJob(g, garbageCollector, batchv1.JobCondition{Type: batchv1.JobComplete})
+	g.Expect(job.Name).To(HavePrefix(cronJob.Name + "-"))
+	g.Expect(job.Labels).To(Equal(cronJob.Spec.JobTemplate.Labels))
+	g.Expect(job.Annotations).To(HaveKeyWithValue("cronjob.kubernetes.io/instantiate", "manual"))
+	g.Expect(job.OwnerReferences).To(HaveLen(1))
+	g.Expect(job.OwnerReferences[0].Kind).To(Equal("CronJob"))
+	g.Expect(job.OwnerReferences[0].Name).To(Equal(cronJob.Name))
+	g.Expect(job.Spec.Template.Spec.Containers).To(Equal(cronJob.Spec.JobTemplate.Spec.Template.Spec.Containers))
+
+	g.Eventually(recorder.Events).Should(Receive(HavePrefix("Normal GarbageCollecting Started job " + job.Name + " after 3 images were removed from cache")))
+	g.Eventually(recorder.Events).Should(Receive(HavePrefix("Normal GarbageCollected Job " + job.Name + " succeeded")))
+
+	garbageCollector.mutex.Lock()
+	defer garbageCollector.mutex.Unlock()
+	g.Expect(garbageCollector.pending).To(Equal(0))
+}
+
+func TestGarbageCollectorDeferred(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	// The CronJob is already running
+	cronJob := newGarbageCollectionCronJob()
+	cronJob.Status.Active = []corev1.ObjectReference{{Kind: "Job", Namespace: cronJob.Namespace, Name: cronJob.Name + "-28000000"}}
+	garbageCollector, _ := newTestGarbageCollector(cronJob)
+	g.Expect(garbageCollector.collect(ctx, 3)).To(MatchError(errGarbageCollectionRunning))
+
+	var jobs batchv1.JobList
+	g.Expect(garbageCollector.List(ctx, &jobs)).To(Succeed())
+	g.Expect(jobs.Items).To(BeEmpty())
+
+	// A cluster upgrade is in progress
+	garbageCollector, recorder := newTestGarbageCollector(
+		newGarbageCollectionCronJob(),
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-0"}, Spec: corev1.NodeSpec{Unschedulable: true}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}},
+	)
+	garbageCollector.UpgradeDetector = NewUpgradeDetector(garbageCollector.ApiReader, 0.5, 0)
+	g.Expect(garbageCollector.collect(ctx, 3)).To(MatchError(errClusterUpgrading))
+
+	g.Expect(garbageCollector.List(ctx, &jobs)).To(Succeed())
+	g.Expect(jobs.Items).To(BeEmpty())
+
+	// Deferred removals are kept until the garbage collection can run
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		garbageCollector.ImageRemoved()
+	}
+	go garbageCollector.Start(ctx)
+	g.Consistently(func() []batchv1.Job {
+		g.Expect(garbageCollector.List(ctx, &jobs)).To(Succeed())
+		return jobs.Items
+	}, 50*time.Millisecond).Should(BeEmpty())
+
+	var node corev1.Node
+	g.Expect(garbageCollector.Get(ctx, client.ObjectKey{Name: "node-0"}, &node)).To(Succeed())
+	node.Spec.Unschedulable = false
+	g.Expect(garbageCollector.Update(ctx, &node)).To(Succeed())
+
+	job := finishGarbageCollectionJob(g, garbageCollector, batchv1.JobCondition{Type: batchv1.JobComplete})
+	g.Eventually(recorder.Events).Should(Receive(HavePrefix("Normal GarbageCollecting Started job " + job.Name + " after 3 images were removed from cache")))
+}
+
+func TestGarbageCollectorJobFailure(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	garbageCollector, recorder := newTestGarbageCollector(newGarbageCollectionCronJob())
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- garbageCollector.collect(ctx, 5)
+	}()
+
+	job := finishGarbageCollectionJob(g, garbageCollector, batchv1.JobCondition{Type: batchv1.JobFailed, Message: "Job has reached the specified backoff limit"})
+	g.Eventually(errs).Should(Receive(MatchError("job " + job.Name + " failed: Job has reached the specified backoff limit")))
+
+	g.Expect(recorder.Events).To(Receive(HavePrefix("Normal GarbageCollecting")))
+	g.Expect(recorder.Events).To(Receive(Equal("Warning GarbageCollectionFailed Job " + job.Name + " failed: Job has reached the specified backoff limit")))
+
+	// Jobs are polled until they complete or fail
+	garbageCollector, _ = newTestGarbageCollector(newGarbageCollectionCronJob())
+	go func() {
+		errs <- garbageCollector.collect(ctx, 5)
+	}()
+	job = finishGarbageCollectionJob(g, garbageCollector, batchv1.JobCondition{Type: batchv1.JobSuspended})
+	g.Consistently(errs, 50*time.Millisecond).ShouldNot(Receive())
+
+	job.Status.Conditions = append(job.Status.Conditions, batchv1.JobCondition{Type: batchv1.JobComplete, Status: corev1.ConditionTrue})
+	g.Expect(garbageCollector.Update(ctx, job)).To(Succeed())
+	g.Eventually(errs).Should(Receive(BeNil()))
+}
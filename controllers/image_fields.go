@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// AnnotationImagePathsName is a JSONPath template, evaluated against each container of a pod, selecting the fields of
+// the containers holding images that must be rewritten and cached as well, e.g. {.args[1]} or
+// {.env[?(@.name=="HELPER_IMAGE")].value}. Several expressions can be given one after the other.
+const AnnotationImagePathsName = "kuik.enix.io/image-paths"
+
+// ImageField is a string field of a container selected by the image paths annotation of its pod
+type ImageField struct {
+	// Location is the path of the field in the container, e.g. args.1 or env.0.value
+	Location string
+	Value    string
+}
+
+// ContainerImageFields returns the string fields of a container selected by paths, the image paths annotation of its
+// pod. Fields that are not strings, or that are values of maps, are ignored.
+func ContainerImageFields(container *corev1.Container, paths string) ([]ImageField, error) {
+	if paths == "" {
+		return nil, nil
+	}
+
+	// Expressions are evaluated one by one, since an expression that doesn't match a container, e.g. selecting an
+	// argument of a container with fewer arguments, makes the evaluation of the whole template fail
+	results := [][]reflect.Value{}
+	for _, expression := range splitExpressions(paths) {
+		parser := jsonpath.New(AnnotationImagePathsName).AllowMissingKeys(true)
+		if err := parser.Parse(expression); err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: %w", AnnotationImagePathsName, err)
+		}
+		// The container is evaluated through a pointer so that the selected fields are addressable, which tells their
+		// location
+		if expressionResults, err := parser.FindResults(container); err == nil {
+			results = append(results, expressionResults...)
+		}
+	}
+
+	locations := map[uintptr]string{}
+	visitStringFields(reflect.ValueOf(container).Elem(), "", func(location string, field reflect.Value) {
+		locations[field.UnsafeAddr()] = location
+	})
+
+	fields := []ImageField{}
+	seen := map[string]bool{}
+	for _, result := range results {
+		for _, value := range result {
+			if value.Kind() != reflect.String || !value.CanAddr() {
+				continue
+			}
+			location, ok := locations[value.UnsafeAddr()]
+			if ok && !seen[location] {
+				seen[location] = true
+				fields = append(fields, ImageField{Location: location, Value: value.String()})
+			}
+		}
+	}
+	return fields, nil
+}
+
+// splitExpressions splits a JSONPath template into its {} expressions, text outside of expressions being dropped
+func splitExpressions(template string) []string {
+	expressions := []string{}
+	depth := 0
+	start := 0
+	var quote rune
+	for i, char := range template {
+		switch {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '"' || char == '\'':
+			quote = char
+		case char == '{':
+			if depth == 0 {
+				start = i
+			}
+			depth++
+		case char == '}' && depth > 0:
+			depth--
+			if depth == 0 {
+				expressions = append(expressions, template[start:i+1])
+			}
+		}
+	}
+	// Unterminated expressions are kept to be reported as invalid
+	if depth > 0 {
+		expressions = append(expressions, template[start:])
+	}
+	return expressions
+}
+
+// SetContainerFields sets the string fields of a container given by their location, see ImageField
+func SetContainerFields(container *corev1.Container, values map[string]string) {
+	visitStringFields(reflect.ValueOf(container).Elem(), "", func(location string, field reflect.Value) {
+		if value, ok := values[location]; ok {
+			field.SetString(value)
+		}
+	})
+}
+
+// visitStringFields calls visit with the string fields of value, found at location, along with their location. Fields
+// are named after their JSON name, items of slices after their index. Maps are not visited.
+func visitStringFields(value reflect.Value, location string, visit func(location string, field reflect.Value)) {
+	switch value.Kind() {
+	case reflect.Pointer:
+		if !value.IsNil() {
+			visitStringFields(value.Elem(), location, visit)
+		}
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			name, _, _ := strings.Cut(value.Type().Field(i).Tag.Get("json"), ",")
+			switch name {
+			case "-":
+			case "":
+				// Inlined fields
+				visitStringFields(value.Field(i), location, visit)
+			default:
+				visitStringFields(value.Field(i), joinLocation(location, name), visit)
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			visitStringFields(value.Index(i), joinLocation(location, strconv.Itoa(i)), visit)
+		}
+	case reflect.String:
+		visit(location, value)
+	}
+}
+
+func joinLocation(location string, key string) string {
+	if location == "" {
+		return key
+	}
+	return location + "." + key
+}
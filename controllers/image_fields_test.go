@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestContainerImageFields(t *testing.T) {
+	g := NewWithT(t)
+
+	container := &corev1.Container{
+		Name:    "runner",
+		Image:   "gitlab/gitlab-runner",
+		Command: []string{"run"},
+		Args:    []string{"--helper-image", "gitlab/gitlab-runner-helper:x86_64-latest"},
+		Env: []corev1.EnvVar{
+			{Name: "OTHER", Value: "value"},
+			{Name: "BUILD_IMAGE", Value: "golang:1.21"},
+		},
+	}
+
+	fields, err := ContainerImageFields(container, "")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(fields).To(BeEmpty())
+
+	// Several expressions can be given, fields selected several times are only returned once
+	fields, err = ContainerImageFields(container, `{.args[1]}{.env[?(@.name=="BUILD_IMAGE")].value}{.args[-1]}{.ports[0].name}`)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(fields).To(Equal([]ImageField{
+		{Location: "args.1", Value: "gitlab/gitlab-runner-helper:x86_64-latest"},
+		{Location: "env.1.value", Value: "golang:1.21"},
+	}))
+
+	_, err = ContainerImageFields(container, "{.args[")
+	g.Expect(err).To(HaveOccurred())
+
+	SetContainerFields(container, map[string]string{
+		"args.1":      "localhost:7439/gitlab/gitlab-runner-helper:x86_64-latest",
+		"env.1.value": "localhost:7439/golang:1.21",
+	})
+	g.Expect(container.Args).To(Equal([]string{"--helper-image", "localhost:7439/gitlab/gitlab-runner-helper:x86_64-latest"}))
+	g.Expect(container.Env[1].Value).To(Equal("localhost:7439/golang:1.21"))
+	g.Expect(container.Env[0].Value).To(Equal("value"))
+	g.Expect(container.Image).To(Equal("gitlab/gitlab-runner"))
+	g.Expect(container.Command).To(Equal([]string{"run"}))
+}
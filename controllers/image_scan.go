@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/enix/kube-image-keeper/internal/scan"
+)
+
+// imageScanTimeout bounds the duration of the vulnerability scan of an image, scanners downloading their vulnerability
+// database on their first run
+const imageScanTimeout = 10 * time.Minute
+
+// imageScanRetryInterval is how long after a failed scan an image is scanned again
+const imageScanRetryInterval = 15 * time.Minute
+
+// ImageScanReconciler scans images for vulnerabilities once they are put in cache and records the summary of the scan
+// in their status, blocking images if vulnerabilities of the block severity or above are found. Scans run apart from
+// the CachedImage controller, so that images waiting to be put in cache don't wait for the scans of other images.
+type ImageScanReconciler struct {
+	client.Client
+	Recorder record.EventRecorder
+	Scanner  scan.Scanner
+	// BlockSeverity blocks images with vulnerabilities of this severity or above, which the proxy refuses to serve,
+	// images are never blocked if nil
+	BlockSeverity *scan.Severity
+}
+
+//+kubebuilder:rbac:groups=kuik.enix.io,resources=cachedimages,verbs=get;list;watch
+//+kubebuilder:rbac:groups=kuik.enix.io,resources=cachedimages/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// Reconcile scans an image in cache whose digest hasn't been scanned yet. Images are only scanned again when their
+// digest changes, the block severity being applied to their last scan otherwise. A failed scan is only reported since
+// the image is in cache anyway, it is retried after imageScanRetryInterval.
+func (r *ImageScanReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var cachedImage kuikv1alpha1.CachedImage
+	if err := r.Get(ctx, req.NamespacedName, &cachedImage); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !cachedImage.Status.IsCached || cachedImage.Status.Digest == "" || !cachedImage.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	if imageScan := cachedImage.Status.Scan; imageScan != nil && imageScan.Digest == cachedImage.Status.Digest {
+		// The block severity may have changed since the image has been scanned
+		if blocked := r.blocks(imageScanSummary(imageScan)); blocked != imageScan.Blocked {
+			patch := client.MergeFrom(cachedImage.DeepCopy())
+			cachedImage.Status.Scan.Blocked = blocked
+			setCachedReadyCondition(&cachedImage)
+			cachedImage.Status.Summary = cachedImageSummary(&cachedImage)
+			return ctrl.Result{}, client.IgnoreNotFound(r.Status().Patch(ctx, &cachedImage, patch))
+		}
+		return ctrl.Result{}, nil
+	}
+
+	imageScan, err := r.scanImage(ctx, &cachedImage)
+	if err != nil {
+		return ctrl.Result{RequeueAfter: imageScanRetryInterval}, nil
+	}
+
+	// The CachedImage may have been updated during the scan, which is only recorded if its digest is still the same
+	if err := r.Get(ctx, req.NamespacedName, &cachedImage); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if cachedImage.Status.Digest != imageScan.Digest {
+		return ctrl.Result{Requeue: true}, nil
+	}
+	patch := client.MergeFrom(cachedImage.DeepCopy())
+	cachedImage.Status.Scan = imageScan
+	setCachedReadyCondition(&cachedImage)
+	cachedImage.Status.Summary = cachedImageSummary(&cachedImage)
+	return ctrl.Result{}, client.IgnoreNotFound(r.Status().Patch(ctx, &cachedImage, patch))
+}
+
+// scanImage scans an image from the cache registry by digest and returns the summary of the scan, reporting it by an
+// event
+func (r *ImageScanReconciler) scanImage(ctx context.Context, cachedImage *kuikv1alpha1.CachedImage) (*kuikv1alpha1.ImageScan, error) {
+	log := log.FromContext(ctx)
+	sourceImage := cachedImage.Spec.SourceImage
+	reference, err := registry.CachedDigestReference(sourceImage, cachedImage.Status.Digest)
+	if err != nil {
+		log.Error(err, "could not scan image")
+		return nil, err
+	}
+
+	scanCtx, cancel := context.WithTimeout(ctx, imageScanTimeout)
+	defer cancel()
+	summary, err := r.Scanner.Scan(scanCtx, scan.Image{Reference: reference, SourceImage: sourceImage, Digest: cachedImage.Status.Digest})
+	if err != nil {
+		log.Error(err, "could not scan image", "image", reference)
+		r.Recorder.Eventf(cachedImage, "Warning", "ScanFailed", "Failed to scan image %s: %s", sourceImage, err)
+		imageScans.WithLabelValues("failed").Inc()
+		return nil, err
+	}
+
+	imageScan := &kuikv1alpha1.ImageScan{
+		Digest:    cachedImage.Status.Digest,
+		ScannedAt: metav1.Now(),
+		Critical:  summary.Critical,
+		High:      summary.High,
+		Medium:    summary.Medium,
+		Low:       summary.Low,
+		Unknown:   summary.Unknown,
+		Blocked:   r.blocks(*summary),
+	}
+	log.Info("image scanned", "critical", summary.Critical, "high", summary.High, "blocked", imageScan.Blocked)
+
+	switch {
+	case imageScan.Blocked:
+		r.Recorder.Eventf(cachedImage, "Warning", "Blocked", "Image %s has %d vulnerabilities of severity %s or above (%d critical, %d high), it won't be served by the proxy",
+			sourceImage, summary.AtLeast(*r.BlockSeverity), r.BlockSeverity, summary.Critical, summary.High)
+		imageScans.WithLabelValues("blocked").Inc()
+	case summary.AtLeast(scan.SeverityUnknown) > 0:
+		r.Recorder.Eventf(cachedImage, "Normal", "Scanned", "Image %s has %d critical and %d high vulnerabilities", sourceImage, summary.Critical, summary.High)
+		imageScans.WithLabelValues("vulnerable").Inc()
+	default:
+		r.Recorder.Eventf(cachedImage, "Normal", "Scanned", "No vulnerability found in image %s", sourceImage)
+		imageScans.WithLabelValues("clean").Inc()
+	}
+
+	return imageScan, nil
+}
+
+// blocks tells whether an image is blocked given the summary of its scan
+func (r *ImageScanReconciler) blocks(summary scan.Summary) bool {
+	return r.BlockSeverity != nil && summary.AtLeast(*r.BlockSeverity) > 0
+}
+
+// setCachedReadyCondition sets the Ready condition of an image in cache, which is false if it is blocked by its last scan
+func setCachedReadyCondition(cachedImage *kuikv1alpha1.CachedImage) {
+	if cachedImage.Status.Scan != nil && cachedImage.Status.Scan.Blocked {
+		setReadyCondition(cachedImage, metav1.ConditionFalse, kuikv1alpha1.ReasonVulnerable, "Image is in cache but the proxy refuses to serve it, vulnerabilities of the block severity or above have been found")
+	} else {
+		setReadyCondition(cachedImage, metav1.ConditionTrue, "Cached", "Image is available from the cache")
+	}
+}
+
+func imageScanSummary(imageScan *kuikv1alpha1.ImageScan) scan.Summary {
+	return scan.Summary{
+		Critical: imageScan.Critical,
+		High:     imageScan.High,
+		Medium:   imageScan.Medium,
+		Low:      imageScan.Low,
+		Unknown:  imageScan.Unknown,
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager. CachedImages are only reconciled once they are put in cache
+// or when their digest changes.
+func (r *ImageScanReconciler) SetupWithManager(mgr ctrl.Manager, maxConcurrentScans int) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("image-scan").
+		For(&kuikv1alpha1.CachedImage{}, builder.WithPredicates(predicate.Funcs{
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				oldCachedImage, newCachedImage := e.ObjectOld.(*kuikv1alpha1.CachedImage), e.ObjectNew.(*kuikv1alpha1.CachedImage)
+				return oldCachedImage.Status.IsCached != newCachedImage.Status.IsCached || oldCachedImage.Status.Digest != newCachedImage.Status.Digest
+			},
+			DeleteFunc: func(e event.DeleteEvent) bool {
+				return false
+			},
+		})).
+		WithOptions(controller.Options{MaxConcurrentReconciles: maxConcurrentScans}).
+		Complete(r)
+}
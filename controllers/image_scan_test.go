@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/enix/kube-image-keeper/internal/scan"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+type fakeScanner struct {
+	summary *scan.Summary
+	err     error
+	scanned []scan.Image
+}
+
+func (f *fakeScanner) Scan(ctx context.Context, image scan.Image) (*scan.Summary, error) {
+	f.scanned = append(f.scanned, image)
+	return f.summary, f.err
+}
+
+func TestImageScanReconcile(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	endpoint := registry.Endpoint
+	defer func() { registry.Endpoint = endpoint }()
+	registry.Endpoint = "kuik-registry:5000"
+
+	cachedImage := &kuikv1alpha1.CachedImage{
+		ObjectMeta: metav1.ObjectMeta{Name: "docker.io-library-nginx-1.25"},
+		Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: "nginx:1.25"},
+		Status:     kuikv1alpha1.CachedImageStatus{IsCached: true, Digest: "sha256:0123456789012345678901234567890123456789012345678901234567890123"},
+	}
+	scanner := &fakeScanner{summary: &scan.Summary{Critical: 1, High: 2, Low: 5}}
+	high := scan.SeverityHigh
+	reconciler := &ImageScanReconciler{
+		Client:        fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(cachedImage).Build(),
+		Recorder:      record.NewFakeRecorder(10),
+		Scanner:       scanner,
+		BlockSeverity: &high,
+	}
+	reconcile := func() ctrl.Result {
+		result, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(cachedImage)})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(reconciler.Get(ctx, client.ObjectKeyFromObject(cachedImage), cachedImage)).To(Succeed())
+		return result
+	}
+
+	// Images are scanned from the cache registry by digest
+	reconcile()
+	g.Expect(scanner.scanned).To(Equal([]scan.Image{{
+		Reference:   "kuik-registry:5000/docker.io/library/nginx@" + cachedImage.Status.Digest,
+		SourceImage: "nginx:1.25",
+		Digest:      cachedImage.Status.Digest,
+	}}))
+	g.Expect(cachedImage.Status.Scan).ToNot(BeNil())
+	g.Expect(cachedImage.Status.Scan.Critical).To(Equal(1))
+	g.Expect(cachedImage.Status.Scan.High).To(Equal(2))
+	g.Expect(cachedImage.Status.Scan.Blocked).To(BeTrue())
+	g.Expect(cachedImage.Status.Conditions).To(ContainElement(HaveField("Reason", kuikv1alpha1.ReasonVulnerable)))
+
+	// Images are only scanned again when their digest changes, the block severity being applied to the last scan
+	critical := scan.SeverityCritical
+	reconciler.BlockSeverity = nil
+	reconcile()
+	g.Expect(scanner.scanned).To(HaveLen(1))
+	g.Expect(cachedImage.Status.Scan.Blocked).To(BeFalse())
+	g.Expect(cachedImage.Status.Conditions).To(ContainElement(HaveField("Reason", "Cached")))
+	reconciler.BlockSeverity = &critical
+	reconcile()
+	g.Expect(cachedImage.Status.Scan.Blocked).To(BeTrue())
+
+	// Failed scans keep the last summary and are retried later
+	scanner.err = errors.New("trivy failed")
+	cachedImage.Status.Digest = "sha256:1123456789012345678901234567890123456789012345678901234567890123"
+	g.Expect(reconciler.Status().Update(ctx, cachedImage)).To(Succeed())
+	g.Expect(reconcile().RequeueAfter).To(Equal(imageScanRetryInterval))
+	g.Expect(scanner.scanned).To(HaveLen(2))
+	g.Expect(cachedImage.Status.Scan.Digest).ToNot(Equal(cachedImage.Status.Digest))
+
+	// Images not in cache are not scanned
+	cachedImage.Status.IsCached = false
+	g.Expect(reconciler.Status().Update(ctx, cachedImage)).To(Succeed())
+	reconcile()
+	g.Expect(scanner.scanned).To(HaveLen(2))
+}
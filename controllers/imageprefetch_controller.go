@@ -0,0 +1,402 @@
+package controllers
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/prefetch"
+	"github.com/enix/kube-image-keeper/internal/registry"
+)
+
+const (
+	typeReadyImagePrefetch = "Ready"
+	typeRunImagePrefetch   = "Run"
+
+	// imagePrefetchRetryInterval is the delay before running an ImagePrefetch again when its repositories could not
+	// be listed
+	imagePrefetchRetryInterval = 5 * time.Minute
+
+	// imagePrefetchFinalizerName releases the CachedImages retained by an ImagePrefetch when it is deleted
+	imagePrefetchFinalizerName = "imageprefetch.kuik.enix.io/finalizer"
+)
+
+// ImagePrefetchReconciler reconciles an ImagePrefetch object
+type ImagePrefetchReconciler struct {
+	client.Client
+	Scheme             *runtime.Scheme
+	Recorder           record.EventRecorder
+	ImmutableTags      *regexp.Regexp
+	InsecureRegistries []string
+	RootCAs            *x509.CertPool
+	// Architectures are the platforms cached for multi-arch images, used to estimate the manifests pulled by a plan
+	Architectures []string
+}
+
+//+kubebuilder:rbac:groups=kuik.enix.io,resources=imageprefetches,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=kuik.enix.io,resources=imageprefetches/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=kuik.enix.io,resources=imageprefetches/finalizers,verbs=update
+//+kubebuilder:rbac:groups=kuik.enix.io,resources=cachedimages,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// Reconcile puts the images of an ImagePrefetch in cache when it is created or updated and each time its schedule is
+// due, and reports whether all of them are cached
+func (r *ImagePrefetchReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var imagePrefetch kuikv1alpha1.ImagePrefetch
+	if err := r.Get(ctx, req.NamespacedName, &imagePrefetch); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	log.Info("reconciling imageprefetch")
+
+	if !imagePrefetch.DeletionTimestamp.IsZero() {
+		if controllerutil.ContainsFinalizer(&imagePrefetch, imagePrefetchFinalizerName) {
+			if err := r.releaseImages(ctx, &imagePrefetch, map[string]bool{}); err != nil {
+				return ctrl.Result{}, err
+			}
+			log.Info("removing finalizer")
+			controllerutil.RemoveFinalizer(&imagePrefetch, imagePrefetchFinalizerName)
+			if err := r.Update(ctx, &imagePrefetch); err != nil {
+				return ctrl.Result{}, client.IgnoreNotFound(err)
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Add finalizer to release the images retained by the ImagePrefetch on deletion
+	if !controllerutil.ContainsFinalizer(&imagePrefetch, imagePrefetchFinalizerName) {
+		log.Info("adding finalizer")
+		controllerutil.AddFinalizer(&imagePrefetch, imagePrefetchFinalizerName)
+		if err := r.Update(ctx, &imagePrefetch); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	var cron *prefetch.Cron
+	if imagePrefetch.Spec.Schedule != "" {
+		var err error
+		if cron, err = prefetch.ParseCron(imagePrefetch.Spec.Schedule); err != nil {
+			r.Recorder.Eventf(&imagePrefetch, "Warning", "InvalidSchedule", "Schedule is invalid: %s", err)
+			imagePrefetch.Status.Phase = "Failed"
+			meta.SetStatusCondition(&imagePrefetch.Status.Conditions, metav1.Condition{
+				Type:    typeReadyImagePrefetch,
+				Status:  metav1.ConditionFalse,
+				Reason:  "InvalidSchedule",
+				Message: err.Error(),
+			})
+			return ctrl.Result{}, r.Status().Update(ctx, &imagePrefetch)
+		}
+	}
+
+	// CachedImages changing only requires to report the progress of the caching
+	now := time.Now()
+	var refreshRequestedAt *metav1.Time
+	status := &imagePrefetch.Status
+	if status.LastRunAt == nil || status.ObservedGeneration != imagePrefetch.Generation ||
+		(status.NextRunAt != nil && !now.Before(status.NextRunAt.Time)) {
+		// Images already in cache only need to be pulled again on schedule, not when the spec changes
+		scheduled := status.LastRunAt != nil && status.ObservedGeneration == imagePrefetch.Generation
+		if r.runImagePrefetch(ctx, &imagePrefetch, now, cron) {
+			if scheduled {
+				refreshRequestedAt = status.LastRunAt
+			}
+			if err := r.planImagePrefetch(ctx, &imagePrefetch, now); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	}
+
+	status.CachedImages = 0
+	wanted := map[string]bool{}
+	for _, sourceImage := range status.Images {
+		cachedImage, err := CachedImageFromSourceImage(sourceImage)
+		if err != nil {
+			r.Recorder.Eventf(&imagePrefetch, "Warning", "InvalidImage", "Image %s is invalid: %s", sourceImage, err)
+			continue
+		}
+		wanted[cachedImage.Name] = true
+
+		if err := r.Get(ctx, types.NamespacedName{Name: cachedImage.Name}, cachedImage); apierrors.IsNotFound(err) {
+			// Planned images are only put in cache once their batch starts
+			if !plannedBatchStarted(status.Plan, sourceImage, now) {
+				continue
+			}
+			log.Info("caching image", "sourceImage", sourceImage)
+			// Prefetched images are retained so that they neither expire nor get evicted before being used
+			cachedImage.Spec.Retain = true
+			cachedImage.Labels = map[string]string{kuikv1alpha1.PrefetchedByLabelName: imagePrefetch.Name}
+			if err := r.Create(ctx, cachedImage); err != nil && !apierrors.IsAlreadyExists(err) {
+				return ctrl.Result{}, err
+			}
+			continue
+		} else if err != nil {
+			return ctrl.Result{}, err
+		}
+
+		if refreshRequestedAt != nil && isMutable(cachedImage, r.ImmutableTags) &&
+			(cachedImage.Status.RefreshedAt == nil || cachedImage.Status.RefreshedAt.Before(refreshRequestedAt)) {
+			log.Info("requesting refresh of cachedimage", "cachedImage", cachedImage.Name)
+			patch := client.MergeFrom(cachedImage.DeepCopy())
+			if cachedImage.Annotations == nil {
+				cachedImage.Annotations = map[string]string{}
+			}
+			cachedImage.Annotations[kuikv1alpha1.RefreshRequestedAtAnnotationName] = refreshRequestedAt.UTC().Format(time.RFC3339)
+			if err := r.Patch(ctx, cachedImage, patch); err != nil && !apierrors.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
+		}
+		if cachedImage.Status.IsCached {
+			status.CachedImages++
+		}
+	}
+
+	if err := r.releaseImages(ctx, &imagePrefetch, wanted); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	condition := metav1.Condition{
+		Type:    typeReadyImagePrefetch,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Cached",
+		Message: "Every image is cached",
+	}
+	status.Phase = "Ready"
+	if status.LastRunAt == nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "NotRun"
+		condition.Message = "Images have not been listed yet"
+		status.Phase = "Failed"
+	} else if status.CachedImages < len(status.Images) {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Caching"
+		condition.Message = fmt.Sprintf("%d/%d images are cached", status.CachedImages, len(status.Images))
+		if nextBatch := nextPlannedBatch(status.Plan, now); !nextBatch.IsZero() {
+			condition.Message += fmt.Sprintf(", next batch planned at %s", nextBatch.UTC().Format(time.RFC3339))
+		}
+		status.Phase = "Caching"
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+
+	if err := r.Status().Update(ctx, &imagePrefetch); err != nil {
+		if apierrors.IsConflict(err) {
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	// Failed runs are retried after imagePrefetchRetryInterval rather than at the next scheduled run
+	requeueAfter := imagePrefetchRetryInterval
+	if status.LastRunAt != nil && status.ObservedGeneration == imagePrefetch.Generation {
+		if status.NextRunAt == nil {
+			requeueAfter = 0
+		} else if untilNextRun := time.Until(status.NextRunAt.Time); untilNextRun > 0 {
+			requeueAfter = untilNextRun
+		}
+	}
+	// The next batch of a plan may start before the next run
+	if nextBatch := nextPlannedBatch(status.Plan, now); !nextBatch.IsZero() {
+		untilNextBatch := time.Until(nextBatch)
+		if untilNextBatch < time.Second {
+			untilNextBatch = time.Second
+		}
+		if requeueAfter == 0 || untilNextBatch < requeueAfter {
+			requeueAfter = untilNextBatch
+		}
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// releaseImages stops retaining the CachedImages created for an ImagePrefetch that it doesn't list anymore, so that they
+// expire once unused. Images still listed by another ImagePrefetch are retained for it instead.
+func (r *ImagePrefetchReconciler) releaseImages(ctx context.Context, imagePrefetch *kuikv1alpha1.ImagePrefetch, wanted map[string]bool) error {
+	var prefetched kuikv1alpha1.CachedImageList
+	if err := r.List(ctx, &prefetched, client.MatchingLabels{kuikv1alpha1.PrefetchedByLabelName: imagePrefetch.Name}); err != nil {
+		return err
+	}
+	var imagePrefetches kuikv1alpha1.ImagePrefetchList
+	if err := r.List(ctx, &imagePrefetches); err != nil {
+		return err
+	}
+
+	for i := range prefetched.Items {
+		cachedImage := &prefetched.Items[i]
+		if wanted[cachedImage.Name] {
+			continue
+		}
+
+		patch := client.MergeFrom(cachedImage.DeepCopy())
+		if other := listedBy(imagePrefetches.Items, imagePrefetch.Name, cachedImage.Name); other != "" {
+			log.FromContext(ctx).Info("handing over prefetched image", "sourceImage", cachedImage.Spec.SourceImage, "imagePrefetch", other)
+			cachedImage.Labels[kuikv1alpha1.PrefetchedByLabelName] = other
+		} else {
+			log.FromContext(ctx).Info("releasing prefetched image", "sourceImage", cachedImage.Spec.SourceImage)
+			delete(cachedImage.Labels, kuikv1alpha1.PrefetchedByLabelName)
+			cachedImage.Spec.Retain = false
+		}
+		if err := r.Patch(ctx, cachedImage, patch); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// listedBy returns the name of an ImagePrefetch other than exclude, not being deleted, that lists the CachedImage with
+// the given name, or an empty string if there is none
+func listedBy(imagePrefetches []kuikv1alpha1.ImagePrefetch, exclude string, cachedImageName string) string {
+	for _, imagePrefetch := range imagePrefetches {
+		if imagePrefetch.Name == exclude || !imagePrefetch.DeletionTimestamp.IsZero() {
+			continue
+		}
+		for _, sourceImage := range imagePrefetch.Status.Images {
+			if cachedImage, err := CachedImageFromSourceImage(sourceImage); err == nil && cachedImage.Name == cachedImageName {
+				return imagePrefetch.Name
+			}
+		}
+	}
+	return ""
+}
+
+// runImagePrefetch updates the images of an ImagePrefetch and schedules its next run, keeping the previous images and
+// schedule if repositories can't be listed. It returns whether the run succeeded.
+func (r *ImagePrefetchReconciler) runImagePrefetch(ctx context.Context, imagePrefetch *kuikv1alpha1.ImagePrefetch, now time.Time, cron *prefetch.Cron) bool {
+	images, err := r.imagePrefetchImages(imagePrefetch)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "could not list images")
+		r.Recorder.Eventf(imagePrefetch, "Warning", "ListFailed", "Could not list images: %s", err)
+		meta.SetStatusCondition(&imagePrefetch.Status.Conditions, metav1.Condition{
+			Type:    typeRunImagePrefetch,
+			Status:  metav1.ConditionFalse,
+			Reason:  "ListFailed",
+			Message: err.Error(),
+		})
+		return false
+	}
+
+	lastRunAt := metav1.NewTime(now)
+	imagePrefetch.Status.Images = images
+	imagePrefetch.Status.LastRunAt = &lastRunAt
+	imagePrefetch.Status.NextRunAt = nil
+	if cron != nil {
+		if next := cron.Next(now); !next.IsZero() {
+			imagePrefetch.Status.NextRunAt = &metav1.Time{Time: next}
+		}
+	}
+	imagePrefetch.Status.ObservedGeneration = imagePrefetch.Generation
+	meta.SetStatusCondition(&imagePrefetch.Status.Conditions, metav1.Condition{
+		Type:    typeRunImagePrefetch,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Listed",
+		Message: fmt.Sprintf("%d images listed", len(images)),
+	})
+	return true
+}
+
+// imagePrefetchImages returns the images listed by an ImagePrefetch and the images of its repositories whose tag
+// matches their pattern, sorted and deduplicated
+func (r *ImagePrefetchReconciler) imagePrefetchImages(imagePrefetch *kuikv1alpha1.ImagePrefetch) ([]string, error) {
+	images := map[string]struct{}{}
+	for _, image := range imagePrefetch.Spec.Images {
+		images[image] = struct{}{}
+	}
+
+	var listErrors []error
+	for _, repository := range imagePrefetch.Spec.Repositories {
+		repositoryName, pattern, err := splitTagPattern(repository)
+		if err != nil {
+			listErrors = append(listErrors, err)
+			continue
+		}
+
+		tags, err := registry.RepositoryTags(repositoryName, nil, r.InsecureRegistries, r.RootCAs)
+		if err != nil {
+			listErrors = append(listErrors, fmt.Errorf("could not list tags of %s: %w", repositoryName, err))
+			continue
+		}
+
+		for _, tag := range tags {
+			if ok, _ := path.Match(pattern, tag); ok {
+				images[repositoryName+":"+tag] = struct{}{}
+			}
+		}
+	}
+	if len(listErrors) > 0 {
+		return nil, utilerrors.NewAggregate(listErrors)
+	}
+
+	sortedImages := make([]string, 0, len(images))
+	for image := range images {
+		sortedImages = append(sortedImages, image)
+	}
+	sort.Strings(sortedImages)
+
+	return sortedImages, nil
+}
+
+// splitTagPattern splits an image reference whose tag is a glob pattern into its repository and its pattern
+func splitTagPattern(reference string) (string, string, error) {
+	separator := strings.LastIndex(reference, ":")
+	if separator < 0 || separator < strings.LastIndex(reference, "/") {
+		return "", "", fmt.Errorf("repository %s has no tag pattern", reference)
+	}
+
+	repositoryName, pattern := reference[:separator], reference[separator+1:]
+	if _, err := path.Match(pattern, ""); err != nil {
+		return "", "", fmt.Errorf("invalid tag pattern %q of repository %s: %w", pattern, repositoryName, err)
+	}
+
+	return repositoryName, pattern, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ImagePrefetchReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&kuikv1alpha1.ImagePrefetch{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(
+			&source.Kind{Type: &kuikv1alpha1.CachedImage{}},
+			handler.EnqueueRequestsFromMapFunc(r.imagePrefetchesFromCachedImage),
+		).
+		Complete(r)
+}
+
+func (r *ImagePrefetchReconciler) imagePrefetchesFromCachedImage(obj client.Object) []ctrl.Request {
+	var imagePrefetchList kuikv1alpha1.ImagePrefetchList
+	if err := r.List(context.Background(), &imagePrefetchList); err != nil {
+		return nil
+	}
+
+	requests := []ctrl.Request{}
+	for _, imagePrefetch := range imagePrefetchList.Items {
+		for _, sourceImage := range imagePrefetch.Status.Images {
+			if cachedImage, err := CachedImageFromSourceImage(sourceImage); err == nil && cachedImage.Name == obj.GetName() {
+				requests = append(requests, ctrl.Request{NamespacedName: types.NamespacedName{Name: imagePrefetch.Name}})
+				break
+			}
+		}
+	}
+
+	return requests
+}
@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	"github.com/enix/kube-image-keeper/pkg/registrytest"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestImagePrefetchReconcile(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	upstream := registrytest.New(t)
+	for _, tag := range []string{"v1.0", "v1.1", "v2.0"} {
+		upstream.PushImage(t, "shop/api:"+tag, registrytest.RandomImage(t, 1))
+	}
+	api := upstream.Addr() + "/shop/api"
+
+	imagePrefetch := &kuikv1alpha1.ImagePrefetch{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Generation: 1},
+		Spec: kuikv1alpha1.ImagePrefetchSpec{
+			Images:       []string{"redis:7"},
+			Repositories: []string{api + ":v1.*"},
+			Schedule:     "0 6 * * *",
+		},
+	}
+	refreshedAt := metav1.NewTime(time.Now().Add(-time.Hour))
+	redis := &kuikv1alpha1.CachedImage{
+		ObjectMeta: metav1.ObjectMeta{Name: "docker.io-library-redis-7"},
+		Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: "redis:7"},
+		Status:     kuikv1alpha1.CachedImageStatus{IsCached: true, RefreshedAt: &refreshedAt},
+	}
+
+	reconciler := &ImagePrefetchReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(imagePrefetch, redis).Build(),
+		Recorder: record.NewFakeRecorder(10),
+	}
+	request := ctrl.Request{NamespacedName: types.NamespacedName{Name: imagePrefetch.Name}}
+	result, err := reconciler.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(BeNumerically("<=", 24*time.Hour))
+
+	// Listed images and tags matching the pattern are put in cache, without refreshing the ones already cached
+	g.Expect(reconciler.Get(ctx, request.NamespacedName, imagePrefetch)).To(Succeed())
+	g.Expect(imagePrefetch.Status.Images).To(Equal([]string{api + ":v1.0", api + ":v1.1", "redis:7"}))
+	g.Expect(imagePrefetch.Status.CachedImages).To(Equal(1))
+	g.Expect(imagePrefetch.Status.Phase).To(Equal("Caching"))
+	g.Expect(imagePrefetch.Status.NextRunAt.Hour()).To(Equal(6))
+	g.Expect(meta.IsStatusConditionTrue(imagePrefetch.Status.Conditions, typeRunImagePrefetch)).To(BeTrue())
+
+	cachedImage, err := CachedImageFromSourceImage(api + ":v1.1")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(reconciler.Get(ctx, types.NamespacedName{Name: cachedImage.Name}, cachedImage)).To(Succeed())
+	g.Expect(reconciler.imagePrefetchesFromCachedImage(cachedImage)).To(Equal([]ctrl.Request{request}))
+	g.Expect(reconciler.Get(ctx, types.NamespacedName{Name: redis.Name}, redis)).To(Succeed())
+	g.Expect(redis.Annotations).ToNot(HaveKey(kuikv1alpha1.RefreshRequestedAtAnnotationName))
+
+	// Mutable tags are pulled again from upstream once the schedule is due
+	nextRunAt := metav1.NewTime(time.Now().Add(-time.Minute))
+	imagePrefetch.Status.NextRunAt = &nextRunAt
+	g.Expect(reconciler.Status().Update(ctx, imagePrefetch)).To(Succeed())
+	_, err = reconciler.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(reconciler.Get(ctx, types.NamespacedName{Name: redis.Name}, redis)).To(Succeed())
+	g.Expect(redis.Annotations).To(HaveKey(kuikv1alpha1.RefreshRequestedAtAnnotationName))
+	g.Expect(reconciler.Get(ctx, request.NamespacedName, imagePrefetch)).To(Succeed())
+	g.Expect(imagePrefetch.Status.NextRunAt.After(time.Now())).To(BeTrue())
+
+	// Images of the last successful run are kept when repositories can't be listed
+	imagePrefetch.Spec.Repositories = []string{api}
+	imagePrefetch.Generation = 2
+	g.Expect(reconciler.Update(ctx, imagePrefetch)).To(Succeed())
+	result, err = reconciler.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(Equal(imagePrefetchRetryInterval))
+	g.Expect(reconciler.Get(ctx, request.NamespacedName, imagePrefetch)).To(Succeed())
+	g.Expect(imagePrefetch.Status.Images).To(HaveLen(3))
+	g.Expect(meta.IsStatusConditionFalse(imagePrefetch.Status.Conditions, typeRunImagePrefetch)).To(BeTrue())
+
+	// Invalid schedules are reported
+	imagePrefetch.Spec.Schedule = "every day"
+	g.Expect(reconciler.Update(ctx, imagePrefetch)).To(Succeed())
+	_, err = reconciler.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(reconciler.Get(ctx, request.NamespacedName, imagePrefetch)).To(Succeed())
+	g.Expect(imagePrefetch.Status.Phase).To(Equal("Failed"))
+	g.Expect(meta.FindStatusCondition(imagePrefetch.Status.Conditions, typeReadyImagePrefetch).Reason).To(Equal("InvalidSchedule"))
+}
+
+func TestImagePrefetchReconcile_Retain(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	imagePrefetch := &kuikv1alpha1.ImagePrefetch{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Generation: 1},
+		Spec:       kuikv1alpha1.ImagePrefetchSpec{Images: []string{"nginx:1.25", "redis:7"}},
+	}
+	other := &kuikv1alpha1.ImagePrefetch{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache", Generation: 1},
+		Spec:       kuikv1alpha1.ImagePrefetchSpec{Images: []string{"redis:7"}},
+		Status:     kuikv1alpha1.ImagePrefetchStatus{Images: []string{"redis:7"}},
+	}
+	reconciler := &ImagePrefetchReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(imagePrefetch, other).Build(),
+		Recorder: record.NewFakeRecorder(10),
+	}
+	request := ctrl.Request{NamespacedName: types.NamespacedName{Name: imagePrefetch.Name}}
+	_, err := reconciler.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Prefetched images are retained by the ImagePrefetch creating them
+	nginx := &kuikv1alpha1.CachedImage{}
+	redis := &kuikv1alpha1.CachedImage{}
+	g.Expect(reconciler.Get(ctx, types.NamespacedName{Name: "docker.io-library-nginx-1.25"}, nginx)).To(Succeed())
+	g.Expect(nginx.Spec.Retain).To(BeTrue())
+	g.Expect(nginx.Labels).To(HaveKeyWithValue(kuikv1alpha1.PrefetchedByLabelName, "shop"))
+	g.Expect(isEvictable(nginx, time.Now())).To(BeFalse())
+
+	// Images that are not listed anymore are released, or handed over to another ImagePrefetch listing them
+	g.Expect(reconciler.Get(ctx, request.NamespacedName, imagePrefetch)).To(Succeed())
+	g.Expect(imagePrefetch.Finalizers).To(ContainElement(imagePrefetchFinalizerName))
+	imagePrefetch.Spec.Images = []string{"redis:7"}
+	imagePrefetch.Generation = 2
+	g.Expect(reconciler.Update(ctx, imagePrefetch)).To(Succeed())
+	_, err = reconciler.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(reconciler.Get(ctx, types.NamespacedName{Name: nginx.Name}, nginx)).To(Succeed())
+	g.Expect(nginx.Spec.Retain).To(BeFalse())
+	g.Expect(nginx.Labels).ToNot(HaveKey(kuikv1alpha1.PrefetchedByLabelName))
+
+	g.Expect(reconciler.Get(ctx, request.NamespacedName, imagePrefetch)).To(Succeed())
+	deletedAt := metav1.Now()
+	imagePrefetch.DeletionTimestamp = &deletedAt
+	g.Expect(reconciler.Update(ctx, imagePrefetch)).To(Succeed())
+	_, err = reconciler.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(reconciler.Get(ctx, types.NamespacedName{Name: "docker.io-library-redis-7"}, redis)).To(Succeed())
+	g.Expect(redis.Spec.Retain).To(BeTrue())
+	g.Expect(redis.Labels).To(HaveKeyWithValue(kuikv1alpha1.PrefetchedByLabelName, "cache"))
+	g.Expect(apierrors.IsNotFound(reconciler.Get(ctx, request.NamespacedName, imagePrefetch))).To(BeTrue())
+}
+
+func TestImagePrefetchReconcile_Planned(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	upstream := registrytest.New(t)
+	for _, tag := range []string{"v1.0", "v1.1", "v1.2"} {
+		upstream.PushImage(t, "shop/api:"+tag, registrytest.RandomImage(t, 1))
+	}
+	upstream.SetHeader("RateLimit-Limit", "4;w=21600")
+	upstream.SetHeader("RateLimit-Remaining", "2")
+	api := upstream.Addr() + "/shop/api"
+
+	imagePrefetch := &kuikv1alpha1.ImagePrefetch{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Generation: 1},
+		Spec: kuikv1alpha1.ImagePrefetchSpec{
+			Repositories: []string{api + ":v1.*"},
+			Planned:      true,
+		},
+	}
+	reconciler := &ImagePrefetchReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(imagePrefetch).Build(),
+		Recorder: record.NewFakeRecorder(10),
+	}
+	request := ctrl.Request{NamespacedName: types.NamespacedName{Name: imagePrefetch.Name}}
+	result, err := reconciler.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(BeNumerically("~", 6*time.Hour, time.Minute))
+
+	// Each image pulls an index and a manifest, only one fits in what is left of the rate limit
+	g.Expect(reconciler.Get(ctx, request.NamespacedName, imagePrefetch)).To(Succeed())
+	plan := imagePrefetch.Status.Plan
+	g.Expect(plan).ToNot(BeNil())
+	g.Expect(plan.EstimatedManifests).To(Equal(int64(6)))
+	g.Expect(plan.Quotas).To(HaveLen(1))
+	g.Expect(plan.Quotas[0].Name).To(Equal(upstream.Addr()))
+	g.Expect(plan.Quotas[0].Remaining).To(Equal(int64(2)))
+	g.Expect(plan.Batches).To(HaveLen(2))
+	g.Expect(plan.Batches[0].Images).To(Equal([]string{api + ":v1.0"}))
+	g.Expect(plan.Batches[1].Images).To(Equal([]string{api + ":v1.1", api + ":v1.2"}))
+	g.Expect(meta.FindStatusCondition(imagePrefetch.Status.Conditions, typeReadyImagePrefetch).Message).To(ContainSubstring("next batch planned at"))
+
+	// Only the images of the first batch are put in cache
+	for i, tag := range []string{"v1.0", "v1.1"} {
+		cachedImage, err := CachedImageFromSourceImage(api + ":" + tag)
+		g.Expect(err).ToNot(HaveOccurred())
+		err = reconciler.Get(ctx, types.NamespacedName{Name: cachedImage.Name}, cachedImage)
+		if i == 0 {
+			g.Expect(err).ToNot(HaveOccurred())
+		} else {
+			g.Expect(err).To(HaveOccurred())
+		}
+	}
+}
+
+func TestSplitTagPattern(t *testing.T) {
+	g := NewWithT(t)
+
+	repositoryName, pattern, err := splitTagPattern("localhost:5000/shop/api:v1.*")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(repositoryName).To(Equal("localhost:5000/shop/api"))
+	g.Expect(pattern).To(Equal("v1.*"))
+
+	_, _, err = splitTagPattern("localhost:5000/shop/api")
+	g.Expect(err).To(HaveOccurred())
+	_, _, err = splitTagPattern("ghcr.io/enix/shop:v[1")
+	g.Expect(err).To(HaveOccurred())
+}
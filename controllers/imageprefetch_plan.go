@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/prefetch"
+	"github.com/enix/kube-image-keeper/internal/registry"
+)
+
+// planImagePrefetch reports in status the plan of the caching of the images of an ImagePrefetch missing from the cache,
+// when it is planned
+func (r *ImagePrefetchReconciler) planImagePrefetch(ctx context.Context, imagePrefetch *kuikv1alpha1.ImagePrefetch, now time.Time) error {
+	if !imagePrefetch.Spec.Planned {
+		imagePrefetch.Status.Plan = nil
+		return nil
+	}
+
+	var cachedImageList kuikv1alpha1.CachedImageList
+	if err := r.List(ctx, &cachedImageList); err != nil {
+		return err
+	}
+
+	// Images of the same repository are assumed to be about the same size
+	existing := map[string]bool{}
+	sizes := map[string]*averageSize{"": {}}
+	for _, cachedImage := range cachedImageList.Items {
+		existing[cachedImage.Name] = true
+		if !cachedImage.Status.IsCached || cachedImage.Status.Size <= 0 {
+			continue
+		}
+		ref, err := name.ParseReference(cachedImage.Spec.SourceImage)
+		if err != nil {
+			continue
+		}
+		if sizes[ref.Context().Name()] == nil {
+			sizes[ref.Context().Name()] = &averageSize{}
+		}
+		sizes[ref.Context().Name()].add(cachedImage.Status.Size)
+		sizes[""].add(cachedImage.Status.Size)
+	}
+
+	// An image index is pulled along with the manifest of each cached platform
+	manifests := int64(1 + len(r.Architectures))
+	if len(r.Architectures) == 0 {
+		manifests = 2
+	}
+
+	images := []prefetch.PlannedImage{}
+	registryImages := map[string]string{}
+	for _, sourceImage := range imagePrefetch.Status.Images {
+		cachedImage, err := CachedImageFromSourceImage(sourceImage)
+		if err != nil || existing[cachedImage.Name] {
+			continue
+		}
+		ref, err := name.ParseReference(sourceImage)
+		if err != nil {
+			continue
+		}
+
+		size := sizes[""].value()
+		if repositorySize, ok := sizes[ref.Context().Name()]; ok {
+			size = repositorySize.value()
+		}
+		registryName := ref.Context().RegistryStr()
+		images = append(images, prefetch.PlannedImage{Image: sourceImage, Registry: registryName, Manifests: manifests, Bytes: size})
+		if _, ok := registryImages[registryName]; !ok {
+			registryImages[registryName] = sourceImage
+		}
+	}
+
+	quotas := r.cachingQuotas(ctx, registryImages, now)
+	plan := &kuikv1alpha1.CachingPlan{PlannedAt: metav1.NewTime(now)}
+	for _, quota := range quotas {
+		plan.Quotas = append(plan.Quotas, kuikv1alpha1.CachingQuota{
+			Name:      quota.Name,
+			Limit:     quota.Amount,
+			Remaining: quota.Remaining,
+			ResetAt:   metav1.NewTime(quota.ResetAt),
+		})
+	}
+	for _, batch := range prefetch.PlanBatches(images, quotas, now) {
+		plan.Batches = append(plan.Batches, kuikv1alpha1.CachingBatch{
+			StartAt:            metav1.NewTime(batch.StartAt),
+			Images:             batch.Images,
+			EstimatedManifests: batch.Manifests,
+			EstimatedBytes:     batch.Bytes,
+		})
+		plan.EstimatedManifests += batch.Manifests
+		plan.EstimatedBytes += batch.Bytes
+	}
+	imagePrefetch.Status.Plan = plan
+
+	r.Recorder.Eventf(imagePrefetch, "Normal", "Planned", "Caching %d images in %d batches, pulling about %d manifests and %s from upstream registries",
+		len(images), len(plan.Batches), plan.EstimatedManifests, FormatBytes(plan.EstimatedBytes))
+	return nil
+}
+
+// cachingQuotas returns what is left of the upstream budget and of the rate limits of the registries of images, given
+// by an image of each registry. Registries whose rate limit can't be known are left out.
+func (r *ImagePrefetchReconciler) cachingQuotas(ctx context.Context, registryImages map[string]string, now time.Time) []prefetch.Quota {
+	quotas := []prefetch.Quota{}
+	for _, quota := range registry.UpstreamBudget.Quotas() {
+		quotas = append(quotas, prefetch.Quota{
+			Name:      "budget/" + quota.Resource,
+			Amount:    quota.Limit.Amount,
+			Remaining: quota.Remaining,
+			Window:    quota.Limit.Window,
+			ResetAt:   quota.ResetAt,
+			Bytes:     quota.Resource == "bytes",
+		})
+	}
+
+	for registryName, image := range registryImages {
+		rateLimit, err := registry.UpstreamRateLimit(image, r.InsecureRegistries, r.RootCAs)
+		if err != nil {
+			log.FromContext(ctx).Info("could not get the rate limit of the upstream registry, ignoring it", "registry", registryName, "error", err.Error())
+			continue
+		}
+		if rateLimit == nil {
+			continue
+		}
+		// Rate limits are usually enforced over a sliding window, pulls are only known to be possible again after a
+		// whole window
+		quotas = append(quotas, prefetch.Quota{
+			Name:      registryName,
+			Amount:    rateLimit.Limit,
+			Remaining: rateLimit.Remaining,
+			Window:    rateLimit.Window,
+			ResetAt:   now.Add(rateLimit.Window),
+			Registry:  registryName,
+		})
+	}
+	sort.Slice(quotas, func(i, j int) bool { return quotas[i].Name < quotas[j].Name })
+
+	return quotas
+}
+
+// plannedBatchStarted tells whether an image missing from the cache can be put in cache according to a plan, i.e.
+// whether its batch has started. Images out of the plan are not held back.
+func plannedBatchStarted(plan *kuikv1alpha1.CachingPlan, sourceImage string, now time.Time) bool {
+	if plan == nil {
+		return true
+	}
+	for _, batch := range plan.Batches {
+		for _, image := range batch.Images {
+			if image == sourceImage {
+				return !now.Before(batch.StartAt.Time)
+			}
+		}
+	}
+	return true
+}
+
+// nextPlannedBatch returns when the next batch of a plan starts, or the zero time if every batch has started
+func nextPlannedBatch(plan *kuikv1alpha1.CachingPlan, now time.Time) time.Time {
+	if plan == nil {
+		return time.Time{}
+	}
+	for _, batch := range plan.Batches {
+		if now.Before(batch.StartAt.Time) {
+			return batch.StartAt.Time
+		}
+	}
+	return time.Time{}
+}
+
+// averageSize is the average of image sizes
+type averageSize struct {
+	total int64
+	count int64
+}
+
+func (a *averageSize) add(size int64) {
+	a.total += size
+	a.count++
+}
+
+func (a *averageSize) value() int64 {
+	if a.count == 0 {
+		return 0
+	}
+	return a.total / a.count
+}
@@ -0,0 +1,285 @@
+package controllers
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/strings/slices"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+)
+
+const (
+	// LabelComponentName tells the component of kuik a pod belongs to
+	LabelComponentName = "app.kubernetes.io/component"
+	// LabelManagedByName is set on the NetworkPolicies managed by the controllers
+	LabelManagedByName = "app.kubernetes.io/managed-by"
+
+	// apiServerRefreshInterval is how often the addresses of the Kubernetes API server are read again, they aren't
+	// watched to avoid caching every Endpoints of the cluster
+	apiServerRefreshInterval = 5 * time.Minute
+)
+
+// NetworkPolicyReconciler maintains least-privilege NetworkPolicies for the components of kuik:
+//   - the registry only accepts connections from the other components of kuik, and from nodes when the proxy runs in
+//     the host network;
+//   - the controllers and the proxy only connect to the other components of kuik, to the Kubernetes API server, to DNS
+//     servers and to the CIDRs of upstream registries.
+//
+// Upstream CIDRs are the ones allowed for every registry along with the ones of Repositories, so that policies are
+// updated as Repositories are. The registry never connects to upstream registries, its egress is left untouched since
+// its storage may be remote.
+type NetworkPolicyReconciler struct {
+	client.Client
+	// ApiReader reads the addresses of the Kubernetes API server without caching every Endpoints of the cluster
+	ApiReader client.Reader
+	// Namespace is the one of the components of kuik, where the NetworkPolicies are created
+	Namespace string
+	// Prefix is the prefix of the names of the NetworkPolicies, followed by the name of the component they apply to
+	Prefix string
+	// Selector are the labels of every pod of kuik, components being told apart by LabelComponentName
+	Selector map[string]string
+	// UpstreamCIDRs are the CIDRs every upstream registry may be reached at
+	UpstreamCIDRs []string
+	// ProxyHostNetwork tells whether the proxy runs in the host network, connecting to the registry from node addresses
+	ProxyHostNetwork bool
+
+	startup chan event.GenericEvent
+}
+
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update
+//+kubebuilder:rbac:groups=core,resources=endpoints,verbs=get
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=kuik.enix.io,resources=repositories,verbs=get;list;watch
+
+// Reconcile creates or updates the NetworkPolicies of the registry, the controllers and the proxy
+func (r *NetworkPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	apiServer, err := r.apiServerPeers(ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	upstream, err := r.upstreamPeers(ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	registryClients := []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{MatchLabels: r.Selector}}}
+	if r.ProxyHostNetwork {
+		nodes, err := r.nodePeers(ctx)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		registryClients = append(registryClients, nodes...)
+	}
+
+	policies := map[string]networkingv1.NetworkPolicySpec{
+		"registry": {
+			PodSelector: r.componentSelector("registry"),
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress:     []networkingv1.NetworkPolicyIngressRule{{From: registryClients}},
+		},
+		"controllers": r.egressPolicy("controllers", apiServer, upstream),
+		"proxy":       r.egressPolicy("proxy", apiServer, upstream),
+	}
+
+	for _, component := range []string{"registry", "controllers", "proxy"} {
+		policy := networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: r.Namespace, Name: r.policyName(component)}}
+		operation, err := controllerutil.CreateOrUpdate(ctx, r.Client, &policy, func() error {
+			metav1.SetMetaDataLabel(&policy.ObjectMeta, LabelManagedByName, "kube-image-keeper")
+			metav1.SetMetaDataLabel(&policy.ObjectMeta, LabelComponentName, component)
+			policy.Spec = policies[component]
+			return nil
+		})
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if operation != controllerutil.OperationResultNone {
+			log.Info("network policy "+string(operation), "networkPolicy", policy.Name)
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: apiServerRefreshInterval}, nil
+}
+
+// egressPolicy returns the policy of a component only connecting to the other components of kuik, to the Kubernetes
+// API server, to DNS servers and to upstream registries
+func (r *NetworkPolicyReconciler) egressPolicy(component string, apiServer []networkingv1.NetworkPolicyPeer, upstream []networkingv1.NetworkPolicyPeer) networkingv1.NetworkPolicySpec {
+	udp, tcp := corev1.ProtocolUDP, corev1.ProtocolTCP
+	dns := intstr.FromInt(53)
+
+	egress := []networkingv1.NetworkPolicyEgressRule{
+		{To: []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{MatchLabels: r.Selector}}}},
+		{Ports: []networkingv1.NetworkPolicyPort{{Protocol: &udp, Port: &dns}, {Protocol: &tcp, Port: &dns}}},
+	}
+	if len(apiServer) > 0 {
+		egress = append(egress, networkingv1.NetworkPolicyEgressRule{To: apiServer})
+	}
+	if len(upstream) > 0 {
+		egress = append(egress, networkingv1.NetworkPolicyEgressRule{To: upstream})
+	}
+
+	return networkingv1.NetworkPolicySpec{
+		PodSelector: r.componentSelector(component),
+		PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+		Egress:      egress,
+	}
+}
+
+func (r *NetworkPolicyReconciler) componentSelector(component string) metav1.LabelSelector {
+	labels := map[string]string{LabelComponentName: component}
+	for key, value := range r.Selector {
+		labels[key] = value
+	}
+	return metav1.LabelSelector{MatchLabels: labels}
+}
+
+func (r *NetworkPolicyReconciler) policyName(component string) string {
+	return r.Prefix + "-" + component
+}
+
+// apiServerPeers returns the addresses of the Kubernetes API server, read from the Endpoints of the kubernetes Service
+// since policies apply to connections once their destination has been translated from the address of the Service
+func (r *NetworkPolicyReconciler) apiServerPeers(ctx context.Context) ([]networkingv1.NetworkPolicyPeer, error) {
+	var endpoints corev1.Endpoints
+	if err := r.ApiReader.Get(ctx, types.NamespacedName{Namespace: metav1.NamespaceDefault, Name: "kubernetes"}, &endpoints); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+
+	addresses := []string{}
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			addresses = append(addresses, address.IP)
+		}
+	}
+	return ipBlockPeers(addresses), nil
+}
+
+// upstreamPeers returns the CIDRs of upstream registries, the ones allowed for every registry along with the upstream
+// CIDRs and addresses of Repositories
+func (r *NetworkPolicyReconciler) upstreamPeers(ctx context.Context) ([]networkingv1.NetworkPolicyPeer, error) {
+	var repositories kuikv1alpha1.RepositoryList
+	if err := r.List(ctx, &repositories); err != nil {
+		return nil, err
+	}
+
+	cidrs := append([]string{}, r.UpstreamCIDRs...)
+	for _, repository := range repositories.Items {
+		cidrs = append(cidrs, repository.Spec.UpstreamCIDRs...)
+		if host, _, err := net.SplitHostPort(repository.Spec.UpstreamAddress); err == nil {
+			cidrs = append(cidrs, strings.Trim(host, "[]"))
+		} else {
+			cidrs = append(cidrs, strings.Trim(repository.Spec.UpstreamAddress, "[]"))
+		}
+	}
+	return ipBlockPeers(cidrs), nil
+}
+
+// nodePeers returns the addresses of nodes
+func (r *NetworkPolicyReconciler) nodePeers(ctx context.Context) ([]networkingv1.NetworkPolicyPeer, error) {
+	var nodes corev1.NodeList
+	if err := r.List(ctx, &nodes); err != nil {
+		return nil, err
+	}
+
+	addresses := []string{}
+	for _, node := range nodes.Items {
+		for _, address := range node.Status.Addresses {
+			if address.Type == corev1.NodeInternalIP || address.Type == corev1.NodeExternalIP {
+				addresses = append(addresses, address.Address)
+			}
+		}
+	}
+	return ipBlockPeers(addresses), nil
+}
+
+// ipBlockPeers returns the sorted and deduplicated peers of CIDRs and IPs, ignoring anything else such as host names
+func ipBlockPeers(addresses []string) []networkingv1.NetworkPolicyPeer {
+	cidrs := map[string]bool{}
+	for _, address := range addresses {
+		if _, ipNet, err := net.ParseCIDR(address); err == nil {
+			cidrs[ipNet.String()] = true
+		} else if ip := net.ParseIP(address); ip != nil {
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			cidrs[(&net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}).String()] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(cidrs))
+	for cidr := range cidrs {
+		sorted = append(sorted, cidr)
+	}
+	sort.Strings(sorted)
+
+	peers := make([]networkingv1.NetworkPolicyPeer, len(sorted))
+	for i, cidr := range sorted {
+		peers[i] = networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr}}
+	}
+	return peers
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *NetworkPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	policies := []string{r.policyName("registry"), r.policyName("controllers"), r.policyName("proxy")}
+	policiesRequest := func(client.Object) []ctrl.Request {
+		return []ctrl.Request{{NamespacedName: types.NamespacedName{Namespace: r.Namespace, Name: r.Prefix}}}
+	}
+
+	// Policies are reconciled once on startup, even if there is neither a policy nor a Repository yet
+	r.startup = make(chan event.GenericEvent, 1)
+	r.startup <- event.GenericEvent{Object: &networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: r.Namespace, Name: r.Prefix}}}
+
+	b := ctrl.NewControllerManagedBy(mgr).
+		Named("network-policies").
+		For(&networkingv1.NetworkPolicy{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return obj.GetNamespace() == r.Namespace && slices.Contains(policies, obj.GetName())
+		}))).
+		Watches(&source.Channel{Source: r.startup}, handler.EnqueueRequestsFromMapFunc(policiesRequest)).
+		Watches(
+			&source.Kind{Type: &kuikv1alpha1.Repository{}},
+			handler.EnqueueRequestsFromMapFunc(policiesRequest),
+			builder.WithPredicates(predicate.Funcs{
+				UpdateFunc: func(e event.UpdateEvent) bool {
+					oldRepository, newRepository := e.ObjectOld.(*kuikv1alpha1.Repository), e.ObjectNew.(*kuikv1alpha1.Repository)
+					return oldRepository.Spec.UpstreamAddress != newRepository.Spec.UpstreamAddress ||
+						!equality.Semantic.DeepEqual(oldRepository.Spec.UpstreamCIDRs, newRepository.Spec.UpstreamCIDRs)
+				},
+			}),
+		)
+
+	if r.ProxyHostNetwork {
+		b = b.Watches(
+			&source.Kind{Type: &corev1.Node{}},
+			handler.EnqueueRequestsFromMapFunc(policiesRequest),
+			builder.WithPredicates(predicate.Funcs{
+				UpdateFunc: func(e event.UpdateEvent) bool {
+					oldNode, newNode := e.ObjectOld.(*corev1.Node), e.ObjectNew.(*corev1.Node)
+					return !equality.Semantic.DeepEqual(oldNode.Status.Addresses, newNode.Status.Addresses)
+				},
+			}),
+		)
+	}
+
+	return b.Complete(r)
+}
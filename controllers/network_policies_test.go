@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+)
+
+func TestNetworkPolicyReconcile(t *testing.T) {
+	g := NewWithT(t)
+
+	apiServer := &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "kubernetes"},
+		Subsets:    []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}}}},
+	}
+	repository := &kuikv1alpha1.Repository{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry.example.com-team-app"},
+		Spec: kuikv1alpha1.RepositorySpec{
+			Name:            "registry.example.com/team/app",
+			UpstreamCIDRs:   []string{"203.0.113.0/24", "not a CIDR"},
+			UpstreamAddress: "198.51.100.7:5000",
+		},
+	}
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status:     corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "192.168.1.10"}, {Type: corev1.NodeHostName, Address: "node-1"}}},
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(apiServer, repository, node).Build()
+
+	r := &NetworkPolicyReconciler{
+		Client:           k8sClient,
+		ApiReader:        k8sClient,
+		Namespace:        "kuik-system",
+		Prefix:           "kuik",
+		Selector:         map[string]string{"app.kubernetes.io/instance": "kuik"},
+		UpstreamCIDRs:    []string{"192.0.2.0/24"},
+		ProxyHostNetwork: true,
+	}
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "kuik-system", Name: "kuik"}})
+	g.Expect(err).To(BeNil())
+	g.Expect(result.RequeueAfter).To(Equal(apiServerRefreshInterval))
+
+	var registryPolicy networkingv1.NetworkPolicy
+	g.Expect(k8sClient.Get(context.Background(), client.ObjectKey{Namespace: "kuik-system", Name: "kuik-registry"}, &registryPolicy)).To(Succeed())
+	g.Expect(registryPolicy.Labels).To(HaveKeyWithValue(LabelManagedByName, "kube-image-keeper"))
+	g.Expect(registryPolicy.Spec.PodSelector.MatchLabels).To(Equal(map[string]string{"app.kubernetes.io/instance": "kuik", LabelComponentName: "registry"}))
+	g.Expect(registryPolicy.Spec.PolicyTypes).To(Equal([]networkingv1.PolicyType{networkingv1.PolicyTypeIngress}))
+	g.Expect(registryPolicy.Spec.Ingress).To(HaveLen(1))
+	g.Expect(registryPolicy.Spec.Ingress[0].From).To(Equal([]networkingv1.NetworkPolicyPeer{
+		{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app.kubernetes.io/instance": "kuik"}}},
+		{IPBlock: &networkingv1.IPBlock{CIDR: "192.168.1.10/32"}},
+	}))
+
+	for _, component := range []string{"controllers", "proxy"} {
+		var policy networkingv1.NetworkPolicy
+		g.Expect(k8sClient.Get(context.Background(), client.ObjectKey{Namespace: "kuik-system", Name: "kuik-" + component}, &policy)).To(Succeed())
+		g.Expect(policy.Spec.PodSelector.MatchLabels).To(HaveKeyWithValue(LabelComponentName, component))
+		g.Expect(policy.Spec.PolicyTypes).To(Equal([]networkingv1.PolicyType{networkingv1.PolicyTypeEgress}))
+		g.Expect(policy.Spec.Egress).To(HaveLen(4))
+		g.Expect(policy.Spec.Egress[2].To).To(Equal([]networkingv1.NetworkPolicyPeer{
+			{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.1/32"}},
+			{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.2/32"}},
+		}))
+		g.Expect(policy.Spec.Egress[3].To).To(Equal([]networkingv1.NetworkPolicyPeer{
+			{IPBlock: &networkingv1.IPBlock{CIDR: "192.0.2.0/24"}},
+			{IPBlock: &networkingv1.IPBlock{CIDR: "198.51.100.7/32"}},
+			{IPBlock: &networkingv1.IPBlock{CIDR: "203.0.113.0/24"}},
+		}))
+	}
+
+	// Policies follow the upstream CIDRs of Repositories
+	repository.Spec.UpstreamCIDRs = nil
+	repository.Spec.UpstreamAddress = ""
+	g.Expect(k8sClient.Update(context.Background(), repository)).To(Succeed())
+	_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "kuik-system", Name: "kuik"}})
+	g.Expect(err).To(BeNil())
+
+	var proxyPolicy networkingv1.NetworkPolicy
+	g.Expect(k8sClient.Get(context.Background(), client.ObjectKey{Namespace: "kuik-system", Name: "kuik-proxy"}, &proxyPolicy)).To(Succeed())
+	g.Expect(proxyPolicy.Spec.Egress[3].To).To(Equal([]networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "192.0.2.0/24"}}}))
+}
@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/registry"
+)
+
+const cachedImageNodeKey = ".status.images.cachedImages"
+
+// nodeImagesResyncPeriod is how often unused CachedImages still present on nodes are checked again, since
+// nodes don't notify the removal of images from their local store
+const nodeImagesResyncPeriod = 10 * time.Minute
+
+// CachedImageNamesFromNode returns the names of the CachedImages matching the images of the local store of a node,
+// whether they have been pulled through the proxy or directly from their original registry.
+func CachedImageNamesFromNode(node *corev1.Node) []string {
+	seen := map[string]bool{}
+	names := []string{}
+
+	for _, image := range node.Status.Images {
+		for _, imageName := range image.Names {
+			cachedImage, err := CachedImageFromSourceImage(registry.ProxiedImage(imageName))
+			if err != nil || seen[cachedImage.Name] {
+				continue
+			}
+			seen[cachedImage.Name] = true
+			names = append(names, cachedImage.Name)
+		}
+	}
+
+	return names
+}
+
+// updateNodeCount sets the Nodes status of a CachedImage from the local store of the nodes, it doesn't update the
+// CachedImage itself.
+func (r *CachedImageReconciler) updateNodeCount(ctx context.Context, cachedImage *kuikv1alpha1.CachedImage) error {
+	var nodes corev1.NodeList
+	if err := r.List(ctx, &nodes, client.MatchingFields{cachedImageNodeKey: cachedImage.Name}); err != nil {
+		return err
+	}
+
+	status := cachedImage.Status.Nodes
+	if status == nil {
+		status = &kuikv1alpha1.Nodes{}
+	}
+
+	status.Count = len(nodes.Items)
+	if status.Count > 0 {
+		status.MissingSince = nil
+	} else if status.MissingSince == nil {
+		now := metav1.Now()
+		status.MissingSince = &now
+	}
+
+	cachedImage.Status.Nodes = status
+	return nil
+}
+
+// pruneNodePulls removes from the pulls of a CachedImage by node the nodes that no longer exist, e.g. removed by the
+// cluster autoscaler, it doesn't update the CachedImage itself.
+func (r *CachedImageReconciler) pruneNodePulls(ctx context.Context, cachedImage *kuikv1alpha1.CachedImage) error {
+	if len(cachedImage.Status.Usage.Nodes) == 0 {
+		return nil
+	}
+
+	var nodes corev1.NodeList
+	if err := r.List(ctx, &nodes); err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for _, node := range nodes.Items {
+		existing[node.Name] = true
+	}
+
+	nodePulls := []kuikv1alpha1.NodePull{}
+	for _, nodePull := range cachedImage.Status.Usage.Nodes {
+		if existing[nodePull.Name] {
+			nodePulls = append(nodePulls, nodePull)
+		}
+	}
+	cachedImage.Status.Usage.Nodes = nodePulls
+	return nil
+}
+
+// nodeAwareExpiry returns the expiry date of an unused CachedImage, brought forward to NodeImagesExpiryDelay after
+// the image went missing from every node when it comes earlier than expiresAt.
+func (r *CachedImageReconciler) nodeAwareExpiry(cachedImage *kuikv1alpha1.CachedImage, expiresAt time.Time) time.Time {
+	if r.NodeImagesExpiryDelay <= 0 || cachedImage.Status.Nodes == nil || cachedImage.Status.Nodes.MissingSince == nil {
+		return expiresAt
+	}
+
+	accelerated := cachedImage.Status.Nodes.MissingSince.Add(r.NodeImagesExpiryDelay)
+	if accelerated.Before(expiresAt) {
+		return accelerated
+	}
+
+	return expiresAt
+}
+
+// isOnNodes returns true if node aware expiry is enabled and the image of a CachedImage is in the local store of a node
+func (r *CachedImageReconciler) isOnNodes(cachedImage *kuikv1alpha1.CachedImage) bool {
+	return r.NodeImagesExpiryDelay > 0 && cachedImage.Status.Nodes != nil && cachedImage.Status.Nodes.Count > 0
+}
@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCachedImageNamesFromNode(t *testing.T) {
+	g := NewWithT(t)
+
+	node := &corev1.Node{
+		Status: corev1.NodeStatus{
+			Images: []corev1.ContainerImage{
+				{Names: []string{"localhost:7439/nginx:1.25", "docker.io/library/nginx:1.25"}},
+				{Names: []string{"localhost:7439/alpine:latest"}},
+				{Names: []string{"quay.io/prometheus/prometheus:v2.48.0"}},
+				{Names: []string{"localhost:7439/Invalid:Name"}},
+			},
+		},
+	}
+
+	g.Expect(CachedImageNamesFromNode(node)).To(Equal([]string{
+		"docker.io-library-nginx-1.25",
+		"docker.io-library-alpine-latest",
+		"quay.io-prometheus-prometheus-v2.48.0",
+	}))
+}
+
+func TestNodeAwareExpiry(t *testing.T) {
+	now := time.Now()
+	missingSince := metav1.NewTime(now.Add(-2 * time.Hour))
+	expiresAt := now.Add(30 * 24 * time.Hour)
+
+	tests := []struct {
+		name     string
+		delay    time.Duration
+		nodes    *kuikv1alpha1.Nodes
+		expected time.Time
+	}{
+		{
+			name:     "Disabled",
+			nodes:    &kuikv1alpha1.Nodes{MissingSince: &missingSince},
+			expected: expiresAt,
+		},
+		{
+			name:     "Unknown nodes status",
+			delay:    24 * time.Hour,
+			expected: expiresAt,
+		},
+		{
+			name:     "Present on nodes",
+			delay:    24 * time.Hour,
+			nodes:    &kuikv1alpha1.Nodes{Count: 2},
+			expected: expiresAt,
+		},
+		{
+			name:     "Missing from every node",
+			delay:    24 * time.Hour,
+			nodes:    &kuikv1alpha1.Nodes{MissingSince: &missingSince},
+			expected: missingSince.Add(24 * time.Hour),
+		},
+		{
+			name:     "Delay longer than expiry",
+			delay:    60 * 24 * time.Hour,
+			nodes:    &kuikv1alpha1.Nodes{MissingSince: &missingSince},
+			expected: expiresAt,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			r := &CachedImageReconciler{NodeImagesExpiryDelay: tt.delay}
+			cachedImage := &kuikv1alpha1.CachedImage{Status: kuikv1alpha1.CachedImageStatus{Nodes: tt.nodes}}
+			g.Expect(r.nodeAwareExpiry(cachedImage, expiresAt)).To(Equal(tt.expected))
+		})
+	}
+}
+
+func TestPruneNodePulls(t *testing.T) {
+	g := NewWithT(t)
+	now := metav1.Now()
+
+	r := &CachedImageReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}},
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-3"}},
+		).Build(),
+	}
+	cachedImage := &kuikv1alpha1.CachedImage{Status: kuikv1alpha1.CachedImageStatus{Usage: kuikv1alpha1.Usage{Nodes: []kuikv1alpha1.NodePull{
+		{Name: "node-1", LastPulledAt: now},
+		{Name: "node-2", LastPulledAt: now},
+		{Name: "node-3", LastPulledAt: now},
+	}}}}
+
+	g.Expect(r.pruneNodePulls(context.Background(), cachedImage)).To(Succeed())
+	g.Expect(cachedImage.Status.Usage.Nodes).To(Equal([]kuikv1alpha1.NodePull{
+		{Name: "node-1", LastPulledAt: now},
+		{Name: "node-3", LastPulledAt: now},
+	}))
+}
@@ -0,0 +1,307 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/proxy"
+)
+
+const (
+	// AnnotationPrePulledAtName is set on nodes each time a pre-pull pod has been created for them
+	AnnotationPrePulledAtName = "kuik.enix.io/pre-pulled-at"
+	// LabelPrePullName is set on pre-pull pods
+	LabelPrePullName = "kuik.enix.io/pre-pull"
+)
+
+// NodePrePullReconciler pulls cached images on the nodes selected by their pre-pull node selector, or by the one of an
+// ImagePrefetch listing them, as soon as they are in cache, so that pods using them start right away even on nodes
+// that never ran them. Images missing from the local store of a node are pulled through the proxy of the node by a pod
+// bound to the node, then the pod is deleted.
+type NodePrePullReconciler struct {
+	client.Client
+	Recorder record.EventRecorder
+	// Namespace is where pre-pull pods are created, it must not be rewritten by the pod webhook
+	Namespace string
+	// Interval is the minimum delay between two pre-pulls on a node. Nodes only report a limited number of images of
+	// their local store, so that some images may still look missing once pulled.
+	Interval time.Duration
+	// ProxyHost and ProxyPort are used to pull images through the proxy, ProxyPorts resolves the port of the proxy when
+	// it had to fall back to another port on some nodes
+	ProxyHost  string
+	ProxyPort  int
+	ProxyPorts *proxy.PortResolver
+}
+
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups=kuik.enix.io,resources=cachedimages,verbs=get;list;watch
+//+kubebuilder:rbac:groups=kuik.enix.io,resources=imageprefetches,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// Reconcile creates a pre-pull pod for a ready node when cached images selected for it are missing from its local
+// store, and deletes it once it is done
+func (r *NodePrePullReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var pod corev1.Pod
+	if err := r.Get(ctx, types.NamespacedName{Namespace: r.Namespace, Name: prePullPodName(req.Name)}, &pod); err == nil {
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			log.Info("deleting pre-pull pod", "pod", pod.Name, "phase", pod.Status.Phase)
+			return ctrl.Result{}, client.IgnoreNotFound(r.Delete(ctx, &pod))
+		}
+		return ctrl.Result{}, nil
+	} else if !apierrors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+
+	var node corev1.Node
+	if err := r.Get(ctx, req.NamespacedName, &node); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !isNodeReady(&node) {
+		return ctrl.Result{}, nil
+	}
+
+	images, err := r.imagesToPrePull(ctx, &node)
+	if err != nil || len(images) == 0 {
+		return ctrl.Result{}, err
+	}
+
+	if prePulledAt, err := time.Parse(time.RFC3339, node.Annotations[AnnotationPrePulledAtName]); err == nil {
+		if remaining := time.Until(prePulledAt.Add(r.Interval)); remaining > 0 {
+			return ctrl.Result{RequeueAfter: remaining}, nil
+		}
+	}
+
+	log.Info("pre-pulling images", "images", images)
+	pod = *proxyPullPod(metav1.ObjectMeta{
+		Name:      prePullPodName(node.Name),
+		Namespace: r.Namespace,
+		Labels:    map[string]string{LabelPrePullName: "true"},
+	}, &node, images, r.ProxyHost, r.ProxyPort, r.ProxyPorts)
+	if err := r.Create(ctx, &pod); err != nil && !apierrors.IsAlreadyExists(err) {
+		return ctrl.Result{}, err
+	}
+	r.Recorder.Eventf(&node, "Normal", "PrePulling", "Pulling %d cached images through kube-image-keeper", len(images))
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{AnnotationPrePulledAtName: time.Now().UTC().Format(time.RFC3339)},
+		},
+	})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, client.IgnoreNotFound(r.Patch(ctx, &node, client.RawPatch(types.MergePatchType, patch)))
+}
+
+// imagesToPrePull returns the cached images selected for a node that are missing from its local store
+func (r *NodePrePullReconciler) imagesToPrePull(ctx context.Context, node *corev1.Node) ([]string, error) {
+	var cachedImageList kuikv1alpha1.CachedImageList
+	if err := r.List(ctx, &cachedImageList); err != nil {
+		return nil, err
+	}
+	prefetchSelectors, err := r.prefetchSelectors(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	present := map[string]bool{}
+	for _, name := range CachedImageNamesFromNode(node) {
+		present[name] = true
+	}
+
+	images := []string{}
+	for _, cachedImage := range cachedImageList.Items {
+		if !cachedImage.Status.IsCached || !cachedImage.DeletionTimestamp.IsZero() || present[cachedImage.Name] {
+			continue
+		}
+		for _, selector := range r.prePullSelectors(ctx, &cachedImage, prefetchSelectors) {
+			if selector.Matches(labels.Set(node.Labels)) {
+				images = append(images, cachedImage.Spec.SourceImage)
+				break
+			}
+		}
+	}
+
+	return images, nil
+}
+
+// prefetchSelectors returns the pre-pull node selectors of the ImagePrefetches by name of the CachedImages they list
+func (r *NodePrePullReconciler) prefetchSelectors(ctx context.Context) (map[string][]labels.Selector, error) {
+	var imagePrefetchList kuikv1alpha1.ImagePrefetchList
+	if err := r.List(ctx, &imagePrefetchList); err != nil {
+		return nil, err
+	}
+
+	selectors := map[string][]labels.Selector{}
+	for _, imagePrefetch := range imagePrefetchList.Items {
+		if imagePrefetch.Spec.PrePullNodeSelector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(imagePrefetch.Spec.PrePullNodeSelector)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "ignoring ImagePrefetch with an invalid pre-pull node selector", "imagePrefetch", imagePrefetch.Name)
+			continue
+		}
+		for _, sourceImage := range imagePrefetch.Status.Images {
+			if cachedImage, err := CachedImageFromSourceImage(sourceImage); err == nil {
+				selectors[cachedImage.Name] = append(selectors[cachedImage.Name], selector)
+			}
+		}
+	}
+
+	return selectors, nil
+}
+
+// prePullSelectors returns the pre-pull node selector of a CachedImage along with the ones of the ImagePrefetches
+// listing it
+func (r *NodePrePullReconciler) prePullSelectors(ctx context.Context, cachedImage *kuikv1alpha1.CachedImage, prefetchSelectors map[string][]labels.Selector) []labels.Selector {
+	selectors := prefetchSelectors[cachedImage.Name]
+	if cachedImage.Spec.PrePullNodeSelector == nil {
+		return selectors
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(cachedImage.Spec.PrePullNodeSelector)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "ignoring invalid pre-pull node selector", "cachedImage", cachedImage.Name)
+		return selectors
+	}
+
+	return append([]labels.Selector{selector}, selectors...)
+}
+
+func prePullPodName(nodeName string) string {
+	return "kuik-prepull-" + nodeName
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *NodePrePullReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("node-prepull").
+		For(&corev1.Node{}, builder.WithPredicates(predicate.Funcs{
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				oldNode, newNode := e.ObjectOld.(*corev1.Node), e.ObjectNew.(*corev1.Node)
+				return isNodeReady(oldNode) != isNodeReady(newNode) || !equality.Semantic.DeepEqual(oldNode.Labels, newNode.Labels)
+			},
+			DeleteFunc: func(e event.DeleteEvent) bool {
+				return false
+			},
+			GenericFunc: func(e event.GenericEvent) bool {
+				return false
+			},
+		})).
+		Watches(
+			&source.Kind{Type: &corev1.Pod{}},
+			handler.EnqueueRequestsFromMapFunc(nodeFromPullPod),
+			builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+				_, ok := obj.GetLabels()[LabelPrePullName]
+				return ok && obj.GetNamespace() == r.Namespace
+			})),
+		).
+		Watches(
+			&source.Kind{Type: &kuikv1alpha1.CachedImage{}},
+			handler.EnqueueRequestsFromMapFunc(r.nodesFromCachedImage),
+			builder.WithPredicates(predicate.Funcs{
+				CreateFunc: func(e event.CreateEvent) bool {
+					return false
+				},
+				UpdateFunc: func(e event.UpdateEvent) bool {
+					oldCachedImage, newCachedImage := e.ObjectOld.(*kuikv1alpha1.CachedImage), e.ObjectNew.(*kuikv1alpha1.CachedImage)
+					return newCachedImage.Status.IsCached && (!oldCachedImage.Status.IsCached ||
+						!equality.Semantic.DeepEqual(oldCachedImage.Spec.PrePullNodeSelector, newCachedImage.Spec.PrePullNodeSelector))
+				},
+				DeleteFunc: func(e event.DeleteEvent) bool {
+					return false
+				},
+				GenericFunc: func(e event.GenericEvent) bool {
+					return false
+				},
+			}),
+		).
+		Watches(
+			&source.Kind{Type: &kuikv1alpha1.ImagePrefetch{}},
+			handler.EnqueueRequestsFromMapFunc(r.nodesFromImagePrefetch),
+			builder.WithPredicates(predicate.Funcs{
+				UpdateFunc: func(e event.UpdateEvent) bool {
+					oldPrefetch, newPrefetch := e.ObjectOld.(*kuikv1alpha1.ImagePrefetch), e.ObjectNew.(*kuikv1alpha1.ImagePrefetch)
+					return newPrefetch.Spec.PrePullNodeSelector != nil && (!equality.Semantic.DeepEqual(oldPrefetch.Status.Images, newPrefetch.Status.Images) ||
+						!equality.Semantic.DeepEqual(oldPrefetch.Spec.PrePullNodeSelector, newPrefetch.Spec.PrePullNodeSelector))
+				},
+				DeleteFunc: func(e event.DeleteEvent) bool {
+					return false
+				},
+			}),
+		).
+		Complete(r)
+}
+
+// nodesFromCachedImage returns the nodes selected by the pre-pull node selectors of a CachedImage
+func (r *NodePrePullReconciler) nodesFromCachedImage(obj client.Object) []ctrl.Request {
+	ctx := context.Background()
+
+	prefetchSelectors, err := r.prefetchSelectors(ctx)
+	if err != nil {
+		return nil
+	}
+
+	return r.nodesMatching(ctx, r.prePullSelectors(ctx, obj.(*kuikv1alpha1.CachedImage), prefetchSelectors))
+}
+
+// nodesFromImagePrefetch returns the nodes selected by the pre-pull node selector of an ImagePrefetch
+func (r *NodePrePullReconciler) nodesFromImagePrefetch(obj client.Object) []ctrl.Request {
+	imagePrefetch := obj.(*kuikv1alpha1.ImagePrefetch)
+	if imagePrefetch.Spec.PrePullNodeSelector == nil {
+		return nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(imagePrefetch.Spec.PrePullNodeSelector)
+	if err != nil {
+		return nil
+	}
+
+	return r.nodesMatching(context.Background(), []labels.Selector{selector})
+}
+
+func (r *NodePrePullReconciler) nodesMatching(ctx context.Context, selectors []labels.Selector) []ctrl.Request {
+	if len(selectors) == 0 {
+		return nil
+	}
+
+	var nodeList corev1.NodeList
+	if err := r.List(ctx, &nodeList); err != nil {
+		return nil
+	}
+
+	requests := []ctrl.Request{}
+	for _, node := range nodeList.Items {
+		for _, selector := range selectors {
+			if selector.Matches(labels.Set(node.Labels)) {
+				requests = append(requests, ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}})
+				break
+			}
+		}
+	}
+
+	return requests
+}
@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNodePrePullReconcile(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	web := &metav1.LabelSelector{MatchLabels: map[string]string{"pool": "web"}}
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{"pool": "web"}},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+			Images:     []corev1.ContainerImage{{Names: []string{"localhost:7439/redis:7"}}},
+		},
+	}
+	cachedImage := func(name string, sourceImage string, isCached bool, selector *metav1.LabelSelector) *kuikv1alpha1.CachedImage {
+		return &kuikv1alpha1.CachedImage{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: sourceImage, PrePullNodeSelector: selector},
+			Status:     kuikv1alpha1.CachedImageStatus{IsCached: isCached},
+		}
+	}
+
+	reconciler := &NodePrePullReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(
+			node,
+			cachedImage("docker.io-library-busybox-1.36", "busybox:1.36", true, nil),
+			cachedImage("docker.io-library-nginx-1.25", "nginx:1.25", true, web),
+			cachedImage("docker.io-library-redis-7", "redis:7", true, web),
+			cachedImage("docker.io-library-alpine-latest", "alpine", false, web),
+			cachedImage("docker.io-library-postgres-16", "postgres:16", true, &metav1.LabelSelector{MatchLabels: map[string]string{"pool": "gpu"}}),
+			cachedImage("docker.io-library-memcached-1.6", "memcached:1.6", true, nil),
+			&kuikv1alpha1.ImagePrefetch{
+				ObjectMeta: metav1.ObjectMeta{Name: "tools"},
+				Spec:       kuikv1alpha1.ImagePrefetchSpec{Images: []string{"busybox:1.36"}, PrePullNodeSelector: web},
+				Status:     kuikv1alpha1.ImagePrefetchStatus{Images: []string{"busybox:1.36"}},
+			},
+		).Build(),
+		Recorder:  record.NewFakeRecorder(10),
+		Namespace: "kuik-system",
+		Interval:  10 * time.Minute,
+		ProxyPort: 7439,
+	}
+	request := ctrl.Request{NamespacedName: types.NamespacedName{Name: "node-1"}}
+	_, err := reconciler.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Cached images selected for the node, by themselves or by an ImagePrefetch, are pulled unless already present
+	var pod corev1.Pod
+	podName := types.NamespacedName{Namespace: "kuik-system", Name: "kuik-prepull-node-1"}
+	g.Expect(reconciler.Get(ctx, podName, &pod)).To(Succeed())
+	g.Expect(pod.Spec.NodeName).To(Equal("node-1"))
+	g.Expect(pod.Labels).To(HaveKey(LabelPrePullName))
+	images := []string{}
+	for _, container := range pod.Spec.Containers {
+		images = append(images, container.Image)
+	}
+	g.Expect(images).To(Equal([]string{
+		"localhost:7439/busybox:1.36",
+		"localhost:7439/nginx:1.25",
+	}))
+
+	g.Expect(reconciler.Get(ctx, request.NamespacedName, node)).To(Succeed())
+	g.Expect(node.Annotations).To(HaveKey(AnnotationPrePulledAtName))
+
+	// The pod is deleted once done, and the node is not pre-pulled again before the interval has elapsed
+	pod.Status.Phase = corev1.PodSucceeded
+	g.Expect(reconciler.Status().Update(ctx, &pod)).To(Succeed())
+	_, err = reconciler.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	err = reconciler.Get(ctx, podName, &pod)
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+	result, err := reconciler.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(BeNumerically(">", 9*time.Minute))
+	err = reconciler.Get(ctx, podName, &pod)
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+	// Nodes that are not selected are left alone
+	g.Expect(reconciler.nodesFromImagePrefetch(&kuikv1alpha1.ImagePrefetch{
+		Spec: kuikv1alpha1.ImagePrefetchSpec{PrePullNodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"pool": "gpu"}}},
+	})).To(BeEmpty())
+	g.Expect(reconciler.nodesFromImagePrefetch(&kuikv1alpha1.ImagePrefetch{
+		Spec: kuikv1alpha1.ImagePrefetchSpec{PrePullNodeSelector: web},
+	})).To(Equal([]ctrl.Request{request}))
+}
@@ -0,0 +1,298 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/strings/slices"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/proxy"
+	"github.com/enix/kube-image-keeper/internal/registry"
+)
+
+const (
+	// AnnotationWarmedUpAtName is set on nodes once a warm-up pod has been created for them
+	AnnotationWarmedUpAtName = "kuik.enix.io/warmed-up-at"
+	// LabelWarmupName is set on warm-up pods
+	LabelWarmupName = "kuik.enix.io/warmup"
+
+	// warmupDeadline is how long a warm-up or pre-pull pod may take to pull its images
+	warmupDeadline = 15 * time.Minute
+)
+
+// NodeWarmupReconciler pulls the images of the matching NodeImageProfiles and the most used cached images on nodes
+// joining the cluster, e.g. during a scale-up of the cluster autoscaler, so that the first pods scheduled on these nodes
+// don't wait for their images to be pulled. Images are pulled through the proxy of the node by a pod bound to the node,
+// then the pod is deleted.
+type NodeWarmupReconciler struct {
+	client.Client
+	Recorder record.EventRecorder
+	// Namespace is where warm-up pods are created, it must not be rewritten by the pod webhook
+	Namespace string
+	// NodeSelector selects the nodes to warm up, every node if nil
+	NodeSelector labels.Selector
+	// TopImages is the number of most pulled images to warm up nodes with
+	TopImages int
+	// MaxNodeAge prevents nodes that have joined the cluster for longer, e.g. when kuik is installed, from being warmed up
+	MaxNodeAge time.Duration
+	// ProxyHost and ProxyPort are used to pull images through the proxy, ProxyPorts resolves the port of the proxy when
+	// it had to fall back to another port on some nodes
+	ProxyHost  string
+	ProxyPort  int
+	ProxyPorts *proxy.PortResolver
+}
+
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups=kuik.enix.io,resources=cachedimages,verbs=get;list;watch;create
+//+kubebuilder:rbac:groups=kuik.enix.io,resources=nodeimageprofiles,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// Reconcile creates a warm-up pod for new nodes once they are ready, and deletes it once it is done
+func (r *NodeWarmupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	// Clean up warm-up pods once their images are pulled, or once they gave up
+	var pod corev1.Pod
+	if err := r.Get(ctx, types.NamespacedName{Namespace: r.Namespace, Name: warmupPodName(req.Name)}, &pod); err == nil {
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			log.Info("deleting warm-up pod", "pod", pod.Name, "phase", pod.Status.Phase)
+			return ctrl.Result{}, client.IgnoreNotFound(r.Delete(ctx, &pod))
+		}
+		return ctrl.Result{}, nil
+	} else if !apierrors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+
+	var node corev1.Node
+	if err := r.Get(ctx, req.NamespacedName, &node); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !r.shouldWarmup(&node, time.Now()) {
+		return ctrl.Result{}, nil
+	}
+	// The proxy of the node is usually not running before the node is ready, which triggers another reconcile
+	if !isNodeReady(&node) {
+		return ctrl.Result{}, nil
+	}
+
+	images, err := r.profileImages(ctx, &node)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	topImages, err := r.topImages(ctx, images)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	images = append(images, topImages...)
+
+	if len(images) > 0 {
+		log.Info("warming up node", "images", images)
+		if err := r.Create(ctx, r.warmupPod(&node, images)); err != nil && !apierrors.IsAlreadyExists(err) {
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Eventf(&node, "Normal", "WarmingUp", "Pulling %d images through kube-image-keeper", len(images))
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{AnnotationWarmedUpAtName: time.Now().UTC().Format(time.RFC3339)},
+		},
+	})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, client.IgnoreNotFound(r.Patch(ctx, &node, client.RawPatch(types.MergePatchType, patch)))
+}
+
+// shouldWarmup tells whether a node is selected, has joined the cluster recently and has not been warmed up yet
+func (r *NodeWarmupReconciler) shouldWarmup(node *corev1.Node, now time.Time) bool {
+	if _, ok := node.Annotations[AnnotationWarmedUpAtName]; ok {
+		return false
+	}
+	if r.MaxNodeAge > 0 && now.Sub(node.CreationTimestamp.Time) > r.MaxNodeAge {
+		return false
+	}
+	return r.NodeSelector == nil || r.NodeSelector.Matches(labels.Set(node.Labels))
+}
+
+func isNodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// profileImages returns the images of the NodeImageProfiles selecting a node, and puts them in cache
+func (r *NodeWarmupReconciler) profileImages(ctx context.Context, node *corev1.Node) ([]string, error) {
+	var profileList kuikv1alpha1.NodeImageProfileList
+	if err := r.List(ctx, &profileList); err != nil {
+		return nil, err
+	}
+
+	images := []string{}
+	for _, profile := range profileList.Items {
+		selector, err := metav1.LabelSelectorAsSelector(&profile.Spec.NodeSelector)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "ignoring NodeImageProfile with an invalid node selector", "profile", profile.Name)
+			continue
+		}
+		if !selector.Matches(labels.Set(node.Labels)) {
+			continue
+		}
+
+		for _, sourceImage := range profile.Spec.Images {
+			if slices.Contains(images, sourceImage) {
+				continue
+			}
+			cachedImage, err := CachedImageFromSourceImage(sourceImage)
+			if err != nil {
+				log.FromContext(ctx).Error(err, "ignoring invalid image", "profile", profile.Name, "sourceImage", sourceImage)
+				continue
+			}
+			if err := r.Create(ctx, cachedImage); err != nil && !apierrors.IsAlreadyExists(err) {
+				return nil, err
+			}
+			images = append(images, sourceImage)
+		}
+	}
+
+	return images, nil
+}
+
+// topImages returns the TopImages cached images that have been pulled the most, then used by the most pods, except the
+// excluded ones
+func (r *NodeWarmupReconciler) topImages(ctx context.Context, excluded []string) ([]string, error) {
+	var cachedImageList kuikv1alpha1.CachedImageList
+	if err := r.List(ctx, &cachedImageList); err != nil {
+		return nil, err
+	}
+
+	cachedImages := []kuikv1alpha1.CachedImage{}
+	for _, cachedImage := range cachedImageList.Items {
+		if cachedImage.Status.IsCached && cachedImage.DeletionTimestamp.IsZero() && !slices.Contains(excluded, cachedImage.Spec.SourceImage) {
+			cachedImages = append(cachedImages, cachedImage)
+		}
+	}
+
+	sort.SliceStable(cachedImages, func(i, j int) bool {
+		a, b := cachedImages[i].Status, cachedImages[j].Status
+		if a.Usage.PullCount != b.Usage.PullCount {
+			return a.Usage.PullCount > b.Usage.PullCount
+		}
+		if a.UsedBy.Count != b.UsedBy.Count {
+			return a.UsedBy.Count > b.UsedBy.Count
+		}
+		return cachedImages[i].Name < cachedImages[j].Name
+	})
+
+	images := []string{}
+	for _, cachedImage := range cachedImages {
+		if len(images) >= r.TopImages {
+			break
+		}
+		images = append(images, cachedImage.Spec.SourceImage)
+	}
+
+	return images, nil
+}
+
+func warmupPodName(nodeName string) string {
+	return "kuik-warmup-" + nodeName
+}
+
+// warmupPod returns a pod bound to a node pulling images through its proxy
+func (r *NodeWarmupReconciler) warmupPod(node *corev1.Node, images []string) *corev1.Pod {
+	return proxyPullPod(metav1.ObjectMeta{
+		Name:      warmupPodName(node.Name),
+		Namespace: r.Namespace,
+		Labels:    map[string]string{LabelWarmupName: "true"},
+	}, node, images, r.ProxyHost, r.ProxyPort, r.ProxyPorts)
+}
+
+// proxyPullPod returns a pod bound to a node pulling images through its proxy. Containers exit right away, or fail if
+// their image has no true command, which doesn't matter since the image has been pulled anyway.
+func proxyPullPod(objectMeta metav1.ObjectMeta, node *corev1.Node, images []string, proxyHost string, proxyPort int, proxyPorts *proxy.PortResolver) *corev1.Pod {
+	if proxyHost == "" {
+		proxyHost = registry.DefaultProxyHost
+	}
+	if port := proxyPorts.Port(node.Name); port > 0 {
+		proxyPort = port
+	}
+
+	deadline := int64(warmupDeadline.Seconds())
+	pod := &corev1.Pod{
+		ObjectMeta: objectMeta,
+		Spec: corev1.PodSpec{
+			NodeName:                     node.Name,
+			RestartPolicy:                corev1.RestartPolicyNever,
+			ActiveDeadlineSeconds:        &deadline,
+			AutomountServiceAccountToken: new(bool),
+			Tolerations:                  []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+		},
+	}
+
+	for i, image := range images {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+			Name:            fmt.Sprintf("image-%d", i),
+			Image:           fmt.Sprintf("%s:%d/%s", proxyHost, proxyPort, image),
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			Command:         []string{"true"},
+		})
+	}
+
+	return pod
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *NodeWarmupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("node-warmup").
+		For(&corev1.Node{}, builder.WithPredicates(predicate.Funcs{
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				node := e.ObjectNew.(*corev1.Node)
+				return r.shouldWarmup(node, time.Now()) && isNodeReady(node) != isNodeReady(e.ObjectOld.(*corev1.Node))
+			},
+			DeleteFunc: func(e event.DeleteEvent) bool {
+				return false
+			},
+			GenericFunc: func(e event.GenericEvent) bool {
+				return false
+			},
+		})).
+		Watches(
+			&source.Kind{Type: &corev1.Pod{}},
+			handler.EnqueueRequestsFromMapFunc(nodeFromPullPod),
+			builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+				_, ok := obj.GetLabels()[LabelWarmupName]
+				return ok && obj.GetNamespace() == r.Namespace
+			})),
+		).
+		Complete(r)
+}
+
+func nodeFromPullPod(obj client.Object) []ctrl.Request {
+	return []ctrl.Request{{NamespacedName: types.NamespacedName{Name: obj.(*corev1.Pod).Spec.NodeName}}}
+}
@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNodeWarmupShouldWarmup(t *testing.T) {
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	selector, err := labels.Parse("node.kubernetes.io/instance-type=m5.large")
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+	reconciler := &NodeWarmupReconciler{NodeSelector: selector, MaxNodeAge: time.Hour}
+
+	tests := []struct {
+		name     string
+		node     metav1.ObjectMeta
+		expected bool
+	}{
+		{
+			name:     "New selected node",
+			node:     metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(now.Add(-time.Minute)), Labels: map[string]string{"node.kubernetes.io/instance-type": "m5.large"}},
+			expected: true,
+		},
+		{
+			name:     "Other instance type",
+			node:     metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(now.Add(-time.Minute)), Labels: map[string]string{"node.kubernetes.io/instance-type": "m5.xlarge"}},
+			expected: false,
+		},
+		{
+			name:     "Old node",
+			node:     metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(now.Add(-2 * time.Hour)), Labels: map[string]string{"node.kubernetes.io/instance-type": "m5.large"}},
+			expected: false,
+		},
+		{
+			name: "Already warmed up",
+			node: metav1.ObjectMeta{
+				CreationTimestamp: metav1.NewTime(now.Add(-time.Minute)),
+				Labels:            map[string]string{"node.kubernetes.io/instance-type": "m5.large"},
+				Annotations:       map[string]string{AnnotationWarmedUpAtName: now.Format(time.RFC3339)},
+			},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(reconciler.shouldWarmup(&corev1.Node{ObjectMeta: tt.node}, now)).To(Equal(tt.expected))
+		})
+	}
+}
+
+func TestNodeWarmupReconcile(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1", CreationTimestamp: metav1.Now(), Labels: map[string]string{"karpenter.sh/nodepool": "gpu"}},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	cachedImage := func(name string, sourceImage string, pullCount int64, isCached bool) *kuikv1alpha1.CachedImage {
+		return &kuikv1alpha1.CachedImage{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: sourceImage},
+			Status:     kuikv1alpha1.CachedImageStatus{IsCached: isCached, Usage: kuikv1alpha1.Usage{PullCount: pullCount}},
+		}
+	}
+
+	reconciler := &NodeWarmupReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(
+			node,
+			cachedImage("docker.io-library-nginx-1.25", "nginx:1.25", 42, true),
+			cachedImage("docker.io-library-redis-7", "redis:7", 7, true),
+			cachedImage("docker.io-library-alpine-latest", "alpine", 3, true),
+			cachedImage("docker.io-library-postgres-16", "postgres:16", 100, false),
+			&kuikv1alpha1.NodeImageProfile{
+				ObjectMeta: metav1.ObjectMeta{Name: "gpu"},
+				Spec: kuikv1alpha1.NodeImageProfileSpec{
+					NodeSelector: metav1.LabelSelector{MatchLabels: map[string]string{"karpenter.sh/nodepool": "gpu"}},
+					Images:       []string{"nvcr.io/nvidia/cuda:12.3.1-base-ubuntu22.04", "nginx:1.25"},
+				},
+			},
+			&kuikv1alpha1.NodeImageProfile{
+				ObjectMeta: metav1.ObjectMeta{Name: "arm"},
+				Spec: kuikv1alpha1.NodeImageProfileSpec{
+					NodeSelector: metav1.LabelSelector{MatchLabels: map[string]string{"kubernetes.io/arch": "arm64"}},
+					Images:       []string{"busybox:1.36"},
+				},
+			},
+		).Build(),
+		Recorder:   record.NewFakeRecorder(10),
+		Namespace:  "kuik-system",
+		TopImages:  2,
+		MaxNodeAge: time.Hour,
+		ProxyPort:  7439,
+	}
+	request := ctrl.Request{NamespacedName: types.NamespacedName{Name: "node-1"}}
+	_, err := reconciler.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Images of the matching profiles, then the most pulled cached images, are pulled on the node through its proxy
+	var pod corev1.Pod
+	podName := types.NamespacedName{Namespace: "kuik-system", Name: "kuik-warmup-node-1"}
+	g.Expect(reconciler.Get(ctx, podName, &pod)).To(Succeed())
+	g.Expect(pod.Spec.NodeName).To(Equal("node-1"))
+	images := []string{}
+	for _, container := range pod.Spec.Containers {
+		images = append(images, container.Image)
+	}
+	g.Expect(images).To(Equal([]string{
+		"localhost:7439/nvcr.io/nvidia/cuda:12.3.1-base-ubuntu22.04",
+		"localhost:7439/nginx:1.25",
+		"localhost:7439/redis:7",
+		"localhost:7439/alpine",
+	}))
+
+	// Images of profiles are put in cache
+	var cuda kuikv1alpha1.CachedImage
+	g.Expect(reconciler.Get(ctx, types.NamespacedName{Name: "nvcr.io-nvidia-cuda-12.3.1-base-ubuntu22.04"}, &cuda)).To(Succeed())
+
+	g.Expect(reconciler.Get(ctx, request.NamespacedName, node)).To(Succeed())
+	g.Expect(node.Annotations).To(HaveKey(AnnotationWarmedUpAtName))
+
+	// The pod is deleted once done, and the node is not warmed up again
+	pod.Status.Phase = corev1.PodSucceeded
+	g.Expect(reconciler.Status().Update(ctx, &pod)).To(Succeed())
+	_, err = reconciler.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	err = reconciler.Get(ctx, podName, &pod)
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+	_, err = reconciler.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	err = reconciler.Get(ctx, podName, &pod)
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+}
@@ -17,6 +17,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
@@ -33,15 +34,63 @@ const cachedImageOwnerKey = ".metadata.podOwner"
 const LabelManagedName = "kuik.enix.io/managed"
 const AnnotationRewriteImagesName = "kuik.enix.io/rewrite-images"
 
+// AnnotationSkipContainersName lists, separated by commas, the containers of a pod whose image must not be rewritten
+const AnnotationSkipContainersName = "kuik.enix.io/skip-containers"
+
+// AnnotationImageEnvVarsName lists, separated by commas, the environment variables of the containers of a pod holding
+// images that must be rewritten and cached as well, e.g. images of pods spawned by a CI runner
+const AnnotationImageEnvVarsName = "kuik.enix.io/image-env-vars"
+
+// ImageEnvVarNames returns the names of the environment variables holding images listed by the image env vars
+// annotation of a pod
+func ImageEnvVarNames(pod *corev1.Pod) map[string]bool {
+	names := map[string]bool{}
+	for _, name := range strings.Split(pod.Annotations[AnnotationImageEnvVarsName], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// AnnotationAdmittedAtName is the time a new pod has been admitted by the webhook, in RFC 3339 format with nanoseconds.
+// It is copied to the CachedImages created for the pod, to measure the delay before their caching starts.
+const AnnotationAdmittedAtName = "kuik.enix.io/admitted-at"
+
+// AnnotationArchitecturesNotCachedName lists the images of a pod that are not rewritten because none of their
+// platforms matching the pod, i.e. its operating system and architecture, is put in cache
+const AnnotationArchitecturesNotCachedName = "kuik.enix.io/architectures-not-cached"
+
+// AnnotationDroppedImagePullSecretsName lists, separated by commas, the image pull secrets removed from a pod whose
+// images are all rewritten, see ImageRewriter.DropImagePullSecrets
+const AnnotationDroppedImagePullSecretsName = "kuik.enix.io/dropped-image-pull-secrets"
+
+// AnnotationPullHintsFromPodsName lists, separated by commas, the pull hint annotations of a CachedImage copied from the
+// pods using its image, so that they are removed once no pod sets them anymore
+const AnnotationPullHintsFromPodsName = "kuik.enix.io/pull-hints-from-pods"
+
+// DroppedImagePullSecretNames returns the names of the image pull secrets removed from a pod by the webhook
+func DroppedImagePullSecretNames(pod *corev1.Pod) []string {
+	names := []string{}
+	for _, name := range strings.Split(pod.Annotations[AnnotationDroppedImagePullSecretsName], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 // PodReconciler reconciles a Pod object
 type PodReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
 }
 
 //+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=pods/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=core,resources=pods/finalizers,verbs=update
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -68,7 +117,11 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 		return ctrl.Result{}, nil
 	}
 
-	cachedImages := desiredCachedImages(ctx, &pod)
+	if images := pod.Annotations[AnnotationArchitecturesNotCachedName]; images != "" && pod.Status.Phase == corev1.PodPending {
+		r.Recorder.Eventf(&pod, "Warning", "ArchitecturesNotCached", "Images %s are not cached since none of their platforms matching the pod is put in cache", strings.ReplaceAll(images, ",", ", "))
+	}
+
+	cachedImages := DesiredCachedImages(ctx, &pod)
 	repositories, err := r.desiredRepositories(ctx, &pod, cachedImages)
 	if err != nil {
 		return ctrl.Result{}, client.IgnoreNotFound(err)
@@ -82,10 +135,15 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 			repo.Spec.Name = repository.Spec.Name
 			repo.Spec.PullSecretNames = repository.Spec.PullSecretNames
 			repo.Spec.PullSecretsNamespace = repository.Spec.PullSecretsNamespace
+			metav1.SetMetaDataLabel(&repo.ObjectMeta, kuikv1alpha1.RegistryLabelName, repository.Labels[kuikv1alpha1.RegistryLabelName])
 			return nil
 		})
 
-		if err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			// Created concurrently by another reconcile since it was fetched, patch it on the next one
+			log.Info("repository created concurrently, requeuing", "repository", klog.KObj(&repository))
+			return ctrl.Result{Requeue: true}, nil
+		} else if err != nil {
 			return ctrl.Result{}, err
 		}
 
@@ -107,14 +165,27 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 
 		// Create or update CachedImage depending on weather it already exists or not
 		if apierrors.IsNotFound(err) {
+			if admittedAt := pod.Annotations[AnnotationAdmittedAtName]; admittedAt != "" {
+				metav1.SetMetaDataAnnotation(&cachedImage.ObjectMeta, AnnotationAdmittedAtName, admittedAt)
+			}
+			if err := r.updatePullHints(ctx, &pod, &cachedImage); err != nil {
+				return ctrl.Result{}, err
+			}
+			// Pods using the same image may be reconciled concurrently, the CachedImage created by another reconcile has
+			// the same source image since its name is derived from it
 			err = r.Create(ctx, &cachedImage)
-			if err != nil {
+			if apierrors.IsAlreadyExists(err) {
+				log.Info("cachedimage created concurrently", "cachedImage", klog.KObj(&cachedImage))
+			} else if err != nil {
 				return ctrl.Result{}, err
 			}
 		} else {
 			patch := client.MergeFrom(ci.DeepCopy())
 
 			ci.Spec.SourceImage = cachedImage.Spec.SourceImage
+			if err := r.updatePullHints(ctx, &pod, &ci); err != nil {
+				return ctrl.Result{}, err
+			}
 
 			if err = r.Patch(ctx, &ci, patch); err != nil {
 				return ctrl.Result{}, err
@@ -128,6 +199,49 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 	return ctrl.Result{}, nil
 }
 
+// updatePullHints sets the pull timeout and the expected size of a CachedImage of one of the images of a pod to the
+// largest ones set by the annotations of the pods using the image, so that the pod needing the most time is not
+// overridden by the others. Hints copied from pods that none of them sets anymore are removed, while the ones set on the
+// CachedImage itself are kept.
+func (r *PodReconciler) updatePullHints(ctx context.Context, pod *corev1.Pod, cachedImage *kuikv1alpha1.CachedImage) error {
+	copied := strings.Split(cachedImage.Annotations[AnnotationPullHintsFromPodsName], ",")
+	if cachedImage.Annotations[AnnotationPullHintsFromPodsName] == "" && !hasPullHints(pod) {
+		return nil
+	}
+
+	var podList corev1.PodList
+	if err := r.List(ctx, &podList, client.MatchingFields{cachedImageOwnerKey: cachedImage.Name}); err != nil {
+		return err
+	}
+	pods := []corev1.Pod{*pod}
+	for _, other := range podList.Items {
+		if other.UID != pod.UID && other.DeletionTimestamp.IsZero() {
+			pods = append(pods, other)
+		}
+	}
+
+	hints := mergePullHints(pods)
+	for _, annotation := range copied {
+		if _, ok := hints[annotation]; !ok && annotation != "" {
+			delete(cachedImage.Annotations, annotation)
+		}
+	}
+	copied = []string{}
+	for _, annotation := range pullHintAnnotations {
+		if value, ok := hints[annotation]; ok {
+			metav1.SetMetaDataAnnotation(&cachedImage.ObjectMeta, annotation, value)
+			copied = append(copied, annotation)
+		}
+	}
+	if len(copied) > 0 {
+		metav1.SetMetaDataAnnotation(&cachedImage.ObjectMeta, AnnotationPullHintsFromPodsName, strings.Join(copied, ","))
+	} else {
+		delete(cachedImage.Annotations, AnnotationPullHintsFromPodsName)
+	}
+
+	return nil
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *PodReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	p := predicate.Funcs{
@@ -205,6 +319,9 @@ func (r *PodReconciler) desiredRepositories(ctx context.Context, pod *corev1.Pod
 		repositories[repositoryName] = kuikv1alpha1.Repository{
 			ObjectMeta: metav1.ObjectMeta{
 				Name: registry.SanitizeName(repositoryName),
+				Labels: map[string]string{
+					kuikv1alpha1.RegistryLabelName: kuikv1alpha1.RegistryLabel(named),
+				},
 			},
 			Spec: kuikv1alpha1.RepositorySpec{
 				Name:                 repositoryName,
@@ -217,10 +334,27 @@ func (r *PodReconciler) desiredRepositories(ctx context.Context, pod *corev1.Pod
 	return maps.Values(repositories), nil
 }
 
-func desiredCachedImages(ctx context.Context, pod *corev1.Pod) []kuikv1alpha1.CachedImage {
+// DesiredCachedImages returns the CachedImages required by a pod, containers using the same image share the same CachedImage
+func DesiredCachedImages(ctx context.Context, pod *corev1.Pod) []kuikv1alpha1.CachedImage {
 	cachedImages := desiredCachedImagesForContainers(ctx, pod.Spec.Containers, pod.Annotations, false)
 	cachedImages = append(cachedImages, desiredCachedImagesForContainers(ctx, pod.Spec.InitContainers, pod.Annotations, true)...)
-	return cachedImages
+	cachedImages = append(cachedImages, desiredCachedImagesForEphemeralContainers(ctx, pod.Spec.EphemeralContainers, pod.Annotations)...)
+	cachedImages = append(cachedImages, desiredCachedImagesForEnvVars(ctx, pod.Spec.Containers, pod.Annotations, false)...)
+	cachedImages = append(cachedImages, desiredCachedImagesForEnvVars(ctx, pod.Spec.InitContainers, pod.Annotations, true)...)
+	cachedImages = append(cachedImages, desiredCachedImagesForFields(ctx, pod.Spec.Containers, pod.Annotations, false)...)
+	cachedImages = append(cachedImages, desiredCachedImagesForFields(ctx, pod.Spec.InitContainers, pod.Annotations, true)...)
+
+	seen := map[string]bool{}
+	uniqueCachedImages := []kuikv1alpha1.CachedImage{}
+	for _, cachedImage := range cachedImages {
+		if seen[cachedImage.Name] {
+			continue
+		}
+		seen[cachedImage.Name] = true
+		uniqueCachedImages = append(uniqueCachedImages, cachedImage)
+	}
+
+	return uniqueCachedImages
 }
 
 func desiredCachedImagesForContainers(ctx context.Context, containers []corev1.Container, annotations map[string]string, initContainer bool) []kuikv1alpha1.CachedImage {
@@ -237,7 +371,7 @@ func desiredCachedImagesForContainers(ctx context.Context, containers []corev1.C
 			continue
 		}
 
-		cachedImage, err := cachedImageFromSourceImage(sourceImage)
+		cachedImage, err := CachedImageFromSourceImage(sourceImage)
 		if err != nil {
 			containerLog.Error(err, "could not create cached image, ignoring")
 			continue
@@ -250,7 +384,128 @@ func desiredCachedImagesForContainers(ctx context.Context, containers []corev1.C
 	return cachedImages
 }
 
-func cachedImageFromSourceImage(sourceImage string) (*kuikv1alpha1.CachedImage, error) {
+// desiredCachedImagesForEnvVars is like desiredCachedImagesForContainers for images held by environment variables of
+// containers, see AnnotationImageEnvVarsName
+func desiredCachedImagesForEnvVars(ctx context.Context, containers []corev1.Container, annotations map[string]string, initContainer bool) []kuikv1alpha1.CachedImage {
+	log := log.FromContext(ctx)
+	cachedImages := []kuikv1alpha1.CachedImage{}
+
+	for _, container := range containers {
+		for _, env := range container.Env {
+			annotationKey := registry.EnvAnnotationKey(container.Name, env.Name, initContainer)
+			sourceImage, ok := annotations[annotationKey]
+			if !ok {
+				continue
+			}
+
+			envLog := log.WithValues("container", container.Name, "env", env.Name, "annotationKey", annotationKey)
+			cachedImage, err := CachedImageFromSourceImage(sourceImage)
+			if err != nil {
+				envLog.Error(err, "could not create cached image, ignoring")
+				continue
+			}
+			cachedImages = append(cachedImages, *cachedImage)
+
+			envLog.V(1).Info("desired CachedImage for environment variable", "sourceImage", cachedImage.Spec.SourceImage)
+		}
+	}
+
+	return cachedImages
+}
+
+// desiredCachedImagesForFields is like desiredCachedImagesForContainers for images held by fields of containers selected
+// by the image paths annotation, see AnnotationImagePathsName
+func desiredCachedImagesForFields(ctx context.Context, containers []corev1.Container, annotations map[string]string, initContainer bool) []kuikv1alpha1.CachedImage {
+	log := log.FromContext(ctx)
+	cachedImages := []kuikv1alpha1.CachedImage{}
+
+	for i := range containers {
+		container := &containers[i]
+		fields, err := ContainerImageFields(container, annotations[AnnotationImagePathsName])
+		if err != nil {
+			log.Error(err, "could not read the fields holding images, ignoring", "container", container.Name)
+			continue
+		}
+
+		for _, field := range fields {
+			annotationKey := registry.FieldAnnotationKey(container.Name, field.Location, initContainer)
+			sourceImage, ok := annotations[annotationKey]
+			if !ok {
+				continue
+			}
+
+			fieldLog := log.WithValues("container", container.Name, "field", field.Location, "annotationKey", annotationKey)
+			cachedImage, err := CachedImageFromSourceImage(sourceImage)
+			if err != nil {
+				fieldLog.Error(err, "could not create cached image, ignoring")
+				continue
+			}
+			cachedImages = append(cachedImages, *cachedImage)
+
+			fieldLog.V(1).Info("desired CachedImage for field", "sourceImage", cachedImage.Spec.SourceImage)
+		}
+	}
+
+	return cachedImages
+}
+
+// desiredCachedImagesForEphemeralContainers is like desiredCachedImagesForContainers for ephemeral containers. Since the
+// API server only keeps changes of ephemeral containers when they are added to a pod, the annotations set by the webhook
+// are usually lost: the source image is then read from the rewritten image.
+func desiredCachedImagesForEphemeralContainers(ctx context.Context, containers []corev1.EphemeralContainer, annotations map[string]string) []kuikv1alpha1.CachedImage {
+	log := log.FromContext(ctx)
+	cachedImages := []kuikv1alpha1.CachedImage{}
+
+	for _, container := range containers {
+		annotationKey := registry.EphemeralContainerAnnotationKey(container.Name)
+		containerLog := log.WithValues("ephemeralContainer", container.Name, "annotationKey", annotationKey)
+
+		sourceImage, ok := annotations[annotationKey]
+		if !ok {
+			if !registry.ProxyHostRegexp.MatchString(container.Image) {
+				containerLog.V(1).Info("image not rewritten, ignoring")
+				continue
+			}
+			sourceImage = registry.ProxiedImage(container.Image)
+		}
+
+		cachedImage, err := CachedImageFromSourceImage(sourceImage)
+		if err != nil {
+			containerLog.Error(err, "could not create cached image, ignoring")
+			continue
+		}
+		cachedImages = append(cachedImages, *cachedImage)
+
+		containerLog.V(1).Info("desired CachedImage for ephemeral container", "sourceImage", cachedImage.Spec.SourceImage)
+	}
+
+	return cachedImages
+}
+
+// pullsAlways tells whether a pod pulls the image of the CachedImage with the given name with imagePullPolicy Always
+func pullsAlways(pod *corev1.Pod, cachedImageName string) bool {
+	containers := map[bool][]corev1.Container{false: pod.Spec.Containers, true: pod.Spec.InitContainers}
+	for initContainer, containers := range containers {
+		for _, container := range containers {
+			if container.ImagePullPolicy != corev1.PullAlways {
+				continue
+			}
+			sourceImage, ok := pod.Annotations[registry.ContainerAnnotationKey(container.Name, initContainer)]
+			if !ok {
+				continue
+			}
+			if cachedImage, err := CachedImageFromSourceImage(sourceImage); err == nil && cachedImage.Name == cachedImageName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CachedImageFromSourceImage returns the CachedImage caching the given image, images referenced both by tag and digest
+// being cached by digest only
+func CachedImageFromSourceImage(sourceImage string) (*kuikv1alpha1.CachedImage, error) {
+	sourceImage = registry.TrimDigestedTag(sourceImage)
 	ref, err := reference.ParseAnyReference(sourceImage)
 	if err != nil {
 		return nil, err
@@ -276,7 +531,7 @@ func cachedImageFromSourceImage(sourceImage string) (*kuikv1alpha1.CachedImage,
 
 func (r *PodReconciler) imagePullSecretNamesFromPod(ctx context.Context, pod *corev1.Pod) ([]string, error) {
 	if pod.Spec.ServiceAccountName == "" {
-		return []string{}, nil
+		return DroppedImagePullSecretNames(pod), nil
 	}
 
 	var serviceAccount corev1.ServiceAccount
@@ -292,5 +547,6 @@ func (r *PodReconciler) imagePullSecretNamesFromPod(ctx context.Context, pod *co
 		imagePullSecretNames[i] = imagePullSecret.Name
 	}
 
-	return imagePullSecretNames, nil
+	// Images are still pulled from their upstream registry with the image pull secrets removed by the webhook
+	return append(imagePullSecretNames, DroppedImagePullSecretNames(pod)...), nil
 }
@@ -2,16 +2,22 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/enix/kube-image-keeper/api/v1alpha1"
 	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
 	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/enix/kube-image-keeper/internal/scheme"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 var podStub = corev1.Pod{
@@ -54,6 +60,38 @@ var podStubNotRewritten = corev1.Pod{
 	},
 }
 
+var podStubWithIdenticalImages = func() corev1.Pod {
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-pod-identical-images",
+			Namespace: "default",
+			Annotations: map[string]string{
+				registry.ContainerAnnotationKey("init", true):     "nginx",
+				registry.ContainerAnnotationKey("sidecar", false): "envoyproxy/envoy:v1.28.0",
+			},
+			Labels: map[string]string{
+				LabelManagedName: "true",
+			},
+		},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{
+				{Name: "init", Image: "nginx"},
+			},
+			Containers: []corev1.Container{
+				{Name: "sidecar", Image: "envoyproxy/envoy:v1.28.0"},
+			},
+		},
+	}
+
+	for i := 0; i < 25; i++ {
+		name := fmt.Sprintf("app-%d", i)
+		pod.Annotations[registry.ContainerAnnotationKey(name, false)] = "nginx"
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: name, Image: "nginx"})
+	}
+
+	return pod
+}()
+
 func TestDesiredCachedImages(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -75,12 +113,24 @@ func TestDesiredCachedImages(t *testing.T) {
 				}},
 			},
 		},
+		{
+			name: "identical images",
+			pod:  podStubWithIdenticalImages,
+			cachedImages: []v1alpha1.CachedImage{
+				{Spec: kuikv1alpha1.CachedImageSpec{
+					SourceImage: "envoyproxy/envoy:v1.28.0",
+				}},
+				{Spec: kuikv1alpha1.CachedImageSpec{
+					SourceImage: "nginx",
+				}},
+			},
+		},
 	}
 
 	g := NewWithT(t)
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			cachedImages := desiredCachedImages(context.Background(), &tt.pod)
+			cachedImages := DesiredCachedImages(context.Background(), &tt.pod)
 			g.Expect(cachedImages).To(HaveLen(len(tt.cachedImages)))
 			for i, cachedImage := range cachedImages {
 				g.Expect(cachedImage.Spec.SourceImage).To(Equal(tt.cachedImages[i].Spec.SourceImage))
@@ -89,7 +139,60 @@ func TestDesiredCachedImages(t *testing.T) {
 	}
 }
 
-func Test_cachedImageFromSourceImage(t *testing.T) {
+func TestDesiredCachedImagesForEphemeralContainers(t *testing.T) {
+	g := NewWithT(t)
+
+	pod := podStub.DeepCopy()
+	pod.Annotations[registry.EphemeralContainerAnnotationKey("annotated")] = "quay.io/prometheus/busybox:latest"
+	pod.Spec.EphemeralContainers = []corev1.EphemeralContainer{
+		{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "annotated", Image: "localhost:7439/quay.io/prometheus/busybox:latest"}},
+		// Annotations set by the webhook are dropped by the API server when ephemeral containers are added
+		{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger", Image: "localhost:7439/docker.io/library/alpine:3.19"}},
+		// The port of the origin registry is sanitized in rewritten images
+		{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "private", Image: "localhost:7439/host-5000/app:v1"}},
+		{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "not-rewritten", Image: "ubuntu:22.04"}},
+	}
+
+	sourceImages := []string{}
+	for _, cachedImage := range DesiredCachedImages(context.Background(), pod) {
+		sourceImages = append(sourceImages, cachedImage.Spec.SourceImage)
+	}
+	g.Expect(sourceImages).To(ConsistOf("nginx", "busybox", "alpine", "quay.io/prometheus/busybox:latest", "docker.io/library/alpine:3.19", "host:5000/app:v1"))
+}
+
+func TestDesiredCachedImagesForEnvVars(t *testing.T) {
+	g := NewWithT(t)
+
+	pod := podStub.DeepCopy()
+	pod.Annotations[registry.EnvAnnotationKey("b", "HELPER_IMAGE", false)] = "gitlab/gitlab-runner-helper:x86_64-latest"
+	pod.Spec.Containers[0].Env = []corev1.EnvVar{
+		{Name: "HELPER_IMAGE", Value: "localhost:7439/gitlab/gitlab-runner-helper:x86_64-latest"},
+		{Name: "OTHER_IMAGE", Value: "ubuntu:22.04"},
+	}
+
+	sourceImages := []string{}
+	for _, cachedImage := range DesiredCachedImages(context.Background(), pod) {
+		sourceImages = append(sourceImages, cachedImage.Spec.SourceImage)
+	}
+	g.Expect(sourceImages).To(ConsistOf("nginx", "busybox", "alpine", "gitlab/gitlab-runner-helper:x86_64-latest"))
+}
+
+func TestDesiredCachedImagesForFields(t *testing.T) {
+	g := NewWithT(t)
+
+	pod := podStub.DeepCopy()
+	pod.Annotations[AnnotationImagePathsName] = "{.args[1]}"
+	pod.Annotations[registry.FieldAnnotationKey("b", "args.1", false)] = "gitlab/gitlab-runner-helper:x86_64-latest"
+	pod.Spec.Containers[0].Args = []string{"--helper-image", "localhost:7439/gitlab/gitlab-runner-helper:x86_64-latest"}
+
+	sourceImages := []string{}
+	for _, cachedImage := range DesiredCachedImages(context.Background(), pod) {
+		sourceImages = append(sourceImages, cachedImage.Spec.SourceImage)
+	}
+	g.Expect(sourceImages).To(ConsistOf("nginx", "busybox", "alpine", "gitlab/gitlab-runner-helper:x86_64-latest"))
+}
+
+func Test_CachedImageFromSourceImage(t *testing.T) {
 	tests := []struct {
 		name               string
 		sourceImage        string
@@ -119,7 +222,7 @@ func Test_cachedImageFromSourceImage(t *testing.T) {
 	g := NewWithT(t)
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			cachedImage, err := cachedImageFromSourceImage(tt.sourceImage)
+			cachedImage, err := CachedImageFromSourceImage(tt.sourceImage)
 			g.Expect(err).ToNot(HaveOccurred())
 
 			g.Expect(cachedImage.Name).To(Equal(tt.expectedName))
@@ -198,3 +301,145 @@ var _ = Describe("Pod Controller", func() {
 		})
 	})
 })
+
+func Test_pullsAlways(t *testing.T) {
+	g := NewWithT(t)
+
+	pod := podStub.DeepCopy()
+	g.Expect(pullsAlways(pod, "docker.io-library-nginx-latest")).To(BeFalse())
+
+	pod.Spec.Containers[0].ImagePullPolicy = corev1.PullAlways
+	g.Expect(pullsAlways(pod, "docker.io-library-nginx-latest")).To(BeTrue())
+	g.Expect(pullsAlways(pod, "docker.io-library-busybox-latest")).To(BeFalse())
+
+	pod.Spec.InitContainers[0].ImagePullPolicy = corev1.PullAlways
+	g.Expect(pullsAlways(pod, "docker.io-library-alpine-latest")).To(BeTrue())
+
+	// Containers that have not been rewritten are not pulled through the proxy
+	g.Expect(pullsAlways(&podStubNotRewritten, "docker.io-library-nginx-latest")).To(BeFalse())
+}
+
+// staleCachedImagesClient doesn't find CachedImages, like a client whose cache is not synced yet with a CachedImage
+// created concurrently
+type staleCachedImagesClient struct {
+	client.Client
+}
+
+func (c *staleCachedImagesClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if _, ok := obj.(*kuikv1alpha1.CachedImage); ok {
+		return apierrors.NewNotFound(kuikv1alpha1.GroupVersion.WithResource("cachedimages").GroupResource(), key.Name)
+	}
+	return c.Client.Get(ctx, key, obj, opts...)
+}
+
+func TestPodReconcileCachedImageCreatedConcurrently(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	pod := podStub.DeepCopy()
+	existing, err := CachedImageFromSourceImage("nginx")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	reconciler := &PodReconciler{
+		Client: &staleCachedImagesClient{
+			Client: fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(pod, existing).Build(),
+		},
+	}
+	result, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result).To(Equal(ctrl.Result{}))
+
+	cachedImages := kuikv1alpha1.CachedImageList{}
+	g.Expect(reconciler.Client.List(ctx, &cachedImages)).To(Succeed())
+	g.Expect(cachedImages.Items).To(HaveLen(3))
+}
+
+func TestPodReconcileAdmittedAt(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	pod := podStub.DeepCopy()
+	pod.Annotations[AnnotationAdmittedAtName] = "2024-01-15T08:04:12.123456789Z"
+
+	reconciler := &PodReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(pod).Build(),
+	}
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// CachedImages created for the pod carry its admission time
+	cachedImages := kuikv1alpha1.CachedImageList{}
+	g.Expect(reconciler.Client.List(ctx, &cachedImages)).To(Succeed())
+	g.Expect(cachedImages.Items).To(HaveLen(3))
+	for _, cachedImage := range cachedImages.Items {
+		g.Expect(cachedImage.Annotations).To(HaveKeyWithValue(AnnotationAdmittedAtName, "2024-01-15T08:04:12.123456789Z"))
+	}
+}
+
+func TestPodReconcilePullHints(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	pod := podStub.DeepCopy()
+	pod.UID = "pod"
+	pod.Annotations[kuikv1alpha1.PullTimeoutAnnotationName] = "6h"
+	other := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "other-pod",
+			Namespace: "default",
+			UID:       "other-pod",
+			Annotations: map[string]string{
+				registry.ContainerAnnotationKey("a", false): "nginx",
+				kuikv1alpha1.PullTimeoutAnnotationName:      "12h",
+				kuikv1alpha1.ExpectedSizeAnnotationName:     "10Gi",
+			},
+			Labels: map[string]string{LabelManagedName: "true"},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "a", Image: "nginx:1.22"}}},
+	}
+	existing, err := CachedImageFromSourceImage("nginx")
+	g.Expect(err).ToNot(HaveOccurred())
+	existing.Annotations = map[string]string{kuikv1alpha1.ExpectedSizeAnnotationName: "40Gi"}
+
+	reconciler := &PodReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(pod, other, existing).
+			WithIndex(&corev1.Pod{}, cachedImageOwnerKey, func(obj client.Object) []string {
+				names := []string{}
+				for _, cachedImage := range DesiredCachedImages(ctx, obj.(*corev1.Pod)) {
+					names = append(names, cachedImage.Name)
+				}
+				return names
+			}).Build(),
+	}
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Created and existing CachedImages get the longest timeout and the largest size set by the pods using them
+	cachedImages := kuikv1alpha1.CachedImageList{}
+	g.Expect(reconciler.Client.List(ctx, &cachedImages)).To(Succeed())
+	g.Expect(cachedImages.Items).To(HaveLen(3))
+	for _, cachedImage := range cachedImages.Items {
+		if cachedImage.Name == existing.Name {
+			g.Expect(cachedImage.Annotations).To(HaveKeyWithValue(kuikv1alpha1.PullTimeoutAnnotationName, "12h"))
+			g.Expect(cachedImage.Annotations).To(HaveKeyWithValue(kuikv1alpha1.ExpectedSizeAnnotationName, "10Gi"))
+		} else {
+			g.Expect(cachedImage.Annotations).To(HaveKeyWithValue(kuikv1alpha1.PullTimeoutAnnotationName, "6h"))
+		}
+	}
+
+	// Hints that no pod sets anymore are removed, while the ones set on the CachedImage itself are kept
+	g.Expect(reconciler.Client.Get(ctx, client.ObjectKeyFromObject(existing), existing)).To(Succeed())
+	existing.Annotations[kuikv1alpha1.ExpectedSizeAnnotationName] = "40Gi"
+	existing.Annotations[AnnotationPullHintsFromPodsName] = kuikv1alpha1.PullTimeoutAnnotationName
+	g.Expect(reconciler.Client.Update(ctx, existing)).To(Succeed())
+	g.Expect(reconciler.Client.Delete(ctx, other)).To(Succeed())
+	g.Expect(reconciler.Client.Get(ctx, client.ObjectKeyFromObject(pod), pod)).To(Succeed())
+	delete(pod.Annotations, kuikv1alpha1.PullTimeoutAnnotationName)
+	g.Expect(reconciler.Client.Update(ctx, pod)).To(Succeed())
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(reconciler.Client.Get(ctx, client.ObjectKeyFromObject(existing), existing)).To(Succeed())
+	g.Expect(existing.Annotations).ToNot(HaveKey(kuikv1alpha1.PullTimeoutAnnotationName))
+	g.Expect(existing.Annotations).ToNot(HaveKey(AnnotationPullHintsFromPodsName))
+	g.Expect(existing.Annotations).To(HaveKeyWithValue(kuikv1alpha1.ExpectedSizeAnnotationName, "40Gi"))
+}
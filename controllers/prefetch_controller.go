@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"context"
+	"crypto/x509"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/strings/slices"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/prefetch"
+	"github.com/enix/kube-image-keeper/internal/registry"
+)
+
+// PrefetchReconciler learns when CachedImages are requested by pods and refreshes them shortly before
+type PrefetchReconciler struct {
+	client.Client
+	Scheme             *runtime.Scheme
+	Recorder           record.EventRecorder
+	ApiReader          client.Reader
+	LeadTime           time.Duration
+	MinRequests        int
+	Architectures      []string
+	InsecureRegistries []string
+	RootCAs            *x509.CertPool
+}
+
+//+kubebuilder:rbac:groups=kuik.enix.io,resources=cachedimages,verbs=get;list;watch
+//+kubebuilder:rbac:groups=kuik.enix.io,resources=cachedimages/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+
+// Reconcile records pods using a CachedImage into its request history and refreshes the image in cache
+// when a request is predicted to happen within the configured lead time.
+func (r *PrefetchReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var cachedImage kuikv1alpha1.CachedImage
+	if err := r.Get(ctx, req.NamespacedName, &cachedImage); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !cachedImage.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	log = log.WithValues("sourceImage", cachedImage.Spec.SourceImage)
+	patch := client.MergeFrom(cachedImage.DeepCopy())
+	status := cachedImage.Status.Prefetch.DeepCopy()
+	if status == nil {
+		status = &kuikv1alpha1.Prefetch{}
+	}
+
+	// Record pods created since the last reconciliation, including the ones created during the same second as the last
+	// recorded pod but not recorded yet
+	var podsList corev1.PodList
+	if err := r.List(ctx, &podsList, client.MatchingFields{cachedImageOwnerKey: cachedImage.Name}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	lastRecordedAt, lastRecordedPods := status.LastRecordedAt, status.LastRecordedPods
+	for _, pod := range podsList.Items {
+		createdAt := pod.CreationTimestamp
+		if status.LastRecordedAt != nil && (createdAt.Before(status.LastRecordedAt) ||
+			createdAt.Equal(status.LastRecordedAt) && slices.Contains(status.LastRecordedPods, string(pod.UID))) {
+			continue
+		}
+		status.History = prefetch.Record(status.History, createdAt.Time)
+		switch {
+		case lastRecordedAt == nil || createdAt.After(lastRecordedAt.Time):
+			lastRecordedAt = createdAt.DeepCopy()
+			lastRecordedPods = []string{string(pod.UID)}
+		case createdAt.Equal(lastRecordedAt):
+			lastRecordedPods = append(lastRecordedPods, string(pod.UID))
+		}
+	}
+	status.LastRecordedAt, status.LastRecordedPods = lastRecordedAt, lastRecordedPods
+
+	// Refresh the image if a prefetch is due, missed prefetches older than the lead time are not caught up
+	now := time.Now()
+	after := now.Add(-r.LeadTime)
+	if status.LastPrefetchAt != nil && status.LastPrefetchAt.After(after) {
+		after = status.LastPrefetchAt.Time
+	}
+
+	due, err := prefetch.Predict(status.History, after, r.MinRequests, r.LeadTime)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if due != nil && !due.PrefetchAt.After(now) {
+		if pausedBy, err := cachedImage.UpstreamPausedBy(ctx, r); err != nil {
+			return ctrl.Result{}, err
+		} else if pausedBy != "" {
+			log.Info("upstream registry is paused, delaying prefetch", "pausedBy", pausedBy)
+			return upstreamPausedResult(), nil
+		}
+		log.Info("prefetching image", "reason", due.Explain())
+		r.Recorder.Eventf(&cachedImage, "Normal", "Prefetching", "Refreshing image %s: %s", cachedImage.Spec.SourceImage, due.Explain())
+		if err := r.cacheImage(ctx, &cachedImage); err != nil {
+			if budgetErr, ok := err.(*registry.BudgetExceededError); ok {
+				log.Info("upstream budget exhausted, delaying prefetch", "retryAfter", budgetErr.RetryAfter)
+				r.Recorder.Eventf(&cachedImage, "Normal", "PrefetchDelayed", "Delaying refresh of image %s: %s", cachedImage.Spec.SourceImage, err)
+				upstreamBudgetExceeded.Inc()
+				return ctrl.Result{RequeueAfter: budgetErr.RetryAfter}, nil
+			}
+			log.Error(err, "failed to prefetch image")
+			r.Recorder.Eventf(&cachedImage, "Warning", "PrefetchFailed", "Failed to refresh image %s, reason: %s", cachedImage.Spec.SourceImage, err)
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Eventf(&cachedImage, "Normal", "Prefetched", "Successfully refreshed image %s", cachedImage.Spec.SourceImage)
+		status.LastPrefetchAt = &metav1.Time{Time: now}
+	}
+
+	// Schedule the next prefetch
+	next, err := prefetch.Predict(status.History, now, r.MinRequests, r.LeadTime)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	result := ctrl.Result{}
+	if next != nil {
+		status.NextPrefetchAt = &metav1.Time{Time: next.PrefetchAt}
+		status.Reason = next.Explain()
+		result.RequeueAfter = time.Until(next.PrefetchAt)
+	} else {
+		status.NextPrefetchAt = nil
+		status.Reason = ""
+	}
+
+	cachedImage.Status.Prefetch = status
+	if err := r.Status().Patch(ctx, &cachedImage, patch); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	return result, nil
+}
+
+func (r *PrefetchReconciler) cacheImage(ctx context.Context, cachedImage *kuikv1alpha1.CachedImage) error {
+	pullSecrets, err := cachedImage.GetPullSecrets(r.ApiReader)
+	if err != nil {
+		return err
+	}
+
+	if err := registry.CacheImage(ctx, cachedImage.Spec.SourceImage, pullSecrets, r.Architectures, r.InsecureRegistries, r.RootCAs, nil); err != nil {
+		return err
+	}
+	copyToPreviousRegistry(ctx, cachedImage.Spec.SourceImage)
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager. It relies on the pods index created
+// by the CachedImageReconciler, which must thus be set up first.
+func (r *PrefetchReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("prefetch").
+		For(&kuikv1alpha1.CachedImage{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(
+			&source.Kind{Type: &corev1.Pod{}},
+			handler.EnqueueRequestsFromMapFunc(cachedImagesRequestFromPod),
+			builder.WithPredicates(predicate.Funcs{
+				CreateFunc: func(e event.CreateEvent) bool {
+					return true
+				},
+				UpdateFunc: func(e event.UpdateEvent) bool {
+					return false
+				},
+				DeleteFunc: func(e event.DeleteEvent) bool {
+					return false
+				},
+				GenericFunc: func(e event.GenericEvent) bool {
+					return false
+				},
+			}),
+		).
+		Complete(r)
+}
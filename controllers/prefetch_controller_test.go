@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestPrefetchReconcileRecordsPods(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	cachedImage := &kuikv1alpha1.CachedImage{
+		ObjectMeta: metav1.ObjectMeta{Name: "docker.io-library-nginx-1.25"},
+		Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: "nginx:1.25"},
+	}
+	createdAt := metav1.NewTime(time.Date(2024, time.January, 1, 8, 0, 0, 0, time.UTC))
+	newPod := func(name string, createdAt metav1.Time) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "default",
+			UID:               types.UID(name),
+			CreationTimestamp: createdAt,
+		}}
+	}
+
+	reconciler := &PrefetchReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(cachedImage, newPod("a", createdAt)).
+			WithIndex(&corev1.Pod{}, cachedImageOwnerKey, func(obj client.Object) []string {
+				return []string{cachedImage.Name}
+			}).Build(),
+		Recorder:    record.NewFakeRecorder(10),
+		LeadTime:    10 * time.Minute,
+		MinRequests: 100,
+	}
+	reconcile := func() *kuikv1alpha1.Prefetch {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(cachedImage)})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(reconciler.Get(ctx, client.ObjectKeyFromObject(cachedImage), cachedImage)).To(Succeed())
+		return cachedImage.Status.Prefetch
+	}
+
+	status := reconcile()
+	g.Expect(status.History).To(Equal(map[string]int{"Mon 08:00": 1}))
+	g.Expect(status.LastRecordedPods).To(Equal([]string{"a"}))
+
+	// Pods created during the same second as the last recorded one are recorded once
+	g.Expect(reconciler.Create(ctx, newPod("b", createdAt))).To(Succeed())
+	status = reconcile()
+	g.Expect(status.History).To(Equal(map[string]int{"Mon 08:00": 2}))
+	g.Expect(status.LastRecordedPods).To(ConsistOf("a", "b"))
+	status = reconcile()
+	g.Expect(status.History).To(Equal(map[string]int{"Mon 08:00": 2}))
+
+	g.Expect(reconciler.Create(ctx, newPod("c", metav1.NewTime(createdAt.Add(time.Second))))).To(Succeed())
+	status = reconcile()
+	g.Expect(status.History).To(Equal(map[string]int{"Mon 08:00": 3}))
+	g.Expect(status.LastRecordedAt.Time).To(BeTemporally("==", createdAt.Add(time.Second)))
+	g.Expect(status.LastRecordedPods).To(Equal([]string{"c"}))
+}
@@ -0,0 +1,228 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// proxyRolloutRetryDelay is how long the rollout of the proxy waits for a proxy to be ready or idle
+const proxyRolloutRetryDelay = 5 * time.Second
+
+// proxyRolloutStuckRetryDelay is how long a rollout halted by a proxy not ready after the progress deadline waits
+// before checking it again
+const proxyRolloutStuckRetryDelay = time.Minute
+
+// ProxyRolloutReconciler rolls out the proxy DaemonSet, whose update strategy must be OnDelete, one node at a time.
+// The proxy of a node is only replaced once it serves no image pull and once the previously replaced proxy is ready,
+// proxies of nodes being drained are left for when they are back. Proxies of nodes that are not ready don't hold the
+// rollout, and the rollout is halted when a replaced proxy is not ready after the progress deadline. Proxies are
+// replaced by evicting them, which honors PodDisruptionBudgets.
+type ProxyRolloutReconciler struct {
+	client.Client
+	Recorder record.EventRecorder
+	// Namespace and Name are those of the proxy DaemonSet
+	Namespace string
+	Name      string
+	// ProgressDeadline is how long a replaced proxy may take to be ready before the rollout is halted and reported by a
+	// ProxyRolloutStuck event, proxies are waited for indefinitely if 0
+	ProgressDeadline time.Duration
+	// InFlightRequests returns how many image pulls a proxy pod is serving
+	InFlightRequests func(ctx context.Context, pod *corev1.Pod) (int, error)
+	// Evict evicts a proxy pod, using the eviction API if nil
+	Evict func(ctx context.Context, pod *corev1.Pod) error
+}
+
+//+kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps,resources=controllerrevisions,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=pods/eviction,verbs=create
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// Reconcile replaces the next outdated proxy pod, if any
+func (r *ProxyRolloutReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var daemonSet appsv1.DaemonSet
+	if err := r.Get(ctx, req.NamespacedName, &daemonSet); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if daemonSet.Spec.UpdateStrategy.Type != appsv1.OnDeleteDaemonSetStrategyType {
+		log.V(1).Info("proxy DaemonSet is updated by Kubernetes, ignoring", "updateStrategy", daemonSet.Spec.UpdateStrategy.Type)
+		return ctrl.Result{}, nil
+	}
+
+	updateRevision, err := r.updateRevision(ctx, &daemonSet)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(daemonSet.Spec.Selector)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(daemonSet.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	outdatedPods := []corev1.Pod{}
+	for _, pod := range pods.Items {
+		upToDate := pod.Labels[appsv1.DefaultDaemonSetUniqueLabelKey] == updateRevision
+		// Replace a single proxy at a time, the previous one must be ready first
+		if !pod.DeletionTimestamp.IsZero() || (upToDate && !isPodReady(&pod)) {
+			var node corev1.Node
+			if err := r.Get(ctx, types.NamespacedName{Name: pod.Spec.NodeName}, &node); client.IgnoreNotFound(err) != nil {
+				return ctrl.Result{}, err
+			} else if err != nil || !isNodeReady(&node) {
+				log.V(1).Info("node of proxy is not ready, ignoring it", "pod", pod.Name, "node", pod.Spec.NodeName)
+				continue
+			}
+
+			since := pod.CreationTimestamp.Time
+			if !pod.DeletionTimestamp.IsZero() {
+				since = pod.DeletionTimestamp.Time
+			}
+			if r.ProgressDeadline > 0 && time.Since(since) > r.ProgressDeadline {
+				log.Info("proxy is not ready after the progress deadline, halting the rollout", "pod", pod.Name, "node", pod.Spec.NodeName)
+				r.Recorder.Eventf(&daemonSet, "Warning", "ProxyRolloutStuck", "Proxy %s of node %s is not ready after %s, the rollout is halted until it is", pod.Name, pod.Spec.NodeName, r.ProgressDeadline)
+				return ctrl.Result{RequeueAfter: proxyRolloutStuckRetryDelay}, nil
+			}
+
+			log.V(1).Info("waiting for proxy to be ready", "pod", pod.Name)
+			return ctrl.Result{RequeueAfter: proxyRolloutRetryDelay}, nil
+		}
+		if !upToDate {
+			outdatedPods = append(outdatedPods, pod)
+		}
+	}
+	if len(outdatedPods) == 0 {
+		return ctrl.Result{}, nil
+	}
+	sort.Slice(outdatedPods, func(i, j int) bool {
+		return outdatedPods[i].Spec.NodeName < outdatedPods[j].Spec.NodeName
+	})
+
+	for i := range outdatedPods {
+		pod := &outdatedPods[i]
+
+		var node corev1.Node
+		if err := r.Get(ctx, types.NamespacedName{Name: pod.Spec.NodeName}, &node); err != nil && !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		if node.Spec.Unschedulable {
+			log.V(1).Info("node is being drained, postponing the update of its proxy", "node", node.Name)
+			continue
+		}
+
+		// Proxies that are not ready don't serve any image pull
+		if isPodReady(pod) {
+			inFlight, err := r.InFlightRequests(ctx, pod)
+			if err != nil {
+				log.Error(err, "could not count image pulls in flight, postponing the update of the proxy", "pod", pod.Name)
+				continue
+			}
+			if inFlight > 0 {
+				log.V(1).Info("proxy is serving image pulls, postponing its update", "pod", pod.Name, "inFlight", inFlight)
+				continue
+			}
+		}
+
+		log.Info("updating proxy", "pod", pod.Name, "node", pod.Spec.NodeName, "revision", updateRevision)
+		if err := r.evict(ctx, pod); err != nil {
+			if apierrors.IsTooManyRequests(err) {
+				log.Info("proxy eviction is not allowed by a PodDisruptionBudget, retrying", "pod", pod.Name)
+				return ctrl.Result{RequeueAfter: proxyRolloutRetryDelay}, nil
+			}
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+		r.Recorder.Eventf(&daemonSet, "Normal", "ProxyUpdated", "Updating proxy %s of node %s to revision %s", pod.Name, pod.Spec.NodeName, updateRevision)
+		return ctrl.Result{RequeueAfter: proxyRolloutRetryDelay}, nil
+	}
+
+	return ctrl.Result{RequeueAfter: proxyRolloutRetryDelay}, nil
+}
+
+// updateRevision returns the hash of the latest revision of a DaemonSet, which is the one of up-to-date pods
+func (r *ProxyRolloutReconciler) updateRevision(ctx context.Context, daemonSet *appsv1.DaemonSet) (string, error) {
+	selector, err := metav1.LabelSelectorAsSelector(daemonSet.Spec.Selector)
+	if err != nil {
+		return "", err
+	}
+	var revisions appsv1.ControllerRevisionList
+	if err := r.List(ctx, &revisions, client.InNamespace(daemonSet.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return "", err
+	}
+
+	var latest *appsv1.ControllerRevision
+	for i, revision := range revisions.Items {
+		if !metav1.IsControlledBy(&revision, daemonSet) {
+			continue
+		}
+		if latest == nil || revision.Revision > latest.Revision {
+			latest = &revisions.Items[i]
+		}
+	}
+	if latest == nil {
+		return "", fmt.Errorf("DaemonSet %s/%s has no revision", daemonSet.Namespace, daemonSet.Name)
+	}
+
+	return latest.Labels[appsv1.DefaultDaemonSetUniqueLabelKey], nil
+}
+
+func (r *ProxyRolloutReconciler) evict(ctx context.Context, pod *corev1.Pod) error {
+	if r.Evict != nil {
+		return r.Evict(ctx, pod)
+	}
+	return r.SubResource("eviction").Create(ctx, pod, &policyv1.Eviction{})
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func (r *ProxyRolloutReconciler) proxyDaemonSetFromPod(obj client.Object) []ctrl.Request {
+	return []ctrl.Request{{NamespacedName: types.NamespacedName{Namespace: r.Namespace, Name: r.Name}}}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ProxyRolloutReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	isProxy := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetNamespace() == r.Namespace && obj.GetName() == r.Name
+	})
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("proxy-rollout").
+		For(&appsv1.DaemonSet{}, builder.WithPredicates(isProxy)).
+		Watches(
+			&source.Kind{Type: &corev1.Pod{}},
+			handler.EnqueueRequestsFromMapFunc(r.proxyDaemonSetFromPod),
+			builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+				owner := metav1.GetControllerOf(obj)
+				return owner != nil && owner.Kind == "DaemonSet" && owner.Name == r.Name && obj.GetNamespace() == r.Namespace
+			})),
+		).
+		Complete(r)
+}
@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var proxyDaemonSet = &appsv1.DaemonSet{
+	ObjectMeta: metav1.ObjectMeta{Namespace: "kuik-system", Name: "kuik-proxy", UID: "proxy-uid"},
+	Spec: appsv1.DaemonSetSpec{
+		Selector:       &metav1.LabelSelector{MatchLabels: map[string]string{"app": "proxy"}},
+		UpdateStrategy: appsv1.DaemonSetUpdateStrategy{Type: appsv1.OnDeleteDaemonSetStrategyType},
+	},
+}
+
+func proxyRevision(name string, revision int64) *appsv1.ControllerRevision {
+	controller := true
+	return &appsv1.ControllerRevision{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "kuik-system",
+			Name:            "kuik-proxy-" + name,
+			Labels:          map[string]string{"app": "proxy", appsv1.DefaultDaemonSetUniqueLabelKey: name},
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "DaemonSet", Name: "kuik-proxy", UID: "proxy-uid", Controller: &controller}},
+		},
+		Revision: revision,
+	}
+}
+
+func proxyPod(nodeName string, revision string, ready bool) *corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "kuik-system",
+			Name:              "kuik-proxy-" + nodeName,
+			Labels:            map[string]string{"app": "proxy", appsv1.DefaultDaemonSetUniqueLabelKey: revision},
+			CreationTimestamp: metav1.Now(),
+		},
+		Spec:   corev1.PodSpec{NodeName: nodeName},
+		Status: corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}}},
+	}
+}
+
+func proxyNode(name string, ready bool) *corev1.Node {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: status}}},
+	}
+}
+
+func TestProxyRolloutReconciler(t *testing.T) {
+	stuck := proxyPod("node-a", "v2", false)
+	stuck.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Hour))
+	terminating := proxyPod("node-a", "v1", true)
+	terminating.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	terminating.Finalizers = []string{"kuik.enix.io/test"}
+
+	tests := []struct {
+		name     string
+		objects  []client.Object
+		inFlight map[string]int
+		evicted  []string
+		result   ctrl.Result
+	}{
+		{
+			name: "Up to date",
+			objects: []client.Object{
+				proxyPod("node-a", "v2", true),
+				proxyPod("node-b", "v2", true),
+			},
+		},
+		{
+			name: "One node at a time",
+			objects: []client.Object{
+				proxyPod("node-a", "v1", true),
+				proxyPod("node-b", "v1", true),
+			},
+			evicted: []string{"kuik-proxy-node-a"},
+		},
+		{
+			name: "Previous proxy not ready yet",
+			objects: []client.Object{
+				proxyNode("node-a", true),
+				proxyPod("node-a", "v2", false),
+				proxyPod("node-b", "v1", true),
+			},
+			result: ctrl.Result{RequeueAfter: proxyRolloutRetryDelay},
+		},
+		{
+			name: "Previous proxy not ready after the progress deadline",
+			objects: []client.Object{
+				proxyNode("node-a", true),
+				stuck,
+				proxyPod("node-b", "v1", true),
+			},
+			result: ctrl.Result{RequeueAfter: proxyRolloutStuckRetryDelay},
+		},
+		{
+			name: "Previous proxy on a node not ready",
+			objects: []client.Object{
+				proxyNode("node-a", false),
+				proxyPod("node-a", "v2", false),
+				proxyPod("node-b", "v1", true),
+			},
+			evicted: []string{"kuik-proxy-node-b"},
+			result:  ctrl.Result{RequeueAfter: proxyRolloutRetryDelay},
+		},
+		{
+			name: "Proxy terminating on a node not ready",
+			objects: []client.Object{
+				proxyNode("node-a", false),
+				terminating,
+				proxyPod("node-b", "v1", true),
+			},
+			evicted: []string{"kuik-proxy-node-b"},
+			result:  ctrl.Result{RequeueAfter: proxyRolloutRetryDelay},
+		},
+		{
+			name: "Pulls in flight",
+			objects: []client.Object{
+				proxyPod("node-a", "v1", true),
+				proxyPod("node-b", "v1", true),
+			},
+			inFlight: map[string]int{"kuik-proxy-node-a": 2},
+			evicted:  []string{"kuik-proxy-node-b"},
+		},
+		{
+			name: "Node being drained",
+			objects: []client.Object{
+				&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}, Spec: corev1.NodeSpec{Unschedulable: true}},
+				proxyPod("node-a", "v1", true),
+				proxyPod("node-b", "v1", true),
+			},
+			evicted: []string{"kuik-proxy-node-b"},
+		},
+		{
+			name: "Outdated proxy not ready",
+			objects: []client.Object{
+				proxyPod("node-a", "v1", false),
+			},
+			inFlight: map[string]int{"kuik-proxy-node-a": 2},
+			evicted:  []string{"kuik-proxy-node-a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			objects := append([]client.Object{proxyDaemonSet.DeepCopy(), proxyRevision("v1", 1), proxyRevision("v2", 2)}, tt.objects...)
+			evicted := []string{}
+			reconciler := &ProxyRolloutReconciler{
+				Client:           fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(objects...).Build(),
+				Recorder:         record.NewFakeRecorder(10),
+				Namespace:        "kuik-system",
+				Name:             "kuik-proxy",
+				ProgressDeadline: 10 * time.Minute,
+				InFlightRequests: func(ctx context.Context, pod *corev1.Pod) (int, error) {
+					return tt.inFlight[pod.Name], nil
+				},
+				Evict: func(ctx context.Context, pod *corev1.Pod) error {
+					evicted = append(evicted, pod.Name)
+					return nil
+				},
+			}
+
+			result, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "kuik-system", Name: "kuik-proxy"}})
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(evicted).To(Equal(append([]string{}, tt.evicted...)))
+			if tt.result != (ctrl.Result{}) {
+				g.Expect(result).To(Equal(tt.result))
+			}
+		})
+	}
+}
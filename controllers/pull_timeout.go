@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"context"
+	"math"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// minPullThroughput is the throughput, in bytes per second, images with an expected size are assumed to be pulled at
+// from upstream at least, their pull timeout being extended accordingly
+const minPullThroughput = 1 << 20
+
+// pullHintAnnotations are the annotations of pods hinting how long pulling their images may take, copied to the
+// CachedImages of their images
+var pullHintAnnotations = []string{kuikv1alpha1.PullTimeoutAnnotationName, kuikv1alpha1.ExpectedSizeAnnotationName}
+
+// hasPullHints tells whether a pod has one of pullHintAnnotations
+func hasPullHints(pod *corev1.Pod) bool {
+	for _, annotation := range pullHintAnnotations {
+		if _, ok := pod.Annotations[annotation]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// mergePullHints returns the longest pull timeout and the largest expected size set by the annotations of pods, by
+// annotation name. A timeout of 0 removes the limit, it is the longest one. Invalid values are ignored.
+func mergePullHints(pods []corev1.Pod) map[string]string {
+	hints := map[string]string{}
+	var longest time.Duration
+	var largest resource.Quantity
+
+	for _, pod := range pods {
+		if value, ok := pod.Annotations[kuikv1alpha1.PullTimeoutAnnotationName]; ok {
+			timeout, err := time.ParseDuration(value)
+			if timeout == 0 {
+				timeout = math.MaxInt64
+			}
+			if _, set := hints[kuikv1alpha1.PullTimeoutAnnotationName]; err == nil && timeout > 0 && (!set || timeout > longest) {
+				hints[kuikv1alpha1.PullTimeoutAnnotationName] = value
+				longest = timeout
+			}
+		}
+		if value, ok := pod.Annotations[kuikv1alpha1.ExpectedSizeAnnotationName]; ok {
+			size, err := resource.ParseQuantity(value)
+			if _, set := hints[kuikv1alpha1.ExpectedSizeAnnotationName]; err == nil && size.Sign() >= 0 && (!set || size.Cmp(largest) > 0) {
+				hints[kuikv1alpha1.ExpectedSizeAnnotationName] = value
+				largest = size
+			}
+		}
+	}
+
+	return hints
+}
+
+// pullTimeout returns how long pulling the image of a CachedImage from upstream may take, 0 if it is not limited: the
+// duration of its pull-timeout annotation, or PullTimeout extended to leave enough time to pull its expected size.
+// Invalid annotations are ignored.
+func (r *CachedImageReconciler) pullTimeout(ctx context.Context, cachedImage *kuikv1alpha1.CachedImage) time.Duration {
+	log := log.FromContext(ctx)
+
+	if timeout, ok, err := cachedImage.PullTimeout(); err != nil {
+		log.Error(err, "ignoring pull timeout")
+	} else if ok {
+		return timeout
+	}
+
+	timeout := r.PullTimeout
+	if timeout <= 0 {
+		return 0
+	}
+
+	expectedSize, err := cachedImage.ExpectedSize()
+	if err != nil {
+		log.Error(err, "ignoring expected size")
+	} else if expectedTimeout := time.Duration(expectedSize/minPullThroughput) * time.Second; expectedTimeout > timeout {
+		return expectedTimeout
+	}
+
+	return timeout
+}
@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPullTimeout(t *testing.T) {
+	tests := []struct {
+		name        string
+		pullTimeout time.Duration
+		annotations map[string]string
+		want        time.Duration
+	}{
+		{name: "default", pullTimeout: time.Hour, want: time.Hour},
+		{name: "disabled", want: 0},
+		{name: "annotation", pullTimeout: time.Hour, annotations: map[string]string{kuikv1alpha1.PullTimeoutAnnotationName: "6h"}, want: 6 * time.Hour},
+		{name: "annotation removing the limit", pullTimeout: time.Hour, annotations: map[string]string{kuikv1alpha1.PullTimeoutAnnotationName: "0"}, want: 0},
+		{name: "invalid annotation", pullTimeout: time.Hour, annotations: map[string]string{kuikv1alpha1.PullTimeoutAnnotationName: "6 hours"}, want: time.Hour},
+		{name: "small expected size", pullTimeout: time.Hour, annotations: map[string]string{kuikv1alpha1.ExpectedSizeAnnotationName: "2Gi"}, want: time.Hour},
+		{name: "large expected size", pullTimeout: time.Hour, annotations: map[string]string{kuikv1alpha1.ExpectedSizeAnnotationName: "40Gi"}, want: 40 * 1024 * time.Second},
+		{name: "expected size without limit", annotations: map[string]string{kuikv1alpha1.ExpectedSizeAnnotationName: "40Gi"}, want: 0},
+		{name: "invalid expected size", pullTimeout: time.Hour, annotations: map[string]string{kuikv1alpha1.ExpectedSizeAnnotationName: "large"}, want: time.Hour},
+		{
+			name:        "annotation overriding expected size",
+			pullTimeout: time.Hour,
+			annotations: map[string]string{kuikv1alpha1.PullTimeoutAnnotationName: "2h", kuikv1alpha1.ExpectedSizeAnnotationName: "40Gi"},
+			want:        2 * time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			r := &CachedImageReconciler{PullTimeout: tt.pullTimeout}
+			cachedImage := &kuikv1alpha1.CachedImage{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}}
+			g.Expect(r.pullTimeout(context.Background(), cachedImage)).To(Equal(tt.want))
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	"github.com/enix/kube-image-keeper/pkg/registrytest"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRecache(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	cache := registrytest.New(t)
+	registry.Endpoint = cache.Addr()
+	image := registrytest.RandomImage(t, 1)
+	cache.PushImage(t, "docker.io/library/nginx:latest", image)
+	digest, err := image.Digest()
+	g.Expect(err).To(BeNil())
+
+	cachedImage := &kuikv1alpha1.CachedImage{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "docker.io-library-nginx-latest",
+			Annotations: map[string]string{kuikv1alpha1.RecacheAnnotationName: "true"},
+		},
+		Spec: kuikv1alpha1.CachedImageSpec{SourceImage: "nginx:latest"},
+		Status: kuikv1alpha1.CachedImageStatus{
+			IsCached: true,
+			Progress: &kuikv1alpha1.CachingProgress{CompletedLayers: []string{"sha256:abc"}},
+		},
+	}
+	g.Expect(cachedImage.IsRecacheRequested()).To(BeTrue())
+
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(cachedImage).Build()
+	recorder := record.NewFakeRecorder(10)
+	reconciler := &CachedImageReconciler{Client: k8sClient, Recorder: recorder}
+
+	// The image is deleted from the cache and the request is removed
+	g.Expect(reconciler.recache(ctx, cachedImage)).To(Succeed())
+	g.Expect(cache.Requests()).To(ContainElement("DELETE /v2/docker.io/library/nginx/manifests/" + digest.String()))
+	g.Expect(cachedImage.Status.Progress).To(BeNil())
+	g.Expect(<-recorder.Events).To(Equal("Normal Recaching Removing image nginx:latest from cache to pull it again from upstream"))
+
+	var updated kuikv1alpha1.CachedImage
+	g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: cachedImage.Name}, &updated)).To(Succeed())
+	g.Expect(updated.IsRecacheRequested()).To(BeFalse())
+}
@@ -0,0 +1,386 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/strings/slices"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/enix/kube-image-keeper/internal/registry"
+)
+
+const (
+	// AnnotationCertificateRenewedAtName is set on the pod template of the registry to restart it once its certificate
+	// has been renewed
+	AnnotationCertificateRenewedAtName = "kuik.enix.io/certificate-renewed-at"
+
+	// Keys of the registry TLS Secret besides those read by clients of the registry
+	registryTLSCAKeyKey      = "ca.key"
+	registryTLSNextCACertKey = "next-ca.crt"
+	registryTLSNextCAKeyKey  = "next-ca.key"
+	registryTLSCertKey       = "tls.crt"
+	registryTLSKeyKey        = "tls.key"
+
+	// certificateAuthorityValidityFactor is how many times longer than the certificates it signs a certificate
+	// authority is valid
+	certificateAuthorityValidityFactor = 4
+)
+
+// keyPair is a certificate and its private key
+type keyPair struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+}
+
+// RegistryCertificates issues and rotates the certificates securing the connections between the proxies, the
+// controllers and the cache registry with mutual TLS. A self-signed certificate authority signs a server certificate
+// for the registry and a client certificate shared by the proxies and the controllers, stored in a Secret along with the
+// certificate authorities to trust.
+//
+// Certificates are renewed once two thirds of their validity have elapsed. The next certificate authority is trusted
+// for a whole certificate validity before it signs certificates, so that every component trusts it by the time it is
+// used. The registry only reads its certificate on startup, it is restarted once its certificate has been renewed.
+type RegistryCertificates struct {
+	client.Client
+	Recorder record.EventRecorder
+	// Namespace and SecretName are those of the Secret holding the certificates
+	Namespace  string
+	SecretName string
+	// Registry is the name of the Deployment or StatefulSet of the registry, in Namespace
+	Registry string
+	// DNSNames are the names the registry is reached with
+	DNSNames []string
+	// Validity is how long the certificates of the registry and of its clients are valid
+	Validity time.Duration
+	// Interval is how often certificates are checked
+	Interval time.Duration
+
+	now func() time.Time
+}
+
+// NewRegistryCertificates returns a RegistryCertificates, or nil if secretName is empty
+func NewRegistryCertificates(k8sClient client.Client, recorder record.EventRecorder, namespace string, secretName string, registry string, dnsNames []string, validity time.Duration) *RegistryCertificates {
+	if secretName == "" {
+		return nil
+	}
+
+	return &RegistryCertificates{
+		Client:     k8sClient,
+		Recorder:   recorder,
+		Namespace:  namespace,
+		SecretName: secretName,
+		Registry:   registry,
+		DNSNames:   dnsNames,
+		Validity:   validity,
+		Interval:   time.Hour,
+		now:        time.Now,
+	}
+}
+
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update
+//+kubebuilder:rbac:groups=apps,resources=deployments;statefulsets,verbs=patch
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// Start implements manager.Runnable
+func (r *RegistryCertificates) Start(ctx context.Context) error {
+	log := log.FromContext(ctx).WithName("registry-certificates")
+
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.reconcile(ctx); err != nil {
+			log.Error(err, "could not issue registry certificates")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable
+func (r *RegistryCertificates) NeedLeaderElection() bool {
+	return true
+}
+
+// reconcile issues the missing certificates and renews the expiring ones
+func (r *RegistryCertificates) reconcile(ctx context.Context) error {
+	log := log.FromContext(ctx).WithName("registry-certificates")
+	now := r.now()
+
+	secret := corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: r.Namespace, Name: r.SecretName}}
+	exists := true
+	if err := r.Get(ctx, client.ObjectKeyFromObject(&secret), &secret); apierrors.IsNotFound(err) {
+		exists = false
+	} else if err != nil {
+		return err
+	}
+	data := map[string][]byte{}
+	for key, value := range secret.Data {
+		data[key] = value
+	}
+
+	ca, _ := parseKeyPair(data[registry.TLSCACertFile], data[registryTLSCAKeyKey])
+	nextCA, _ := parseKeyPair(data[registryTLSNextCACertKey], data[registryTLSNextCAKeyKey])
+	renewCertificates := false
+
+	// The next certificate authority replaces the current one once it expires within a certificate validity, it is
+	// generated a certificate validity before that
+	if ca == nil || ca.certificate.NotAfter.Sub(now) < r.Validity {
+		if nextCA == nil {
+			var err error
+			if nextCA, err = r.newCertificateAuthority(now); err != nil {
+				return err
+			}
+		}
+		if ca != nil {
+			log.Info("rotating registry certificate authority")
+		}
+		ca, nextCA = nextCA, nil
+		renewCertificates = true
+	} else if nextCA == nil && ca.certificate.NotAfter.Sub(now) < 2*r.Validity {
+		var err error
+		if nextCA, err = r.newCertificateAuthority(now); err != nil {
+			return err
+		}
+		log.Info("generated next registry certificate authority")
+	}
+
+	data[registry.TLSCACertFile] = r.trustBundle(ca, nextCA, data[registry.TLSCACertFile], now)
+	data[registryTLSCAKeyKey] = encodeKey(ca.key)
+	if nextCA != nil {
+		data[registryTLSNextCACertKey] = encodeCertificate(nextCA.certificate)
+		data[registryTLSNextCAKeyKey] = encodeKey(nextCA.key)
+	} else {
+		delete(data, registryTLSNextCACertKey)
+		delete(data, registryTLSNextCAKeyKey)
+	}
+
+	serverRenewed := false
+	for _, certificate := range []struct {
+		certKey, keyKey string
+		server          bool
+	}{
+		{registryTLSCertKey, registryTLSKeyKey, true},
+		{registry.TLSClientCertFile, registry.TLSClientKeyFile, false},
+	} {
+		current, _ := parseKeyPair(data[certificate.certKey], data[certificate.keyKey])
+		if !renewCertificates && current != nil && current.certificate.CheckSignatureFrom(ca.certificate) == nil && current.certificate.NotAfter.Sub(now) > r.Validity/3 {
+			continue
+		}
+
+		issued, err := r.issueCertificate(ca, certificate.server, now)
+		if err != nil {
+			return err
+		}
+		data[certificate.certKey] = encodeCertificate(issued.certificate)
+		data[certificate.keyKey] = encodeKey(issued.key)
+		serverRenewed = serverRenewed || (certificate.server && current != nil)
+	}
+
+	if exists && secretDataEqual(secret.Data, data) {
+		return nil
+	}
+
+	secret.Type = corev1.SecretTypeOpaque
+	secret.Data = data
+	if exists {
+		if err := r.Update(ctx, &secret); err != nil {
+			return err
+		}
+	} else if err := r.Create(ctx, &secret); err != nil {
+		return err
+	}
+	log.Info("updated registry certificates", "secret", r.SecretName)
+
+	if serverRenewed {
+		return r.restartRegistry(ctx, now)
+	}
+	return nil
+}
+
+// trustBundle returns the certificate authorities to trust: the current one, the next one and the previous ones that
+// have not expired yet, since certificates they signed may still be in use
+func (r *RegistryCertificates) trustBundle(ca *keyPair, nextCA *keyPair, previousBundle []byte, now time.Time) []byte {
+	bundle := encodeCertificate(ca.certificate)
+	if nextCA != nil {
+		bundle = append(bundle, encodeCertificate(nextCA.certificate)...)
+	}
+
+	for block, rest := pem.Decode(previousBundle); block != nil; block, rest = pem.Decode(rest) {
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil || now.After(certificate.NotAfter) || certificate.Equal(ca.certificate) || (nextCA != nil && certificate.Equal(nextCA.certificate)) {
+			continue
+		}
+		bundle = append(bundle, encodeCertificate(certificate)...)
+	}
+
+	return bundle
+}
+
+// restartRegistry restarts the registry by updating the pod template of its Deployment or StatefulSet
+func (r *RegistryCertificates) restartRegistry(ctx context.Context, now time.Time) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{AnnotationCertificateRenewedAtName: now.UTC().Format(time.RFC3339)},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, workload := range []client.Object{&appsv1.Deployment{}, &appsv1.StatefulSet{}} {
+		workload.SetNamespace(r.Namespace)
+		workload.SetName(r.Registry)
+		err := r.Patch(ctx, workload, client.RawPatch(types.StrategicMergePatchType, patch))
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		log.FromContext(ctx).WithName("registry-certificates").Info("restarting registry to load its renewed certificate", "registry", r.Registry)
+		r.Recorder.Eventf(workload, "Normal", "CertificateRenewed", "Registry certificate has been renewed, restarting the registry")
+		return nil
+	}
+
+	return errors.New("registry " + r.Registry + " not found, it must be restarted to load its renewed certificate")
+}
+
+// RegistryDNSNames returns the names of the registry reached at host, the name of its Service in namespace possibly
+// qualified with its namespace
+func RegistryDNSNames(host string, namespace string) []string {
+	service := strings.Split(host, ".")[0]
+	names := []string{host}
+	for _, name := range []string{service, service + "." + namespace, service + "." + namespace + ".svc", service + "." + namespace + ".svc.cluster.local"} {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (r *RegistryCertificates) newCertificateAuthority(now time.Time) (*keyPair, error) {
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "kube-image-keeper registry CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(certificateAuthorityValidityFactor * r.Validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	return newKeyPair(template, nil)
+}
+
+func (r *RegistryCertificates) issueCertificate(ca *keyPair, server bool, now time.Time) (*keyPair, error) {
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "kube-image-keeper registry client"},
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(r.Validity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		template.Subject.CommonName = r.DNSNames[0]
+		template.DNSNames = r.DNSNames
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	return newKeyPair(template, ca)
+}
+
+// newKeyPair generates a key and its certificate from template, signed by parent or self-signed if parent is nil
+func newKeyPair(template *x509.Certificate, parent *keyPair) (*keyPair, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template.SerialNumber = serialNumber
+
+	signer := &keyPair{certificate: template, key: key}
+	if parent != nil {
+		signer = parent
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer.certificate, &key.PublicKey, signer.key)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &keyPair{certificate: certificate, key: key}, nil
+}
+
+// parseKeyPair parses a PEM encoded certificate and key, the first certificate is used if there are several of them
+func parseKeyPair(certificatePEM []byte, keyPEM []byte) (*keyPair, error) {
+	certificateBlock, _ := pem.Decode(certificatePEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certificateBlock == nil || keyBlock == nil {
+		return nil, errors.New("missing certificate or key")
+	}
+
+	certificate, err := x509.ParseCertificate(certificateBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if !key.PublicKey.Equal(certificate.PublicKey) {
+		return nil, errors.New("key does not match certificate")
+	}
+
+	return &keyPair{certificate: certificate, key: key}, nil
+}
+
+func encodeCertificate(certificate *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})
+}
+
+func encodeKey(key *ecdsa.PrivateKey) []byte {
+	der, _ := x509.MarshalECPrivateKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func secretDataEqual(a map[string][]byte, b map[string][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if !bytes.Equal(value, b[key]) {
+			return false
+		}
+	}
+	return true
+}
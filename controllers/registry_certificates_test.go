@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRegistryDNSNames(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(RegistryDNSNames("kuik-registry", "kuik-system")).To(Equal([]string{
+		"kuik-registry",
+		"kuik-registry.kuik-system",
+		"kuik-registry.kuik-system.svc",
+		"kuik-registry.kuik-system.svc.cluster.local",
+	}))
+	g.Expect(RegistryDNSNames("kuik-registry.kuik-system.svc", "kuik-system")).To(Equal([]string{
+		"kuik-registry.kuik-system.svc",
+		"kuik-registry",
+		"kuik-registry.kuik-system",
+		"kuik-registry.kuik-system.svc.cluster.local",
+	}))
+}
+
+func TestRegistryCertificates(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "kuik-system", Name: "kuik-registry"}},
+	).Build()
+	validity := 30 * 24 * time.Hour
+	certificates := NewRegistryCertificates(k8sClient, record.NewFakeRecorder(10), "kuik-system", "kuik-registry-tls", "kuik-registry", RegistryDNSNames("kuik-registry", "kuik-system"), validity)
+	certificates.now = func() time.Time { return now }
+
+	getSecret := func() *corev1.Secret {
+		var secret corev1.Secret
+		g.Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: "kuik-system", Name: "kuik-registry-tls"}, &secret)).To(Succeed())
+		return &secret
+	}
+	restartedAt := func() string {
+		var deployment appsv1.Deployment
+		g.Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: "kuik-system", Name: "kuik-registry"}, &deployment)).To(Succeed())
+		return deployment.Spec.Template.Annotations[AnnotationCertificateRenewedAtName]
+	}
+	verify := func(secret *corev1.Secret, certKey string, usage x509.ExtKeyUsage) {
+		roots := x509.NewCertPool()
+		g.Expect(roots.AppendCertsFromPEM(secret.Data[registry.TLSCACertFile])).To(BeTrue())
+		block, _ := pem.Decode(secret.Data[certKey])
+		g.Expect(block).ToNot(BeNil())
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = certificate.Verify(x509.VerifyOptions{Roots: roots, CurrentTime: certificates.now(), KeyUsages: []x509.ExtKeyUsage{usage}})
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+
+	// Certificates are issued without restarting the registry
+	g.Expect(certificates.reconcile(ctx)).To(Succeed())
+	secret := getSecret()
+	verify(secret, registryTLSCertKey, x509.ExtKeyUsageServerAuth)
+	verify(secret, registry.TLSClientCertFile, x509.ExtKeyUsageClientAuth)
+	g.Expect(secret.Data).ToNot(HaveKey(registryTLSNextCACertKey))
+	g.Expect(restartedAt()).To(BeEmpty())
+
+	// Nothing changes while certificates are valid
+	certificates.now = func() time.Time { return now.Add(validity / 2) }
+	g.Expect(certificates.reconcile(ctx)).To(Succeed())
+	g.Expect(getSecret().Data).To(Equal(secret.Data))
+
+	// Certificates are renewed once two thirds of their validity have elapsed, restarting the registry
+	certificates.now = func() time.Time { return now.Add(validity * 3 / 4) }
+	g.Expect(certificates.reconcile(ctx)).To(Succeed())
+	renewed := getSecret()
+	g.Expect(renewed.Data[registryTLSCertKey]).ToNot(Equal(secret.Data[registryTLSCertKey]))
+	g.Expect(renewed.Data[registry.TLSCACertFile]).To(Equal(secret.Data[registry.TLSCACertFile]))
+	verify(renewed, registryTLSCertKey, x509.ExtKeyUsageServerAuth)
+	g.Expect(restartedAt()).ToNot(BeEmpty())
+
+	// The next certificate authority is trusted before it signs certificates
+	certificates.now = func() time.Time { return now.Add(certificateAuthorityValidityFactor*validity - validity*3/2) }
+	g.Expect(certificates.reconcile(ctx)).To(Succeed())
+	secret = getSecret()
+	g.Expect(secret.Data).To(HaveKey(registryTLSNextCACertKey))
+	g.Expect(string(secret.Data[registry.TLSCACertFile])).To(ContainSubstring(string(secret.Data[registryTLSNextCACertKey])))
+
+	// The next certificate authority replaces the current one, which is still trusted until it expires
+	certificates.now = func() time.Time { return now.Add(certificateAuthorityValidityFactor*validity - validity/2) }
+	g.Expect(certificates.reconcile(ctx)).To(Succeed())
+	rotated := getSecret()
+	g.Expect(rotated.Data).ToNot(HaveKey(registryTLSNextCACertKey))
+	g.Expect(rotated.Data[registryTLSCAKeyKey]).To(Equal(secret.Data[registryTLSNextCAKeyKey]))
+	g.Expect(string(rotated.Data[registry.TLSCACertFile])).To(HavePrefix(string(secret.Data[registryTLSNextCACertKey])))
+	g.Expect(rotated.Data[registry.TLSCACertFile]).To(HaveLen(len(secret.Data[registry.TLSCACertFile])))
+	verify(rotated, registryTLSCertKey, x509.ExtKeyUsageServerAuth)
+	verify(rotated, registry.TLSClientCertFile, x509.ExtKeyUsageClientAuth)
+}
@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/enix/kube-image-keeper/internal/registry"
+)
+
+// RegistryHealthChecker periodically checks that the cache registry answers, which it doesn't while the health check
+// of its storage driver fails, e.g. when the bucket of an object storage backend is unreachable
+type RegistryHealthChecker struct {
+	Interval time.Duration
+	Backend  registry.StorageBackend
+	// check is registry.CheckHealth, replaced in tests
+	check func() error
+}
+
+// NewRegistryHealthChecker returns a RegistryHealthChecker, or nil if interval is not positive
+func NewRegistryHealthChecker(interval time.Duration, backend registry.StorageBackend) *RegistryHealthChecker {
+	if interval <= 0 {
+		return nil
+	}
+
+	return &RegistryHealthChecker{
+		Interval: interval,
+		Backend:  backend,
+		check:    registry.CheckHealth,
+	}
+}
+
+// Start implements manager.Runnable
+func (c *RegistryHealthChecker) Start(ctx context.Context) error {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	healthy := true
+	for {
+		healthy = c.checkOnce(ctx, healthy)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkOnce checks the registry and reports it in the registryHealthy metric, logging changes from the previous state
+func (c *RegistryHealthChecker) checkOnce(ctx context.Context, wasHealthy bool) bool {
+	log := log.FromContext(ctx).WithName("registry-health")
+
+	err := c.check()
+	if err != nil {
+		registryHealthy.WithLabelValues(string(c.Backend)).Set(0)
+		if wasHealthy {
+			log.Error(err, "cache registry is unhealthy, images are served from their upstream registries", "storage", c.Backend)
+		}
+		return false
+	}
+
+	registryHealthy.WithLabelValues(string(c.Backend)).Set(1)
+	if !wasHealthy {
+		log.Info("cache registry is healthy again", "storage", c.Backend)
+	}
+	return true
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, every replica reports the health of the registry
+func (c *RegistryHealthChecker) NeedLeaderElection() bool {
+	return false
+}
@@ -0,0 +1,30 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/enix/kube-image-keeper/internal/registry"
+	. "github.com/onsi/gomega"
+)
+
+func TestRegistryHealthChecker(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(NewRegistryHealthChecker(0, registry.StorageGCS)).To(BeNil())
+
+	checker := NewRegistryHealthChecker(time.Minute, registry.StorageGCS)
+	var checkErr error
+	checker.check = func() error { return checkErr }
+
+	g.Expect(checker.checkOnce(context.Background(), true)).To(BeTrue())
+
+	checkErr = errors.New("registry answered with status 503 Service Unavailable")
+	g.Expect(checker.checkOnce(context.Background(), true)).To(BeFalse())
+	g.Expect(checker.checkOnce(context.Background(), false)).To(BeFalse())
+
+	checkErr = nil
+	g.Expect(checker.checkOnce(context.Background(), false)).To(BeTrue())
+}
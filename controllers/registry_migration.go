@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/registry"
+)
+
+// registryMigrationReportedImages bounds the number of images missing from the cache registry listed in the report of
+// the migration, which is stored in a ConfigMap
+const registryMigrationReportedImages = 100
+
+// RegistryMigration migrates cached images from the previous cache registry to the current one, reports the images
+// not yet present in the current registry in a ConfigMap, and completes the migration once there are none left: images
+// are no longer read from nor written to the previous registry, by the controllers as well as by the proxies watching
+// the ConfigMap.
+type RegistryMigration struct {
+	client.Client
+	Recorder record.EventRecorder
+	// Namespace and ConfigMapName locate the report of the migration
+	Namespace     string
+	ConfigMapName string
+	// Interval is how often cached images missing from the current registry are migrated
+	Interval time.Duration
+
+	now func() time.Time
+}
+
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;create;update
+//+kubebuilder:rbac:groups=kuik.enix.io,resources=cachedimages,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// NewRegistryMigration returns a RegistryMigration, or nil if no previous registry is configured
+func NewRegistryMigration(k8sClient client.Client, recorder record.EventRecorder, namespace string, configMapName string, interval time.Duration) *RegistryMigration {
+	if registry.PreviousEndpoint == "" || configMapName == "" || interval <= 0 {
+		return nil
+	}
+
+	return &RegistryMigration{
+		Client:        k8sClient,
+		Recorder:      recorder,
+		Namespace:     namespace,
+		ConfigMapName: configMapName,
+		Interval:      interval,
+		now:           time.Now,
+	}
+}
+
+// Start implements manager.Runnable, it returns once the migration is completed
+func (m *RegistryMigration) Start(ctx context.Context) error {
+	log := log.FromContext(ctx).WithName("registry-migration")
+
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+
+	for registry.MigrationInProgress() {
+		if err := m.migrate(ctx); err != nil {
+			log.Error(err, "could not migrate cached images from the previous registry")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable
+func (m *RegistryMigration) NeedLeaderElection() bool {
+	return true
+}
+
+// migrate copies the cached images missing from the current registry from the previous one, then reports the images
+// still missing and completes the migration if there are none
+func (m *RegistryMigration) migrate(ctx context.Context) error {
+	log := log.FromContext(ctx).WithName("registry-migration")
+
+	// The completion of the migration survives restarts of the controllers
+	configMap := corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: m.Namespace, Name: m.ConfigMapName}}
+	exists := true
+	if err := m.Get(ctx, client.ObjectKeyFromObject(&configMap), &configMap); apierrors.IsNotFound(err) {
+		exists = false
+	} else if err != nil {
+		return err
+	}
+	if registry.MigrationReportCompleted(&configMap) {
+		log.Info("registry migration has been completed, ignoring the previous registry", "previousEndpoint", registry.PreviousEndpoint)
+		registry.CompleteMigration()
+		return nil
+	}
+
+	var cachedImages kuikv1alpha1.CachedImageList
+	if err := m.List(ctx, &cachedImages); err != nil {
+		return err
+	}
+
+	total := 0
+	missing := []string{}
+	for _, cachedImage := range cachedImages.Items {
+		if !cachedImage.Status.IsCached || !cachedImage.DeletionTimestamp.IsZero() {
+			continue
+		}
+		total++
+
+		sourceImage := cachedImage.Spec.SourceImage
+		isCached, err := registry.ImageIsCached(sourceImage)
+		if err != nil {
+			return err
+		}
+		if isCached {
+			continue
+		}
+
+		// Images missing from both registries are put in cache from upstream by the CachedImage controller
+		if migrated, err := registry.MigrateImage(sourceImage); err != nil {
+			log.Error(err, "could not migrate image from the previous registry", "sourceImage", sourceImage)
+			missing = append(missing, sourceImage)
+		} else if !migrated {
+			missing = append(missing, sourceImage)
+		} else {
+			log.Info("image migrated from the previous registry", "sourceImage", sourceImage)
+		}
+	}
+
+	registryMigrationMissingImages.Set(float64(len(missing)))
+
+	phase := registry.MigrationInProgressPhase
+	if len(missing) == 0 {
+		phase = registry.MigrationCompletedPhase
+	}
+	reported := missing
+	if len(reported) > registryMigrationReportedImages {
+		reported = reported[:registryMigrationReportedImages]
+	}
+	configMap.Data = map[string]string{
+		registry.MigrationPhaseKey:            phase,
+		registry.MigrationPreviousEndpointKey: registry.PreviousEndpoint,
+		registry.MigrationEndpointKey:         registry.Endpoint,
+		"cachedImages":                        strconv.Itoa(total),
+		"missingImages":                       strconv.Itoa(len(missing)),
+		"missing":                             strings.Join(reported, "\n"),
+		"updatedAt":                           m.now().UTC().Format(time.RFC3339),
+	}
+
+	if exists {
+		if err := m.Update(ctx, &configMap); err != nil {
+			return err
+		}
+	} else if err := m.Create(ctx, &configMap); err != nil {
+		return err
+	}
+
+	if phase == registry.MigrationCompletedPhase {
+		log.Info("registry migration completed, every cached image is in the current registry", "previousEndpoint", registry.PreviousEndpoint, "cachedImages", total)
+		m.Recorder.Eventf(&configMap, "Normal", "MigrationCompleted", "Every cached image (%d) has been migrated from %s", total, registry.PreviousEndpoint)
+		registry.CompleteMigration()
+	} else {
+		log.Info("registry migration in progress", "cachedImages", total, "missingImages", len(missing))
+	}
+
+	return nil
+}
+
+// copyToPreviousRegistry writes an image put in cache to the previous registry while the migration is in progress, so
+// that going back to it is still possible. A failure doesn't affect the current registry so it is only logged.
+func copyToPreviousRegistry(ctx context.Context, sourceImage string) {
+	if err := registry.CopyToPreviousRegistry(sourceImage); err != nil {
+		log.FromContext(ctx).Error(err, "could not copy image to the previous registry", "sourceImage", sourceImage)
+	}
+}
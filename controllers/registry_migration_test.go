@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	"github.com/enix/kube-image-keeper/pkg/registrytest"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRegistryMigration(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	previous := registrytest.New(t)
+	cache := registrytest.New(t)
+	registry.Endpoint, registry.PreviousEndpoint = cache.Addr(), previous.Addr()
+	t.Cleanup(func() { registry.PreviousEndpoint = "" })
+
+	previous.PushImage(t, "docker.io/library/alpine:3.19", registrytest.RandomImage(t, 1))
+	cache.PushImage(t, "docker.io/library/nginx:1.25", registrytest.RandomImage(t, 1))
+
+	cachedImage := func(name string, sourceImage string, isCached bool) *kuikv1alpha1.CachedImage {
+		return &kuikv1alpha1.CachedImage{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: sourceImage},
+			Status:     kuikv1alpha1.CachedImageStatus{IsCached: isCached},
+		}
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(
+		cachedImage("docker.io-library-alpine-3.19", "alpine:3.19", true),
+		cachedImage("docker.io-library-nginx-1.25", "nginx:1.25", true),
+		cachedImage("docker.io-library-redis-7", "redis:7", true),
+		cachedImage("docker.io-library-busybox-1.36", "busybox:1.36", false),
+		// Left by a previous migration, which doesn't complete this one
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kuik-system", Name: "kuik-registry-migration"},
+			Data: map[string]string{
+				registry.MigrationPhaseKey:            registry.MigrationCompletedPhase,
+				registry.MigrationPreviousEndpointKey: "kube-image-keeper-registry-old:5000",
+				registry.MigrationEndpointKey:         previous.Addr(),
+			},
+		},
+	).Build()
+	migration := NewRegistryMigration(k8sClient, record.NewFakeRecorder(10), "kuik-system", "kuik-registry-migration", time.Minute)
+	g.Expect(migration).ToNot(BeNil())
+
+	report := func() map[string]string {
+		var configMap corev1.ConfigMap
+		g.Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: "kuik-system", Name: "kuik-registry-migration"}, &configMap)).To(Succeed())
+		return configMap.Data
+	}
+
+	// Images of the previous registry are copied, images missing from both registries are reported
+	g.Expect(migration.migrate(ctx)).To(Succeed())
+	g.Expect(registry.ImageIsCached("alpine:3.19")).To(BeTrue())
+	g.Expect(report()).To(HaveKeyWithValue(registry.MigrationPhaseKey, registry.MigrationInProgressPhase))
+	g.Expect(report()).To(HaveKeyWithValue("cachedImages", "3"))
+	g.Expect(report()).To(HaveKeyWithValue("missingImages", "1"))
+	g.Expect(report()).To(HaveKeyWithValue("missing", "redis:7"))
+	g.Expect(report()).To(HaveKeyWithValue(registry.MigrationPreviousEndpointKey, previous.Addr()))
+	g.Expect(registry.MigrationInProgress()).To(BeTrue())
+
+	// The migration is completed once every cached image is in the registry
+	cache.PushImage(t, "docker.io/library/redis:7", registrytest.RandomImage(t, 1))
+	g.Expect(migration.migrate(ctx)).To(Succeed())
+	g.Expect(report()).To(HaveKeyWithValue(registry.MigrationPhaseKey, registry.MigrationCompletedPhase))
+	g.Expect(report()).To(HaveKeyWithValue("missingImages", "0"))
+	g.Expect(registry.MigrationInProgress()).To(BeFalse())
+}
@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/registry"
+)
+
+const (
+	typeReadyRelease  = "Ready"
+	typeSyncedRelease = "Synced"
+
+	defaultReleaseInterval = 5 * time.Minute
+)
+
+// ReleaseReconciler reconciles a Release object
+type ReleaseReconciler struct {
+	client.Client
+	Scheme             *runtime.Scheme
+	Recorder           record.EventRecorder
+	InsecureRegistries []string
+	RootCAs            *x509.CertPool
+}
+
+//+kubebuilder:rbac:groups=kuik.enix.io,resources=releases,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=kuik.enix.io,resources=releases/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=kuik.enix.io,resources=cachedimages,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// Reconcile syncs the images of a Release from its source, puts them in cache and reports whether all of them are
+// cached, so that a CD pipeline can wait for the Ready condition before rolling the release out
+func (r *ReleaseReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var release kuikv1alpha1.Release
+	if err := r.Get(ctx, req.NamespacedName, &release); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	log.Info("reconciling release")
+
+	interval := defaultReleaseInterval
+	if release.Spec.Interval != nil && release.Spec.Interval.Duration > 0 {
+		interval = release.Spec.Interval.Duration
+	}
+
+	// CachedImages of the release changing only requires to report the progress of the caching
+	if release.Status.LastSyncedAt == nil || time.Since(release.Status.LastSyncedAt.Time) >= interval ||
+		release.Status.ObservedGeneration != release.Generation {
+		r.syncRelease(ctx, &release)
+	}
+
+	release.Status.CachedImages = 0
+	for _, sourceImage := range release.Status.Images {
+		cachedImage, err := CachedImageFromSourceImage(sourceImage)
+		if err != nil {
+			r.Recorder.Eventf(&release, "Warning", "InvalidImage", "Image %s is invalid: %s", sourceImage, err)
+			continue
+		}
+
+		if err := r.Get(ctx, types.NamespacedName{Name: cachedImage.Name}, cachedImage); apierrors.IsNotFound(err) {
+			log.Info("caching image", "sourceImage", sourceImage)
+			if err := r.Create(ctx, cachedImage); err != nil && !apierrors.IsAlreadyExists(err) {
+				return ctrl.Result{}, err
+			}
+		} else if err != nil {
+			return ctrl.Result{}, err
+		} else if cachedImage.Status.IsCached {
+			release.Status.CachedImages++
+		}
+	}
+
+	condition := metav1.Condition{
+		Type:    typeReadyRelease,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Cached",
+		Message: "Every image of the release is cached",
+	}
+	release.Status.Phase = "Ready"
+	if release.Status.LastSyncedAt == nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "NotSynced"
+		condition.Message = "The source of the release has not been synced yet"
+		release.Status.Phase = "Failed"
+	} else if release.Status.CachedImages < len(release.Status.Images) {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Caching"
+		condition.Message = fmt.Sprintf("%d/%d images of the release are cached", release.Status.CachedImages, len(release.Status.Images))
+		release.Status.Phase = "Caching"
+	}
+	meta.SetStatusCondition(&release.Status.Conditions, condition)
+
+	if err := r.Status().Update(ctx, &release); err != nil {
+		if apierrors.IsConflict(err) {
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	if release.Status.LastSyncedAt == nil || release.Status.ObservedGeneration != release.Generation {
+		return ctrl.Result{RequeueAfter: interval}, nil
+	}
+	return ctrl.Result{RequeueAfter: time.Until(release.Status.LastSyncedAt.Add(interval))}, nil
+}
+
+// syncRelease updates the images of a Release from its source, keeping the previous ones if the sync fails
+func (r *ReleaseReconciler) syncRelease(ctx context.Context, release *kuikv1alpha1.Release) {
+	images, err := r.releaseImages(release)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "could not sync release")
+		r.Recorder.Eventf(release, "Warning", "SyncFailed", "Could not sync release: %s", err)
+		meta.SetStatusCondition(&release.Status.Conditions, metav1.Condition{
+			Type:    typeSyncedRelease,
+			Status:  metav1.ConditionFalse,
+			Reason:  "SyncFailed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	now := metav1.Now()
+	release.Status.Images = images
+	release.Status.LastSyncedAt = &now
+	release.Status.ObservedGeneration = release.Generation
+	meta.SetStatusCondition(&release.Status.Conditions, metav1.Condition{
+		Type:    typeSyncedRelease,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Synced",
+		Message: fmt.Sprintf("%d images found in source", len(images)),
+	})
+}
+
+// releaseImages returns the images found in the source of a Release
+func (r *ReleaseReconciler) releaseImages(release *kuikv1alpha1.Release) ([]string, error) {
+	source := release.Spec.Source
+	switch {
+	case source.Index != "" && source.URL != "":
+		return nil, errors.New("only one of index and url can be set in source")
+	case source.Index != "":
+		return registry.IndexImages(source.Index, r.InsecureRegistries, r.RootCAs)
+	case source.URL != "":
+		return fetchManifests(source.URL, r.RootCAs)
+	default:
+		return nil, errors.New("either index or url must be set in source")
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ReleaseReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&kuikv1alpha1.Release{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(
+			&source.Kind{Type: &kuikv1alpha1.CachedImage{}},
+			handler.EnqueueRequestsFromMapFunc(r.releasesFromCachedImage),
+		).
+		Complete(r)
+}
+
+func (r *ReleaseReconciler) releasesFromCachedImage(obj client.Object) []ctrl.Request {
+	var releaseList kuikv1alpha1.ReleaseList
+	if err := r.List(context.Background(), &releaseList); err != nil {
+		return nil
+	}
+
+	requests := []ctrl.Request{}
+	for _, release := range releaseList.Items {
+		for _, sourceImage := range release.Status.Images {
+			if cachedImage, err := CachedImageFromSourceImage(sourceImage); err == nil && cachedImage.Name == obj.GetName() {
+				requests = append(requests, ctrl.Request{NamespacedName: types.NamespacedName{Name: release.Name}})
+				break
+			}
+		}
+	}
+
+	return requests
+}
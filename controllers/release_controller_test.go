@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReleaseReconcile(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	manifests := "kind: Kustomization\nimages:\n- name: nginx\n  newTag: \"1.25\"\n- name: redis\n  newTag: \"7\"\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/kustomization.yaml" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(manifests))
+	}))
+	defer server.Close()
+
+	release := &kuikv1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop-v2.3", Generation: 1},
+		Spec: kuikv1alpha1.ReleaseSpec{
+			Source: kuikv1alpha1.ReleaseSource{URL: server.URL + "/kustomization.yaml"},
+		},
+	}
+	cached := &kuikv1alpha1.CachedImage{
+		ObjectMeta: metav1.ObjectMeta{Name: "docker.io-library-redis-7"},
+		Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: "redis:7"},
+		Status:     kuikv1alpha1.CachedImageStatus{IsCached: true},
+	}
+
+	reconciler := &ReleaseReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(release, cached).Build(),
+		Recorder: record.NewFakeRecorder(10),
+	}
+	request := ctrl.Request{NamespacedName: types.NamespacedName{Name: release.Name}}
+	result, err := reconciler.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(BeNumerically("~", defaultReleaseInterval, defaultReleaseInterval/10))
+
+	// Missing images are put in cache, and the release is ready once all of them are cached
+	var nginx kuikv1alpha1.CachedImage
+	g.Expect(reconciler.Get(ctx, types.NamespacedName{Name: "docker.io-library-nginx-1.25"}, &nginx)).To(Succeed())
+	g.Expect(nginx.Spec.SourceImage).To(Equal("nginx:1.25"))
+
+	g.Expect(reconciler.Get(ctx, request.NamespacedName, release)).To(Succeed())
+	g.Expect(release.Status.Images).To(Equal([]string{"nginx:1.25", "redis:7"}))
+	g.Expect(release.Status.CachedImages).To(Equal(1))
+	g.Expect(release.Status.Phase).To(Equal("Caching"))
+	g.Expect(meta.IsStatusConditionTrue(release.Status.Conditions, typeSyncedRelease)).To(BeTrue())
+	g.Expect(meta.IsStatusConditionFalse(release.Status.Conditions, typeReadyRelease)).To(BeTrue())
+	g.Expect(reconciler.releasesFromCachedImage(&nginx)).To(Equal([]ctrl.Request{request}))
+
+	nginx.Status.IsCached = true
+	g.Expect(reconciler.Status().Update(ctx, &nginx)).To(Succeed())
+	// The source is not fetched again before the interval has elapsed
+	manifests = ""
+	_, err = reconciler.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(reconciler.Get(ctx, request.NamespacedName, release)).To(Succeed())
+	g.Expect(release.Status.Images).To(HaveLen(2))
+	g.Expect(release.Status.Phase).To(Equal("Ready"))
+	g.Expect(meta.IsStatusConditionTrue(release.Status.Conditions, typeReadyRelease)).To(BeTrue())
+
+	// Images of the last successful sync are kept when the source can't be fetched
+	release.Spec.Source.URL = server.URL + "/missing.yaml"
+	release.Generation = 2
+	g.Expect(reconciler.Update(ctx, release)).To(Succeed())
+	_, err = reconciler.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(reconciler.Get(ctx, request.NamespacedName, release)).To(Succeed())
+	g.Expect(release.Status.Images).To(HaveLen(2))
+	g.Expect(meta.IsStatusConditionFalse(release.Status.Conditions, typeSyncedRelease)).To(BeTrue())
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/enix/kube-image-keeper/internal/tlsconfig"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// maxManifestsSize is the maximum size of the manifests describing a release
+const maxManifestsSize = 10 << 20
+
+// fetchManifests downloads the manifests describing a release, e.g. from the raw URL of a file in a Git repository
+func fetchManifests(url string, rootCAs *x509.CertPool) ([]string, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsconfig.New()
+	transport.TLSClientConfig.RootCAs = rootCAs
+	httpClient := &http.Client{Transport: transport, Timeout: 30 * time.Second}
+
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch %s: %s", url, resp.Status)
+	}
+
+	return imagesFromManifests(io.LimitReader(resp.Body, maxManifestsSize))
+}
+
+// imagesFromManifests returns the images referenced by a stream of YAML or JSON documents, i.e. the image fields of
+// Kubernetes manifests and the images of kustomizations having a new tag or digest, sorted and deduplicated
+func imagesFromManifests(reader io.Reader) ([]string, error) {
+	images := map[string]struct{}{}
+
+	decoder := yaml.NewYAMLOrJSONDecoder(reader, 4096)
+	for {
+		document := map[string]interface{}{}
+		if err := decoder.Decode(&document); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if document["kind"] == "Kustomization" {
+			for _, image := range kustomizationImages(document) {
+				images[image] = struct{}{}
+			}
+			continue
+		}
+
+		collectImages(document, images)
+	}
+
+	sortedImages := make([]string, 0, len(images))
+	for image := range images {
+		sortedImages = append(sortedImages, image)
+	}
+	sort.Strings(sortedImages)
+
+	return sortedImages, nil
+}
+
+// collectImages adds the string values of the image fields found at any depth of a document to images
+func collectImages(value interface{}, images map[string]struct{}) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			if image, ok := field.(string); ok && key == "image" && image != "" {
+				images[image] = struct{}{}
+			} else {
+				collectImages(field, images)
+			}
+		}
+	case []interface{}:
+		for _, item := range value {
+			collectImages(item, images)
+		}
+	}
+}
+
+// kustomizationImages returns the images of a kustomization, as overridden by its images field. Entries changing
+// neither the tag nor the digest of an image are ignored since the resulting image can't be known without building
+// the kustomization.
+func kustomizationImages(kustomization map[string]interface{}) []string {
+	entries, _ := kustomization["images"].([]interface{})
+
+	images := []string{}
+	for _, entry := range entries {
+		image, _ := entry.(map[string]interface{})
+		name, _ := image["newName"].(string)
+		if name == "" {
+			name, _ = image["name"].(string)
+		}
+		if name == "" {
+			continue
+		}
+
+		if digest, _ := image["digest"].(string); digest != "" {
+			images = append(images, name+"@"+digest)
+		} else if tag, ok := image["newTag"]; ok {
+			images = append(images, fmt.Sprintf("%s:%v", name, tag))
+		}
+	}
+
+	return images
+}
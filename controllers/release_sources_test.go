@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestImagesFromManifests(t *testing.T) {
+	tests := []struct {
+		name      string
+		manifests string
+		expected  []string
+	}{
+		{
+			name: "Kubernetes manifests",
+			manifests: `apiVersion: apps/v1
+kind: Deployment
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: migrations
+        image: ghcr.io/enix/shop-migrations:2.3.0
+      containers:
+      - name: shop
+        image: ghcr.io/enix/shop:2.3.0
+      - name: nginx
+        image: nginx:1.25
+---
+apiVersion: batch/v1
+kind: CronJob
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: report
+            image: ghcr.io/enix/shop:2.3.0
+`,
+			expected: []string{"ghcr.io/enix/shop-migrations:2.3.0", "ghcr.io/enix/shop:2.3.0", "nginx:1.25"},
+		},
+		{
+			name: "Kustomization",
+			manifests: `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+resources:
+- ../base
+images:
+- name: ghcr.io/enix/shop
+  newTag: 2.3.0
+- name: nginx
+  newName: registry.example.com/nginx
+  newTag: "1.25"
+- name: redis
+  digest: sha256:8a5a6ff5a8c2a6ee07e5a3b1a9cdbe8e8b5b5c4a3d0f7b2c9e1d4f6a8b0c2e4f
+- name: postgres
+  newName: registry.example.com/postgres
+`,
+			expected: []string{
+				"ghcr.io/enix/shop:2.3.0",
+				"redis@sha256:8a5a6ff5a8c2a6ee07e5a3b1a9cdbe8e8b5b5c4a3d0f7b2c9e1d4f6a8b0c2e4f",
+				"registry.example.com/nginx:1.25",
+			},
+		},
+		{
+			name:      "JSON",
+			manifests: `{"kind": "Pod", "spec": {"containers": [{"name": "shop", "image": "ghcr.io/enix/shop:2.3.0"}]}}`,
+			expected:  []string{"ghcr.io/enix/shop:2.3.0"},
+		},
+		{
+			name:      "Empty",
+			manifests: "",
+			expected:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			images, err := imagesFromManifests(strings.NewReader(tt.manifests))
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(images).To(Equal(tt.expected))
+		})
+	}
+
+	g := NewWithT(t)
+	_, err := imagesFromManifests(strings.NewReader("kind: [Deployment"))
+	g.Expect(err).To(HaveOccurred())
+}
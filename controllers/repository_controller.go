@@ -2,6 +2,8 @@ package controllers
 
 import (
 	"context"
+	"crypto/x509"
+	"time"
 
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
@@ -30,6 +32,13 @@ const (
 type RepositoryReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// ApiReader reads the pull secrets the tags of Repositories are listed with
+	ApiReader          client.Reader
+	InsecureRegistries []string
+	RootCAs            *x509.CertPool
+	// MaxSyncedTags bounds the number of tags of a Repository put in cache by its spec.tags, the latest ones being
+	// kept, unbounded if 0
+	MaxSyncedTags int
 }
 
 //+kubebuilder:rbac:groups=kuik.enix.io,resources=repositories,verbs=get;list;watch;create;update;patch;delete
@@ -60,6 +69,7 @@ func (r *RepositoryReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{}, err
 	}
 	repository.Status.Images = len(cachedImageList.Items)
+	imagesReady := aggregateImages(&repository, cachedImageList.Items)
 
 	if !repository.ObjectMeta.DeletionTimestamp.IsZero() {
 		r.UpdateStatus(ctx, &repository, []metav1.Condition{{
@@ -86,12 +96,15 @@ func (r *RepositoryReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{}, nil
 	}
 
+	// Failing to list tags doesn't prevent the status from being updated, the error is returned once it is
+	tagsSyncIn, tagsErr := r.syncTags(ctx, &repository, time.Now())
+
 	err := r.UpdateStatus(ctx, &repository, []metav1.Condition{{
 		Type:    typeReadyRepository,
 		Status:  metav1.ConditionTrue,
 		Reason:  "Created",
 		Message: "Repository is ready",
-	}})
+	}, imagesReady})
 	if err != nil {
 		return ctrl.Result{}, err
 	}
@@ -105,15 +118,17 @@ func (r *RepositoryReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		}
 	}
 
-	return ctrl.Result{}, nil
+	return ctrl.Result{RequeueAfter: tagsSyncIn}, tagsErr
 }
 
 func (r *RepositoryReconciler) UpdateStatus(ctx context.Context, repository *kuikv1alpha1.Repository, conditions []metav1.Condition) error {
 	log := log.FromContext(ctx)
 
 	for _, condition := range conditions {
+		condition.ObservedGeneration = repository.Generation
 		meta.SetStatusCondition(&repository.Status.Conditions, condition)
 	}
+	repository.Status.ObservedGeneration = repository.Generation
 
 	conditionReady := meta.FindStatusCondition(repository.Status.Conditions, typeReadyRepository)
 	if conditionReady.Status == metav1.ConditionTrue {
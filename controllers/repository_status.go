@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"fmt"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// typeImagesReadyRepository is the type of the condition telling whether every image of a Repository is cached
+const typeImagesReadyRepository = "ImagesReady"
+
+// cachedImageFailure returns the false Ready condition of a CachedImage that failed to be cached, or nil if it is cached
+// or not cached yet, e.g. while caching is delayed by the upstream budget
+func cachedImageFailure(cachedImage *kuikv1alpha1.CachedImage) *metav1.Condition {
+	if cachedImage.Status.IsCached {
+		return nil
+	}
+	condition := meta.FindStatusCondition(cachedImage.Status.Conditions, kuikv1alpha1.ConditionReady)
+	if condition == nil || condition.Status != metav1.ConditionFalse || condition.Reason == "CacheDelayed" {
+		return nil
+	}
+	return condition
+}
+
+// aggregateImages sets the status of a Repository from the states of its CachedImages and returns its ImagesReady
+// condition, so that the Repository tells whether every image of a repository is healthy
+func aggregateImages(repository *kuikv1alpha1.Repository, cachedImages []kuikv1alpha1.CachedImage) metav1.Condition {
+	status := &repository.Status
+	status.CachedImages = 0
+	status.PendingImages = 0
+	status.FailedImages = 0
+	status.TotalSize = 0
+	status.OldestFailure = nil
+
+	for i := range cachedImages {
+		cachedImage := &cachedImages[i]
+		status.TotalSize += cachedImage.Status.Size
+
+		if cachedImage.Status.IsCached {
+			status.CachedImages++
+		} else if failure := cachedImageFailure(cachedImage); failure != nil {
+			status.FailedImages++
+			if status.OldestFailure == nil || failure.LastTransitionTime.Before(&status.OldestFailure.Since) {
+				status.OldestFailure = &kuikv1alpha1.ImageFailure{
+					CachedImage: cachedImage.Name,
+					SourceImage: cachedImage.Spec.SourceImage,
+					Reason:      failure.Reason,
+					Since:       failure.LastTransitionTime,
+				}
+			}
+		} else {
+			status.PendingImages++
+		}
+	}
+
+	switch {
+	case status.FailedImages > 0:
+		return metav1.Condition{
+			Type:    typeImagesReadyRepository,
+			Status:  metav1.ConditionFalse,
+			Reason:  "ImagesFailed",
+			Message: fmt.Sprintf("%d images failed to be cached, %s for the longest time (%s)", status.FailedImages, status.OldestFailure.SourceImage, status.OldestFailure.Reason),
+		}
+	case status.PendingImages > 0:
+		return metav1.Condition{
+			Type:    typeImagesReadyRepository,
+			Status:  metav1.ConditionFalse,
+			Reason:  "ImagesPending",
+			Message: fmt.Sprintf("%d images are not cached yet", status.PendingImages),
+		}
+	default:
+		return metav1.Condition{
+			Type:    typeImagesReadyRepository,
+			Status:  metav1.ConditionTrue,
+			Reason:  "ImagesCached",
+			Message: fmt.Sprintf("%d images are cached", status.CachedImages),
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func failedCachedImage(name string, reason string, since time.Time) kuikv1alpha1.CachedImage {
+	return kuikv1alpha1.CachedImage{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: "ghcr.io/myorg/" + name},
+		Status: kuikv1alpha1.CachedImageStatus{
+			Conditions: []metav1.Condition{{
+				Type:               kuikv1alpha1.ConditionReady,
+				Status:             metav1.ConditionFalse,
+				Reason:             reason,
+				LastTransitionTime: metav1.NewTime(since),
+			}},
+		},
+	}
+}
+
+func TestAggregateImages(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	cached := kuikv1alpha1.CachedImage{Status: kuikv1alpha1.CachedImageStatus{IsCached: true, Size: 100}}
+	pending := kuikv1alpha1.CachedImage{}
+	delayed := failedCachedImage("delayed", "CacheDelayed", now.Add(-time.Hour))
+
+	tests := []struct {
+		name          string
+		cachedImages  []kuikv1alpha1.CachedImage
+		expected      kuikv1alpha1.RepositoryStatus
+		wantCondition metav1.Condition
+	}{
+		{
+			name:         "Every image cached",
+			cachedImages: []kuikv1alpha1.CachedImage{cached, cached},
+			expected:     kuikv1alpha1.RepositoryStatus{CachedImages: 2, TotalSize: 200},
+			wantCondition: metav1.Condition{
+				Type:    typeImagesReadyRepository,
+				Status:  metav1.ConditionTrue,
+				Reason:  "ImagesCached",
+				Message: "2 images are cached",
+			},
+		},
+		{
+			name:         "Images pending",
+			cachedImages: []kuikv1alpha1.CachedImage{cached, pending, delayed},
+			expected:     kuikv1alpha1.RepositoryStatus{CachedImages: 1, PendingImages: 2, TotalSize: 100},
+			wantCondition: metav1.Condition{
+				Type:    typeImagesReadyRepository,
+				Status:  metav1.ConditionFalse,
+				Reason:  "ImagesPending",
+				Message: "2 images are not cached yet",
+			},
+		},
+		{
+			name: "Images failed",
+			cachedImages: []kuikv1alpha1.CachedImage{
+				cached,
+				pending,
+				failedCachedImage("app", kuikv1alpha1.ReasonUnauthorized, now.Add(-time.Minute)),
+				failedCachedImage("worker", kuikv1alpha1.ReasonImageNotFound, now.Add(-time.Hour)),
+			},
+			expected: kuikv1alpha1.RepositoryStatus{
+				CachedImages:  1,
+				PendingImages: 1,
+				FailedImages:  2,
+				TotalSize:     100,
+				OldestFailure: &kuikv1alpha1.ImageFailure{
+					CachedImage: "worker",
+					SourceImage: "ghcr.io/myorg/worker",
+					Reason:      kuikv1alpha1.ReasonImageNotFound,
+					Since:       metav1.NewTime(now.Add(-time.Hour)),
+				},
+			},
+			wantCondition: metav1.Condition{
+				Type:    typeImagesReadyRepository,
+				Status:  metav1.ConditionFalse,
+				Reason:  "ImagesFailed",
+				Message: "2 images failed to be cached, ghcr.io/myorg/worker for the longest time (ImageNotFound)",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			repository := &kuikv1alpha1.Repository{}
+			g.Expect(aggregateImages(repository, tt.cachedImages)).To(Equal(tt.wantCondition))
+			g.Expect(repository.Status).To(Equal(tt.expected))
+		})
+	}
+}
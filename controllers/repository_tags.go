@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+	"unicode"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/registry"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// defaultTagsSyncInterval is how often the tags of Repositories are listed upstream when their spec.tags doesn't set it
+const defaultTagsSyncInterval = time.Hour
+
+// errTagsUnbounded is returned for a spec.tags setting neither a pattern nor a number of latest tags, which would put
+// every tag of the repository in cache
+var errTagsUnbounded = errors.New("spec.tags must set a pattern or a number of latest tags")
+
+// syncTags puts in cache the tags of a Repository matching the pattern of its spec.tags, only the latest ones if
+// spec.tags.latest is set and at most MaxSyncedTags of them, creating a retained CachedImage for each new tag, and
+// releases the CachedImages it created for tags that disappeared upstream or no longer match, which then expire as
+// usual. Tags are listed again once the interval of spec.tags has elapsed or when the spec changes. It returns how long
+// until tags must be listed again, 0 if they are not synced.
+func (r *RepositoryReconciler) syncTags(ctx context.Context, repository *kuikv1alpha1.Repository, now time.Time) (time.Duration, error) {
+	log := log.FromContext(ctx)
+
+	var synced kuikv1alpha1.CachedImageList
+	if err := r.List(ctx, &synced, client.MatchingLabels{kuikv1alpha1.SyncedTagsLabelName: repository.Name}); err != nil {
+		return 0, err
+	}
+
+	tags := repository.Spec.Tags
+	if tags == nil {
+		repository.Status.SyncedTags = 0
+		repository.Status.TagsSyncedAt = nil
+		return 0, r.releaseTags(ctx, synced.Items, map[string]bool{})
+	}
+
+	interval := defaultTagsSyncInterval
+	if tags.Interval != nil && tags.Interval.Duration > 0 {
+		interval = tags.Interval.Duration
+	}
+	specChanged := repository.Status.ObservedGeneration != repository.Generation
+	if syncedAt := repository.Status.TagsSyncedAt; syncedAt != nil && !specChanged && now.Before(syncedAt.Add(interval)) {
+		return syncedAt.Add(interval).Sub(now), nil
+	}
+	if repository.Spec.Paused || registry.Airgapped {
+		log.Info("repository is paused or upstream registries are not reachable in air-gapped mode, not listing its tags")
+		return interval, nil
+	}
+
+	if tags.Pattern == "" && tags.Latest <= 0 {
+		return 0, errTagsUnbounded
+	}
+	pattern, err := regexp.Compile(tags.Pattern)
+	if err != nil {
+		return 0, fmt.Errorf("invalid tags pattern %q: %w", tags.Pattern, err)
+	}
+	pullSecrets, err := registry.GetPullSecrets(r.ApiReader, repository.Spec.PullSecretsNamespace, repository.Spec.PullSecretNames)
+	if err != nil {
+		return 0, err
+	}
+	upstreamTags, err := registry.RepositoryTags(repository.Spec.Name, pullSecrets, r.InsecureRegistries, r.RootCAs)
+	if err != nil {
+		return 0, fmt.Errorf("could not list tags of %s: %w", repository.Spec.Name, err)
+	}
+
+	matching := []string{}
+	for _, tag := range upstreamTags {
+		if pattern.MatchString(tag) {
+			matching = append(matching, tag)
+		}
+	}
+	sort.SliceStable(matching, func(i, j int) bool {
+		return compareTags(matching[i], matching[j]) > 0
+	})
+	limit := tags.Latest
+	if r.MaxSyncedTags > 0 && (limit <= 0 || limit > r.MaxSyncedTags) {
+		limit = r.MaxSyncedTags
+	}
+	if limit > 0 && len(matching) > limit {
+		if tags.Latest <= 0 || tags.Latest > limit {
+			log.Info("too many tags match, only caching the latest ones", "matching", len(matching), "maxSyncedTags", limit)
+		}
+		matching = matching[:limit]
+	}
+
+	wanted := map[string]bool{}
+	for _, tag := range matching {
+		sourceImage := repository.Spec.Name + ":" + tag
+		cachedImage, err := CachedImageFromSourceImage(sourceImage)
+		if err != nil {
+			log.Error(err, "ignoring invalid tag", "tag", tag)
+			continue
+		}
+		wanted[cachedImage.Name] = true
+
+		if err := r.Get(ctx, types.NamespacedName{Name: cachedImage.Name}, cachedImage); apierrors.IsNotFound(err) {
+			log.Info("caching new tag", "sourceImage", sourceImage)
+			cachedImage.Spec.Retain = true
+			cachedImage.Labels = map[string]string{kuikv1alpha1.SyncedTagsLabelName: repository.Name}
+			if err := r.Create(ctx, cachedImage); err != nil && !apierrors.IsAlreadyExists(err) {
+				return 0, err
+			}
+		} else if err != nil {
+			return 0, err
+		}
+	}
+
+	if err := r.releaseTags(ctx, synced.Items, wanted); err != nil {
+		return 0, err
+	}
+
+	repository.Status.SyncedTags = len(wanted)
+	repository.Status.TagsSyncedAt = &metav1.Time{Time: now}
+	return interval, nil
+}
+
+// releaseTags stops retaining the CachedImages created for tags that are not wanted anymore, so that they expire once
+// unused
+func (r *RepositoryReconciler) releaseTags(ctx context.Context, synced []kuikv1alpha1.CachedImage, wanted map[string]bool) error {
+	for i := range synced {
+		cachedImage := &synced[i]
+		if wanted[cachedImage.Name] {
+			continue
+		}
+
+		log.FromContext(ctx).Info("releasing tag", "sourceImage", cachedImage.Spec.SourceImage)
+		patch := client.MergeFrom(cachedImage.DeepCopy())
+		delete(cachedImage.Labels, kuikv1alpha1.SyncedTagsLabelName)
+		cachedImage.Spec.Retain = false
+		if err := r.Patch(ctx, cachedImage, patch); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// compareTags orders tags like versions, comparing their numeric parts as numbers and the others lexically, so that
+// e.g. v1.10 comes after v1.9. It returns a negative number if a comes before b, a positive one if it comes after.
+func compareTags(a, b string) int {
+	for a != "" && b != "" {
+		partA, partB := tagPart(a), tagPart(b)
+		a, b = a[len(partA):], b[len(partB):]
+		numberA, errA := strconv.ParseUint(partA, 10, 64)
+		numberB, errB := strconv.ParseUint(partB, 10, 64)
+		switch {
+		case errA == nil && errB == nil && numberA != numberB:
+			if numberA < numberB {
+				return -1
+			}
+			return 1
+		case partA < partB:
+			return -1
+		case partA > partB:
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
+
+// tagPart returns the leading run of digits or non-digits of a tag
+func tagPart(tag string) string {
+	isDigit := unicode.IsDigit(rune(tag[0]))
+	for i, c := range tag {
+		if unicode.IsDigit(c) != isDigit {
+			return tag[:i]
+		}
+	}
+	return tag
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	"github.com/enix/kube-image-keeper/pkg/registrytest"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSyncTags(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	upstream := registrytest.New(t)
+	for _, tag := range []string{"v1", "v2", "dev"} {
+		upstream.PushImage(t, "shop/api:"+tag, registrytest.RandomImage(t, 1))
+	}
+	repositoryName := upstream.Addr() + "/shop/api"
+
+	// The CachedImage of v1 has been created for a pod, the one of v0 for a tag that disappeared upstream
+	existing, err := CachedImageFromSourceImage(repositoryName + ":v1")
+	g.Expect(err).To(BeNil())
+	removed, err := CachedImageFromSourceImage(repositoryName + ":v0")
+	g.Expect(err).To(BeNil())
+	removed.Labels = map[string]string{kuikv1alpha1.SyncedTagsLabelName: "shop-api"}
+	removed.Spec.Retain = true
+
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(existing, removed).Build()
+	r := &RepositoryReconciler{Client: k8sClient, ApiReader: k8sClient}
+	repository := &kuikv1alpha1.Repository{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop-api", Generation: 1},
+		Spec: kuikv1alpha1.RepositorySpec{
+			Name: repositoryName,
+			Tags: &kuikv1alpha1.RepositoryTags{Pattern: "^v[0-9]+$", Interval: &metav1.Duration{Duration: 10 * time.Minute}},
+		},
+	}
+
+	get := func(sourceImage string) *kuikv1alpha1.CachedImage {
+		cachedImage, err := CachedImageFromSourceImage(sourceImage)
+		g.Expect(err).To(BeNil())
+		g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: cachedImage.Name}, cachedImage)).To(Succeed())
+		return cachedImage
+	}
+
+	syncIn, err := r.syncTags(ctx, repository, now)
+	g.Expect(err).To(BeNil())
+	g.Expect(syncIn).To(Equal(10 * time.Minute))
+	g.Expect(repository.Status.SyncedTags).To(Equal(2))
+	g.Expect(repository.Status.TagsSyncedAt.Time).To(Equal(now))
+
+	// New matching tags are retained, existing CachedImages are left as is and tags that disappeared are released
+	g.Expect(get(repositoryName + ":v2").Spec.Retain).To(BeTrue())
+	g.Expect(get(repositoryName + ":v2").Labels).To(HaveKeyWithValue(kuikv1alpha1.SyncedTagsLabelName, "shop-api"))
+	g.Expect(get(repositoryName + ":v1").Spec.Retain).To(BeFalse())
+	g.Expect(get(repositoryName + ":v0").Spec.Retain).To(BeFalse())
+	g.Expect(get(repositoryName + ":v0").Labels).ToNot(HaveKey(kuikv1alpha1.SyncedTagsLabelName))
+	cachedImage, err := CachedImageFromSourceImage(repositoryName + ":dev")
+	g.Expect(err).To(BeNil())
+	g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: cachedImage.Name}, cachedImage)).ToNot(Succeed())
+
+	// Tags are not listed again before the interval has elapsed, unless the spec changes
+	repository.Status.ObservedGeneration = 1
+	requests := len(upstream.Requests())
+	syncIn, err = r.syncTags(ctx, repository, now.Add(4*time.Minute))
+	g.Expect(err).To(BeNil())
+	g.Expect(syncIn).To(Equal(6 * time.Minute))
+	g.Expect(upstream.Requests()).To(HaveLen(requests))
+
+	// Only the latest matching tags are put in cache, bounded by MaxSyncedTags
+	upstream.PushImage(t, "shop/api:v10", registrytest.RandomImage(t, 1))
+	repository.Spec.Tags.Latest = 2
+	repository.Generation = 2
+	_, err = r.syncTags(ctx, repository, now)
+	g.Expect(err).To(BeNil())
+	g.Expect(repository.Status.SyncedTags).To(Equal(2))
+	g.Expect(get(repositoryName + ":v10").Spec.Retain).To(BeTrue())
+	g.Expect(get(repositoryName + ":v2").Spec.Retain).To(BeTrue())
+	r.MaxSyncedTags = 1
+	repository.Generation = 3
+	_, err = r.syncTags(ctx, repository, now)
+	g.Expect(err).To(BeNil())
+	g.Expect(repository.Status.SyncedTags).To(Equal(1))
+	g.Expect(get(repositoryName + ":v2").Spec.Retain).To(BeFalse())
+
+	// Every tag is never put in cache by mistake
+	repository.Spec.Tags = &kuikv1alpha1.RepositoryTags{}
+	repository.Generation = 4
+	_, err = r.syncTags(ctx, repository, now)
+	g.Expect(err).To(MatchError(errTagsUnbounded))
+	g.Expect(get(repositoryName + ":v10").Spec.Retain).To(BeTrue())
+
+	// CachedImages are released once tags are not synced anymore
+	repository.Spec.Tags = nil
+	_, err = r.syncTags(ctx, repository, now)
+	g.Expect(err).To(BeNil())
+	g.Expect(get(repositoryName + ":v10").Spec.Retain).To(BeFalse())
+	g.Expect(repository.Status.SyncedTags).To(BeZero())
+}
+
+func TestCompareTags(t *testing.T) {
+	g := NewWithT(t)
+
+	tags := []string{"v1.10.0", "latest", "v1.9.1", "v1.9", "v1.9.1-alpine", "v2.0.0"}
+	sort.Slice(tags, func(i, j int) bool { return compareTags(tags[i], tags[j]) < 0 })
+	g.Expect(tags).To(Equal([]string{"latest", "v1.9", "v1.9.1", "v1.9.1-alpine", "v1.10.0", "v2.0.0"}))
+}
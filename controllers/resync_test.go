@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	"github.com/enix/kube-image-keeper/pkg/registrytest"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestTagMoved(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	upstream := registrytest.New(t)
+	image := registrytest.RandomImage(t, 1)
+	upstream.PushImage(t, "app:latest", image)
+	digest, err := image.Digest()
+	g.Expect(err).To(BeNil())
+
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).Build()
+	recorder := record.NewFakeRecorder(10)
+	r := &CachedImageReconciler{Client: k8sClient, ApiReader: k8sClient, Recorder: recorder, MutableTagsResyncInterval: time.Hour}
+
+	refreshedAt := metav1.NewTime(time.Now().Add(-2 * time.Hour))
+	cachedImage := &kuikv1alpha1.CachedImage{
+		Spec: kuikv1alpha1.CachedImageSpec{SourceImage: upstream.Addr() + "/app:latest"},
+		// Only some architectures of the image are cached
+		Status: kuikv1alpha1.CachedImageStatus{RefreshedAt: &refreshedAt, Digest: "sha256:" + strings.Repeat("1", 64)},
+	}
+
+	// Images cached before their upstream digest was recorded get it recorded instead of being pulled again
+	g.Expect(r.tagMoved(ctx, cachedImage)).To(BeFalse())
+	g.Expect(cachedImage.Status.ResyncedAt).ToNot(BeNil())
+	g.Expect(cachedImage.Status.UpstreamDigest).To(Equal(digest.String()))
+	g.Expect(upstream.Requests()).To(ContainElement("HEAD /v2/app/manifests/latest"))
+
+	// The tag still points to the pulled image
+	cachedImage.Status.ResyncedAt = nil
+	g.Expect(r.tagMoved(ctx, cachedImage)).To(BeFalse())
+
+	// Images are not resynced before the interval has elapsed
+	requests := len(upstream.Requests())
+	g.Expect(r.tagMoved(ctx, cachedImage)).To(BeFalse())
+	g.Expect(upstream.Requests()).To(HaveLen(requests))
+
+	// The tag has moved since the image has been pulled
+	cachedImage.Status.ResyncedAt = nil
+	cachedImage.Status.UpstreamDigest = "sha256:" + strings.Repeat("0", 64)
+	g.Expect(r.tagMoved(ctx, cachedImage)).To(BeTrue())
+	g.Expect(<-recorder.Events).To(HavePrefix("Normal TagMoved Tag of image " + upstream.Addr() + "/app:latest moved upstream"))
+
+	// The upstream digest of pulled images is recorded
+	r.recordUpstreamDigest(ctx, cachedImage)
+	g.Expect(cachedImage.Status.UpstreamDigest).To(Equal(digest.String()))
+}
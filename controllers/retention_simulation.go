@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"regexp"
+	"sort"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+)
+
+// RetentionPolicy is a retention policy of the cache whose effects are simulated by SimulateRetention, made of the same
+// settings as the ones of the controllers
+type RetentionPolicy struct {
+	ExpiryDelay              time.Duration
+	MutableTagsExpiryDelay   time.Duration
+	ImmutableTagsExpiryDelay time.Duration
+	// ImmutableTags matches tags that are not expected to change upstream, DefaultImmutableTags if nil
+	ImmutableTags         *regexp.Regexp
+	NodeImagesExpiryDelay time.Duration
+	// MaxSize is the cache quota in bytes, 0 if the cache is not limited
+	MaxSize int64
+}
+
+type SimulatedRemoval struct {
+	Name        string    `json:"name"`
+	SourceImage string    `json:"sourceImage"`
+	Size        int64     `json:"size"`
+	At          time.Time `json:"at"`
+	// Reason is Expired for images expiring, or Evicted for images evicted to enforce the cache quota
+	Reason string `json:"reason"`
+}
+
+type StorageProjection struct {
+	At     time.Time `json:"at"`
+	Images int       `json:"images"`
+	Bytes  int64     `json:"bytes"`
+}
+
+type RetentionSimulation struct {
+	// Removed are the images that would leave the cache before the end of the simulation, in chronological order
+	Removed []SimulatedRemoval `json:"removed"`
+	// Storage is the projected size of the cache at the start of the simulation and after each step
+	Storage []StorageProjection `json:"storage"`
+}
+
+// SimulateRetention simulates the expiry and eviction of cached images under a retention policy, from now until the
+// horizon, and projects the size of the cache after each step. Usage is assumed not to change: images used by pods
+// stay used, images present on nodes stay present and unused images are not pulled anymore. Images used by scaled
+// workloads are considered unused.
+func SimulateRetention(cachedImages []kuikv1alpha1.CachedImage, policy RetentionPolicy, now time.Time, horizon time.Duration, step time.Duration) *RetentionSimulation {
+	r := &CachedImageReconciler{
+		ExpiryDelay:              policy.ExpiryDelay,
+		MutableTagsExpiryDelay:   policy.MutableTagsExpiryDelay,
+		ImmutableTagsExpiryDelay: policy.ImmutableTagsExpiryDelay,
+		ImmutableTags:            policy.ImmutableTags,
+		NodeImagesExpiryDelay:    policy.NodeImagesExpiryDelay,
+	}
+
+	simulation := &RetentionSimulation{Removed: []SimulatedRemoval{}, Storage: []StorageProjection{}}
+	remaining := []*kuikv1alpha1.CachedImage{}
+	expiries := map[string]time.Time{}
+	for i := range cachedImages {
+		cachedImage := &cachedImages[i]
+		if !cachedImage.Status.IsCached || !cachedImage.DeletionTimestamp.IsZero() {
+			continue
+		}
+		remaining = append(remaining, cachedImage)
+		if expiresAt, ok := r.simulatedExpiry(cachedImage, now); ok {
+			expiries[cachedImage.Name] = expiresAt
+		}
+	}
+
+	// Images are evicted in the same order as by the cache quota
+	sort.SliceStable(remaining, func(i, j int) bool {
+		return lastUsedAt(remaining[i]).Before(lastUsedAt(remaining[j]))
+	})
+
+	remove := func(cachedImage *kuikv1alpha1.CachedImage, at time.Time, reason string) {
+		simulation.Removed = append(simulation.Removed, SimulatedRemoval{
+			Name:        cachedImage.Name,
+			SourceImage: cachedImage.Spec.SourceImage,
+			Size:        cachedImage.Status.Size,
+			At:          at,
+			Reason:      reason,
+		})
+	}
+
+	for t := now; !t.After(now.Add(horizon)); t = t.Add(step) {
+		kept := remaining[:0]
+		size := int64(0)
+		for _, cachedImage := range remaining {
+			if expiresAt, ok := expiries[cachedImage.Name]; ok && !expiresAt.After(t) {
+				remove(cachedImage, expiresAt, "Expired")
+				continue
+			}
+			kept = append(kept, cachedImage)
+			size += cachedImage.Status.Size
+		}
+		remaining = kept
+
+		if policy.MaxSize > 0 && size > policy.MaxSize {
+			kept := remaining[:0]
+			for _, cachedImage := range remaining {
+				if size > policy.MaxSize && isEvictable(cachedImage, t) {
+					remove(cachedImage, t, "Evicted")
+					size -= cachedImage.Status.Size
+					continue
+				}
+				kept = append(kept, cachedImage)
+			}
+			remaining = kept
+		}
+
+		simulation.Storage = append(simulation.Storage, StorageProjection{At: t, Images: len(remaining), Bytes: size})
+		if step <= 0 {
+			break
+		}
+	}
+
+	sort.SliceStable(simulation.Removed, func(i, j int) bool {
+		return simulation.Removed[i].At.Before(simulation.Removed[j].At)
+	})
+
+	return simulation
+}
+
+// simulatedExpiry returns when an unused CachedImage would expire under the retention policy of r, counting the expiry
+// delay from the last time it has been used or from the end of its pin, and false if it doesn't expire because it is
+// used, retained or present on nodes. Images that should already have expired expire now.
+func (r *CachedImageReconciler) simulatedExpiry(cachedImage *kuikv1alpha1.CachedImage, now time.Time) (time.Time, bool) {
+	if len(cachedImage.Status.UsedBy.Pods) > 0 || cachedImage.Spec.Retain || r.isOnNodes(cachedImage) {
+		return time.Time{}, false
+	}
+
+	delay := r.expiryDelay(cachedImage)
+	expiresAt := lastUsedAt(cachedImage).Add(delay)
+	if cachedImage.IsPinned(now) {
+		if unpinnedExpiry := cachedImage.Spec.PinnedUntil.Add(delay); unpinnedExpiry.After(expiresAt) {
+			expiresAt = unpinnedExpiry
+		}
+	} else {
+		expiresAt = r.nodeAwareExpiry(cachedImage, expiresAt)
+	}
+
+	if expiresAt.Before(now) {
+		return now, true
+	}
+	return expiresAt, true
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSimulateRetention(t *testing.T) {
+	g := NewWithT(t)
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	cachedImage := func(name string, sourceImage string, size int64, lastUsedDaysAgo int) kuikv1alpha1.CachedImage {
+		lastUsedAt := metav1.NewTime(now.Add(-time.Duration(lastUsedDaysAgo) * day))
+		return kuikv1alpha1.CachedImage{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: sourceImage},
+			Status:     kuikv1alpha1.CachedImageStatus{IsCached: true, Size: size, LastUsedAt: &lastUsedAt},
+		}
+	}
+
+	used := cachedImage("used", "nginx:latest", 100, 40)
+	used.Status.UsedBy.Pods = []kuikv1alpha1.PodReference{{NamespacedName: "default/nginx"}}
+	retained := cachedImage("retained", "redis:7", 100, 40)
+	retained.Spec.Retain = true
+	pinned := cachedImage("pinned", "postgres:16.2.0", 100, 40)
+	pinnedUntil := metav1.NewTime(now.Add(2 * day))
+	pinned.Spec.PinnedUntil = &pinnedUntil
+	cachedImages := []kuikv1alpha1.CachedImage{
+		used,
+		retained,
+		pinned,
+		cachedImage("stale", "alpine:latest", 100, 40),
+		cachedImage("mutable", "busybox:latest", 100, 5),
+		cachedImage("immutable", "nginx:1.25.3", 100, 5),
+	}
+
+	policy := RetentionPolicy{ExpiryDelay: 30 * day, MutableTagsExpiryDelay: 7 * day}
+	simulation := SimulateRetention(cachedImages, policy, now, 30*day, day)
+
+	// Images that should already have expired expire now, others once their expiry delay has elapsed
+	removed := []SimulatedRemoval{}
+	for _, removal := range simulation.Removed {
+		removed = append(removed, SimulatedRemoval{Name: removal.Name, At: removal.At, Reason: removal.Reason})
+	}
+	g.Expect(removed).To(Equal([]SimulatedRemoval{
+		{Name: "stale", At: now, Reason: "Expired"},
+		{Name: "mutable", At: now.Add(2 * day), Reason: "Expired"},
+		{Name: "immutable", At: now.Add(25 * day), Reason: "Expired"},
+	}))
+	g.Expect(simulation.Storage).To(HaveLen(31))
+	g.Expect(simulation.Storage[0]).To(Equal(StorageProjection{At: now, Images: 5, Bytes: 500}))
+	g.Expect(simulation.Storage[2]).To(Equal(StorageProjection{At: now.Add(2 * day), Images: 4, Bytes: 400}))
+	g.Expect(simulation.Storage[30]).To(Equal(StorageProjection{At: now.Add(30 * day), Images: 3, Bytes: 300}))
+
+	// The least recently used images are evicted to fit in the quota, pinned ones once their pin has ended
+	policy.MaxSize = 250
+	simulation = SimulateRetention(cachedImages, policy, now, 40*day, day)
+	g.Expect(simulation.Removed).To(ContainElement(SimulatedRemoval{Name: "mutable", SourceImage: "busybox:latest", Size: 100, At: now, Reason: "Evicted"}))
+	g.Expect(simulation.Removed).To(ContainElement(SimulatedRemoval{Name: "pinned", SourceImage: "postgres:16.2.0", Size: 100, At: now.Add(2 * day), Reason: "Evicted"}))
+	g.Expect(simulation.Storage[0].Bytes).To(Equal(int64(300)))
+	g.Expect(simulation.Storage[2].Bytes).To(Equal(int64(200)))
+}
@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/strings/slices"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"github.com/enix/kube-image-keeper/internal/registry"
+)
+
+// LabelSandboxImageName is set on CachedImages of the sandbox images of container runtimes
+const LabelSandboxImageName = "kuik.enix.io/sandbox-image"
+
+// DefaultSandboxImages matches the pause images used as sandbox image by most container runtimes and distributions,
+// e.g. registry.k8s.io/pause:3.9, mcr.microsoft.com/oss/kubernetes/pause:3.6 or rancher/mirrored-pause:3.6
+var DefaultSandboxImages = regexp.MustCompile(`(^|[/-])pause:[^/@]+$`)
+
+// SandboxImageReconciler caches the sandbox image of the container runtime of each node, which is pulled by the
+// runtime itself and thus can't be rewritten by the pod webhook, while no pod can start without it.
+type SandboxImageReconciler struct {
+	client.Client
+	// SandboxImages matches sandbox images among the images of the local store of nodes, DefaultSandboxImages if nil
+	SandboxImages *regexp.Regexp
+}
+
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=kuik.enix.io,resources=cachedimages,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile creates retained CachedImages for the sandbox images found in the local store of a node
+func (r *SandboxImageReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var node corev1.Node
+	if err := r.Get(ctx, req.NamespacedName, &node); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	for _, sourceImage := range r.sandboxImagesFromNode(&node) {
+		cachedImage, err := CachedImageFromSourceImage(sourceImage)
+		if err != nil {
+			log.Error(err, "ignoring invalid sandbox image", "sourceImage", sourceImage)
+			continue
+		}
+
+		cachedImage.Labels = map[string]string{LabelSandboxImageName: "true"}
+		cachedImage.Spec.Retain = true
+		err = r.Create(ctx, cachedImage)
+		if err == nil {
+			log.Info("caching sandbox image", "sourceImage", sourceImage)
+			continue
+		} else if !apierrors.IsAlreadyExists(err) {
+			return ctrl.Result{}, err
+		}
+
+		// Make sure the sandbox image is retained if it has been cached otherwise before
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{"labels": cachedImage.Labels},
+			"spec":     map[string]interface{}{"retain": true},
+		})
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.Patch(ctx, cachedImage, client.RawPatch(types.MergePatchType, patch)); err != nil {
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// sandboxImagesFromNode returns the sandbox images found in the local store of a node, without the proxy prefix of
+// images already pulled through the proxy
+func (r *SandboxImageReconciler) sandboxImagesFromNode(node *corev1.Node) []string {
+	sandboxImages := r.SandboxImages
+	if sandboxImages == nil {
+		sandboxImages = DefaultSandboxImages
+	}
+
+	images := []string{}
+	for _, image := range node.Status.Images {
+		for _, imageName := range image.Names {
+			sourceImage := registry.ProxiedImage(imageName)
+			if sandboxImages.MatchString(sourceImage) && !slices.Contains(images, sourceImage) {
+				images = append(images, sourceImage)
+			}
+		}
+	}
+
+	return images
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *SandboxImageReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("sandbox-image").
+		For(&corev1.Node{}, builder.WithPredicates(predicate.Funcs{
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				oldImages := r.sandboxImagesFromNode(e.ObjectOld.(*corev1.Node))
+				newImages := r.sandboxImagesFromNode(e.ObjectNew.(*corev1.Node))
+				return !slices.Equal(oldImages, newImages)
+			},
+			DeleteFunc: func(e event.DeleteEvent) bool {
+				return false
+			},
+		})).
+		Complete(r)
+}
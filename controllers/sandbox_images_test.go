@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSandboxImagesFromNode(t *testing.T) {
+	g := NewWithT(t)
+
+	node := &corev1.Node{
+		Status: corev1.NodeStatus{
+			Images: []corev1.ContainerImage{
+				{Names: []string{"registry.k8s.io/pause:3.9", "registry.k8s.io/pause@sha256:7031c1b283388d2c2e09b57badb803c05ebed362dc88d84b480cc47f72a21097"}},
+				{Names: []string{"localhost:7439/registry.k8s.io/pause:3.9"}},
+				{Names: []string{"mcr.microsoft.com/oss/kubernetes/pause:3.6"}},
+				{Names: []string{"docker.io/library/nginx:1.25"}},
+				{Names: []string{"docker.io/library/pause-exporter:1.0"}},
+			},
+		},
+	}
+
+	reconciler := &SandboxImageReconciler{}
+	g.Expect(reconciler.sandboxImagesFromNode(node)).To(Equal([]string{
+		"registry.k8s.io/pause:3.9",
+		"mcr.microsoft.com/oss/kubernetes/pause:3.6",
+	}))
+}
+
+func TestSandboxImageReconcile(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: corev1.NodeStatus{
+			Images: []corev1.ContainerImage{
+				{Names: []string{"registry.k8s.io/pause:3.9"}},
+				{Names: []string{"rancher/mirrored-pause:3.6"}},
+			},
+		},
+	}
+	alreadyCached := &kuikv1alpha1.CachedImage{
+		ObjectMeta: metav1.ObjectMeta{Name: "docker.io-rancher-mirrored-pause-3.6"},
+		Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: "rancher/mirrored-pause:3.6"},
+	}
+
+	reconciler := &SandboxImageReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(node, alreadyCached).Build(),
+	}
+	_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "node-1"}})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	for _, name := range []string{"registry.k8s.io-pause-3.9", "docker.io-rancher-mirrored-pause-3.6"} {
+		var cachedImage kuikv1alpha1.CachedImage
+		g.Expect(reconciler.Get(ctx, types.NamespacedName{Name: name}, &cachedImage)).To(Succeed())
+		g.Expect(cachedImage.Spec.Retain).To(BeTrue())
+		g.Expect(cachedImage.Labels).To(HaveKeyWithValue(LabelSandboxImageName, "true"))
+	}
+
+	// Deleted nodes are ignored
+	_, err = reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "node-2"}})
+	g.Expect(err).ToNot(HaveOccurred())
+}
@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/distribution/reference"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/enix/kube-image-keeper/internal/registry"
+)
+
+// scaledWorkloadsResyncPeriod is how often unused CachedImages of scaled workloads are checked again, since CachedImages
+// are not reconciled when autoscalers or workloads change
+const scaledWorkloadsResyncPeriod = 10 * time.Minute
+
+// ScaledWorkloads finds the workloads scaled by a HorizontalPodAutoscaler, including the ones scaled by KEDA which
+// manages a HorizontalPodAutoscaler for each of its ScaledObjects. Such workloads may have few pods or none at all, e.g.
+// when KEDA scales them to zero, but can scale up to the max replicas of their autoscaler at any time: their images are
+// considered in use so that they don't expire.
+type ScaledWorkloads struct {
+	client.Reader
+}
+
+// NewScaledWorkloads returns a ScaledWorkloads, or nil if disabled
+func NewScaledWorkloads(reader client.Reader, enabled bool) *ScaledWorkloads {
+	if !enabled {
+		return nil
+	}
+
+	return &ScaledWorkloads{Reader: reader}
+}
+
+// Using returns the workloads that can be scaled up by an autoscaler and whose pod template uses an image, as
+// <namespace>/<kind>/<name>
+func (s *ScaledWorkloads) Using(ctx context.Context, sourceImage string) ([]string, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	image, err := normalizeImage(sourceImage)
+	if err != nil {
+		return nil, err
+	}
+
+	var autoscalers autoscalingv2.HorizontalPodAutoscalerList
+	if err := s.List(ctx, &autoscalers); err != nil {
+		return nil, err
+	}
+
+	workloads := []string{}
+	for _, autoscaler := range autoscalers.Items {
+		if autoscaler.Spec.MaxReplicas <= 0 {
+			continue
+		}
+
+		target := autoscaler.Spec.ScaleTargetRef
+		template, err := s.podTemplate(ctx, autoscaler.Namespace, target)
+		if err != nil {
+			return nil, err
+		}
+		if template != nil && podTemplateUses(template, image) {
+			workloads = append(workloads, fmt.Sprintf("%s/%s/%s", autoscaler.Namespace, strings.ToLower(target.Kind), target.Name))
+		}
+	}
+
+	return workloads, nil
+}
+
+// podTemplate returns the pod template of the workload scaled by an autoscaler, or nil if it is not a Deployment nor a
+// StatefulSet or if it doesn't exist
+func (s *ScaledWorkloads) podTemplate(ctx context.Context, namespace string, target autoscalingv2.CrossVersionObjectReference) (*corev1.PodTemplateSpec, error) {
+	if !strings.HasPrefix(target.APIVersion, appsv1.GroupName+"/") {
+		return nil, nil
+	}
+
+	key := types.NamespacedName{Namespace: namespace, Name: target.Name}
+	var err error
+	var template *corev1.PodTemplateSpec
+	switch target.Kind {
+	case "Deployment":
+		deployment := &appsv1.Deployment{}
+		err = s.Get(ctx, key, deployment)
+		template = &deployment.Spec.Template
+	case "StatefulSet":
+		statefulSet := &appsv1.StatefulSet{}
+		err = s.Get(ctx, key, statefulSet)
+		template = &statefulSet.Spec.Template
+	default:
+		return nil, nil
+	}
+
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return template, nil
+}
+
+// podTemplateUses tells whether a container or an init container of a pod template uses a normalized image
+func podTemplateUses(template *corev1.PodTemplateSpec, image string) bool {
+	for _, containers := range [][]corev1.Container{template.Spec.InitContainers, template.Spec.Containers} {
+		for _, container := range containers {
+			if containerImage, err := normalizeImage(container.Image); err == nil && containerImage == image {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// normalizeImage returns the fully qualified name of an image, with the latest tag if it has neither a tag nor a digest,
+// so that images can be compared whatever the way they are referenced
+func normalizeImage(image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(registry.TrimDigestedTag(image))
+	if err != nil {
+		return "", err
+	}
+
+	return reference.TagNameOnly(named).String(), nil
+}
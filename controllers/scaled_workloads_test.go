@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestScaledWorkloads_Using(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	podTemplate := func(images ...string) corev1.PodTemplateSpec {
+		template := corev1.PodTemplateSpec{}
+		for _, image := range images {
+			template.Spec.Containers = append(template.Spec.Containers, corev1.Container{Name: "app", Image: image})
+		}
+		return template
+	}
+	autoscaler := func(name string, kind string, maxReplicas int32) *autoscalingv2.HorizontalPodAutoscaler {
+		return &autoscalingv2.HorizontalPodAutoscaler{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+				ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: kind, Name: name},
+				MaxReplicas:    maxReplicas,
+			},
+		}
+	}
+
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Spec:       appsv1.DeploymentSpec{Template: podTemplate("nginx:1.25", "busybox")},
+		},
+		autoscaler("web", "Deployment", 10),
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+			Spec:       appsv1.StatefulSetSpec{Template: podTemplate("docker.io/library/postgres:16")},
+		},
+		autoscaler("db", "StatefulSet", 3),
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "unscaled", Namespace: "default"},
+			Spec:       appsv1.DeploymentSpec{Template: podTemplate("redis")},
+		},
+		// The workload of an autoscaler may not exist yet
+		autoscaler("missing", "Deployment", 5),
+	).Build()
+
+	scaledWorkloads := NewScaledWorkloads(k8sClient, true)
+
+	g.Expect(scaledWorkloads.Using(ctx, "docker.io/library/nginx:1.25")).To(Equal([]string{"default/deployment/web"}))
+	g.Expect(scaledWorkloads.Using(ctx, "busybox:latest")).To(Equal([]string{"default/deployment/web"}))
+	g.Expect(scaledWorkloads.Using(ctx, "postgres:16")).To(Equal([]string{"default/statefulset/db"}))
+	g.Expect(scaledWorkloads.Using(ctx, "nginx:1.24")).To(BeEmpty())
+	g.Expect(scaledWorkloads.Using(ctx, "redis")).To(BeEmpty())
+
+	// Scaled workloads are ignored when disabled
+	g.Expect(NewScaledWorkloads(k8sClient, false)).To(BeNil())
+	g.Expect((*ScaledWorkloads)(nil).Using(ctx, "nginx:1.25")).To(BeNil())
+}
@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/distribution/reference"
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+)
+
+// DefaultImmutableTags matches full versions (e.g. "1.25.3", "v2.48.0" or "1.25.3-alpine"), which are usually not
+// pushed again, contrary to tags like "latest" or "1.25".
+var DefaultImmutableTags = regexp.MustCompile(`^v?[0-9]+\.[0-9]+\.[0-9]+([-+._][0-9A-Za-z.-]+)?$`)
+
+// isMutable tells whether the image of a CachedImage may change upstream, i.e. when it is not referenced by digest and
+// its tag doesn't match immutableTags. Images with an invalid name are considered mutable.
+func isMutable(cachedImage *kuikv1alpha1.CachedImage, immutableTags *regexp.Regexp) bool {
+	ref, err := reference.ParseNormalizedNamed(cachedImage.Spec.SourceImage)
+	if err != nil {
+		return true
+	}
+
+	if _, ok := ref.(reference.Digested); ok {
+		return false
+	}
+
+	tag := "latest"
+	if tagged, ok := ref.(reference.Tagged); ok {
+		tag = tagged.Tag()
+	}
+
+	if immutableTags == nil {
+		immutableTags = DefaultImmutableTags
+	}
+
+	return !immutableTags.MatchString(tag)
+}
+
+// expiryDelay returns the delay before an unused CachedImage expires, depending on whether its tag is mutable
+func (r *CachedImageReconciler) expiryDelay(cachedImage *kuikv1alpha1.CachedImage) time.Duration {
+	if isMutable(cachedImage, r.ImmutableTags) {
+		if r.MutableTagsExpiryDelay > 0 {
+			return r.MutableTagsExpiryDelay
+		}
+	} else if r.ImmutableTagsExpiryDelay > 0 {
+		return r.ImmutableTagsExpiryDelay
+	}
+
+	return r.ExpiryDelay
+}
+
+// usageAwareExpiry returns the expiry date of an unused CachedImage, postponed to the expiry delay after the image has
+// last been used when it comes later than expiresAt.
+func (r *CachedImageReconciler) usageAwareExpiry(cachedImage *kuikv1alpha1.CachedImage, expiresAt time.Time) time.Time {
+	if cachedImage.Status.LastUsedAt == nil {
+		return expiresAt
+	}
+
+	postponed := cachedImage.Status.LastUsedAt.Add(r.expiryDelay(cachedImage))
+	if postponed.After(expiresAt) {
+		return postponed
+	}
+
+	return expiresAt
+}
+
+// refreshIn returns how long until a cached image with a mutable tag must be pulled again from upstream, and false if
+// it is never refreshed. Images cached before their last pull was recorded are refreshed an interval after the creation
+// of their CachedImage, so that they are not all pulled again at once when refreshes are enabled.
+func (r *CachedImageReconciler) refreshIn(cachedImage *kuikv1alpha1.CachedImage, now time.Time) (time.Duration, bool) {
+	if r.MutableTagsRefreshInterval <= 0 || !isMutable(cachedImage, r.ImmutableTags) {
+		return 0, false
+	}
+
+	refreshedAt := cachedImage.CreationTimestamp
+	if cachedImage.Status.RefreshedAt != nil {
+		refreshedAt = *cachedImage.Status.RefreshedAt
+	}
+
+	return refreshedAt.Add(r.MutableTagsRefreshInterval).Sub(now), true
+}
+
+// resyncInterval returns how often the upstream digest of a cached image with a mutable tag is checked, the one of its
+// spec overriding MutableTagsResyncInterval
+func (r *CachedImageReconciler) resyncInterval(cachedImage *kuikv1alpha1.CachedImage) time.Duration {
+	if cachedImage.Spec.ResyncInterval != nil {
+		return cachedImage.Spec.ResyncInterval.Duration
+	}
+	return r.MutableTagsResyncInterval
+}
+
+// resyncIn returns how long until the upstream digest of a cached image with a mutable tag must be checked again, and
+// false if it is never resynced. Pulling the image from upstream counts as a resync.
+func (r *CachedImageReconciler) resyncIn(cachedImage *kuikv1alpha1.CachedImage, now time.Time) (time.Duration, bool) {
+	interval := r.resyncInterval(cachedImage)
+	if interval <= 0 || !isMutable(cachedImage, r.ImmutableTags) {
+		return 0, false
+	}
+
+	syncedAt := cachedImage.Status.RefreshedAt
+	if resyncedAt := cachedImage.Status.ResyncedAt; resyncedAt != nil && (syncedAt == nil || resyncedAt.After(syncedAt.Time)) {
+		syncedAt = resyncedAt
+	}
+	if syncedAt == nil {
+		return 0, true
+	}
+
+	return syncedAt.Add(interval).Sub(now), true
+}
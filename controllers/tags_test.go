@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIsMutable(t *testing.T) {
+	tests := []struct {
+		sourceImage   string
+		immutableTags *regexp.Regexp
+		expected      bool
+	}{
+		{sourceImage: "nginx", expected: true},
+		{sourceImage: "nginx:latest", expected: true},
+		{sourceImage: "nginx:1.25", expected: true},
+		{sourceImage: "nginx:1.25.3", expected: false},
+		{sourceImage: "nginx:1.25.3-alpine", expected: false},
+		{sourceImage: "quay.io/prometheus/prometheus:v2.48.0", expected: false},
+		{sourceImage: "localhost:5000/app:1.0.0", expected: false},
+		{sourceImage: "nginx@sha256:4c0fdaa8b6341bfdeca5f18f7837462c80cff90527ee35ef185571e1c327beac", expected: false},
+		{sourceImage: "nginx:stable", immutableTags: regexp.MustCompile(`^stable$`), expected: false},
+		{sourceImage: "nginx:1.25.3", immutableTags: regexp.MustCompile(`^stable$`), expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sourceImage, func(t *testing.T) {
+			g := NewWithT(t)
+			cachedImage := &kuikv1alpha1.CachedImage{Spec: kuikv1alpha1.CachedImageSpec{SourceImage: tt.sourceImage}}
+			g.Expect(isMutable(cachedImage, tt.immutableTags)).To(Equal(tt.expected))
+		})
+	}
+}
+
+func TestExpiryDelay(t *testing.T) {
+	g := NewWithT(t)
+
+	mutable := &kuikv1alpha1.CachedImage{Spec: kuikv1alpha1.CachedImageSpec{SourceImage: "nginx:latest"}}
+	immutable := &kuikv1alpha1.CachedImage{Spec: kuikv1alpha1.CachedImageSpec{SourceImage: "nginx:1.25.3"}}
+
+	r := &CachedImageReconciler{ExpiryDelay: 30 * 24 * time.Hour}
+	g.Expect(r.expiryDelay(mutable)).To(Equal(30 * 24 * time.Hour))
+	g.Expect(r.expiryDelay(immutable)).To(Equal(30 * 24 * time.Hour))
+
+	r.MutableTagsExpiryDelay = 72 * time.Hour
+	r.ImmutableTagsExpiryDelay = 90 * 24 * time.Hour
+	g.Expect(r.expiryDelay(mutable)).To(Equal(72 * time.Hour))
+	g.Expect(r.expiryDelay(immutable)).To(Equal(90 * 24 * time.Hour))
+}
+
+func TestUsageAwareExpiry(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Now()
+	expiresAt := now.Add(24 * time.Hour)
+	r := &CachedImageReconciler{ExpiryDelay: 48 * time.Hour}
+
+	cachedImage := &kuikv1alpha1.CachedImage{Spec: kuikv1alpha1.CachedImageSpec{SourceImage: "nginx:1.25.3"}}
+	g.Expect(r.usageAwareExpiry(cachedImage, expiresAt)).To(Equal(expiresAt))
+
+	lastUsedAt := metav1.NewTime(now.Add(-time.Hour))
+	cachedImage.Status.LastUsedAt = &lastUsedAt
+	g.Expect(r.usageAwareExpiry(cachedImage, expiresAt)).To(Equal(lastUsedAt.Add(48 * time.Hour)))
+
+	lastUsedAt = metav1.NewTime(now.Add(-47 * time.Hour))
+	g.Expect(r.usageAwareExpiry(cachedImage, expiresAt)).To(Equal(expiresAt))
+}
+
+func TestRefreshIn(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Now()
+	refreshedAt := metav1.NewTime(now.Add(-time.Hour))
+	mutable := &kuikv1alpha1.CachedImage{
+		Spec:   kuikv1alpha1.CachedImageSpec{SourceImage: "nginx:latest"},
+		Status: kuikv1alpha1.CachedImageStatus{RefreshedAt: &refreshedAt},
+	}
+	immutable := &kuikv1alpha1.CachedImage{Spec: kuikv1alpha1.CachedImageSpec{SourceImage: "nginx:1.25.3"}}
+
+	r := &CachedImageReconciler{}
+	_, ok := r.refreshIn(mutable, now)
+	g.Expect(ok).To(BeFalse())
+
+	r.MutableTagsRefreshInterval = 6 * time.Hour
+	refreshIn, ok := r.refreshIn(mutable, now)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(refreshIn).To(Equal(5 * time.Hour))
+
+	// Images whose last pull hasn't been recorded are refreshed an interval after their CachedImage was created
+	mutable.Status.RefreshedAt = nil
+	mutable.CreationTimestamp = metav1.NewTime(now.Add(-2 * time.Hour))
+	refreshIn, ok = r.refreshIn(mutable, now)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(refreshIn).To(Equal(4 * time.Hour))
+
+	_, ok = r.refreshIn(immutable, now)
+	g.Expect(ok).To(BeFalse())
+}
+
+func TestResyncIn(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Now()
+	refreshedAt := metav1.NewTime(now.Add(-2 * time.Hour))
+	resyncedAt := metav1.NewTime(now.Add(-30 * time.Minute))
+	mutable := &kuikv1alpha1.CachedImage{
+		Spec:   kuikv1alpha1.CachedImageSpec{SourceImage: "nginx:latest"},
+		Status: kuikv1alpha1.CachedImageStatus{RefreshedAt: &refreshedAt},
+	}
+	immutable := &kuikv1alpha1.CachedImage{Spec: kuikv1alpha1.CachedImageSpec{SourceImage: "nginx:1.25.3"}}
+
+	r := &CachedImageReconciler{}
+	_, ok := r.resyncIn(mutable, now)
+	g.Expect(ok).To(BeFalse())
+
+	r.MutableTagsResyncInterval = 3 * time.Hour
+	resyncIn, ok := r.resyncIn(mutable, now)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(resyncIn).To(Equal(time.Hour))
+
+	// The last resync counts when it is more recent than the last pull
+	mutable.Status.ResyncedAt = &resyncedAt
+	resyncIn, _ = r.resyncIn(mutable, now)
+	g.Expect(resyncIn).To(Equal(150 * time.Minute))
+
+	// The interval of the CachedImage overrides the one of the controllers
+	mutable.Spec.ResyncInterval = &metav1.Duration{Duration: time.Hour}
+	resyncIn, _ = r.resyncIn(mutable, now)
+	g.Expect(resyncIn).To(Equal(30 * time.Minute))
+	mutable.Spec.ResyncInterval = &metav1.Duration{}
+	_, ok = r.resyncIn(mutable, now)
+	g.Expect(ok).To(BeFalse())
+
+	_, ok = r.resyncIn(immutable, now)
+	g.Expect(ok).To(BeFalse())
+}
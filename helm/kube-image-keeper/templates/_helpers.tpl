@@ -109,6 +109,71 @@ Create the name of the service account to use
 {{- default (printf "%s-%s" (include "kube-image-keeper.fullname" .) "controllers") .Values.serviceAccount.name }}
 {{- end }}
 
+{{/*
+Host the proxy binds to in hostNetwork mode, IPv6 addresses are put between brackets
+*/}}
+{{- define "kube-image-keeper.proxy-bind-host" -}}
+{{- if contains ":" .Values.proxy.hostIp }}
+{{- printf "[%s]" (trimAll "[]" .Values.proxy.hostIp) }}
+{{- else }}
+{{- .Values.proxy.hostIp }}
+{{- end }}
+{{- end }}
+
 {{- define "kube-image-keeper.registry-stateless-mode" -}}
-{{- ternary "true" "false" (or .Values.minio.enabled (not (empty .Values.registry.persistence.s3))) }}
+{{- ternary "true" "false" (or .Values.minio.enabled (not (empty .Values.registry.persistence.s3)) (not (empty .Values.registry.persistence.azure)) (not (empty .Values.registry.persistence.gcs))) }}
+{{- end }}
+
+{{/*
+Environment variables of the registry serving its API with mutual TLS, and its readiness probe, since the kubelet has no
+client certificate
+*/}}
+{{- define "kube-image-keeper.registry-tls-env" -}}
+{{- if .Values.registry.tls.enabled }}
+- name: REGISTRY_HTTP_TLS_CERTIFICATE
+  value: /etc/kuik/registry-tls/tls.crt
+- name: REGISTRY_HTTP_TLS_KEY
+  value: /etc/kuik/registry-tls/tls.key
+- name: REGISTRY_HTTP_TLS_CLIENTCAS_0
+  value: /etc/kuik/registry-tls/ca.crt
+{{- end }}
+{{- end }}
+
+{{- define "kube-image-keeper.registry-readiness-probe" -}}
+{{- if .Values.registry.tls.enabled }}
+tcpSocket:
+  port: 5000
+{{- else }}
+{{- toYaml .Values.registry.readinessProbe }}
+{{- end }}
+{{- end }}
+
+{{/*
+Storage backend of the registry, as reported by the admin API of the controllers
+*/}}
+{{- define "kube-image-keeper.registry-storage" -}}
+{{- if .Values.minio.enabled }}
+{{- "minio" }}
+{{- else if .Values.registry.persistence.s3 }}
+{{- "s3" }}
+{{- else if .Values.registry.persistence.azure }}
+{{- "azure" }}
+{{- else if .Values.registry.persistence.gcs }}
+{{- "gcs" }}
+{{- else if .Values.registry.persistence.enabled }}
+{{- "persistent-volume" }}
+{{- else }}
+{{- "ephemeral" }}
+{{- end }}
+{{- end }}
+
+{{/*
+Feature gates as a comma separated list of feature=bool pairs
+*/}}
+{{- define "kube-image-keeper.feature-gates" -}}
+{{- $gates := list }}
+{{- range $feature, $enabled := . }}
+{{- $gates = append $gates (printf "%s=%t" $feature $enabled) }}
+{{- end }}
+{{- join "," $gates }}
 {{- end }}
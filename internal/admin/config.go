@@ -0,0 +1,99 @@
+package admin
+
+import (
+	"flag"
+	"net/http"
+	"sort"
+
+	"k8s.io/utils/strings/slices"
+
+	"github.com/enix/kube-image-keeper/internal/featuregate"
+	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/gin-gonic/gin"
+)
+
+// redactedValue replaces the values of the redacted flags that are set
+const redactedValue = "<redacted>"
+
+// redactedFlags are the flags whose value is not exported, since they may embed credentials, e.g. the URL of the scanner
+// webhook, or tell where secrets are mounted
+var redactedFlags = []string{
+	"kubeconfig",
+	"pull-token-issuers-htpasswd",
+	"pull-token-key",
+	"registry-tls-dir",
+	"scan-webhook-url",
+	"signature-policy",
+	"single-port-htpasswd",
+	"snapshot-signing-key",
+}
+
+// RuntimeConfig is the effective configuration of the controllers, so that support tooling can introspect a deployment
+// without reading the flags from the spec of its pods
+type RuntimeConfig struct {
+	// Flags are the values of every command line flag, defaults included, the values of redacted flags being replaced
+	// by <redacted> when they are set
+	Flags        map[string]string            `json:"flags"`
+	FeatureGates map[featuregate.Feature]bool `json:"featureGates"`
+	Registry     RegistryConfig               `json:"registry"`
+	// Capabilities are the optional features of the admin API that are enabled, e.g. pull-tokens
+	Capabilities []string `json:"capabilities"`
+}
+
+type RegistryConfig struct {
+	Endpoint string `json:"endpoint"`
+	// Storage is the storage backend of the registry, e.g. s3 or persistent-volume
+	Storage string `json:"storage,omitempty"`
+}
+
+// NewRuntimeConfig reads the effective configuration of the controllers from their parsed flags and feature gates
+func NewRuntimeConfig(flags *flag.FlagSet, gates *featuregate.FeatureGate, registryStorage string) *RuntimeConfig {
+	config := &RuntimeConfig{
+		Flags:        map[string]string{},
+		FeatureGates: gates.Features(),
+		Registry: RegistryConfig{
+			Endpoint: registry.Protocol + registry.Endpoint,
+			Storage:  registryStorage,
+		},
+	}
+	flags.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if value != "" && slices.Contains(redactedFlags, f.Name) {
+			value = redactedValue
+		}
+		config.Flags[f.Name] = value
+	})
+
+	return config
+}
+
+// WithRuntimeConfig enables the export of the effective configuration of the controllers
+func (s *Server) WithRuntimeConfig(config *RuntimeConfig) *Server {
+	s.config = config
+	return s
+}
+
+// capabilities lists the optional features of the admin API that are enabled
+func (s *Server) capabilities() []string {
+	capabilities := []string{}
+	if s.pullTokens != nil {
+		capabilities = append(capabilities, "pull-tokens")
+	}
+	if s.snapshots != nil {
+		capabilities = append(capabilities, "snapshots")
+	}
+	sort.Strings(capabilities)
+	return capabilities
+}
+
+// exportConfig returns the effective configuration of the controllers along with the capabilities of the admin API
+func (s *Server) exportConfig(c *gin.Context) {
+	if s.config == nil {
+		c.String(http.StatusNotFound, "runtime configuration is not available")
+		return
+	}
+
+	config := *s.config
+	config.Capabilities = s.capabilities()
+	c.JSON(http.StatusOK, config)
+}
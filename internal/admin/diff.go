@@ -0,0 +1,189 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/gin-gonic/gin"
+)
+
+type Layer struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+}
+
+// ConfigChange is a field of the config of an image whose value differs between two images, e.g. env.NGINX_VERSION,
+// From or To being empty when the field is missing from one of them
+type ConfigChange struct {
+	Field string `json:"field"`
+	From  string `json:"from,omitempty"`
+	To    string `json:"to,omitempty"`
+}
+
+type PlatformDiff struct {
+	Platform      string         `json:"platform"`
+	AddedLayers   []Layer        `json:"addedLayers"`
+	RemovedLayers []Layer        `json:"removedLayers"`
+	SizeDelta     int64          `json:"sizeDelta"`
+	ConfigChanges []ConfigChange `json:"configChanges"`
+}
+
+type ImageDiff struct {
+	From      string         `json:"from"`
+	To        string         `json:"to"`
+	Platforms []PlatformDiff `json:"platforms"`
+	// MissingPlatforms are the platforms that only one of the images has, which can't be compared
+	MissingPlatforms []string `json:"missingPlatforms,omitempty"`
+}
+
+// imageDiff compares the layers and the configs of the platforms two images have in common, only the platform matching
+// platform if it is not empty, e.g. linux/arm64
+func imageDiff(from *kuikv1alpha1.CachedImage, fromConfigs []registry.ImageConfig, to *kuikv1alpha1.CachedImage, toConfigs []registry.ImageConfig, platform string) *ImageDiff {
+	diff := &ImageDiff{
+		From:      from.Spec.SourceImage,
+		To:        to.Spec.SourceImage,
+		Platforms: []PlatformDiff{},
+	}
+
+	toByPlatform := map[string]registry.ImageConfig{}
+	for _, toConfig := range toConfigs {
+		toByPlatform[toConfig.Config.Platform().String()] = toConfig
+	}
+
+	for _, fromConfig := range fromConfigs {
+		name := fromConfig.Config.Platform().String()
+		toConfig, ok := toByPlatform[name]
+		delete(toByPlatform, name)
+		if platform != "" && name != platform {
+			continue
+		}
+		if !ok {
+			diff.MissingPlatforms = append(diff.MissingPlatforms, name)
+			continue
+		}
+		diff.Platforms = append(diff.Platforms, platformDiff(name, fromConfig, toConfig))
+	}
+	for name := range toByPlatform {
+		if platform == "" || name == platform {
+			diff.MissingPlatforms = append(diff.MissingPlatforms, name)
+		}
+	}
+	sort.Strings(diff.MissingPlatforms)
+
+	return diff
+}
+
+func platformDiff(platform string, from registry.ImageConfig, to registry.ImageConfig) PlatformDiff {
+	diff := PlatformDiff{
+		Platform:      platform,
+		AddedLayers:   []Layer{},
+		RemovedLayers: []Layer{},
+		ConfigChanges: []ConfigChange{},
+	}
+
+	fromLayers := map[string]bool{}
+	for _, layer := range from.Layers {
+		fromLayers[layer.Digest.String()] = true
+		diff.SizeDelta -= layer.Size
+	}
+	toLayers := map[string]bool{}
+	for _, layer := range to.Layers {
+		toLayers[layer.Digest.String()] = true
+		diff.SizeDelta += layer.Size
+		if !fromLayers[layer.Digest.String()] {
+			diff.AddedLayers = append(diff.AddedLayers, Layer{Digest: layer.Digest.String(), Size: layer.Size})
+		}
+	}
+	for _, layer := range from.Layers {
+		if !toLayers[layer.Digest.String()] {
+			diff.RemovedLayers = append(diff.RemovedLayers, Layer{Digest: layer.Digest.String(), Size: layer.Size})
+		}
+	}
+
+	diff.ConfigChanges = configChanges(configFields(from), configFields(to))
+
+	return diff
+}
+
+// configFields flattens the config of an image into fields that can be compared one by one
+func configFields(imageConfig registry.ImageConfig) map[string]string {
+	config := imageConfig.Config.Config
+	fields := map[string]string{
+		"user":       config.User,
+		"workingDir": config.WorkingDir,
+	}
+	if !imageConfig.Config.Created.IsZero() {
+		fields["created"] = imageConfig.Config.Created.UTC().Format(time.RFC3339)
+	}
+	if len(config.Entrypoint) > 0 {
+		entrypoint, _ := json.Marshal(config.Entrypoint)
+		fields["entrypoint"] = string(entrypoint)
+	}
+	if len(config.Cmd) > 0 {
+		cmd, _ := json.Marshal(config.Cmd)
+		fields["cmd"] = string(cmd)
+	}
+	for _, env := range config.Env {
+		name, value, _ := strings.Cut(env, "=")
+		fields["env."+name] = value
+	}
+	for name, value := range config.Labels {
+		fields["labels."+name] = value
+	}
+	for port := range config.ExposedPorts {
+		fields["exposedPorts."+port] = "exposed"
+	}
+
+	return fields
+}
+
+func configChanges(from map[string]string, to map[string]string) []ConfigChange {
+	changes := []ConfigChange{}
+	for field, fromValue := range from {
+		if toValue := to[field]; toValue != fromValue {
+			changes = append(changes, ConfigChange{Field: field, From: fromValue, To: toValue})
+		}
+	}
+	for field, toValue := range to {
+		if _, ok := from[field]; !ok && toValue != "" {
+			changes = append(changes, ConfigChange{Field: field, To: toValue})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+
+	return changes
+}
+
+// exportImageDiff reports the layers added and removed, the size delta and the config changes from a cached image to
+// another one given by the ?to query parameter, for every platform they have in common or for the ?platform one, e.g. to
+// understand why a new tag is much larger before rolling it out
+func (s *Server) exportImageDiff(c *gin.Context) {
+	if c.Query("to") == "" {
+		c.String(http.StatusBadRequest, "the CachedImage to compare to must be given with the to query parameter")
+		return
+	}
+
+	from, fromConfigs := s.cachedImageConfigs(c, c.Param("name"))
+	if from == nil {
+		return
+	}
+	to, toConfigs := s.cachedImageConfigs(c, c.Query("to"))
+	if to == nil {
+		return
+	}
+
+	diff := imageDiff(from, fromConfigs, to, toConfigs, c.Query("platform"))
+	if len(diff.Platforms) == 0 {
+		c.String(http.StatusNotFound, "images %s and %s have no platform in common", from.Spec.SourceImage, to.Spec.SourceImage)
+		return
+	}
+
+	c.JSON(http.StatusOK, diff)
+}
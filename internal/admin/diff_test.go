@@ -0,0 +1,94 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	. "github.com/onsi/gomega"
+)
+
+func imageConfig(architecture string, env []string, layers ...v1.Descriptor) registry.ImageConfig {
+	return registry.ImageConfig{
+		Digest: hash(architecture),
+		Config: &v1.ConfigFile{
+			OS:           "linux",
+			Architecture: architecture,
+			Config: v1.Config{
+				Cmd: []string{"nginx", "-g", "daemon off;"},
+				Env: env,
+			},
+		},
+		Layers: layers,
+	}
+}
+
+func Test_imageDiff(t *testing.T) {
+	g := NewWithT(t)
+
+	base := v1.Descriptor{Digest: hash("base"), Size: 100}
+	nginx := v1.Descriptor{Digest: hash("nginx"), Size: 50}
+	newNginx := v1.Descriptor{Digest: hash("new-nginx"), Size: 80}
+	from := &kuikv1alpha1.CachedImage{Spec: kuikv1alpha1.CachedImageSpec{SourceImage: "nginx:1.25"}}
+	to := &kuikv1alpha1.CachedImage{Spec: kuikv1alpha1.CachedImageSpec{SourceImage: "nginx:1.26"}}
+	fromConfigs := []registry.ImageConfig{
+		imageConfig("amd64", []string{"NGINX_VERSION=1.25.3", "PKG_RELEASE=1"}, base, nginx),
+		imageConfig("s390x", nil, base),
+	}
+	toConfigs := []registry.ImageConfig{
+		imageConfig("amd64", []string{"NGINX_VERSION=1.26.0", "NJS_VERSION=0.8.4"}, base, newNginx),
+		imageConfig("arm64", nil, base),
+	}
+
+	diff := imageDiff(from, fromConfigs, to, toConfigs, "")
+	g.Expect(diff.From).To(Equal("nginx:1.25"))
+	g.Expect(diff.To).To(Equal("nginx:1.26"))
+	g.Expect(diff.MissingPlatforms).To(Equal([]string{"linux/arm64", "linux/s390x"}))
+	g.Expect(diff.Platforms).To(Equal([]PlatformDiff{{
+		Platform:      "linux/amd64",
+		AddedLayers:   []Layer{{Digest: "sha256:new-nginx", Size: 80}},
+		RemovedLayers: []Layer{{Digest: "sha256:nginx", Size: 50}},
+		SizeDelta:     30,
+		ConfigChanges: []ConfigChange{
+			{Field: "env.NGINX_VERSION", From: "1.25.3", To: "1.26.0"},
+			{Field: "env.NJS_VERSION", To: "0.8.4"},
+			{Field: "env.PKG_RELEASE", From: "1"},
+		},
+	}}))
+
+	diff = imageDiff(from, fromConfigs, to, toConfigs, "linux/arm64")
+	g.Expect(diff.Platforms).To(BeEmpty())
+	g.Expect(diff.MissingPlatforms).To(Equal([]string{"linux/arm64"}))
+}
+
+func Test_exportImageDiff(t *testing.T) {
+	g := NewWithT(t)
+	server := newTestServer()
+	server.imageConfigs = func(image string) ([]registry.ImageConfig, error) {
+		return []registry.ImageConfig{imageConfig("amd64", nil, v1.Descriptor{Digest: hash("base"), Size: 100})}, nil
+	}
+
+	recorder := httptest.NewRecorder()
+	server.engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/images/docker.io-library-nginx-1.25/diff?to=docker.io-library-nginx-1.25", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusOK))
+
+	diff := ImageDiff{}
+	g.Expect(json.Unmarshal(recorder.Body.Bytes(), &diff)).To(Succeed())
+	g.Expect(diff.Platforms).To(HaveLen(1))
+	g.Expect(diff.Platforms[0].AddedLayers).To(BeEmpty())
+	g.Expect(diff.Platforms[0].SizeDelta).To(BeZero())
+	g.Expect(diff.Platforms[0].ConfigChanges).To(BeEmpty())
+
+	recorder = httptest.NewRecorder()
+	server.engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/images/docker.io-library-nginx-1.25/diff", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+
+	// Images that are not cached yet can't be compared
+	recorder = httptest.NewRecorder()
+	server.engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/images/docker.io-library-nginx-1.25/diff?to=docker.io-library-alpine-latest", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusNotFound))
+}
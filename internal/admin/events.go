@@ -0,0 +1,57 @@
+package admin
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/enix/kube-image-keeper/internal/events"
+	"github.com/gin-gonic/gin"
+)
+
+// eventsHeartbeatInterval is how often a comment is sent on idle event streams to keep them open through proxies
+var eventsHeartbeatInterval = 30 * time.Second
+
+// streamEvents streams cache lifecycle events as server-sent events with JSON data, optionally filtered by type with
+// ?type=cached,failed
+func (s *Server) streamEvents(c *gin.Context) {
+	types := map[events.Type]bool{}
+	if filter := c.Query("type"); filter != "" {
+		for _, str := range strings.Split(filter, ",") {
+			eventType := events.Type(strings.TrimSpace(str))
+			if !eventType.IsValid() {
+				c.String(http.StatusBadRequest, "unsupported event type %q, use cached, served, expired or failed", eventType)
+				return
+			}
+			types[eventType] = true
+		}
+	}
+
+	subscription, unsubscribe := s.events.Subscribe()
+	defer unsubscribe()
+
+	heartbeat := time.NewTicker(eventsHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	// Send headers right away so that clients know they are subscribed
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Writer.WriteHeaderNow()
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-heartbeat.C:
+			_, err := io.WriteString(w, ": heartbeat\n\n")
+			return err == nil
+		case event := <-subscription:
+			if len(types) == 0 || types[event.Type] {
+				c.SSEvent(string(event.Type), event)
+			}
+			return true
+		}
+	})
+}
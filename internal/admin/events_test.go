@@ -0,0 +1,48 @@
+package admin
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/enix/kube-image-keeper/internal/events"
+	. "github.com/onsi/gomega"
+)
+
+func Test_streamEvents(t *testing.T) {
+	g := NewWithT(t)
+	server := newTestServer()
+	httpServer := httptest.NewServer(server.engine)
+	defer httpServer.Close()
+
+	response, err := http.Get(httpServer.URL + "/api/v1/events?type=pulled")
+	g.Expect(err).ToNot(HaveOccurred())
+	response.Body.Close()
+	g.Expect(response.StatusCode).To(Equal(http.StatusBadRequest))
+
+	response, err = http.Get(httpServer.URL + "/api/v1/events?type=expired,failed")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer response.Body.Close()
+	g.Expect(response.StatusCode).To(Equal(http.StatusOK))
+	g.Expect(response.Header.Get("Content-Type")).To(Equal("text/event-stream"))
+
+	server.events.Publish(events.Event{Type: events.Cached, CachedImage: "docker.io-library-nginx-1.25"})
+	server.events.Publish(events.Event{Type: events.Failed, CachedImage: "docker.io-library-alpine-latest", Reason: "CacheFailed"})
+
+	reader := bufio.NewReader(response.Body)
+	line, err := reader.ReadString('\n')
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(line).To(Equal("event:failed\n"))
+	line, err = reader.ReadString('\n')
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(line).To(HavePrefix("data:"))
+
+	event := events.Event{}
+	g.Expect(json.Unmarshal([]byte(strings.TrimPrefix(line, "data:")), &event)).To(Succeed())
+	g.Expect(event.Type).To(Equal(events.Failed))
+	g.Expect(event.CachedImage).To(Equal("docker.io-library-alpine-latest"))
+	g.Expect(event.Reason).To(Equal("CacheFailed"))
+}
@@ -0,0 +1,115 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/gin-gonic/gin"
+)
+
+// healthResource describes how to assess the health of a kuik resource from its Ready condition
+type healthResource struct {
+	Kind string
+	// FailedReasons are the reasons of a false Ready condition that won't resolve without an intervention
+	FailedReasons []string
+}
+
+var healthResources = []healthResource{
+	{Kind: "CachedImage", FailedReasons: []string{
+		kuikv1alpha1.ReasonCacheFailed,
+		kuikv1alpha1.ReasonUnauthorized,
+		kuikv1alpha1.ReasonImageNotFound,
+		kuikv1alpha1.ReasonStorageFull,
+		kuikv1alpha1.ReasonLimitExceeded,
+		kuikv1alpha1.ReasonPolicyViolation,
+		kuikv1alpha1.ReasonSignatureInvalid,
+	}},
+	{Kind: "Application"},
+	{Kind: "Release", FailedReasons: []string{"NotSynced"}},
+	{Kind: "ImagePrefetch", FailedReasons: []string{"NotRun", "InvalidSchedule"}},
+}
+
+// argoCDHealthLua returns an Argo CD custom health check of a resource: healthy once its Ready condition is true,
+// degraded if it failed and progressing otherwise, e.g. while images are being cached
+func argoCDHealthLua(resource healthResource) string {
+	failed := make([]string, 0, len(resource.FailedReasons))
+	for _, reason := range resource.FailedReasons {
+		failed = append(failed, fmt.Sprintf("condition.reason == %q", reason))
+	}
+	degraded := "false"
+	if len(failed) > 0 {
+		degraded = strings.Join(failed, " or ")
+	}
+
+	return fmt.Sprintf(`hs = {status = "Progressing", message = "Waiting for images to be cached"}
+if obj.status == nil or obj.status.conditions == nil then
+  return hs
+end
+if obj.status.observedGeneration ~= nil and obj.metadata.generation ~= nil and obj.status.observedGeneration < obj.metadata.generation then
+  return hs
+end
+for _, condition in ipairs(obj.status.conditions) do
+  if condition.type == %q then
+    hs.message = condition.message
+    if condition.status == "True" then
+      hs.status = "Healthy"
+    elseif condition.status == "False" and (%s) then
+      hs.status = "Degraded"
+    end
+  end
+end
+return hs
+`, kuikv1alpha1.ConditionReady, degraded)
+}
+
+// argoCDHealthRules returns a patch of the argocd-cm ConfigMap adding custom health checks of kuik resources
+func argoCDHealthRules() string {
+	var rules strings.Builder
+	rules.WriteString("data:\n")
+	for _, resource := range healthResources {
+		fmt.Fprintf(&rules, "  resource.customizations.health.%s_%s: |\n", kuikv1alpha1.GroupVersion.Group, resource.Kind)
+		for _, line := range strings.SplitAfter(strings.TrimSuffix(argoCDHealthLua(resource), "\n"), "\n") {
+			rules.WriteString("    " + line)
+		}
+		rules.WriteString("\n")
+	}
+	return rules.String()
+}
+
+// fluxHealthRules returns the healthCheckExprs of a Flux Kustomization assessing the health of kuik resources
+func fluxHealthRules() string {
+	readyWith := func(condition string) string {
+		return fmt.Sprintf("has(status.conditions) && status.conditions.exists(e, e.type == '%s' && %s)", kuikv1alpha1.ConditionReady, condition)
+	}
+
+	var rules strings.Builder
+	rules.WriteString("healthCheckExprs:\n")
+	for _, resource := range healthResources {
+		fmt.Fprintf(&rules, "- apiVersion: %s\n", kuikv1alpha1.GroupVersion.String())
+		fmt.Fprintf(&rules, "  kind: %s\n", resource.Kind)
+		fmt.Fprintf(&rules, "  current: %s\n", readyWith("e.status == 'True'"))
+		if len(resource.FailedReasons) > 0 {
+			reasons := make([]string, 0, len(resource.FailedReasons))
+			for _, reason := range resource.FailedReasons {
+				reasons = append(reasons, "'"+reason+"'")
+			}
+			fmt.Fprintf(&rules, "  failed: %s\n", readyWith("e.status == 'False' && e.reason in ["+strings.Join(reasons, ", ")+"]"))
+		}
+	}
+	return rules.String()
+}
+
+// exportHealthRules returns the health rules of kuik resources for Argo CD with ?format=argocd (default) or for Flux
+// with ?format=flux, so that GitOps syncs can wait for images to be cached
+func (s *Server) exportHealthRules(c *gin.Context) {
+	switch c.DefaultQuery("format", "argocd") {
+	case "argocd":
+		c.Data(http.StatusOK, "application/yaml", []byte(argoCDHealthRules()))
+	case "flux":
+		c.Data(http.StatusOK, "application/yaml", []byte(fluxHealthRules()))
+	default:
+		c.String(http.StatusBadRequest, "unsupported format, use argocd or flux")
+	}
+}
@@ -0,0 +1,53 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+func Test_exportHealthRules(t *testing.T) {
+	g := NewWithT(t)
+	server := newTestServer()
+
+	recorder := httptest.NewRecorder()
+	server.engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/health-rules", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusOK))
+
+	argoCD := struct {
+		Data map[string]string `json:"data"`
+	}{}
+	g.Expect(yaml.Unmarshal(recorder.Body.Bytes(), &argoCD)).To(Succeed())
+	g.Expect(argoCD.Data).To(HaveLen(4))
+	g.Expect(argoCD.Data).To(HaveKeyWithValue("resource.customizations.health.kuik.enix.io_CachedImage", And(
+		HavePrefix("hs = {"),
+		ContainSubstring(`condition.type == "Ready"`),
+		ContainSubstring(`(condition.reason == "CacheFailed" or condition.reason == "Unauthorized" or condition.reason == "ImageNotFound" or condition.reason == "StorageFull" or condition.reason == "LimitExceeded" or condition.reason == "PolicyViolation" or condition.reason == "SignatureInvalid")`),
+		HaveSuffix("return hs\n"),
+	)))
+	g.Expect(argoCD.Data).To(HaveKeyWithValue("resource.customizations.health.kuik.enix.io_Application", ContainSubstring(`condition.status == "False" and (false)`)))
+
+	recorder = httptest.NewRecorder()
+	server.engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/health-rules?format=flux", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusOK))
+
+	flux := struct {
+		HealthCheckExprs []map[string]string `json:"healthCheckExprs"`
+	}{}
+	g.Expect(yaml.Unmarshal(recorder.Body.Bytes(), &flux)).To(Succeed())
+	g.Expect(flux.HealthCheckExprs).To(HaveLen(4))
+	g.Expect(flux.HealthCheckExprs[0]).To(Equal(map[string]string{
+		"apiVersion": "kuik.enix.io/v1alpha1",
+		"kind":       "CachedImage",
+		"current":    "has(status.conditions) && status.conditions.exists(e, e.type == 'Ready' && e.status == 'True')",
+		"failed":     "has(status.conditions) && status.conditions.exists(e, e.type == 'Ready' && e.status == 'False' && e.reason in ['CacheFailed', 'Unauthorized', 'ImageNotFound', 'StorageFull', 'LimitExceeded', 'PolicyViolation', 'SignatureInvalid'])",
+	}))
+	g.Expect(flux.HealthCheckExprs[1]).ToNot(HaveKey("failed"))
+
+	recorder = httptest.NewRecorder()
+	server.engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/health-rules?format=helm", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+}
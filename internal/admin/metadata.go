@@ -0,0 +1,115 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type PlatformMetadata struct {
+	Platform string `json:"platform"`
+	// Digest is the digest of the manifest of the image for this platform
+	Digest       string            `json:"digest"`
+	Created      *time.Time        `json:"created,omitempty"`
+	Entrypoint   []string          `json:"entrypoint,omitempty"`
+	Cmd          []string          `json:"cmd,omitempty"`
+	Env          []string          `json:"env,omitempty"`
+	WorkingDir   string            `json:"workingDir,omitempty"`
+	User         string            `json:"user,omitempty"`
+	ExposedPorts []string          `json:"exposedPorts,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+}
+
+type ImageMetadata struct {
+	Name        string             `json:"name"`
+	SourceImage string             `json:"sourceImage"`
+	Platforms   []PlatformMetadata `json:"platforms"`
+}
+
+// imageMetadata returns the metadata of the platforms of an image parsed from their configs, only the platform matching
+// platform if it is not empty, e.g. linux/arm64
+func imageMetadata(cachedImage *kuikv1alpha1.CachedImage, configs []registry.ImageConfig, platform string) *ImageMetadata {
+	metadata := &ImageMetadata{
+		Name:        cachedImage.Name,
+		SourceImage: cachedImage.Spec.SourceImage,
+		Platforms:   []PlatformMetadata{},
+	}
+
+	for _, imageConfig := range configs {
+		config := imageConfig.Config
+		platformMetadata := PlatformMetadata{
+			Platform:   config.Platform().String(),
+			Digest:     imageConfig.Digest.String(),
+			Entrypoint: config.Config.Entrypoint,
+			Cmd:        config.Config.Cmd,
+			Env:        config.Config.Env,
+			WorkingDir: config.Config.WorkingDir,
+			User:       config.Config.User,
+			Labels:     config.Config.Labels,
+		}
+		if platform != "" && platformMetadata.Platform != platform {
+			continue
+		}
+		if !config.Created.IsZero() {
+			created := config.Created.Time.UTC()
+			platformMetadata.Created = &created
+		}
+		for port := range config.Config.ExposedPorts {
+			platformMetadata.ExposedPorts = append(platformMetadata.ExposedPorts, port)
+		}
+		sort.Strings(platformMetadata.ExposedPorts)
+		metadata.Platforms = append(metadata.Platforms, platformMetadata)
+	}
+
+	return metadata
+}
+
+// cachedImageConfigs returns a CachedImage along with the configs of its platforms read from the cache, or writes an
+// error response and returns nil
+func (s *Server) cachedImageConfigs(c *gin.Context, name string) (*kuikv1alpha1.CachedImage, []registry.ImageConfig) {
+	var cachedImage kuikv1alpha1.CachedImage
+	if err := s.k8sClient.Get(c, types.NamespacedName{Name: name}, &cachedImage); err != nil {
+		if apierrors.IsNotFound(err) {
+			c.String(http.StatusNotFound, "CachedImage %s not found", name)
+			return nil, nil
+		}
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return nil, nil
+	}
+	if !cachedImage.Status.IsCached {
+		c.String(http.StatusNotFound, "image %s is not cached yet", cachedImage.Spec.SourceImage)
+		return nil, nil
+	}
+
+	configs, err := s.imageConfigs(cachedImage.Spec.SourceImage)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusBadGateway, fmt.Errorf("could not read image %s from cache: %w", cachedImage.Spec.SourceImage, err))
+		return nil, nil
+	}
+
+	return &cachedImage, configs
+}
+
+// exportImageMetadata returns the entrypoint, environment, labels and creation date of every platform of a cached image,
+// or of the ?platform one, read from the cache so that tools can inspect images without pulling them
+func (s *Server) exportImageMetadata(c *gin.Context) {
+	cachedImage, configs := s.cachedImageConfigs(c, c.Param("name"))
+	if cachedImage == nil {
+		return
+	}
+
+	metadata := imageMetadata(cachedImage, configs, c.Query("platform"))
+	if len(metadata.Platforms) == 0 {
+		c.String(http.StatusNotFound, "image %s has no %s platform", cachedImage.Spec.SourceImage, c.Query("platform"))
+		return
+	}
+
+	c.JSON(http.StatusOK, metadata)
+}
@@ -0,0 +1,78 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/enix/kube-image-keeper/internal/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	. "github.com/onsi/gomega"
+)
+
+func Test_exportImageMetadata(t *testing.T) {
+	g := NewWithT(t)
+	created := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	server := newTestServer()
+	server.imageConfigs = func(image string) ([]registry.ImageConfig, error) {
+		config := func(architecture string) *v1.ConfigFile {
+			return &v1.ConfigFile{
+				OS:           "linux",
+				Architecture: architecture,
+				Created:      v1.Time{Time: created},
+				Config: v1.Config{
+					Entrypoint:   []string{"/docker-entrypoint.sh"},
+					Cmd:          []string{"nginx", "-g", "daemon off;"},
+					Env:          []string{"NGINX_VERSION=1.25.3"},
+					ExposedPorts: map[string]struct{}{"80/tcp": {}, "443/tcp": {}},
+					Labels:       map[string]string{"maintainer": "NGINX Docker Maintainers"},
+				},
+			}
+		}
+		return []registry.ImageConfig{
+			{Digest: hash("amd64"), Config: config("amd64")},
+			{Digest: hash("arm64"), Config: config("arm64")},
+		}, nil
+	}
+
+	recorder := httptest.NewRecorder()
+	server.engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/images/docker.io-library-nginx-1.25/metadata", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusOK))
+
+	metadata := ImageMetadata{}
+	g.Expect(json.Unmarshal(recorder.Body.Bytes(), &metadata)).To(Succeed())
+	g.Expect(metadata.SourceImage).To(Equal("nginx:1.25"))
+	g.Expect(metadata.Platforms).To(HaveLen(2))
+	g.Expect(metadata.Platforms[0]).To(Equal(PlatformMetadata{
+		Platform:     "linux/amd64",
+		Digest:       "sha256:amd64",
+		Created:      &created,
+		Entrypoint:   []string{"/docker-entrypoint.sh"},
+		Cmd:          []string{"nginx", "-g", "daemon off;"},
+		Env:          []string{"NGINX_VERSION=1.25.3"},
+		ExposedPorts: []string{"443/tcp", "80/tcp"},
+		Labels:       map[string]string{"maintainer": "NGINX Docker Maintainers"},
+	}))
+
+	recorder = httptest.NewRecorder()
+	server.engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/images/docker.io-library-nginx-1.25/metadata?platform=linux/arm64", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusOK))
+	g.Expect(json.Unmarshal(recorder.Body.Bytes(), &metadata)).To(Succeed())
+	g.Expect(metadata.Platforms).To(HaveLen(1))
+	g.Expect(metadata.Platforms[0].Platform).To(Equal("linux/arm64"))
+
+	recorder = httptest.NewRecorder()
+	server.engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/images/docker.io-library-nginx-1.25/metadata?platform=windows/amd64", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusNotFound))
+
+	// Images that are not cached yet can't be inspected
+	recorder = httptest.NewRecorder()
+	server.engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/images/docker.io-library-alpine-latest/metadata", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusNotFound))
+
+	recorder = httptest.NewRecorder()
+	server.engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/images/missing/metadata", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusNotFound))
+}
@@ -0,0 +1,198 @@
+package admin
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/controllers"
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// NodeCoverage tells how much of the images used on a node are served from the cache
+type NodeCoverage struct {
+	Node string `json:"node"`
+	// PulledImages is the number of cached images the proxy of the node served
+	PulledImages int   `json:"pulledImages"`
+	PulledBytes  int64 `json:"pulledBytes"`
+	// ImagesInUse is the number of images with a CachedImage used by pods of the node, CachedImagesInUse the number of
+	// them that are cached
+	ImagesInUse       int     `json:"imagesInUse"`
+	CachedImagesInUse int     `json:"cachedImagesInUse"`
+	Coverage          float64 `json:"coverage"`
+}
+
+// RequiredImage is an image a node would need to run a pod
+type RequiredImage struct {
+	Name        string `json:"name"`
+	SourceImage string `json:"sourceImage"`
+	IsCached    bool   `json:"isCached"`
+	Size        int64  `json:"size"`
+	// OnNode tells whether the node already has the image, as reported by the node or pulled through its proxy
+	OnNode bool `json:"onNode"`
+	// LastPulledAt is the last time the node pulled the image through its proxy
+	LastPulledAt *time.Time `json:"lastPulledAt,omitempty"`
+}
+
+// NodeRequirements are the images a node would need to pull if a pod moved there
+type NodeRequirements struct {
+	Node   string          `json:"node"`
+	Pod    string          `json:"pod"`
+	Images []RequiredImage `json:"images"`
+	// MissingBytes is the size of the images missing from the node, UpstreamImages the number of images missing from
+	// the node that would be pulled from their upstream registry since they are not cached
+	MissingBytes   int64 `json:"missingBytes"`
+	UpstreamImages int   `json:"upstreamImages"`
+}
+
+// exportNodes reports the cache coverage of every node, sorted by name
+func (s *Server) exportNodes(c *gin.Context) {
+	var cachedImages kuikv1alpha1.CachedImageList
+	if err := s.k8sClient.List(c, &cachedImages); err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	var nodes corev1.NodeList
+	if err := s.k8sClient.List(c, &nodes); err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	var pods corev1.PodList
+	if err := s.k8sClient.List(c, &pods); err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	podNodes := map[string]string{}
+	for _, pod := range pods.Items {
+		podNodes[pod.Namespace+"/"+pod.Name] = pod.Spec.NodeName
+	}
+
+	coverages := map[string]*NodeCoverage{}
+	for _, node := range nodes.Items {
+		coverages[node.Name] = &NodeCoverage{Node: node.Name}
+	}
+
+	for _, cachedImage := range cachedImages.Items {
+		for _, nodePull := range cachedImage.Status.Usage.Nodes {
+			if coverage, ok := coverages[nodePull.Name]; ok {
+				coverage.PulledImages++
+				coverage.PulledBytes += cachedImage.Status.Size
+			}
+		}
+
+		usedOn := map[string]bool{}
+		for _, pod := range cachedImage.Status.UsedBy.Pods {
+			usedOn[podNodes[pod.NamespacedName]] = true
+		}
+		for nodeName := range usedOn {
+			if coverage, ok := coverages[nodeName]; ok {
+				coverage.ImagesInUse++
+				if cachedImage.Status.IsCached {
+					coverage.CachedImagesInUse++
+				}
+			}
+		}
+	}
+
+	result := make([]NodeCoverage, 0, len(coverages))
+	for _, coverage := range coverages {
+		if coverage.ImagesInUse > 0 {
+			coverage.Coverage = float64(coverage.CachedImagesInUse) / float64(coverage.ImagesInUse)
+		}
+		result = append(result, *coverage)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Node < result[j].Node
+	})
+
+	c.JSON(http.StatusOK, result)
+}
+
+// exportNodeRequirements reports the images a node would need to pull if the pod given by ?pod=namespace/name moved
+// there, i.e. the images of the pod that are cached by kuik and missing from the node
+func (s *Server) exportNodeRequirements(c *gin.Context) {
+	namespace, name, found := strings.Cut(c.Query("pod"), "/")
+	if !found || namespace == "" || name == "" {
+		c.String(http.StatusBadRequest, "pod must be given as namespace/name")
+		return
+	}
+
+	var node corev1.Node
+	if err := s.k8sClient.Get(c, types.NamespacedName{Name: c.Param("node")}, &node); apierrors.IsNotFound(err) {
+		c.String(http.StatusNotFound, "node not found")
+		return
+	} else if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	var pod corev1.Pod
+	if err := s.k8sClient.Get(c, types.NamespacedName{Namespace: namespace, Name: name}, &pod); apierrors.IsNotFound(err) {
+		c.String(http.StatusNotFound, "pod not found")
+		return
+	} else if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	var cachedImages kuikv1alpha1.CachedImageList
+	if err := s.k8sClient.List(c, &cachedImages); err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	onNode := map[string]bool{}
+	for _, cachedImageName := range controllers.CachedImageNamesFromNode(&node) {
+		onNode[cachedImageName] = true
+	}
+
+	requirements := NodeRequirements{Node: node.Name, Pod: namespace + "/" + name, Images: []RequiredImage{}}
+	for _, cachedImage := range cachedImages.Items {
+		if !usedByPod(&cachedImage, requirements.Pod) {
+			continue
+		}
+
+		image := RequiredImage{
+			Name:        cachedImage.Name,
+			SourceImage: cachedImage.Spec.SourceImage,
+			IsCached:    cachedImage.Status.IsCached,
+			Size:        cachedImage.Status.Size,
+			OnNode:      onNode[cachedImage.Name],
+		}
+		for _, nodePull := range cachedImage.Status.Usage.Nodes {
+			if nodePull.Name == node.Name {
+				lastPulledAt := nodePull.LastPulledAt.Time
+				image.LastPulledAt = &lastPulledAt
+			}
+		}
+		// Images pulled through the proxy may have been removed from the node since, the node only reports the images it
+		// still has when it reports any
+		if image.LastPulledAt != nil && len(node.Status.Images) == 0 {
+			image.OnNode = true
+		}
+
+		if !image.OnNode {
+			requirements.MissingBytes += image.Size
+			if !image.IsCached {
+				requirements.UpstreamImages++
+			}
+		}
+		requirements.Images = append(requirements.Images, image)
+	}
+
+	c.JSON(http.StatusOK, requirements)
+}
+
+func usedByPod(cachedImage *kuikv1alpha1.CachedImage, pod string) bool {
+	for _, podReference := range cachedImage.Status.UsedBy.Pods {
+		if podReference.NamespacedName == pod {
+			return true
+		}
+	}
+	return false
+}
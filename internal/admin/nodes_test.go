@@ -0,0 +1,96 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/events"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newNodesTestServer() *Server {
+	usedBy := kuikv1alpha1.UsedBy{Pods: []kuikv1alpha1.PodReference{{NamespacedName: "default/app"}}, Count: 1}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+			Status: corev1.NodeStatus{Images: []corev1.ContainerImage{
+				{Names: []string{"localhost:7439/docker.io/library/nginx:1.25"}},
+			}},
+		},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+			Spec:       corev1.PodSpec{NodeName: "node-1"},
+		},
+		&kuikv1alpha1.CachedImage{
+			ObjectMeta: metav1.ObjectMeta{Name: "docker.io-library-nginx-1.25"},
+			Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: "nginx:1.25"},
+			Status: kuikv1alpha1.CachedImageStatus{
+				IsCached: true,
+				Size:     100,
+				UsedBy:   usedBy,
+				Usage:    kuikv1alpha1.Usage{Nodes: []kuikv1alpha1.NodePull{{Name: "node-1", LastPulledAt: lastPulledAt}}},
+			},
+		},
+		&kuikv1alpha1.CachedImage{
+			ObjectMeta: metav1.ObjectMeta{Name: "docker.io-library-redis-7"},
+			Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: "redis:7"},
+			Status:     kuikv1alpha1.CachedImageStatus{Size: 50, UsedBy: usedBy},
+		},
+	).Build()
+
+	return New(k8sClient, events.NewBroker(), ":0")
+}
+
+func Test_exportNodes(t *testing.T) {
+	g := NewWithT(t)
+	server := newNodesTestServer()
+
+	recorder := httptest.NewRecorder()
+	server.engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/nodes", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusOK))
+
+	coverages := []NodeCoverage{}
+	g.Expect(json.Unmarshal(recorder.Body.Bytes(), &coverages)).To(Succeed())
+	g.Expect(coverages).To(Equal([]NodeCoverage{
+		{Node: "node-1", PulledImages: 1, PulledBytes: 100, ImagesInUse: 2, CachedImagesInUse: 1, Coverage: 0.5},
+		{Node: "node-2"},
+	}))
+}
+
+func Test_exportNodeRequirements(t *testing.T) {
+	g := NewWithT(t)
+	server := newNodesTestServer()
+
+	recorder := httptest.NewRecorder()
+	server.engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/nodes/node-2/requirements?pod=default/app", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusOK))
+
+	requirements := NodeRequirements{}
+	g.Expect(json.Unmarshal(recorder.Body.Bytes(), &requirements)).To(Succeed())
+	g.Expect(requirements.Images).To(HaveLen(2))
+	g.Expect(requirements.MissingBytes).To(BeEquivalentTo(150))
+	g.Expect(requirements.UpstreamImages).To(Equal(1))
+
+	recorder = httptest.NewRecorder()
+	server.engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/nodes/node-1/requirements?pod=default/app", nil))
+	g.Expect(json.Unmarshal(recorder.Body.Bytes(), &requirements)).To(Succeed())
+	g.Expect(requirements.Images[0].OnNode).To(BeTrue())
+	g.Expect(requirements.Images[0].LastPulledAt).ToNot(BeNil())
+	g.Expect(requirements.MissingBytes).To(BeEquivalentTo(50))
+
+	recorder = httptest.NewRecorder()
+	server.engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/nodes/node-3/requirements?pod=default/app", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusNotFound))
+
+	recorder = httptest.NewRecorder()
+	server.engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/nodes/node-1/requirements", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+}
@@ -0,0 +1,114 @@
+package admin
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/enix/kube-image-keeper/controllers"
+	"github.com/enix/kube-image-keeper/internal/pulltoken"
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// defaultPullTokenTTL is how long pull tokens are valid when no TTL is requested
+const defaultPullTokenTTL = time.Hour
+
+type PullTokenRequest struct {
+	Image string `json:"image"`
+	// TTL is how long the token is valid, e.g. "15m", 1h by default
+	TTL string `json:"ttl,omitempty"`
+}
+
+type PullToken struct {
+	Token string `json:"token"`
+	// Image is the only image the token allows to pull, by tag or digest
+	Image      string    `json:"image"`
+	Repository string    `json:"repository"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// issuePullToken mints a short-lived token allowing to pull a cached image through the authenticated endpoint of the
+// proxy, without giving access to the rest of the cache. Tokens are only issued to users authenticated by
+// pullTokenIssuers.
+func (s *Server) issuePullToken(c *gin.Context) {
+	if s.pullTokens == nil {
+		c.String(http.StatusNotFound, "pull tokens are disabled")
+		return
+	}
+
+	user, password, ok := c.Request.BasicAuth()
+	if !ok || s.pullTokenIssuers == nil || !s.pullTokenIssuers.Authenticate(user, password) {
+		c.Header("WWW-Authenticate", `Basic realm="kube-image-keeper"`)
+		c.String(http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	var request PullTokenRequest
+	if err := c.BindJSON(&request); err != nil {
+		return
+	}
+
+	ttl := defaultPullTokenTTL
+	if ttl > s.pullTokenMaxTTL {
+		ttl = s.pullTokenMaxTTL
+	}
+	if request.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(request.TTL); err != nil || ttl <= 0 {
+			c.String(http.StatusBadRequest, "invalid ttl %q", request.TTL)
+			return
+		}
+	}
+	if ttl > s.pullTokenMaxTTL {
+		c.String(http.StatusBadRequest, "ttl must not exceed %s", s.pullTokenMaxTTL)
+		return
+	}
+
+	named, err := reference.ParseNormalizedNamed(request.Image)
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid image %q: %s", request.Image, err)
+		return
+	}
+	ref := reference.TagNameOnly(named)
+
+	cachedImage, err := controllers.CachedImageFromSourceImage(request.Image)
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid image %q: %s", request.Image, err)
+		return
+	}
+	if err := s.k8sClient.Get(c, client.ObjectKeyFromObject(cachedImage), cachedImage); err != nil && !apierrors.IsNotFound(err) {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	} else if err != nil || !cachedImage.Status.IsCached {
+		c.String(http.StatusNotFound, "image %s is not cached", request.Image)
+		return
+	}
+
+	// The manifests of the image are pulled by its tag or digest, then by the digests of the manifests of its platforms
+	scope := pulltoken.Scope{Repository: ref.Name()}
+	if tagged, ok := ref.(reference.Tagged); ok {
+		scope.Manifests = append(scope.Manifests, tagged.Tag())
+	}
+	digests, err := s.imageManifests(cachedImage.Spec.SourceImage)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	for _, digest := range digests {
+		scope.Manifests = append(scope.Manifests, digest.String())
+	}
+
+	pullToken := PullToken{
+		Image:      ref.String(),
+		Repository: scope.Repository,
+		ExpiresAt:  time.Now().Add(ttl).Truncate(time.Second),
+	}
+	if pullToken.Token, err = s.pullTokens.Issue(scope, pullToken.ExpiresAt); err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, pullToken)
+}
@@ -0,0 +1,83 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/enix/kube-image-keeper/internal/pulltoken"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	. "github.com/onsi/gomega"
+)
+
+type testIssuers map[string]string
+
+func (t testIssuers) Authenticate(user string, password string) bool {
+	expected, ok := t[user]
+	return ok && expected == password
+}
+
+func Test_issuePullToken(t *testing.T) {
+	g := NewWithT(t)
+
+	signer, err := pulltoken.NewSigner([]byte("0123456789abcdef"))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	server := newTestServer()
+	digests := []v1.Hash{{Algorithm: "sha256", Hex: "index"}, {Algorithm: "sha256", Hex: "amd64"}}
+	server.imageManifests = func(string) ([]v1.Hash, error) {
+		return digests, nil
+	}
+	issueAs := func(user string, password string, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/pull-tokens", strings.NewReader(body))
+		if user != "" {
+			request.SetBasicAuth(user, password)
+		}
+		server.engine.ServeHTTP(recorder, request)
+		return recorder
+	}
+	issue := func(body string) *httptest.ResponseRecorder {
+		return issueAs("ci", "s3cr3t", body)
+	}
+
+	g.Expect(issue(`{"image":"nginx:1.25"}`).Code).To(Equal(http.StatusNotFound))
+
+	server.WithPullTokens(signer, 24*time.Hour, testIssuers{"ci": "s3cr3t"})
+
+	// Only authenticated users can issue pull tokens
+	g.Expect(issueAs("", "", `{"image":"nginx:1.25"}`).Code).To(Equal(http.StatusUnauthorized))
+	g.Expect(issueAs("ci", "wrong", `{"image":"nginx:1.25"}`).Code).To(Equal(http.StatusUnauthorized))
+
+	// Tokens only allow to pull the manifests of the image they have been issued for
+	recorder := issue(`{"image":"nginx:1.25","ttl":"15m"}`)
+	g.Expect(recorder.Code).To(Equal(http.StatusCreated))
+	pullToken := PullToken{}
+	g.Expect(json.Unmarshal(recorder.Body.Bytes(), &pullToken)).To(Succeed())
+	g.Expect(pullToken.Image).To(Equal("docker.io/library/nginx:1.25"))
+	g.Expect(pullToken.Repository).To(Equal("docker.io/library/nginx"))
+	g.Expect(pullToken.ExpiresAt).To(BeTemporally("~", time.Now().Add(15*time.Minute), time.Second))
+	g.Expect(signer.Verify(pullToken.Token)).To(Equal(pulltoken.Scope{
+		Repository: "docker.io/library/nginx",
+		Manifests:  []string{"1.25", "sha256:index", "sha256:amd64"},
+	}))
+
+	// Images that are not cached can't be shared
+	g.Expect(issue(`{"image":"alpine"}`).Code).To(Equal(http.StatusNotFound))
+	g.Expect(issue(`{"image":"redis:7"}`).Code).To(Equal(http.StatusNotFound))
+
+	g.Expect(issue(`{"image":"nginx:1.25","ttl":"48h"}`).Code).To(Equal(http.StatusBadRequest))
+	g.Expect(issue(`{"image":"nginx:1.25","ttl":"-1h"}`).Code).To(Equal(http.StatusBadRequest))
+	g.Expect(issue(`{"image":"Invalid:Image"}`).Code).To(Equal(http.StatusBadRequest))
+	g.Expect(issue(`not json`).Code).To(Equal(http.StatusBadRequest))
+
+	// The default validity doesn't exceed the maximum one
+	server.WithPullTokens(signer, 10*time.Minute, testIssuers{"ci": "s3cr3t"})
+	recorder = issue(`{"image":"nginx:1.25"}`)
+	g.Expect(recorder.Code).To(Equal(http.StatusCreated))
+	g.Expect(json.Unmarshal(recorder.Body.Bytes(), &pullToken)).To(Succeed())
+	g.Expect(pullToken.ExpiresAt).To(BeTemporally("~", time.Now().Add(10*time.Minute), time.Second))
+}
@@ -0,0 +1,113 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/controllers"
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// defaultSimulationHorizon is how far in the future retention policies are simulated when no horizon is requested
+	defaultSimulationHorizon = 30 * 24 * time.Hour
+	// maxSimulationSteps bounds the number of points of the projected size of the cache
+	maxSimulationSteps = 1000
+)
+
+type RetentionSimulationRequest struct {
+	// ExpiryDelay is the delay before unused images expire, e.g. "720h"
+	ExpiryDelay              string `json:"expiryDelay"`
+	MutableTagsExpiryDelay   string `json:"mutableTagsExpiryDelay,omitempty"`
+	ImmutableTagsExpiryDelay string `json:"immutableTagsExpiryDelay,omitempty"`
+	// ImmutableTags is a regex matching tags that are not expected to change upstream
+	ImmutableTags         string `json:"immutableTags,omitempty"`
+	NodeImagesExpiryDelay string `json:"nodeImagesExpiryDelay,omitempty"`
+	// MaxSize is the cache quota, e.g. "100Gi"
+	MaxSize string `json:"maxSize,omitempty"`
+	// Horizon is how far in the future the policy is simulated, 720h by default
+	Horizon string `json:"horizon,omitempty"`
+	// Step is the interval between points of the projected size of the cache, 24h by default
+	Step string `json:"step,omitempty"`
+}
+
+// parse returns the retention policy, horizon and step of a simulation request
+func (request *RetentionSimulationRequest) parse() (controllers.RetentionPolicy, time.Duration, time.Duration, error) {
+	policy := controllers.RetentionPolicy{}
+	horizon, step := defaultSimulationHorizon, 24*time.Hour
+
+	durations := []struct {
+		name     string
+		value    string
+		duration *time.Duration
+	}{
+		{"expiryDelay", request.ExpiryDelay, &policy.ExpiryDelay},
+		{"mutableTagsExpiryDelay", request.MutableTagsExpiryDelay, &policy.MutableTagsExpiryDelay},
+		{"immutableTagsExpiryDelay", request.ImmutableTagsExpiryDelay, &policy.ImmutableTagsExpiryDelay},
+		{"nodeImagesExpiryDelay", request.NodeImagesExpiryDelay, &policy.NodeImagesExpiryDelay},
+		{"horizon", request.Horizon, &horizon},
+		{"step", request.Step, &step},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(d.value)
+		if err != nil || duration < 0 {
+			return policy, 0, 0, fmt.Errorf("invalid %s %q", d.name, d.value)
+		}
+		*d.duration = duration
+	}
+
+	if policy.ExpiryDelay <= 0 {
+		return policy, 0, 0, fmt.Errorf("expiryDelay must be positive")
+	}
+	if step <= 0 || horizon/step > maxSimulationSteps {
+		return policy, 0, 0, fmt.Errorf("step must be positive and split the horizon in at most %d steps", maxSimulationSteps)
+	}
+
+	if request.ImmutableTags != "" {
+		immutableTags, err := regexp.Compile(request.ImmutableTags)
+		if err != nil {
+			return policy, 0, 0, fmt.Errorf("invalid immutableTags %q: %w", request.ImmutableTags, err)
+		}
+		policy.ImmutableTags = immutableTags
+	}
+
+	if request.MaxSize != "" {
+		maxSize, err := resource.ParseQuantity(request.MaxSize)
+		if err != nil {
+			return policy, 0, 0, fmt.Errorf("invalid maxSize %q: %w", request.MaxSize, err)
+		}
+		policy.MaxSize = maxSize.Value()
+	}
+
+	return policy, horizon, step, nil
+}
+
+// simulateRetention simulates a proposed retention policy against the current usage of cached images, reporting the
+// images it would remove and the projected size of the cache, so that policy changes can be evaluated safely
+func (s *Server) simulateRetention(c *gin.Context) {
+	var request RetentionSimulationRequest
+	if err := c.BindJSON(&request); err != nil {
+		return
+	}
+
+	policy, horizon, step, err := request.parse()
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var cachedImages kuikv1alpha1.CachedImageList
+	if err := s.k8sClient.List(c, &cachedImages); err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, controllers.SimulateRetention(cachedImages.Items, policy, time.Now(), horizon, step))
+}
@@ -0,0 +1,43 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/enix/kube-image-keeper/controllers"
+	. "github.com/onsi/gomega"
+)
+
+func Test_simulateRetention(t *testing.T) {
+	g := NewWithT(t)
+	server := newTestServer()
+
+	simulate := func(body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/retention/simulate", strings.NewReader(body)))
+		return recorder
+	}
+
+	// nginx:1.25 has last been pulled long ago, alpine is not cached
+	recorder := simulate(`{"expiryDelay":"720h","horizon":"48h","step":"12h"}`)
+	g.Expect(recorder.Code).To(Equal(http.StatusOK))
+	simulation := controllers.RetentionSimulation{}
+	g.Expect(json.Unmarshal(recorder.Body.Bytes(), &simulation)).To(Succeed())
+	g.Expect(simulation.Removed).To(HaveLen(1))
+	g.Expect(simulation.Removed[0].SourceImage).To(Equal("nginx:1.25"))
+	g.Expect(simulation.Removed[0].Reason).To(Equal("Expired"))
+	g.Expect(simulation.Storage).To(HaveLen(5))
+	g.Expect(simulation.Storage[0].Images).To(BeZero())
+
+	g.Expect(simulate(`{"expiryDelay":"720h","maxSize":"100Gi","immutableTags":"^v"}`).Code).To(Equal(http.StatusOK))
+
+	g.Expect(simulate(`{}`).Code).To(Equal(http.StatusBadRequest))
+	g.Expect(simulate(`{"expiryDelay":"30d"}`).Code).To(Equal(http.StatusBadRequest))
+	g.Expect(simulate(`{"expiryDelay":"720h","step":"1s"}`).Code).To(Equal(http.StatusBadRequest))
+	g.Expect(simulate(`{"expiryDelay":"720h","maxSize":"lots"}`).Code).To(Equal(http.StatusBadRequest))
+	g.Expect(simulate(`{"expiryDelay":"720h","immutableTags":"("}`).Code).To(Equal(http.StatusBadRequest))
+	g.Expect(simulate(`not json`).Code).To(Equal(http.StatusBadRequest))
+}
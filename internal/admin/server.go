@@ -0,0 +1,134 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/enix/kube-image-keeper/internal/events"
+	"github.com/enix/kube-image-keeper/internal/pulltoken"
+	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/enix/kube-image-keeper/internal/snapshot"
+	"github.com/gin-gonic/gin"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Server exposes an HTTP API to inspect kuik state from outside of the cluster objects
+type Server struct {
+	engine    *gin.Engine
+	k8sClient client.Client
+	events    *events.Broker
+	addr      string
+	// imageBlobs lists the blobs of an image in the registry
+	imageBlobs func(string) (map[v1.Hash]int64, error)
+	// imageConfigs reads the configs of an image in the registry
+	imageConfigs func(string) ([]registry.ImageConfig, error)
+	// imageDigest reads the digest of an image in the registry
+	imageDigest func(string) (v1.Hash, error)
+	// imageManifests reads the digests of the manifests of an image in the registry
+	imageManifests   func(string) ([]v1.Hash, error)
+	pullTokens       *pulltoken.Signer
+	pullTokenMaxTTL  time.Duration
+	pullTokenIssuers Authenticator
+	snapshots        *snapshot.Signer
+	config           *RuntimeConfig
+}
+
+// Authenticator authenticates users with their password, e.g. the users of an htpasswd file
+type Authenticator interface {
+	Authenticate(user string, password string) bool
+}
+
+func New(k8sClient client.Client, broker *events.Broker, addr string) *Server {
+	gin.SetMode(gin.ReleaseMode)
+	s := &Server{
+		engine:         gin.New(),
+		k8sClient:      k8sClient,
+		events:         broker,
+		addr:           addr,
+		imageBlobs:     registry.ImageBlobs,
+		imageConfigs:   registry.ImageConfigs,
+		imageDigest:    registry.ImageDigest,
+		imageManifests: registry.ImageManifests,
+	}
+	s.engine.Use(gin.Recovery())
+	s.routes()
+	return s
+}
+
+// WithPullTokens enables the issuance of pull tokens valid for at most maxTTL, to users authenticated by issuers
+func (s *Server) WithPullTokens(signer *pulltoken.Signer, maxTTL time.Duration, issuers Authenticator) *Server {
+	s.pullTokens = signer
+	s.pullTokenMaxTTL = maxTTL
+	s.pullTokenIssuers = issuers
+	return s
+}
+
+// WithSnapshots enables the export of snapshots of the images in use, signed by signer
+func (s *Server) WithSnapshots(signer *snapshot.Signer) *Server {
+	s.snapshots = signer
+	return s
+}
+
+func (s *Server) routes() {
+	v1 := s.engine.Group("/api/v1")
+	{
+		v1.GET("/usage", s.exportUsage)
+		v1.GET("/events", s.streamEvents)
+		v1.GET("/storage", s.exportStorage)
+		v1.GET("/nodes", s.exportNodes)
+		v1.GET("/nodes/:node/requirements", s.exportNodeRequirements)
+		v1.GET("/images/:name/metadata", s.exportImageMetadata)
+		v1.GET("/images/:name/diff", s.exportImageDiff)
+		v1.POST("/pull-tokens", s.issuePullToken)
+		v1.POST("/retention/simulate", s.simulateRetention)
+		v1.GET("/health-rules", s.exportHealthRules)
+		v1.GET("/snapshot", s.exportSnapshot)
+		v1.GET("/snapshot/public-key", s.exportSnapshotPublicKey)
+	}
+
+	kuik := s.engine.Group("/apis/kuik/v1")
+	{
+		kuik.GET("/config", s.exportConfig)
+	}
+}
+
+// Start implements manager.Runnable
+func (s *Server) Start(ctx context.Context) error {
+	return serve(ctx, "admin server", s.addr, s.engine)
+}
+
+// serve serves handler on addr until ctx is done
+func serve(ctx context.Context, name string, addr string, handler http.Handler) error {
+	server := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+		// Cancel requests on shutdown to end event streams
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.FromContext(ctx).Error(err, "could not shutdown "+name)
+		}
+	}()
+
+	log.FromContext(ctx).Info("starting "+name, "addr", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, the admin API is served by every replica
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
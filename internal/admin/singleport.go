@@ -0,0 +1,70 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/enix/kube-image-keeper/internal/registry"
+)
+
+// SinglePort serves the admin API, the metrics of the controllers and the API of the registry behind a single address,
+// routing requests by path, so that a single kubectl port-forward is enough to browse the cache or to seed it by
+// pushing images to the registry, e.g. in clusters where exposing more ports is not allowed
+type SinglePort struct {
+	addr    string
+	handler http.Handler
+}
+
+// NewSinglePort routes /api/ and /apis/ to the admin API, /metrics to metrics and /v2/ to the registry at registryURL. Every
+// request goes through authenticate when it is not nil. Without authentication, addr must be a loopback address, only
+// reachable through kubectl port-forward, since the registry accepts pushes.
+func NewSinglePort(addr string, admin *Server, metrics http.Handler, registryURL *url.URL, authenticate func(http.Handler) http.Handler) (*SinglePort, error) {
+	if authenticate == nil && !isLoopback(addr) {
+		return nil, fmt.Errorf("the single port requires authentication to be served on %s, which is not a loopback address", addr)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/", admin.engine)
+	mux.Handle("/apis/", admin.engine)
+	mux.Handle("/metrics", metrics)
+	// The Host header of requests is kept so that the registry returns upload locations reachable by the client
+	registryProxy := httputil.NewSingleHostReverseProxy(registryURL)
+	if registry.CacheTransport != nil {
+		registryProxy.Transport = registry.CacheTransport
+	}
+	mux.Handle("/v2/", registryProxy)
+
+	var handler http.Handler = mux
+	if authenticate != nil {
+		handler = authenticate(mux)
+	}
+
+	return &SinglePort{addr: addr, handler: handler}, nil
+}
+
+// isLoopback tells whether addr only listens on a loopback interface
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Start implements manager.Runnable
+func (s *SinglePort) Start(ctx context.Context) error {
+	return serve(ctx, "single port server", s.addr, s.handler)
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, the single port is served by every replica
+func (s *SinglePort) NeedLeaderElection() bool {
+	return false
+}
@@ -0,0 +1,107 @@
+package admin
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestSinglePort(t *testing.T) {
+	registryServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "registry "+r.Method+" "+r.URL.Path+" "+r.Host)
+	}))
+	defer registryServer.Close()
+	registryURL, err := url.Parse(registryServer.URL)
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+
+	metrics := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "metrics")
+	})
+	authenticate := func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, _, ok := r.BasicAuth(); !ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			handler.ServeHTTP(w, r)
+		})
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		anonymous    bool
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "Registry upload",
+			method:       http.MethodPost,
+			path:         "/v2/docker.io/library/alpine/blobs/uploads/",
+			expectedCode: http.StatusOK,
+			expectedBody: "registry POST /v2/docker.io/library/alpine/blobs/uploads/ localhost:8084",
+		},
+		{
+			name:         "Metrics",
+			method:       http.MethodGet,
+			path:         "/metrics",
+			expectedCode: http.StatusOK,
+			expectedBody: "metrics",
+		},
+		{
+			name:         "Admin API",
+			method:       http.MethodGet,
+			path:         "/api/v1/health-rules?format=unknown",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Admin API config",
+			method:       http.MethodGet,
+			path:         "/apis/kuik/v1/config",
+			expectedCode: http.StatusNotFound,
+			expectedBody: "runtime configuration is not available",
+		},
+		{
+			name:         "Unknown path",
+			method:       http.MethodGet,
+			path:         "/debug",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "Anonymous",
+			method:       http.MethodGet,
+			path:         "/v2/",
+			anonymous:    true,
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+
+	g := NewWithT(t)
+	_, err = NewSinglePort(":8084", New(nil, nil, ":8083"), metrics, registryURL, nil)
+	g.Expect(err).To(MatchError(ContainSubstring("requires authentication")))
+	_, err = NewSinglePort("127.0.0.1:8084", New(nil, nil, ":8083"), metrics, registryURL, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	singlePort, err := NewSinglePort(":8084", New(nil, nil, ":8083"), metrics, registryURL, authenticate)
+	g.Expect(err).ToNot(HaveOccurred())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			req := httptest.NewRequest(tt.method, "http://localhost:8084"+tt.path, nil)
+			if !tt.anonymous {
+				req.SetBasicAuth("admin", "password")
+			}
+			w := httptest.NewRecorder()
+			singlePort.handler.ServeHTTP(w, req)
+			g.Expect(w.Code).To(Equal(tt.expectedCode))
+			if tt.expectedBody != "" {
+				g.Expect(w.Body.String()).To(Equal(tt.expectedBody))
+			}
+		})
+	}
+}
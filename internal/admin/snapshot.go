@@ -0,0 +1,54 @@
+package admin
+
+import (
+	"encoding/base64"
+	"net/http"
+
+	"github.com/enix/kube-image-keeper/internal/snapshot"
+	"github.com/gin-gonic/gin"
+)
+
+// exportSnapshot returns a signed document listing the digest of every image stored in cache and the pods using it, as
+// SPDX or as CycloneDX with ?format=cyclonedx, for compliance audits
+func (s *Server) exportSnapshot(c *gin.Context) {
+	if s.snapshots == nil {
+		c.String(http.StatusNotFound, "snapshots are disabled")
+		return
+	}
+
+	format, err := snapshot.ParseFormat(c.DefaultQuery("format", string(snapshot.SPDX)))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	images, err := snapshot.Build(c, s.k8sClient, s.imageDigest)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	document, err := images.Marshal(format)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Header(snapshot.SignatureHeader, base64.StdEncoding.EncodeToString(s.snapshots.Sign(document)))
+	c.Data(http.StatusOK, format.ContentType(), document)
+}
+
+// exportSnapshotPublicKey returns the PEM encoded public key verifying the signature of snapshots
+func (s *Server) exportSnapshotPublicKey(c *gin.Context) {
+	if s.snapshots == nil {
+		c.String(http.StatusNotFound, "snapshots are disabled")
+		return
+	}
+
+	publicKey, err := s.snapshots.PublicKey()
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/x-pem-file", publicKey)
+}
@@ -0,0 +1,52 @@
+package admin
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/enix/kube-image-keeper/internal/snapshot"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	. "github.com/onsi/gomega"
+)
+
+func Test_exportSnapshot(t *testing.T) {
+	g := NewWithT(t)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	server := newTestServer()
+	server.imageDigest = func(string) (v1.Hash, error) {
+		return v1.Hash{Algorithm: "sha256", Hex: "4c0fdaa8b6341bfdeca5f18f7837462c80cff90527ee35ef185571e1c327beac"}, nil
+	}
+	export := func(url string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, url, nil))
+		return recorder
+	}
+
+	g.Expect(export("/api/v1/snapshot").Code).To(Equal(http.StatusNotFound))
+
+	server.WithSnapshots(snapshot.NewSigner(privateKey))
+
+	for _, format := range []string{"spdx", "cyclonedx"} {
+		recorder := export("/api/v1/snapshot?format=" + format)
+		g.Expect(recorder.Code).To(Equal(http.StatusOK))
+		g.Expect(recorder.Body.String()).To(ContainSubstring(`"nginx:1.25"`))
+		g.Expect(recorder.Body.String()).ToNot(ContainSubstring(`"alpine"`))
+
+		signature, err := base64.StdEncoding.DecodeString(recorder.Header().Get(snapshot.SignatureHeader))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(ed25519.Verify(publicKey, recorder.Body.Bytes(), signature)).To(BeTrue())
+	}
+
+	g.Expect(export("/api/v1/snapshot?format=csv").Code).To(Equal(http.StatusBadRequest))
+
+	recorder := export("/api/v1/snapshot/public-key")
+	g.Expect(recorder.Code).To(Equal(http.StatusOK))
+	g.Expect(recorder.Body.String()).To(HavePrefix("-----BEGIN PUBLIC KEY-----"))
+}
@@ -0,0 +1,109 @@
+package admin
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/gin-gonic/gin"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+type ImageStorage struct {
+	Name        string `json:"name"`
+	SourceImage string `json:"sourceImage"`
+	// LogicalBytes is the size of every blob of the image, including blobs shared with other images
+	LogicalBytes int64 `json:"logicalBytes"`
+	// UniqueBytes is the size of the blobs of the image that no other image uses, i.e. the space freed once the image
+	// is removed from the cache and garbage collected
+	UniqueBytes int64 `json:"uniqueBytes"`
+}
+
+type StorageReport struct {
+	// StoredBytes is the size of the blobs of every cached image, shared blobs being counted once. The filesystem usage
+	// of the registry volume above it is reclaimable by garbage collection.
+	StoredBytes int64 `json:"storedBytes"`
+	// LogicalBytes is the sum of the logical sizes of every cached image
+	LogicalBytes int64 `json:"logicalBytes"`
+	// Images are the top images by unique bytes
+	Images []ImageStorage `json:"images"`
+	// Unavailable lists the cached images that couldn't be inspected in the registry
+	Unavailable []string `json:"unavailable,omitempty"`
+}
+
+// storageReport computes how much space cached images use in the registry, deduplicating blobs shared between images
+func storageReport(cachedImages []kuikv1alpha1.CachedImage, imageBlobs func(string) (map[v1.Hash]int64, error)) *StorageReport {
+	report := &StorageReport{Images: []ImageStorage{}}
+	blobsByImage := map[string]map[v1.Hash]int64{}
+	references := map[v1.Hash]int{}
+	sizes := map[v1.Hash]int64{}
+
+	for _, cachedImage := range cachedImages {
+		if !cachedImage.Status.IsCached {
+			continue
+		}
+		blobs, err := imageBlobs(cachedImage.Spec.SourceImage)
+		if err != nil {
+			report.Unavailable = append(report.Unavailable, cachedImage.Name)
+			continue
+		}
+		blobsByImage[cachedImage.Name] = blobs
+		for digest, size := range blobs {
+			references[digest]++
+			sizes[digest] = size
+		}
+	}
+
+	for _, size := range sizes {
+		report.StoredBytes += size
+	}
+
+	for _, cachedImage := range cachedImages {
+		blobs, ok := blobsByImage[cachedImage.Name]
+		if !ok {
+			continue
+		}
+		image := ImageStorage{Name: cachedImage.Name, SourceImage: cachedImage.Spec.SourceImage}
+		for digest, size := range blobs {
+			image.LogicalBytes += size
+			if references[digest] == 1 {
+				image.UniqueBytes += size
+			}
+		}
+		report.LogicalBytes += image.LogicalBytes
+		report.Images = append(report.Images, image)
+	}
+
+	sort.SliceStable(report.Images, func(i, j int) bool {
+		if report.Images[i].UniqueBytes != report.Images[j].UniqueBytes {
+			return report.Images[i].UniqueBytes > report.Images[j].UniqueBytes
+		}
+		return report.Images[i].Name < report.Images[j].Name
+	})
+
+	return report
+}
+
+// exportStorage reports the space used in the registry by cached images, listing the ?top=N (10 by default) images
+// freeing the most space once removed from the cache
+func (s *Server) exportStorage(c *gin.Context) {
+	top, err := strconv.Atoi(c.DefaultQuery("top", "10"))
+	if err != nil || top <= 0 {
+		c.String(http.StatusBadRequest, "top must be a positive integer")
+		return
+	}
+
+	var cachedImages kuikv1alpha1.CachedImageList
+	if err := s.k8sClient.List(c, &cachedImages); err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	report := storageReport(cachedImages.Items, s.imageBlobs)
+	if len(report.Images) > top {
+		report.Images = report.Images[:top]
+	}
+
+	c.JSON(http.StatusOK, report)
+}
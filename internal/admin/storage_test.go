@@ -0,0 +1,80 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func hash(hex string) v1.Hash {
+	return v1.Hash{Algorithm: "sha256", Hex: hex}
+}
+
+func Test_storageReport(t *testing.T) {
+	g := NewWithT(t)
+
+	cachedImage := func(name string, sourceImage string, isCached bool) kuikv1alpha1.CachedImage {
+		return kuikv1alpha1.CachedImage{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: sourceImage},
+			Status:     kuikv1alpha1.CachedImageStatus{IsCached: isCached},
+		}
+	}
+	cachedImages := []kuikv1alpha1.CachedImage{
+		cachedImage("docker.io-library-nginx-1.25", "nginx:1.25", true),
+		cachedImage("docker.io-library-nginx-1.25-alpine", "nginx:1.25-alpine", true),
+		cachedImage("docker.io-library-alpine-latest", "alpine", true),
+		cachedImage("docker.io-library-redis-7", "redis:7", false),
+		cachedImage("docker.io-library-missing-latest", "missing", true),
+	}
+
+	report := storageReport(cachedImages, func(image string) (map[v1.Hash]int64, error) {
+		switch image {
+		case "nginx:1.25":
+			return map[v1.Hash]int64{hash("manifest1"): 1, hash("base"): 100, hash("nginx"): 50}, nil
+		case "nginx:1.25-alpine":
+			return map[v1.Hash]int64{hash("manifest2"): 1, hash("base"): 100, hash("nginx-alpine"): 20}, nil
+		case "alpine":
+			return map[v1.Hash]int64{hash("manifest3"): 1, hash("alpine"): 3}, nil
+		}
+		return nil, errors.New("not found")
+	})
+
+	g.Expect(report.StoredBytes).To(BeEquivalentTo(176))
+	g.Expect(report.LogicalBytes).To(BeEquivalentTo(276))
+	g.Expect(report.Unavailable).To(Equal([]string{"docker.io-library-missing-latest"}))
+	g.Expect(report.Images).To(Equal([]ImageStorage{
+		{Name: "docker.io-library-nginx-1.25", SourceImage: "nginx:1.25", LogicalBytes: 151, UniqueBytes: 51},
+		{Name: "docker.io-library-nginx-1.25-alpine", SourceImage: "nginx:1.25-alpine", LogicalBytes: 121, UniqueBytes: 21},
+		{Name: "docker.io-library-alpine-latest", SourceImage: "alpine", LogicalBytes: 4, UniqueBytes: 4},
+	}))
+}
+
+func Test_exportStorage(t *testing.T) {
+	g := NewWithT(t)
+	server := newTestServer()
+	server.imageBlobs = func(image string) (map[v1.Hash]int64, error) {
+		return map[v1.Hash]int64{hash(image): 10}, nil
+	}
+
+	recorder := httptest.NewRecorder()
+	server.engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/storage?top=1", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusOK))
+
+	report := StorageReport{}
+	g.Expect(json.Unmarshal(recorder.Body.Bytes(), &report)).To(Succeed())
+	g.Expect(report.StoredBytes).To(BeEquivalentTo(10))
+	g.Expect(report.Images).To(HaveLen(1))
+	g.Expect(report.Images[0].SourceImage).To(Equal("nginx:1.25"))
+
+	recorder = httptest.NewRecorder()
+	server.engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/storage?top=0", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+}
@@ -0,0 +1,89 @@
+package admin
+
+import (
+	"encoding/csv"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/gin-gonic/gin"
+)
+
+type ImageUsage struct {
+	Name         string     `json:"name"`
+	SourceImage  string     `json:"sourceImage"`
+	Repository   string     `json:"repository"`
+	IsCached     bool       `json:"isCached"`
+	PodsCount    int        `json:"podsCount"`
+	PullCount    int64      `json:"pullCount"`
+	LastPulledAt *time.Time `json:"lastPulledAt,omitempty"`
+}
+
+var usageCSVHeader = []string{"name", "sourceImage", "repository", "isCached", "podsCount", "pullCount", "lastPulledAt"}
+
+func (u *ImageUsage) csvRecord() []string {
+	lastPulledAt := ""
+	if u.LastPulledAt != nil {
+		lastPulledAt = u.LastPulledAt.UTC().Format(time.RFC3339)
+	}
+
+	return []string{
+		u.Name,
+		u.SourceImage,
+		u.Repository,
+		strconv.FormatBool(u.IsCached),
+		strconv.Itoa(u.PodsCount),
+		strconv.FormatInt(u.PullCount, 10),
+		lastPulledAt,
+	}
+}
+
+// exportUsage lists pull statistics of every CachedImage, most pulled first, as JSON or as CSV with ?format=csv
+func (s *Server) exportUsage(c *gin.Context) {
+	var cachedImages kuikv1alpha1.CachedImageList
+	if err := s.k8sClient.List(c, &cachedImages); err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	usages := make([]ImageUsage, 0, len(cachedImages.Items))
+	for _, cachedImage := range cachedImages.Items {
+		usage := ImageUsage{
+			Name:        cachedImage.Name,
+			SourceImage: cachedImage.Spec.SourceImage,
+			Repository:  cachedImage.Labels[kuikv1alpha1.RepositoryLabelName],
+			IsCached:    cachedImage.Status.IsCached,
+			PodsCount:   cachedImage.Status.UsedBy.Count,
+			PullCount:   cachedImage.Status.Usage.PullCount,
+		}
+		if lastPulledAt := cachedImage.Status.Usage.LastPulledAt; lastPulledAt != nil {
+			usage.LastPulledAt = &lastPulledAt.Time
+		}
+		usages = append(usages, usage)
+	}
+
+	sort.SliceStable(usages, func(i, j int) bool {
+		if usages[i].PullCount != usages[j].PullCount {
+			return usages[i].PullCount > usages[j].PullCount
+		}
+		return usages[i].Name < usages[j].Name
+	})
+
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		c.JSON(http.StatusOK, usages)
+	case "csv":
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", `attachment; filename="image-usage.csv"`)
+		writer := csv.NewWriter(c.Writer)
+		_ = writer.Write(usageCSVHeader)
+		for _, usage := range usages {
+			_ = writer.Write(usage.csvRecord())
+		}
+		writer.Flush()
+	default:
+		c.String(http.StatusBadRequest, "unsupported format, use json or csv")
+	}
+}
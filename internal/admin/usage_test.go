@@ -0,0 +1,69 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/events"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var lastPulledAt = metav1.NewTime(time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC))
+
+func newTestServer() *Server {
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(
+		&kuikv1alpha1.CachedImage{
+			ObjectMeta: metav1.ObjectMeta{Name: "docker.io-library-alpine-latest"},
+			Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: "alpine"},
+			Status: kuikv1alpha1.CachedImageStatus{
+				Usage: kuikv1alpha1.Usage{PullCount: 1},
+			},
+		},
+		&kuikv1alpha1.CachedImage{
+			ObjectMeta: metav1.ObjectMeta{Name: "docker.io-library-nginx-1.25"},
+			Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: "nginx:1.25"},
+			Status: kuikv1alpha1.CachedImageStatus{
+				IsCached: true,
+				Usage:    kuikv1alpha1.Usage{PullCount: 42, LastPulledAt: &lastPulledAt},
+			},
+		},
+	).Build()
+
+	return New(k8sClient, events.NewBroker(), ":0")
+}
+
+func Test_exportUsage(t *testing.T) {
+	g := NewWithT(t)
+	server := newTestServer()
+
+	recorder := httptest.NewRecorder()
+	server.engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/usage", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusOK))
+
+	usages := []ImageUsage{}
+	g.Expect(json.Unmarshal(recorder.Body.Bytes(), &usages)).To(Succeed())
+	g.Expect(usages).To(HaveLen(2))
+	g.Expect(usages[0].SourceImage).To(Equal("nginx:1.25"))
+	g.Expect(usages[0].PullCount).To(BeEquivalentTo(42))
+	g.Expect(usages[1].LastPulledAt).To(BeNil())
+
+	recorder = httptest.NewRecorder()
+	server.engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/usage?format=csv", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusOK))
+	g.Expect(recorder.Body.String()).To(Equal(
+		"name,sourceImage,repository,isCached,podsCount,pullCount,lastPulledAt\n" +
+			"docker.io-library-nginx-1.25,nginx:1.25,,true,0,42,2024-01-01T08:00:00Z\n" +
+			"docker.io-library-alpine-latest,alpine,,false,0,1,\n",
+	))
+
+	recorder = httptest.NewRecorder()
+	server.engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/usage?format=xml", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+}
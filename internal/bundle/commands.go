@@ -0,0 +1,241 @@
+package bundle
+
+import (
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/controllers"
+	"github.com/enix/kube-image-keeper/internal"
+	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const exportUsage = `Export cached images to a tarball of an OCI image layout, e.g. to seed the cache of an air-gapped cluster or of
+another cluster with "manager import". Images are selected by source image name, or with -all or -l among the images
+in cache. Bundles whose name ends with .gz are gzipped.
+
+Usage:
+  manager export -o <bundle> [flags] [image...]
+
+Flags:
+`
+
+const importUsage = `Import a bundle written by "manager export" in the cache registry, creating the CachedImages of its images so that
+they are served from the cache right away, even in air-gapped mode. Images are checked against the -signature-policy
+and the -allowed-base-registries of the controllers, which must be given to the import as well.
+
+Usage:
+  manager import -i <bundle> [flags]
+
+Flags:
+`
+
+// Commands are the subcommands of the manager, run instead of the controllers, e.g. from a Job
+var Commands = map[string]func(args []string) error{
+	"export": exportCommand,
+	"import": importCommand,
+}
+
+// registryFlags adds the flags reaching the cache registry to a subcommand
+func registryFlags(flags *flag.FlagSet) *string {
+	var registryTLSDir string
+	flags.StringVar(&registry.Endpoint, "registry-endpoint", "kube-image-keeper-registry:5000", "The address of the registry where cached images are stored.")
+	flags.StringVar(&registryTLSDir, "registry-tls-dir", "", "Directory where the client certificates of the registry are mounted, the registry is reached over plain HTTP if empty.")
+	return &registryTLSDir
+}
+
+// newBundleClient returns a Kubernetes client for the subcommands of the manager
+func newBundleClient() (client.Client, error) {
+	config, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return client.New(config, client.Options{Scheme: scheme.NewScheme()})
+}
+
+func exportCommand(args []string) error {
+	var output, selector string
+	var all bool
+
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, exportUsage)
+		flags.PrintDefaults()
+	}
+	registryTLSDir := registryFlags(flags)
+	flags.StringVar(&output, "o", "", "Path of the bundle to write, - for the standard output.")
+	flags.BoolVar(&all, "all", false, "Export every image in cache.")
+	flags.StringVar(&selector, "l", "", "Label selector of the CachedImages to export among the ones in cache.")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if output == "" {
+		return fmt.Errorf("-o is required")
+	}
+	if *registryTLSDir != "" {
+		registry.ConfigureClientTLS(*registryTLSDir)
+	}
+
+	imageNames := flags.Args()
+	if all || selector != "" {
+		labelSelector, err := labels.Parse(selector)
+		if err != nil {
+			return fmt.Errorf("invalid -l: %w", err)
+		}
+
+		k8sClient, err := newBundleClient()
+		if err != nil {
+			return err
+		}
+		var cachedImages kuikv1alpha1.CachedImageList
+		if err := k8sClient.List(context.Background(), &cachedImages, client.MatchingLabelsSelector{Selector: labelSelector}); err != nil {
+			return err
+		}
+		for _, cachedImage := range cachedImages.Items {
+			if cachedImage.Status.IsCached {
+				imageNames = append(imageNames, cachedImage.Spec.SourceImage)
+			}
+		}
+	}
+	if len(imageNames) == 0 {
+		return fmt.Errorf("no image to export")
+	}
+
+	var w io.Writer = os.Stdout
+	var file *os.File
+	if output != "-" {
+		var err error
+		if file, err = os.Create(output); err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	var gw *gzip.Writer
+	if strings.HasSuffix(output, ".gz") {
+		gw = gzip.NewWriter(w)
+		w = gw
+	}
+
+	if err := registry.ExportBundle(w, imageNames); err != nil {
+		return err
+	}
+	if gw != nil {
+		if err := gw.Close(); err != nil {
+			return err
+		}
+	}
+	if file != nil {
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "exported %d images\n", len(imageNames))
+
+	return nil
+}
+
+func importCommand(args []string) error {
+	var input, maxSize, signaturePolicyPath string
+	var createCachedImages, retain, skipPolicies bool
+	var allowedBaseRegistries internal.ArrayFlags
+
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, importUsage)
+		flags.PrintDefaults()
+	}
+	registryTLSDir := registryFlags(flags)
+	flags.StringVar(&input, "i", "", "Path of the bundle to import, - for the standard input.")
+	flags.BoolVar(&createCachedImages, "create-cachedimages", true, "Create the CachedImages of the imported images.")
+	flags.BoolVar(&retain, "retain", false, "Retain the created CachedImages, so that they don't expire while no pod uses them.")
+	flags.StringVar(&maxSize, "max-size", "10Gi", "Maximum size of the bundle, which is extracted to the temporary directory before being imported (0 to disable).")
+	flags.StringVar(&signaturePolicyPath, "signature-policy", "", "Path of the JSON file listing the registries whose images must have a cosign signature verified by their keys or keyless identities, fetched from upstream, to be imported.")
+	flags.Var(&allowedBaseRegistries, "allowed-base-registries", "Registries the base images declared in the provenance attestations of imported images may come from (this flag can be used multiple times, every registry is allowed by default).")
+	flags.IntVar(&registry.BaseImagesPolicy.MaxDepth, "base-images-policy-depth", registry.BaseImagesPolicy.MaxDepth, "How many levels of base images with provenance attestations are checked against -allowed-base-registries.")
+	flags.BoolVar(&skipPolicies, "skip-policies", false, "Import images without checking them against -signature-policy and -allowed-base-registries, e.g. when their signatures can't be fetched from upstream in air-gapped clusters.")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if input == "" {
+		return fmt.Errorf("-i is required")
+	}
+	if *registryTLSDir != "" {
+		registry.ConfigureClientTLS(*registryTLSDir)
+	}
+	options := registry.ImportOptions{SkipPolicies: skipPolicies}
+	var err error
+	if options.MaxSize, err = registry.ParseSize(maxSize); err != nil {
+		return fmt.Errorf("invalid -max-size: %w", err)
+	}
+	registry.BaseImagesPolicy.AllowedRegistries = allowedBaseRegistries
+	if signaturePolicyPath != "" {
+		if registry.ImageSignaturePolicy, err = registry.LoadSignaturePolicy(signaturePolicyPath); err != nil {
+			return fmt.Errorf("invalid signature policy: %w", err)
+		}
+	}
+
+	var k8sClient client.Client
+	if createCachedImages {
+		if k8sClient, err = newBundleClient(); err != nil {
+			return err
+		}
+	}
+
+	var r io.Reader = os.Stdin
+	if input != "-" {
+		file, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	imageNames, err := registry.ImportBundle(r, options)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "imported %d images\n", len(imageNames))
+
+	if !createCachedImages {
+		return nil
+	}
+	for _, imageName := range imageNames {
+		if err := createImportedCachedImage(context.Background(), k8sClient, imageName, retain); err != nil {
+			return fmt.Errorf("could not create the CachedImage of %s: %w", imageName, err)
+		}
+	}
+
+	return nil
+}
+
+// createImportedCachedImage creates the CachedImage of an imported image, existing ones being left untouched
+func createImportedCachedImage(ctx context.Context, k8sClient client.Client, imageName string, retain bool) error {
+	cachedImage, err := controllers.CachedImageFromSourceImage(imageName)
+	if err != nil {
+		return err
+	}
+	cachedImage.Spec.Retain = retain
+
+	if err := k8sClient.Create(ctx, cachedImage); apierrors.IsAlreadyExists(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "created CachedImage %s\n", cachedImage.Name)
+
+	return nil
+}
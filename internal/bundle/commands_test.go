@@ -0,0 +1,36 @@
+package bundle
+
+import (
+	"context"
+	"testing"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_createImportedCachedImage(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	existing := &kuikv1alpha1.CachedImage{
+		ObjectMeta: metav1.ObjectMeta{Name: "docker.io-library-redis-7"},
+		Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: "redis:7", Priority: 10},
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(existing).Build()
+
+	g.Expect(createImportedCachedImage(ctx, k8sClient, "alpine:3.19", true)).To(Succeed())
+	cachedImage := &kuikv1alpha1.CachedImage{}
+	g.Expect(k8sClient.Get(ctx, client.ObjectKey{Name: "docker.io-library-alpine-3.19"}, cachedImage)).To(Succeed())
+	g.Expect(cachedImage.Spec.SourceImage).To(Equal("alpine:3.19"))
+	g.Expect(cachedImage.Spec.Retain).To(BeTrue())
+
+	// Existing CachedImages are left untouched
+	g.Expect(createImportedCachedImage(ctx, k8sClient, "redis:7", true)).To(Succeed())
+	g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(existing), cachedImage)).To(Succeed())
+	g.Expect(cachedImage.Spec.Retain).To(BeFalse())
+	g.Expect(cachedImage.Spec.Priority).To(BeEquivalentTo(10))
+}
@@ -0,0 +1,77 @@
+package distro
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Distribution is the Kubernetes distribution a node runs
+type Distribution string
+
+const (
+	Generic  Distribution = "generic"
+	K3s      Distribution = "k3s"
+	MicroK8s Distribution = "microk8s"
+
+	// microK8sClusterLabel is set on every node of MicroK8s clusters
+	microK8sClusterLabel = "microk8s.io/cluster"
+	// serviceLBLabel is set on the pods of the ServiceLB (klipper-lb) DaemonSets of k3s, one per LoadBalancer Service
+	serviceLBLabel = "svccontroller.k3s.cattle.io/svcname"
+)
+
+// containerdConfigTemplates are the paths of the containerd configuration templates of distributions that generate the
+// configuration of their embedded containerd, overwriting any change made to it directly
+var containerdConfigTemplates = map[Distribution]string{
+	K3s:      "/var/lib/rancher/k3s/agent/etc/containerd/config.toml.tmpl",
+	MicroK8s: "/var/snap/microk8s/current/args/containerd-template.toml",
+}
+
+// Detect returns the distribution of a node, from its labels and from the version of its kubelet, e.g. v1.28.4+k3s2
+func Detect(node *corev1.Node) Distribution {
+	if _, ok := node.Labels[microK8sClusterLabel]; ok {
+		return MicroK8s
+	}
+	if strings.Contains(node.Status.NodeInfo.KubeletVersion, "+k3s") {
+		return K3s
+	}
+	return Generic
+}
+
+// ContainerdConfigTemplate returns the path of the template the containerd configuration of the distribution is
+// generated from, or an empty string if containerd is configured directly
+func (d Distribution) ContainerdConfigTemplate() string {
+	return containerdConfigTemplates[d]
+}
+
+// ReservedHostPorts returns the host ports of a node that are taken without being bound, which can't be detected when
+// listening on them. On k3s, ServiceLB redirects the ports of LoadBalancer Services with iptables rules, so that the
+// proxy would listen on them successfully while pulls are sent to the Service.
+func ReservedHostPorts(ctx context.Context, k8sClient client.Client, node *corev1.Node) ([]int, error) {
+	if Detect(node) != K3s {
+		return nil, nil
+	}
+
+	pods := &corev1.PodList{}
+	if err := k8sClient.List(ctx, pods, client.HasLabels{serviceLBLabel}); err != nil {
+		return nil, err
+	}
+
+	ports := []int{}
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName != node.Name {
+			continue
+		}
+		for _, container := range pod.Spec.Containers {
+			for _, port := range container.Ports {
+				if port.HostPort > 0 {
+					ports = append(ports, int(port.HostPort))
+				}
+			}
+		}
+	}
+
+	return ports, nil
+}
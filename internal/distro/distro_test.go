@@ -0,0 +1,95 @@
+package distro
+
+import (
+	"context"
+	"testing"
+
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name           string
+		labels         map[string]string
+		kubeletVersion string
+		expected       Distribution
+	}{
+		{
+			name:           "Generic",
+			kubeletVersion: "v1.28.0",
+			expected:       Generic,
+		},
+		{
+			name:           "k3s",
+			kubeletVersion: "v1.28.4+k3s2",
+			expected:       K3s,
+		},
+		{
+			name:           "MicroK8s",
+			labels:         map[string]string{"microk8s.io/cluster": "true"},
+			kubeletVersion: "v1.28.3",
+			expected:       MicroK8s,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			node := &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Labels: tt.labels},
+				Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{KubeletVersion: tt.kubeletVersion}},
+			}
+			g.Expect(Detect(node)).To(Equal(tt.expected))
+		})
+	}
+
+	g := NewWithT(t)
+	g.Expect(Generic.ContainerdConfigTemplate()).To(BeEmpty())
+	g.Expect(K3s.ContainerdConfigTemplate()).To(Equal("/var/lib/rancher/k3s/agent/etc/containerd/config.toml.tmpl"))
+}
+
+func serviceLBPod(name string, nodeName string, hostPort int32) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "kube-system",
+			Name:      name,
+			Labels:    map[string]string{"svccontroller.k3s.cattle.io/svcname": "traefik"},
+		},
+		Spec: corev1.PodSpec{
+			NodeName: nodeName,
+			Containers: []corev1.Container{
+				{Name: "lb", Ports: []corev1.ContainerPort{{ContainerPort: hostPort, HostPort: hostPort}}},
+			},
+		},
+	}
+}
+
+func TestReservedHostPorts(t *testing.T) {
+	g := NewWithT(t)
+
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(
+		serviceLBPod("svclb-traefik-a", "node-a", 7439),
+		serviceLBPod("svclb-traefik-b", "node-b", 443),
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "other"},
+			Spec: corev1.PodSpec{
+				NodeName:   "node-a",
+				Containers: []corev1.Container{{Name: "other", Ports: []corev1.ContainerPort{{ContainerPort: 80, HostPort: 80}}}},
+			},
+		},
+	).Build()
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+		Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{KubeletVersion: "v1.28.4+k3s2"}},
+	}
+	g.Expect(ReservedHostPorts(context.Background(), k8sClient, node)).To(Equal([]int{7439}))
+
+	// ServiceLB only runs on k3s
+	node.Status.NodeInfo.KubeletVersion = "v1.28.4"
+	g.Expect(ReservedHostPorts(context.Background(), k8sClient, node)).To(BeEmpty())
+}
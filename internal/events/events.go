@@ -0,0 +1,93 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// subscriberBufferSize is the number of events kept for a subscriber that doesn't keep up before events are dropped
+const subscriberBufferSize = 256
+
+type Type string
+
+const (
+	// Cached is published when an image is put in cache or refreshed
+	Cached Type = "cached"
+	// Served is published when an image has been pulled through the proxy
+	Served Type = "served"
+	// Expired is published when an unused image expires
+	Expired Type = "expired"
+	// Failed is published when an image could not be cached, refreshed, expired or removed from cache
+	Failed Type = "failed"
+)
+
+func (t Type) IsValid() bool {
+	switch t {
+	case Cached, Served, Expired, Failed:
+		return true
+	}
+	return false
+}
+
+// Event is a cache lifecycle event
+type Event struct {
+	Type        Type      `json:"type"`
+	Time        time.Time `json:"time"`
+	CachedImage string    `json:"cachedImage"`
+	SourceImage string    `json:"sourceImage,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+	Message     string    `json:"message,omitempty"`
+	// Pulls is the number of pulls through the proxy of a Served event
+	Pulls int64 `json:"pulls,omitempty"`
+}
+
+// Broker fans out cache lifecycle events to subscribers. Events are dropped for subscribers that don't keep up so that
+// publishers are never blocked.
+type Broker struct {
+	mutex       sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		subscribers: map[chan Event]struct{}{},
+	}
+}
+
+// Publish sends event to every subscriber, it is a no-op on a nil Broker
+func (b *Broker) Publish(event Event) {
+	if b == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for subscriber := range b.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving published events and a function to call to unsubscribe
+func (b *Broker) Subscribe() (<-chan Event, func()) {
+	subscriber := make(chan Event, subscriberBufferSize)
+
+	b.mutex.Lock()
+	b.subscribers[subscriber] = struct{}{}
+	b.mutex.Unlock()
+
+	return subscriber, func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		if _, ok := b.subscribers[subscriber]; ok {
+			delete(b.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestBroker(t *testing.T) {
+	g := NewWithT(t)
+	broker := NewBroker()
+
+	first, unsubscribeFirst := broker.Subscribe()
+	second, unsubscribeSecond := broker.Subscribe()
+	defer unsubscribeSecond()
+
+	broker.Publish(Event{Type: Cached, CachedImage: "a"})
+	g.Expect((<-first).CachedImage).To(Equal("a"))
+	event := <-second
+	g.Expect(event.CachedImage).To(Equal("a"))
+	g.Expect(event.Time).ToNot(BeZero())
+
+	unsubscribeFirst()
+	unsubscribeFirst()
+	g.Expect(first).To(BeClosed())
+
+	// Slow subscribers don't block publishers
+	for i := 0; i < subscriberBufferSize+1; i++ {
+		broker.Publish(Event{Type: Served, CachedImage: "b"})
+	}
+	g.Expect(second).To(HaveLen(subscriberBufferSize))
+
+	var nilBroker *Broker
+	nilBroker.Publish(Event{Type: Cached})
+}
+
+// annotationsRecorder records the annotations of the events it records
+type annotationsRecorder struct {
+	*record.FakeRecorder
+	annotations []map[string]string
+}
+
+func (r *annotationsRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.annotations = append(r.annotations, annotations)
+	r.FakeRecorder.AnnotatedEventf(object, annotations, eventtype, reason, messageFmt, args...)
+}
+
+func TestRecorder(t *testing.T) {
+	g := NewWithT(t)
+
+	fakeRecorder := &annotationsRecorder{FakeRecorder: record.NewFakeRecorder(10)}
+	recorder := NewRecorder(fakeRecorder)
+	cachedImage := &kuikv1alpha1.CachedImage{
+		ObjectMeta: metav1.ObjectMeta{Name: "docker.io-library-alpine-latest"},
+		Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: "alpine"},
+	}
+
+	recorder.Eventf(cachedImage, "Warning", "CacheFailed", "Failed to cache image %s, reason: %s", "alpine", "unauthorized")
+	recorder.Event(cachedImage, "Normal", "Expired", "Image expired, 100% unused")
+	recorder.Event(&corev1.Pod{}, "Warning", "CacheFailed", "not a CachedImage")
+	g.Expect(fakeRecorder.Events).To(HaveLen(3))
+	g.Expect(<-fakeRecorder.Events).To(Equal("Warning CacheFailed Failed to cache image alpine, reason: unauthorized"))
+	g.Expect(<-fakeRecorder.Events).To(Equal("Normal Expired Image expired, 100% unused"))
+
+	// Only the events of CachedImages are annotated with their source image
+	g.Expect(fakeRecorder.annotations).To(Equal([]map[string]string{
+		{SourceImageAnnotation: "alpine"},
+		{SourceImageAnnotation: "alpine"},
+	}))
+}
+
+func TestWatcherPublishEvent(t *testing.T) {
+	g := NewWithT(t)
+	broker := NewBroker()
+	subscription, unsubscribe := broker.Subscribe()
+	defer unsubscribe()
+
+	watcher := &Watcher{Broker: broker, startedAt: time.Now()}
+	kubernetesEvent := &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Annotations: map[string]string{SourceImageAnnotation: "alpine"}},
+		InvolvedObject: corev1.ObjectReference{Kind: "CachedImage", Name: "docker.io-library-alpine-latest"},
+		Reason:         "CacheFailed",
+		Message:        "Failed to cache image alpine, reason: unauthorized",
+		Count:          1,
+		LastTimestamp:  metav1.NewTime(watcher.startedAt.Add(time.Second)),
+	}
+
+	watcher.publishEvent(nil, kubernetesEvent)
+	g.Expect(subscription).To(HaveLen(1))
+	event := <-subscription
+	g.Expect(event.Type).To(Equal(Failed))
+	g.Expect(event.CachedImage).To(Equal("docker.io-library-alpine-latest"))
+	g.Expect(event.SourceImage).To(Equal("alpine"))
+	g.Expect(event.Message).To(Equal("Failed to cache image alpine, reason: unauthorized"))
+	g.Expect(event.Time).To(Equal(kubernetesEvent.LastTimestamp.Time))
+
+	// Events recorded again are published again, not events updated otherwise
+	recordedAgain := kubernetesEvent.DeepCopy()
+	recordedAgain.Count = 2
+	watcher.publishEvent(kubernetesEvent, recordedAgain)
+	watcher.publishEvent(recordedAgain, recordedAgain)
+	g.Expect(subscription).To(HaveLen(1))
+	<-subscription
+
+	// Events recorded before the watcher started, not lifecycle events or events of other objects are not published
+	oldEvent := kubernetesEvent.DeepCopy()
+	oldEvent.LastTimestamp = metav1.NewTime(watcher.startedAt.Add(-time.Minute))
+	watcher.publishEvent(nil, oldEvent)
+	startedEvent := kubernetesEvent.DeepCopy()
+	startedEvent.Reason = "CacheStarted"
+	watcher.publishEvent(nil, startedEvent)
+	podEvent := kubernetesEvent.DeepCopy()
+	podEvent.InvolvedObject.Kind = "Pod"
+	watcher.publishEvent(nil, podEvent)
+	g.Expect(subscription).To(BeEmpty())
+}
+
+func TestWatcherPublishServed(t *testing.T) {
+	g := NewWithT(t)
+	broker := NewBroker()
+	subscription, unsubscribe := broker.Subscribe()
+	defer unsubscribe()
+
+	lastPulledAt := metav1.Now()
+	oldCachedImage := &kuikv1alpha1.CachedImage{
+		ObjectMeta: metav1.ObjectMeta{Name: "docker.io-library-alpine-latest"},
+		Status:     kuikv1alpha1.CachedImageStatus{Usage: kuikv1alpha1.Usage{PullCount: 2}},
+	}
+	newCachedImage := oldCachedImage.DeepCopy()
+	newCachedImage.Status.Usage = kuikv1alpha1.Usage{PullCount: 5, LastPulledAt: &lastPulledAt}
+
+	watcher := &Watcher{Broker: broker}
+	watcher.publishServed(oldCachedImage, oldCachedImage)
+	g.Expect(subscription).To(BeEmpty())
+
+	watcher.publishServed(oldCachedImage, newCachedImage)
+	g.Expect(subscription).To(HaveLen(1))
+	event := <-subscription
+	g.Expect(event.Type).To(Equal(Served))
+	g.Expect(event.Pulls).To(BeEquivalentTo(3))
+	g.Expect(event.Time).To(Equal(lastPulledAt.Time))
+}
@@ -0,0 +1,71 @@
+package events
+
+import (
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// SourceImageAnnotation is the annotation of the Kubernetes events recorded on CachedImages holding their source image,
+// which is published with the cache lifecycle events even once the CachedImage has been deleted
+const SourceImageAnnotation = "kuik.enix.io/source-image"
+
+// reasonTypes maps reasons of the Kubernetes events recorded by the controllers to cache lifecycle events
+var reasonTypes = map[string]Type{
+	"CacheSucceeded": Cached,
+	"Prefetched":     Cached,
+	"Refreshed":      Cached,
+	"Expired":        Expired,
+	"CacheFailed":    Failed,
+	"PrefetchFailed": Failed,
+	"RefreshFailed":  Failed,
+	"ExpiringFailed": Failed,
+	"CleanupFailed":  Failed,
+}
+
+// Recorder is a record.EventRecorder annotating the events it records on CachedImages with their source image, for the
+// Watcher to publish them as cache lifecycle events
+type Recorder struct {
+	record.EventRecorder
+}
+
+func NewRecorder(recorder record.EventRecorder) record.EventRecorder {
+	return &Recorder{EventRecorder: recorder}
+}
+
+func (r *Recorder) Event(object runtime.Object, eventtype, reason, message string) {
+	if annotations := eventAnnotations(object, nil); annotations != nil {
+		r.EventRecorder.AnnotatedEventf(object, annotations, eventtype, reason, "%s", message)
+		return
+	}
+	r.EventRecorder.Event(object, eventtype, reason, message)
+}
+
+func (r *Recorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	if annotations := eventAnnotations(object, nil); annotations != nil {
+		r.EventRecorder.AnnotatedEventf(object, annotations, eventtype, reason, messageFmt, args...)
+		return
+	}
+	r.EventRecorder.Eventf(object, eventtype, reason, messageFmt, args...)
+}
+
+func (r *Recorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	if cachedImageAnnotations := eventAnnotations(object, annotations); cachedImageAnnotations != nil {
+		annotations = cachedImageAnnotations
+	}
+	r.EventRecorder.AnnotatedEventf(object, annotations, eventtype, reason, messageFmt, args...)
+}
+
+// eventAnnotations returns annotations with the source image of object if it is a CachedImage, nil otherwise
+func eventAnnotations(object runtime.Object, annotations map[string]string) map[string]string {
+	cachedImage, ok := object.(*kuikv1alpha1.CachedImage)
+	if !ok {
+		return nil
+	}
+
+	eventAnnotations := map[string]string{SourceImageAnnotation: cachedImage.Spec.SourceImage}
+	for key, value := range annotations {
+		eventAnnotations[key] = value
+	}
+	return eventAnnotations
+}
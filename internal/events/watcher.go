@@ -0,0 +1,163 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// Watcher publishes cache lifecycle events to a Broker from the Kubernetes events recorded on CachedImages and from the
+// pulls recorded in their status. Since it only watches the API server, it runs on every replica, so that the admin API
+// of any replica streams the events of the controllers running on the leader.
+type Watcher struct {
+	Broker *Broker
+	config *rest.Config
+	scheme *runtime.Scheme
+	mapper meta.RESTMapper
+	// cachedImages are the informers of the manager, which already watch CachedImages
+	cachedImages cache.Informers
+	// startedAt is when the watcher started, Kubernetes events recorded before are not published again
+	startedAt time.Time
+}
+
+func NewWatcher(mgr manager.Manager, broker *Broker) *Watcher {
+	return &Watcher{
+		Broker:       broker,
+		config:       mgr.GetConfig(),
+		scheme:       mgr.GetScheme(),
+		mapper:       mgr.GetRESTMapper(),
+		cachedImages: mgr.GetCache(),
+	}
+}
+
+//+kubebuilder:rbac:groups=core,resources=events,verbs=list;watch
+
+// Start watches Kubernetes events and CachedImages until ctx is done
+func (w *Watcher) Start(ctx context.Context) error {
+	w.startedAt = time.Now()
+
+	// Only the Kubernetes events of CachedImages are watched
+	informers, err := cache.New(w.config, cache.Options{
+		Scheme: w.scheme,
+		Mapper: w.mapper,
+		SelectorsByObject: cache.SelectorsByObject{
+			&corev1.Event{}: {Field: fields.OneTermEqualSelector("involvedObject.kind", "CachedImage")},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	eventInformer, err := informers.GetInformer(ctx, &corev1.Event{})
+	if err != nil {
+		return err
+	}
+	if _, err := eventInformer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if event, ok := obj.(*corev1.Event); ok {
+				w.publishEvent(nil, event)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldEvent, ok := oldObj.(*corev1.Event)
+			if !ok {
+				return
+			}
+			if newEvent, ok := newObj.(*corev1.Event); ok {
+				w.publishEvent(oldEvent, newEvent)
+			}
+		},
+	}); err != nil {
+		return err
+	}
+
+	cachedImageInformer, err := w.cachedImages.GetInformer(ctx, &kuikv1alpha1.CachedImage{})
+	if err != nil {
+		return err
+	}
+	if _, err := cachedImageInformer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldCachedImage, ok := oldObj.(*kuikv1alpha1.CachedImage)
+			if !ok {
+				return
+			}
+			if newCachedImage, ok := newObj.(*kuikv1alpha1.CachedImage); ok {
+				w.publishServed(oldCachedImage, newCachedImage)
+			}
+		},
+	}); err != nil {
+		return err
+	}
+
+	return informers.Start(ctx)
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, the admin API of every replica streams events
+func (w *Watcher) NeedLeaderElection() bool {
+	return false
+}
+
+// publishEvent publishes the cache lifecycle event of a Kubernetes event recorded on a CachedImage, when it is
+// recorded after the watcher started or again since oldEvent, the last version of the event seen, if any
+func (w *Watcher) publishEvent(oldEvent *corev1.Event, event *corev1.Event) {
+	eventType, ok := reasonTypes[event.Reason]
+	if !ok || event.InvolvedObject.Kind != "CachedImage" {
+		return
+	}
+
+	eventTime := kubernetesEventTime(event)
+	if oldEvent == nil && eventTime.Before(w.startedAt.Truncate(time.Second)) {
+		return
+	}
+	if oldEvent != nil && event.Count <= oldEvent.Count {
+		return
+	}
+
+	w.Broker.Publish(Event{
+		Type:        eventType,
+		Time:        eventTime,
+		CachedImage: event.InvolvedObject.Name,
+		SourceImage: event.Annotations[SourceImageAnnotation],
+		Reason:      event.Reason,
+		Message:     event.Message,
+	})
+}
+
+// publishServed publishes a Served event when the proxy records new pulls in the status of a CachedImage
+func (w *Watcher) publishServed(oldCachedImage *kuikv1alpha1.CachedImage, cachedImage *kuikv1alpha1.CachedImage) {
+	pulls := cachedImage.Status.Usage.PullCount - oldCachedImage.Status.Usage.PullCount
+	if pulls <= 0 {
+		return
+	}
+
+	event := Event{
+		Type:        Served,
+		CachedImage: cachedImage.Name,
+		SourceImage: cachedImage.Spec.SourceImage,
+		Pulls:       pulls,
+	}
+	if lastPulledAt := cachedImage.Status.Usage.LastPulledAt; lastPulledAt != nil {
+		event.Time = lastPulledAt.Time
+	}
+	w.Broker.Publish(event)
+}
+
+// kubernetesEventTime returns the last time a Kubernetes event has been recorded, whose timestamps depend on the API
+// used to record it
+func kubernetesEventTime(event *corev1.Event) time.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	}
+	return event.CreationTimestamp.Time
+}
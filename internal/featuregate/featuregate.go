@@ -0,0 +1,148 @@
+package featuregate
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Feature is the name of a feature that can be enabled or disabled with the -feature-gates flag
+type Feature string
+
+// Stage is the maturity of a feature, following the Kubernetes conventions: alpha features are disabled by default and
+// may change or be removed, beta features are usually enabled by default and GA features are always enabled
+type Stage string
+
+const (
+	Alpha = Stage("ALPHA")
+	Beta  = Stage("BETA")
+	GA    = Stage("")
+)
+
+// FeatureSpec is the default value and the stage of a feature
+type FeatureSpec struct {
+	Default bool
+	Stage   Stage
+}
+
+const (
+	// DigestRewriting rewrites images referenced by digest, which are currently left untouched
+	DigestRewriting Feature = "DigestRewriting"
+	// PushThrough lets clients push images to the cache through the proxy
+	PushThrough Feature = "PushThrough"
+	// P2P lets proxies serve layers from the local store of other nodes
+	P2P Feature = "P2P"
+)
+
+var defaultFeatures = map[Feature]FeatureSpec{
+	DigestRewriting: {Default: false, Stage: Alpha},
+	PushThrough:     {Default: false, Stage: Alpha},
+	P2P:             {Default: false, Stage: Alpha},
+}
+
+// Gates are the feature gates of the current process, set with the -feature-gates flag
+var Gates = New(defaultFeatures)
+
+// FeatureGate tells which features are enabled, it implements flag.Value to be set from a comma separated list of
+// feature=bool pairs, e.g. "DigestRewriting=true,P2P=false"
+type FeatureGate struct {
+	mutex   sync.RWMutex
+	known   map[Feature]FeatureSpec
+	enabled map[Feature]bool
+}
+
+func New(known map[Feature]FeatureSpec) *FeatureGate {
+	return &FeatureGate{
+		known:   known,
+		enabled: map[Feature]bool{},
+	}
+}
+
+// Enabled tells whether a feature is enabled, unknown features being disabled
+func (g *FeatureGate) Enabled(feature Feature) bool {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	if enabled, ok := g.enabled[feature]; ok {
+		return enabled
+	}
+	return g.known[feature].Default
+}
+
+// Set enables or disables the features of a comma separated list of feature=bool pairs. It fails without changing
+// anything if a feature is unknown, if a value is not a boolean or if a GA feature is disabled.
+func (g *FeatureGate) Set(value string) error {
+	enabled := map[Feature]bool{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, val, found := strings.Cut(pair, "=")
+		if !found {
+			return fmt.Errorf("missing bool value for feature gate %s", key)
+		}
+		feature := Feature(strings.TrimSpace(key))
+		spec, ok := g.known[feature]
+		if !ok {
+			return fmt.Errorf("unrecognized feature gate: %s", feature)
+		}
+		boolValue, err := strconv.ParseBool(strings.TrimSpace(val))
+		if err != nil {
+			return fmt.Errorf("invalid value of %s=%s, err: %w", feature, val, err)
+		}
+		if spec.Stage == GA && !boolValue {
+			return fmt.Errorf("feature gate %s is GA and can't be disabled", feature)
+		}
+		enabled[feature] = boolValue
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for feature, boolValue := range enabled {
+		g.enabled[feature] = boolValue
+	}
+
+	return nil
+}
+
+func (g *FeatureGate) String() string {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	pairs := []string{}
+	for feature, enabled := range g.enabled {
+		pairs = append(pairs, fmt.Sprintf("%s=%t", feature, enabled))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Features tells whether each known feature is enabled
+func (g *FeatureGate) Features() map[Feature]bool {
+	features := map[Feature]bool{}
+	for feature := range g.known {
+		features[feature] = g.Enabled(feature)
+	}
+	return features
+}
+
+// KnownFeatures returns a description of every known feature, e.g. to document the -feature-gates flag
+func (g *FeatureGate) KnownFeatures() []string {
+	features := []string{}
+	for feature, spec := range g.known {
+		if spec.Stage == GA {
+			continue
+		}
+		features = append(features, fmt.Sprintf("%s=true|false (%s - default=%t)", feature, spec.Stage, spec.Default))
+	}
+	sort.Strings(features)
+	return features
+}
+
+// Usage is the usage of the -feature-gates flag
+func (g *FeatureGate) Usage() string {
+	return "A set of key=value pairs that describe feature gates for alpha/beta features. Options are:\n" + strings.Join(g.KnownFeatures(), "\n")
+}
@@ -0,0 +1,96 @@
+package featuregate
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestFeatureGate_Set(t *testing.T) {
+	known := map[Feature]FeatureSpec{
+		"AlphaFeature": {Default: false, Stage: Alpha},
+		"BetaFeature":  {Default: true, Stage: Beta},
+		"GAFeature":    {Default: true, Stage: GA},
+	}
+
+	tests := []struct {
+		name     string
+		value    string
+		expected map[Feature]bool
+		wantErr  string
+	}{
+		{
+			name:     "Defaults",
+			value:    "",
+			expected: map[Feature]bool{"AlphaFeature": false, "BetaFeature": true, "GAFeature": true, "Unknown": false},
+		},
+		{
+			name:     "Enable and disable",
+			value:    "AlphaFeature=true, BetaFeature=false",
+			expected: map[Feature]bool{"AlphaFeature": true, "BetaFeature": false, "GAFeature": true},
+		},
+		{
+			name:     "Unknown feature",
+			value:    "AlphaFeature=true,Unknown=true",
+			expected: map[Feature]bool{"AlphaFeature": false},
+			wantErr:  "unrecognized feature gate: Unknown",
+		},
+		{
+			name:     "Invalid value",
+			value:    "AlphaFeature=maybe",
+			expected: map[Feature]bool{"AlphaFeature": false},
+			wantErr:  "invalid value of AlphaFeature=maybe",
+		},
+		{
+			name:    "Missing value",
+			value:   "AlphaFeature",
+			wantErr: "missing bool value for feature gate AlphaFeature",
+		},
+		{
+			name:     "Disable GA feature",
+			value:    "GAFeature=false",
+			expected: map[Feature]bool{"GAFeature": true},
+			wantErr:  "feature gate GAFeature is GA and can't be disabled",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			gate := New(known)
+			err := gate.Set(tt.value)
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.wantErr)))
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+			for feature, enabled := range tt.expected {
+				g.Expect(gate.Enabled(feature)).To(Equal(enabled), string(feature))
+			}
+		})
+	}
+}
+
+func TestFeatureGate_KnownFeatures(t *testing.T) {
+	g := NewWithT(t)
+	g.Expect(Gates.KnownFeatures()).To(Equal([]string{
+		"DigestRewriting=true|false (ALPHA - default=false)",
+		"P2P=true|false (ALPHA - default=false)",
+		"PushThrough=true|false (ALPHA - default=false)",
+	}))
+
+	gate := New(defaultFeatures)
+	g.Expect(gate.Set("P2P=true,DigestRewriting=false")).To(Succeed())
+	g.Expect(gate.String()).To(Equal("DigestRewriting=false,P2P=true"))
+}
+
+func TestFeatureGate_Features(t *testing.T) {
+	g := NewWithT(t)
+	gate := New(map[Feature]FeatureSpec{
+		"AlphaFeature": {Default: false, Stage: Alpha},
+		"BetaFeature":  {Default: true, Stage: Beta},
+	})
+	g.Expect(gate.Set("AlphaFeature=true")).To(Succeed())
+
+	g.Expect(gate.Features()).To(Equal(map[Feature]bool{"AlphaFeature": true, "BetaFeature": true}))
+}
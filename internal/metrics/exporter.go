@@ -56,7 +56,10 @@ func (e *Exporter) Listen() error {
 
 func (e *Exporter) Serve() error {
 	mux := http.NewServeMux()
-	mux.Handle("/metrics", promhttp.Handler())
+	// OpenMetrics is required to expose exemplars, native histograms are negotiated by scrapers supporting them
+	mux.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+		EnableOpenMetrics: true,
+	})))
 
 	e.server = &http.Server{
 		Handler: mux,
@@ -0,0 +1,160 @@
+package prefetch
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronHorizon bounds the search of the next time matching a cron expression, which may never match (e.g. February 30)
+const cronHorizon = 5 * 366 * 24 * time.Hour
+
+// cronDescriptors are the shorthands of common cron expressions
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronFields are the bounds of the fields of a cron expression, in order
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// Cron is a standard cron expression with 5 fields (minute, hour, day of month, month and day of week), evaluated in
+// UTC. Fields hold a value, a range (1-5), a step (*/15 or 0-30/10) or a list of them (1,15), Sunday is 0 or 7.
+type Cron struct {
+	minute, hour, dayOfMonth, month, dayOfWeek uint64
+	// anyDayOfMonth and anyDayOfWeek tell whether the day fields are *, in which case a day must only match the other
+	// one instead of any of both
+	anyDayOfMonth, anyDayOfWeek bool
+}
+
+// ParseCron parses a cron expression, or one of the @yearly, @monthly, @weekly, @daily and @hourly shorthands
+func ParseCron(expression string) (*Cron, error) {
+	if descriptor, ok := cronDescriptors[strings.TrimSpace(expression)]; ok {
+		expression = descriptor
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("invalid cron expression %q: expected %d fields, got %d", expression, len(cronFields), len(fields))
+	}
+
+	bits := make([]uint64, len(fields))
+	for i, field := range fields {
+		var err error
+		if bits[i], err = parseCronField(field, cronFields[i].min, cronFields[i].max); err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: invalid %s: %w", expression, cronFields[i].name, err)
+		}
+	}
+
+	cron := &Cron{
+		minute:        bits[0],
+		hour:          bits[1],
+		dayOfMonth:    bits[2],
+		month:         bits[3],
+		dayOfWeek:     bits[4],
+		anyDayOfMonth: fields[2] == "*",
+		anyDayOfWeek:  fields[4] == "*",
+	}
+	if cron.dayOfWeek&(1<<7) != 0 {
+		cron.dayOfWeek |= 1 << time.Sunday
+	}
+
+	return cron, nil
+}
+
+// parseCronField returns the values of a field of a cron expression as a bit set
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		valueRange, stepValue, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepValue); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepValue)
+			}
+		}
+
+		low, high := min, max
+		if valueRange != "*" {
+			lowValue, highValue, isRange := strings.Cut(valueRange, "-")
+			var err error
+			if low, err = strconv.Atoi(lowValue); err != nil {
+				return 0, fmt.Errorf("invalid value %q", lowValue)
+			}
+			high = low
+			if isRange {
+				if high, err = strconv.Atoi(highValue); err != nil {
+					return 0, fmt.Errorf("invalid value %q", highValue)
+				}
+			} else if hasStep {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q is out of range %d-%d", valueRange, min, max)
+		}
+
+		for value := low; value <= high; value += step {
+			bits |= 1 << value
+		}
+	}
+
+	return bits, nil
+}
+
+// Next returns the first time strictly after the given one matching the expression, or the zero time if it never does
+func (c *Cron) Next(after time.Time) time.Time {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(cronHorizon)
+
+	for t.Before(limit) {
+		if c.month&(1<<t.Month()) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if c.hour&(1<<t.Hour()) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if c.minute&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
+
+// matchesDay tells whether the day of t matches the expression, days matching either of the day fields when both are
+// restricted as cron does
+func (c *Cron) matchesDay(t time.Time) bool {
+	dayOfMonth := c.dayOfMonth&(1<<t.Day()) != 0
+	dayOfWeek := c.dayOfWeek&(1<<t.Weekday()) != 0
+
+	if !c.anyDayOfMonth && !c.anyDayOfWeek {
+		return dayOfMonth || dayOfWeek
+	}
+	return dayOfMonth && dayOfWeek
+}
@@ -0,0 +1,81 @@
+package prefetch
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, expression := range []string{"* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@reboot"} {
+		t.Run(expression, func(t *testing.T) {
+			g := NewWithT(t)
+			_, err := ParseCron(expression)
+			g.Expect(err).To(HaveOccurred())
+		})
+	}
+}
+
+func TestCron_Next(t *testing.T) {
+	tests := []struct {
+		expression string
+		after      time.Time
+		expected   time.Time
+	}{
+		{
+			expression: "*/15 * * * *",
+			after:      monday,
+			expected:   time.Date(2024, 1, 1, 8, 30, 0, 0, time.UTC),
+		},
+		{
+			expression: "30 2 * * *",
+			after:      monday,
+			expected:   time.Date(2024, 1, 2, 2, 30, 0, 0, time.UTC),
+		},
+		{
+			expression: "0 6 * * 1-5",
+			after:      time.Date(2024, 1, 5, 7, 0, 0, 0, time.UTC),
+			expected:   time.Date(2024, 1, 8, 6, 0, 0, 0, time.UTC),
+		},
+		{
+			expression: "0 0 * * 7",
+			after:      monday,
+			expected:   time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			expression: "0 0 13 * 5",
+			after:      monday,
+			expected:   time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			expression: "0 0 29 2 *",
+			after:      monday,
+			expected:   time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			expression: "@monthly",
+			after:      time.Date(2024, 12, 15, 0, 0, 0, 0, time.UTC),
+			expected:   time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			expression: "20 8 * * *",
+			after:      monday,
+			expected:   time.Date(2024, 1, 2, 8, 20, 0, 0, time.UTC),
+		},
+		{
+			expression: "0 0 30 2 *",
+			after:      monday,
+			expected:   time.Time{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			g := NewWithT(t)
+			cron, err := ParseCron(tt.expression)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(cron.Next(tt.after)).To(Equal(tt.expected))
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package prefetch
+
+import (
+	"time"
+)
+
+// Quota is an amount of manifests or bytes pulled from upstream registries allowed per time window, of which Remaining
+// are left until ResetAt
+type Quota struct {
+	Name      string
+	Amount    int64
+	Remaining int64
+	Window    time.Duration
+	ResetAt   time.Time
+	// Bytes tells whether the quota limits bytes rather than manifests
+	Bytes bool
+	// Registry restricts the quota to the images of a registry, e.g. for its rate limit
+	Registry string
+}
+
+// PlannedImage is an image to put in cache, with the estimated number of manifests and bytes pulled to cache it
+type PlannedImage struct {
+	Image     string
+	Registry  string
+	Manifests int64
+	Bytes     int64
+}
+
+// Batch is a set of images put in cache together once StartAt is reached
+type Batch struct {
+	StartAt   time.Time
+	Images    []string
+	Manifests int64
+	Bytes     int64
+}
+
+// PlanBatches splits images into batches fitting in what is left of the quotas at their start, the first batch
+// starting at now and the following ones once the quotas they would exceed are reset. Images are kept in order, an
+// image exceeding a whole quota on its own being put in a batch of its own. Images are put in a single batch if there
+// are no quotas.
+func PlanBatches(images []PlannedImage, quotas []Quota, now time.Time) []Batch {
+	quotas = append([]Quota{}, quotas...)
+	batches := []Batch{}
+	batch := Batch{StartAt: now}
+
+	for _, image := range images {
+		if !fitsIn(image, quotas) && len(batch.Images) > 0 {
+			batches = append(batches, batch)
+			batch = Batch{StartAt: resetExceeded(image, quotas, batch.StartAt)}
+		}
+
+		for i := range quotas {
+			quotas[i].Remaining -= quotaCost(image, quotas[i])
+			if quotas[i].Remaining < 0 {
+				quotas[i].Remaining = 0
+			}
+		}
+		batch.Images = append(batch.Images, image.Image)
+		batch.Manifests += image.Manifests
+		batch.Bytes += image.Bytes
+	}
+
+	if len(batch.Images) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+func quotaCost(image PlannedImage, quota Quota) int64 {
+	if quota.Registry != "" && quota.Registry != image.Registry {
+		return 0
+	}
+	if quota.Bytes {
+		return image.Bytes
+	}
+	return image.Manifests
+}
+
+func fitsIn(image PlannedImage, quotas []Quota) bool {
+	for _, quota := range quotas {
+		if quotaCost(image, quota) > quota.Remaining {
+			return false
+		}
+	}
+	return true
+}
+
+// resetExceeded resets the quotas image doesn't fit in at the latest of their reset times, along with every quota
+// resetting by then, and returns it
+func resetExceeded(image PlannedImage, quotas []Quota, after time.Time) time.Time {
+	resetAt := after
+	for _, quota := range quotas {
+		if quotaCost(image, quota) > quota.Remaining && quota.ResetAt.After(resetAt) {
+			resetAt = quota.ResetAt
+		}
+	}
+
+	for i := range quotas {
+		if quotas[i].ResetAt.After(resetAt) {
+			continue
+		}
+		quotas[i].Remaining = quotas[i].Amount
+		for !quotas[i].ResetAt.After(resetAt) && quotas[i].Window > 0 {
+			quotas[i].ResetAt = quotas[i].ResetAt.Add(quotas[i].Window)
+		}
+	}
+	return resetAt
+}
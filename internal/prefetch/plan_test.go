@@ -0,0 +1,98 @@
+package prefetch
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestPlanBatches(t *testing.T) {
+	images := []PlannedImage{
+		{Image: "alpine:3.17", Manifests: 2, Bytes: 100},
+		{Image: "alpine:3.18", Manifests: 2, Bytes: 100},
+		{Image: "alpine:3.19", Manifests: 2, Bytes: 100},
+		{Image: "alpine:3.20", Manifests: 2, Bytes: 100},
+		{Image: "alpine:3.21", Manifests: 2, Bytes: 100},
+	}
+
+	tests := []struct {
+		name     string
+		images   []PlannedImage
+		quotas   []Quota
+		expected []Batch
+	}{
+		{
+			name:   "Unlimited",
+			images: images,
+			expected: []Batch{
+				{StartAt: monday, Images: []string{"alpine:3.17", "alpine:3.18", "alpine:3.19", "alpine:3.20", "alpine:3.21"}, Manifests: 10, Bytes: 500},
+			},
+		},
+		{
+			name:     "No images",
+			quotas:   []Quota{{Name: "manifests", Amount: 4, Remaining: 2, Window: time.Hour, ResetAt: monday.Add(time.Hour)}},
+			expected: []Batch{},
+		},
+		{
+			name:   "Manifests",
+			images: images,
+			quotas: []Quota{
+				{Name: "manifests", Amount: 4, Remaining: 2, Window: time.Hour, ResetAt: monday.Add(time.Hour)},
+				{Name: "bytes", Amount: 1000, Remaining: 1000, Window: 24 * time.Hour, ResetAt: monday.Add(24 * time.Hour), Bytes: true},
+			},
+			expected: []Batch{
+				{StartAt: monday, Images: []string{"alpine:3.17"}, Manifests: 2, Bytes: 100},
+				{StartAt: monday.Add(time.Hour), Images: []string{"alpine:3.18", "alpine:3.19"}, Manifests: 4, Bytes: 200},
+				{StartAt: monday.Add(2 * time.Hour), Images: []string{"alpine:3.20", "alpine:3.21"}, Manifests: 4, Bytes: 200},
+			},
+		},
+		{
+			name:   "Bytes and manifests",
+			images: images,
+			quotas: []Quota{
+				{Name: "manifests", Amount: 6, Remaining: 6, Window: time.Hour, ResetAt: monday.Add(time.Hour)},
+				{Name: "bytes", Amount: 250, Remaining: 150, Window: 2 * time.Hour, ResetAt: monday.Add(90 * time.Minute), Bytes: true},
+			},
+			expected: []Batch{
+				{StartAt: monday, Images: []string{"alpine:3.17"}, Manifests: 2, Bytes: 100},
+				{StartAt: monday.Add(90 * time.Minute), Images: []string{"alpine:3.18", "alpine:3.19"}, Manifests: 4, Bytes: 200},
+				{StartAt: monday.Add(210 * time.Minute), Images: []string{"alpine:3.20", "alpine:3.21"}, Manifests: 4, Bytes: 200},
+			},
+		},
+		{
+			name: "Registry rate limit",
+			images: []PlannedImage{
+				{Image: "alpine:3.19", Registry: "index.docker.io", Manifests: 1},
+				{Image: "ghcr.io/enix/shop:v1", Registry: "ghcr.io", Manifests: 1},
+				{Image: "alpine:3.20", Registry: "index.docker.io", Manifests: 1},
+			},
+			quotas: []Quota{{Name: "index.docker.io", Amount: 100, Remaining: 1, Window: 6 * time.Hour, ResetAt: monday.Add(6 * time.Hour), Registry: "index.docker.io"}},
+			expected: []Batch{
+				{StartAt: monday, Images: []string{"alpine:3.19", "ghcr.io/enix/shop:v1"}, Manifests: 2},
+				{StartAt: monday.Add(6 * time.Hour), Images: []string{"alpine:3.20"}, Manifests: 1},
+			},
+		},
+		{
+			name: "Image exceeding a whole quota",
+			images: []PlannedImage{
+				{Image: "alpine:3.19", Manifests: 1, Bytes: 100},
+				{Image: "cuda:12", Manifests: 1, Bytes: 5000},
+				{Image: "alpine:3.20", Manifests: 1, Bytes: 100},
+			},
+			quotas: []Quota{{Name: "bytes", Amount: 1000, Remaining: 1000, Window: time.Hour, ResetAt: monday.Add(time.Hour), Bytes: true}},
+			expected: []Batch{
+				{StartAt: monday, Images: []string{"alpine:3.19"}, Manifests: 1, Bytes: 100},
+				{StartAt: monday.Add(time.Hour), Images: []string{"cuda:12"}, Manifests: 1, Bytes: 5000},
+				{StartAt: monday.Add(2 * time.Hour), Images: []string{"alpine:3.20"}, Manifests: 1, Bytes: 100},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(PlanBatches(tt.images, tt.quotas, monday)).To(Equal(tt.expected))
+		})
+	}
+}
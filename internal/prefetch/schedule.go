@@ -0,0 +1,112 @@
+package prefetch
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const week = 7 * 24 * time.Hour
+
+// Slot is an hour of the week, in UTC, during which an image has been requested.
+type Slot struct {
+	Weekday time.Weekday
+	Hour    int
+}
+
+// SlotOf returns the slot containing t.
+func SlotOf(t time.Time) Slot {
+	t = t.UTC()
+	return Slot{Weekday: t.Weekday(), Hour: t.Hour()}
+}
+
+// ParseSlot parses a slot formatted by Slot.String (e.g. "Mon 08:00").
+func ParseSlot(s string) (Slot, error) {
+	t, err := time.Parse("Mon 15:04", s)
+	if err != nil {
+		return Slot{}, fmt.Errorf("invalid slot %q: %w", s, err)
+	}
+
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		if weekday.String()[:3] == s[:3] {
+			return Slot{Weekday: weekday, Hour: t.Hour()}, nil
+		}
+	}
+
+	return Slot{}, fmt.Errorf("invalid slot %q: unknown weekday", s)
+}
+
+func (s Slot) String() string {
+	return fmt.Sprintf("%s %02d:00", s.Weekday.String()[:3], s.Hour)
+}
+
+// next returns the first start of the slot such that start - lead is strictly after the given time.
+func (s Slot) next(after time.Time, lead time.Duration) time.Time {
+	after = after.UTC()
+	weekStart := time.Date(after.Year(), after.Month(), after.Day()-int(after.Weekday()), 0, 0, 0, 0, time.UTC)
+	start := weekStart.Add(time.Duration(s.Weekday)*24*time.Hour + time.Duration(s.Hour)*time.Hour)
+	for !start.Add(-lead).After(after) {
+		start = start.Add(week)
+	}
+	return start
+}
+
+// Record returns the history updated with a request made at t.
+func Record(history map[string]int, t time.Time) map[string]int {
+	if history == nil {
+		history = map[string]int{}
+	}
+	history[SlotOf(t).String()]++
+	return history
+}
+
+// Prediction is an upcoming time at which an image is expected to be requested.
+type Prediction struct {
+	Slot Slot
+	// Requests is the number of requests recorded during the slot
+	Requests int
+	// At is the start of the next occurrence of the slot
+	At time.Time
+	// PrefetchAt is the time at which the image should be refreshed
+	PrefetchAt time.Time
+}
+
+// Explain returns a human readable reason for the prediction.
+func (p *Prediction) Explain() string {
+	return fmt.Sprintf("requested %d times on %s around %02d:00 UTC, refreshing %s before", p.Requests, p.Slot.Weekday, p.Slot.Hour, p.At.Sub(p.PrefetchAt))
+}
+
+// Predict returns the earliest prediction whose prefetch time is strictly after the given time, considering
+// only slots with at least minRequests recorded requests. It returns nil if the history shows no pattern.
+func Predict(history map[string]int, after time.Time, minRequests int, lead time.Duration) (*Prediction, error) {
+	keys := make([]string, 0, len(history))
+	for key := range history {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var prediction *Prediction
+	for _, key := range keys {
+		requests := history[key]
+		if requests < minRequests {
+			continue
+		}
+
+		slot, err := ParseSlot(key)
+		if err != nil {
+			return nil, err
+		}
+
+		at := slot.next(after, lead)
+		if prediction == nil || at.Before(prediction.At) {
+			prediction = &Prediction{
+				Slot:       slot,
+				Requests:   requests,
+				At:         at,
+				PrefetchAt: at.Add(-lead),
+			}
+		}
+	}
+
+	return prediction, nil
+}
@@ -0,0 +1,112 @@
+package prefetch
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+// 2024-01-01 is a Monday
+var monday = time.Date(2024, 1, 1, 8, 20, 0, 0, time.UTC)
+
+func TestSlot(t *testing.T) {
+	g := NewWithT(t)
+
+	slot := SlotOf(monday)
+	g.Expect(slot).To(Equal(Slot{Weekday: time.Monday, Hour: 8}))
+	g.Expect(slot.String()).To(Equal("Mon 08:00"))
+
+	parsed, err := ParseSlot(slot.String())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(parsed).To(Equal(slot))
+
+	_, err = ParseSlot("Monday")
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestRecord(t *testing.T) {
+	g := NewWithT(t)
+
+	history := Record(nil, monday)
+	history = Record(history, monday.Add(30*time.Minute))
+	history = Record(history, monday.Add(24*time.Hour))
+
+	g.Expect(history).To(Equal(map[string]int{
+		"Mon 08:00": 2,
+		"Tue 08:00": 1,
+	}))
+}
+
+func TestPredict(t *testing.T) {
+	tests := []struct {
+		name               string
+		history            map[string]int
+		after              time.Time
+		expectedNil        bool
+		expectedAt         time.Time
+		expectedExplain    string
+		expectedPrefetchAt time.Time
+	}{
+		{
+			name:        "Empty history",
+			history:     map[string]int{},
+			after:       monday,
+			expectedNil: true,
+		},
+		{
+			name:        "Not enough requests",
+			history:     map[string]int{"Mon 08:00": 1},
+			after:       monday,
+			expectedNil: true,
+		},
+		{
+			name:               "Later this week",
+			history:            map[string]int{"Wed 02:00": 3},
+			after:              monday,
+			expectedAt:         time.Date(2024, 1, 3, 2, 0, 0, 0, time.UTC),
+			expectedPrefetchAt: time.Date(2024, 1, 3, 1, 30, 0, 0, time.UTC),
+			expectedExplain:    "requested 3 times on Wednesday around 02:00 UTC, refreshing 30m0s before",
+		},
+		{
+			name:               "Next week",
+			history:            map[string]int{"Mon 08:00": 2},
+			after:              monday,
+			expectedAt:         time.Date(2024, 1, 8, 8, 0, 0, 0, time.UTC),
+			expectedPrefetchAt: time.Date(2024, 1, 8, 7, 30, 0, 0, time.UTC),
+		},
+		{
+			name:               "Earliest slot wins",
+			history:            map[string]int{"Sat 23:00": 5, "Sun 01:00": 2, "Mon 09:00": 2},
+			after:              monday,
+			expectedAt:         time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC),
+			expectedPrefetchAt: time.Date(2024, 1, 1, 8, 30, 0, 0, time.UTC),
+		},
+		{
+			name:               "Prefetch window across weeks",
+			history:            map[string]int{"Sun 00:00": 2},
+			after:              time.Date(2024, 1, 6, 23, 0, 0, 0, time.UTC),
+			expectedAt:         time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC),
+			expectedPrefetchAt: time.Date(2024, 1, 6, 23, 30, 0, 0, time.UTC),
+		},
+	}
+
+	g := NewWithT(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prediction, err := Predict(tt.history, tt.after, 2, 30*time.Minute)
+			g.Expect(err).ToNot(HaveOccurred())
+			if tt.expectedNil {
+				g.Expect(prediction).To(BeNil())
+				return
+			}
+
+			g.Expect(prediction).ToNot(BeNil())
+			g.Expect(prediction.At).To(Equal(tt.expectedAt))
+			g.Expect(prediction.PrefetchAt).To(Equal(tt.expectedPrefetchAt))
+			if tt.expectedExplain != "" {
+				g.Expect(prediction.Explain()).To(Equal(tt.expectedExplain))
+			}
+		})
+	}
+}
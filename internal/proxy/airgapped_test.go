@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/enix/kube-image-keeper/pkg/registrytest"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/gomega"
+)
+
+func TestAirgapped(t *testing.T) {
+	g := NewWithT(t)
+
+	cache := registrytest.New(t)
+	registry.Endpoint = cache.Addr()
+	cache.PushImage(t, "docker.io/library/alpine:3.19", registrytest.RandomImage(t, 1))
+
+	defer func() { registry.Airgapped = false }()
+	registry.Airgapped = true
+
+	r := gin.New()
+	p := NewWithEngine(dummyK8sClient, r)
+	p.quarantine = NewQuarantine()
+	p.manifestHeads = NewManifestHeads(0)
+	p.Serve()
+	// The reverse proxy requires a real connection to the client
+	server := httptest.NewServer(r)
+	defer server.Close()
+	serve := func(path string) (int, string) {
+		resp, err := server.Client().Get(server.URL + path)
+		g.Expect(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		g.Expect(err).ToNot(HaveOccurred())
+		return resp.StatusCode, string(body)
+	}
+
+	// Cached images are served, others are never pulled from upstream
+	status, _ := serve("/v2/docker.io/library/alpine/manifests/3.19")
+	g.Expect(status).To(Equal(http.StatusOK))
+	status, body := serve("/v2/docker.io/library/nginx/manifests/latest")
+	g.Expect(status).To(Equal(http.StatusNotFound))
+	g.Expect(body).To(Equal(`{"errors":[{"code":"MANIFEST_UNKNOWN","message":"image is not in cache: upstream registries are not reachable in air-gapped mode"}]}`))
+	status, body = serve("/v2/docker.io/library/nginx/blobs/sha256:0000000000000000000000000000000000000000000000000000000000000000")
+	g.Expect(status).To(Equal(http.StatusNotFound))
+	g.Expect(body).To(ContainSubstring("BLOB_UNKNOWN"))
+}
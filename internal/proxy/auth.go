@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/enix/kube-image-keeper/internal/pulltoken"
+	"golang.org/x/crypto/bcrypt"
+	"k8s.io/klog/v2"
+)
+
+// dummyHash is compared to the password of unknown users, so that they can't be told apart from known users by the
+// response time
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("kube-image-keeper"), bcrypt.DefaultCost)
+
+// Htpasswd authenticates users against the bcrypt hashed passwords of an htpasswd file, e.g. generated with
+// "htpasswd -B"
+type Htpasswd struct {
+	users map[string][]byte
+}
+
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseHtpasswd(file)
+}
+
+func ParseHtpasswd(reader io.Reader) (*Htpasswd, error) {
+	htpasswd := &Htpasswd{users: map[string][]byte{}}
+
+	scanner := bufio.NewScanner(reader)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("invalid htpasswd entry at line %d", lineNumber)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("invalid htpasswd entry for user %q, only bcrypt passwords are supported: %w", user, err)
+		}
+		htpasswd.users[user] = []byte(hash)
+	}
+
+	return htpasswd, scanner.Err()
+}
+
+func (h *Htpasswd) Authenticate(user string, password string) bool {
+	hash, ok := h.users[user]
+	if !ok {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+// PullTokenUser is the user to authenticate as with a pull token as password
+const PullTokenUser = "token"
+
+var pullPathRegexp = regexp.MustCompile("^/v2/(.+)/(manifests|blobs)/([^/]+)$")
+
+// BasicAuth requires clients of a handler to authenticate with the credentials of a user of htpasswd, or with a pull
+// token as password of PullTokenUser or as a bearer token. Pull tokens only allow to pull the image they have been
+// issued for. htpasswd and tokens are optional. Credentials are not forwarded to registries.
+func BasicAuth(htpasswd *Htpasswd, tokens *pulltoken.Signer, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(r, htpasswd, tokens) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="kube-image-keeper"`)
+			w.Header().Set(apiVersionHeader, "registry/2.0")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = io.WriteString(w, `{"errors":[{"code":"UNAUTHORIZED","message":"authentication required"}]}`)
+			return
+		}
+
+		r.Header.Del("Authorization")
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func authorize(r *http.Request, htpasswd *Htpasswd, tokens *pulltoken.Signer) bool {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return tokens != nil && pullTokenAllows(tokens, token, r)
+	}
+
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	if user == PullTokenUser && tokens != nil {
+		return pullTokenAllows(tokens, password, r)
+	}
+
+	if htpasswd == nil || !htpasswd.Authenticate(user, password) {
+		klog.InfoS("authentication failed", "user", user, "remoteAddr", r.RemoteAddr)
+		return false
+	}
+
+	return true
+}
+
+// pullTokenAllows tells whether a pull token allows a request, i.e. a registry ping, a pull of the manifests of the
+// image the token has been issued for or of blobs of its repository. Blobs can't be related to an image without reading
+// its manifests, but their digests can only be known from the manifests of the image.
+func pullTokenAllows(tokens *pulltoken.Signer, token string, r *http.Request) bool {
+	scope, err := tokens.Verify(token)
+	if err != nil {
+		klog.InfoS("pull token rejected", "error", err, "remoteAddr", r.RemoteAddr)
+		return false
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if r.URL.Path == "/v2" || r.URL.Path == "/v2/" {
+		return true
+	}
+
+	subMatches := pullPathRegexp.FindStringSubmatch(r.URL.Path)
+	if subMatches == nil {
+		return false
+	}
+	// The repository of the token is compared to the one of the request the way the proxy routes it, since the port of
+	// its registry is sanitized in rewritten images
+	repository, err := proxiedRepository(subMatches[1])
+	if err != nil {
+		return false
+	}
+	if scope.Repository, err = proxiedRepository(scope.Repository); err != nil {
+		return false
+	}
+
+	if subMatches[2] == "manifests" {
+		return scope.AllowsManifest(repository, subMatches[3])
+	}
+	return repository == scope.Repository
+}
+
+// proxiedRepository returns the repository a pull of the given repository through the proxy is routed to, e.g.
+// enix.io:5000/app for enix.io:5000/app or enix.io-5000/app, the port of the registry being sanitized in rewritten images
+func proxiedRepository(repository string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(repository)
+	if err != nil {
+		return "", err
+	}
+	domain := reference.Domain(named)
+	ref, err := reference.ParseAnyReference(strings.Replace(named.Name(), domain, strings.ReplaceAll(domain, ":", "-"), 1))
+	if err != nil {
+		return "", err
+	}
+
+	originRegistry, path, _ := strings.Cut(ref.String(), "/")
+	return handleOriginRegistryPort(originRegistry) + "/" + path, nil
+}
@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/enix/kube-image-keeper/internal/pulltoken"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestHtpasswd(g *WithT) *Htpasswd {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cr3t"), bcrypt.MinCost)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	htpasswd, err := ParseHtpasswd(strings.NewReader("# CI runners\nci:" + string(hash) + "\n\n"))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	return htpasswd
+}
+
+func TestParseHtpasswd(t *testing.T) {
+	g := NewWithT(t)
+
+	htpasswd := newTestHtpasswd(g)
+	g.Expect(htpasswd.Authenticate("ci", "s3cr3t")).To(BeTrue())
+	g.Expect(htpasswd.Authenticate("ci", "wrong")).To(BeFalse())
+	g.Expect(htpasswd.Authenticate("unknown", "s3cr3t")).To(BeFalse())
+
+	_, err := ParseHtpasswd(strings.NewReader("ci:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g="))
+	g.Expect(err).To(HaveOccurred())
+
+	_, err = ParseHtpasswd(strings.NewReader("no separator"))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestBasicAuth(t *testing.T) {
+	g := NewWithT(t)
+
+	var forwardedAuthorization string
+	pullTokens, err := pulltoken.NewSigner([]byte("0123456789abcdef"))
+	g.Expect(err).ToNot(HaveOccurred())
+	nginx := pulltoken.Scope{Repository: "docker.io/library/nginx", Manifests: []string{"1.25", "sha256:0123"}}
+	nginxToken, err := pullTokens.Issue(nginx, time.Now().Add(time.Hour))
+	g.Expect(err).ToNot(HaveOccurred())
+	expiredToken, err := pullTokens.Issue(nginx, time.Now().Add(-time.Hour))
+	g.Expect(err).ToNot(HaveOccurred())
+	// Scopes hold the repositories of images as issued by the admin API, with the port of their registry
+	portToken, err := pullTokens.Issue(pulltoken.Scope{Repository: "enix.io:5000/app", Manifests: []string{"v1"}}, time.Now().Add(time.Hour))
+	g.Expect(err).ToNot(HaveOccurred())
+	hostPortToken, err := pullTokens.Issue(pulltoken.Scope{Repository: "host:5000/app", Manifests: []string{"v1"}}, time.Now().Add(time.Hour))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	handler := BasicAuth(newTestHtpasswd(g), pullTokens, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwardedAuthorization = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		user           string
+		password       string
+		bearer         string
+		expectedStatus int
+	}{
+		{
+			name:           "No credentials",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Wrong password",
+			user:           "ci",
+			password:       "wrong",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Valid credentials",
+			user:           "ci",
+			password:       "s3cr3t",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Pull token as password",
+			path:           "/v2/docker.io/library/nginx/manifests/1.25",
+			user:           PullTokenUser,
+			password:       nginxToken,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Pull token as bearer token",
+			path:           "/v2/nginx/blobs/sha256:0000000000000000000000000000000000000000000000000000000000000000",
+			bearer:         nginxToken,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Pull token for a digest of its image",
+			path:           "/v2/docker.io/library/nginx/manifests/sha256:0123",
+			bearer:         nginxToken,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Pull token for another tag",
+			path:           "/v2/docker.io/library/nginx/manifests/1.24",
+			bearer:         nginxToken,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Pull token for another repository",
+			path:           "/v2/docker.io/library/alpine/manifests/latest",
+			bearer:         nginxToken,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Pull token for a registry with a port",
+			path:           "/v2/enix.io-5000/app/manifests/v1",
+			bearer:         portToken,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Pull token for a blob of a registry with a port",
+			path:           "/v2/enix.io-5000/app/blobs/sha256:0000000000000000000000000000000000000000000000000000000000000000",
+			bearer:         portToken,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Pull token for a registry with a port and without domain",
+			path:           "/v2/host-5000/app/manifests/v1",
+			bearer:         hostPortToken,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Pull token for a registry with another port",
+			path:           "/v2/enix.io-5001/app/manifests/v1",
+			bearer:         portToken,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Pull token used to push",
+			method:         http.MethodPut,
+			path:           "/v2/docker.io/library/nginx/manifests/1.25",
+			bearer:         nginxToken,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Expired pull token",
+			path:           "/v2/docker.io/library/nginx/manifests/1.25",
+			user:           PullTokenUser,
+			password:       expiredToken,
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			method, path := tt.method, tt.path
+			if method == "" {
+				method = http.MethodGet
+			}
+			if path == "" {
+				path = "/v2/"
+			}
+			request := httptest.NewRequest(method, path, nil)
+			if tt.user != "" {
+				request.SetBasicAuth(tt.user, tt.password)
+			}
+			if tt.bearer != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			g.Expect(recorder.Code).To(Equal(tt.expectedStatus))
+			if tt.expectedStatus == http.StatusUnauthorized {
+				g.Expect(recorder.Header().Get("WWW-Authenticate")).To(Equal(`Basic realm="kube-image-keeper"`))
+			} else {
+				g.Expect(forwardedAuthorization).To(BeEmpty())
+			}
+		})
+	}
+}
+
+func TestWithBasicAuth(t *testing.T) {
+	g := NewWithT(t)
+
+	// Credentials are only sent in clear to loopback addresses
+	_, err := (&Proxy{}).WithBasicAuth(":7440", nil, nil, "", "")
+	g.Expect(err).To(MatchError(ContainSubstring("not a loopback address")))
+	_, err = (&Proxy{}).WithBasicAuth("127.0.0.1:7440", nil, nil, "", "")
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = (&Proxy{}).WithBasicAuth("localhost:7440", nil, nil, "", "")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	p, err := (&Proxy{}).WithBasicAuth(":7440", nil, nil, "/etc/kuik/basic-auth-tls/tls.crt", "/etc/kuik/basic-auth-tls/tls.key")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(p.basicAuthTLSCert).To(Equal("/etc/kuik/basic-auth-tls/tls.crt"))
+	_, err = (&Proxy{}).WithBasicAuth(":7440", nil, nil, "/etc/kuik/basic-auth-tls/tls.crt", "")
+	g.Expect(err).To(HaveOccurred())
+}
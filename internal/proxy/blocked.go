@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/registry"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// RefuseBlockedImages makes the proxy refuse to serve the manifests of images blocked by their vulnerability scan,
+// neither from the cache nor from their origin registry
+var RefuseBlockedImages = false
+
+// imageIsBlocked tells whether a manifest pulled from the given path of an image is the one of an image blocked by its
+// vulnerability scan. Manifests pulled by tag are looked up by the name of their CachedImage, manifests pulled by digest
+// among the CachedImages of their repository, so that a blocked image can't be pulled by digest instead. CachedImages
+// are looked up through the lookup cache, and images whose CachedImage can't be looked up are not blocked.
+func (p *Proxy) imageIsBlocked(ctx context.Context, method string, image string, path string) bool {
+	cachedImageName := pulledCachedImageName(method, image, path)
+	if cachedImageName == "" {
+		return false
+	}
+
+	key := "blocked/" + cachedImageName
+	lookup := func() (*ImageLookup, error) {
+		ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+		defer cancel()
+
+		var cachedImage kuikv1alpha1.CachedImage
+		if err := p.k8sClient.Get(ctx, types.NamespacedName{Name: cachedImageName}, &cachedImage); err != nil {
+			if client.IgnoreNotFound(err) == nil {
+				return &ImageLookup{}, nil
+			}
+			return nil, err
+		}
+		return &ImageLookup{CachedImage: &cachedImage}, nil
+	}
+	if digest := strings.TrimPrefix(path, "manifests/"); strings.Contains(digest, ":") && method == http.MethodGet {
+		key = "blocked/" + image + "@" + digest
+		lookup = func() (*ImageLookup, error) {
+			return p.lookupBlockedDigest(ctx, image, digest)
+		}
+	}
+
+	result, err := p.lookups.Get(key, lookup)
+	if err != nil {
+		klog.InfoS("could not look up CachedImage, not blocking it", "image", image, "path", path, "error", err)
+	}
+	if result == nil || result.CachedImage == nil {
+		return false
+	}
+
+	imageScan := result.CachedImage.Status.Scan
+	return imageScan != nil && imageScan.Blocked
+}
+
+// lookupBlockedDigest looks up a CachedImage of the repository of an image blocked by the scan of the given digest
+func (p *Proxy) lookupBlockedDigest(ctx context.Context, image string, digest string) (*ImageLookup, error) {
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+
+	var cachedImages kuikv1alpha1.CachedImageList
+	if err := p.k8sClient.List(ctx, &cachedImages, client.MatchingLabels{
+		kuikv1alpha1.RepositoryLabelName: registry.RepositoryLabel(image),
+	}); err != nil {
+		return nil, err
+	}
+
+	for _, cachedImage := range cachedImages.Items {
+		if imageScan := cachedImage.Status.Scan; imageScan != nil && imageScan.Blocked && imageScan.Digest == digest {
+			return &ImageLookup{CachedImage: &cachedImage}, nil
+		}
+	}
+	return &ImageLookup{}, nil
+}
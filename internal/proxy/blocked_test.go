@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRefuseBlockedImages(t *testing.T) {
+	g := NewWithT(t)
+
+	defer func() { RefuseBlockedImages = false }()
+	RefuseBlockedImages = true
+
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(&kuikv1alpha1.CachedImage{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "docker.io-library-nginx-1.25",
+			Labels: map[string]string{kuikv1alpha1.RepositoryLabelName: registry.RepositoryLabel("docker.io/library/nginx")},
+		},
+		Spec: kuikv1alpha1.CachedImageSpec{SourceImage: "nginx:1.25"},
+		Status: kuikv1alpha1.CachedImageStatus{
+			IsCached: true,
+			Scan:     &kuikv1alpha1.ImageScan{Digest: "sha256:01", Critical: 3, Blocked: true},
+		},
+	}).Build()
+	p := NewWithEngine(k8sClient, gin.New())
+
+	g.Expect(p.imageIsBlocked(context.Background(), http.MethodGet, "docker.io/library/nginx", "manifests/1.25")).To(BeTrue())
+	g.Expect(p.imageIsBlocked(context.Background(), http.MethodGet, "docker.io/library/redis", "manifests/7")).To(BeFalse())
+
+	// Blocked images can't be pulled by digest either
+	g.Expect(p.imageIsBlocked(context.Background(), http.MethodGet, "docker.io/library/nginx", "manifests/sha256:01")).To(BeTrue())
+	g.Expect(p.imageIsBlocked(context.Background(), http.MethodGet, "docker.io/library/nginx", "manifests/sha256:02")).To(BeFalse())
+	g.Expect(p.imageIsBlocked(context.Background(), http.MethodGet, "docker.io/library/nginx", "blobs/sha256:01")).To(BeFalse())
+
+	r := gin.New()
+	NewWithEngine(k8sClient, r).Serve()
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/v2/docker.io/library/nginx/manifests/1.25", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusForbidden))
+	g.Expect(recorder.Body.String()).To(Equal(`{"errors":[{"code":"DENIED","message":"image docker.io/library/nginx is blocked by its vulnerability scan"}]}`))
+}
@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/enix/kube-image-keeper/internal/metrics"
 	"github.com/prometheus/client_golang/prometheus"
@@ -10,8 +11,13 @@ import (
 const subsystem = "proxy"
 
 type Collector struct {
-	httpCall *prometheus.CounterVec
-	info     prometheus.Collector
+	httpCall         *prometheus.CounterVec
+	manifestRequests *prometheus.CounterVec
+	imageRequests    *prometheus.CounterVec
+	requestDuration  *prometheus.HistogramVec
+	corruptedBlobs   prometheus.Counter
+	inFlight         prometheus.Gauge
+	info             prometheus.Collector
 }
 
 func NewCollector() *Collector {
@@ -25,20 +31,116 @@ func NewCollector() *Collector {
 			},
 			[]string{"registry", "statusCode", "cacheHit"},
 		),
+		manifestRequests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: metrics.Namespace,
+				Subsystem: subsystem,
+				Name:      "manifest_requests_total",
+				Help:      "How many manifest requests have been handled, by method (HEAD for checks, GET for pulls) and by source they have been served from (memory, cache or origin)",
+			},
+			[]string{"method", "source"},
+		),
+		imageRequests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: metrics.Namespace,
+				Subsystem: subsystem,
+				Name:      "requests_total",
+				Help:      "How many manifest and blob requests of images have been served, by image (repository), type (manifest or blob) and origin they have been served from (cache or upstream)",
+			},
+			[]string{"image", "type", "origin"},
+		),
+		requestDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: metrics.Namespace,
+				Subsystem: subsystem,
+				Name:      "request_duration_seconds",
+				Help:      "How long serving manifest and blob requests of images took, including the transfer of blobs, by type (manifest or blob) and origin they have been served from (cache or upstream)",
+				Buckets:   prometheus.ExponentialBuckets(0.005, 4, 8),
+				// Exposed as a native histogram as well to scrapers supporting them, with a resolution of 10%
+				NativeHistogramBucketFactor:     1.1,
+				NativeHistogramMaxBucketNumber:  160,
+				NativeHistogramMinResetDuration: time.Hour,
+			},
+			[]string{"type", "origin"},
+		),
+		corruptedBlobs: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: metrics.Namespace,
+				Subsystem: subsystem,
+				Name:      "corrupted_blobs_total",
+				Help:      "How many blobs of the cache registry didn't match their digest while being served",
+			},
+		),
+		inFlight: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: metrics.Namespace,
+				Subsystem: subsystem,
+				Name:      inFlightRequestsName,
+				Help:      "How many requests of image pulls are being served",
+			},
+		),
 		info: metrics.NewInfo(subsystem),
 	}
 }
 
 func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 	c.httpCall.Describe(ch)
+	c.manifestRequests.Describe(ch)
+	c.imageRequests.Describe(ch)
+	c.requestDuration.Describe(ch)
+	c.corruptedBlobs.Describe(ch)
+	c.inFlight.Describe(ch)
 	c.info.Describe(ch)
 }
 
 func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 	c.httpCall.Collect(ch)
+	c.manifestRequests.Collect(ch)
+	c.imageRequests.Collect(ch)
+	c.requestDuration.Collect(ch)
+	c.corruptedBlobs.Collect(ch)
+	c.inFlight.Collect(ch)
 	c.info.Collect(ch)
 }
 
 func (c *Collector) IncHTTPCall(registry string, statusCode int, cacheHit bool) {
 	c.httpCall.WithLabelValues(registry, fmt.Sprintf("%d", statusCode), fmt.Sprintf("%t", cacheHit)).Inc()
 }
+
+func (c *Collector) IncManifestRequest(method string, source string) {
+	c.manifestRequests.WithLabelValues(method, source).Inc()
+}
+
+// ObserveImageRequest counts a manifest or blob request of an image served from the cache or from upstream, and
+// observes how long serving it took. The duration is observed with an exemplar linking it to the trace of the request
+// and to the image if traceID is not empty.
+func (c *Collector) ObserveImageRequest(image string, requestType string, origin string, duration time.Duration, traceID string) {
+	c.imageRequests.WithLabelValues(image, requestType, origin).Inc()
+
+	observer := c.requestDuration.WithLabelValues(requestType, origin)
+	if traceID == "" {
+		observer.Observe(duration.Seconds())
+		return
+	}
+	observer.(prometheus.ExemplarObserver).ObserveWithExemplar(duration.Seconds(), exemplarLabels(traceID, image))
+}
+
+// exemplarLabels returns the labels of the exemplar of a request, leaving the image out if the labels would exceed the
+// length allowed by OpenMetrics
+func exemplarLabels(traceID string, image string) prometheus.Labels {
+	labels := prometheus.Labels{"trace_id": traceID}
+	if len("trace_id")+len(traceID)+len("image")+len(image) <= prometheus.ExemplarMaxRunes {
+		labels["image"] = image
+	}
+	return labels
+}
+
+func (c *Collector) IncCorruptedBlob() {
+	c.corruptedBlobs.Inc()
+}
+
+// TrackInFlight counts a request as being served until the returned function is called
+func (c *Collector) TrackInFlight() func() {
+	c.inFlight.Inc()
+	return c.inFlight.Dec
+}
@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/enix/kube-image-keeper/pkg/registrytest"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestImageRequestMetrics(t *testing.T) {
+	g := NewWithT(t)
+
+	cache := registrytest.New(t)
+	registry.Endpoint = cache.Addr()
+	cache.PushImage(t, "docker.io/library/alpine:3.19", registrytest.RandomImage(t, 1))
+
+	r := gin.New()
+	p := NewWithEngine(dummyK8sClient, r)
+	p.collector = NewCollector()
+	p.quarantine = NewQuarantine()
+	p.manifestHeads = NewManifestHeads(0)
+	p.Serve()
+	// The reverse proxy requires a real connection to the client
+	server := httptest.NewServer(r)
+	defer server.Close()
+	serve := func(method string, path string) int {
+		req, err := http.NewRequest(method, server.URL+path, nil)
+		g.Expect(err).ToNot(HaveOccurred())
+		resp, err := server.Client().Do(req)
+		g.Expect(err).ToNot(HaveOccurred())
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	g.Expect(serve(http.MethodGet, "/v2/docker.io/library/alpine/manifests/3.19")).To(Equal(http.StatusOK))
+	g.Expect(serve(http.MethodHead, "/v2/docker.io/library/alpine/manifests/3.19")).To(Equal(http.StatusOK))
+	g.Expect(testutil.ToFloat64(p.collector.imageRequests.WithLabelValues("docker.io/library/alpine", "manifest", "cache"))).To(Equal(2.0))
+	g.Expect(testutil.CollectAndCount(p.collector.requestDuration)).To(Equal(1))
+
+	// Failed requests are not counted
+	g.Expect(serve(http.MethodGet, "/v2/docker.io/library/alpine/manifests/"+strings.Repeat("a", 129))).To(Equal(http.StatusBadRequest))
+	g.Expect(testutil.CollectAndCount(p.collector.imageRequests)).To(Equal(1))
+}
+
+func TestCollector_ObserveImageRequest(t *testing.T) {
+	g := NewWithT(t)
+
+	collector := NewCollector()
+	collector.ObserveImageRequest("docker.io/library/alpine", "blob", "upstream", 3*time.Second, "4bf92f3577b34da6a3ce929d0e0e4736")
+	collector.ObserveImageRequest("docker.io/library/alpine", "blob", "upstream", time.Second, "")
+
+	// Slow pulls are linked to their trace and image by an exemplar
+	gatherer := prometheus.NewRegistry()
+	gatherer.MustRegister(collector.requestDuration)
+	families, err := gatherer.Gather()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(families).To(HaveLen(1))
+	histogram := families[0].GetMetric()[0].GetHistogram()
+	g.Expect(histogram.GetSampleCount()).To(Equal(uint64(2)))
+	g.Expect(histogram.GetSchema()).To(BeNumerically(">", 0))
+	exemplars := map[string]string{}
+	for _, bucket := range histogram.GetBucket() {
+		for _, label := range bucket.GetExemplar().GetLabel() {
+			exemplars[label.GetName()] = label.GetValue()
+		}
+	}
+	g.Expect(exemplars).To(Equal(map[string]string{
+		"image":    "docker.io/library/alpine",
+		"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736",
+	}))
+
+	g.Expect(exemplarLabels("4bf92f3577b34da6a3ce929d0e0e4736", "registry.example.com/"+strings.Repeat("a", 100))).To(Equal(prometheus.Labels{
+		"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736",
+	}))
+}
+
+func Test_imageRequestType(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(imageRequestType("manifests/3.19")).To(Equal("manifest"))
+	g.Expect(imageRequestType("blobs/sha256:01")).To(Equal("blob"))
+	g.Expect(imageRequestType("referrers/sha256:01")).To(BeEmpty())
+}
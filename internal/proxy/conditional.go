@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/enix/kube-image-keeper/internal/registry"
+)
+
+// maxDigestedManifestSize is the size up to which manifests served without a Docker-Content-Digest header are read to
+// compute it, when UpstreamLimits doesn't limit the size of manifests
+const maxDigestedManifestSize = 4 << 20
+
+// manifestETag returns the ETag of a manifest, its quoted digest as sent by the distribution registry
+func manifestETag(digest string) string {
+	return `"` + digest + `"`
+}
+
+// ifNoneMatch tells whether the If-None-Match header of req matches the manifest with the given digest, so that it
+// can be answered with a 304 Not Modified
+func ifNoneMatch(req *http.Request, digest string) bool {
+	if digest == "" {
+		return false
+	}
+	for _, value := range req.Header.Values("If-None-Match") {
+		for _, etag := range strings.Split(value, ",") {
+			etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+			if etag == "*" || strings.Trim(etag, `"`) == digest {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setManifestHeaders makes manifest responses carry a Docker-Content-Digest header, taken from the request when the
+// manifest is referenced by digest or computed from the body otherwise, and an ETag derived from it, so that clients
+// caching manifests can revalidate them
+func setManifestHeaders(req *http.Request, resp *http.Response) error {
+	if resp.StatusCode != http.StatusOK || !strings.Contains(req.URL.Path, "/manifests/") {
+		return nil
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		if reference := req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]; strings.HasPrefix(reference, "sha256:") {
+			digest = reference
+		} else if req.Method == http.MethodGet {
+			var err error
+			if digest, err = digestBody(resp); err != nil {
+				return err
+			}
+		}
+		if digest == "" {
+			return nil
+		}
+		resp.Header.Set("Docker-Content-Digest", digest)
+	}
+	if resp.Header.Get("Etag") == "" {
+		resp.Header.Set("Etag", manifestETag(digest))
+	}
+
+	return nil
+}
+
+// digestBody returns the sha256 digest of the body of a response, which is replaced so that it can still be read, or
+// an empty string if the body is too large to be buffered
+func digestBody(resp *http.Response) (string, error) {
+	maxSize := int64(maxDigestedManifestSize)
+	if registry.UpstreamLimits.MaxManifestSize > 0 {
+		maxSize = registry.UpstreamLimits.MaxManifestSize
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(body)) > maxSize {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return "", nil
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	sum := sha256.Sum256(body)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// answerNotModified turns a manifest response into a 304 Not Modified if it matches the If-None-Match header of the
+// request, for upstream registries that ignore it
+func answerNotModified(req *http.Request, resp *http.Response) {
+	if resp.StatusCode != http.StatusOK || !strings.Contains(req.URL.Path, "/manifests/") || !ifNoneMatch(req, resp.Header.Get("Docker-Content-Digest")) {
+		return
+	}
+
+	resp.Body.Close()
+	resp.Body = http.NoBody
+	resp.ContentLength = 0
+	resp.Header.Del("Content-Length")
+	resp.StatusCode = http.StatusNotModified
+	resp.Status = "304 Not Modified"
+}
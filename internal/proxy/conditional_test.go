@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func Test_ifNoneMatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		ifNoneMatch []string
+		digest      string
+		want        bool
+	}{
+		{name: "no header", digest: "sha256:01", want: false},
+		{name: "matching digest", ifNoneMatch: []string{`"sha256:01"`}, digest: "sha256:01", want: true},
+		{name: "unquoted digest", ifNoneMatch: []string{"sha256:01"}, digest: "sha256:01", want: true},
+		{name: "weak ETag in a list", ifNoneMatch: []string{`"sha256:02", W/"sha256:01"`}, digest: "sha256:01", want: true},
+		{name: "wildcard", ifNoneMatch: []string{"*"}, digest: "sha256:01", want: true},
+		{name: "other digest", ifNoneMatch: []string{`"sha256:02"`}, digest: "sha256:01", want: false},
+		{name: "unknown digest", ifNoneMatch: []string{"*"}, digest: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			req := httptest.NewRequest(http.MethodGet, "/v2/docker.io/library/nginx/manifests/latest", nil)
+			for _, value := range tt.ifNoneMatch {
+				req.Header.Add("If-None-Match", value)
+			}
+			g.Expect(ifNoneMatch(req, tt.digest)).To(Equal(tt.want))
+		})
+	}
+}
+
+func Test_setManifestHeaders(t *testing.T) {
+	g := NewWithT(t)
+
+	response := func(header http.Header, body string) *http.Response {
+		return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(strings.NewReader(body))}
+	}
+
+	// The digest of manifests referenced by tag is computed from their body, which can still be read
+	req := httptest.NewRequest(http.MethodGet, "/v2/docker.io/library/nginx/manifests/latest", nil)
+	resp := response(http.Header{}, "{}")
+	g.Expect(setManifestHeaders(req, resp)).To(Succeed())
+	digest := "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"
+	g.Expect(resp.Header.Get("Docker-Content-Digest")).To(Equal(digest))
+	g.Expect(resp.Header.Get("Etag")).To(Equal(`"` + digest + `"`))
+	body, err := io.ReadAll(resp.Body)
+	g.Expect(err).To(BeNil())
+	g.Expect(string(body)).To(Equal("{}"))
+
+	// The digest of manifests referenced by digest is taken from the request
+	req = httptest.NewRequest(http.MethodHead, "/v2/docker.io/library/nginx/manifests/sha256:01", nil)
+	resp = response(http.Header{}, "")
+	g.Expect(setManifestHeaders(req, resp)).To(Succeed())
+	g.Expect(resp.Header.Get("Docker-Content-Digest")).To(Equal("sha256:01"))
+
+	// Headers sent by the registry are kept
+	req = httptest.NewRequest(http.MethodGet, "/v2/docker.io/library/nginx/manifests/latest", nil)
+	resp = response(http.Header{"Docker-Content-Digest": {"sha256:01"}, "Etag": {`"custom"`}}, "{}")
+	g.Expect(setManifestHeaders(req, resp)).To(Succeed())
+	g.Expect(resp.Header.Get("Docker-Content-Digest")).To(Equal("sha256:01"))
+	g.Expect(resp.Header.Get("Etag")).To(Equal(`"custom"`))
+
+	// Blobs are left untouched
+	req = httptest.NewRequest(http.MethodGet, "/v2/docker.io/library/nginx/blobs/sha256:01", nil)
+	resp = response(http.Header{}, "{}")
+	g.Expect(setManifestHeaders(req, resp)).To(Succeed())
+	g.Expect(resp.Header).To(BeEmpty())
+}
+
+func Test_answerNotModified(t *testing.T) {
+	g := NewWithT(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/docker.io/library/nginx/manifests/latest", nil)
+	req.Header.Set("If-None-Match", `"sha256:01"`)
+	resp := &http.Response{
+		StatusCode:    http.StatusOK,
+		ContentLength: 2,
+		Header:        http.Header{"Docker-Content-Digest": {"sha256:01"}, "Content-Length": {"2"}},
+		Body:          io.NopCloser(strings.NewReader("{}")),
+	}
+	answerNotModified(req, resp)
+	g.Expect(resp.StatusCode).To(Equal(http.StatusNotModified))
+	g.Expect(resp.Header.Get("Docker-Content-Digest")).To(Equal("sha256:01"))
+	g.Expect(resp.Header.Get("Content-Length")).To(BeEmpty())
+
+	// Manifests that changed are served
+	resp = &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Docker-Content-Digest": {"sha256:02"}}, Body: http.NoBody}
+	answerNotModified(req, resp)
+	g.Expect(resp.StatusCode).To(Equal(http.StatusOK))
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	corev1 "k8s.io/api/core/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// CredentialSealer encrypts the upstream credentials kept in memory by the proxy with a key generated on startup, which
+// is never written anywhere, so that credentials are only readable in clear while a request is using them and are lost
+// when the proxy restarts
+type CredentialSealer struct {
+	aead cipher.AEAD
+}
+
+// sealedCredential is an authn.AuthConfig as sealed, since the JSON encoding of authn.AuthConfig computes its Auth field
+// from the username and password, turning anonymous credentials into basic authentication
+type sealedCredential struct {
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	Auth          string `json:"auth,omitempty"`
+	IdentityToken string `json:"identityToken,omitempty"`
+	RegistryToken string `json:"registryToken,omitempty"`
+}
+
+func NewCredentialSealer() (*CredentialSealer, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("could not generate credentials encryption key: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CredentialSealer{aead: aead}, nil
+}
+
+// Seal encrypts credentials, bound to the given key so that they can't be opened for another one
+func (s *CredentialSealer) Seal(key string, credentials []authn.AuthConfig) ([]byte, error) {
+	sealedCredentials := make([]sealedCredential, 0, len(credentials))
+	for _, c := range credentials {
+		sealedCredentials = append(sealedCredentials, sealedCredential(c))
+	}
+	data, err := json.Marshal(sealedCredentials)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return s.aead.Seal(nonce, nonce, data, []byte(key)), nil
+}
+
+// Open decrypts credentials sealed for the given key
+func (s *CredentialSealer) Open(key string, sealed []byte) ([]authn.AuthConfig, error) {
+	if len(sealed) < s.aead.NonceSize() {
+		return nil, errors.New("sealed credentials are too short")
+	}
+
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	data, err := s.aead.Open(nil, nonce, ciphertext, []byte(key))
+	if err != nil {
+		return nil, err
+	}
+
+	sealedCredentials := []sealedCredential{}
+	if err := json.Unmarshal(data, &sealedCredentials); err != nil {
+		return nil, err
+	}
+	credentials := make([]authn.AuthConfig, 0, len(sealedCredentials))
+	for _, c := range sealedCredentials {
+		credentials = append(credentials, authn.AuthConfig(c))
+	}
+	return credentials, nil
+}
+
+// resolveCredentials returns the credentials given by each keychain of an image for its repository, by order of
+// preference. Keychains that can't be resolved are skipped, unless none of them can.
+func resolveCredentials(sourceImage string, pullSecrets []corev1.Secret) ([]authn.AuthConfig, error) {
+	ref, err := name.ParseReference(sourceImage)
+	if err != nil {
+		return nil, err
+	}
+
+	keychains, err := registry.GetKeychains(sourceImage, pullSecrets)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := []authn.AuthConfig{}
+	var resolveErrors []error
+	for _, keychain := range keychains {
+		authenticator, err := keychain.Resolve(ref.Context())
+		if err != nil {
+			resolveErrors = append(resolveErrors, err)
+			continue
+		}
+		authConfig, err := authenticator.Authorization()
+		if err != nil {
+			resolveErrors = append(resolveErrors, err)
+			continue
+		}
+		credentials = append(credentials, *authConfig)
+	}
+
+	if len(credentials) == 0 {
+		return nil, utilerrors.NewAggregate(resolveErrors)
+	}
+	return credentials, nil
+}
@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	. "github.com/onsi/gomega"
+)
+
+func TestCredentialSealer(t *testing.T) {
+	g := NewWithT(t)
+
+	sealer, err := NewCredentialSealer()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	credentials := []authn.AuthConfig{{Username: "login", Password: "password"}, {}}
+	sealed, err := sealer.Seal("docker.io/library/alpine", credentials)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(sealed)).ToNot(ContainSubstring("password"))
+
+	g.Expect(sealer.Open("docker.io/library/alpine", sealed)).To(Equal(credentials))
+
+	// Credentials are bound to the repository they were sealed for
+	_, err = sealer.Open("docker.io/library/nginx", sealed)
+	g.Expect(err).To(HaveOccurred())
+
+	// and to the sealer, whose key is lost when the proxy restarts
+	otherSealer, err := NewCredentialSealer()
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = otherSealer.Open("docker.io/library/alpine", sealed)
+	g.Expect(err).To(HaveOccurred())
+
+	_, err = sealer.Open("docker.io/library/alpine", []byte("short"))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestResolveCredentials(t *testing.T) {
+	g := NewWithT(t)
+
+	// Only anonymous pulls without pull secrets
+	credentials, err := resolveCredentials("alpine", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(credentials).To(Equal([]authn.AuthConfig{{}}))
+
+	_, err = resolveCredentials("Invalid Image", nil)
+	g.Expect(err).To(HaveOccurred())
+}
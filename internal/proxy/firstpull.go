@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/registry"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// CacheOnFirstPull enables the creation of CachedImages for images pulled through the proxy that have none, e.g. when
+// the webhook has been bypassed or the CachedImage deleted. Such images are served from their origin registry with
+// anonymous credentials while the controllers cache them in the background.
+var CacheOnFirstPull = false
+
+// firstPulledImage returns the source image of a request for a manifest, or an empty string for other requests
+func firstPulledImage(request *http.Request, originRegistry string, repository string) string {
+	if request.Method != http.MethodGet {
+		return ""
+	}
+
+	i := strings.LastIndex(request.URL.Path, "/manifests/")
+	if i < 0 {
+		return ""
+	}
+	reference := request.URL.Path[i+len("/manifests/"):]
+
+	separator := ":"
+	if strings.Contains(reference, ":") {
+		separator = "@"
+	}
+	return originRegistry + "/" + repository + separator + reference
+}
+
+// cacheOnFirstPull creates the CachedImage of an image pulled through the proxy, so that the controllers cache it. An
+// existing CachedImage is left untouched.
+func (p *Proxy) cacheOnFirstPull(ctx context.Context, sourceImage string) error {
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+
+	cachedImage := kuikv1alpha1.CachedImage{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: registry.SanitizeName(sourceImage),
+		},
+		Spec: kuikv1alpha1.CachedImageSpec{
+			SourceImage: sourceImage,
+		},
+	}
+
+	err := p.k8sClient.Create(ctx, &cachedImage)
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	klog.InfoS("created CachedImage of image pulled for the first time", "cachedImage", cachedImage.Name, "sourceImage", sourceImage)
+	return nil
+}
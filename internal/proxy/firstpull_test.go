@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_firstPulledImage(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		expected string
+	}{
+		{
+			name:     "manifest by tag",
+			method:   http.MethodGet,
+			path:     "/v2/docker.io/library/nginx/manifests/1.25",
+			expected: "docker.io/library/nginx:1.25",
+		},
+		{
+			name:     "manifest by digest",
+			method:   http.MethodGet,
+			path:     "/v2/docker.io/library/nginx/manifests/sha256:0123",
+			expected: "docker.io/library/nginx@sha256:0123",
+		},
+		{
+			name:   "HEAD request",
+			method: http.MethodHead,
+			path:   "/v2/docker.io/library/nginx/manifests/1.25",
+		},
+		{
+			name:   "blob",
+			method: http.MethodGet,
+			path:   "/v2/docker.io/library/nginx/blobs/sha256:0123",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			request := httptest.NewRequest(tt.method, tt.path, nil)
+			g.Expect(firstPulledImage(request, "docker.io", "library/nginx")).To(Equal(tt.expected))
+		})
+	}
+}
+
+func Test_cacheOnFirstPull(t *testing.T) {
+	g := NewWithT(t)
+
+	existing := &kuikv1alpha1.CachedImage{
+		ObjectMeta: metav1.ObjectMeta{Name: "docker.io-library-alpine-3.19"},
+		Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: "alpine:3.19"},
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(existing).Build()
+	p := NewWithEngine(k8sClient, nil)
+
+	g.Expect(p.cacheOnFirstPull(context.Background(), "docker.io/library/nginx:1.25")).To(Succeed())
+	cachedImage := kuikv1alpha1.CachedImage{}
+	g.Expect(k8sClient.Get(context.Background(), types.NamespacedName{Name: "docker.io-library-nginx-1.25"}, &cachedImage)).To(Succeed())
+	g.Expect(cachedImage.Spec.SourceImage).To(Equal("docker.io/library/nginx:1.25"))
+
+	g.Expect(p.cacheOnFirstPull(context.Background(), "docker.io/library/alpine:3.19")).To(Succeed())
+	g.Expect(k8sClient.Get(context.Background(), types.NamespacedName{Name: existing.Name}, &cachedImage)).To(Succeed())
+	g.Expect(cachedImage.Spec.SourceImage).To(Equal("alpine:3.19"))
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// VerifyAlwaysPulled enables the verification of manifests of images pulled with imagePullPolicy Always against their
+// upstream registry, so that their cached manifest is only served while it is up to date
+var VerifyAlwaysPulled = false
+
+// manifestIsStale tells whether the upstream manifest of an image pulled by tag with imagePullPolicy Always differs from
+// the one it has been cached from, in which case a refresh of the image is requested to the controllers. The upstream
+// manifest is checked with a HEAD request, memoized by registry.UpstreamDigests. Images that are not pulled with
+// imagePullPolicy Always, whose upstream registry is paused, whose upstream digest can't be resolved or has not been
+// recorded yet, are considered up to date. CachedImages are read from the cache of the proxy.
+func (p *Proxy) manifestIsStale(ctx context.Context, taggedImage string, originRegistry string, repository string) bool {
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+
+	var cachedImage kuikv1alpha1.CachedImage
+	if err := p.reader.Get(ctx, types.NamespacedName{Name: registry.SanitizeName(taggedImage)}, &cachedImage); err != nil {
+		return false
+	}
+	// The digest of the cached index differs from the upstream one when only some architectures are cached, the digest
+	// the image has been pulled from is compared instead
+	pulledDigest := cachedImage.Status.UpstreamDigest
+	if !cachedImage.Status.IsCached || !cachedImage.Status.UsedBy.AlwaysPulled || pulledDigest == "" {
+		return false
+	}
+	if p.upstreamPausedBy(originRegistry, repository) != "" {
+		return false
+	}
+
+	upstreamDigest, err := registry.UpstreamDigests.Get(cachedImage.Spec.SourceImage, func() (v1.Hash, error) {
+		return p.upstreamDigest(ctx, cachedImage.Spec.SourceImage, originRegistry, repository)
+	})
+	if err != nil {
+		klog.InfoS("could not resolve upstream digest, serving cached manifest", "sourceImage", cachedImage.Spec.SourceImage, "error", err)
+		return false
+	}
+	if upstreamDigest.String() == pulledDigest {
+		return false
+	}
+
+	klog.InfoS("cached manifest is outdated, serving upstream manifest", "sourceImage", cachedImage.Spec.SourceImage, "pulledDigest", pulledDigest, "upstreamDigest", upstreamDigest)
+	if !cachedImage.IsRefreshRequested() {
+		if err := p.requestRefresh(ctx, &cachedImage); err != nil {
+			klog.ErrorS(err, "could not request refresh of image", "sourceImage", cachedImage.Spec.SourceImage)
+		}
+	}
+
+	return true
+}
+
+// resolveUpstreamDigest resolves the digest of an image in its origin registry with a HEAD request, authenticated with
+// the credentials of its repository
+func (p *Proxy) resolveUpstreamDigest(ctx context.Context, sourceImage string, originRegistry string, repository string) (v1.Hash, error) {
+	ref, err := name.ParseReference(sourceImage)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+
+	_, credentials, err := p.originCredentials(originRegistry, repository)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	originTransport, err := p.getAuthentifiedTransport(sourceImage, credentials, "https://"+ref.Context().RegistryStr())
+	if err != nil {
+		return v1.Hash{}, err
+	}
+
+	descriptor, err := remote.Head(ref, remote.WithContext(ctx), remote.WithTransport(originTransport))
+	if err != nil {
+		return v1.Hash{}, err
+	}
+
+	return descriptor.Digest, nil
+}
+
+// requestRefresh requests the controllers to pull an image again from its upstream registry
+func (p *Proxy) requestRefresh(ctx context.Context, cachedImage *kuikv1alpha1.CachedImage) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{kuikv1alpha1.RefreshRequestedAtAnnotationName: time.Now().UTC().Format(time.RFC3339)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return p.k8sClient.Patch(ctx, cachedImage, client.RawPatch(types.MergePatchType, patch))
+}
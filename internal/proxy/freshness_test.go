@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	"github.com/gin-gonic/gin"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_manifestIsStale(t *testing.T) {
+	cached := v1.Hash{Algorithm: "sha256", Hex: "01"}
+	updated := v1.Hash{Algorithm: "sha256", Hex: "02"}
+
+	tests := []struct {
+		name             string
+		alwaysPulled     bool
+		unrecorded       bool
+		upstreamDigest   v1.Hash
+		upstreamErr      error
+		paused           bool
+		stale            bool
+		refreshRequested bool
+	}{
+		{
+			name:           "not pulled with imagePullPolicy Always",
+			upstreamDigest: updated,
+		},
+		{
+			name:           "up to date",
+			alwaysPulled:   true,
+			upstreamDigest: cached,
+		},
+		{
+			name:             "outdated",
+			alwaysPulled:     true,
+			upstreamDigest:   updated,
+			stale:            true,
+			refreshRequested: true,
+		},
+		{
+			name:           "upstream digest not recorded",
+			alwaysPulled:   true,
+			unrecorded:     true,
+			upstreamDigest: updated,
+		},
+		{
+			name:         "upstream unreachable",
+			alwaysPulled: true,
+			upstreamErr:  errors.New("connection refused"),
+		},
+		{
+			name:           "upstream paused",
+			alwaysPulled:   true,
+			upstreamDigest: updated,
+			paused:         true,
+		},
+	}
+
+	upstreamDigests := registry.UpstreamDigests
+	defer func() { registry.UpstreamDigests = upstreamDigests }()
+	registry.UpstreamDigests = registry.NewDigestCache(0)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(&kuikv1alpha1.CachedImage{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "docker.io-library-nginx-latest",
+					Labels: map[string]string{kuikv1alpha1.RepositoryLabelName: registry.RepositoryLabel("docker.io/library/nginx")},
+				},
+				Spec: kuikv1alpha1.CachedImageSpec{SourceImage: "nginx"},
+				Status: kuikv1alpha1.CachedImageStatus{
+					IsCached:       true,
+					Digest:         "sha256:03",
+					UpstreamDigest: cached.String(),
+					UsedBy:         kuikv1alpha1.UsedBy{AlwaysPulled: tt.alwaysPulled},
+				},
+			}, &kuikv1alpha1.ClusterPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "default"},
+				Spec:       kuikv1alpha1.ClusterPolicySpec{Paused: tt.paused},
+			}).Build()
+
+			if tt.unrecorded {
+				cachedImage := &kuikv1alpha1.CachedImage{}
+				g.Expect(k8sClient.Get(context.Background(), types.NamespacedName{Name: "docker.io-library-nginx-latest"}, cachedImage)).To(Succeed())
+				cachedImage.Status.UpstreamDigest = ""
+				g.Expect(k8sClient.Status().Update(context.Background(), cachedImage)).To(Succeed())
+			}
+
+			p := NewWithEngine(k8sClient, gin.New())
+			p.upstreamDigest = func(context.Context, string, string, string) (v1.Hash, error) {
+				return tt.upstreamDigest, tt.upstreamErr
+			}
+
+			g.Expect(p.manifestIsStale(context.Background(), "docker.io/library/nginx:latest", "docker.io", "library/nginx")).To(Equal(tt.stale))
+
+			var cachedImage kuikv1alpha1.CachedImage
+			g.Expect(k8sClient.Get(context.Background(), types.NamespacedName{Name: "docker.io-library-nginx-latest"}, &cachedImage)).To(Succeed())
+			g.Expect(cachedImage.IsRefreshRequested()).To(Equal(tt.refreshRequested))
+
+			// Images that are not cached are served from their origin registry anyway
+			g.Expect(p.manifestIsStale(context.Background(), "docker.io/library/redis:latest", "docker.io", "library/redis")).To(BeFalse())
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+)
+
+// lookupTimeout is how long the proxy waits for the Kubernetes API when looking up an image
+const lookupTimeout = 5 * time.Second
+
+// LookupTTL is how long the proxy keeps the CachedImages it looked up in the Kubernetes API, and the credentials
+// resolved from their pull secrets, before looking them up again
+var LookupTTL = time.Minute
+
+// ImageLookup is what the proxy looks up in the Kubernetes API to pull an image from its origin registry
+type ImageLookup struct {
+	CachedImage *kuikv1alpha1.CachedImage
+	// Credentials are the credentials resolved from the pull secrets of the CachedImage, sealed with a CredentialSealer
+	Credentials []byte
+	// PausedBy is the ClusterPolicy or the Repository pausing pulls from the origin registry of the image, if any
+	PausedBy string
+}
+
+type lookupEntry struct {
+	lookup    *ImageLookup
+	fetchedAt time.Time
+}
+
+// LookupCache keeps lookups in the Kubernetes API for a TTL. Expired lookups are kept as well and returned when they
+// can't be looked up again, so that images keep being pulled from their origin registry while the API server is
+// unreachable, e.g. to recover control plane components during an outage.
+type LookupCache struct {
+	ttl     time.Duration
+	now     func() time.Time
+	mutex   sync.Mutex
+	entries map[string]lookupEntry
+}
+
+func NewLookupCache(ttl time.Duration) *LookupCache {
+	return &LookupCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[string]lookupEntry{},
+	}
+}
+
+// Get returns the lookup cached for key, calling lookup if it is missing or expired. If lookup fails, the expired
+// lookup is returned along with the error, or nil if there is none, and is kept for another TTL.
+func (l *LookupCache) Get(key string, lookup func() (*ImageLookup, error)) (*ImageLookup, error) {
+	l.mutex.Lock()
+	entry, found := l.entries[key]
+	l.mutex.Unlock()
+
+	if found && l.now().Sub(entry.fetchedAt) < l.ttl {
+		return entry.lookup, nil
+	}
+
+	fresh, err := lookup()
+	if err != nil {
+		if found {
+			// Don't look it up again before the next TTL, so that requests are not slowed down during outages
+			l.mutex.Lock()
+			l.entries[key] = lookupEntry{lookup: entry.lookup, fetchedAt: l.now()}
+			l.mutex.Unlock()
+		}
+		return entry.lookup, err
+	}
+
+	l.mutex.Lock()
+	l.entries[key] = lookupEntry{lookup: fresh, fetchedAt: l.now()}
+	l.mutex.Unlock()
+
+	return fresh, nil
+}
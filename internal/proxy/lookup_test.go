@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLookupCache_Get(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewLookupCache(time.Minute)
+	cache.now = func() time.Time { return now }
+
+	calls := 0
+	outage := errors.New("connection refused")
+	var apiErr error
+	lookup := func() (*ImageLookup, error) {
+		calls++
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		return &ImageLookup{CachedImage: &kuikv1alpha1.CachedImage{ObjectMeta: metav1.ObjectMeta{Name: "alpine"}}}, nil
+	}
+
+	// The API server is unreachable and the image was never looked up
+	apiErr = outage
+	result, err := cache.Get("docker.io/library/alpine", lookup)
+	g.Expect(err).To(MatchError(outage))
+	g.Expect(result).To(BeNil())
+
+	// Lookups are cached for the TTL
+	apiErr = nil
+	result, err = cache.Get("docker.io/library/alpine", lookup)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.CachedImage.Name).To(Equal("alpine"))
+	now = now.Add(30 * time.Second)
+	_, _ = cache.Get("docker.io/library/alpine", lookup)
+	g.Expect(calls).To(Equal(2))
+
+	// The expired lookup is used during outages, without looking it up again before the next TTL
+	apiErr = outage
+	now = now.Add(time.Minute)
+	result, err = cache.Get("docker.io/library/alpine", lookup)
+	g.Expect(err).To(MatchError(outage))
+	g.Expect(result.CachedImage.Name).To(Equal("alpine"))
+	result, err = cache.Get("docker.io/library/alpine", lookup)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.CachedImage.Name).To(Equal("alpine"))
+	g.Expect(calls).To(Equal(3))
+
+	// Lookups are refreshed once the API server is back
+	apiErr = nil
+	now = now.Add(time.Minute)
+	_, err = cache.Get("docker.io/library/alpine", lookup)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(calls).To(Equal(4))
+}
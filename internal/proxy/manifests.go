@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ManifestHeadTTL is how long the proxy answers HEAD requests of manifests of the cache registry from memory
+var ManifestHeadTTL = 30 * time.Second
+
+// manifestHeaders are the headers of manifest responses kept in memory, the ones runtimes rely on to resolve tags and
+// clients caching manifests rely on to revalidate them
+var manifestHeaders = []string{"Content-Type", "Content-Length", "Docker-Content-Digest", "Etag"}
+
+type manifestHead struct {
+	header    http.Header
+	expiresAt time.Time
+}
+
+// ManifestHeads keeps the headers of manifests served from the cache registry for a TTL, so that the HEAD requests
+// kubelets send to check whether images are up to date are answered from memory, without reaching the cache registry
+// and its storage. Entries are keyed by path and Accept header, since registries pick the manifest to return from it.
+type ManifestHeads struct {
+	ttl     time.Duration
+	now     func() time.Time
+	mutex   sync.Mutex
+	entries map[string]manifestHead
+}
+
+func NewManifestHeads(ttl time.Duration) *ManifestHeads {
+	return &ManifestHeads{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[string]manifestHead{},
+	}
+}
+
+func manifestHeadKey(req *http.Request) string {
+	return req.URL.Path + "\n" + strings.Join(req.Header.Values("Accept"), ",")
+}
+
+// Record keeps the headers of a response of the cache registry to a manifest request
+func (m *ManifestHeads) Record(req *http.Request, resp *http.Response) {
+	if m == nil || m.ttl <= 0 || resp.StatusCode != http.StatusOK || !strings.Contains(req.URL.Path, "/manifests/") {
+		return
+	}
+	if resp.Header.Get("Docker-Content-Digest") == "" {
+		return
+	}
+
+	header := http.Header{}
+	for _, key := range manifestHeaders {
+		if value := resp.Header.Get(key); value != "" {
+			header.Set(key, value)
+		}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.removeExpired()
+	m.entries[manifestHeadKey(req)] = manifestHead{header: header, expiresAt: m.now().Add(m.ttl)}
+}
+
+// Get returns the headers answering req if it is a HEAD request of a manifest whose headers are known and not expired
+func (m *ManifestHeads) Get(req *http.Request) (http.Header, bool) {
+	if m == nil || req.Method != http.MethodHead {
+		return nil, false
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	entry, ok := m.entries[manifestHeadKey(req)]
+	if !ok || m.now().After(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.header, true
+}
+
+// removeExpired removes expired entries to keep memory usage bounded, m.mutex must be held
+func (m *ManifestHeads) removeExpired() {
+	now := m.now()
+	for key, entry := range m.entries {
+		if now.After(entry.expiresAt) {
+			delete(m.entries, key)
+		}
+	}
+}
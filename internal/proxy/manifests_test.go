@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestManifestHeads(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Now()
+	manifestHeads := NewManifestHeads(30 * time.Second)
+	manifestHeads.now = func() time.Time { return now }
+
+	request := func(method string, path string, accept string) *http.Request {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Accept", accept)
+		return req
+	}
+	response := &http.Response{StatusCode: http.StatusOK, Header: http.Header{
+		"Content-Type":          {"application/vnd.oci.image.index.v1+json"},
+		"Content-Length":        {"1234"},
+		"Docker-Content-Digest": {"sha256:01"},
+		"Etag":                  {`"sha256:01"`},
+	}}
+
+	manifest := "/v2/docker.io/library/nginx/manifests/latest"
+	manifestHeads.Record(request(http.MethodGet, manifest, "application/vnd.oci.image.index.v1+json"), response)
+	manifestHeads.Record(request(http.MethodGet, "/v2/docker.io/library/nginx/blobs/sha256:02", ""), response)
+	manifestHeads.Record(request(http.MethodHead, "/v2/docker.io/library/redis/manifests/latest", ""), &http.Response{StatusCode: http.StatusNotFound, Header: http.Header{}})
+
+	header, ok := manifestHeads.Get(request(http.MethodHead, manifest, "application/vnd.oci.image.index.v1+json"))
+	g.Expect(ok).To(BeTrue())
+	g.Expect(header).To(Equal(http.Header{
+		"Content-Type":          {"application/vnd.oci.image.index.v1+json"},
+		"Content-Length":        {"1234"},
+		"Docker-Content-Digest": {"sha256:01"},
+		"Etag":                  {`"sha256:01"`},
+	}))
+
+	// Only HEAD requests are answered from memory, with the same Accept header
+	_, ok = manifestHeads.Get(request(http.MethodGet, manifest, "application/vnd.oci.image.index.v1+json"))
+	g.Expect(ok).To(BeFalse())
+	_, ok = manifestHeads.Get(request(http.MethodHead, manifest, "application/vnd.docker.distribution.manifest.v2+json"))
+	g.Expect(ok).To(BeFalse())
+	_, ok = manifestHeads.Get(request(http.MethodHead, "/v2/docker.io/library/nginx/blobs/sha256:02", ""))
+	g.Expect(ok).To(BeFalse())
+	_, ok = manifestHeads.Get(request(http.MethodHead, "/v2/docker.io/library/redis/manifests/latest", ""))
+	g.Expect(ok).To(BeFalse())
+
+	now = now.Add(time.Minute)
+	_, ok = manifestHeads.Get(request(http.MethodHead, manifest, "application/vnd.oci.image.index.v1+json"))
+	g.Expect(ok).To(BeFalse())
+
+	var disabled *ManifestHeads
+	disabled.Record(request(http.MethodGet, manifest, ""), response)
+	_, ok = disabled.Get(request(http.MethodHead, manifest, ""))
+	g.Expect(ok).To(BeFalse())
+}
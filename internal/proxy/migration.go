@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/enix/kube-image-keeper/internal/registry"
+)
+
+// migrationRefreshInterval is how often the report of the migration of the cache registry is read
+const migrationRefreshInterval = 30 * time.Second
+
+// WatchMigration stops reading images from the previous cache registry once the controllers report the migration as
+// completed in the given ConfigMap. It returns once the migration is completed or ctx is done.
+func WatchMigration(ctx context.Context, k8sClient client.Reader, namespace string, name string) {
+	ticker := time.NewTicker(migrationRefreshInterval)
+	defer ticker.Stop()
+
+	for registry.MigrationInProgress() {
+		var configMap corev1.ConfigMap
+		if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &configMap); client.IgnoreNotFound(err) != nil {
+			klog.Errorf("could not read the report of the registry migration in ConfigMap %s/%s: %s", namespace, name, err)
+		} else if registry.MigrationReportCompleted(&configMap) {
+			klog.InfoS("registry migration completed, no longer proxying the previous registry", "previousEndpoint", registry.PreviousEndpoint)
+			registry.CompleteMigration()
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// portsRefreshInterval is how often the PortResolver reads the ports recorded by the proxies
+const portsRefreshInterval = 30 * time.Second
+
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;create;patch
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
+
+// Listen opens a listener on each of the given ports that is not already in use on host, ports being given by order
+// of preference. It fails if none of them is available.
+func Listen(host string, ports []int) ([]net.Listener, []int, error) {
+	listeners := []net.Listener{}
+	boundPorts := []int{}
+
+	for _, port := range ports {
+		listener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if errors.Is(err, syscall.EADDRINUSE) {
+			klog.Warningf("port %d is already in use, falling back to the next port", port)
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		listeners = append(listeners, listener)
+		boundPorts = append(boundPorts, port)
+	}
+
+	if len(listeners) == 0 {
+		return nil, nil, fmt.Errorf("none of the ports %v is available", ports)
+	}
+
+	return listeners, boundPorts, nil
+}
+
+// RecordPorts records the ports the proxy listens on for the given node in a ConfigMap, creating it if needed
+func RecordPorts(ctx context.Context, k8sClient client.Client, namespace string, name string, nodeName string, ports []int) error {
+	data := map[string]string{nodeName: formatPorts(ports)}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Data:       data,
+	}
+	if err := k8sClient.Create(ctx, configMap); !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{"data": data})
+	if err != nil {
+		return err
+	}
+
+	return k8sClient.Patch(ctx, configMap, client.RawPatch(types.MergePatchType, patch))
+}
+
+func formatPorts(ports []int) string {
+	strs := make([]string, 0, len(ports))
+	for _, port := range ports {
+		strs = append(strs, strconv.Itoa(port))
+	}
+	return strings.Join(strs, ",")
+}
+
+// ParsePorts parses a comma separated list of ports
+func ParsePorts(str string) ([]int, error) {
+	ports := []int{}
+	for _, s := range strings.Split(str, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		port, err := strconv.Atoi(s)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %q", s)
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+// ExcludePorts returns ports without the excluded ones, keeping their order of preference
+func ExcludePorts(ports []int, excluded []int) []int {
+	kept := []int{}
+	for _, port := range ports {
+		isExcluded := false
+		for _, e := range excluded {
+			if port == e {
+				isExcluded = true
+				break
+			}
+		}
+		if !isExcluded {
+			kept = append(kept, port)
+		}
+	}
+	return kept
+}
+
+// NegotiatePort returns the port available on every node, nodes being given with the ports their proxy listens on by
+// order of preference. When several ports are available on every node, the most preferred one is returned. It returns
+// false if no port is available on every node.
+func NegotiatePort(nodePorts map[string][]int) (int, bool) {
+	counts := map[int]int{}
+	positions := map[int]int{}
+	for _, ports := range nodePorts {
+		for i, port := range ports {
+			counts[port]++
+			if position, ok := positions[port]; !ok || i < position {
+				positions[port] = i
+			}
+		}
+	}
+
+	negotiated, found := 0, false
+	for port, count := range counts {
+		if count != len(nodePorts) {
+			continue
+		}
+		if !found || positions[port] < positions[negotiated] || (positions[port] == positions[negotiated] && port < negotiated) {
+			negotiated, found = port, true
+		}
+	}
+
+	return negotiated, found
+}
+
+// PortResolver tells the webhook which port to use to pull images through the proxy, based on the ports recorded by
+// the proxies in a ConfigMap. It periodically reads the ConfigMap and implements manager.Runnable.
+type PortResolver struct {
+	Reader      client.Reader
+	Namespace   string
+	Name        string
+	DefaultPort int
+
+	mutex      sync.RWMutex
+	nodePorts  map[string][]int
+	negotiated int
+}
+
+// Port returns the port to use for a pod scheduled on the given node, or the port available on every node if the node
+// is unknown, which is the case of most pods since they are not scheduled yet when they are created.
+func (r *PortResolver) Port(nodeName string) int {
+	if r == nil {
+		return 0
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if ports := r.nodePorts[nodeName]; len(ports) > 0 {
+		return ports[0]
+	}
+	if r.negotiated > 0 {
+		return r.negotiated
+	}
+	return r.DefaultPort
+}
+
+// Start implements manager.Runnable
+func (r *PortResolver) Start(ctx context.Context) error {
+	log := log.FromContext(ctx).WithName("proxy-ports")
+
+	ticker := time.NewTicker(portsRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.refresh(ctx); err != nil {
+			log.Error(err, "could not read proxy ports", "configMap", klog.KRef(r.Namespace, r.Name))
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable
+func (r *PortResolver) NeedLeaderElection() bool {
+	return false
+}
+
+func (r *PortResolver) refresh(ctx context.Context) error {
+	log := log.FromContext(ctx).WithName("proxy-ports")
+
+	var configMap corev1.ConfigMap
+	if err := r.Reader.Get(ctx, types.NamespacedName{Namespace: r.Namespace, Name: r.Name}, &configMap); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	// Ignore ports recorded by proxies of nodes that don't exist anymore
+	var nodes corev1.NodeList
+	if err := r.Reader.List(ctx, &nodes); err != nil {
+		return err
+	}
+
+	nodePorts := map[string][]int{}
+	for _, node := range nodes.Items {
+		str, ok := configMap.Data[node.Name]
+		if !ok {
+			continue
+		}
+		ports, err := ParsePorts(str)
+		if err != nil {
+			log.Error(err, "ignoring invalid proxy ports", "node", node.Name)
+			continue
+		}
+		nodePorts[node.Name] = ports
+	}
+
+	negotiated, ok := NegotiatePort(nodePorts)
+	if !ok && len(nodePorts) > 0 {
+		log.Info("no proxy port is available on every node, falling back to the default port", "port", r.DefaultPort, "ports", nodePorts)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if negotiated != r.negotiated && ok {
+		log.Info("negotiated proxy port", "port", negotiated, "previousPort", r.negotiated)
+	}
+	r.nodePorts = nodePorts
+	r.negotiated = negotiated
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNegotiatePort(t *testing.T) {
+	tests := []struct {
+		name      string
+		nodePorts map[string][]int
+		expected  int
+		found     bool
+	}{
+		{
+			name:      "No nodes",
+			nodePorts: map[string][]int{},
+		},
+		{
+			name:      "Default port everywhere",
+			nodePorts: map[string][]int{"a": {7439, 7440}, "b": {7439, 7440}},
+			expected:  7439,
+			found:     true,
+		},
+		{
+			name:      "Default port in use on a node",
+			nodePorts: map[string][]int{"a": {7439, 7440, 7441}, "b": {7440, 7441}},
+			expected:  7440,
+			found:     true,
+		},
+		{
+			name:      "No common port",
+			nodePorts: map[string][]int{"a": {7439}, "b": {7440}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			port, found := NegotiatePort(tt.nodePorts)
+			g.Expect(found).To(Equal(tt.found))
+			g.Expect(port).To(Equal(tt.expected))
+		})
+	}
+}
+
+func TestParsePorts(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(ParsePorts("7439, 7440,")).To(Equal([]int{7439, 7440}))
+	g.Expect(ParsePorts("")).To(BeEmpty())
+
+	_, err := ParsePorts("7439,http")
+	g.Expect(err).To(HaveOccurred())
+	_, err = ParsePorts("70000")
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestExcludePorts(t *testing.T) {
+	g := NewWithT(t)
+	g.Expect(ExcludePorts([]int{7439, 7440, 7441}, []int{80, 7440})).To(Equal([]int{7439, 7441}))
+	g.Expect(ExcludePorts([]int{7439}, []int{7439})).To(BeEmpty())
+	g.Expect(ExcludePorts([]int{7439}, nil)).To(Equal([]int{7439}))
+}
+
+func TestListen(t *testing.T) {
+	g := NewWithT(t)
+
+	inUse, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer inUse.Close()
+	inUsePort := inUse.Addr().(*net.TCPAddr).Port
+
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).ToNot(HaveOccurred())
+	freePort := free.Addr().(*net.TCPAddr).Port
+	free.Close()
+
+	listeners, ports, err := Listen("127.0.0.1", []int{inUsePort, freePort})
+	g.Expect(err).ToNot(HaveOccurred())
+	defer listeners[0].Close()
+	g.Expect(listeners).To(HaveLen(1))
+	g.Expect(ports).To(Equal([]int{freePort}))
+
+	_, _, err = Listen("127.0.0.1", []int{inUsePort})
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestPortResolver(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "a"}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "b"}},
+	).Build()
+
+	resolver := &PortResolver{Reader: k8sClient, Namespace: "kuik-system", Name: "proxy-ports", DefaultPort: 7439}
+	g.Expect(resolver.refresh(ctx)).To(Succeed())
+	g.Expect(resolver.Port("")).To(Equal(7439))
+
+	g.Expect(RecordPorts(ctx, k8sClient, "kuik-system", "proxy-ports", "a", []int{7439, 7440})).To(Succeed())
+	g.Expect(RecordPorts(ctx, k8sClient, "kuik-system", "proxy-ports", "b", []int{7440})).To(Succeed())
+	g.Expect(RecordPorts(ctx, k8sClient, "kuik-system", "proxy-ports", "deleted", []int{7441})).To(Succeed())
+
+	var configMap corev1.ConfigMap
+	g.Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: "kuik-system", Name: "proxy-ports"}, &configMap)).To(Succeed())
+	g.Expect(configMap.Data).To(Equal(map[string]string{"a": "7439,7440", "b": "7440", "deleted": "7441"}))
+
+	g.Expect(resolver.refresh(ctx)).To(Succeed())
+	g.Expect(resolver.Port("")).To(Equal(7440))
+	g.Expect(resolver.Port("a")).To(Equal(7439))
+	g.Expect(resolver.Port("unknown")).To(Equal(7440))
+
+	var nilResolver *PortResolver
+	g.Expect(nilResolver.Port("a")).To(Equal(0))
+}
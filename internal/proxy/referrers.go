@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"k8s.io/klog/v2"
+
+	"github.com/enix/kube-image-keeper/internal/registry"
+)
+
+// filtersAppliedHeader tells clients of the Referrers API that referrers have been filtered by artifact type
+const filtersAppliedHeader = "OCI-Filters-Applied"
+
+// serveReferrers answers the Referrers API with the referrers of a manifest in cache, since the cache registry may not
+// support it, so that policy controllers find the signatures and attestations of images pulled through the proxy. The
+// request is proxied as usual when there are none, e.g. for images cached before their referrers were.
+func (p *Proxy) serveReferrers(c *gin.Context, digest string) {
+	if c.Request.Method != http.MethodGet {
+		c.Status(http.StatusMethodNotAllowed)
+		return
+	}
+
+	repository := c.Param("originRegistry") + "/" + c.Param("repository")
+	artifactType := c.Query("artifactType")
+	referrers, err := registry.CachedReferrers(repository, digest, artifactType)
+	if err != nil || len(referrers.Manifests) == 0 {
+		if err != nil {
+			klog.InfoS("could not get referrers from the cache", "repository", repository, "digest", digest, "error", err)
+		}
+		p.routeProxy(c)
+		return
+	}
+
+	referrers.MediaType = types.OCIImageIndex
+	data, err := json.Marshal(referrers)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if artifactType != "" {
+		c.Header(filtersAppliedHeader, "artifactType")
+	}
+	c.Set("cacheHit", true)
+	c.Data(http.StatusOK, string(types.OCIImageIndex), data)
+}
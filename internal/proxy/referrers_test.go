@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/enix/kube-image-keeper/pkg/registrytest"
+	"github.com/gin-gonic/gin"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	. "github.com/onsi/gomega"
+)
+
+func Test_serveReferrers(t *testing.T) {
+	g := NewWithT(t)
+
+	cache := registrytest.New(t)
+	registry.Endpoint = cache.Addr()
+
+	cache.PushImage(t, "docker.io/library/alpine:3.19", registrytest.RandomImage(t, 1))
+	subject, err := remote.Head(cache.Reference(t, "docker.io/library/alpine:3.19"))
+	g.Expect(err).ToNot(HaveOccurred())
+	sbom, err := mutate.Append(mutate.MediaType(empty.Image, types.OCIManifestSchema1), mutate.Addendum{
+		Layer: static.NewLayer([]byte(`{"spdxVersion":"SPDX-2.3"}`), "application/spdx+json"),
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	sbom = mutate.Subject(mutate.ConfigMediaType(sbom, "application/spdx+json"), *subject).(v1.Image)
+	sbomDigest, err := sbom.Digest()
+	g.Expect(err).ToNot(HaveOccurred())
+	cache.PushImage(t, "docker.io/library/alpine@"+sbomDigest.String(), sbom)
+
+	r := gin.New()
+	NewWithEngine(dummyK8sClient, r).Serve()
+	serve := func(method string, path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+		return recorder
+	}
+	path := "/v2/docker.io/library/alpine/referrers/" + subject.Digest.String()
+
+	// Referrers are served from the cache, which doesn't support the Referrers API
+	recorder := serve(http.MethodGet, path)
+	g.Expect(recorder.Code).To(Equal(http.StatusOK))
+	g.Expect(recorder.Header().Get("Content-Type")).To(Equal(string(types.OCIImageIndex)))
+	g.Expect(recorder.Header().Get(filtersAppliedHeader)).To(BeEmpty())
+	var index v1.IndexManifest
+	g.Expect(json.Unmarshal(recorder.Body.Bytes(), &index)).To(Succeed())
+	g.Expect(index.MediaType).To(Equal(types.OCIImageIndex))
+	g.Expect(index.Manifests).To(HaveLen(1))
+	g.Expect(index.Manifests[0].Digest).To(Equal(sbomDigest))
+
+	recorder = serve(http.MethodGet, path+"?artifactType=application/spdx%2Bjson")
+	g.Expect(recorder.Code).To(Equal(http.StatusOK))
+	g.Expect(recorder.Header().Get(filtersAppliedHeader)).To(Equal("artifactType"))
+
+	g.Expect(serve(http.MethodDelete, path).Code).To(Equal(http.StatusMethodNotAllowed))
+}
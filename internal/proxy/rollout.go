@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/enix/kube-image-keeper/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	inFlightRequestsName = "in_flight_requests"
+	// metricsPortName is the name of the port of proxy pods serving metrics
+	metricsPortName = "metrics"
+)
+
+// metricsClient reads the metrics of proxies, which answer right away unless they are stuck
+var metricsClient = &http.Client{Timeout: 5 * time.Second}
+
+// InFlightRequests returns how many requests of image pulls a proxy pod is serving, read from its metrics. Proxies that
+// don't expose this metric, e.g. older versions, are considered idle. Proxies on the host network serve their metrics
+// on the IP of their node.
+func InFlightRequests(ctx context.Context, pod *corev1.Pod) (int, error) {
+	port := 0
+	for _, container := range pod.Spec.Containers {
+		for _, containerPort := range container.Ports {
+			if containerPort.Name == metricsPortName {
+				port = int(containerPort.ContainerPort)
+			}
+		}
+	}
+	if port == 0 {
+		return 0, fmt.Errorf("pod %s/%s has no %s port", pod.Namespace, pod.Name, metricsPortName)
+	}
+	ip := pod.Status.PodIP
+	if pod.Spec.HostNetwork {
+		ip = pod.Status.HostIP
+	}
+	if ip == "" {
+		return 0, fmt.Errorf("pod %s/%s has no IP", pod.Namespace, pod.Name)
+	}
+
+	url := "http://" + net.JoinHostPort(ip, strconv.Itoa(port)) + "/metrics"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := metricsClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("could not read metrics of pod %s/%s: %s", pod.Namespace, pod.Name, resp.Status)
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	family, ok := families[prometheus.BuildFQName(metrics.Namespace, subsystem, inFlightRequestsName)]
+	if !ok {
+		return 0, nil
+	}
+	inFlight := 0.
+	for _, metric := range family.GetMetric() {
+		inFlight += metric.GetGauge().GetValue()
+	}
+	return int(inFlight), nil
+}
@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// metricsPod returns a proxy pod whose metrics are served by server
+func metricsPod(g *WithT, server *httptest.Server) *corev1.Pod {
+	host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	g.Expect(err).ToNot(HaveOccurred())
+	containerPort, err := strconv.Atoi(port)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	return &corev1.Pod{
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name:  "cache-proxy",
+			Ports: []corev1.ContainerPort{{Name: "metrics", ContainerPort: int32(containerPort)}},
+		}}},
+		Status: corev1.PodStatus{PodIP: host},
+	}
+}
+
+func TestInFlightRequests(t *testing.T) {
+	g := NewWithT(t)
+
+	collector := NewCollector()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	server := httptest.NewServer(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	defer server.Close()
+	pod := metricsPod(g, server)
+
+	g.Expect(InFlightRequests(context.Background(), pod)).To(Equal(0))
+
+	done := collector.TrackInFlight()
+	collector.TrackInFlight()
+	g.Expect(InFlightRequests(context.Background(), pod)).To(Equal(2))
+	done()
+	g.Expect(InFlightRequests(context.Background(), pod)).To(Equal(1))
+
+	// Older proxies don't expose the metric
+	older := httptest.NewServer(promhttp.HandlerFor(prometheus.NewRegistry(), promhttp.HandlerOpts{}))
+	defer older.Close()
+	g.Expect(InFlightRequests(context.Background(), metricsPod(g, older))).To(Equal(0))
+
+	// Proxies on the host network are reached on the IP of their node
+	hostNetwork := metricsPod(g, server)
+	hostNetwork.Spec.HostNetwork = true
+	hostNetwork.Status.HostIP, hostNetwork.Status.PodIP = hostNetwork.Status.PodIP, ""
+	g.Expect(InFlightRequests(context.Background(), hostNetwork)).To(Equal(1))
+
+	_, err := InFlightRequests(context.Background(), &corev1.Pod{})
+	g.Expect(err).To(HaveOccurred())
+}
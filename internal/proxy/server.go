@@ -2,23 +2,31 @@ package proxy
 
 import (
 	"context"
-	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"os"
+	"os/signal"
 	"regexp"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/distribution/reference"
 	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
 	"github.com/enix/kube-image-keeper/internal/metrics"
+	"github.com/enix/kube-image-keeper/internal/pulltoken"
 	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/enix/kube-image-keeper/internal/tlsconfig"
 	"github.com/gin-gonic/gin"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
 	"golang.org/x/exp/slices"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
@@ -27,31 +35,125 @@ import (
 )
 
 type Proxy struct {
-	engine             *gin.Engine
-	k8sClient          client.Client
+	engine    *gin.Engine
+	k8sClient client.Client
+	// reader reads the CachedImages looked up for every manifest request, from the cache of the proxy when set by
+	// WithCache
+	reader             client.Reader
 	collector          *Collector
 	exporter           *metrics.Exporter
 	insecureRegistries []string
 	rootCAs            *x509.CertPool
+	usage              *UsageRecorder
+	quarantine         *Quarantine
+	basicAuthAddr      string
+	basicAuthTLSCert   string
+	basicAuthTLSKey    string
+	htpasswd           *Htpasswd
+	pullTokens         *pulltoken.Signer
+	lookups            *LookupCache
+	credentials        *CredentialSealer
+	manifestHeads      *ManifestHeads
+	// streamedBlobs are the digests of the blobs being pushed to the cache registry while they are streamed
+	streamedBlobs sync.Map
+	// upstreamDigest resolves the digest of an image in its origin registry
+	upstreamDigest func(ctx context.Context, sourceImage string, originRegistry string, repository string) (v1.Hash, error)
+	// imageBlobs is registry.ImageBlobs, replaced in tests
+	imageBlobs func(string) (map[v1.Hash]int64, error)
 }
 
+const usageFlushInterval = 30 * time.Second
+
+const apiVersionHeader = "Docker-Distribution-Api-Version"
+
+var errNoCachedImage = errors.New("no CachedImage found for this repository")
+
 func New(k8sClient client.Client, metricsAddr string, insecureRegistries []string, rootCAs *x509.CertPool) *Proxy {
 	collector := NewCollector()
-	return &Proxy{
+	credentials, err := NewCredentialSealer()
+	if err != nil {
+		panic(err)
+	}
+	p := &Proxy{
 		k8sClient:          k8sClient,
+		reader:             k8sClient,
 		engine:             gin.Default(),
 		collector:          collector,
 		exporter:           metrics.New(collector, metricsAddr),
 		insecureRegistries: insecureRegistries,
 		rootCAs:            rootCAs,
+		usage:              NewUsageRecorder(k8sClient),
+		quarantine:         NewQuarantine(),
+		lookups:            NewLookupCache(LookupTTL),
+		manifestHeads:      NewManifestHeads(ManifestHeadTTL),
+		credentials:        credentials,
+		imageBlobs:         registry.ImageBlobs,
 	}
+	p.upstreamDigest = p.resolveUpstreamDigest
+	return p
 }
 
 func NewWithEngine(k8sClient client.Client, engine *gin.Engine) *Proxy {
-	return &Proxy{
-		k8sClient: k8sClient,
-		engine:    engine,
+	credentials, err := NewCredentialSealer()
+	if err != nil {
+		panic(err)
+	}
+	p := &Proxy{
+		k8sClient:   k8sClient,
+		reader:      k8sClient,
+		engine:      engine,
+		lookups:     NewLookupCache(LookupTTL),
+		credentials: credentials,
+		imageBlobs:  registry.ImageBlobs,
 	}
+	p.upstreamDigest = p.resolveUpstreamDigest
+	return p
+}
+
+// WithCache reads the CachedImages looked up for every manifest request from reader, e.g. the cache of an informer,
+// instead of requesting them to the API server
+func (p *Proxy) WithCache(reader client.Reader) *Proxy {
+	p.reader = reader
+	return p
+}
+
+// WithNodeName attributes the pulls served by the proxy to the node it runs on
+func (p *Proxy) WithNodeName(nodeName string) *Proxy {
+	p.usage.NodeName = nodeName
+	return p
+}
+
+// WithBasicAuth serves the proxy on an additional address, requiring clients to authenticate as users of htpasswd or
+// with pull tokens, e.g. for clients outside of the cluster. htpasswd or pullTokens may be nil. The address is served
+// over TLS with the given certificate and key files. Without them, addr must be a loopback address, since credentials
+// and pull tokens would otherwise be sent in clear over the network.
+func (p *Proxy) WithBasicAuth(addr string, htpasswd *Htpasswd, pullTokens *pulltoken.Signer, tlsCert string, tlsKey string) (*Proxy, error) {
+	if (tlsCert == "") != (tlsKey == "") {
+		return nil, errors.New("both the certificate and the key of the authenticated endpoint are required to serve it over TLS")
+	}
+	if tlsCert == "" && !isLoopback(addr) {
+		return nil, fmt.Errorf("the authenticated endpoint requires TLS to be served on %s, which is not a loopback address", addr)
+	}
+
+	p.basicAuthAddr = addr
+	p.basicAuthTLSCert = tlsCert
+	p.basicAuthTLSKey = tlsKey
+	p.htpasswd = htpasswd
+	p.pullTokens = pullTokens
+	return p, nil
+}
+
+// isLoopback tells whether addr only listens on a loopback interface
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
 }
 
 func (p *Proxy) Serve() *Proxy {
@@ -61,20 +163,35 @@ func (p *Proxy) Serve() *Proxy {
 	r.Use(func(c *gin.Context) {
 		c.Next()
 		registry := c.Param("originRegistry")
-		if registry == "" {
+		if registry == "" || p.collector == nil {
 			return
 		}
 		p.collector.IncHTTPCall(registry, c.Writer.Status(), c.GetBool("cacheHit"))
 	})
 
+	// Some clients ping the registry without the trailing slash, answer them instead of redirecting
+	r.GET("/v2", p.v2Endpoint)
+	r.HEAD("/v2", p.v2Endpoint)
+
 	v2 := r.Group("/v2")
 	{
-		pathRegex := regexp.MustCompile("/(.+)/((manifests|blobs)/.+)")
+		pathRegex := regexp.MustCompile("/(.+)/((manifests|blobs|referrers)/.+)")
+
+		// Every response must advertise the API version, including errors, since some clients rely on it to detect
+		// registries. When proxying, the header is left to the upstream registry, see proxyRegistry.
+		v2.Use(func(c *gin.Context) {
+			c.Header(apiVersionHeader, "registry/2.0")
+			c.Next()
+		})
 
 		v2.Any("*catch-all", func(c *gin.Context) {
 			subPath := c.Request.URL.Path[len("/v2"):]
-			if c.Request.Method == http.MethodGet && subPath == "/" {
-				p.v2Endpoint(c)
+			if subPath == "/" {
+				if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+					p.v2Endpoint(c)
+				} else {
+					c.Status(http.StatusMethodNotAllowed)
+				}
 				return
 			}
 
@@ -83,6 +200,7 @@ func (p *Proxy) Serve() *Proxy {
 				c.Status(404)
 				return
 			}
+			start := time.Now()
 
 			ref, err := reference.ParseAnyReference(subMatches[1])
 			if err != nil {
@@ -91,6 +209,16 @@ func (p *Proxy) Serve() *Proxy {
 			}
 			image := ref.String()
 
+			if tag, found := strings.CutPrefix(subMatches[2], "manifests/"); found && !strings.Contains(tag, ":") {
+				if err := registry.UpstreamLimits.CheckTag(tag); err != nil {
+					abortWithRegistryError(c, http.StatusBadRequest, transport.TagInvalidErrorCode, err)
+					return
+				}
+				if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+					c.Set("taggedImage", image+":"+tag)
+				}
+			}
+
 			c.Request.URL.Path = fmt.Sprintf("/v2/%s/%s", image, subMatches[2])
 
 			imageParts := strings.Split(image, "/")
@@ -103,7 +231,30 @@ func (p *Proxy) Serve() *Proxy {
 				Value: strings.Join(imageParts[1:], "/"),
 			})
 
-			p.routeProxy(c)
+			if p.collector != nil {
+				defer p.collector.TrackInFlight()()
+			}
+			if RefuseBlockedImages && p.imageIsBlocked(c, c.Request.Method, image, subMatches[2]) {
+				klog.InfoS("refusing to serve image blocked by its vulnerability scan", "image", image, "path", subMatches[2])
+				abortWithRegistryError(c, http.StatusForbidden, transport.DeniedErrorCode, fmt.Errorf("image %s is blocked by its vulnerability scan", image))
+			} else if digest, found := strings.CutPrefix(subMatches[2], "referrers/"); found {
+				p.serveReferrers(c, digest)
+			} else {
+				p.routeProxy(c)
+			}
+
+			if p.collector != nil && strings.HasPrefix(subMatches[2], "manifests/") {
+				p.collector.IncManifestRequest(c.Request.Method, manifestSource(c))
+			}
+			if requestType := imageRequestType(subMatches[2]); p.collector != nil && requestType != "" && c.Writer.Status() < http.StatusBadRequest {
+				p.collector.ObserveImageRequest(image, requestType, imageRequestOrigin(c), time.Since(start), traceID(c.Request))
+			}
+
+			if p.usage != nil && c.Writer.Status() == http.StatusOK {
+				if cachedImageName := pulledCachedImageName(c.Request.Method, image, subMatches[2]); cachedImageName != "" {
+					p.usage.Record(cachedImageName, time.Now())
+				}
+			}
 		})
 	}
 
@@ -111,16 +262,67 @@ func (p *Proxy) Serve() *Proxy {
 }
 
 func (p *Proxy) Run(proxyAddr string) chan struct{} {
+	return p.run(func() error {
+		return p.engine.Run(proxyAddr)
+	})
+}
+
+// RunListeners is like Run but serves the proxy on each of the given listeners, see Listen
+func (p *Proxy) RunListeners(listeners []net.Listener) chan struct{} {
+	return p.run(func() error {
+		errs := make(chan error, len(listeners))
+		for _, listener := range listeners {
+			go func(listener net.Listener) {
+				errs <- p.engine.RunListener(listener)
+			}(listener)
+		}
+		return <-errs
+	})
+}
+
+// run serves the proxy until serve returns or the proxy is asked to terminate, the usage recorded so far being flushed
+// before the returned channel is notified
+func (p *Proxy) run(serve func() error) chan struct{} {
 	p.Serve()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	usageFlushed := make(chan struct{})
+	go func() {
+		p.usage.Start(ctx, usageFlushInterval)
+		close(usageFlushed)
+	}()
+
 	finished := make(chan struct{})
 	go func() {
-		if err := p.engine.Run(proxyAddr); err != nil {
-			panic(err)
-		}
+		go func() {
+			if err := serve(); err != nil {
+				panic(err)
+			}
+			stop()
+		}()
+		<-ctx.Done()
+		stop()
+		<-usageFlushed
 		finished <- struct{}{}
 		p.exporter.Shutdown()
 	}()
 
+	if p.htpasswd != nil || p.pullTokens != nil {
+		go func() {
+			klog.InfoS("serving proxy with basic authentication", "addr", p.basicAuthAddr, "tls", p.basicAuthTLSCert != "")
+			server := &http.Server{Addr: p.basicAuthAddr, Handler: BasicAuth(p.htpasswd, p.pullTokens, p.engine)}
+			var err error
+			if p.basicAuthTLSCert != "" {
+				server.TLSConfig = tlsconfig.New()
+				err = server.ListenAndServeTLS(p.basicAuthTLSCert, p.basicAuthTLSKey)
+			} else {
+				err = server.ListenAndServe()
+			}
+			if err != nil {
+				panic(err)
+			}
+		}()
+	}
+
 	go func() {
 		if err := p.exporter.ListenAndServe(); err != nil {
 			panic(err)
@@ -132,7 +334,7 @@ func (p *Proxy) Run(proxyAddr string) chan struct{} {
 
 // https://distribution.github.io/distribution/spec/api/#api-version-check
 func (p *Proxy) v2Endpoint(c *gin.Context) {
-	c.Header("Docker-Distribution-Api-Version", "registry/2.0")
+	c.Header(apiVersionHeader, "registry/2.0")
 	c.Header("X-Content-Type-Options", "nosniff")
 	c.JSON(200, map[string]string{})
 }
@@ -143,16 +345,66 @@ func (p *Proxy) routeProxy(c *gin.Context) {
 
 	klog.InfoS("proxying request", "repository", repository, "originRegistry", originRegistry)
 
-	if err := p.proxyRegistry(c, registry.Protocol+registry.Endpoint, false, nil); err != nil {
+	var err error
+	if digest := blobDigest(c.Request.URL.Path); p.quarantine.Contains(digest) {
+		err = fmt.Errorf("blob %s is quarantined", digest)
+	} else if taggedImage := c.GetString("taggedImage"); VerifyAlwaysPulled && taggedImage != "" && p.manifestIsStale(c, taggedImage, originRegistry, repository) {
+		err = fmt.Errorf("manifest of image %s is outdated", taggedImage)
+	} else if header, ok := p.manifestHeads.Get(c.Request); ok {
+		for key := range header {
+			c.Header(key, header.Get(key))
+		}
+		if ifNoneMatch(c.Request, header.Get("Docker-Content-Digest")) {
+			c.Status(http.StatusNotModified)
+		} else {
+			c.Status(http.StatusOK)
+		}
+		c.Set("cacheHit", true)
+		c.Set("memoryHit", true)
+		return
+	} else {
+		err = p.proxyCache(c)
+		if err != nil && registry.MigrationInProgress() {
+			klog.InfoS("image is not in the cache registry, proxying the previous registry", "repository", repository, "originRegistry", originRegistry, "error", err)
+			err = p.proxyRegistry(c, registry.Protocol+registry.PreviousEndpoint, false, registry.CacheTransport)
+		}
+	}
+
+	if err != nil {
+		if registry.Airgapped {
+			klog.InfoS("cached image is not available, refusing to proxy origin in air-gapped mode", "repository", repository, "originRegistry", originRegistry, "error", err)
+			code := transport.ManifestUnknownErrorCode
+			if blobDigest(c.Request.URL.Path) != "" {
+				code = transport.BlobUnknownErrorCode
+			}
+			abortWithRegistryError(c, http.StatusNotFound, code, fmt.Errorf("image is not in cache: %w", registry.ErrAirgapped))
+			return
+		}
+
 		klog.InfoS("cached image is not available, proxying origin", "originRegistry", originRegistry, "error", err)
 
-		cachedImage, err := p.getCachedImage(originRegistry, repository)
+		if pausedBy := p.upstreamPausedBy(originRegistry, repository); pausedBy != "" {
+			klog.InfoS("refusing to proxy origin, pulls from upstream are paused", "repository", repository, "originRegistry", originRegistry, "pausedBy", pausedBy)
+			abortWithRegistryError(c, http.StatusServiceUnavailable, transport.UnavailableErrorCode, fmt.Errorf("pulls from upstream are paused by %s", pausedBy))
+			return
+		}
+
+		sourceImage, credentials, err := p.originCredentials(originRegistry, repository)
+		if errors.Is(err, errNoCachedImage) && CacheOnFirstPull {
+			if pulledImage := firstPulledImage(c.Request, originRegistry, repository); pulledImage != "" {
+				if err := p.cacheOnFirstPull(c, pulledImage); err != nil {
+					klog.ErrorS(err, "could not create CachedImage of image pulled for the first time", "sourceImage", pulledImage)
+				}
+			}
+			sourceImage = originRegistry + "/" + repository
+			credentials, err = resolveCredentials(sourceImage, nil)
+		}
 		if err != nil {
 			_ = c.AbortWithError(http.StatusInternalServerError, err)
 			return
 		}
 
-		transport, err := p.getAuthentifiedTransport(cachedImage, "https://"+originRegistry)
+		originTransport, err := p.getAuthentifiedTransport(sourceImage, credentials, "https://"+originRegistry)
 		if err != nil {
 			_ = c.AbortWithError(http.StatusUnauthorized, err)
 			return
@@ -162,11 +414,18 @@ func (p *Proxy) routeProxy(c *gin.Context) {
 			originRegistry = "index.docker.io"
 		}
 
-		err = p.proxyRegistry(c, "https://"+originRegistry, true, transport)
+		err = p.proxyRegistry(c, "https://"+originRegistry, true, originTransport)
 		if err == nil {
 			return
 		}
 
+		var limitErr *registry.LimitExceededError
+		if errors.As(err, &limitErr) {
+			klog.InfoS("refusing to proxy manifest exceeding limits", "repository", repository, "originRegistry", originRegistry, "error", err)
+			abortWithRegistryError(c, http.StatusBadGateway, transport.ManifestInvalidErrorCode, err)
+			return
+		}
+
 		if err != nil {
 			klog.Errorf("could not proxy registry: %s", err)
 			_ = c.AbortWithError(http.StatusInternalServerError, err)
@@ -178,6 +437,33 @@ func (p *Proxy) routeProxy(c *gin.Context) {
 	c.Set("cacheHit", true)
 }
 
+// proxyCache proxies the read replicas of the cache registry in the order they are routed to, then the cache registry
+// itself. Replicas that can't be reached are skipped for a while, and images missing from a replica, e.g. not replicated
+// yet, are read from the next one.
+func (p *Proxy) proxyCache(c *gin.Context) error {
+	var err error
+	for _, endpoint := range registry.ReadReplicas.Endpoints() {
+		if err = p.proxyRegistry(c, registry.Protocol+endpoint, false, registry.CacheTransport); err == nil {
+			return nil
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && !errors.Is(err, context.Canceled) {
+			klog.InfoS("read replica of the cache registry is unreachable, skipping it", "endpoint", endpoint, "error", err)
+			registry.ReadReplicas.MarkFailed(endpoint)
+		}
+	}
+	return err
+}
+
+// isCacheEndpoint tells whether endpoint is the cache registry or one of its read replicas, or the previous one while
+// the migration from it is in progress
+func isCacheEndpoint(endpoint string) bool {
+	if address, ok := strings.CutPrefix(endpoint, registry.Protocol); ok && registry.ReadReplicas.IsReadEndpoint(address) {
+		return true
+	}
+	return registry.MigrationInProgress() && endpoint == registry.Protocol+registry.PreviousEndpoint
+}
+
 func (p *Proxy) proxyRegistry(c *gin.Context, endpoint string, endpointIsOrigin bool, transport http.RoundTripper) error {
 	klog.V(2).InfoS("proxying registry", "endpoint", endpoint)
 
@@ -224,8 +510,31 @@ func (p *Proxy) proxyRegistry(c *gin.Context, endpoint string, endpointIsOrigin
 	}
 
 	proxy.ModifyResponse = func(resp *http.Response) error {
-		if endpoint == registry.Protocol+registry.Endpoint && !(resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusTemporaryRedirect) {
-			return errors.New(resp.Status)
+		if isCacheEndpoint(endpoint) {
+			if !(resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusTemporaryRedirect || resp.StatusCode == http.StatusNotModified) {
+				return errors.New(resp.Status)
+			}
+			if err := setManifestHeaders(c.Request, resp); err != nil {
+				return err
+			}
+			p.verifyBlob(resp, c.Param("originRegistry")+"/"+c.Param("repository"))
+			p.manifestHeads.Record(c.Request, resp)
+		}
+		if endpointIsOrigin && registry.IsManifestResponse(resp.Request, resp) {
+			if err := registry.UpstreamLimits.CheckManifest(resp); err != nil {
+				return err
+			}
+		}
+		if endpointIsOrigin {
+			if err := setManifestHeaders(c.Request, resp); err != nil {
+				return err
+			}
+			p.streamBlob(c.Request.URL.Path, resp)
+		}
+		answerNotModified(c.Request, resp)
+		// prevent the API version header from being sent twice
+		if resp.Header.Get(apiVersionHeader) != "" {
+			c.Writer.Header().Del(apiVersionHeader)
 		}
 		return nil
 	}
@@ -243,15 +552,18 @@ func (p *Proxy) getCachedImage(registryDomain string, repositoryName string) (*k
 	repositoryLabel := registry.RepositoryLabel(registryDomain + "/" + repositoryName)
 	cachedImages := &kuikv1alpha1.CachedImageList{}
 
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+
 	klog.InfoS("listing CachedImages", "repositoryLabel", repositoryLabel)
-	if err := p.k8sClient.List(context.Background(), cachedImages, client.MatchingLabels{
+	if err := p.k8sClient.List(ctx, cachedImages, client.MatchingLabels{
 		kuikv1alpha1.RepositoryLabelName: repositoryLabel,
 	}, client.Limit(1)); err != nil {
 		return nil, err
 	}
 
 	if len(cachedImages.Items) == 0 {
-		return nil, errors.New("no CachedImage found for this repository")
+		return nil, errNoCachedImage
 	}
 
 	cachedImage := cachedImages.Items[0] // Images from the same repository should need the same pull-secret
@@ -259,29 +571,85 @@ func (p *Proxy) getCachedImage(registryDomain string, repositoryName string) (*k
 	return &cachedImage, nil
 }
 
-func (p *Proxy) getKeychains(cachedImage *kuikv1alpha1.CachedImage) ([]authn.Keychain, error) {
-	pullSecrets, err := cachedImage.GetPullSecrets(p.k8sClient)
-	if err != nil {
-		return nil, err
-	}
+// lookupImage returns the CachedImage of a repository and the credentials resolved from its pull secrets, cached by the
+// proxy for LookupTTL. If they can't be looked up, the last known ones are returned along with the error.
+func (p *Proxy) lookupImage(registryDomain string, repositoryName string) (*ImageLookup, error) {
+	key := registryDomain + "/" + repositoryName
+	return p.lookups.Get(key, func() (*ImageLookup, error) {
+		cachedImage, err := p.getCachedImage(registryDomain, repositoryName)
+		if err != nil {
+			return nil, err
+		}
+
+		pullSecrets, err := cachedImage.GetPullSecrets(p.k8sClient)
+		if err != nil {
+			return nil, err
+		}
+
+		credentials, err := resolveCredentials(cachedImage.Spec.SourceImage, pullSecrets)
+		if err != nil {
+			return nil, err
+		}
+		sealed, err := p.credentials.Seal(key, credentials)
+		if err != nil {
+			return nil, err
+		}
 
-	return registry.GetKeychains(cachedImage.Spec.SourceImage, pullSecrets)
+		ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+		defer cancel()
+		pausedBy, err := cachedImage.UpstreamPausedBy(ctx, p.k8sClient)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ImageLookup{CachedImage: cachedImage, Credentials: sealed, PausedBy: pausedBy}, nil
+	})
 }
 
-func (p *Proxy) getAuthentifiedTransport(cachedImage *kuikv1alpha1.CachedImage, originRegistry string) (http.RoundTripper, error) {
-	imageRef, err := name.ParseReference(cachedImage.Spec.SourceImage)
+// originCredentials returns the source image of a repository and the credentials to pull it from its origin registry,
+// using the last known ones when the Kubernetes API is unreachable, or anonymous ones if there are none
+func (p *Proxy) originCredentials(registryDomain string, repositoryName string) (string, []authn.AuthConfig, error) {
+	lookup, err := p.lookupImage(registryDomain, repositoryName)
+	if errors.Is(err, errNoCachedImage) {
+		return "", nil, err
+	} else if err != nil && lookup == nil {
+		klog.ErrorS(err, "could not look up image in the Kubernetes API, pulling anonymously", "repository", repositoryName, "originRegistry", registryDomain)
+		sourceImage := registryDomain + "/" + repositoryName
+		credentials, err := resolveCredentials(sourceImage, nil)
+		return sourceImage, credentials, err
+	} else if err != nil {
+		klog.ErrorS(err, "could not look up image in the Kubernetes API, using the last known lookup", "repository", repositoryName, "originRegistry", registryDomain)
+	}
+
+	credentials, err := p.credentials.Open(registryDomain+"/"+repositoryName, lookup.Credentials)
 	if err != nil {
-		return nil, err
+		return "", nil, fmt.Errorf("could not open cached credentials: %w", err)
+	}
+	return lookup.CachedImage.Spec.SourceImage, credentials, nil
+}
+
+// upstreamPausedBy returns the ClusterPolicy or the Repository pausing pulls from the origin registry of a repository,
+// as last looked up, or the air-gapped mode, or an empty string if they are not paused or can't be looked up
+func (p *Proxy) upstreamPausedBy(registryDomain string, repositoryName string) string {
+	if registry.Airgapped {
+		return "the air-gapped mode"
+	}
+	lookup, _ := p.lookupImage(registryDomain, repositoryName)
+	if lookup == nil {
+		return ""
 	}
+	return lookup.PausedBy
+}
 
-	keychains, err := p.getKeychains(cachedImage)
+func (p *Proxy) getAuthentifiedTransport(sourceImage string, credentials []authn.AuthConfig, originRegistry string) (http.RoundTripper, error) {
+	imageRef, err := name.ParseReference(sourceImage)
 	if err != nil {
 		return nil, err
 	}
 
 	var proxyErrors []error
-	for _, keychain := range keychains {
-		transport, err := p.getAuthentifiedTransportWithKeychain(imageRef.Context(), keychain)
+	for _, authConfig := range credentials {
+		transport, err := p.getAuthentifiedTransportWithAuthenticator(imageRef.Context(), authn.FromConfig(authConfig))
 		if err != nil {
 			proxyErrors = append(proxyErrors, err)
 			continue
@@ -301,21 +669,24 @@ func (p *Proxy) getAuthentifiedTransport(cachedImage *kuikv1alpha1.CachedImage,
 	return nil, utilerrors.NewAggregate(proxyErrors)
 }
 
-func (p *Proxy) getAuthentifiedTransportWithKeychain(repository name.Repository, keychain authn.Keychain) (http.RoundTripper, error) {
-	auth, err := keychain.Resolve(repository)
-	if err != nil {
-		return nil, err
-	}
-
+func (p *Proxy) getAuthentifiedTransportWithAuthenticator(repository name.Repository, auth authn.Authenticator) (http.RoundTripper, error) {
 	originalTransport := http.DefaultTransport.(*http.Transport).Clone()
-	originalTransport.TLSClientConfig = &tls.Config{RootCAs: p.rootCAs}
+	originalTransport.TLSClientConfig = tlsconfig.New()
+	originalTransport.DialContext = registry.UpstreamDialContext(repository)
 	if slices.Contains(p.insecureRegistries, repository.Registry.RegistryStr()) {
-		originalTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		originalTransport.TLSClientConfig.InsecureSkipVerify = true
+	} else {
+		originalTransport.TLSClientConfig.RootCAs = p.rootCAs
 	}
 
 	return transport.NewWithContext(context.Background(), repository.Registry, auth, originalTransport, []string{repository.Scope(transport.PullScope)})
 }
 
+// abortWithRegistryError responds with an error following the format of the registry API
+func abortWithRegistryError(c *gin.Context, status int, code transport.ErrorCode, err error) {
+	c.AbortWithStatusJSON(status, gin.H{"errors": []gin.H{{"code": code, "message": err.Error()}}})
+}
+
 // See https://github.com/golang/go/issues/28239, https://github.com/golang/go/issues/23643 and https://github.com/golang/go/issues/56228
 func recoveryMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -333,6 +704,60 @@ func recoveryMiddleware() gin.HandlerFunc {
 	}
 }
 
+// manifestSource returns where a manifest request has been served from: memory, cache or origin
+func manifestSource(c *gin.Context) string {
+	if c.GetBool("memoryHit") {
+		return "memory"
+	}
+	if c.GetBool("cacheHit") {
+		return "cache"
+	}
+	return "origin"
+}
+
+// imageRequestType returns the type of the request of an image given its path relative to the repository, manifest or
+// blob, or an empty string for other requests, e.g. of referrers
+func imageRequestType(path string) string {
+	switch {
+	case strings.HasPrefix(path, "manifests/"):
+		return "manifest"
+	case strings.HasPrefix(path, "blobs/"):
+		return "blob"
+	}
+	return ""
+}
+
+// imageRequestOrigin returns where the request of an image has been served from: cache, including the manifests
+// served from memory, or upstream
+func imageRequestOrigin(c *gin.Context) string {
+	if c.GetBool("cacheHit") {
+		return "cache"
+	}
+	return "upstream"
+}
+
+// pulledCachedImageName returns the name of the CachedImage pulled by a manifest request, or an empty string if the
+// request should not be counted as a pull. Runtimes resolving tags with a HEAD request then fetch manifests by digest,
+// so requests by tag are counted for both methods whereas requests by digest are only counted for GET requests.
+func pulledCachedImageName(method string, image string, path string) string {
+	reference, found := strings.CutPrefix(path, "manifests/")
+	if !found {
+		return ""
+	}
+
+	if strings.Contains(reference, ":") {
+		if method != http.MethodGet {
+			return ""
+		}
+		return registry.SanitizeName(image + "@" + reference)
+	}
+
+	if method != http.MethodGet && method != http.MethodHead {
+		return ""
+	}
+	return registry.SanitizeName(image + ":" + reference)
+}
+
 func handleOriginRegistryPort(originRegistry string) string {
 	re := regexp.MustCompile(`-([0-9]+)$`)
 	parts := re.FindStringSubmatch(originRegistry)
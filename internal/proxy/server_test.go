@@ -3,6 +3,7 @@ package proxy
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
@@ -97,3 +98,105 @@ func Test_handleOriginRegistryPort(t *testing.T) {
 		})
 	}
 }
+
+func Test_pulledCachedImageName(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		expectedName string
+	}{
+		{
+			name:         "GET by tag",
+			method:       http.MethodGet,
+			path:         "manifests/1.25",
+			expectedName: "docker.io-library-nginx-1.25",
+		},
+		{
+			name:         "HEAD by tag",
+			method:       http.MethodHead,
+			path:         "manifests/latest",
+			expectedName: "docker.io-library-nginx-latest",
+		},
+		{
+			name:         "GET by digest",
+			method:       http.MethodGet,
+			path:         "manifests/sha256:0000000000000000000000000000000000000000000000000000000000000000",
+			expectedName: "docker.io-library-nginx-sha256-0000000000000000000000000000000000000000000000000000000000000000",
+		},
+		{
+			name:   "HEAD by digest",
+			method: http.MethodHead,
+			path:   "manifests/sha256:0000000000000000000000000000000000000000000000000000000000000000",
+		},
+		{
+			name:   "Blob",
+			method: http.MethodGet,
+			path:   "blobs/sha256:0000000000000000000000000000000000000000000000000000000000000000",
+		},
+	}
+
+	g := NewWithT(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g.Expect(pulledCachedImageName(tt.method, "docker.io/library/nginx", tt.path)).To(Equal(tt.expectedName))
+		})
+	}
+}
+
+func Test_v2Ping(t *testing.T) {
+	userAgents := map[string]string{
+		"containerd": "containerd/v1.7.11",
+		"cri-o":      "cri-o/1.28.2 go/go1.20.10 os/linux arch/amd64",
+		"docker":     "docker/24.0.7 go/go1.20.10 git-commit/311b9ff kernel/6.5.0 os/linux arch/amd64",
+		"podman":     "containers/5.29.1 (github.com/containers/image)",
+		"crane":      "crane/v0.17.0 go-containerregistry/v0.17.0",
+	}
+
+	tests := []struct {
+		method         string
+		path           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{method: http.MethodGet, path: "/v2/", expectedStatus: http.StatusOK, expectedBody: "{}"},
+		{method: http.MethodGet, path: "/v2", expectedStatus: http.StatusOK, expectedBody: "{}"},
+		{method: http.MethodHead, path: "/v2/", expectedStatus: http.StatusOK},
+		{method: http.MethodHead, path: "/v2", expectedStatus: http.StatusOK},
+		{method: http.MethodPost, path: "/v2/", expectedStatus: http.StatusMethodNotAllowed},
+		{method: http.MethodGet, path: "/v2/unknown", expectedStatus: http.StatusNotFound},
+	}
+
+	g := NewWithT(t)
+	for client, userAgent := range userAgents {
+		for _, tt := range tests {
+			t.Run(client+" "+tt.method+" "+tt.path, func(t *testing.T) {
+				r := gin.New()
+				NewWithEngine(dummyK8sClient, r).Serve()
+
+				recorder := httptest.NewRecorder()
+				request := httptest.NewRequest(tt.method, tt.path, nil)
+				request.Header.Set("User-Agent", userAgent)
+				r.ServeHTTP(recorder, request)
+
+				g.Expect(recorder.Code).To(Equal(tt.expectedStatus))
+				g.Expect(recorder.Header().Values("Docker-Distribution-Api-Version")).To(Equal([]string{"registry/2.0"}))
+				if tt.expectedBody != "" {
+					g.Expect(recorder.Body.String()).To(Equal(tt.expectedBody))
+				}
+			})
+		}
+	}
+}
+
+func Test_tagLengthLimit(t *testing.T) {
+	g := NewWithT(t)
+	r := gin.New()
+	NewWithEngine(dummyK8sClient, r).Serve()
+
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/v2/docker.io/library/alpine/manifests/"+strings.Repeat("a", 129), nil))
+
+	g.Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+	g.Expect(recorder.Body.String()).To(Equal(`{"errors":[{"code":"TAG_INVALID","message":"tag length of 129 exceeds the limit of 128"}]}`))
+}
@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/enix/kube-image-keeper/internal/tlsconfig"
+	"k8s.io/klog/v2"
+)
+
+// StreamBlobs enables pushing blobs served from their origin registry to the cache registry while they are streamed to
+// the client, so that the controllers caching the image don't pull them again
+var StreamBlobs = false
+
+// streamBufferSize bounds the amount of a blob read by the client but not yet pushed to the cache registry
+const streamBufferSize = 8 << 20
+
+var errBlobStreamInterrupted = errors.New("client stopped reading the blob before its end")
+
+var errBlobStreamTooSlow = errors.New("cache registry is slower than the client, abandoning the push of the blob")
+
+// blobBuffer passes a blob streamed to a client to its push to the cache registry. Writes never block, so that a slow
+// cache registry doesn't slow down the client: the push is abandoned once it falls behind by more than its size.
+type blobBuffer struct {
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	buffer   bytes.Buffer
+	size     int
+	writeErr error
+	readErr  error
+}
+
+func newBlobBuffer(size int) *blobBuffer {
+	b := &blobBuffer{size: size}
+	b.cond = sync.NewCond(&b.mutex)
+	return b
+}
+
+// Write buffers p, failing once the reader is closed or if the buffer is full
+func (b *blobBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.readErr != nil {
+		return 0, b.readErr
+	}
+	if b.writeErr != nil {
+		return 0, io.ErrClosedPipe
+	}
+	if b.buffer.Len()+len(p) > b.size {
+		b.writeErr = errBlobStreamTooSlow
+		b.cond.Broadcast()
+		return 0, errBlobStreamTooSlow
+	}
+
+	b.buffer.Write(p)
+	b.cond.Broadcast()
+	return len(p), nil
+}
+
+// CloseWithError makes reads fail with err once the buffer has been read, or io.EOF if err is nil
+func (b *blobBuffer) CloseWithError(err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if err == nil {
+		err = io.EOF
+	}
+	if b.writeErr == nil {
+		b.writeErr = err
+	}
+	b.cond.Broadcast()
+}
+
+// Read reads the buffer, waiting for writes while it is empty. Reads fail right away when the writer abandons the push.
+func (b *blobBuffer) Read(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for b.buffer.Len() == 0 && b.writeErr == nil && b.readErr == nil {
+		b.cond.Wait()
+	}
+	if b.readErr != nil {
+		return 0, b.readErr
+	}
+	if b.writeErr != nil && b.writeErr != io.EOF {
+		return 0, b.writeErr
+	}
+	if b.buffer.Len() > 0 {
+		return b.buffer.Read(p)
+	}
+	return 0, b.writeErr
+}
+
+// Close makes writes fail, e.g. once the blob is known to be in cache already
+func (b *blobBuffer) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.readErr = io.ErrClosedPipe
+	b.buffer.Reset()
+	b.cond.Broadcast()
+	return nil
+}
+
+// teeReader copies a blob to a buffer as it is read. Copying stops without failing reads once the buffer fails, e.g.
+// when the blob is already in cache or when the cache registry falls behind.
+type teeReader struct {
+	io.ReadCloser
+	pipe *blobBuffer
+}
+
+func (r *teeReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if r.pipe == nil {
+		return n, err
+	}
+
+	if n > 0 {
+		if _, writeErr := r.pipe.Write(p[:n]); writeErr != nil {
+			r.pipe = nil
+			return n, err
+		}
+	}
+	if err == io.EOF {
+		r.pipe.CloseWithError(nil)
+		r.pipe = nil
+	} else if err != nil {
+		r.pipe.CloseWithError(err)
+		r.pipe = nil
+	}
+
+	return n, err
+}
+
+func (r *teeReader) Close() error {
+	if r.pipe != nil {
+		r.pipe.CloseWithError(errBlobStreamInterrupted)
+		r.pipe = nil
+	}
+	return r.ReadCloser.Close()
+}
+
+// streamBlob wraps the body of a response of an origin registry to a blob request to push the blob to the cache
+// registry while it is streamed to the client. Blobs redirected to another location, e.g. a CDN, are fetched by the
+// proxy instead of redirecting the client. A blob is only pushed once at a time, concurrent requests are just proxied.
+func (p *Proxy) streamBlob(path string, resp *http.Response) {
+	if !StreamBlobs || resp.Request.Method != http.MethodGet {
+		return
+	}
+
+	digest := blobDigest(path)
+	if digest == "" {
+		return
+	}
+	repository := strings.TrimPrefix(path[:strings.LastIndex(path, "/blobs/")], "/v2/")
+
+	if resp.StatusCode == http.StatusTemporaryRedirect || resp.StatusCode == http.StatusFound || resp.StatusCode == http.StatusSeeOther || resp.StatusCode == http.StatusPermanentRedirect {
+		if err := p.followBlobRedirect(resp); err != nil {
+			klog.InfoS("could not follow blob redirect, redirecting client", "digest", digest, "error", err)
+			return
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return
+	}
+
+	if _, streaming := p.streamedBlobs.LoadOrStore(digest, struct{}{}); streaming {
+		return
+	}
+
+	buffer := newBlobBuffer(streamBufferSize)
+	resp.Body = &teeReader{ReadCloser: resp.Body, pipe: buffer}
+
+	go func() {
+		defer p.streamedBlobs.Delete(digest)
+
+		err := registry.PushBlob(repository, digest, resp.ContentLength, buffer)
+		if err != nil {
+			klog.InfoS("could not push streamed blob to the cache", "repository", repository, "digest", digest, "error", err)
+		} else {
+			klog.V(1).InfoS("pushed streamed blob to the cache", "repository", repository, "digest", digest)
+		}
+		// Stop copying the blob if it has not been read, e.g. because it was already in cache
+		buffer.Close()
+	}()
+}
+
+// followBlobRedirect replaces a redirect response of an origin registry with the response of the location it redirects
+// to. Blob locations are signed URLs that don't require the credentials of the origin registry.
+func (p *Proxy) followBlobRedirect(resp *http.Response) error {
+	location, err := resp.Location()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(resp.Request.Context(), http.MethodGet, location.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsconfig.New()
+	transport.TLSClientConfig.RootCAs = p.rootCAs
+
+	redirected, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+	resp.Status = redirected.Status
+	resp.StatusCode = redirected.StatusCode
+	resp.Header = redirected.Header
+	resp.Body = redirected.Body
+	resp.ContentLength = redirected.ContentLength
+	return nil
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func Test_teeReader(t *testing.T) {
+	g := NewWithT(t)
+
+	buffer := newBlobBuffer(streamBufferSize)
+	reader := &teeReader{ReadCloser: io.NopCloser(strings.NewReader("blob content")), pipe: buffer}
+
+	copied := make(chan string)
+	go func() {
+		content, _ := io.ReadAll(buffer)
+		copied <- string(content)
+	}()
+
+	content, err := io.ReadAll(reader)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(content)).To(Equal("blob content"))
+	g.Expect(<-copied).To(Equal("blob content"))
+}
+
+func Test_teeReaderWithClosedPipe(t *testing.T) {
+	g := NewWithT(t)
+
+	buffer := newBlobBuffer(streamBufferSize)
+	g.Expect(buffer.Close()).To(Succeed())
+	reader := &teeReader{ReadCloser: io.NopCloser(strings.NewReader("blob content")), pipe: buffer}
+
+	content, err := io.ReadAll(reader)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(content)).To(Equal("blob content"))
+	g.Expect(reader.pipe).To(BeNil())
+}
+
+func Test_teeReaderInterrupted(t *testing.T) {
+	g := NewWithT(t)
+
+	buffer := newBlobBuffer(streamBufferSize)
+	reader := &teeReader{ReadCloser: io.NopCloser(strings.NewReader("blob content")), pipe: buffer}
+	g.Expect(reader.Close()).To(Succeed())
+
+	_, err := io.ReadAll(buffer)
+	g.Expect(err).To(MatchError(errBlobStreamInterrupted))
+}
+
+func Test_teeReaderTooSlow(t *testing.T) {
+	g := NewWithT(t)
+
+	// The client isn't slowed down by a push falling behind, which is abandoned
+	buffer := newBlobBuffer(4)
+	reader := &teeReader{ReadCloser: io.NopCloser(strings.NewReader("blob content")), pipe: buffer}
+
+	content, err := io.ReadAll(reader)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(content)).To(Equal("blob content"))
+	g.Expect(reader.pipe).To(BeNil())
+
+	_, err = io.ReadAll(buffer)
+	g.Expect(err).To(MatchError(errBlobStreamTooSlow))
+}
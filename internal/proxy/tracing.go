@@ -0,0 +1,22 @@
+package proxy
+
+import (
+	"net/http"
+	"regexp"
+)
+
+// traceparentHeader is the header propagating the trace context of requests, see https://www.w3.org/TR/trace-context/
+const traceparentHeader = "traceparent"
+
+// traceparentRegex matches the traceparent header, capturing its trace ID. Trace IDs made of zeros are invalid.
+var traceparentRegex = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-[0-9a-f]{16}-[0-9a-f]{2}`)
+
+// traceID returns the trace ID of a request propagated by its traceparent header, e.g. by a registry client or a
+// tracing proxy in front of the proxy, or an empty string if the request is not traced
+func traceID(req *http.Request) string {
+	subMatches := traceparentRegex.FindStringSubmatch(req.Header.Get(traceparentHeader))
+	if subMatches == nil || subMatches[1] == "00000000000000000000000000000000" {
+		return ""
+	}
+	return subMatches[1]
+}
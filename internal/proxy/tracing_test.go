@@ -0,0 +1,32 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func Test_traceID(t *testing.T) {
+	tests := []struct {
+		name        string
+		traceparent string
+		expected    string
+	}{
+		{name: "Not traced"},
+		{name: "Traced", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", expected: "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{name: "Invalid", traceparent: "00-4bf92f3577b34da6-00f067aa0ba902b7-01"},
+		{name: "Zero trace ID", traceparent: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			req := httptest.NewRequest("GET", "/v2/docker.io/library/alpine/manifests/3.19", nil)
+			if tt.traceparent != "" {
+				req.Header.Set(traceparentHeader, tt.traceparent)
+			}
+			g.Expect(traceID(req)).To(Equal(tt.expected))
+		})
+	}
+}
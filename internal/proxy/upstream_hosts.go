@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"context"
+
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/registry"
+)
+
+// WatchUpstreamHosts keeps the addresses upstream registries are reached at in sync with the upstream addresses of
+// Repositories, watched through informers. It is used by every replica of the controllers as well as by the proxies,
+// which all reach upstream registries.
+func WatchUpstreamHosts(ctx context.Context, informers cache.Informers) error {
+	informer, err := informers.GetInformer(ctx, &kuikv1alpha1.Repository{})
+	if err != nil {
+		return err
+	}
+
+	_, err = informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			setUpstreamHost(obj)
+		},
+		UpdateFunc: func(_, newObj interface{}) {
+			setUpstreamHost(newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if repository, ok := obj.(*kuikv1alpha1.Repository); ok {
+				registry.UpstreamHosts.Set(repository.Name, "")
+			}
+		},
+	})
+	return err
+}
+
+func setUpstreamHost(obj interface{}) {
+	if repository, ok := obj.(*kuikv1alpha1.Repository); ok {
+		registry.UpstreamHosts.Set(repository.Name, repository.Spec.UpstreamAddress)
+	}
+}
@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	"github.com/google/go-containerregistry/pkg/name"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+)
+
+func TestWatchUpstreamHosts(t *testing.T) {
+	g := NewWithT(t)
+
+	upstreamHosts := registry.UpstreamHosts
+	defer func() { registry.UpstreamHosts = upstreamHosts }()
+	registry.UpstreamHosts = registry.NewHostOverrides()
+
+	informers := &informertest.FakeInformers{Scheme: scheme.NewScheme()}
+	g.Expect(WatchUpstreamHosts(context.Background(), informers)).To(Succeed())
+	informer, err := informers.FakeInformerFor(&kuikv1alpha1.Repository{})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	repositoryName, err := name.NewRepository("registry.example.com/team/app")
+	g.Expect(err).ToNot(HaveOccurred())
+	repository := &kuikv1alpha1.Repository{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry.example.com-team-app"},
+		Spec:       kuikv1alpha1.RepositorySpec{UpstreamAddress: "10.0.2.10"},
+	}
+
+	informer.Add(repository)
+	g.Expect(registry.UpstreamHosts.Address(repositoryName)).To(Equal("10.0.2.10"))
+
+	updated := repository.DeepCopy()
+	updated.Spec.UpstreamAddress = "10.0.2.11:5000"
+	informer.Update(repository, updated)
+	g.Expect(registry.UpstreamHosts.Address(repositoryName)).To(Equal("10.0.2.11:5000"))
+
+	informer.Delete(updated)
+	g.Expect(registry.UpstreamHosts.Address(repositoryName)).To(BeEmpty())
+}
@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// MaxRecentPulls is the size of the ring buffer of pull dates kept in the CachedImage status
+const MaxRecentPulls = 20
+
+// MaxNodePulls bounds the number of nodes whose last pull is kept in the CachedImage status, the nodes that pulled the
+// image the least recently being dropped first. Nodes that no longer exist are pruned by the controllers.
+const MaxNodePulls = 100
+
+// UsageRecorder counts image pulls in memory and periodically persists them in the status of CachedImages.
+// Several proxies may flush concurrently, so updates use optimistic locking.
+type UsageRecorder struct {
+	// NodeName is the name of the node of the proxy, pulls are attributed to it when it is not empty
+	NodeName  string
+	k8sClient client.Client
+	mutex     sync.Mutex
+	pulls     map[string][]time.Time
+}
+
+func NewUsageRecorder(k8sClient client.Client) *UsageRecorder {
+	return &UsageRecorder{
+		k8sClient: k8sClient,
+		pulls:     map[string][]time.Time{},
+	}
+}
+
+// Record registers a pull of the CachedImage with the given name
+func (u *UsageRecorder) Record(cachedImageName string, at time.Time) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.pulls[cachedImageName] = append(u.pulls[cachedImageName], at)
+}
+
+// Flush persists recorded pulls, pulls that could not be persisted are recorded back to be retried on next flush
+func (u *UsageRecorder) Flush(ctx context.Context) {
+	u.mutex.Lock()
+	pulls := u.pulls
+	u.pulls = map[string][]time.Time{}
+	u.mutex.Unlock()
+
+	for cachedImageName, dates := range pulls {
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			var cachedImage kuikv1alpha1.CachedImage
+			if err := u.k8sClient.Get(ctx, types.NamespacedName{Name: cachedImageName}, &cachedImage); err != nil {
+				return err
+			}
+			patch := client.MergeFromWithOptions(cachedImage.DeepCopy(), client.MergeFromWithOptimisticLock{})
+			addPulls(&cachedImage.Status.Usage, dates)
+			addNodePulls(&cachedImage.Status.Usage, u.NodeName, dates)
+			if lastPulledAt := cachedImage.Status.Usage.LastPulledAt; cachedImage.Status.LastUsedAt == nil || lastPulledAt.After(cachedImage.Status.LastUsedAt.Time) {
+				cachedImage.Status.LastUsedAt = lastPulledAt.DeepCopy()
+			}
+			return u.k8sClient.Status().Patch(ctx, &cachedImage, patch)
+		})
+
+		if apierrors.IsNotFound(err) {
+			klog.V(2).InfoS("ignoring pulls of unknown CachedImage", "cachedImage", cachedImageName)
+		} else if err != nil {
+			klog.ErrorS(err, "could not persist image usage", "cachedImage", cachedImageName)
+			for _, date := range dates {
+				u.Record(cachedImageName, date)
+			}
+		}
+	}
+}
+
+// Start flushes recorded pulls at the given interval until the context is done
+func (u *UsageRecorder) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			u.Flush(context.Background())
+			return
+		case <-ticker.C:
+			u.Flush(ctx)
+		}
+	}
+}
+
+func addPulls(usage *kuikv1alpha1.Usage, dates []time.Time) {
+	for _, date := range dates {
+		usage.PullCount++
+		if usage.LastPulledAt == nil || date.After(usage.LastPulledAt.Time) {
+			usage.LastPulledAt = &metav1.Time{Time: date}
+		}
+		usage.RecentPulls = append(usage.RecentPulls, metav1.Time{Time: date})
+	}
+
+	if len(usage.RecentPulls) > MaxRecentPulls {
+		usage.RecentPulls = usage.RecentPulls[len(usage.RecentPulls)-MaxRecentPulls:]
+	}
+}
+
+// addNodePulls records the last of the given pull dates for a node
+func addNodePulls(usage *kuikv1alpha1.Usage, nodeName string, dates []time.Time) {
+	if nodeName == "" || len(dates) == 0 {
+		return
+	}
+
+	last := dates[0]
+	for _, date := range dates[1:] {
+		if date.After(last) {
+			last = date
+		}
+	}
+
+	for i := range usage.Nodes {
+		if usage.Nodes[i].Name == nodeName {
+			if last.After(usage.Nodes[i].LastPulledAt.Time) {
+				usage.Nodes[i].LastPulledAt = metav1.Time{Time: last}
+			}
+			return
+		}
+	}
+	usage.Nodes = append(usage.Nodes, kuikv1alpha1.NodePull{Name: nodeName, LastPulledAt: metav1.Time{Time: last}})
+
+	if len(usage.Nodes) > MaxNodePulls {
+		oldest := 0
+		for i := range usage.Nodes {
+			if usage.Nodes[i].LastPulledAt.Before(&usage.Nodes[oldest].LastPulledAt) {
+				oldest = i
+			}
+		}
+		usage.Nodes = append(usage.Nodes[:oldest], usage.Nodes[oldest+1:]...)
+	}
+}
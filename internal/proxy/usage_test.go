@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	. "github.com/onsi/gomega"
+)
+
+func Test_addPulls(t *testing.T) {
+	g := NewWithT(t)
+	now := time.Now().Truncate(time.Second)
+
+	usage := kuikv1alpha1.Usage{}
+	addPulls(&usage, []time.Time{now.Add(-time.Minute), now})
+	g.Expect(usage.PullCount).To(BeEquivalentTo(2))
+	g.Expect(usage.LastPulledAt.Time).To(Equal(now))
+	g.Expect(usage.RecentPulls).To(HaveLen(2))
+
+	dates := []time.Time{}
+	for i := 1; i <= MaxRecentPulls; i++ {
+		dates = append(dates, now.Add(time.Duration(i)*time.Second))
+	}
+	addPulls(&usage, dates)
+	g.Expect(usage.PullCount).To(BeEquivalentTo(MaxRecentPulls + 2))
+	g.Expect(usage.RecentPulls).To(HaveLen(MaxRecentPulls))
+	g.Expect(usage.RecentPulls[0].Time).To(Equal(dates[0]))
+	g.Expect(usage.LastPulledAt.Time).To(Equal(dates[MaxRecentPulls-1]))
+}
+
+func Test_addNodePulls(t *testing.T) {
+	g := NewWithT(t)
+	now := time.Now().Truncate(time.Second)
+
+	usage := kuikv1alpha1.Usage{}
+	addNodePulls(&usage, "", []time.Time{now})
+	g.Expect(usage.Nodes).To(BeEmpty())
+
+	addNodePulls(&usage, "node-1", []time.Time{now, now.Add(-time.Minute)})
+	addNodePulls(&usage, "node-2", []time.Time{now.Add(-time.Hour)})
+	g.Expect(usage.Nodes).To(HaveLen(2))
+	g.Expect(usage.Nodes[0].LastPulledAt.Time).To(Equal(now))
+
+	addNodePulls(&usage, "node-2", []time.Time{now})
+	addNodePulls(&usage, "node-1", []time.Time{now.Add(-time.Hour)})
+	g.Expect(usage.Nodes).To(HaveLen(2))
+	g.Expect(usage.Nodes[0].LastPulledAt.Time).To(Equal(now))
+	g.Expect(usage.Nodes[1].LastPulledAt.Time).To(Equal(now))
+
+	// The nodes that pulled the image the least recently are dropped first
+	addNodePulls(&usage, "node-3", []time.Time{now.Add(-time.Hour)})
+	for i := 4; i <= MaxNodePulls; i++ {
+		addNodePulls(&usage, fmt.Sprintf("node-%d", i), []time.Time{now})
+	}
+	g.Expect(usage.Nodes).To(HaveLen(MaxNodePulls))
+	addNodePulls(&usage, "node-new", []time.Time{now})
+	g.Expect(usage.Nodes).To(HaveLen(MaxNodePulls))
+	g.Expect(usage.Nodes).ToNot(ContainElement(HaveField("Name", "node-3")))
+	g.Expect(usage.Nodes).To(ContainElement(HaveField("Name", "node-new")))
+}
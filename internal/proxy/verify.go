@@ -0,0 +1,232 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// VerifyBlobs enables the verification of the digest of blobs served from the cache registry
+var VerifyBlobs = true
+
+var blobDigestRegexp = regexp.MustCompile(`/blobs/(sha256:[a-f0-9]{64})$`)
+
+// blobDigest returns the digest of the blob requested by a path, or an empty string if the path is not a blob
+func blobDigest(path string) string {
+	if subMatches := blobDigestRegexp.FindStringSubmatch(path); subMatches != nil {
+		return subMatches[1]
+	}
+	return ""
+}
+
+// maxQuarantinedBlobs bounds the number of blobs in quarantine, the oldest ones being released first. Released blobs
+// are verified again when they are served from the cache registry.
+const maxQuarantinedBlobs = 1000
+
+// Quarantine records the blobs found corrupted in the cache registry, which are served from their origin registry
+// from then on
+type Quarantine struct {
+	mutex   sync.RWMutex
+	digests map[string]struct{}
+	// order is the order blobs have been put in quarantine in
+	order []string
+}
+
+func NewQuarantine() *Quarantine {
+	return &Quarantine{digests: map[string]struct{}{}}
+}
+
+func (q *Quarantine) Add(digest string) {
+	if q == nil {
+		return
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if _, ok := q.digests[digest]; ok {
+		return
+	}
+	q.digests[digest] = struct{}{}
+	q.order = append(q.order, digest)
+	if len(q.order) > maxQuarantinedBlobs {
+		delete(q.digests, q.order[0])
+		q.order = q.order[1:]
+	}
+}
+
+func (q *Quarantine) Contains(digest string) bool {
+	if q == nil {
+		return false
+	}
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+	_, ok := q.digests[digest]
+	return ok
+}
+
+// BlobDigestMismatchError is returned while streaming a blob whose content doesn't match its digest
+type BlobDigestMismatchError struct {
+	Expected string
+	Actual   string
+}
+
+func (e *BlobDigestMismatchError) Error() string {
+	return fmt.Sprintf("blob digest mismatch: expected %s, got %s", e.Expected, e.Actual)
+}
+
+// verifyingReader hashes a blob as it is streamed. The last bytes of the blob are only returned once its digest has
+// been verified, so that a corrupted blob is never fully delivered: the response is cut short instead.
+type verifyingReader struct {
+	io.ReadCloser
+	hasher     hash.Hash
+	digest     string
+	size       int64
+	read       int64
+	err        error
+	onMismatch func(err *BlobDigestMismatchError)
+}
+
+func newVerifyingReader(body io.ReadCloser, digest string, size int64, onMismatch func(err *BlobDigestMismatchError)) *verifyingReader {
+	return &verifyingReader{
+		ReadCloser: body,
+		hasher:     sha256.New(),
+		digest:     digest,
+		size:       size,
+		onMismatch: onMismatch,
+	}
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	n, err := r.ReadCloser.Read(p)
+	r.hasher.Write(p[:n])
+	r.read += int64(n)
+
+	if r.read >= r.size || err == io.EOF {
+		if actual := fmt.Sprintf("sha256:%x", r.hasher.Sum(nil)); actual != r.digest {
+			mismatchErr := &BlobDigestMismatchError{Expected: r.digest, Actual: actual}
+			r.err = mismatchErr
+			r.onMismatch(mismatchErr)
+			return 0, r.err
+		}
+		if err == nil {
+			err = io.EOF
+		}
+		r.err = err
+	}
+
+	return n, err
+}
+
+// verifyBlob wraps the body of a response of the cache registry to a blob request of an image to verify its digest
+// while it is streamed. Blobs whose size is unknown are not verified since they couldn't be cut short.
+func (p *Proxy) verifyBlob(resp *http.Response, image string) {
+	if !VerifyBlobs || resp.Request.Method != http.MethodGet || resp.StatusCode != http.StatusOK || resp.ContentLength < 0 {
+		return
+	}
+
+	digest := blobDigest(resp.Request.URL.Path)
+	if digest == "" {
+		return
+	}
+
+	blobURL := *resp.Request.URL
+	resp.Body = newVerifyingReader(resp.Body, digest, resp.ContentLength, func(err *BlobDigestMismatchError) {
+		klog.ErrorS(err, "corrupted blob in cache, quarantining it", "url", blobURL.String())
+		p.quarantine.Add(digest)
+		if p.collector != nil {
+			p.collector.IncCorruptedBlob()
+		}
+		go p.invalidateBlob(image, digest, blobURL.String())
+	})
+}
+
+// invalidateBlob removes a corrupted blob from the repository of an image in the cache registry, and requests every
+// cached image of the repository referencing it to be put in cache again, so that the blob is pushed again and the
+// images sharing it are not considered cached while it is missing
+func (p *Proxy) invalidateBlob(image string, digest string, blobURL string) {
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+
+	var cachedImages kuikv1alpha1.CachedImageList
+	err := p.k8sClient.List(ctx, &cachedImages, client.MatchingLabels{kuikv1alpha1.RepositoryLabelName: registry.RepositoryLabel(image)})
+	if err != nil {
+		// The blob is kept in the cache registry so that the images referencing it stay consistent, it is served from
+		// its origin registry while it is in quarantine
+		klog.ErrorS(err, "could not list the images referencing a corrupted blob", "image", image, "digest", digest)
+		return
+	}
+
+	// Images are looked up before the blob is deleted, since their manifests may not be readable anymore afterwards
+	hash := v1.Hash{Algorithm: "sha256", Hex: strings.TrimPrefix(digest, "sha256:")}
+	var invalidated []kuikv1alpha1.CachedImage
+	for _, cachedImage := range cachedImages.Items {
+		if !cachedImage.Status.IsCached {
+			continue
+		}
+		blobs, err := p.imageBlobs(cachedImage.Spec.SourceImage)
+		if err != nil {
+			klog.ErrorS(err, "could not read the blobs of an image", "sourceImage", cachedImage.Spec.SourceImage)
+			continue
+		}
+		if _, ok := blobs[hash]; ok {
+			invalidated = append(invalidated, cachedImage)
+		}
+	}
+
+	deleteBlob(blobURL)
+
+	for i := range invalidated {
+		cachedImage := &invalidated[i]
+		klog.InfoS("image references a corrupted blob, putting it in cache again", "sourceImage", cachedImage.Spec.SourceImage, "digest", digest)
+		patch := client.MergeFrom(cachedImage.DeepCopy())
+		if cachedImage.Annotations == nil {
+			cachedImage.Annotations = map[string]string{}
+		}
+		cachedImage.Annotations[kuikv1alpha1.RecacheAnnotationName] = "true"
+		if err := p.k8sClient.Patch(ctx, cachedImage, patch); err != nil {
+			klog.ErrorS(err, "could not request an image referencing a corrupted blob to be put in cache again", "sourceImage", cachedImage.Spec.SourceImage)
+			continue
+		}
+		patch = client.MergeFrom(cachedImage.DeepCopy())
+		cachedImage.Status.IsCached = false
+		if err := p.k8sClient.Status().Patch(ctx, cachedImage, patch); err != nil {
+			klog.ErrorS(err, "could not mark an image referencing a corrupted blob as not cached", "sourceImage", cachedImage.Spec.SourceImage)
+		}
+	}
+}
+
+// deleteBlob removes a corrupted blob from the cache registry, so that it is pushed again when the images referencing
+// it are put in cache again
+func deleteBlob(blobURL string) {
+	req, err := http.NewRequest(http.MethodDelete, blobURL, nil)
+	if err != nil {
+		klog.ErrorS(err, "could not delete corrupted blob", "url", blobURL)
+		return
+	}
+
+	resp, err := registry.CacheHTTPClient().Do(req)
+	if err != nil {
+		klog.ErrorS(err, "could not delete corrupted blob", "url", blobURL)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		klog.ErrorS(fmt.Errorf("unexpected status %s", resp.Status), "could not delete corrupted blob", "url", blobURL)
+	}
+}
@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// chunkedReader returns at most 3 bytes per read, like a blob streamed over the network
+type chunkedReader struct {
+	io.Reader
+}
+
+func (r *chunkedReader) Read(p []byte) (int, error) {
+	if len(p) > 3 {
+		p = p[:3]
+	}
+	return r.Reader.Read(p)
+}
+
+func Test_blobDigest(t *testing.T) {
+	g := NewWithT(t)
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("blob")))
+	g.Expect(blobDigest("/v2/docker.io/library/alpine/blobs/" + digest)).To(Equal(digest))
+	g.Expect(blobDigest("/v2/docker.io/library/alpine/manifests/" + digest)).To(BeEmpty())
+	g.Expect(blobDigest("/v2/docker.io/library/alpine/blobs/uploads/")).To(BeEmpty())
+}
+
+func Test_verifyingReader(t *testing.T) {
+	blob := []byte("some blob content")
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+
+	tests := []struct {
+		name      string
+		content   []byte
+		size      int64
+		corrupted bool
+	}{
+		{
+			name:    "Valid blob",
+			content: blob,
+			size:    int64(len(blob)),
+		},
+		{
+			name:      "Corrupted blob",
+			content:   []byte("some blob c0ntent"),
+			size:      int64(len(blob)),
+			corrupted: true,
+		},
+		{
+			name:      "Truncated blob",
+			content:   blob[:10],
+			size:      int64(len(blob)),
+			corrupted: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			var mismatch *BlobDigestMismatchError
+			body := io.NopCloser(&chunkedReader{bytes.NewReader(tt.content)})
+			reader := newVerifyingReader(body, digest, tt.size, func(err *BlobDigestMismatchError) {
+				mismatch = err
+			})
+
+			read, err := io.ReadAll(reader)
+			if tt.corrupted {
+				g.Expect(err).To(Equal(mismatch))
+				g.Expect(mismatch.Expected).To(Equal(digest))
+				// The last chunk of a corrupted blob is never delivered
+				g.Expect(len(read)).To(BeNumerically("<", tt.size))
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(mismatch).To(BeNil())
+				g.Expect(read).To(Equal(tt.content))
+			}
+		})
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	g := NewWithT(t)
+
+	quarantine := NewQuarantine()
+	quarantine.Add("sha256:0000")
+	g.Expect(quarantine.Contains("sha256:0000")).To(BeTrue())
+	g.Expect(quarantine.Contains("sha256:1111")).To(BeFalse())
+
+	// The oldest blobs are released from quarantine first
+	for i := 0; i < maxQuarantinedBlobs; i++ {
+		quarantine.Add(fmt.Sprintf("sha256:%04d", i+1))
+	}
+	g.Expect(quarantine.digests).To(HaveLen(maxQuarantinedBlobs))
+	g.Expect(quarantine.Contains("sha256:0000")).To(BeFalse())
+	g.Expect(quarantine.Contains("sha256:0001")).To(BeTrue())
+
+	var nilQuarantine *Quarantine
+	nilQuarantine.Add("sha256:0000")
+	g.Expect(nilQuarantine.Contains("sha256:0000")).To(BeFalse())
+}
+
+func TestInvalidateBlob(t *testing.T) {
+	g := NewWithT(t)
+
+	deleted := []string{}
+	cacheRegistry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deleted = append(deleted, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer cacheRegistry.Close()
+
+	corrupted := v1.Hash{Algorithm: "sha256", Hex: "0000"}
+	cachedImage := func(name string, isCached bool) *kuikv1alpha1.CachedImage {
+		return &kuikv1alpha1.CachedImage{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{kuikv1alpha1.RepositoryLabelName: registry.RepositoryLabel("docker.io/library/alpine")},
+			},
+			Spec:   kuikv1alpha1.CachedImageSpec{SourceImage: "alpine:" + name},
+			Status: kuikv1alpha1.CachedImageStatus{IsCached: isCached},
+		}
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(
+		cachedImage("3.18", true),
+		cachedImage("3.19", true),
+		cachedImage("3.20", false),
+	).Build()
+	blobs := map[string]map[v1.Hash]int64{
+		"alpine:3.18": {corrupted: 1, {Algorithm: "sha256", Hex: "1111"}: 1},
+		"alpine:3.19": {{Algorithm: "sha256", Hex: "2222"}: 1},
+		"alpine:3.20": {corrupted: 1},
+	}
+	p := NewWithEngine(k8sClient, nil)
+	p.imageBlobs = func(imageName string) (map[v1.Hash]int64, error) {
+		return blobs[imageName], nil
+	}
+
+	// Only the cached images referencing the blob are put in cache again
+	p.invalidateBlob("docker.io/library/alpine", "sha256:0000", cacheRegistry.URL+"/v2/docker.io/library/alpine/blobs/sha256:0000")
+	g.Expect(deleted).To(Equal([]string{"DELETE /v2/docker.io/library/alpine/blobs/sha256:0000"}))
+
+	var cachedImages kuikv1alpha1.CachedImageList
+	g.Expect(k8sClient.List(context.Background(), &cachedImages)).To(Succeed())
+	g.Expect(cachedImages.Items).To(HaveLen(3))
+	for _, cachedImage := range cachedImages.Items {
+		g.Expect(cachedImage.Annotations[kuikv1alpha1.RecacheAnnotationName] == "true").To(Equal(cachedImage.Name == "3.18"), cachedImage.Name)
+		g.Expect(cachedImage.Status.IsCached).To(Equal(cachedImage.Name == "3.19"), cachedImage.Name)
+	}
+}
@@ -0,0 +1,112 @@
+package pulltoken
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/utils/strings/slices"
+)
+
+// minKeyLength is the minimum length of keys signing pull tokens
+const minKeyLength = 16
+
+var (
+	ErrInvalidToken = errors.New("invalid pull token")
+	ErrExpiredToken = errors.New("expired pull token")
+)
+
+// Signer issues and verifies short-lived pull tokens scoped to a single image, signed with a key shared by the
+// controllers and the proxy
+type Signer struct {
+	key []byte
+	now func() time.Time
+}
+
+func NewSigner(key []byte) (*Signer, error) {
+	if len(key) < minKeyLength {
+		return nil, fmt.Errorf("pull token key must be at least %d bytes long", minKeyLength)
+	}
+	return &Signer{key: key, now: time.Now}, nil
+}
+
+// LoadSigner reads the key of a Signer from a file, ignoring surrounding whitespaces
+func LoadSigner(path string) (*Signer, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewSigner(bytes.TrimSpace(key))
+}
+
+// Scope is what a pull token allows to pull: the manifests of a single image of a repository, by tag or digest, and the
+// blobs of the repository
+type Scope struct {
+	// Repository is the repository of the image, e.g. "docker.io/library/nginx"
+	Repository string `json:"repository"`
+	// Manifests are the references the manifests of the image can be pulled by, i.e. its tag, the digest of its
+	// manifest and the ones of the manifests of its platforms
+	Manifests []string `json:"manifests"`
+}
+
+// AllowsManifest tells whether the scope allows to pull the manifest of repository with the given tag or digest
+func (s Scope) AllowsManifest(repository string, reference string) bool {
+	return repository == s.Repository && slices.Contains(s.Manifests, reference)
+}
+
+type claims struct {
+	Scope
+	ExpiresAt int64 `json:"exp"`
+}
+
+// Issue returns a token allowing to pull the image of scope until expiresAt
+func (s *Signer) Issue(scope Scope, expiresAt time.Time) (string, error) {
+	payload, err := json.Marshal(claims{Scope: scope, ExpiresAt: expiresAt.Unix()})
+	if err != nil {
+		return "", err
+	}
+
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+	return encodedPayload + "." + base64.RawURLEncoding.EncodeToString(s.sign(encodedPayload)), nil
+}
+
+// Verify returns the scope of a token, or an error if it is invalid or expired
+func (s *Signer) Verify(token string) (Scope, error) {
+	encodedPayload, encodedSignature, ok := strings.Cut(token, ".")
+	if !ok {
+		return Scope{}, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, s.sign(encodedPayload)) {
+		return Scope{}, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return Scope{}, ErrInvalidToken
+	}
+	var c claims
+	if err := json.Unmarshal(payload, &c); err != nil || c.Repository == "" || len(c.Manifests) == 0 {
+		return Scope{}, ErrInvalidToken
+	}
+
+	if !s.now().Before(time.Unix(c.ExpiresAt, 0)) {
+		return Scope{}, ErrExpiredToken
+	}
+
+	return c.Scope, nil
+}
+
+func (s *Signer) sign(encodedPayload string) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(encodedPayload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,74 @@
+package pulltoken
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestSigner(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	signer, err := NewSigner([]byte("0123456789abcdef"))
+	g.Expect(err).ToNot(HaveOccurred())
+	signer.now = func() time.Time { return now }
+
+	scope := Scope{Repository: "docker.io/library/nginx", Manifests: []string{"1.25", "sha256:0123"}}
+	token, err := signer.Issue(scope, now.Add(time.Hour))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(signer.Verify(token)).To(Equal(scope))
+
+	// Tokens only allow to pull the manifests of their image
+	g.Expect(scope.AllowsManifest("docker.io/library/nginx", "1.25")).To(BeTrue())
+	g.Expect(scope.AllowsManifest("docker.io/library/nginx", "sha256:0123")).To(BeTrue())
+	g.Expect(scope.AllowsManifest("docker.io/library/nginx", "latest")).To(BeFalse())
+	g.Expect(scope.AllowsManifest("docker.io/library/alpine", "1.25")).To(BeFalse())
+
+	// Tokens scoped to a whole repository are rejected
+	repositoryToken, err := signer.Issue(Scope{Repository: "docker.io/library/nginx"}, now.Add(time.Hour))
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = signer.Verify(repositoryToken)
+	g.Expect(err).To(MatchError(ErrInvalidToken))
+
+	// Tokens are only valid until they expire
+	signer.now = func() time.Time { return now.Add(time.Hour) }
+	_, err = signer.Verify(token)
+	g.Expect(err).To(MatchError(ErrExpiredToken))
+	signer.now = func() time.Time { return now }
+
+	// Tokens signed with another key or tampered with are rejected
+	otherSigner, err := NewSigner([]byte("fedcba9876543210"))
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = otherSigner.Verify(token)
+	g.Expect(err).To(MatchError(ErrInvalidToken))
+
+	forged, err := otherSigner.Issue(scope, now.Add(time.Hour))
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = signer.Verify(forged)
+	g.Expect(err).To(MatchError(ErrInvalidToken))
+
+	for _, invalid := range []string{"", "no-signature", "a.b", token + "x"} {
+		_, err = signer.Verify(invalid)
+		g.Expect(err).To(MatchError(ErrInvalidToken), invalid)
+	}
+}
+
+func TestLoadSigner(t *testing.T) {
+	g := NewWithT(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "key")
+
+	g.Expect(os.WriteFile(path, []byte("short\n"), 0o600)).To(Succeed())
+	_, err := LoadSigner(path)
+	g.Expect(err).To(HaveOccurred())
+
+	g.Expect(os.WriteFile(path, []byte("0123456789abcdef\n"), 0o600)).To(Succeed())
+	signer, err := LoadSigner(path)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(signer.key).To(Equal([]byte("0123456789abcdef")))
+}
@@ -0,0 +1,20 @@
+package registry
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Airgapped forbids any request to upstream registries, for disconnected clusters where kube-image-keeper is the only
+// registry: the proxy only serves images already in cache and the controllers never pull images
+var Airgapped bool
+
+// ErrAirgapped is returned by requests to upstream registries in air-gapped mode
+var ErrAirgapped = errors.New("upstream registries are not reachable in air-gapped mode")
+
+// airgappedTransport fails every request, so that nothing reaches upstream registries in air-gapped mode
+type airgappedTransport struct{}
+
+func (airgappedTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, ErrAirgapped
+}
@@ -0,0 +1,26 @@
+package registry
+
+import (
+	"context"
+	"testing"
+
+	"github.com/enix/kube-image-keeper/pkg/registrytest"
+	. "github.com/onsi/gomega"
+)
+
+func TestAirgapped(t *testing.T) {
+	g := NewWithT(t)
+
+	upstream := registrytest.New(t)
+	upstream.PushImage(t, "shop/api:v1.0", registrytest.RandomImage(t, 1))
+
+	requests := len(upstream.Requests())
+
+	defer func() { Airgapped = false }()
+	Airgapped = true
+
+	_, err := RepositoryTags(upstream.Addr()+"/shop/api", nil, nil, nil)
+	g.Expect(err).To(MatchError(ErrAirgapped))
+	g.Expect(CacheImage(context.Background(), upstream.Addr()+"/shop/api:v1.0", nil, nil, nil, nil, nil)).To(MatchError(ErrAirgapped))
+	g.Expect(upstream.Requests()).To(HaveLen(requests))
+}
@@ -0,0 +1,176 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// CacheArtifacts tells whether the signatures, attestations and SBOMs of images are put in cache along with them, so
+// that policy controllers verifying images pulled through the proxy find them
+var CacheArtifacts = false
+
+// cosignTagSuffixes are the suffixes of the tags cosign stores the signatures, attestations and SBOMs of a manifest
+// under, after its digest, e.g. sha256-<hex>.sig
+var cosignTagSuffixes = []string{".sig", ".att", ".sbom"}
+
+// artifact is a manifest attached to the manifest of an image, either under a cosign tag or as an OCI referrer
+type artifact struct {
+	// Tag is the cosign tag of the artifact, empty for referrers which are referenced by digest
+	Tag        string
+	Descriptor *remote.Descriptor
+}
+
+// upstreamArtifacts returns the artifacts attached to the manifest of repository with the given digest. Cosign tags
+// are looked up with HEAD requests first, since most images have none and HEAD requests don't count toward the pull
+// rate limit of some registries.
+func upstreamArtifacts(repository name.Repository, digest v1.Hash, options ...remote.Option) ([]artifact, error) {
+	artifacts := []artifact{}
+
+	for _, suffix := range cosignTagSuffixes {
+		tag := repository.Tag(digest.Algorithm + "-" + digest.Hex + suffix)
+		if _, err := remote.Head(tag, options...); err != nil {
+			if errIsImageNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		desc, err := remote.Get(tag, options...)
+		if err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, artifact{Tag: tag.TagStr(), Descriptor: desc})
+	}
+
+	referrers, err := remote.Referrers(repository.Digest(digest.String()), options...)
+	if err != nil {
+		if referrersUnsupported(err) {
+			return artifacts, nil
+		}
+		return nil, err
+	}
+	manifest, err := referrers.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, referrer := range manifest.Manifests {
+		desc, err := remote.Get(repository.Digest(referrer.Digest.String()), options...)
+		if err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, artifact{Descriptor: desc})
+	}
+
+	return artifacts, nil
+}
+
+// referrersUnsupported tells whether an error listing referrers comes from a registry that supports neither the
+// Referrers API nor the referrers tag schema, some registries rejecting the requests they don't know instead of
+// answering them with a 404
+func referrersUnsupported(err error) bool {
+	var transportErr *transport.Error
+	if !errors.As(err, &transportErr) {
+		return false
+	}
+	switch transportErr.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusMethodNotAllowed:
+		return true
+	}
+	return false
+}
+
+// artifactSubjects returns the digests of the manifests of an index put in cache whose artifacts are cached as well:
+// the ones of its platforms, and its own digest unless the index has been filtered
+func artifactSubjects(digest v1.Hash, filteredIndex v1.ImageIndex) ([]v1.Hash, error) {
+	subjects := []v1.Hash{}
+	if filteredDigest, err := filteredIndex.Digest(); err != nil {
+		return nil, err
+	} else if filteredDigest == digest {
+		subjects = append(subjects, digest)
+	}
+
+	manifest, err := filteredIndex.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, desc := range manifest.Manifests {
+		subjects = append(subjects, desc.Digest)
+	}
+	return subjects, nil
+}
+
+// cacheArtifactsOf puts in cache the artifacts attached upstream to the manifests with the given digests. Images are
+// cached without the artifacts that could not be, which are only logged.
+func cacheArtifactsOf(ctx context.Context, source name.Repository, destination name.Repository, digests []v1.Hash, options ...remote.Option) {
+	for _, digest := range digests {
+		artifacts, err := upstreamArtifacts(source, digest, options...)
+		if err == nil {
+			err = cacheArtifacts(destination, artifacts)
+		}
+		if err != nil {
+			log.FromContext(ctx).Error(err, "could not cache signatures, attestations and SBOMs", "digest", digest.String())
+		}
+	}
+}
+
+// cacheArtifacts writes artifacts to a repository of the cache, under their cosign tag or by digest. Referrers are
+// indexed by the cache registry, or under the tag of the referrers tag schema if it doesn't support the Referrers API.
+func cacheArtifacts(repository name.Repository, artifacts []artifact) error {
+	for _, artifact := range artifacts {
+		var ref name.Reference = repository.Digest(artifact.Descriptor.Digest.String())
+		if artifact.Tag != "" {
+			ref = repository.Tag(artifact.Tag)
+		}
+		if err := writeDescriptor(ref, artifact.Descriptor, cacheOptions()...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CachedReferrers returns the referrers in cache of the manifest of repository with the given digest, only the ones of
+// artifactType if not empty, e.g. to answer the Referrers API which the cache registry may not support
+func CachedReferrers(repository string, digest string, artifactType string) (*v1.IndexManifest, error) {
+	ref, err := parseLocalReference(repository + "@" + digest)
+	if err != nil {
+		return nil, err
+	}
+	digestRef, ok := ref.(name.Digest)
+	if !ok {
+		return nil, fmt.Errorf("invalid digest %q", digest)
+	}
+
+	options := cacheOptions()
+	if artifactType != "" {
+		options = append(options, remote.WithFilter("artifactType", artifactType))
+	}
+	referrers, err := remote.Referrers(digestRef, options...)
+	if err != nil {
+		return nil, err
+	}
+	return referrers.IndexManifest()
+}
+
+// writeDescriptor writes the image or the index of a descriptor to ref
+func writeDescriptor(ref name.Reference, desc *remote.Descriptor, options ...remote.Option) error {
+	if desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return err
+		}
+		return remote.WriteIndex(ref, index, options...)
+	}
+
+	image, err := desc.Image()
+	if err != nil {
+		return err
+	}
+	return remote.Write(ref, image, options...)
+}
@@ -0,0 +1,93 @@
+package registry
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/enix/kube-image-keeper/pkg/registrytest"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	. "github.com/onsi/gomega"
+)
+
+// sbomReferrer returns an SBOM artifact referring to the manifest described by subject
+func sbomReferrer(g *WithT, subject v1.Descriptor) v1.Image {
+	sbom, err := mutate.Append(mutate.MediaType(empty.Image, types.OCIManifestSchema1), mutate.Addendum{
+		Layer: static.NewLayer([]byte(`{"spdxVersion":"SPDX-2.3"}`), "application/spdx+json"),
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	return mutate.Subject(mutate.ConfigMediaType(sbom, "application/spdx+json"), subject).(v1.Image)
+}
+
+func TestCacheImageArtifacts(t *testing.T) {
+	g := NewWithT(t)
+
+	upstream := registrytest.New(t)
+	cache := registrytest.New(t)
+	Endpoint = cache.Addr()
+	cachedRepository := strings.ReplaceAll(upstream.Addr(), ":", "-") + "/shop/app"
+	CacheArtifacts = true
+	t.Cleanup(func() { CacheArtifacts = false })
+
+	index := mutate.IndexMediaType(registrytest.PlatformIndex(t, "linux/amd64", "linux/arm64"), types.OCIImageIndex)
+	upstream.PushIndex(t, "shop/app:signed", index)
+	digest, err := index.Digest()
+	g.Expect(err).ToNot(HaveOccurred())
+	indexManifest, err := index.IndexManifest()
+	g.Expect(err).ToNot(HaveOccurred())
+	amd64, arm64 := indexManifest.Manifests[0], indexManifest.Manifests[1]
+
+	// Cosign signatures of the index and of the arm64 image, and an SBOM of the amd64 image attached as an OCI referrer
+	upstream.PushImage(t, "shop/app:sha256-"+digest.Hex+".sig", registrytest.RandomImage(t, 1))
+	upstream.PushImage(t, "shop/app:sha256-"+arm64.Digest.Hex+".sig", registrytest.RandomImage(t, 1))
+	sbom := sbomReferrer(g, amd64)
+	sbomDigest, err := sbom.Digest()
+	g.Expect(err).ToNot(HaveOccurred())
+	upstream.PushImage(t, "shop/app@"+sbomDigest.String(), sbom)
+
+	g.Expect(CacheImage(context.Background(), upstream.Addr()+"/shop/app:signed", nil, []string{"amd64"}, nil, nil, nil)).To(Succeed())
+
+	// Only the architectures put in cache are kept, along with their artifacts
+	cachedIndex, err := remote.Index(cache.Reference(t, cachedRepository+":signed"))
+	g.Expect(err).ToNot(HaveOccurred())
+	manifest, err := cachedIndex.IndexManifest()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(manifest.Manifests).To(HaveLen(1))
+
+	referrers, err := CachedReferrers(upstream.Addr()+"/shop/app", amd64.Digest.String(), "")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(referrers.Manifests).To(HaveLen(1))
+	g.Expect(referrers.Manifests[0].Digest).To(Equal(sbomDigest))
+	g.Expect(referrers.Manifests[0].ArtifactType).To(Equal("application/spdx+json"))
+	referrers, err = CachedReferrers(upstream.Addr()+"/shop/app", amd64.Digest.String(), "application/vnd.dev.cosign.artifact.sig.v1+json")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(referrers.Manifests).To(BeEmpty())
+
+	// The signature of the index refers to a digest that is not in cache, and arm64 is not cached
+	_, err = remote.Image(cache.Reference(t, cachedRepository+":sha256-"+digest.Hex+".sig"))
+	g.Expect(err).To(HaveOccurred())
+	_, err = remote.Image(cache.Reference(t, cachedRepository+":sha256-"+arm64.Digest.Hex+".sig"))
+	g.Expect(err).To(HaveOccurred())
+
+	// Indexes cached as is keep their own artifacts
+	g.Expect(CacheImage(context.Background(), upstream.Addr()+"/shop/app:signed", nil, []string{"amd64", "arm64"}, nil, nil, nil)).To(Succeed())
+	cachedDigest, err := ImageDigest(upstream.Addr() + "/shop/app:signed")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cachedDigest).To(Equal(digest))
+	_, err = remote.Image(cache.Reference(t, cachedRepository+":sha256-"+digest.Hex+".sig"))
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = remote.Image(cache.Reference(t, cachedRepository+":sha256-"+arm64.Digest.Hex+".sig"))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Images are cached even if their artifacts can't be
+	upstream.PushIndex(t, "shop/app:unlisted", registrytest.PlatformIndex(t, "linux/amd64"))
+	upstream.Fail(registrytest.Failure{PathContains: "/referrers/", StatusCode: 500})
+	g.Expect(CacheImage(context.Background(), upstream.Addr()+"/shop/app:unlisted", nil, []string{"amd64"}, nil, nil, nil)).To(Succeed())
+	_, err = remote.Index(cache.Reference(t, cachedRepository+":unlisted"))
+	g.Expect(err).ToNot(HaveOccurred())
+}
@@ -0,0 +1,58 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// StorageBackend is the storage backend of the cache registry, as configured by the Helm chart
+type StorageBackend string
+
+const (
+	StorageEphemeral        = StorageBackend("ephemeral")
+	StoragePersistentVolume = StorageBackend("persistent-volume")
+	StorageMinio            = StorageBackend("minio")
+	StorageS3               = StorageBackend("s3")
+	StorageAzure            = StorageBackend("azure")
+	StorageGCS              = StorageBackend("gcs")
+)
+
+var storageBackends = []StorageBackend{StorageEphemeral, StoragePersistentVolume, StorageMinio, StorageS3, StorageAzure, StorageGCS}
+
+// ParseStorageBackend validates the name of a storage backend, an empty name meaning that the backend is unknown
+func ParseStorageBackend(name string) (StorageBackend, error) {
+	if name == "" {
+		return "", nil
+	}
+	for _, backend := range storageBackends {
+		if StorageBackend(name) == backend {
+			return backend, nil
+		}
+	}
+	return "", fmt.Errorf("unknown registry storage backend %q, expected one of %v", name, storageBackends)
+}
+
+// ObjectStorage tells whether the content of the cache is stored in an object storage outside of the registry pods,
+// which the registry checks periodically when its storage driver health check is enabled
+func (b StorageBackend) ObjectStorage() bool {
+	return b == StorageMinio || b == StorageS3 || b == StorageAzure || b == StorageGCS
+}
+
+var healthClient = &http.Client{Timeout: 5 * time.Second}
+
+// CheckHealth checks that the cache registry answers. The registry answers 503 to every request while the health check
+// of its storage driver fails, e.g. when its bucket is unreachable.
+func CheckHealth() error {
+	response, err := healthClient.Get(Protocol + Endpoint + "/v2/")
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusUnauthorized {
+		return fmt.Errorf("registry answered with status %s", response.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestParseStorageBackend(t *testing.T) {
+	g := NewWithT(t)
+
+	backend, err := ParseStorageBackend("gcs")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(backend).To(Equal(StorageGCS))
+	g.Expect(backend.ObjectStorage()).To(BeTrue())
+
+	backend, err = ParseStorageBackend("")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(backend.ObjectStorage()).To(BeFalse())
+
+	g.Expect(StoragePersistentVolume.ObjectStorage()).To(BeFalse())
+
+	_, err = ParseStorageBackend("swift")
+	g.Expect(err).To(MatchError(ContainSubstring(`unknown registry storage backend "swift"`)))
+}
+
+func TestCheckHealth(t *testing.T) {
+	g := NewWithT(t)
+
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	Endpoint = strings.TrimPrefix(server.URL, "http://")
+
+	g.Expect(CheckHealth()).To(Succeed())
+
+	status = http.StatusServiceUnavailable
+	g.Expect(CheckHealth()).To(MatchError("registry answered with status 503 Service Unavailable"))
+}
@@ -0,0 +1,228 @@
+package registry
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// UpstreamBudget limits pulls from upstream registries, it is unlimited by default
+var UpstreamBudget = NewBudget()
+
+// Limit is an amount allowed per time window, the zero Limit being unlimited
+type Limit struct {
+	Amount int64
+	Window time.Duration
+}
+
+// ParseLimit parses a limit like "500/1h", amounts being parsed as quantities when bytes is true, e.g. "50Gi/24h"
+func ParseLimit(str string, bytes bool) (Limit, error) {
+	if str == "" {
+		return Limit{}, nil
+	}
+
+	amountStr, windowStr, ok := strings.Cut(str, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid limit %q, expected <amount>/<window>", str)
+	}
+
+	var amount int64
+	if bytes {
+		quantity, err := resource.ParseQuantity(amountStr)
+		if err != nil {
+			return Limit{}, fmt.Errorf("invalid amount in limit %q: %w", str, err)
+		}
+		amount = quantity.Value()
+	} else {
+		var err error
+		if amount, err = strconv.ParseInt(amountStr, 10, 64); err != nil {
+			return Limit{}, fmt.Errorf("invalid amount in limit %q: %w", str, err)
+		}
+	}
+
+	window, err := time.ParseDuration(windowStr)
+	if err != nil {
+		return Limit{}, fmt.Errorf("invalid window in limit %q: %w", str, err)
+	}
+	if amount <= 0 || window <= 0 {
+		return Limit{}, fmt.Errorf("invalid limit %q, amount and window must be positive", str)
+	}
+
+	return Limit{Amount: amount, Window: window}, nil
+}
+
+func (l Limit) IsUnlimited() bool {
+	return l.Amount <= 0 || l.Window <= 0
+}
+
+// usage is the amount used during a fixed time window starting at the first use, or since the start when unlimited
+type usage struct {
+	start time.Time
+	used  int64
+}
+
+func (u *usage) reset(limit Limit, now time.Time) {
+	if u.start.IsZero() || (!limit.IsUnlimited() && now.Sub(u.start) >= limit.Window) {
+		u.start = now
+		u.used = 0
+	}
+}
+
+// Budget limits the number of manifests and the amount of bytes pulled from upstream registries per time window.
+// Images are pulled as long as the budget is not exhausted, so the last pull of a window may exceed it.
+type Budget struct {
+	Manifests Limit
+	Bytes     Limit
+
+	mutex     sync.Mutex
+	manifests usage
+	bytes     usage
+	now       func() time.Time
+}
+
+func NewBudget() *Budget {
+	return &Budget{now: time.Now}
+}
+
+// BudgetExceededError is returned when an image can't be pulled without exceeding the upstream budget
+type BudgetExceededError struct {
+	Resource   string
+	RetryAfter time.Duration
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("upstream %s budget exhausted, retrying in %s", e.Resource, e.RetryAfter.Round(time.Second))
+}
+
+// Check returns a BudgetExceededError if the budget is exhausted
+func (b *Budget) Check() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := b.now()
+	for _, budget := range []struct {
+		resource string
+		limit    Limit
+		usage    *usage
+	}{
+		{"manifests", b.Manifests, &b.manifests},
+		{"bytes", b.Bytes, &b.bytes},
+	} {
+		budget.usage.reset(budget.limit, now)
+		if !budget.limit.IsUnlimited() && budget.usage.used >= budget.limit.Amount {
+			return &BudgetExceededError{
+				Resource:   budget.resource,
+				RetryAfter: budget.usage.start.Add(budget.limit.Window).Sub(now),
+			}
+		}
+	}
+
+	return nil
+}
+
+// Used returns the number of manifests and bytes pulled during the current time windows
+func (b *Budget) Used() (manifests int64, bytes int64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := b.now()
+	b.manifests.reset(b.Manifests, now)
+	b.bytes.reset(b.Bytes, now)
+
+	return b.manifests.used, b.bytes.used
+}
+
+// BudgetQuota is what is left of a limit of the budget until the end of its current time window
+type BudgetQuota struct {
+	Resource  string
+	Limit     Limit
+	Remaining int64
+	ResetAt   time.Time
+}
+
+// Quotas returns what is left of the manifests and bytes limits of the budget, unlimited ones being omitted. Time
+// windows start at the first use, so the window of an unused limit is considered to start now.
+func (b *Budget) Quotas() []BudgetQuota {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := b.now()
+	quotas := []BudgetQuota{}
+	for _, budget := range []struct {
+		resource string
+		limit    Limit
+		usage    *usage
+	}{
+		{"manifests", b.Manifests, &b.manifests},
+		{"bytes", b.Bytes, &b.bytes},
+	} {
+		budget.usage.reset(budget.limit, now)
+		if budget.limit.IsUnlimited() {
+			continue
+		}
+		remaining := budget.limit.Amount - budget.usage.used
+		if remaining < 0 {
+			remaining = 0
+		}
+		quotas = append(quotas, BudgetQuota{
+			Resource:  budget.resource,
+			Limit:     budget.limit,
+			Remaining: remaining,
+			ResetAt:   budget.usage.start.Add(budget.limit.Window),
+		})
+	}
+
+	return quotas
+}
+
+func (b *Budget) record(manifests int64, bytes int64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := b.now()
+	b.manifests.reset(b.Manifests, now)
+	b.bytes.reset(b.Bytes, now)
+	b.manifests.used += manifests
+	b.bytes.used += bytes
+}
+
+// Transport returns a transport recording the manifests and bytes pulled through it in the budget
+func (b *Budget) Transport(inner http.RoundTripper) http.RoundTripper {
+	return &budgetTransport{inner: inner, budget: b}
+}
+
+type budgetTransport struct {
+	inner  http.RoundTripper
+	budget *Budget
+}
+
+func (t *budgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.inner.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if IsManifestResponse(req, resp) {
+		t.budget.record(1, 0)
+	}
+	resp.Body = &budgetReader{ReadCloser: resp.Body, budget: t.budget}
+
+	return resp, nil
+}
+
+type budgetReader struct {
+	io.ReadCloser
+	budget *Budget
+}
+
+func (r *budgetReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.budget.record(0, int64(n))
+	return n, err
+}
@@ -0,0 +1,151 @@
+package registry
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		str      string
+		bytes    bool
+		expected Limit
+		wantErr  bool
+	}{
+		{
+			name: "Unlimited",
+		},
+		{
+			name:     "Manifests",
+			str:      "500/1h",
+			expected: Limit{Amount: 500, Window: time.Hour},
+		},
+		{
+			name:     "Bytes",
+			str:      "50Gi/24h",
+			bytes:    true,
+			expected: Limit{Amount: 50 << 30, Window: 24 * time.Hour},
+		},
+		{
+			name:    "Quantity without bytes",
+			str:     "50Gi/24h",
+			wantErr: true,
+		},
+		{
+			name:    "Missing window",
+			str:     "500",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid window",
+			str:     "500/hour",
+			wantErr: true,
+		},
+		{
+			name:    "Zero amount",
+			str:     "0/1h",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			limit, err := ParseLimit(tt.str, tt.bytes)
+			if tt.wantErr {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(limit).To(Equal(tt.expected))
+			}
+		})
+	}
+}
+
+func TestBudget(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Now()
+	budget := NewBudget()
+	budget.now = func() time.Time { return now }
+	budget.Manifests = Limit{Amount: 2, Window: time.Hour}
+	budget.Bytes = Limit{Amount: 100, Window: 24 * time.Hour}
+
+	g.Expect(budget.Check()).To(Succeed())
+	budget.record(2, 10)
+	err := budget.Check()
+	g.Expect(err).To(BeAssignableToTypeOf(&BudgetExceededError{}))
+	g.Expect(err.(*BudgetExceededError).Resource).To(Equal("manifests"))
+	g.Expect(err.(*BudgetExceededError).RetryAfter).To(Equal(time.Hour))
+
+	now = now.Add(time.Hour)
+	g.Expect(budget.Check()).To(Succeed())
+	manifests, bytes := budget.Used()
+	g.Expect(manifests).To(BeEquivalentTo(0))
+	g.Expect(bytes).To(BeEquivalentTo(10))
+
+	budget.record(1, 90)
+	err = budget.Check()
+	g.Expect(err).To(BeAssignableToTypeOf(&BudgetExceededError{}))
+	g.Expect(err.(*BudgetExceededError).Resource).To(Equal("bytes"))
+	g.Expect(err.(*BudgetExceededError).RetryAfter).To(Equal(23 * time.Hour))
+
+	manifests, bytes = budget.Used()
+	g.Expect(manifests).To(BeEquivalentTo(1))
+	g.Expect(bytes).To(BeEquivalentTo(100))
+
+	g.Expect(NewBudget().Check()).To(Succeed())
+}
+
+func TestBudget_Quotas(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Now()
+	budget := NewBudget()
+	budget.now = func() time.Time { return now }
+	g.Expect(budget.Quotas()).To(BeEmpty())
+
+	budget.Manifests = Limit{Amount: 10, Window: time.Hour}
+	budget.record(4, 10)
+	now = now.Add(10 * time.Minute)
+	g.Expect(budget.Quotas()).To(Equal([]BudgetQuota{{
+		Resource:  "manifests",
+		Limit:     budget.Manifests,
+		Remaining: 6,
+		ResetAt:   now.Add(50 * time.Minute),
+	}}))
+
+	budget.record(12, 0)
+	g.Expect(budget.Quotas()[0].Remaining).To(BeEquivalentTo(0))
+}
+
+func TestBudgetTransport(t *testing.T) {
+	g := NewWithT(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, strings.Repeat("a", 42))
+	}))
+	defer server.Close()
+
+	budget := NewBudget()
+	client := &http.Client{Transport: budget.Transport(http.DefaultTransport)}
+
+	for _, path := range []string{"/v2/alpine/manifests/latest", "/v2/alpine/blobs/sha256:a"} {
+		resp, err := client.Get(server.URL + path)
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = io.Copy(io.Discard, resp.Body)
+		g.Expect(err).ToNot(HaveOccurred())
+		resp.Body.Close()
+	}
+
+	manifests, bytes := budget.Used()
+	g.Expect(manifests).To(BeEquivalentTo(1))
+	g.Expect(bytes).To(BeEquivalentTo(84))
+}
@@ -0,0 +1,263 @@
+package registry
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// BundleRefNameAnnotation is the annotation of the manifests of a bundle holding the source image name of the images
+const BundleRefNameAnnotation = "org.opencontainers.image.ref.name"
+
+// ExportBundle writes images stored in cache, with every platform cached, to w as a tarball of an OCI image layout.
+// Images are listed in the index of the layout with their source image name in the BundleRefNameAnnotation, so that
+// ImportBundle loads them back in the cache registry of another cluster.
+func ExportBundle(w io.Writer, imageNames []string) error {
+	dir, err := os.MkdirTemp("", "kuik-bundle-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	path, err := layout.Write(dir, empty.Index)
+	if err != nil {
+		return err
+	}
+
+	for _, imageName := range imageNames {
+		if err := appendToLayout(path, imageName); err != nil {
+			return fmt.Errorf("could not export image %s: %w", imageName, err)
+		}
+	}
+
+	return writeTar(w, dir)
+}
+
+// appendToLayout adds an image stored in cache to an OCI image layout
+func appendToLayout(path layout.Path, imageName string) error {
+	ref, err := parseLocalReference(imageName)
+	if err != nil {
+		return err
+	}
+
+	desc, err := remote.Get(ref, cacheOptions()...)
+	if err != nil {
+		return err
+	}
+
+	annotations := layout.WithAnnotations(map[string]string{BundleRefNameAnnotation: imageName})
+	if desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return err
+		}
+		return path.AppendIndex(index, annotations)
+	}
+
+	image, err := desc.Image()
+	if err != nil {
+		return err
+	}
+	return path.AppendImage(image, annotations)
+}
+
+// ImportOptions tells how the images of a bundle are imported
+type ImportOptions struct {
+	// MaxSize is the maximum size of the files of a bundle, which are extracted to a temporary directory before being
+	// imported, 0 disabling the limit
+	MaxSize int64
+	// SkipPolicies imports images without checking them against ImageSignaturePolicy and BaseImagesPolicy, e.g. when
+	// their signatures or their base images can't be fetched from their upstream registry
+	SkipPolicies bool
+}
+
+// ImportBundle loads the images of a tarball written by ExportBundle, optionally gzipped, in the cache registry and
+// returns their source image names. Manifests of the index without a BundleRefNameAnnotation are ignored. Images are
+// checked against ImageSignaturePolicy and BaseImagesPolicy before being written, as when they are put in cache from
+// upstream, unless the options skip policies.
+func ImportBundle(r io.Reader, options ImportOptions) ([]string, error) {
+	dir, err := os.MkdirTemp("", "kuik-bundle-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := extractTar(r, dir, options.MaxSize); err != nil {
+		return nil, err
+	}
+
+	index, err := layout.ImageIndexFromPath(dir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle: %w", err)
+	}
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	imageNames := []string{}
+	for _, manifest := range indexManifest.Manifests {
+		imageName := manifest.Annotations[BundleRefNameAnnotation]
+		if imageName == "" {
+			continue
+		}
+		if !options.SkipPolicies {
+			if err := checkPolicies(index, manifest, imageName); err != nil {
+				return imageNames, fmt.Errorf("could not import image %s: %w", imageName, err)
+			}
+		}
+		if err := importFromLayout(index, manifest, imageName); err != nil {
+			return imageNames, fmt.Errorf("could not import image %s: %w", imageName, err)
+		}
+		imageNames = append(imageNames, imageName)
+	}
+
+	return imageNames, nil
+}
+
+// checkPolicies checks an image of an OCI image layout against ImageSignaturePolicy and BaseImagesPolicy, fetching its
+// signatures and its base images from upstream
+func checkPolicies(index v1.ImageIndex, manifest v1.Descriptor, imageName string) error {
+	sourceRef, err := name.ParseReference(imageName)
+	if err != nil {
+		return err
+	}
+
+	opts := upstreamOptions(sourceRef, authn.DefaultKeychain, nil, nil)
+	if err := ImageSignaturePolicy.Verify(sourceRef, manifest.Digest, opts...); err != nil {
+		return err
+	}
+	if !manifest.MediaType.IsIndex() {
+		return nil
+	}
+	imageIndex, err := index.ImageIndex(manifest.Digest)
+	if err != nil {
+		return err
+	}
+	return BaseImagesPolicy.Check(imageIndex, opts...)
+}
+
+// importFromLayout writes an image of an OCI image layout to the cache registry
+func importFromLayout(index v1.ImageIndex, manifest v1.Descriptor, imageName string) error {
+	ref, err := parseLocalReference(imageName)
+	if err != nil {
+		return err
+	}
+
+	if manifest.MediaType.IsIndex() {
+		imageIndex, err := index.ImageIndex(manifest.Digest)
+		if err != nil {
+			return err
+		}
+		return remote.WriteIndex(ref, imageIndex, cacheOptions()...)
+	}
+
+	image, err := index.Image(manifest.Digest)
+	if err != nil {
+		return err
+	}
+	return remote.Write(ref, image, cacheOptions()...)
+}
+
+// writeTar writes the regular files of a directory to w as a tarball
+func writeTar(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tw, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// extractTar extracts the regular files of a tarball, optionally gzipped, to a directory, refusing files that would
+// be written outside of it. It returns a LimitExceededError once the files would be larger than maxSize, unless it is 0.
+func extractTar(r io.Reader, dir string, maxSize int64) error {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		r = gr
+	} else {
+		r = br
+	}
+
+	tr := tar.NewReader(r)
+	size := int64(0)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("invalid bundle: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		path := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(path, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid bundle: file %s is outside of the image layout", header.Name)
+		}
+		size += header.Size
+		if maxSize > 0 && size > maxSize {
+			return &LimitExceededError{Limit: "bundle size", Value: size, Max: maxSize}
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, tr)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
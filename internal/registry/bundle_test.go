@@ -0,0 +1,87 @@
+package registry
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"testing"
+
+	"github.com/enix/kube-image-keeper/pkg/registrytest"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/onsi/gomega"
+)
+
+func TestBundle(t *testing.T) {
+	g := NewWithT(t)
+
+	source := registrytest.New(t)
+	Endpoint = source.Addr()
+
+	ref, err := parseLocalReference("alpine:3.19")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(remote.Write(ref, registrytest.RandomImage(t, 2))).To(Succeed())
+	ref, err = parseLocalReference("quay.io/prometheus/node-exporter:v1.7.0")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(remote.WriteIndex(ref, registrytest.RandomIndex(t, 2, 1))).To(Succeed())
+
+	alpineDigest, err := ImageDigest("alpine:3.19")
+	g.Expect(err).ToNot(HaveOccurred())
+	nodeExporterDigest, err := ImageDigest("quay.io/prometheus/node-exporter:v1.7.0")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	bundle := bytes.Buffer{}
+	g.Expect(ExportBundle(&bundle, []string{"alpine:3.19", "quay.io/prometheus/node-exporter:v1.7.0"})).To(Succeed())
+	g.Expect(ExportBundle(&bytes.Buffer{}, []string{"redis:7"})).ToNot(Succeed())
+
+	// Images are loaded in the cache registry of another cluster with the same digests, gzipped bundles included
+	gzipped := bytes.Buffer{}
+	gw := gzip.NewWriter(&gzipped)
+	_, err = gw.Write(bundle.Bytes())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(gw.Close()).To(Succeed())
+
+	for _, bundle := range []*bytes.Buffer{&bundle, &gzipped} {
+		destination := registrytest.New(t)
+		Endpoint = destination.Addr()
+
+		g.Expect(ImportBundle(bytes.NewReader(bundle.Bytes()), ImportOptions{})).To(Equal([]string{"alpine:3.19", "quay.io/prometheus/node-exporter:v1.7.0"}))
+		g.Expect(ImageDigest("alpine:3.19")).To(Equal(alpineDigest))
+		g.Expect(ImageDigest("quay.io/prometheus/node-exporter:v1.7.0")).To(Equal(nodeExporterDigest))
+	}
+
+	// Images are checked against the signature policy, fetching their signatures from upstream
+	destination := registrytest.New(t)
+	Endpoint = destination.Addr()
+	signaturePolicy, airgapped := ImageSignaturePolicy, Airgapped
+	defer func() { ImageSignaturePolicy, Airgapped = signaturePolicy, airgapped }()
+	_, publicKey := generateKey(g)
+	data, err := json.Marshal(map[string]interface{}{
+		"registries": []interface{}{map[string]interface{}{"registry": "quay.io", "keys": []string{publicKey}}},
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	ImageSignaturePolicy, err = ParseSignaturePolicy(data)
+	g.Expect(err).ToNot(HaveOccurred())
+	Airgapped = true
+	imageNames, err := ImportBundle(bytes.NewReader(bundle.Bytes()), ImportOptions{})
+	g.Expect(err).To(MatchError(ErrAirgapped))
+	g.Expect(imageNames).To(Equal([]string{"alpine:3.19"}))
+	g.Expect(ImportBundle(bytes.NewReader(bundle.Bytes()), ImportOptions{SkipPolicies: true})).To(HaveLen(2))
+
+	// Bundles can't be larger than the maximum size
+	_, err = ImportBundle(bytes.NewReader(bundle.Bytes()), ImportOptions{MaxSize: 1024})
+	g.Expect(err).To(MatchError(ContainSubstring("exceeds the limit of 1024")))
+
+	// Files can't be extracted outside of the image layout
+	malicious := bytes.Buffer{}
+	tw := tar.NewWriter(&malicious)
+	g.Expect(tw.WriteHeader(&tar.Header{Name: "../oci-layout", Typeflag: tar.TypeReg, Size: 2, Mode: 0o644})).To(Succeed())
+	_, err = tw.Write([]byte("{}"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tw.Close()).To(Succeed())
+	_, err = ImportBundle(&malicious, ImportOptions{})
+	g.Expect(err).To(MatchError(ContainSubstring("outside of the image layout")))
+
+	_, err = ImportBundle(bytes.NewBufferString("not a tarball"), ImportOptions{})
+	g.Expect(err).To(HaveOccurred())
+}
@@ -0,0 +1,87 @@
+package registry
+
+import (
+	"sync"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// UpstreamDigests memoizes digests of upstream images, shared by every reconcile
+var UpstreamDigests = NewDigestCache(30 * time.Second)
+
+// DigestCache memoizes the digests of images by reference for a short time, so that many reconciles of images with
+// the same mutable tag don't trigger as many identical requests to the upstream registry. Concurrent lookups of the same
+// reference are merged into a single request. Failed lookups are not memoized.
+type DigestCache struct {
+	// TTL is how long digests are memoized, memoization is disabled if it is not positive
+	TTL time.Duration
+
+	mutex   sync.Mutex
+	entries map[string]*digestEntry
+	now     func() time.Time
+}
+
+type digestEntry struct {
+	done      chan struct{}
+	digest    v1.Hash
+	err       error
+	expiresAt time.Time
+}
+
+func NewDigestCache(ttl time.Duration) *DigestCache {
+	return &DigestCache{
+		TTL:     ttl,
+		entries: map[string]*digestEntry{},
+		now:     time.Now,
+	}
+}
+
+// Get returns the memoized digest of reference, or looks it up with lookup if it is unknown or expired
+func (c *DigestCache) Get(reference string, lookup func() (v1.Hash, error)) (v1.Hash, error) {
+	if c.TTL <= 0 {
+		return lookup()
+	}
+
+	c.mutex.Lock()
+	entry, ok := c.entries[reference]
+	if ok {
+		select {
+		case <-entry.done:
+			if entry.err != nil || c.now().After(entry.expiresAt) {
+				ok = false
+			}
+		default: // lookup in progress
+		}
+	}
+	if ok {
+		c.mutex.Unlock()
+		<-entry.done
+		return entry.digest, entry.err
+	}
+
+	entry = &digestEntry{done: make(chan struct{})}
+	c.entries[reference] = entry
+	c.removeExpired()
+	c.mutex.Unlock()
+
+	entry.digest, entry.err = lookup()
+	entry.expiresAt = c.now().Add(c.TTL)
+	close(entry.done)
+
+	return entry.digest, entry.err
+}
+
+// removeExpired removes expired entries to keep memory usage bounded, c.mutex must be held
+func (c *DigestCache) removeExpired() {
+	now := c.now()
+	for reference, entry := range c.entries {
+		select {
+		case <-entry.done:
+			if entry.err != nil || now.After(entry.expiresAt) {
+				delete(c.entries, reference)
+			}
+		default:
+		}
+	}
+}
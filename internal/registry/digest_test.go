@@ -0,0 +1,78 @@
+package registry
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	. "github.com/onsi/gomega"
+)
+
+func TestDigestCache(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Now()
+	cache := NewDigestCache(time.Minute)
+	cache.now = func() time.Time { return now }
+
+	lookups := 0
+	digest := v1.Hash{Algorithm: "sha256", Hex: "a"}
+	lookup := func() (v1.Hash, error) {
+		lookups++
+		return digest, nil
+	}
+
+	g.Expect(cache.Get("nginx:latest", lookup)).To(Equal(digest))
+	g.Expect(cache.Get("nginx:latest", lookup)).To(Equal(digest))
+	g.Expect(lookups).To(Equal(1))
+
+	g.Expect(cache.Get("alpine:latest", lookup)).To(Equal(digest))
+	g.Expect(lookups).To(Equal(2))
+
+	now = now.Add(2 * time.Minute)
+	g.Expect(cache.Get("nginx:latest", lookup)).To(Equal(digest))
+	g.Expect(lookups).To(Equal(3))
+
+	// Failed lookups are not memoized
+	_, err := cache.Get("failing:latest", func() (v1.Hash, error) { return v1.Hash{}, errors.New("unavailable") })
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(cache.Get("failing:latest", lookup)).To(Equal(digest))
+
+	cache.TTL = 0
+	g.Expect(cache.Get("nginx:latest", lookup)).To(Equal(digest))
+	g.Expect(lookups).To(Equal(5))
+}
+
+func TestDigestCacheConcurrentLookups(t *testing.T) {
+	g := NewWithT(t)
+
+	cache := NewDigestCache(time.Minute)
+	digest := v1.Hash{Algorithm: "sha256", Hex: "a"}
+
+	release := make(chan struct{})
+	lookups := 0
+	lookup := func() (v1.Hash, error) {
+		lookups++
+		<-release
+		return digest, nil
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d, err := cache.Get("nginx:latest", lookup)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(d).To(Equal(digest))
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	g.Expect(lookups).To(Equal(1))
+}
@@ -0,0 +1,137 @@
+package registry
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// ErrImageNotFound is returned when the source image doesn't exist upstream
+var ErrImageNotFound = errors.New("could not find source image")
+
+// FailureClass classifies the errors of pulls from upstream registries and pushes to the cache, so that they can be
+// retried according to their cause
+type FailureClass string
+
+const (
+	// FailureAuth is an authentication or authorization failure, e.g. missing or expired pull secrets
+	FailureAuth FailureClass = "auth"
+	// FailureNotFound is an image that doesn't exist upstream
+	FailureNotFound FailureClass = "not-found"
+	// FailureRateLimit is an upstream registry rejecting pulls because of its rate limit
+	FailureRateLimit FailureClass = "rate-limit"
+	// FailureNetwork is a registry that could not be reached or that failed to answer, which is usually transient
+	FailureNetwork FailureClass = "network"
+	// FailureLimitExceeded is upstream content exceeding UpstreamLimits
+	FailureLimitExceeded FailureClass = "limit-exceeded"
+	// FailurePolicy is an image whose base images are not allowed by BaseImagesPolicy
+	FailurePolicy FailureClass = "policy"
+	// FailureSignature is an image whose signature is not verified by ImageSignaturePolicy
+	FailureSignature FailureClass = "signature"
+	// FailureStorageFull is the storage of the cache running out of space
+	FailureStorageFull FailureClass = "storage-full"
+	// FailureUnknown is any other failure
+	FailureUnknown FailureClass = "unknown"
+)
+
+// failureClassPrecedence orders failure classes from the least to the most relevant, to classify the failures of an
+// image pulled with several keychains: a rate limit or a missing image explains the failure better than the
+// authentication error of an anonymous pull
+var failureClassPrecedence = map[FailureClass]int{
+	FailureUnknown:       0,
+	FailureAuth:          1,
+	FailureNetwork:       2,
+	FailureNotFound:      3,
+	FailureLimitExceeded: 4,
+	FailureRateLimit:     5,
+	FailureStorageFull:   6,
+	FailurePolicy:        7,
+	FailureSignature:     8,
+}
+
+// ClassifyError returns the class of an error returned by CacheImage
+func ClassifyError(err error) FailureClass {
+	if err == nil {
+		return FailureUnknown
+	}
+
+	if aggregate, ok := err.(utilerrors.Aggregate); ok {
+		class := FailureUnknown
+		for _, err := range aggregate.Errors() {
+			if errClass := ClassifyError(err); failureClassPrecedence[errClass] > failureClassPrecedence[class] {
+				class = errClass
+			}
+		}
+		return class
+	}
+
+	if errors.Is(err, syscall.ENOSPC) || strings.Contains(err.Error(), "no space left on device") {
+		return FailureStorageFull
+	}
+	if errors.Is(err, ErrImageNotFound) {
+		return FailureNotFound
+	}
+
+	var policyErr *PolicyViolationError
+	if errors.As(err, &policyErr) {
+		return FailurePolicy
+	}
+
+	var signatureErr *SignatureVerificationError
+	if errors.As(err, &signatureErr) {
+		return FailureSignature
+	}
+
+	var limitErr *LimitExceededError
+	if errors.As(err, &limitErr) {
+		return FailureLimitExceeded
+	}
+
+	var transportErr *transport.Error
+	if errors.As(err, &transportErr) {
+		return classifyTransportError(transportErr)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return FailureNetwork
+	}
+
+	return FailureUnknown
+}
+
+func classifyTransportError(err *transport.Error) FailureClass {
+	switch err.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return FailureAuth
+	case http.StatusNotFound:
+		return FailureNotFound
+	case http.StatusTooManyRequests:
+		return FailureRateLimit
+	case http.StatusInsufficientStorage:
+		return FailureStorageFull
+	}
+
+	for _, diagnostic := range err.Errors {
+		switch diagnostic.Code {
+		case transport.UnauthorizedErrorCode, transport.DeniedErrorCode:
+			return FailureAuth
+		case transport.ManifestUnknownErrorCode, transport.NameUnknownErrorCode:
+			return FailureNotFound
+		case transport.TooManyRequestsErrorCode:
+			return FailureRateLimit
+		}
+	}
+
+	if err.StatusCode >= http.StatusInternalServerError {
+		return FailureNetwork
+	}
+
+	return FailureUnknown
+}
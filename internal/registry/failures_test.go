@@ -0,0 +1,94 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	. "github.com/onsi/gomega"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+func TestClassifyError(t *testing.T) {
+	unauthorized := &transport.Error{StatusCode: http.StatusUnauthorized}
+	tooManyRequests := &transport.Error{StatusCode: http.StatusTooManyRequests}
+
+	tests := []struct {
+		name     string
+		err      error
+		expected FailureClass
+	}{
+		{
+			name:     "Unauthorized",
+			err:      unauthorized,
+			expected: FailureAuth,
+		},
+		{
+			name:     "Denied",
+			err:      &transport.Error{StatusCode: http.StatusBadRequest, Errors: []transport.Diagnostic{{Code: transport.DeniedErrorCode}}},
+			expected: FailureAuth,
+		},
+		{
+			name:     "Image not found",
+			err:      ErrImageNotFound,
+			expected: FailureNotFound,
+		},
+		{
+			name:     "Repository not found",
+			err:      &transport.Error{StatusCode: http.StatusBadRequest, Errors: []transport.Diagnostic{{Code: transport.NameUnknownErrorCode}}},
+			expected: FailureNotFound,
+		},
+		{
+			name:     "Rate limited",
+			err:      tooManyRequests,
+			expected: FailureRateLimit,
+		},
+		{
+			name:     "Upstream unavailable",
+			err:      &transport.Error{StatusCode: http.StatusServiceUnavailable},
+			expected: FailureNetwork,
+		},
+		{
+			name:     "Connection refused",
+			err:      &url.Error{Op: "Get", URL: "https://registry.example.com/v2/", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}},
+			expected: FailureNetwork,
+		},
+		{
+			name:     "Manifest too large",
+			err:      &url.Error{Op: "Get", URL: "https://registry.example.com/v2/alpine/manifests/latest", Err: &LimitExceededError{Limit: "manifest size", Value: 5 << 20, Max: 4 << 20}},
+			expected: FailureLimitExceeded,
+		},
+		{
+			name:     "Storage full",
+			err:      fmt.Errorf("could not write blob: %w", syscall.ENOSPC),
+			expected: FailureStorageFull,
+		},
+		{
+			name:     "Storage full in the cache registry",
+			err:      &transport.Error{StatusCode: http.StatusInternalServerError, Errors: []transport.Diagnostic{{Code: transport.UnknownErrorCode, Message: "write /var/lib/registry/docker: no space left on device"}}},
+			expected: FailureStorageFull,
+		},
+		{
+			name:     "Several keychains",
+			err:      utilerrors.NewAggregate([]error{unauthorized, tooManyRequests}),
+			expected: FailureRateLimit,
+		},
+		{
+			name:     "Unknown",
+			err:      errors.New("unexpected media type"),
+			expected: FailureUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(ClassifyError(tt.err)).To(Equal(tt.expected))
+		})
+	}
+}
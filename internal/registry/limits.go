@@ -0,0 +1,123 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// UpstreamLimits protects the proxy and the controllers from malicious or malformed upstream content, e.g. huge
+// manifests that would be read in memory
+var UpstreamLimits = ContentLimits{
+	MaxManifestSize: 4 << 20,
+	MaxLayers:       256,
+	MaxTagLength:    128,
+}
+
+// ContentLimits are the limits of what is processed from upstream registries, 0 disabling a limit
+type ContentLimits struct {
+	MaxManifestSize int64
+	MaxLayers       int
+	MaxTagLength    int
+}
+
+// ParseSize parses a size in bytes as a quantity, e.g. "4Mi"
+func ParseSize(str string) (int64, error) {
+	quantity, err := resource.ParseQuantity(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %w", str, err)
+	}
+	return quantity.Value(), nil
+}
+
+// LimitExceededError is returned when upstream content exceeds one of the ContentLimits
+type LimitExceededError struct {
+	Limit string
+	Value int64
+	Max   int64
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("%s of %d exceeds the limit of %d", e.Limit, e.Value, e.Max)
+}
+
+// CheckTag returns a LimitExceededError if a tag is longer than MaxTagLength
+func (l ContentLimits) CheckTag(tag string) error {
+	if l.MaxTagLength > 0 && len(tag) > l.MaxTagLength {
+		return &LimitExceededError{Limit: "tag length", Value: int64(len(tag)), Max: int64(l.MaxTagLength)}
+	}
+	return nil
+}
+
+// CheckManifest reads the manifest in the body of a response, without reading more than MaxManifestSize, and returns a
+// LimitExceededError if it is too large or if it has more than MaxLayers layers. The body of the response is replaced
+// by the manifest so that it can be read again.
+func (l ContentLimits) CheckManifest(resp *http.Response) error {
+	if l.MaxManifestSize <= 0 && l.MaxLayers <= 0 {
+		return nil
+	}
+	if l.MaxManifestSize > 0 && resp.ContentLength > l.MaxManifestSize {
+		return &LimitExceededError{Limit: "manifest size", Value: resp.ContentLength, Max: l.MaxManifestSize}
+	}
+
+	body := resp.Body
+	if l.MaxManifestSize > 0 {
+		body = io.NopCloser(io.LimitReader(resp.Body, l.MaxManifestSize+1))
+	}
+	manifest, err := io.ReadAll(body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(manifest))
+
+	if l.MaxManifestSize > 0 && int64(len(manifest)) > l.MaxManifestSize {
+		return &LimitExceededError{Limit: "manifest size", Value: int64(len(manifest)), Max: l.MaxManifestSize}
+	}
+
+	// Malformed manifests are left to the client
+	layers := struct {
+		Layers []json.RawMessage `json:"layers"`
+	}{}
+	if l.MaxLayers > 0 && json.Unmarshal(manifest, &layers) == nil && len(layers.Layers) > l.MaxLayers {
+		return &LimitExceededError{Limit: "layer count", Value: int64(len(layers.Layers)), Max: int64(l.MaxLayers)}
+	}
+
+	return nil
+}
+
+// Transport returns a transport checking the manifests pulled through it against the limits
+func (l ContentLimits) Transport(inner http.RoundTripper) http.RoundTripper {
+	return &limitsTransport{inner: inner, limits: l}
+}
+
+type limitsTransport struct {
+	inner  http.RoundTripper
+	limits ContentLimits
+}
+
+func (t *limitsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.inner.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if IsManifestResponse(req, resp) {
+		if err := t.limits.CheckManifest(resp); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+// IsManifestResponse tells whether a response is a manifest returned by a registry
+func IsManifestResponse(req *http.Request, resp *http.Response) bool {
+	return req.Method == http.MethodGet && resp.StatusCode == http.StatusOK && strings.Contains(req.URL.Path, "/manifests/")
+}
@@ -0,0 +1,92 @@
+package registry
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestContentLimits_CheckManifest(t *testing.T) {
+	limits := ContentLimits{MaxManifestSize: 64, MaxLayers: 2}
+
+	tests := []struct {
+		name          string
+		manifest      string
+		contentLength int64
+		wantErr       string
+	}{
+		{
+			name:     "Within limits",
+			manifest: `{"layers":[{},{}]}`,
+		},
+		{
+			name:          "Announced too large",
+			manifest:      `{}`,
+			contentLength: 65,
+			wantErr:       "manifest size of 65 exceeds the limit of 64",
+		},
+		{
+			name:     "Too large",
+			manifest: `{"annotations":{"padding":"` + strings.Repeat("a", 64) + `"}}`,
+			wantErr:  "manifest size of 65 exceeds the limit of 64",
+		},
+		{
+			name:     "Too many layers",
+			manifest: `{"layers":[{},{},{}]}`,
+			wantErr:  "layer count of 3 exceeds the limit of 2",
+		},
+		{
+			name:     "Malformed",
+			manifest: `{"layers":`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			resp := &http.Response{Body: io.NopCloser(strings.NewReader(tt.manifest)), ContentLength: tt.contentLength}
+
+			err := limits.CheckManifest(resp)
+			if tt.wantErr != "" {
+				g.Expect(err).To(BeAssignableToTypeOf(&LimitExceededError{}))
+				g.Expect(err).To(MatchError(tt.wantErr))
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			body, err := io.ReadAll(resp.Body)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(string(body)).To(Equal(tt.manifest))
+		})
+	}
+}
+
+func TestContentLimits_Transport(t *testing.T) {
+	g := NewWithT(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"layers":[{},{},{}]}`)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: ContentLimits{MaxLayers: 2}.Transport(http.DefaultTransport)}
+
+	_, err := client.Get(server.URL + "/v2/library/alpine/manifests/latest")
+	g.Expect(err).To(MatchError(ContainSubstring("layer count of 3 exceeds the limit of 2")))
+	g.Expect(ClassifyError(err)).To(Equal(FailureLimitExceeded))
+
+	// Only manifests are checked
+	resp, err := client.Get(server.URL + "/v2/library/alpine/blobs/sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+	g.Expect(err).ToNot(HaveOccurred())
+	resp.Body.Close()
+}
+
+func TestContentLimits_CheckTag(t *testing.T) {
+	g := NewWithT(t)
+	g.Expect(ContentLimits{MaxTagLength: 8}.CheckTag("v1.2.3")).To(Succeed())
+	g.Expect(ContentLimits{MaxTagLength: 8}.CheckTag("v1.2.3-alpine")).To(MatchError("tag length of 13 exceeds the limit of 8"))
+	g.Expect(ContentLimits{}.CheckTag("v1.2.3-alpine")).To(Succeed())
+}
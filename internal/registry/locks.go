@@ -0,0 +1,70 @@
+package registry
+
+import (
+	"sync"
+)
+
+// ImageLocks serializes the writes of the controllers to each image in cache, e.g. a refresh and a prefetch of the same
+// image, or its deletion while it is being cached. Writes of the cache registry replicas are thus coordinated by the
+// controllers: only the leader writes, one image at a time.
+var ImageLocks = NewKeyedLocks()
+
+// KeyedLocks is a set of mutexes created on demand, one by key, and removed once they are not held nor waited for
+type KeyedLocks struct {
+	mutex sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	// refs is the number of goroutines holding or waiting for the lock
+	refs int
+}
+
+func NewKeyedLocks() *KeyedLocks {
+	return &KeyedLocks{
+		locks: map[string]*keyedLock{},
+	}
+}
+
+// Lock locks the mutex of key, waiting for it to be unlocked if needed, and returns the function unlocking it
+func (l *KeyedLocks) Lock(key string) func() {
+	l.mutex.Lock()
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &keyedLock{}
+		l.locks[key] = lock
+	}
+	lock.refs++
+	l.mutex.Unlock()
+
+	lock.Lock()
+
+	return func() {
+		lock.Unlock()
+
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, key)
+		}
+	}
+}
+
+// Len returns the number of keys whose mutex is held or waited for
+func (l *KeyedLocks) Len() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return len(l.locks)
+}
+
+// lockImage locks the image in cache an image is written to, images whose name is invalid are not locked since they
+// can't be written anyway
+func lockImage(imageName string) func() {
+	destName, err := getDestinationName(imageName)
+	if err != nil {
+		return func() {}
+	}
+	return ImageLocks.Lock(destName)
+}
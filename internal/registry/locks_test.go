@@ -0,0 +1,37 @@
+package registry
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestKeyedLocks(t *testing.T) {
+	g := NewWithT(t)
+
+	locks := NewKeyedLocks()
+
+	unlockNginx := locks.Lock("nginx:latest")
+	// Other keys are not locked
+	locks.Lock("alpine:latest")()
+	g.Expect(locks.Len()).To(Equal(1))
+
+	var wg sync.WaitGroup
+	locked := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		unlock := locks.Lock("nginx:latest")
+		close(locked)
+		unlock()
+	}()
+
+	g.Consistently(locked, 100*time.Millisecond).ShouldNot(BeClosed())
+	unlockNginx()
+	g.Eventually(locked).Should(BeClosed())
+	wg.Wait()
+
+	g.Expect(locks.Len()).To(Equal(0))
+}
@@ -0,0 +1,82 @@
+package registry
+
+import (
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// ImageConfig is the config of an image stored in cache for a platform
+type ImageConfig struct {
+	// Digest is the digest of the manifest of the image for the platform
+	Digest v1.Hash
+	Config *v1.ConfigFile
+	// Layers are the descriptors of the layers of the image for the platform, from its manifest
+	Layers []v1.Descriptor
+}
+
+// ImageConfigs returns the config of every platform of an image stored in cache, read from the manifests and config
+// blobs of the registry so that images can be inspected without pulling their layers
+func ImageConfigs(imageName string) ([]ImageConfig, error) {
+	ref, err := parseLocalReference(imageName)
+	if err != nil {
+		return nil, err
+	}
+
+	desc, err := remote.Get(ref, cacheOptions()...)
+	if err != nil {
+		return nil, err
+	}
+
+	if !desc.MediaType.IsIndex() {
+		image, err := desc.Image()
+		if err != nil {
+			return nil, err
+		}
+		imageConfig, err := readImageConfig(desc.Digest, image)
+		if err != nil {
+			return nil, err
+		}
+		return []ImageConfig{imageConfig}, nil
+	}
+
+	index, err := desc.ImageIndex()
+	if err != nil {
+		return nil, err
+	}
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	configs := []ImageConfig{}
+	for _, manifest := range indexManifest.Manifests {
+		// Skip attestation manifests, which are images of an unknown platform
+		if !manifest.MediaType.IsImage() || manifest.Annotations[referenceTypeAnnotation] == referenceTypeAttestation {
+			continue
+		}
+		image, err := remote.Image(ref.Context().Digest(manifest.Digest.String()), cacheOptions()...)
+		if err != nil {
+			return nil, err
+		}
+		imageConfig, err := readImageConfig(manifest.Digest, image)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, imageConfig)
+	}
+
+	return configs, nil
+}
+
+func readImageConfig(digest v1.Hash, image v1.Image) (ImageConfig, error) {
+	config, err := image.ConfigFile()
+	if err != nil {
+		return ImageConfig{}, err
+	}
+	manifest, err := image.Manifest()
+	if err != nil {
+		return ImageConfig{}, err
+	}
+
+	return ImageConfig{Digest: digest, Config: config, Layers: manifest.Layers}, nil
+}
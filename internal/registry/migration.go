@@ -0,0 +1,93 @@
+package registry
+
+import (
+	"sync/atomic"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PreviousEndpoint is the address of the registry cached images are migrated from, reached with the same protocol and
+// credentials as Endpoint. While the migration is in progress, images missing from Endpoint are read from it and
+// images put in cache are written to both registries, so that going back to the previous registry is still possible.
+var PreviousEndpoint = ""
+
+var migrationCompleted atomic.Bool
+
+// MigrationPhaseKey is the key of the phase of the migration in the ConfigMap the controllers report it in, which is
+// either MigrationInProgressPhase or MigrationCompletedPhase
+const MigrationPhaseKey = "phase"
+
+// Keys of the registries the migration reported in the ConfigMap is from and to
+const (
+	MigrationPreviousEndpointKey = "previousEndpoint"
+	MigrationEndpointKey         = "endpoint"
+)
+
+// Phases of the migration
+const (
+	MigrationInProgressPhase = "InProgress"
+	MigrationCompletedPhase  = "Completed"
+)
+
+// MigrationReportCompleted tells whether the migration from PreviousEndpoint to Endpoint is reported as completed in a
+// ConfigMap. A report of a migration between other registries, e.g. left by a previous migration, is not.
+func MigrationReportCompleted(configMap *corev1.ConfigMap) bool {
+	return configMap.Data[MigrationPhaseKey] == MigrationCompletedPhase &&
+		configMap.Data[MigrationPreviousEndpointKey] == PreviousEndpoint &&
+		configMap.Data[MigrationEndpointKey] == Endpoint
+}
+
+// MigrationInProgress tells whether cached images are being migrated from PreviousEndpoint
+func MigrationInProgress() bool {
+	return PreviousEndpoint != "" && !migrationCompleted.Load()
+}
+
+// CompleteMigration stops reading images from and writing them to PreviousEndpoint, once every cached image is present
+// in Endpoint
+func CompleteMigration() {
+	migrationCompleted.Store(true)
+}
+
+// MigrateImage copies an image from the previous registry to the cache registry while the migration is in progress.
+// It returns false if the image is not in the previous registry either.
+func MigrateImage(imageName string) (bool, error) {
+	if !MigrationInProgress() {
+		return false, nil
+	}
+
+	return copyCachedImage(imageName, PreviousEndpoint, Endpoint)
+}
+
+// CopyToPreviousRegistry copies an image put in cache to the previous registry while the migration is in progress
+func CopyToPreviousRegistry(imageName string) error {
+	if !MigrationInProgress() {
+		return nil
+	}
+
+	_, err := copyCachedImage(imageName, Endpoint, PreviousEndpoint)
+	return err
+}
+
+// copyCachedImage copies an image, with every platform cached, between cache registries. It returns false if the image
+// is not in the source registry.
+func copyCachedImage(imageName string, from string, to string) (bool, error) {
+	sourceRef, err := parseLocalReferenceIn(from, imageName)
+	if err != nil {
+		return false, err
+	}
+	destRef, err := parseLocalReferenceIn(to, imageName)
+	if err != nil {
+		return false, err
+	}
+
+	desc, err := remote.Get(sourceRef, cacheOptions()...)
+	if err != nil {
+		if errIsImageNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, writeDescriptor(destRef, desc, cacheOptions()...)
+}
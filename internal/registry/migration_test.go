@@ -0,0 +1,45 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/enix/kube-image-keeper/pkg/registrytest"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/onsi/gomega"
+)
+
+func TestMigration(t *testing.T) {
+	g := NewWithT(t)
+
+	previous := registrytest.New(t)
+	cache := registrytest.New(t)
+	Endpoint, PreviousEndpoint = cache.Addr(), previous.Addr()
+	t.Cleanup(func() {
+		PreviousEndpoint = ""
+		migrationCompleted.Store(false)
+	})
+
+	ref, err := parseLocalReferenceIn(PreviousEndpoint, "alpine:3.19")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(remote.Write(ref, registrytest.RandomImage(t, 2))).To(Succeed())
+
+	// Images of the previous registry are copied to the cache registry
+	g.Expect(MigrationInProgress()).To(BeTrue())
+	g.Expect(MigrateImage("alpine:3.19")).To(BeTrue())
+	g.Expect(ImageIsCached("alpine:3.19")).To(BeTrue())
+	g.Expect(MigrateImage("redis:7")).To(BeFalse())
+
+	// Images put in cache are copied to the previous registry
+	ref, err = parseLocalReference("nginx:1.25")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(remote.WriteIndex(ref, registrytest.RandomIndex(t, 2, 1))).To(Succeed())
+	g.Expect(CopyToPreviousRegistry("nginx:1.25")).To(Succeed())
+	ref, err = parseLocalReferenceIn(PreviousEndpoint, "nginx:1.25")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(imageExists(ref)).To(BeTrue())
+
+	// The previous registry is left alone once the migration is completed
+	CompleteMigration()
+	g.Expect(MigrationInProgress()).To(BeFalse())
+	g.Expect(MigrateImage("redis:7")).To(BeFalse())
+}
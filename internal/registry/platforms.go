@@ -0,0 +1,217 @@
+package registry
+
+import (
+	"context"
+	"crypto/x509"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	corev1 "k8s.io/api/core/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/utils/strings/slices"
+)
+
+// UpstreamPlatforms memoizes the platforms provided by upstream images, which rarely change, so that their index is
+// not pulled again for every pod using them
+var UpstreamPlatforms = NewArchitectureCache(10 * time.Minute)
+
+// platformsLookupTimeout bounds the lookup of the platforms of an image, which is done while admitting pods
+const platformsLookupTimeout = 3 * time.Second
+
+// ArchitectureCache memoizes the architectures of images by reference. Concurrent lookups of the same reference, e.g.
+// while many pods using the same image are admitted at once, are merged into a single request so that every pod gets
+// the same rewrite decision. Failed lookups are not memoized.
+type ArchitectureCache struct {
+	// TTL is how long architectures are memoized, memoization is disabled if it is not positive
+	TTL time.Duration
+
+	mutex   sync.Mutex
+	entries map[string]*architectureEntry
+	now     func() time.Time
+}
+
+type architectureEntry struct {
+	done          chan struct{}
+	architectures []string
+	err           error
+	expiresAt     time.Time
+}
+
+func NewArchitectureCache(ttl time.Duration) *ArchitectureCache {
+	return &ArchitectureCache{
+		TTL:     ttl,
+		entries: map[string]*architectureEntry{},
+		now:     time.Now,
+	}
+}
+
+// Get returns the memoized architectures of reference, or looks them up with lookup if they are unknown or expired
+func (c *ArchitectureCache) Get(reference string, lookup func() ([]string, error)) ([]string, error) {
+	if c.TTL <= 0 {
+		return lookup()
+	}
+
+	c.mutex.Lock()
+	entry, ok := c.entries[reference]
+	if ok {
+		select {
+		case <-entry.done:
+			if entry.err != nil || c.now().After(entry.expiresAt) {
+				ok = false
+			}
+		default: // lookup in progress
+		}
+	}
+	if ok {
+		c.mutex.Unlock()
+		<-entry.done
+		return entry.architectures, entry.err
+	}
+
+	entry = &architectureEntry{done: make(chan struct{})}
+	c.entries[reference] = entry
+	c.removeExpired()
+	c.mutex.Unlock()
+
+	entry.architectures, entry.err = lookup()
+	entry.expiresAt = c.now().Add(c.TTL)
+	close(entry.done)
+
+	return entry.architectures, entry.err
+}
+
+// removeExpired removes expired entries to keep memory usage bounded, c.mutex must be held
+func (c *ArchitectureCache) removeExpired() {
+	now := c.now()
+	for reference, entry := range c.entries {
+		select {
+		case <-entry.done:
+			if entry.err != nil || now.After(entry.expiresAt) {
+				delete(c.entries, reference)
+			}
+		default:
+		}
+	}
+}
+
+// ImagePlatforms returns the platforms provided by a multi-arch image, as os/architecture, or nil if it is not a
+// multi-arch image, in which case it is cached whatever its platform. Platforms are read from the cache registry when
+// the image is cached, and looked up in its upstream registry otherwise.
+func ImagePlatforms(ctx context.Context, imageName string, pullSecrets []corev1.Secret, insecureRegistries []string, rootCAs *x509.CertPool) ([]string, error) {
+	return UpstreamPlatforms.Get(imageName, func() ([]string, error) {
+		ctx, cancel := context.WithTimeout(ctx, platformsLookupTimeout)
+		defer cancel()
+
+		if platforms, ok := cachedPlatforms(ctx, imageName); ok {
+			return platforms, nil
+		}
+
+		keychains, err := GetKeychains(imageName, pullSecrets)
+		if err != nil {
+			return nil, err
+		}
+		ref, err := name.ParseReference(imageName)
+		if err != nil {
+			return nil, err
+		}
+
+		var lookupErrors []error
+		for _, keychain := range keychains {
+			opts := append(upstreamOptions(ref, keychain, insecureRegistries, rootCAs), remote.WithContext(ctx))
+			desc, err := getUpstreamManifest(ref, opts...)
+			if err != nil {
+				lookupErrors = append(lookupErrors, err)
+				continue
+			}
+			if !desc.MediaType.IsIndex() {
+				return nil, nil
+			}
+			index, err := desc.ImageIndex()
+			if err != nil {
+				return nil, err
+			}
+			return indexPlatforms(index)
+		}
+
+		return nil, utilerrors.NewAggregate(lookupErrors)
+	})
+}
+
+// cachedPlatforms returns the platforms of an image in the cache registry, and whether it is cached
+func cachedPlatforms(ctx context.Context, imageName string) ([]string, bool) {
+	ref, err := parseLocalReference(imageName)
+	if err != nil {
+		return nil, false
+	}
+	desc, err := remote.Get(ref, append(cacheOptions(), remote.WithContext(ctx))...)
+	if err != nil {
+		return nil, false
+	}
+	if !desc.MediaType.IsIndex() {
+		return nil, true
+	}
+	index, err := desc.ImageIndex()
+	if err != nil {
+		return nil, false
+	}
+	platforms, err := indexPlatforms(index)
+	if err != nil {
+		return nil, false
+	}
+	return platforms, true
+}
+
+// indexPlatforms returns the platforms of the manifests of an index as os/architecture, without attestation manifests
+func indexPlatforms(index v1.ImageIndex) ([]string, error) {
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	platforms := []string{}
+	for _, desc := range indexManifest.Manifests {
+		if desc.Platform == nil || desc.Annotations[referenceTypeAnnotation] == referenceTypeAttestation {
+			continue
+		}
+		platform := desc.Platform.OS + "/" + desc.Platform.Architecture
+		if !slices.Contains(platforms, platform) {
+			platforms = append(platforms, platform)
+		}
+	}
+	return platforms, nil
+}
+
+// PlatformArchitectures returns the architectures of platforms given as os/architecture
+func PlatformArchitectures(platforms []string) []string {
+	architectures := []string{}
+	for _, platform := range platforms {
+		_, architecture, _ := strings.Cut(platform, "/")
+		if !slices.Contains(architectures, architecture) {
+			architectures = append(architectures, architecture)
+		}
+	}
+	return architectures
+}
+
+// ProvidesPlatform tells whether an image providing the available platforms provides a variant for the operating
+// system os and one of the wanted architectures, any operating system or architecture matching when they are empty.
+// Images that are not multi-arch, with nil available platforms, provide every platform.
+func ProvidesPlatform(available []string, os string, architectures []string) bool {
+	if available == nil {
+		return true
+	}
+	for _, platform := range available {
+		platformOS, architecture, _ := strings.Cut(platform, "/")
+		if os != "" && platformOS != os {
+			continue
+		}
+		if len(architectures) == 0 || slices.Contains(architectures, architecture) {
+			return true
+		}
+	}
+	return false
+}
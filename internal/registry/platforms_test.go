@@ -0,0 +1,113 @@
+package registry
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/enix/kube-image-keeper/pkg/registrytest"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	. "github.com/onsi/gomega"
+)
+
+func TestIndexPlatforms(t *testing.T) {
+	g := NewWithT(t)
+
+	index := provenanceIndex(g, provenanceMaterial{URI: "pkg:docker/alpine@3.18"})
+	for _, architecture := range []string{"amd64", "arm64", "amd64"} {
+		index = mutate.AppendManifests(index, mutate.IndexAddendum{
+			Add:        registrytest.RandomImage(t, 1),
+			Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: architecture}},
+		})
+	}
+
+	index = mutate.AppendManifests(index, mutate.IndexAddendum{
+		Add:        registrytest.RandomImage(t, 1),
+		Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348.2113"}},
+	})
+
+	// Attestations are not a platform
+	g.Expect(indexPlatforms(index)).To(Equal([]string{"linux/amd64", "linux/arm64", "windows/amd64"}))
+	g.Expect(indexPlatforms(empty.Index)).To(BeEmpty())
+}
+
+func TestProvidesPlatform(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(ProvidesPlatform([]string{"linux/amd64", "linux/arm64"}, "", []string{"arm64"})).To(BeTrue())
+	g.Expect(ProvidesPlatform([]string{"linux/arm64"}, "", []string{"amd64", "arm"})).To(BeFalse())
+	g.Expect(ProvidesPlatform([]string{}, "", []string{"amd64"})).To(BeFalse())
+	g.Expect(ProvidesPlatform([]string{"linux/amd64", "windows/amd64"}, "windows", []string{"amd64"})).To(BeTrue())
+	g.Expect(ProvidesPlatform([]string{"linux/amd64", "windows/arm64"}, "windows", []string{"amd64"})).To(BeFalse())
+	g.Expect(ProvidesPlatform([]string{"linux/amd64", "linux/arm64"}, "windows", nil)).To(BeFalse())
+	g.Expect(ProvidesPlatform([]string{"linux/amd64", "windows/arm64"}, "windows", nil)).To(BeTrue())
+	// Images that are not multi-arch are cached whatever their platform
+	g.Expect(ProvidesPlatform(nil, "windows", []string{"amd64"})).To(BeTrue())
+}
+
+func TestPlatformArchitectures(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(PlatformArchitectures([]string{"linux/amd64", "linux/arm64", "windows/amd64"})).To(Equal([]string{"amd64", "arm64"}))
+}
+
+func TestArchitectureCache(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Now()
+	cache := NewArchitectureCache(time.Minute)
+	cache.now = func() time.Time { return now }
+
+	calls := 0
+	lookup := func() ([]string, error) {
+		calls++
+		return []string{"amd64"}, nil
+	}
+
+	g.Expect(cache.Get("alpine", lookup)).To(Equal([]string{"amd64"}))
+	g.Expect(cache.Get("alpine", lookup)).To(Equal([]string{"amd64"}))
+	g.Expect(calls).To(Equal(1))
+
+	now = now.Add(2 * time.Minute)
+	g.Expect(cache.Get("alpine", lookup)).To(Equal([]string{"amd64"}))
+	g.Expect(calls).To(Equal(2))
+
+	// Failed lookups are not memoized
+	_, err := cache.Get("nginx", func() ([]string, error) { return nil, errors.New("unreachable") })
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(cache.Get("nginx", lookup)).To(Equal([]string{"amd64"}))
+}
+
+func TestArchitectureCacheConcurrentLookups(t *testing.T) {
+	g := NewWithT(t)
+
+	cache := NewArchitectureCache(time.Minute)
+
+	release := make(chan struct{})
+	lookups := 0
+	lookup := func() ([]string, error) {
+		lookups++
+		<-release
+		return []string{"amd64", "arm64"}, nil
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			architectures, err := cache.Get("alpine", lookup)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(architectures).To(Equal([]string{"amd64", "arm64"}))
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	g.Expect(lookups).To(Equal(1))
+}
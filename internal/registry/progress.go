@@ -0,0 +1,123 @@
+package registry
+
+import (
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// maxParallelLayers is the number of layers pushed in cache at the same time
+const maxParallelLayers = 4
+
+// CacheProgress is the progress of an image being put in cache, so that a caching interrupted by a restart resumes from
+// the layers already pushed in cache instead of starting over
+type CacheProgress struct {
+	// CompletedLayers are the digests of the layers already pushed in cache, which are not pulled again
+	CompletedLayers []string
+	// OnLayerCompleted is called each time a layer has been pushed in cache, one call at a time
+	OnLayerCompleted func(digest string)
+
+	mutex sync.Mutex
+}
+
+func (p *CacheProgress) completed(digest string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.CompletedLayers = append(p.CompletedLayers, digest)
+	if p.OnLayerCompleted != nil {
+		p.OnLayerCompleted(digest)
+	}
+}
+
+// pushLayers pushes the layers of images to repository one by one, skipping the completed ones, and reports each pushed
+// layer to progress. Layers completed by a previous attempt but deleted since, e.g. by the garbage collection of the
+// registry, are pushed again when writing the manifests of the images since every blob is checked at this time.
+func pushLayers(repository name.Repository, images []v1.Image, progress *CacheProgress) error {
+	completed := map[string]bool{}
+	for _, digest := range progress.CompletedLayers {
+		completed[digest] = true
+	}
+
+	layers := []v1.Layer{}
+	for _, image := range images {
+		imageLayers, err := image.Layers()
+		if err != nil {
+			return err
+		}
+		for _, layer := range imageLayers {
+			digest, err := layer.Digest()
+			if err != nil {
+				return err
+			}
+			if completed[digest.String()] {
+				continue
+			}
+			completed[digest.String()] = true
+			layers = append(layers, layer)
+		}
+	}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var pushErr error
+	semaphore := make(chan struct{}, maxParallelLayers)
+
+	for _, layer := range layers {
+		semaphore <- struct{}{}
+		mutex.Lock()
+		failed := pushErr != nil
+		mutex.Unlock()
+		if failed {
+			<-semaphore
+			break
+		}
+
+		wg.Add(1)
+		go func(layer v1.Layer) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			digest, err := layer.Digest()
+			if err == nil {
+				err = remote.WriteLayer(repository, layer, cacheOptions()...)
+			}
+			if err != nil {
+				mutex.Lock()
+				if pushErr == nil {
+					pushErr = err
+				}
+				mutex.Unlock()
+				return
+			}
+			progress.completed(digest.String())
+		}(layer)
+	}
+
+	wg.Wait()
+	return pushErr
+}
+
+// indexImages returns the images of an index, skipping nested indexes
+func indexImages(index v1.ImageIndex) ([]v1.Image, error) {
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	images := []v1.Image{}
+	for _, desc := range indexManifest.Manifests {
+		if !desc.MediaType.IsImage() {
+			continue
+		}
+		image, err := index.Image(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+
+	return images, nil
+}
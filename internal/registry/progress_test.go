@@ -0,0 +1,65 @@
+package registry
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/enix/kube-image-keeper/pkg/registrytest"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/onsi/gomega"
+)
+
+func Test_pushLayers(t *testing.T) {
+	g := NewWithT(t)
+
+	cache := registrytest.New(t)
+	Endpoint = cache.Addr()
+
+	index := registrytest.RandomIndex(t, 2, 3)
+	images, err := indexImages(index)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(images).To(HaveLen(2))
+
+	layers := []string{}
+	for _, image := range images {
+		imageLayers, err := image.Layers()
+		g.Expect(err).ToNot(HaveOccurred())
+		for _, layer := range imageLayers {
+			digest, err := layer.Digest()
+			g.Expect(err).ToNot(HaveOccurred())
+			layers = append(layers, digest.String())
+		}
+	}
+	g.Expect(layers).To(HaveLen(6))
+
+	ref, err := parseLocalReference("alpine:3.19")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Resume after the first layer, as if the caching had been interrupted
+	reported := []string{}
+	progress := &CacheProgress{
+		CompletedLayers:  []string{layers[0]},
+		OnLayerCompleted: func(digest string) { reported = append(reported, digest) },
+	}
+	g.Expect(pushLayers(ref.Context(), images, progress)).To(Succeed())
+	g.Expect(reported).To(ConsistOf(layers[1:]))
+	g.Expect(progress.CompletedLayers).To(ConsistOf(layers))
+
+	for i, digest := range layers {
+		resp, err := http.Head(cache.URL() + "/v2/" + ref.Context().RepositoryStr() + "/blobs/" + digest)
+		g.Expect(err).ToNot(HaveOccurred())
+		resp.Body.Close()
+		if i == 0 {
+			// The completed layer has been skipped
+			g.Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+		} else {
+			g.Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		}
+	}
+
+	// Writing the index pushes skipped layers that are missing from the cache
+	g.Expect(remote.WriteIndex(ref, index)).To(Succeed())
+	blobs, err := ImageBlobs("alpine:3.19")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(blobs).To(HaveLen(11)) // index, 2 manifests, 2 configs and 6 layers
+}
@@ -0,0 +1,245 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"k8s.io/utils/strings/slices"
+)
+
+const (
+	// referenceTypeAnnotation and referenceTypeAttestation identify attestation manifests in image indexes built by
+	// BuildKit, see https://docs.docker.com/build/attestations/attestation-storage/
+	referenceTypeAnnotation  = "vnd.docker.reference.type"
+	referenceTypeAttestation = "attestation-manifest"
+	predicateTypeAnnotation  = "in-toto.io/predicate-type"
+	slsaProvenancePrefix     = "https://slsa.dev/provenance/"
+
+	// maxProvenanceSize is the maximum size of a provenance attestation read in memory
+	maxProvenanceSize = 4 << 20
+)
+
+// BaseImagesPolicy restricts the registries the base images of images with provenance attestations come from, it
+// allows every registry by default
+var BaseImagesPolicy = ProvenancePolicy{MaxDepth: 3}
+
+// ProvenancePolicy checks the base images declared in the provenance attestations of images, which includes every stage
+// of multi-stage builds. Images without provenance attestations are allowed.
+type ProvenancePolicy struct {
+	// AllowedRegistries are the registries base images may come from, every registry if empty
+	AllowedRegistries []string
+	// MaxDepth is how many levels of base images are checked, base images with provenance attestations being checked
+	// the same way as the image built from them
+	MaxDepth int
+}
+
+// PolicyViolationError is returned when a base image of an image comes from a registry that is not allowed
+type PolicyViolationError struct {
+	BaseImage string
+	Registry  string
+	// Via are the base images through which the base image is used, from the checked image
+	Via []string
+}
+
+func (e *PolicyViolationError) Error() string {
+	via := ""
+	if len(e.Via) > 0 {
+		via = " via " + strings.Join(e.Via, ", ")
+	}
+	return fmt.Sprintf("base image %s%s comes from registry %s, which is not allowed", e.BaseImage, via, e.Registry)
+}
+
+func (p ProvenancePolicy) Enabled() bool {
+	return len(p.AllowedRegistries) > 0
+}
+
+// provenanceStatement is an in-toto statement with a SLSA provenance predicate, either v0.2 listing base images in
+// materials or v1 listing them in resolved dependencies
+type provenanceStatement struct {
+	Predicate struct {
+		Materials       []provenanceMaterial `json:"materials"`
+		BuildDefinition struct {
+			ResolvedDependencies []provenanceMaterial `json:"resolvedDependencies"`
+		} `json:"buildDefinition"`
+	} `json:"predicate"`
+}
+
+type provenanceMaterial struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest"`
+}
+
+// baseImage is a base image declared in a provenance attestation, with its digest when known
+type baseImage struct {
+	Repository name.Repository
+	Digest     string
+}
+
+func (b baseImage) String() string {
+	if b.Digest != "" {
+		return b.Repository.String() + "@" + b.Digest
+	}
+	return b.Repository.String()
+}
+
+// Check returns a PolicyViolationError if a base image of index comes from a registry that is not allowed, fetching
+// base images with options to check their own base images
+func (p ProvenancePolicy) Check(index v1.ImageIndex, options ...remote.Option) error {
+	if !p.Enabled() {
+		return nil
+	}
+
+	return p.check(index, func(digest name.Digest) (v1.ImageIndex, error) {
+		desc, err := remote.Get(digest, options...)
+		if err != nil {
+			return nil, err
+		}
+		if desc.MediaType != types.OCIImageIndex && desc.MediaType != types.DockerManifestList {
+			return nil, nil
+		}
+		return desc.ImageIndex()
+	}, 1, nil, map[string]bool{})
+}
+
+func (p ProvenancePolicy) check(index v1.ImageIndex, fetch func(name.Digest) (v1.ImageIndex, error), depth int, via []string, visited map[string]bool) error {
+	allowed := make([]string, 0, len(p.AllowedRegistries))
+	for _, registry := range p.AllowedRegistries {
+		if reg, err := name.NewRegistry(registry); err == nil {
+			allowed = append(allowed, reg.RegistryStr())
+		}
+	}
+
+	baseImages, err := provenanceBaseImages(index)
+	if err != nil {
+		return err
+	}
+
+	for _, base := range baseImages {
+		if visited[base.String()] {
+			continue
+		}
+		visited[base.String()] = true
+
+		if registry := base.Repository.RegistryStr(); !slices.Contains(allowed, registry) {
+			return &PolicyViolationError{BaseImage: base.String(), Registry: registry, Via: via}
+		}
+
+		if depth >= p.MaxDepth || base.Digest == "" {
+			continue
+		}
+		baseIndex, err := fetch(base.Repository.Digest(base.Digest))
+		if err != nil {
+			return fmt.Errorf("could not fetch base image %s: %w", base, err)
+		}
+		if baseIndex == nil {
+			continue
+		}
+		if err := p.check(baseIndex, fetch, depth+1, append(via, base.String()), visited); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// provenanceBaseImages returns the base images declared in the provenance attestations of an index
+func provenanceBaseImages(index v1.ImageIndex) ([]baseImage, error) {
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	baseImages := []baseImage{}
+	for _, desc := range indexManifest.Manifests {
+		if desc.Annotations[referenceTypeAnnotation] != referenceTypeAttestation {
+			continue
+		}
+
+		attestation, err := index.Image(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		manifest, err := attestation.Manifest()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, layerDesc := range manifest.Layers {
+			if !strings.HasPrefix(layerDesc.Annotations[predicateTypeAnnotation], slsaProvenancePrefix) {
+				continue
+			}
+			statement, err := readProvenance(attestation, layerDesc.Digest)
+			if err != nil {
+				return nil, fmt.Errorf("could not read provenance attestation %s: %w", layerDesc.Digest, err)
+			}
+			baseImages = append(baseImages, statement.baseImages()...)
+		}
+	}
+
+	return baseImages, nil
+}
+
+func readProvenance(attestation v1.Image, digest v1.Hash) (*provenanceStatement, error) {
+	layer, err := attestation.LayerByDigest(digest)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxProvenanceSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxProvenanceSize {
+		return nil, &LimitExceededError{Limit: "provenance size", Value: int64(len(data)), Max: maxProvenanceSize}
+	}
+
+	var statement provenanceStatement
+	if err := json.Unmarshal(data, &statement); err != nil {
+		return nil, err
+	}
+	return &statement, nil
+}
+
+// baseImages returns the container images among the materials of a provenance, identified by package URLs like
+// pkg:docker/alpine@3.18?platform=linux%2Famd64
+func (s *provenanceStatement) baseImages() []baseImage {
+	baseImages := []baseImage{}
+	for _, material := range append(s.Predicate.Materials, s.Predicate.BuildDefinition.ResolvedDependencies...) {
+		path, found := strings.CutPrefix(material.URI, "pkg:docker/")
+		if !found {
+			continue
+		}
+		path, _, _ = strings.Cut(path, "#")
+		path, _, _ = strings.Cut(path, "?")
+		if i := strings.LastIndex(path, "@"); i >= 0 {
+			path = path[:i]
+		}
+		path, err := url.PathUnescape(path)
+		if err != nil {
+			continue
+		}
+
+		repository, err := name.NewRepository(path)
+		if err != nil {
+			continue
+		}
+		base := baseImage{Repository: repository}
+		if digest, ok := material.Digest["sha256"]; ok {
+			base.Digest = "sha256:" + digest
+		}
+		baseImages = append(baseImages, base)
+	}
+	return baseImages
+}
@@ -0,0 +1,114 @@
+package registry
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	alpineDigest = "c5b1261d6d3e43071626931fc004f70149baeba2c8ec672bd4f27761f8e1ad6b"
+	golangDigest = "5c7c2c9f1a930f937a539ff66587b6947890079470921d62ef1a6ed24395b4b3"
+)
+
+// provenanceIndex returns an index with an attestation manifest holding a SLSA v0.2 provenance with the given materials
+func provenanceIndex(g *WithT, materials ...provenanceMaterial) v1.ImageIndex {
+	statement := map[string]interface{}{
+		"_type":         "https://in-toto.io/Statement/v0.1",
+		"predicateType": "https://slsa.dev/provenance/v0.2",
+		"predicate":     map[string]interface{}{"materials": materials},
+	}
+	data, err := json.Marshal(statement)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	attestation, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer:       static.NewLayer(data, "application/vnd.in-toto+json"),
+		Annotations: map[string]string{predicateTypeAnnotation: "https://slsa.dev/provenance/v0.2"},
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	var index v1.ImageIndex = empty.Index
+	return mutate.AppendManifests(mutate.IndexMediaType(index, types.OCIImageIndex), mutate.IndexAddendum{
+		Add: attestation,
+		Descriptor: v1.Descriptor{
+			Platform:    &v1.Platform{OS: "unknown", Architecture: "unknown"},
+			Annotations: map[string]string{referenceTypeAnnotation: referenceTypeAttestation},
+		},
+	})
+}
+
+func TestProvenancePolicy_check(t *testing.T) {
+	g := NewWithT(t)
+
+	// A multi-stage build with a golang builder image and an alpine runtime image
+	index := provenanceIndex(g,
+		provenanceMaterial{URI: "pkg:docker/golang@1.21?platform=linux%2Famd64", Digest: map[string]string{"sha256": golangDigest}},
+		provenanceMaterial{URI: "pkg:docker/ghcr.io/enix/alpine@3.18?platform=linux%2Famd64", Digest: map[string]string{"sha256": alpineDigest}},
+		provenanceMaterial{URI: "https://github.com/enix/kube-image-keeper.git#main"},
+	)
+	// The alpine image of ghcr.io is itself built from an image of quay.io
+	alpineIndex := provenanceIndex(g, provenanceMaterial{URI: "pkg:docker/quay.io/enix/base@1?platform=linux%2Famd64"})
+	fetch := func(digest name.Digest) (v1.ImageIndex, error) {
+		if digest.String() == "ghcr.io/enix/alpine@sha256:"+alpineDigest {
+			return alpineIndex, nil
+		}
+		return nil, nil
+	}
+
+	tests := []struct {
+		name    string
+		policy  ProvenancePolicy
+		wantErr string
+	}{
+		{
+			name:    "Builder stage not allowed",
+			policy:  ProvenancePolicy{AllowedRegistries: []string{"ghcr.io"}, MaxDepth: 1},
+			wantErr: "base image index.docker.io/library/golang@sha256:" + golangDigest + " comes from registry index.docker.io, which is not allowed",
+		},
+		{
+			name:   "Every stage allowed",
+			policy: ProvenancePolicy{AllowedRegistries: []string{"docker.io", "ghcr.io"}, MaxDepth: 1},
+		},
+		{
+			name:    "Base image of a base image not allowed",
+			policy:  ProvenancePolicy{AllowedRegistries: []string{"docker.io", "ghcr.io"}, MaxDepth: 2},
+			wantErr: "base image quay.io/enix/base via ghcr.io/enix/alpine@sha256:" + alpineDigest + " comes from registry quay.io, which is not allowed",
+		},
+		{
+			name:   "Every level allowed",
+			policy: ProvenancePolicy{AllowedRegistries: []string{"docker.io", "ghcr.io", "quay.io"}, MaxDepth: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			err := tt.policy.check(index, fetch, 1, nil, map[string]bool{})
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(tt.wantErr))
+				g.Expect(ClassifyError(err)).To(Equal(FailurePolicy))
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+		})
+	}
+}
+
+func TestProvenancePolicy_Check(t *testing.T) {
+	g := NewWithT(t)
+
+	// Images without provenance are allowed
+	var index v1.ImageIndex = empty.Index
+	g.Expect(ProvenancePolicy{AllowedRegistries: []string{"ghcr.io"}, MaxDepth: 1}.Check(index)).To(Succeed())
+
+	// Every registry is allowed by default
+	index = provenanceIndex(g, provenanceMaterial{URI: "pkg:docker/alpine@3.18"})
+	g.Expect(ProvenancePolicy{MaxDepth: 1}.Check(index)).To(Succeed())
+}
@@ -0,0 +1,48 @@
+package registry
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// DefaultProxyHost is the host used in images rewritten to be pulled through the proxy
+const DefaultProxyHost = "localhost"
+
+// ProxyHostRegexp matches the host and port of the proxy in rewritten images, whatever the loopback literal in use
+var ProxyHostRegexp = regexp.MustCompile(`^(localhost|127(\.[0-9]+){3}|\[::1\]):[0-9]+/`)
+
+// sanitizedPortRegexp matches the origin registry of rewritten images whose port is sanitized, e.g. host-5000/. Docker
+// Hub namespaces can't hold dashes, so that such a first component is always a registry.
+var sanitizedPortRegexp = regexp.MustCompile(`^([^/]+)-([0-9]+)/`)
+
+// ProxiedImage returns the image pulled through the proxy by an image rewritten by the webhook, i.e. without the host
+// of the proxy and with the port of its origin registry restored, e.g. host:5000/app:v1 for
+// localhost:7439/host-5000/app:v1. Images that are not rewritten are returned as is.
+func ProxiedImage(image string) string {
+	if !ProxyHostRegexp.MatchString(image) {
+		return image
+	}
+	image = ProxyHostRegexp.ReplaceAllString(image, "")
+	return sanitizedPortRegexp.ReplaceAllString(image, "$1:$2/")
+}
+
+// ParseProxyHost validates the host to use in rewritten images, which must be "localhost" or a loopback IP address,
+// and returns it in a form suitable for an image reference, i.e. with IPv6 addresses between brackets.
+func ParseProxyHost(host string) (string, error) {
+	if host == DefaultProxyHost {
+		return host, nil
+	}
+
+	ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"))
+	if ip == nil || !ip.IsLoopback() {
+		return "", fmt.Errorf("invalid proxy host %q: must be %q or a loopback IP address", host, DefaultProxyHost)
+	}
+
+	if ip.To4() != nil {
+		return ip.String(), nil
+	}
+
+	return "[" + ip.String() + "]", nil
+}
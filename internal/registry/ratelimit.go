@@ -0,0 +1,103 @@
+package registry
+
+import (
+	"crypto/x509"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+const (
+	// Headers advertising the pull rate limit of Docker Hub, e.g. "100;w=21600", see
+	// https://docs.docker.com/docker-hub/download-rate-limit/
+	rateLimitLimitHeader     = "RateLimit-Limit"
+	rateLimitRemainingHeader = "RateLimit-Remaining"
+)
+
+// RateLimit is the pull rate limit of an upstream registry: Remaining manifests can still be pulled out of Limit per
+// Window
+type RateLimit struct {
+	Limit     int64
+	Remaining int64
+	Window    time.Duration
+}
+
+// ParseRateLimit parses the rate limit advertised by the headers of a response, if any
+func ParseRateLimit(header http.Header) (RateLimit, bool) {
+	limit, window, ok := parseRateLimitHeader(header.Get(rateLimitLimitHeader))
+	if !ok {
+		return RateLimit{}, false
+	}
+	remaining, _, ok := parseRateLimitHeader(header.Get(rateLimitRemainingHeader))
+	if !ok {
+		return RateLimit{}, false
+	}
+	return RateLimit{Limit: limit, Remaining: remaining, Window: window}, true
+}
+
+// parseRateLimitHeader parses a value like "100;w=21600", the window being given in seconds
+func parseRateLimitHeader(value string) (int64, time.Duration, bool) {
+	amountStr, params, _ := strings.Cut(value, ";")
+	amount, err := strconv.ParseInt(strings.TrimSpace(amountStr), 10, 64)
+	if err != nil || amount < 0 {
+		return 0, 0, false
+	}
+
+	var window time.Duration
+	for _, param := range strings.Split(params, ";") {
+		if seconds, found := strings.CutPrefix(strings.TrimSpace(param), "w="); found {
+			if value, err := strconv.ParseInt(seconds, 10, 64); err == nil && value > 0 {
+				window = time.Duration(value) * time.Second
+			}
+		}
+	}
+	return amount, window, true
+}
+
+// UpstreamRateLimit returns the pull rate limit the upstream registry of an image advertises, with a HEAD request on
+// the image which doesn't count toward it. It returns nil if the registry doesn't advertise any.
+func UpstreamRateLimit(imageName string, insecureRegistries []string, rootCAs *x509.CertPool) (*RateLimit, error) {
+	ref, err := name.ParseReference(imageName)
+	if err != nil {
+		return nil, err
+	}
+
+	keychains, err := GetKeychains(imageName, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var headErrors []error
+	for _, keychain := range keychains {
+		recorder := &rateLimitRecorder{inner: upstreamTransport(ref, insecureRegistries, rootCAs)}
+		if _, err := remote.Head(ref, remote.WithAuthFromKeychain(keychain), remote.WithTransport(recorder)); err != nil {
+			headErrors = append(headErrors, err)
+			continue
+		}
+		return recorder.rateLimit, nil
+	}
+
+	return nil, utilerrors.NewAggregate(headErrors)
+}
+
+// rateLimitRecorder records the rate limit advertised by the last response advertising one
+type rateLimitRecorder struct {
+	inner     http.RoundTripper
+	rateLimit *RateLimit
+}
+
+func (r *rateLimitRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.inner.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if rateLimit, ok := ParseRateLimit(resp.Header); ok {
+		r.rateLimit = &rateLimit
+	}
+	return resp, nil
+}
@@ -0,0 +1,62 @@
+package registry
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/enix/kube-image-keeper/pkg/registrytest"
+	. "github.com/onsi/gomega"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     string
+		remaining string
+		expected  RateLimit
+		ok        bool
+	}{
+		{name: "No headers"},
+		{name: "Docker Hub", limit: "100;w=21600", remaining: "76;w=21600", expected: RateLimit{Limit: 100, Remaining: 76, Window: 6 * time.Hour}, ok: true},
+		{name: "No window", limit: "100", remaining: "76", expected: RateLimit{Limit: 100, Remaining: 76}, ok: true},
+		{name: "Invalid limit", limit: "many", remaining: "76"},
+		{name: "Missing remaining", limit: "100;w=21600"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			header := http.Header{}
+			if tt.limit != "" {
+				header.Set(rateLimitLimitHeader, tt.limit)
+			}
+			if tt.remaining != "" {
+				header.Set(rateLimitRemainingHeader, tt.remaining)
+			}
+			rateLimit, ok := ParseRateLimit(header)
+			g.Expect(ok).To(Equal(tt.ok))
+			if tt.ok {
+				g.Expect(rateLimit).To(Equal(tt.expected))
+			}
+		})
+	}
+}
+
+func TestUpstreamRateLimit(t *testing.T) {
+	g := NewWithT(t)
+
+	upstream := registrytest.New(t)
+	upstream.PushImage(t, "library/nginx:1.25", registrytest.RandomImage(t, 1))
+	image := upstream.Addr() + "/library/nginx:1.25"
+
+	rateLimit, err := UpstreamRateLimit(image, nil, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(rateLimit).To(BeNil())
+
+	upstream.SetHeader("RateLimit-Limit", "100;w=21600")
+	upstream.SetHeader("RateLimit-Remaining", "42;w=21600")
+	rateLimit, err = UpstreamRateLimit(image, nil, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(rateLimit).To(Equal(&RateLimit{Limit: 100, Remaining: 42, Window: 6 * time.Hour}))
+}
@@ -1,22 +1,23 @@
 package registry
 
 import (
+	"context"
 	"crypto/sha1"
 	"crypto/sha256"
-	"crypto/tls"
 	"crypto/x509"
-	"errors"
 	"fmt"
 	"net/http"
 	"regexp"
 	"strings"
 
+	"github.com/enix/kube-image-keeper/internal/tlsconfig"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	"github.com/google/go-containerregistry/pkg/v1/types"
 	corev1 "k8s.io/api/core/v1"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
@@ -29,6 +30,11 @@ var Protocol = "http://"
 // See https://github.com/kubernetes/apimachinery/blob/v0.20.6/pkg/util/validation/validation.go#L198
 var sanitizeNameRegex = regexp.MustCompile(`[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*`)
 
+var alphanumericSuffixRegex = regexp.MustCompile(`[A-Za-z0-9]$`)
+
+// See https://github.com/kubernetes/apimachinery/blob/v0.20.6/pkg/util/validation/validation.go#L36
+var qualifiedNameRegex = regexp.MustCompile(`^([A-Za-z0-9][-A-Za-z0-9_.]*)?[A-Za-z0-9]$`)
+
 func imageExists(ref name.Reference, options ...remote.Option) (bool, error) {
 	_, err := remote.Head(ref, options...)
 	if err != nil {
@@ -51,6 +57,11 @@ func errIsImageNotFound(err error) bool {
 }
 
 func getDestinationName(sourceName string) (string, error) {
+	return getDestinationNameIn(Endpoint, sourceName)
+}
+
+// getDestinationNameIn returns the name of an image in the cache registry reachable at endpoint
+func getDestinationNameIn(endpoint string, sourceName string) (string, error) {
 	sourceRef, err := name.ParseReference(sourceName)
 	if err != nil {
 		return "", err
@@ -60,15 +71,33 @@ func getDestinationName(sourceName string) (string, error) {
 	fullname := strings.ReplaceAll(sourceRef.Name(), "index.docker.io", "docker.io")
 	fullname = strings.ReplaceAll(fullname, sourceRef.Context().RegistryStr(), sanitizedRegistryName)
 
-	return Endpoint + "/" + fullname, nil
+	return endpoint + "/" + fullname, nil
+}
+
+// TrimDigestedTag removes the tag of an image referenced both by tag and digest, e.g. nginx:1.25@sha256:..., since
+// runtimes pull such images by digest, ignoring their tag
+func TrimDigestedTag(image string) string {
+	repository, digest, found := strings.Cut(image, "@")
+	if !found {
+		return image
+	}
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository = repository[:i]
+	}
+	return repository + "@" + digest
 }
 
 func parseLocalReference(imageName string) (name.Reference, error) {
-	destName, err := getDestinationName(imageName)
+	return parseLocalReferenceIn(Endpoint, imageName)
+}
+
+// parseLocalReferenceIn returns the reference of an image in the cache registry reachable at endpoint
+func parseLocalReferenceIn(endpoint string, imageName string) (name.Reference, error) {
+	destName, err := getDestinationNameIn(endpoint, imageName)
 	if err != nil {
 		return nil, err
 	}
-	return name.ParseReference(destName, name.Insecure)
+	return name.ParseReference(destName, cacheNameOptions()...)
 }
 
 func ImageIsCached(imageName string) (bool, error) {
@@ -77,16 +106,116 @@ func ImageIsCached(imageName string) (bool, error) {
 		return false, err
 	}
 
-	return imageExists(reference)
+	return imageExists(reference, cacheOptions()...)
+}
+
+// ImageDigest returns the digest of an image stored in cache, the one of its index for multi-arch images
+func ImageDigest(imageName string) (v1.Hash, error) {
+	ref, err := parseLocalReference(imageName)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+
+	descriptor, err := remote.Head(ref, cacheOptions()...)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+
+	return descriptor.Digest, nil
+}
+
+// ImageManifests returns the digest of the manifest of an image in the registry, followed by the ones of the manifests
+// of its platforms if it is an index
+func ImageManifests(imageName string) ([]v1.Hash, error) {
+	ref, err := parseLocalReference(imageName)
+	if err != nil {
+		return nil, err
+	}
+
+	desc, err := remote.Get(ref, cacheOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	digests := []v1.Hash{desc.Digest}
+	if !desc.MediaType.IsIndex() {
+		return digests, nil
+	}
+
+	index, err := desc.ImageIndex()
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range manifest.Manifests {
+		digests = append(digests, child.Digest)
+	}
+
+	return digests, nil
+}
+
+// CachedDigestReference returns the reference of an image in the cache registry pinned to the given digest, e.g. for
+// tools reading the image from the cache rather than from its upstream registry
+func CachedDigestReference(imageName string, digest string) (string, error) {
+	ref, err := parseLocalReference(imageName)
+	if err != nil {
+		return "", err
+	}
+	return ref.Context().Digest(digest).String(), nil
+}
+
+// ExportCachedImage writes an image of the cache registry pinned to the given digest to a tarball at path, in the
+// format of docker save, for tools that can't reach the cache registry, e.g. because it requires client certificates.
+// The first image of an index is written for multi-arch images.
+func ExportCachedImage(ctx context.Context, imageName string, digest string, path string) error {
+	ref, err := parseLocalReference(imageName)
+	if err != nil {
+		return err
+	}
+	digestRef := ref.Context().Digest(digest)
+
+	desc, err := remote.Get(digestRef, append(cacheOptions(), remote.WithContext(ctx))...)
+	if err != nil {
+		return err
+	}
+
+	var image v1.Image
+	if desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return err
+		}
+		images, err := indexImages(index)
+		if err != nil {
+			return err
+		}
+		if len(images) == 0 {
+			return fmt.Errorf("no image found in index %s", digestRef)
+		}
+		image = images[0]
+	} else if image, err = desc.Image(); err != nil {
+		return err
+	}
+
+	return tarball.WriteToFile(path, digestRef, image)
+}
+
+// CacheIsInsecure tells whether the cache registry is reached over plain HTTP
+func CacheIsInsecure() bool {
+	return Protocol != "https://"
 }
 
 func DeleteImage(imageName string) error {
+	defer lockImage(imageName)()
+
 	ref, err := parseLocalReference(imageName)
 	if err != nil {
 		return err
 	}
 
-	descriptor, err := remote.Head(ref)
+	descriptor, err := remote.Head(ref, cacheOptions()...)
 	if err != nil {
 		if errIsImageNotFound(err) {
 			return nil
@@ -94,15 +223,25 @@ func DeleteImage(imageName string) error {
 		return err
 	}
 
-	digest, err := name.NewDigest(ref.Name()+"@"+descriptor.Digest.String(), name.Insecure)
+	digest, err := name.NewDigest(ref.Name()+"@"+descriptor.Digest.String(), cacheNameOptions()...)
 	if err != nil {
 		return err
 	}
 
-	return remote.Delete(digest)
+	return remote.Delete(digest, cacheOptions()...)
 }
 
-func CacheImage(imageName string, pullSecrets []corev1.Secret, architectures []string, insecureRegistries []string, rootCAs *x509.CertPool) error {
+// CacheImage pulls an image from its upstream registry and pushes it in cache, the pull being aborted once ctx is done.
+// It returns a BudgetExceededError without pulling anything if UpstreamBudget is exhausted. When progress is not nil,
+// layers are pushed one by one and reported to it, and its completed layers are not pulled again. The image is locked in
+// ImageLocks while it is being cached.
+func CacheImage(ctx context.Context, imageName string, pullSecrets []corev1.Secret, architectures []string, insecureRegistries []string, rootCAs *x509.CertPool, progress *CacheProgress) error {
+	if err := UpstreamBudget.Check(); err != nil {
+		return err
+	}
+
+	defer lockImage(imageName)()
+
 	keychains, err := GetKeychains(imageName, pullSecrets)
 	if err != nil {
 		return err
@@ -110,7 +249,7 @@ func CacheImage(imageName string, pullSecrets []corev1.Secret, architectures []s
 
 	var cacheErrors []error
 	for _, keychain := range keychains {
-		err := cacheImageWithKeychain(imageName, keychain, architectures, insecureRegistries, rootCAs)
+		err := cacheImageWithKeychain(ctx, imageName, keychain, architectures, insecureRegistries, rootCAs, progress)
 		if err == nil { // stops at the first success
 			return nil
 		}
@@ -121,34 +260,117 @@ func CacheImage(imageName string, pullSecrets []corev1.Secret, architectures []s
 
 }
 
-func cacheImageWithKeychain(imageName string, keychain authn.Keychain, architectures []string, insecureRegistries []string, rootCAs *x509.CertPool) error {
-	destRef, err := parseLocalReference(imageName)
+// getUpstreamManifest fetches the manifest of an image from its upstream registry with a single GET request. The digest
+// a tag points to is memoized in UpstreamDigests from the GET request itself, so that a burst of reconciles of the same
+// mutable tag fetches the manifest by its memoized digest instead of resolving the tag again. The tag is fetched as is
+// if the lookup of another reconcile, possibly made with other credentials, failed.
+func getUpstreamManifest(ref name.Reference, options ...remote.Option) (*remote.Descriptor, error) {
+	if _, ok := ref.(name.Digest); ok {
+		return remote.Get(ref, options...)
+	}
+
+	var desc *remote.Descriptor
+	var lookupErr error
+	digest, err := UpstreamDigests.Get(ref.Name(), func() (v1.Hash, error) {
+		desc, lookupErr = remote.Get(ref, options...)
+		if lookupErr != nil {
+			return v1.Hash{}, lookupErr
+		}
+		return desc.Digest, nil
+	})
+	switch {
+	case desc != nil || lookupErr != nil:
+		return desc, lookupErr
+	case err != nil:
+		return remote.Get(ref, options...)
+	}
+
+	return remote.Get(ref.Context().Digest(digest.String()), options...)
+}
+
+// UpstreamImageDigest returns the digest an image points to in its upstream registry, resolved with a HEAD request
+// authenticated with its pull secrets in turn and memoized in UpstreamDigests along with the ones resolved when caching
+// images
+func UpstreamImageDigest(imageName string, pullSecrets []corev1.Secret, insecureRegistries []string, rootCAs *x509.CertPool) (v1.Hash, error) {
+	ref, err := name.ParseReference(imageName)
 	if err != nil {
-		return err
+		return v1.Hash{}, err
 	}
-	sourceRef, err := name.ParseReference(imageName)
+	keychains, err := GetKeychains(imageName, pullSecrets)
 	if err != nil {
-		return err
+		return v1.Hash{}, err
+	}
+
+	return UpstreamDigests.Get(ref.Name(), func() (v1.Hash, error) {
+		var errs []error
+		for _, keychain := range keychains {
+			desc, err := remote.Head(ref, upstreamOptions(ref, keychain, insecureRegistries, rootCAs)...)
+			if err == nil {
+				return desc.Digest, nil
+			}
+			errs = append(errs, err)
+		}
+		return v1.Hash{}, utilerrors.NewAggregate(errs)
+	})
+}
+
+// upstreamOptions returns the options to reach the upstream registry of ref, counting requests toward UpstreamBudget and
+// checking manifests against UpstreamLimits
+func upstreamOptions(ref name.Reference, keychain authn.Keychain, insecureRegistries []string, rootCAs *x509.CertPool) []remote.Option {
+	return []remote.Option{
+		remote.WithAuthFromKeychain(keychain),
+		remote.WithTransport(upstreamTransport(ref, insecureRegistries, rootCAs)),
+	}
+}
+
+func upstreamTransport(ref name.Reference, insecureRegistries []string, rootCAs *x509.CertPool) http.RoundTripper {
+	if Airgapped {
+		return airgappedTransport{}
 	}
 
-	auth := remote.WithAuthFromKeychain(keychain)
-	opts := []remote.Option{auth}
 	transport := http.DefaultTransport.(*http.Transport).Clone()
-	transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs}
+	transport.TLSClientConfig = tlsconfig.New()
+	transport.TLSClientConfig.RootCAs = rootCAs
+	transport.DialContext = UpstreamDialContext(ref.Context())
 
-	if slices.Contains(insecureRegistries, sourceRef.Context().Registry.RegistryStr()) {
+	if slices.Contains(insecureRegistries, ref.Context().Registry.RegistryStr()) {
 		transport.TLSClientConfig.InsecureSkipVerify = true
 	}
 
-	opts = append(opts, remote.WithTransport(transport))
+	return UpstreamLimits.Transport(UpstreamBudget.Transport(transport))
+}
 
-	desc, err := remote.Get(sourceRef, opts...)
+func cacheImageWithKeychain(ctx context.Context, imageName string, keychain authn.Keychain, architectures []string, insecureRegistries []string, rootCAs *x509.CertPool, progress *CacheProgress) error {
+	destRef, err := parseLocalReference(imageName)
+	if err != nil {
+		return err
+	}
+	sourceRef, err := name.ParseReference(imageName)
+	if err != nil {
+		return err
+	}
+	if tag, ok := sourceRef.(name.Tag); ok {
+		if err := UpstreamLimits.CheckTag(tag.TagStr()); err != nil {
+			return err
+		}
+	}
+
+	opts := append(upstreamOptions(sourceRef, keychain, insecureRegistries, rootCAs), remote.WithContext(ctx))
+
+	desc, err := getUpstreamManifest(sourceRef, opts...)
 	if err != nil {
 		if errIsImageNotFound(err) {
-			return errors.New("could not find source image")
+			return ErrImageNotFound
 		}
 		return err
 	}
+	if err := ImageSignaturePolicy.Verify(sourceRef, desc.Digest, opts...); err != nil {
+		return err
+	}
+
+	// Manifests whose artifacts are put in cache, the ones of an index only when it is cached as is since they refer to
+	// its digest
+	var subjects []v1.Hash
 
 	switch desc.MediaType {
 	case types.OCIImageIndex, types.DockerManifestList:
@@ -156,17 +378,38 @@ func cacheImageWithKeychain(imageName string, keychain authn.Keychain, architect
 		if err != nil {
 			return err
 		}
+		if err := BaseImagesPolicy.Check(index, opts...); err != nil {
+			return err
+		}
 
-		filteredIndex := mutate.RemoveManifests(index, func(desc v1.Descriptor) bool {
-			for _, arch := range architectures {
-				if arch == desc.Platform.Architecture {
-					return false
+		// Filtering the index of an image referenced by digest would change its digest, every architecture is cached
+		filteredIndex := index
+		if _, ok := sourceRef.(name.Digest); !ok {
+			filteredIndex = mutate.RemoveManifests(index, func(desc v1.Descriptor) bool {
+				for _, arch := range architectures {
+					if arch == desc.Platform.Architecture {
+						return false
+					}
 				}
+				return true
+			})
+		}
+		if CacheArtifacts {
+			if subjects, err = artifactSubjects(desc.Digest, filteredIndex); err != nil {
+				return err
 			}
-			return true
-		})
+		}
 
-		if err := remote.WriteIndex(destRef, filteredIndex); err != nil {
+		if progress != nil {
+			images, err := indexImages(filteredIndex)
+			if err != nil {
+				return err
+			}
+			if err := pushLayers(destRef.Context(), images, progress); err != nil {
+				return err
+			}
+		}
+		if err := remote.WriteIndex(destRef, filteredIndex, cacheOptions()...); err != nil {
 			return err
 		}
 	default:
@@ -174,9 +417,19 @@ func cacheImageWithKeychain(imageName string, keychain authn.Keychain, architect
 		if err != nil {
 			return err
 		}
-		if err := remote.Write(destRef, image); err != nil {
+		if progress != nil {
+			if err := pushLayers(destRef.Context(), []v1.Image{image}, progress); err != nil {
+				return err
+			}
+		}
+		if err := remote.Write(destRef, image, cacheOptions()...); err != nil {
 			return err
 		}
+		subjects = []v1.Hash{desc.Digest}
+	}
+
+	if CacheArtifacts {
+		cacheArtifactsOf(ctx, sourceRef.Context(), destRef.Context(), subjects, opts...)
 	}
 
 	return nil
@@ -202,6 +455,50 @@ func ContainerAnnotationKey(containerName string, initContainer bool) string {
 		template = "original-init-image-%s"
 	}
 
+	return containerAnnotationKey(template, containerName)
+}
+
+// EphemeralContainerAnnotationKey returns the key of the annotation storing the original image of an ephemeral
+// container
+func EphemeralContainerAnnotationKey(containerName string) string {
+	return containerAnnotationKey("original-ephemeral-image-%s", containerName)
+}
+
+// EnvAnnotationKey returns the key of the annotation storing the original image held by an environment variable of a
+// container
+func EnvAnnotationKey(containerName string, envName string, initContainer bool) string {
+	template := "original-env-image-%s"
+	if initContainer {
+		template = "original-init-env-image-%s"
+	}
+
+	name := containerName + "." + envName
+	// Annotation keys must end with an alphanumeric character, contrary to environment variable names
+	if !alphanumericSuffixRegex.MatchString(name) {
+		name = fmt.Sprintf("%x", sha1.Sum([]byte(name)))
+	}
+
+	return containerAnnotationKey(template, name)
+}
+
+// FieldAnnotationKey returns the key of the annotation storing the original image held by a field of a container
+// selected by the image paths annotation of its pod, given by its location in the container, e.g. args.1
+func FieldAnnotationKey(containerName string, location string, initContainer bool) string {
+	template := "original-field-image-%s"
+	if initContainer {
+		template = "original-init-field-image-%s"
+	}
+
+	name := containerName + "." + location
+	// Locations may contain map keys that are not allowed in annotation keys
+	if !qualifiedNameRegex.MatchString(name) {
+		name = fmt.Sprintf("%x", sha1.Sum([]byte(name)))
+	}
+
+	return containerAnnotationKey(template, name)
+}
+
+func containerAnnotationKey(template string, containerName string) string {
 	if len(containerName)+len(template)-2 > 63 {
 		containerName = fmt.Sprintf("%x", sha1.Sum([]byte(containerName)))
 	}
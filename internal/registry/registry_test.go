@@ -1,16 +1,22 @@
 package registry
 
 import (
+	"context"
 	"crypto/sha1"
 	"crypto/sha256"
 	"errors"
 	"fmt"
 	"net/http"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/enix/kube-image-keeper/pkg/registrytest"
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/ghttp"
 	corev1 "k8s.io/api/core/v1"
@@ -289,6 +295,7 @@ func Test_CacheImage(t *testing.T) {
 			defer originRegistry.Close()
 			originRegistry.AppendHandlers(
 				mockV2Endpoint(gh),
+				// the manifest is fetched by tag with a single request, its digest being memoized from it
 				ghttp.CombineHandlers(
 					gh.VerifyRequest(http.MethodGet, "/v2/"+tt.image+"/manifests/latest"),
 					gh.RespondWith(tt.httpStatus, tt.httpResponse, mockedHeadImageHeader),
@@ -330,7 +337,7 @@ func Test_CacheImage(t *testing.T) {
 			)
 
 			Endpoint = cacheRegistry.Addr()
-			err := CacheImage(originRegistry.Addr()+"/"+tt.image, []corev1.Secret{}, []string{"amd64"}, []string{}, nil)
+			err := CacheImage(context.Background(), originRegistry.Addr()+"/"+tt.image, []corev1.Secret{}, []string{"amd64"}, []string{}, nil, nil)
 			if tt.wantErr != "" {
 				g.Expect(err).To(BeAssignableToTypeOf(tt.errType))
 				g.Expect(err).To(MatchError(ContainSubstring(tt.wantErr)))
@@ -341,6 +348,128 @@ func Test_CacheImage(t *testing.T) {
 	}
 }
 
+func Test_CacheImageByDigest(t *testing.T) {
+	g := NewWithT(t)
+
+	upstream := registrytest.New(t)
+	cache := registrytest.New(t)
+	Endpoint = cache.Addr()
+
+	index := registrytest.PlatformIndex(t, "linux/amd64", "linux/arm64")
+	upstream.PushIndex(t, "shop/app:v1", index)
+	digest, err := index.Digest()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Images referenced by tag only keep the architectures put in cache
+	g.Expect(CacheImage(context.Background(), upstream.Addr()+"/shop/app:v1", nil, []string{"amd64"}, nil, nil, nil)).To(Succeed())
+	cachedIndex, err := remote.Index(cache.Reference(t, strings.ReplaceAll(upstream.Addr(), ":", "-")+"/shop/app:v1"))
+	g.Expect(err).ToNot(HaveOccurred())
+	manifest, err := cachedIndex.IndexManifest()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(manifest.Manifests).To(HaveLen(1))
+
+	// Images referenced by digest keep every architecture so that their digest doesn't change
+	image := upstream.Addr() + "/shop/app@" + digest.String()
+	g.Expect(CacheImage(context.Background(), image, nil, []string{"amd64"}, nil, nil, nil)).To(Succeed())
+	g.Expect(ImageIsCached(image)).To(BeTrue())
+	cachedDigest, err := ImageDigest(image)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cachedDigest).To(Equal(digest))
+}
+
+func Test_getUpstreamManifest(t *testing.T) {
+	g := NewWithT(t)
+
+	upstream := registrytest.New(t)
+	image := registrytest.RandomImage(t, 1)
+	ref := upstream.PushImage(t, "shop/app:v1", image)
+	digest, err := image.Digest()
+	g.Expect(err).ToNot(HaveOccurred())
+	pushRequests := len(upstream.Requests())
+
+	// Tags are fetched with a single request, then by their memoized digest
+	for i := 0; i < 2; i++ {
+		desc, err := getUpstreamManifest(ref)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(desc.Digest).To(Equal(digest))
+	}
+	g.Expect(upstream.Requests()[pushRequests:]).To(Equal([]string{
+		"GET /v2/",
+		"GET /v2/shop/app/manifests/v1",
+		"GET /v2/",
+		"GET /v2/shop/app/manifests/" + digest.String(),
+	}))
+}
+
+func Test_ImageManifests(t *testing.T) {
+	g := NewWithT(t)
+
+	cache := registrytest.New(t)
+	Endpoint = cache.Addr()
+
+	index := registrytest.PlatformIndex(t, "linux/amd64", "linux/arm64")
+	cache.PushIndex(t, "docker.io/library/nginx:1.25", index)
+	digest, err := index.Digest()
+	g.Expect(err).ToNot(HaveOccurred())
+	manifest, err := index.IndexManifest()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	g.Expect(ImageManifests("nginx:1.25")).To(Equal([]v1.Hash{digest, manifest.Manifests[0].Digest, manifest.Manifests[1].Digest}))
+
+	image := registrytest.RandomImage(t, 1)
+	cache.PushImage(t, "docker.io/library/alpine:3.19", image)
+	digest, err = image.Digest()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ImageManifests("alpine:3.19")).To(Equal([]v1.Hash{digest}))
+
+	_, err = ImageManifests("redis:7")
+	g.Expect(err).To(HaveOccurred())
+}
+
+func Test_ExportCachedImage(t *testing.T) {
+	g := NewWithT(t)
+
+	cache := registrytest.New(t)
+	Endpoint = cache.Addr()
+
+	index := registrytest.PlatformIndex(t, "linux/amd64", "linux/arm64")
+	cache.PushIndex(t, "docker.io/library/nginx:1.25", index)
+	digest, err := index.Digest()
+	g.Expect(err).ToNot(HaveOccurred())
+	manifest, err := index.IndexManifest()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// The first image of an index is written
+	path := filepath.Join(t.TempDir(), "image.tar")
+	g.Expect(ExportCachedImage(context.Background(), "nginx:1.25", digest.String(), path)).To(Succeed())
+	image, err := tarball.ImageFromPath(path, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(image.Digest()).To(Equal(manifest.Manifests[0].Digest))
+
+	g.Expect(ExportCachedImage(context.Background(), "nginx:1.25", mockedDigest, path)).ToNot(Succeed())
+}
+
+func TestTrimDigestedTag(t *testing.T) {
+	digest := "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	tests := []struct {
+		image    string
+		expected string
+	}{
+		{image: "nginx:1.25", expected: "nginx:1.25"},
+		{image: "nginx@" + digest, expected: "nginx@" + digest},
+		{image: "nginx:1.25@" + digest, expected: "nginx@" + digest},
+		{image: "localhost:5000/nginx@" + digest, expected: "localhost:5000/nginx@" + digest},
+		{image: "localhost:5000/nginx:1.25@" + digest, expected: "localhost:5000/nginx@" + digest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(TrimDigestedTag(tt.image)).To(Equal(tt.expected))
+		})
+	}
+}
+
 func TestSanitizeName(t *testing.T) {
 	tests := []struct {
 		name                   string
@@ -480,3 +609,126 @@ func TestContainerAnnotationKey(t *testing.T) {
 		})
 	}
 }
+
+func TestEnvAnnotationKey(t *testing.T) {
+	tests := []struct {
+		name                  string
+		containerName         string
+		envName               string
+		initContainer         bool
+		expectedAnnotationKey string
+	}{
+		{
+			name:                  "Basic",
+			containerName:         "runner",
+			envName:               "HELPER_IMAGE",
+			expectedAnnotationKey: "original-env-image-runner.HELPER_IMAGE",
+		},
+		{
+			name:                  "Basic init",
+			containerName:         "runner",
+			envName:               "HELPER_IMAGE",
+			initContainer:         true,
+			expectedAnnotationKey: "original-init-env-image-runner.HELPER_IMAGE",
+		},
+		{
+			name:                  "Trailing underscore",
+			containerName:         "runner",
+			envName:               "IMAGE_",
+			expectedAnnotationKey: "original-env-image-" + sha1Sum("runner.IMAGE_"),
+		},
+		{
+			name:                  "Long name",
+			containerName:         "my-incredible-and-marvelous-runner",
+			envName:               "KUBERNETES_HELPER_IMAGE",
+			expectedAnnotationKey: "original-env-image-" + sha1Sum("my-incredible-and-marvelous-runner.KUBERNETES_HELPER_IMAGE"),
+		},
+	}
+
+	g := NewWithT(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			annotationKey := EnvAnnotationKey(tt.containerName, tt.envName, tt.initContainer)
+			g.Expect(annotationKey).To(Equal(tt.expectedAnnotationKey))
+		})
+	}
+}
+
+func TestFieldAnnotationKey(t *testing.T) {
+	tests := []struct {
+		name                  string
+		containerName         string
+		location              string
+		initContainer         bool
+		expectedAnnotationKey string
+	}{
+		{
+			name:                  "Basic",
+			containerName:         "runner",
+			location:              "args.1",
+			expectedAnnotationKey: "original-field-image-runner.args.1",
+		},
+		{
+			name:                  "Basic init",
+			containerName:         "runner",
+			location:              "env.0.value",
+			initContainer:         true,
+			expectedAnnotationKey: "original-init-field-image-runner.env.0.value",
+		},
+		{
+			name:                  "Invalid characters",
+			containerName:         "runner",
+			location:              "resources.limits.nvidia.com/gpu",
+			expectedAnnotationKey: "original-field-image-" + sha1Sum("runner.resources.limits.nvidia.com/gpu"),
+		},
+	}
+
+	g := NewWithT(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			annotationKey := FieldAnnotationKey(tt.containerName, tt.location, tt.initContainer)
+			g.Expect(annotationKey).To(Equal(tt.expectedAnnotationKey))
+		})
+	}
+}
+
+func TestProxiedImage(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(ProxiedImage("localhost:7439/docker.io/library/nginx:1.25")).To(Equal("docker.io/library/nginx:1.25"))
+	g.Expect(ProxiedImage("127.0.0.1:7439/nginx")).To(Equal("nginx"))
+	g.Expect(ProxiedImage("localhost:7439/host-5000/app:v1")).To(Equal("host:5000/app:v1"))
+	g.Expect(ProxiedImage("[::1]:7439/registry-5000.enix.io-5000/app")).To(Equal("registry-5000.enix.io:5000/app"))
+	g.Expect(ProxiedImage("localhost:7439/enix.io/app-5000/image")).To(Equal("enix.io/app-5000/image"))
+	g.Expect(ProxiedImage("host-5000/app")).To(Equal("host-5000/app"))
+}
+
+func TestParseProxyHost(t *testing.T) {
+	tests := []struct {
+		host     string
+		expected string
+		wantErr  bool
+	}{
+		{host: "localhost", expected: "localhost"},
+		{host: "127.0.0.1", expected: "127.0.0.1"},
+		{host: "::1", expected: "[::1]"},
+		{host: "[::1]", expected: "[::1]"},
+		{host: "0:0:0:0:0:0:0:1", expected: "[::1]"},
+		{host: "10.0.0.1", wantErr: true},
+		{host: "example.com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			g := NewWithT(t)
+			host, err := ParseProxyHost(tt.host)
+			if tt.wantErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(host).To(Equal(tt.expected))
+			g.Expect(ProxyHostRegexp.MatchString(host + ":7439/nginx")).To(BeTrue())
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package registry
+
+import (
+	"crypto/x509"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	corev1 "k8s.io/api/core/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// ImageRefNameAnnotation is the annotation of the manifests of an image index holding the reference of an image
+const ImageRefNameAnnotation = "org.opencontainers.image.ref.name"
+
+// IndexImages returns the images referenced by the ImageRefNameAnnotation annotation of the manifests of an upstream
+// image index, e.g. the images of a release pushed as an OCI artifact by a CI pipeline. Manifests without this
+// annotation are ignored.
+func IndexImages(indexName string, insecureRegistries []string, rootCAs *x509.CertPool) ([]string, error) {
+	ref, err := name.ParseReference(indexName)
+	if err != nil {
+		return nil, err
+	}
+
+	keychains, err := GetKeychains(indexName, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var indexErrors []error
+	for _, keychain := range keychains {
+		index, err := remote.Index(ref, upstreamOptions(ref, keychain, insecureRegistries, rootCAs)...)
+		if err != nil {
+			indexErrors = append(indexErrors, err)
+			continue
+		}
+
+		manifest, err := index.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+
+		images := []string{}
+		for _, desc := range manifest.Manifests {
+			if image := desc.Annotations[ImageRefNameAnnotation]; image != "" {
+				images = append(images, image)
+			}
+		}
+		return images, nil
+	}
+
+	return nil, utilerrors.NewAggregate(indexErrors)
+}
+
+// RepositoryTags returns the tags of a repository of an upstream registry, e.g. ghcr.io/enix/shop, authenticated with
+// its pull secrets in turn
+func RepositoryTags(repositoryName string, pullSecrets []corev1.Secret, insecureRegistries []string, rootCAs *x509.CertPool) ([]string, error) {
+	repository, err := name.NewRepository(repositoryName)
+	if err != nil {
+		return nil, err
+	}
+
+	keychains, err := GetKeychains(repositoryName, pullSecrets)
+	if err != nil {
+		return nil, err
+	}
+
+	var listErrors []error
+	for _, keychain := range keychains {
+		tags, err := remote.List(repository, upstreamOptions(repository.Tag(name.DefaultTag), keychain, insecureRegistries, rootCAs)...)
+		if err != nil {
+			listErrors = append(listErrors, err)
+			continue
+		}
+		return tags, nil
+	}
+
+	return nil, utilerrors.NewAggregate(listErrors)
+}
@@ -0,0 +1,56 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/enix/kube-image-keeper/pkg/registrytest"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	. "github.com/onsi/gomega"
+)
+
+func TestIndexImages(t *testing.T) {
+	g := NewWithT(t)
+
+	upstream := registrytest.New(t)
+	host := upstream.Addr()
+
+	var index v1.ImageIndex = empty.Index
+	for _, annotations := range []map[string]string{
+		{ImageRefNameAnnotation: "docker.io/library/nginx:1.25"},
+		{ImageRefNameAnnotation: "ghcr.io/enix/shop@sha256:8a5a6ff5a8c2a6ee07e5a3b1a9cdbe8e8b5b5c4a3d0f7b2c9e1d4f6a8b0c2e4f"},
+		{"org.opencontainers.image.title": "release notes"},
+	} {
+		index = mutate.AppendManifests(index, mutate.IndexAddendum{Add: registrytest.RandomImage(t, 1), Descriptor: v1.Descriptor{Annotations: annotations}})
+	}
+	upstream.PushIndex(t, "shop/release:v2.3", index)
+
+	images, err := IndexImages(host+"/shop/release:v2.3", nil, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(images).To(Equal([]string{
+		"docker.io/library/nginx:1.25",
+		"ghcr.io/enix/shop@sha256:8a5a6ff5a8c2a6ee07e5a3b1a9cdbe8e8b5b5c4a3d0f7b2c9e1d4f6a8b0c2e4f",
+	}))
+
+	_, err = IndexImages(host+"/shop/release:v2.4", nil, nil)
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestRepositoryTags(t *testing.T) {
+	g := NewWithT(t)
+
+	upstream := registrytest.New(t)
+	host := upstream.Addr()
+
+	for _, tag := range []string{"v1.0", "v1.1", "v2.0"} {
+		upstream.PushImage(t, "shop/api:"+tag, registrytest.RandomImage(t, 1))
+	}
+
+	tags, err := RepositoryTags(host+"/shop/api", nil, nil, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tags).To(ConsistOf("v1.0", "v1.1", "v2.0"))
+
+	_, err = RepositoryTags(host+"/shop/API", nil, nil, nil)
+	g.Expect(err).To(HaveOccurred())
+}
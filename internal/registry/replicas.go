@@ -0,0 +1,117 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReadReplicaFailureTTL is how long a read replica that could not be reached is skipped
+var ReadReplicaFailureTTL = 30 * time.Second
+
+// ReadReplicas are the read replicas of the cache registry the proxy reads images from, nil if there are none. Images
+// are always written to Endpoint, which the replicas share or replicate the storage of.
+var ReadReplicas *ReplicaRouter
+
+// ReadReplica is a replica of the cache registry serving reads, reached with the same protocol and credentials as
+// Endpoint
+type ReadReplica struct {
+	Endpoint string
+	// Zone is the failure domain of the replica, as in the topology.kubernetes.io/zone label of nodes, empty if unknown
+	Zone string
+}
+
+// ParseReadReplicas parses read replicas given as endpoint or endpoint=zone
+func ParseReadReplicas(values []string) ([]ReadReplica, error) {
+	replicas := make([]ReadReplica, 0, len(values))
+	for _, value := range values {
+		endpoint, zone, _ := strings.Cut(value, "=")
+		if endpoint == "" {
+			return nil, fmt.Errorf("invalid read replica %q, expected endpoint or endpoint=zone", value)
+		}
+		replicas = append(replicas, ReadReplica{Endpoint: endpoint, Zone: zone})
+	}
+	return replicas, nil
+}
+
+// ReplicaRouter routes reads to the read replicas of the cache registry, preferring those of the zone of the proxy
+// and spreading reads across them, then to the primary registry
+type ReplicaRouter struct {
+	replicas []ReadReplica
+	zone     string
+	now      func() time.Time
+
+	mu          sync.Mutex
+	next        int
+	failedUntil map[string]time.Time
+}
+
+func NewReplicaRouter(replicas []ReadReplica, zone string) *ReplicaRouter {
+	return &ReplicaRouter{
+		replicas:    replicas,
+		zone:        zone,
+		now:         time.Now,
+		failedUntil: map[string]time.Time{},
+	}
+}
+
+// Endpoints returns the endpoints to read from, in the order they should be tried: the replicas in the zone of the
+// proxy, then the ones in other zones, then the primary registry. Replicas are rotated on each call so that reads are
+// spread across them, and replicas that recently failed are left out.
+func (r *ReplicaRouter) Endpoints() []string {
+	if r == nil {
+		return []string{Endpoint}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	local, remote := []string{}, []string{}
+	for i := range r.replicas {
+		replica := r.replicas[(r.next+i)%len(r.replicas)]
+		if now.Before(r.failedUntil[replica.Endpoint]) {
+			continue
+		}
+		if r.zone != "" && replica.Zone == r.zone {
+			local = append(local, replica.Endpoint)
+		} else {
+			remote = append(remote, replica.Endpoint)
+		}
+	}
+	if len(r.replicas) > 0 {
+		r.next = (r.next + 1) % len(r.replicas)
+	}
+
+	return append(append(local, remote...), Endpoint)
+}
+
+// MarkFailed skips a replica for ReadReplicaFailureTTL
+func (r *ReplicaRouter) MarkFailed(endpoint string) {
+	if r == nil || !r.isReplica(endpoint) {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.failedUntil[endpoint] = r.now().Add(ReadReplicaFailureTTL)
+}
+
+// IsReadEndpoint tells whether endpoint is the primary registry or one of its read replicas
+func (r *ReplicaRouter) IsReadEndpoint(endpoint string) bool {
+	return endpoint == Endpoint || r.isReplica(endpoint)
+}
+
+func (r *ReplicaRouter) isReplica(endpoint string) bool {
+	if r == nil {
+		return false
+	}
+	for _, replica := range r.replicas {
+		if replica.Endpoint == endpoint {
+			return true
+		}
+	}
+	return false
+}
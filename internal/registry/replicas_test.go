@@ -0,0 +1,62 @@
+package registry
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestParseReadReplicas(t *testing.T) {
+	g := NewWithT(t)
+
+	replicas, err := ParseReadReplicas([]string{"replica-a:5000=zone-a", "replica:5000"})
+	g.Expect(err).To(BeNil())
+	g.Expect(replicas).To(Equal([]ReadReplica{
+		{Endpoint: "replica-a:5000", Zone: "zone-a"},
+		{Endpoint: "replica:5000"},
+	}))
+
+	_, err = ParseReadReplicas([]string{"=zone-a"})
+	g.Expect(err).ToNot(BeNil())
+}
+
+func TestReplicaRouter_Endpoints(t *testing.T) {
+	g := NewWithT(t)
+	defer func(endpoint string) { Endpoint = endpoint }(Endpoint)
+	Endpoint = "primary:5000"
+
+	g.Expect((*ReplicaRouter)(nil).Endpoints()).To(Equal([]string{"primary:5000"}))
+
+	now := time.Now()
+	router := NewReplicaRouter([]ReadReplica{
+		{Endpoint: "a1:5000", Zone: "zone-a"},
+		{Endpoint: "b1:5000", Zone: "zone-b"},
+		{Endpoint: "a2:5000", Zone: "zone-a"},
+	}, "zone-a")
+	router.now = func() time.Time { return now }
+
+	g.Expect(router.Endpoints()).To(Equal([]string{"a1:5000", "a2:5000", "b1:5000", "primary:5000"}))
+	g.Expect(router.Endpoints()).To(Equal([]string{"a2:5000", "a1:5000", "b1:5000", "primary:5000"}))
+	g.Expect(router.Endpoints()).To(Equal([]string{"a2:5000", "a1:5000", "b1:5000", "primary:5000"}))
+	g.Expect(router.Endpoints()).To(Equal([]string{"a1:5000", "a2:5000", "b1:5000", "primary:5000"}))
+
+	router.MarkFailed("a1:5000")
+	router.MarkFailed("primary:5000")
+	g.Expect(router.Endpoints()).To(Equal([]string{"a2:5000", "b1:5000", "primary:5000"}))
+
+	now = now.Add(ReadReplicaFailureTTL)
+	g.Expect(router.Endpoints()).To(ContainElement("a1:5000"))
+}
+
+func TestReplicaRouter_IsReadEndpoint(t *testing.T) {
+	g := NewWithT(t)
+	defer func(endpoint string) { Endpoint = endpoint }(Endpoint)
+	Endpoint = "primary:5000"
+
+	router := NewReplicaRouter([]ReadReplica{{Endpoint: "a1:5000"}}, "")
+	g.Expect(router.IsReadEndpoint("primary:5000")).To(BeTrue())
+	g.Expect(router.IsReadEndpoint("a1:5000")).To(BeTrue())
+	g.Expect(router.IsReadEndpoint("upstream:5000")).To(BeFalse())
+	g.Expect((*ReplicaRouter)(nil).IsReadEndpoint("primary:5000")).To(BeTrue())
+}
@@ -0,0 +1,107 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// ShortNameAliases resolve short image names, e.g. "ubi8", the way container runtimes configured with
+// containers-registries.conf do, e.g. CRI-O on OpenShift, RHEL or Fedora nodes, instead of resolving them to docker.io.
+// Aliases map short names to fully qualified repositories.
+type ShortNameAliases map[string]string
+
+// ShortNames are the rules resolving short image names: aliases first, then the search registry short names without
+// alias are pulled from. Short names are resolved to docker.io when there is no search registry, like Kubernetes does.
+type ShortNames struct {
+	Aliases ShortNameAliases
+	// SearchRegistry is the only registry of the unqualified-search-registries of nodes. Runtimes try every search
+	// registry in turn when there are several of them, which can't be resolved without pulling images, so short names
+	// are only resolved to a search registry when it is the only one.
+	SearchRegistry string
+}
+
+// LoadShortNames reads the [aliases] tables and the unqualified-search-registries of containers-registries.conf files,
+// e.g. /etc/containers/registries.conf.d/000-shortnames.conf. The .conf files of directories are read in lexical order
+// like registries.conf.d, aliases of later files overriding earlier ones, as well as unqualified-search-registries.
+func LoadShortNames(paths ...string) (ShortNames, error) {
+	shortNames := ShortNames{Aliases: ShortNameAliases{}}
+	searchRegistries := []string{}
+
+	for _, path := range paths {
+		files := []string{path}
+		if info, err := os.Stat(path); err != nil {
+			return ShortNames{}, err
+		} else if info.IsDir() {
+			if files, err = filepath.Glob(filepath.Join(path, "*.conf")); err != nil {
+				return ShortNames{}, err
+			}
+			sort.Strings(files)
+		}
+
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return ShortNames{}, err
+			}
+			config := struct {
+				UnqualifiedSearchRegistries *[]string         `toml:"unqualified-search-registries"`
+				Aliases                     map[string]string `toml:"aliases"`
+			}{}
+			if err := toml.Unmarshal(data, &config); err != nil {
+				return ShortNames{}, err
+			}
+			for shortName, alias := range config.Aliases {
+				shortNames.Aliases[shortName] = alias
+			}
+			if config.UnqualifiedSearchRegistries != nil {
+				searchRegistries = *config.UnqualifiedSearchRegistries
+			}
+		}
+	}
+
+	if len(searchRegistries) == 1 {
+		shortNames.SearchRegistry = searchRegistries[0]
+	}
+
+	return shortNames, nil
+}
+
+// Resolve returns the fully qualified image a short image name resolves to, keeping its tag or digest, or the image
+// unchanged if it is not a short name or if it has neither an alias nor a search registry
+func (s ShortNames) Resolve(image string) string {
+	if resolved := s.Aliases.Resolve(image); resolved != image || s.SearchRegistry == "" || !isShortName(image) {
+		return resolved
+	}
+	return s.SearchRegistry + "/" + image
+}
+
+// Resolve returns the fully qualified image a short image name is an alias of, keeping its tag or digest, or the image
+// unchanged if it is not a short name or if it has no alias
+func (a ShortNameAliases) Resolve(image string) string {
+	if len(a) == 0 || !isShortName(image) {
+		return image
+	}
+
+	repository, suffix := image, ""
+	if i := strings.Index(repository, "@"); i >= 0 {
+		repository, suffix = repository[:i], repository[i:]
+	}
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository, suffix = repository[:i], repository[i:]+suffix
+	}
+
+	if alias, ok := a[repository]; ok {
+		return alias + suffix
+	}
+	return image
+}
+
+// isShortName tells whether an image has no registry
+func isShortName(image string) bool {
+	first, _, found := strings.Cut(image, "/")
+	return !found || !(strings.ContainsAny(first, ".:") || first == "localhost")
+}
@@ -0,0 +1,104 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestLoadShortNames(t *testing.T) {
+	g := NewWithT(t)
+
+	dir := t.TempDir()
+	g.Expect(os.WriteFile(filepath.Join(dir, "000-shortnames.conf"), []byte(`
+[aliases]
+  # Red Hat Universal Base Image
+  "ubi8" = "registry.access.redhat.com/ubi8"
+  "nginx" = "docker.io/library/nginx"
+`), 0o644)).To(Succeed())
+	g.Expect(os.WriteFile(filepath.Join(dir, "001-custom.conf"), []byte(`
+unqualified-search-registries = ["quay.io"]
+
+[aliases]
+"nginx" = "quay.io/myorg/nginx"
+`), 0o644)).To(Succeed())
+	g.Expect(os.WriteFile(filepath.Join(dir, "README"), []byte("not a config file"), 0o644)).To(Succeed())
+
+	shortNames, err := LoadShortNames(dir)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(shortNames).To(Equal(ShortNames{
+		Aliases: ShortNameAliases{
+			"ubi8":  "registry.access.redhat.com/ubi8",
+			"nginx": "quay.io/myorg/nginx",
+		},
+		SearchRegistry: "quay.io",
+	}))
+
+	// Short names can't be resolved to one of several search registries
+	g.Expect(os.WriteFile(filepath.Join(dir, "002-search.conf"), []byte(`
+unqualified-search-registries = ["registry.fedoraproject.org", "docker.io"]
+`), 0o644)).To(Succeed())
+	shortNames, err = LoadShortNames(dir)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(shortNames.SearchRegistry).To(BeEmpty())
+	g.Expect(shortNames.Aliases).To(HaveLen(2))
+
+	_, err = LoadShortNames(filepath.Join(dir, "missing.conf"))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestShortNameAliases_Resolve(t *testing.T) {
+	aliases := ShortNameAliases{
+		"ubi8":       "registry.access.redhat.com/ubi8",
+		"rhel7/rhel": "registry.access.redhat.com/rhel7/rhel",
+	}
+
+	tests := []struct {
+		image    string
+		expected string
+	}{
+		{image: "ubi8", expected: "registry.access.redhat.com/ubi8"},
+		{image: "ubi8:8.9", expected: "registry.access.redhat.com/ubi8:8.9"},
+		{image: "ubi8@sha256:0000000000000000000000000000000000000000000000000000000000000000", expected: "registry.access.redhat.com/ubi8@sha256:0000000000000000000000000000000000000000000000000000000000000000"},
+		{image: "rhel7/rhel:7.9", expected: "registry.access.redhat.com/rhel7/rhel:7.9"},
+		{image: "nginx:1.25", expected: "nginx:1.25"},
+		{image: "quay.io/ubi8", expected: "quay.io/ubi8"},
+		{image: "localhost/ubi8", expected: "localhost/ubi8"},
+		{image: "localhost:5000/ubi8", expected: "localhost:5000/ubi8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(aliases.Resolve(tt.image)).To(Equal(tt.expected))
+		})
+	}
+}
+
+func TestShortNames_Resolve(t *testing.T) {
+	shortNames := ShortNames{
+		Aliases:        ShortNameAliases{"ubi8": "registry.access.redhat.com/ubi8"},
+		SearchRegistry: "quay.io",
+	}
+
+	tests := []struct {
+		image    string
+		expected string
+	}{
+		{image: "ubi8:8.9", expected: "registry.access.redhat.com/ubi8:8.9"},
+		{image: "nginx:1.25", expected: "quay.io/nginx:1.25"},
+		{image: "myorg/app@sha256:0000000000000000000000000000000000000000000000000000000000000000", expected: "quay.io/myorg/app@sha256:0000000000000000000000000000000000000000000000000000000000000000"},
+		{image: "docker.io/library/nginx:1.25", expected: "docker.io/library/nginx:1.25"},
+		{image: "localhost:5000/nginx", expected: "localhost:5000/nginx"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(shortNames.Resolve(tt.image)).To(Equal(tt.expected))
+			g.Expect(ShortNames{}.Resolve(tt.image)).To(Equal(tt.image))
+		})
+	}
+}
@@ -0,0 +1,518 @@
+package registry
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+const (
+	// Media type and annotations of the layers of cosign signature manifests, see
+	// https://github.com/sigstore/cosign/blob/main/specs/SIGNATURE_SPEC.md
+	simpleSigningMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	signatureAnnotation    = "dev.cosignproject.cosign/signature"
+	certificateAnnotation  = "dev.sigstore.cosign/certificate"
+	chainAnnotation        = "dev.sigstore.cosign/chain"
+	bundleAnnotation       = "dev.sigstore.cosign/bundle"
+
+	// maxSignaturePayloadSize is the maximum size of the payload of a signature read in memory
+	maxSignaturePayloadSize = 1 << 20
+)
+
+var (
+	// Extensions of Fulcio certificates holding the OIDC issuer of the identity of the signer, the first one being
+	// deprecated in favor of the second one, see https://github.com/sigstore/fulcio/blob/main/docs/oid-info.md
+	fulcioIssuerV1OID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	fulcioIssuerV2OID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// ImageSignaturePolicy requires the images of some registries to be signed with cosign before they are put in cache,
+// images of other registries are cached without verification
+var ImageSignaturePolicy = SignaturePolicy{}
+
+// SignaturePolicy verifies the cosign signatures of images, either made with keys or keyless ones made with the short
+// lived certificates of Fulcio and recorded in Rekor, per registry
+type SignaturePolicy struct {
+	// Registries are the policies of the registries whose images must be signed
+	Registries []RegistrySignaturePolicy `json:"registries"`
+	// FulcioCertificates are the PEM encoded root and intermediate certificates of the Fulcio certificate authority
+	// issuing the certificates of keyless signatures
+	FulcioCertificates string `json:"fulcioCertificates,omitempty"`
+	// RekorPublicKeys are the PEM encoded public keys of the Rekor transparency logs keyless signatures are recorded in
+	RekorPublicKeys string `json:"rekorPublicKeys,omitempty"`
+
+	fulcioRoots         *x509.CertPool
+	fulcioIntermediates *x509.CertPool
+	rekorKeys           []crypto.PublicKey
+}
+
+// RegistrySignaturePolicy tells who may sign the images of a registry, a signature made with any of its keys or by any
+// of its identities being enough
+type RegistrySignaturePolicy struct {
+	// Registry is the registry of the images, e.g. ghcr.io or docker.io
+	Registry string `json:"registry"`
+	// Keys are the PEM encoded public keys of the signers, e.g. the cosign.pub file of cosign generate-key-pair
+	Keys []string `json:"keys,omitempty"`
+	// Identities are the identities of the signers of keyless signatures
+	Identities []KeylessIdentity `json:"identities,omitempty"`
+
+	keys []crypto.PublicKey
+}
+
+// KeylessIdentity is the identity of the signer of keyless signatures, as certified by Fulcio
+type KeylessIdentity struct {
+	// Issuer is the OIDC issuer of the identity, e.g. https://token.actions.githubusercontent.com
+	Issuer string `json:"issuer"`
+	// Subject is the email address or the URI of the identity, e.g. the workflow of a GitHub Actions run
+	Subject string `json:"subject,omitempty"`
+	// SubjectRegExp matches the email address or the URI of the identity, when Subject is empty
+	SubjectRegExp string `json:"subjectRegExp,omitempty"`
+
+	subjectRegExp *regexp.Regexp
+}
+
+// SignatureVerificationError is returned when an image of a registry requiring signatures has no signature verified by
+// the policy of the registry
+type SignatureVerificationError struct {
+	Image  string
+	Reason string
+}
+
+func (e *SignatureVerificationError) Error() string {
+	return fmt.Sprintf("signature of image %s could not be verified: %s", e.Image, e.Reason)
+}
+
+// LoadSignaturePolicy reads a SignaturePolicy from a JSON file
+func LoadSignaturePolicy(path string) (SignaturePolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return SignaturePolicy{}, err
+	}
+	return ParseSignaturePolicy(data)
+}
+
+// ParseSignaturePolicy parses a JSON encoded SignaturePolicy and the keys and certificates it holds
+func ParseSignaturePolicy(data []byte) (SignaturePolicy, error) {
+	var policy SignaturePolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return SignaturePolicy{}, err
+	}
+
+	keyless := false
+	for i := range policy.Registries {
+		registryPolicy := &policy.Registries[i]
+		if _, err := name.NewRegistry(registryPolicy.Registry); err != nil {
+			return SignaturePolicy{}, fmt.Errorf("invalid registry %q: %w", registryPolicy.Registry, err)
+		}
+		if len(registryPolicy.Keys) == 0 && len(registryPolicy.Identities) == 0 {
+			return SignaturePolicy{}, fmt.Errorf("policy of registry %s has neither keys nor identities", registryPolicy.Registry)
+		}
+
+		for _, key := range registryPolicy.Keys {
+			publicKeys, err := parsePublicKeys([]byte(key))
+			if err != nil {
+				return SignaturePolicy{}, fmt.Errorf("invalid key of registry %s: %w", registryPolicy.Registry, err)
+			}
+			registryPolicy.keys = append(registryPolicy.keys, publicKeys...)
+		}
+
+		for j := range registryPolicy.Identities {
+			identity := &registryPolicy.Identities[j]
+			if identity.Issuer == "" || (identity.Subject == "" && identity.SubjectRegExp == "") {
+				return SignaturePolicy{}, fmt.Errorf("identities of registry %s require an issuer and a subject", registryPolicy.Registry)
+			}
+			if identity.Subject == "" {
+				subjectRegExp, err := regexp.Compile(identity.SubjectRegExp)
+				if err != nil {
+					return SignaturePolicy{}, fmt.Errorf("invalid subject regexp of registry %s: %w", registryPolicy.Registry, err)
+				}
+				identity.subjectRegExp = subjectRegExp
+			}
+			keyless = true
+		}
+	}
+
+	if !keyless {
+		return policy, nil
+	}
+
+	certificates, err := parseCertificates([]byte(policy.FulcioCertificates))
+	if err != nil {
+		return SignaturePolicy{}, fmt.Errorf("invalid Fulcio certificates: %w", err)
+	}
+	policy.fulcioRoots = x509.NewCertPool()
+	policy.fulcioIntermediates = x509.NewCertPool()
+	for _, certificate := range certificates {
+		if bytes.Equal(certificate.RawIssuer, certificate.RawSubject) && certificate.CheckSignatureFrom(certificate) == nil {
+			policy.fulcioRoots.AddCert(certificate)
+		} else {
+			policy.fulcioIntermediates.AddCert(certificate)
+		}
+	}
+	if policy.rekorKeys, err = parsePublicKeys([]byte(policy.RekorPublicKeys)); err != nil {
+		return SignaturePolicy{}, fmt.Errorf("invalid Rekor public keys: %w", err)
+	}
+	if len(certificates) == 0 || len(policy.rekorKeys) == 0 {
+		return SignaturePolicy{}, errors.New("keyless signatures require Fulcio certificates and Rekor public keys")
+	}
+
+	return policy, nil
+}
+
+func (p SignaturePolicy) Enabled() bool {
+	return len(p.Registries) > 0
+}
+
+// Applies tells whether the signature of an image is verified before it is put in cache
+func (p SignaturePolicy) Applies(imageName string) bool {
+	ref, err := name.ParseReference(imageName)
+	if err != nil {
+		return false
+	}
+	return p.registryPolicy(ref) != nil
+}
+
+func (p SignaturePolicy) registryPolicy(ref name.Reference) *RegistrySignaturePolicy {
+	for i, registryPolicy := range p.Registries {
+		if registry, err := name.NewRegistry(registryPolicy.Registry); err == nil && registry.RegistryStr() == ref.Context().RegistryStr() {
+			return &p.Registries[i]
+		}
+	}
+	return nil
+}
+
+// Verify returns a SignatureVerificationError if the manifest of ref with the given digest has no cosign signature
+// verified by the policy of its registry, fetching signatures with options. Images of registries without policy are
+// not verified.
+func (p SignaturePolicy) Verify(ref name.Reference, digest v1.Hash, options ...remote.Option) error {
+	registryPolicy := p.registryPolicy(ref)
+	if registryPolicy == nil {
+		return nil
+	}
+
+	// Signatures are stored in the repository of the image, under a tag derived from the digest of the signed manifest
+	signatureRef := ref.Context().Tag(digest.Algorithm + "-" + digest.Hex + ".sig")
+	signatures, err := remote.Image(signatureRef, options...)
+	if err != nil {
+		if errIsImageNotFound(err) {
+			return &SignatureVerificationError{Image: ref.String(), Reason: "no signature found"}
+		}
+		return err
+	}
+	manifest, err := signatures.Manifest()
+	if err != nil {
+		return err
+	}
+
+	reason := "no signature found"
+	for _, layerDesc := range manifest.Layers {
+		if layerDesc.MediaType != types.MediaType(simpleSigningMediaType) {
+			continue
+		}
+		payload, err := readSignaturePayload(signatures, layerDesc.Digest)
+		if err != nil {
+			return fmt.Errorf("could not read signature %s: %w", layerDesc.Digest, err)
+		}
+		if err := p.verifySignature(registryPolicy, digest, payload, layerDesc.Annotations); err != nil {
+			reason = err.Error()
+			continue
+		}
+		return nil
+	}
+
+	return &SignatureVerificationError{Image: ref.String(), Reason: reason}
+}
+
+// verifySignature verifies a signature layer, i.e. that its payload signs digest and that it has been made with a key
+// or by an identity of the policy
+func (p SignaturePolicy) verifySignature(registryPolicy *RegistrySignaturePolicy, digest v1.Hash, payload []byte, annotations map[string]string) error {
+	var simpleSigning struct {
+		Critical struct {
+			Image struct {
+				DockerManifestDigest string `json:"docker-manifest-digest"`
+			} `json:"image"`
+			Type string `json:"type"`
+		} `json:"critical"`
+	}
+	if err := json.Unmarshal(payload, &simpleSigning); err != nil {
+		return fmt.Errorf("invalid signature payload: %w", err)
+	}
+	if simpleSigning.Critical.Image.DockerManifestDigest != digest.String() {
+		return fmt.Errorf("signature is for manifest %s", simpleSigning.Critical.Image.DockerManifestDigest)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(annotations[signatureAnnotation])
+	if err != nil || len(signature) == 0 {
+		return errors.New("signature annotation is missing or invalid")
+	}
+
+	for _, key := range registryPolicy.keys {
+		if verifyWithKey(key, payload, signature) == nil {
+			return nil
+		}
+	}
+
+	if certificate, ok := annotations[certificateAnnotation]; ok && len(registryPolicy.Identities) > 0 {
+		return p.verifyKeyless(registryPolicy, payload, signature, []byte(certificate), []byte(annotations[chainAnnotation]), []byte(annotations[bundleAnnotation]))
+	}
+
+	return errors.New("signature doesn't match any key of the policy")
+}
+
+// rekorBundle is the proof, signed by Rekor, that a signature has been recorded in its transparency log
+type rekorBundle struct {
+	SignedEntryTimestamp []byte `json:"SignedEntryTimestamp"`
+	Payload              struct {
+		// Fields are ordered like the canonical JSON encoding the SignedEntryTimestamp is the signature of
+		Body           string `json:"body"`
+		IntegratedTime int64  `json:"integratedTime"`
+		LogID          string `json:"logID"`
+		LogIndex       int64  `json:"logIndex"`
+	} `json:"Payload"`
+}
+
+// hashedRekord is the body of a Rekor entry recording a signature
+type hashedRekord struct {
+	Spec struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content   []byte `json:"content"`
+			PublicKey struct {
+				Content []byte `json:"content"`
+			} `json:"publicKey"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+// verifyKeyless verifies a keyless signature: the certificate of the signer must have been issued by Fulcio to an
+// identity of the policy and must have been valid when Rekor recorded the signature
+func (p SignaturePolicy) verifyKeyless(registryPolicy *RegistrySignaturePolicy, payload []byte, signature []byte, certificatePEM []byte, chainPEM []byte, bundleJSON []byte) error {
+	certificates, err := parseCertificates(certificatePEM)
+	if err != nil || len(certificates) != 1 {
+		return errors.New("invalid signing certificate")
+	}
+	certificate := certificates[0]
+
+	if len(bundleJSON) == 0 {
+		return errors.New("keyless signature has no Rekor bundle")
+	}
+	var bundle rekorBundle
+	if err := json.Unmarshal(bundleJSON, &bundle); err != nil {
+		return fmt.Errorf("invalid Rekor bundle: %w", err)
+	}
+	signedPayload, err := json.Marshal(bundle.Payload)
+	if err != nil {
+		return err
+	}
+	verified := false
+	for _, key := range p.rekorKeys {
+		if verifyWithKey(key, signedPayload, bundle.SignedEntryTimestamp) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return errors.New("Rekor bundle is not signed by a Rekor public key of the policy")
+	}
+	if err := verifyRekorEntry(bundle.Payload.Body, payload, signature, certificate); err != nil {
+		return err
+	}
+
+	intermediates := p.fulcioIntermediates.Clone()
+	if chain, err := parseCertificates(chainPEM); err == nil {
+		for _, intermediate := range chain {
+			intermediates.AddCert(intermediate)
+		}
+	}
+	if _, err := certificate.Verify(x509.VerifyOptions{
+		Roots:         p.fulcioRoots,
+		Intermediates: intermediates,
+		CurrentTime:   time.Unix(bundle.Payload.IntegratedTime, 0),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return fmt.Errorf("signing certificate is not trusted: %w", err)
+	}
+
+	if !registryPolicy.allowsIdentity(certificate) {
+		return fmt.Errorf("signer %s is not allowed", certificateIdentity(certificate))
+	}
+
+	return verifyWithKey(certificate.PublicKey, payload, signature)
+}
+
+// verifyRekorEntry checks that the body of a Rekor entry records the given signature of payload, made with certificate
+func verifyRekorEntry(body string, payload []byte, signature []byte, certificate *x509.Certificate) error {
+	data, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return fmt.Errorf("invalid Rekor entry: %w", err)
+	}
+	var entry hashedRekord
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return fmt.Errorf("invalid Rekor entry: %w", err)
+	}
+
+	payloadHash := sha256.Sum256(payload)
+	if entry.Spec.Data.Hash.Algorithm != "sha256" || entry.Spec.Data.Hash.Value != hex.EncodeToString(payloadHash[:]) {
+		return errors.New("Rekor entry doesn't record the signed payload")
+	}
+	if !bytes.Equal(entry.Spec.Signature.Content, signature) {
+		return errors.New("Rekor entry doesn't record the signature")
+	}
+	recorded, err := parseCertificates(entry.Spec.Signature.PublicKey.Content)
+	if err != nil || len(recorded) != 1 || !recorded[0].Equal(certificate) {
+		return errors.New("Rekor entry doesn't record the signing certificate")
+	}
+
+	return nil
+}
+
+func (p *RegistrySignaturePolicy) allowsIdentity(certificate *x509.Certificate) bool {
+	issuer := certificateIssuer(certificate)
+	subjects := certificate.EmailAddresses
+	for _, uri := range certificate.URIs {
+		subjects = append(subjects, uri.String())
+	}
+
+	for _, identity := range p.Identities {
+		if identity.Issuer != issuer {
+			continue
+		}
+		for _, subject := range subjects {
+			if subject == identity.Subject || (identity.subjectRegExp != nil && identity.subjectRegExp.MatchString(subject)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// certificateIssuer returns the OIDC issuer of the identity certified by a Fulcio certificate
+func certificateIssuer(certificate *x509.Certificate) string {
+	for _, extension := range certificate.Extensions {
+		if extension.Id.Equal(fulcioIssuerV2OID) {
+			var issuer string
+			if _, err := asn1.Unmarshal(extension.Value, &issuer); err == nil {
+				return issuer
+			}
+		}
+	}
+	for _, extension := range certificate.Extensions {
+		if extension.Id.Equal(fulcioIssuerV1OID) {
+			return string(extension.Value)
+		}
+	}
+	return ""
+}
+
+func certificateIdentity(certificate *x509.Certificate) string {
+	subjects := certificate.EmailAddresses
+	for _, uri := range certificate.URIs {
+		subjects = append(subjects, uri.String())
+	}
+	return fmt.Sprintf("%s (issuer %s)", strings.Join(subjects, ", "), certificateIssuer(certificate))
+}
+
+// verifyWithKey verifies the signature of a message with an ECDSA, RSA or Ed25519 public key, ECDSA and RSA signatures
+// being made on the SHA-256 digest of the message whatever the size of the key, like cosign does
+func verifyWithKey(key crypto.PublicKey, message []byte, signature []byte) error {
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(message)
+		if !ecdsa.VerifyASN1(key, digest[:], signature) {
+			return errors.New("invalid ECDSA signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(message)
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil {
+			return nil
+		}
+		return rsa.VerifyPSS(key, crypto.SHA256, digest[:], signature, nil)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, message, signature) {
+			return errors.New("invalid Ed25519 signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+func readSignaturePayload(signatures v1.Image, digest v1.Hash) ([]byte, error) {
+	layer, err := signatures.LayerByDigest(digest)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxSignaturePayloadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSignaturePayloadSize {
+		return nil, &LimitExceededError{Limit: "signature payload size", Value: int64(len(data)), Max: maxSignaturePayloadSize}
+	}
+	return data, nil
+}
+
+// parsePublicKeys parses PEM encoded PKIX public keys
+func parsePublicKeys(data []byte) ([]crypto.PublicKey, error) {
+	keys := []crypto.PublicKey{}
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 && len(bytes.TrimSpace(data)) > 0 {
+		return nil, errors.New("no PEM encoded public key found")
+	}
+	return keys, nil
+}
+
+// parseCertificates parses PEM encoded certificates
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	certificates := []*x509.Certificate{}
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, certificate)
+	}
+	if len(certificates) == 0 && len(bytes.TrimSpace(data)) > 0 {
+		return nil, errors.New("no PEM encoded certificate found")
+	}
+	return certificates, nil
+}
@@ -0,0 +1,380 @@
+package registry
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/enix/kube-image-keeper/pkg/registrytest"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	. "github.com/onsi/gomega"
+)
+
+const githubActionsIssuer = "https://token.actions.githubusercontent.com"
+
+func generateKey(g *WithT) (*ecdsa.PrivateKey, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	g.Expect(err).ToNot(HaveOccurred())
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func sign(g *WithT, key *ecdsa.PrivateKey, message []byte) []byte {
+	digest := sha256.Sum256(message)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	g.Expect(err).ToNot(HaveOccurred())
+	return signature
+}
+
+func signaturePayload(g *WithT, ref name.Reference, digest v1.Hash) []byte {
+	payload, err := json.Marshal(map[string]interface{}{
+		"critical": map[string]interface{}{
+			"identity": map[string]string{"docker-reference": ref.Context().Name()},
+			"image":    map[string]string{"docker-manifest-digest": digest.String()},
+			"type":     "cosign container image signature",
+		},
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	return payload
+}
+
+// pushSignature pushes a cosign signature manifest of the image with the given digest, holding a signature of payload
+// with the given annotations
+func pushSignature(t *testing.T, ref name.Reference, digest v1.Hash, payload []byte, annotations map[string]string) {
+	signatures, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer:       static.NewLayer(payload, simpleSigningMediaType),
+		Annotations: annotations,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(ref.Context().Tag(digest.Algorithm+"-"+digest.Hex+".sig"), signatures); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// keylessSigner issues Fulcio like certificates and records signatures in a Rekor like log
+type keylessSigner struct {
+	root     *x509.Certificate
+	rootKey  *ecdsa.PrivateKey
+	rootPEM  string
+	rekorKey *ecdsa.PrivateKey
+	rekorPEM string
+	issuedAt time.Time
+	// recordedAfter is the delay between the issuance of certificates and the recording of signatures in the log
+	recordedAfter time.Duration
+}
+
+func newKeylessSigner(g *WithT) *keylessSigner {
+	s := &keylessSigner{issuedAt: time.Now().Add(-time.Hour), recordedAfter: time.Minute}
+	s.rootKey, _ = generateKey(g)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sigstore"},
+		NotBefore:             s.issuedAt.Add(-24 * time.Hour),
+		NotAfter:              s.issuedAt.Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, s.rootKey.Public(), s.rootKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	s.root, err = x509.ParseCertificate(der)
+	g.Expect(err).ToNot(HaveOccurred())
+	s.rootPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	s.rekorKey, s.rekorPEM = generateKey(g)
+	return s
+}
+
+// sign returns the annotations of a keyless signature of payload by subject, with a certificate valid for 10 minutes
+// like the ones of Fulcio
+func (s *keylessSigner) sign(g *WithT, payload []byte, subject string, issuer string) map[string]string {
+	signerKey, _ := generateKey(g)
+	subjectURI, err := url.Parse(subject)
+	g.Expect(err).ToNot(HaveOccurred())
+	issuerValue, err := asn1.Marshal(issuer)
+	g.Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		NotBefore:    s.issuedAt,
+		NotAfter:     s.issuedAt.Add(10 * time.Minute),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		URIs:         []*url.URL{subjectURI},
+		ExtraExtensions: []pkix.Extension{
+			{Id: fulcioIssuerV2OID, Value: issuerValue},
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, s.root, signerKey.Public(), s.rootKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	certificatePEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	signature := sign(g, signerKey, payload)
+	payloadHash := sha256.Sum256(payload)
+	var entry hashedRekord
+	entry.Spec.Data.Hash.Algorithm = "sha256"
+	entry.Spec.Data.Hash.Value = hex.EncodeToString(payloadHash[:])
+	entry.Spec.Signature.Content = signature
+	entry.Spec.Signature.PublicKey.Content = certificatePEM
+	body, err := json.Marshal(entry)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	var bundle rekorBundle
+	bundle.Payload.Body = base64.StdEncoding.EncodeToString(body)
+	bundle.Payload.IntegratedTime = s.issuedAt.Add(s.recordedAfter).Unix()
+	bundle.Payload.LogID = "c0d23d6ad406973f9559f3ba2d1ca01f84147d8ffc5b8445c224f98b9591801d"
+	bundle.Payload.LogIndex = 42
+	signedPayload, err := json.Marshal(bundle.Payload)
+	g.Expect(err).ToNot(HaveOccurred())
+	bundle.SignedEntryTimestamp = sign(g, s.rekorKey, signedPayload)
+	bundleJSON, err := json.Marshal(bundle)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	return map[string]string{
+		signatureAnnotation:   base64.StdEncoding.EncodeToString(signature),
+		certificateAnnotation: string(certificatePEM),
+		bundleAnnotation:      string(bundleJSON),
+	}
+}
+
+func TestParseSignaturePolicy(t *testing.T) {
+	g := NewWithT(t)
+	_, publicKey := generateKey(g)
+	signer := newKeylessSigner(g)
+
+	tests := []struct {
+		name    string
+		policy  map[string]interface{}
+		wantErr string
+	}{
+		{
+			name:   "Keys",
+			policy: map[string]interface{}{"registries": []interface{}{map[string]interface{}{"registry": "ghcr.io", "keys": []string{publicKey}}}},
+		},
+		{
+			name:    "Neither keys nor identities",
+			policy:  map[string]interface{}{"registries": []interface{}{map[string]interface{}{"registry": "ghcr.io"}}},
+			wantErr: "policy of registry ghcr.io has neither keys nor identities",
+		},
+		{
+			name:    "Invalid key",
+			policy:  map[string]interface{}{"registries": []interface{}{map[string]interface{}{"registry": "ghcr.io", "keys": []string{"cosign.pub"}}}},
+			wantErr: "invalid key of registry ghcr.io: no PEM encoded public key found",
+		},
+		{
+			name: "Identities",
+			policy: map[string]interface{}{
+				"registries":         []interface{}{map[string]interface{}{"registry": "ghcr.io", "identities": []interface{}{map[string]string{"issuer": githubActionsIssuer, "subjectRegExp": "^https://github.com/enix/"}}}},
+				"fulcioCertificates": signer.rootPEM,
+				"rekorPublicKeys":    signer.rekorPEM,
+			},
+		},
+		{
+			name: "Identities without subject",
+			policy: map[string]interface{}{
+				"registries": []interface{}{map[string]interface{}{"registry": "ghcr.io", "identities": []interface{}{map[string]string{"issuer": githubActionsIssuer}}}},
+			},
+			wantErr: "identities of registry ghcr.io require an issuer and a subject",
+		},
+		{
+			name: "Identities without Rekor public keys",
+			policy: map[string]interface{}{
+				"registries":         []interface{}{map[string]interface{}{"registry": "ghcr.io", "identities": []interface{}{map[string]string{"issuer": githubActionsIssuer, "subject": "ops@example.com"}}}},
+				"fulcioCertificates": signer.rootPEM,
+			},
+			wantErr: "keyless signatures require Fulcio certificates and Rekor public keys",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			data, err := json.Marshal(tt.policy)
+			g.Expect(err).ToNot(HaveOccurred())
+			policy, err := ParseSignaturePolicy(data)
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(tt.wantErr))
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(policy.Applies("ghcr.io/enix/app:v1")).To(BeTrue())
+				g.Expect(policy.Applies("alpine:3.19")).To(BeFalse())
+			}
+		})
+	}
+}
+
+func TestSignaturePolicy_Verify(t *testing.T) {
+	g := NewWithT(t)
+
+	upstream := registrytest.New(t)
+	key, publicKey := generateKey(g)
+	_, otherPublicKey := generateKey(g)
+	signer := newKeylessSigner(g)
+	subject := "https://github.com/enix/app/.github/workflows/release.yml@refs/heads/main"
+
+	push := func(image string) (name.Reference, v1.Hash) {
+		img := registrytest.RandomImage(t, 1)
+		digest, err := img.Digest()
+		g.Expect(err).ToNot(HaveOccurred())
+		return upstream.PushImage(t, image, img), digest
+	}
+	signedRef, signedDigest := push("enix/signed:v1")
+	payload := signaturePayload(g, signedRef, signedDigest)
+	pushSignature(t, signedRef, signedDigest, payload, map[string]string{signatureAnnotation: base64.StdEncoding.EncodeToString(sign(g, key, payload))})
+
+	keylessRef, keylessDigest := push("enix/keyless:v1")
+	payload = signaturePayload(g, keylessRef, keylessDigest)
+	pushSignature(t, keylessRef, keylessDigest, payload, signer.sign(g, payload, subject, githubActionsIssuer))
+
+	otherIssuerRef, otherIssuerDigest := push("enix/other-issuer:v1")
+	payload = signaturePayload(g, otherIssuerRef, otherIssuerDigest)
+	pushSignature(t, otherIssuerRef, otherIssuerDigest, payload, signer.sign(g, payload, subject, "https://accounts.google.com"))
+
+	// A signature of another manifest copied to the signature tag of an image
+	copiedRef, copiedDigest := push("enix/copied:v1")
+	payload = signaturePayload(g, signedRef, signedDigest)
+	pushSignature(t, copiedRef, copiedDigest, payload, map[string]string{signatureAnnotation: base64.StdEncoding.EncodeToString(sign(g, key, payload))})
+
+	unsignedRef, unsignedDigest := push("enix/unsigned:v1")
+
+	policy := func(registryPolicy map[string]interface{}) SignaturePolicy {
+		registryPolicy["registry"] = upstream.Addr()
+		data, err := json.Marshal(map[string]interface{}{
+			"registries":         []interface{}{registryPolicy},
+			"fulcioCertificates": signer.rootPEM,
+			"rekorPublicKeys":    signer.rekorPEM,
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+		policy, err := ParseSignaturePolicy(data)
+		g.Expect(err).ToNot(HaveOccurred())
+		return policy
+	}
+	keys := policy(map[string]interface{}{"keys": []string{otherPublicKey, publicKey}})
+	otherKeys := policy(map[string]interface{}{"keys": []string{otherPublicKey}})
+	identities := policy(map[string]interface{}{"identities": []interface{}{
+		map[string]string{"issuer": githubActionsIssuer, "subjectRegExp": "^https://github.com/enix/"},
+	}})
+	otherIdentities := policy(map[string]interface{}{"identities": []interface{}{
+		map[string]string{"issuer": githubActionsIssuer, "subject": "https://github.com/enix/other/.github/workflows/release.yml@refs/heads/main"},
+	}})
+
+	tests := []struct {
+		name    string
+		policy  SignaturePolicy
+		ref     name.Reference
+		digest  v1.Hash
+		wantErr string
+	}{
+		{name: "Signed with a key of the policy", policy: keys, ref: signedRef, digest: signedDigest},
+		{name: "Signed with another key", policy: otherKeys, ref: signedRef, digest: signedDigest, wantErr: "signature doesn't match any key of the policy"},
+		{name: "Signature of another manifest", policy: keys, ref: copiedRef, digest: copiedDigest, wantErr: "signature is for manifest " + signedDigest.String()},
+		{name: "Not signed", policy: keys, ref: unsignedRef, digest: unsignedDigest, wantErr: "no signature found"},
+		{name: "Signed by an identity of the policy", policy: identities, ref: keylessRef, digest: keylessDigest},
+		{name: "Signed by another identity", policy: otherIdentities, ref: keylessRef, digest: keylessDigest, wantErr: "signer " + subject + " (issuer " + githubActionsIssuer + ") is not allowed"},
+		{name: "Identity of another issuer", policy: identities, ref: otherIssuerRef, digest: otherIssuerDigest, wantErr: "signer " + subject + " (issuer https://accounts.google.com) is not allowed"},
+		{name: "Registry without policy", policy: SignaturePolicy{}, ref: unsignedRef, digest: unsignedDigest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			err := tt.policy.Verify(tt.ref, tt.digest)
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.wantErr)))
+				g.Expect(ClassifyError(err)).To(Equal(FailureSignature))
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+		})
+	}
+}
+
+func Test_verifyWithKey(t *testing.T) {
+	message := []byte("payload")
+
+	// Cosign signs the SHA-256 digest of payloads whatever the curve of the key
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		t.Run(curve.Params().Name, func(t *testing.T) {
+			g := NewWithT(t)
+			key, err := ecdsa.GenerateKey(curve, rand.Reader)
+			g.Expect(err).ToNot(HaveOccurred())
+			der, err := x509.MarshalPKIXPublicKey(key.Public())
+			g.Expect(err).ToNot(HaveOccurred())
+			publicKeys, err := parsePublicKeys(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(publicKeys).To(HaveLen(1))
+
+			g.Expect(verifyWithKey(publicKeys[0], message, sign(g, key, message))).To(Succeed())
+			g.Expect(verifyWithKey(publicKeys[0], []byte("other payload"), sign(g, key, message))).ToNot(Succeed())
+		})
+	}
+}
+
+func TestSignaturePolicy_verifyKeyless(t *testing.T) {
+	g := NewWithT(t)
+
+	signer := newKeylessSigner(g)
+	subject := "https://github.com/enix/app/.github/workflows/release.yml@refs/heads/main"
+	data, err := json.Marshal(map[string]interface{}{
+		"registries": []interface{}{map[string]interface{}{
+			"registry":   "ghcr.io",
+			"identities": []interface{}{map[string]string{"issuer": githubActionsIssuer, "subject": subject}},
+		}},
+		"fulcioCertificates": signer.rootPEM,
+		"rekorPublicKeys":    signer.rekorPEM,
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	policy, err := ParseSignaturePolicy(data)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	payload := []byte(`{"critical":{}}`)
+	verify := func(annotations map[string]string) error {
+		signature, err := base64.StdEncoding.DecodeString(annotations[signatureAnnotation])
+		g.Expect(err).ToNot(HaveOccurred())
+		return policy.verifyKeyless(&policy.Registries[0], payload, signature, []byte(annotations[certificateAnnotation]), nil, []byte(annotations[bundleAnnotation]))
+	}
+
+	g.Expect(verify(signer.sign(g, payload, subject, githubActionsIssuer))).To(Succeed())
+
+	// Bundles must be signed by Rekor
+	annotations := signer.sign(g, payload, subject, githubActionsIssuer)
+	var bundle rekorBundle
+	g.Expect(json.Unmarshal([]byte(annotations[bundleAnnotation]), &bundle)).To(Succeed())
+	bundle.Payload.IntegratedTime = signer.issuedAt.Add(time.Hour).Unix()
+	tampered, err := json.Marshal(bundle)
+	g.Expect(err).ToNot(HaveOccurred())
+	annotations[bundleAnnotation] = string(tampered)
+	g.Expect(verify(annotations)).To(MatchError("Rekor bundle is not signed by a Rekor public key of the policy"))
+
+	// Signatures missing from Rekor are not trusted
+	annotations = signer.sign(g, payload, subject, githubActionsIssuer)
+	delete(annotations, bundleAnnotation)
+	g.Expect(verify(annotations)).To(MatchError("keyless signature has no Rekor bundle"))
+
+	// The certificate must have been valid when the signature has been recorded in Rekor
+	signer.recordedAfter = time.Hour
+	g.Expect(verify(signer.sign(g, payload, subject, githubActionsIssuer))).To(MatchError(ContainSubstring("signing certificate is not trusted")))
+
+	// Certificates issued by another certificate authority are not trusted
+	otherSigner := newKeylessSigner(g)
+	otherSigner.rekorKey = signer.rekorKey
+	g.Expect(verify(otherSigner.sign(g, payload, subject, githubActionsIssuer))).To(MatchError(ContainSubstring("signing certificate is not trusted")))
+}
@@ -0,0 +1,110 @@
+package registry
+
+import (
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// ImageStats are the digest, the size and the number of layers of an image stored in cache
+type ImageStats struct {
+	// Digest is the digest of the manifest of the image, the one of its index for multi-arch images
+	Digest v1.Hash
+	// Size is the size of every blob of the image, including blobs shared with other images
+	Size int64
+	// Layers is the number of distinct layers of every platform of the image
+	Layers int
+}
+
+// ImageBlobs returns the size of every blob stored in cache for an image, by digest: manifests, configs and layers of
+// every platform of the image. Blobs shared with other images are stored only once by the registry.
+func ImageBlobs(imageName string) (map[v1.Hash]int64, error) {
+	_, blobs, _, err := imageContents(imageName)
+	return blobs, err
+}
+
+// GetImageStats returns the digest, the size and the number of layers of an image stored in cache
+func GetImageStats(imageName string) (ImageStats, error) {
+	desc, blobs, layers, err := imageContents(imageName)
+	if err != nil {
+		return ImageStats{}, err
+	}
+
+	stats := ImageStats{Digest: desc.Digest, Layers: len(layers)}
+	for _, size := range blobs {
+		stats.Size += size
+	}
+
+	return stats, nil
+}
+
+// imageContents returns the descriptor of an image stored in cache, the size of its blobs by digest and its layers
+func imageContents(imageName string) (*remote.Descriptor, map[v1.Hash]int64, map[v1.Hash]bool, error) {
+	ref, err := parseLocalReference(imageName)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	desc, err := remote.Get(ref, cacheOptions()...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	blobs := map[v1.Hash]int64{desc.Digest: desc.Size}
+	layers := map[v1.Hash]bool{}
+	if !desc.MediaType.IsIndex() {
+		image, err := desc.Image()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return desc, blobs, layers, addImageBlobs(blobs, layers, image)
+	}
+
+	if err := addIndexBlobs(ref, desc, blobs, layers); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return desc, blobs, layers, nil
+}
+
+func addIndexBlobs(ref name.Reference, desc *remote.Descriptor, blobs map[v1.Hash]int64, layers map[v1.Hash]bool) error {
+	index, err := desc.ImageIndex()
+	if err != nil {
+		return err
+	}
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range indexManifest.Manifests {
+		if !manifest.MediaType.IsImage() {
+			continue
+		}
+		image, err := remote.Image(ref.Context().Digest(manifest.Digest.String()), cacheOptions()...)
+		if err != nil {
+			return err
+		}
+		blobs[manifest.Digest] = manifest.Size
+		if err := addImageBlobs(blobs, layers, image); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func addImageBlobs(blobs map[v1.Hash]int64, layers map[v1.Hash]bool, image v1.Image) error {
+	manifest, err := image.Manifest()
+	if err != nil {
+		return err
+	}
+
+	blobs[manifest.Config.Digest] = manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		blobs[layer.Digest] = layer.Size
+		layers[layer.Digest] = true
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/enix/kube-image-keeper/pkg/registrytest"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/onsi/gomega"
+)
+
+func TestImageBlobs(t *testing.T) {
+	g := NewWithT(t)
+
+	cache := registrytest.New(t)
+	Endpoint = cache.Addr()
+
+	image := registrytest.RandomImage(t, 2)
+	ref, err := parseLocalReference("alpine:3.19")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(remote.Write(ref, image)).To(Succeed())
+
+	blobs, err := ImageBlobs("alpine:3.19")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(blobs).To(HaveLen(4)) // manifest, config and 2 layers
+	digest, err := image.Digest()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(blobs).To(HaveKey(digest))
+
+	index := registrytest.RandomIndex(t, 2, 1)
+	ref, err = parseLocalReference("nginx:1.25")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(remote.WriteIndex(ref, index)).To(Succeed())
+
+	blobs, err = ImageBlobs("nginx:1.25")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(blobs).To(HaveLen(7)) // index, 2 manifests, 2 configs and 2 layers
+
+	_, err = ImageBlobs("redis:7")
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestGetImageStats(t *testing.T) {
+	g := NewWithT(t)
+
+	cache := registrytest.New(t)
+	Endpoint = cache.Addr()
+
+	index := registrytest.RandomIndex(t, 2, 1)
+	ref, err := parseLocalReference("nginx:1.25")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(remote.WriteIndex(ref, index)).To(Succeed())
+
+	blobs, err := ImageBlobs("nginx:1.25")
+	g.Expect(err).ToNot(HaveOccurred())
+	size := int64(0)
+	for _, blobSize := range blobs {
+		size += blobSize
+	}
+
+	stats, err := GetImageStats("nginx:1.25")
+	g.Expect(err).ToNot(HaveOccurred())
+	digest, err := index.Digest()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(stats.Digest).To(Equal(digest))
+	g.Expect(stats.Size).To(Equal(size))
+	g.Expect(stats.Layers).To(Equal(2))
+
+	_, err = GetImageStats("redis:7")
+	g.Expect(err).To(HaveOccurred())
+}
@@ -0,0 +1,64 @@
+package registry
+
+import (
+	"errors"
+	"io"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+var errBlobAlreadyRead = errors.New("streamed blob can only be read once")
+
+// streamedBlob is a blob of known digest whose content can only be read once, e.g. while it is served to a client
+type streamedBlob struct {
+	digest v1.Hash
+	size   int64
+	reader io.ReadCloser
+}
+
+func (b *streamedBlob) Digest() (v1.Hash, error) {
+	return b.digest, nil
+}
+
+func (b *streamedBlob) DiffID() (v1.Hash, error) {
+	return v1.Hash{}, errors.New("diff ID of a streamed blob is unknown")
+}
+
+func (b *streamedBlob) Compressed() (io.ReadCloser, error) {
+	if b.reader == nil {
+		return nil, errBlobAlreadyRead
+	}
+	reader := b.reader
+	b.reader = nil
+	return reader, nil
+}
+
+func (b *streamedBlob) Uncompressed() (io.ReadCloser, error) {
+	return nil, errors.New("streamed blobs can't be uncompressed")
+}
+
+func (b *streamedBlob) Size() (int64, error) {
+	return b.size, nil
+}
+
+func (b *streamedBlob) MediaType() (types.MediaType, error) {
+	return types.DockerLayer, nil
+}
+
+// PushBlob pushes a blob to a repository of the cache registry, e.g. docker.io/library/nginx, reading its content from
+// reader. Nothing is read if the blob is already in cache. The registry checks the digest of the blob before storing it.
+func PushBlob(repository string, digest string, size int64, reader io.ReadCloser) error {
+	repo, err := name.NewRepository(Endpoint+"/"+repository, cacheNameOptions()...)
+	if err != nil {
+		return err
+	}
+	hash, err := v1.NewHash(digest)
+	if err != nil {
+		return err
+	}
+
+	return remote.WriteLayer(repo, &streamedBlob{digest: hash, size: size, reader: reader}, cacheOptions()...)
+}
@@ -0,0 +1,144 @@
+package registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/enix/kube-image-keeper/internal/tlsconfig"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// Files of the certificates used to connect to the cache registry with mutual TLS, as issued by the controllers
+const (
+	TLSCACertFile     = "ca.crt"
+	TLSClientCertFile = "client.crt"
+	TLSClientKeyFile  = "client.key"
+)
+
+// CacheTransport is the transport of requests to the cache registry, the default transport if nil
+var CacheTransport http.RoundTripper
+
+// clientCertificates holds the client certificate and the certificate authorities used to connect to the cache
+// registry, read again from their files whenever they change so that rotated certificates are used without restarting
+type clientCertificates struct {
+	caFile, certFile, keyFile string
+
+	mutex       sync.Mutex
+	modTimes    [3]time.Time
+	certificate *tls.Certificate
+	roots       *x509.CertPool
+}
+
+func (c *clientCertificates) load() (*tls.Certificate, *x509.CertPool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	modTimes := [3]time.Time{}
+	for i, file := range []string{c.caFile, c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	if c.certificate != nil && modTimes == c.modTimes {
+		return c.certificate, c.roots, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	caCertificates, err := os.ReadFile(c.caFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caCertificates) {
+		return nil, nil, fmt.Errorf("no certificate authority found in %s", c.caFile)
+	}
+
+	c.modTimes = modTimes
+	c.certificate = &certificate
+	c.roots = roots
+	return c.certificate, c.roots, nil
+}
+
+// verifyConnection verifies the certificate of the cache registry against the current certificate authorities
+func (c *clientCertificates) verifyConnection(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("cache registry presented no certificate")
+	}
+	_, roots, err := c.load()
+	if err != nil {
+		return err
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, certificate := range state.PeerCertificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+	_, err = state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       state.ServerName,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// ConfigureClientTLS makes requests to the cache registry use mutual TLS with the certificates of dir. Certificates are
+// read on each connection since they may not be issued yet when it is configured.
+func ConfigureClientTLS(dir string) {
+	certificates := &clientCertificates{
+		caFile:   filepath.Join(dir, TLSCACertFile),
+		certFile: filepath.Join(dir, TLSClientCertFile),
+		keyFile:  filepath.Join(dir, TLSClientKeyFile),
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsconfig.New()
+	// The certificate of the registry is verified by VerifyConnection, against certificate authorities that may rotate
+	transport.TLSClientConfig.InsecureSkipVerify = true
+	transport.TLSClientConfig.VerifyConnection = certificates.verifyConnection
+	transport.TLSClientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		certificate, _, err := certificates.load()
+		return certificate, err
+	}
+
+	Protocol = "https://"
+	CacheTransport = transport
+	healthClient.Transport = transport
+}
+
+// CacheHTTPClient returns an HTTP client of the cache registry
+func CacheHTTPClient() *http.Client {
+	if CacheTransport == nil {
+		return http.DefaultClient
+	}
+	return &http.Client{Transport: CacheTransport}
+}
+
+// cacheOptions are the options of requests to the cache registry
+func cacheOptions() []remote.Option {
+	if CacheTransport == nil {
+		return nil
+	}
+	return []remote.Option{remote.WithTransport(CacheTransport)}
+}
+
+// cacheNameOptions are the options of references to images of the cache registry, which is reached over plain HTTP
+// unless TLS is configured
+func cacheNameOptions() []name.Option {
+	if Protocol == "https://" {
+		return nil
+	}
+	return []name.Option{name.Insecure}
+}
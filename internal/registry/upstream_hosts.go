@@ -0,0 +1,136 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// UpstreamResolver resolves the names of upstream registries, the resolver of the system being used if nil
+var UpstreamResolver *net.Resolver
+
+// UpstreamHosts are the addresses upstream registries are reached at instead of the ones their name resolves to, per
+// repository
+var UpstreamHosts = NewHostOverrides()
+
+// HostOverrides maps the names of Repository objects to the address, an IP or a host name optionally followed by a
+// port, their upstream registry is dialed at. TLS certificates are still verified against the name of the registry.
+type HostOverrides struct {
+	mu        sync.RWMutex
+	addresses map[string]string
+}
+
+func NewHostOverrides() *HostOverrides {
+	return &HostOverrides{addresses: map[string]string{}}
+}
+
+// Set overrides the address of the upstream registry of a Repository, an empty address removing the override
+func (h *HostOverrides) Set(repositoryName string, address string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if address == "" {
+		delete(h.addresses, repositoryName)
+	} else {
+		h.addresses[repositoryName] = address
+	}
+}
+
+// Replace overrides the addresses of the upstream registries of every Repository at once
+func (h *HostOverrides) Replace(addresses map[string]string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.addresses = map[string]string{}
+	for repositoryName, address := range addresses {
+		if address != "" {
+			h.addresses[repositoryName] = address
+		}
+	}
+}
+
+// Address returns the address the upstream registry of repository is dialed at, or an empty string if it isn't
+// overridden
+func (h *HostOverrides) Address(repository name.Repository) string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.addresses[SanitizeName(normalizedRepositoryName(repository))]
+}
+
+// normalizedRepositoryName returns the name of a repository the way Repository objects are named after it, i.e. with
+// docker.io as the registry of Docker Hub images
+func normalizedRepositoryName(repository name.Repository) string {
+	if repository.RegistryStr() == name.DefaultRegistry {
+		return "docker.io/" + repository.RepositoryStr()
+	}
+	return repository.Name()
+}
+
+// NewResolver returns a resolver querying the given DNS servers, given as host[:port], in turn until one answers
+func NewResolver(servers []string) *net.Resolver {
+	addresses := make([]string, len(servers))
+	for i, server := range servers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+		}
+		addresses[i] = server
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			dialer := net.Dialer{Timeout: 5 * time.Second}
+			var err error
+			for _, address := range addresses {
+				var conn net.Conn
+				if conn, err = dialer.DialContext(ctx, network, address); err == nil {
+					return conn, nil
+				}
+			}
+			return nil, err
+		},
+	}
+}
+
+// UpstreamDialContext returns the function dialing the upstream registry of repository, at the address it is
+// overridden with if any, names being resolved with UpstreamResolver. Other hosts, e.g. the storage blobs are
+// redirected to, are dialed as usual.
+func UpstreamDialContext(repository name.Repository) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Resolver:  UpstreamResolver,
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if override := UpstreamHosts.Address(repository); override != "" {
+			if host, port, err := net.SplitHostPort(addr); err == nil && isRegistryHost(repository.Registry, host) {
+				addr = overrideAddress(override, port)
+			}
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
+
+// isRegistryHost tells whether host is the one of a registry, whose name may include a port
+func isRegistryHost(registry name.Registry, host string) bool {
+	registryHost := registry.RegistryStr()
+	if h, _, err := net.SplitHostPort(registryHost); err == nil {
+		registryHost = h
+	}
+	return host == registryHost
+}
+
+// overrideAddress returns the address to dial for an override, keeping the port of the original address if the
+// override has none
+func overrideAddress(override string, port string) string {
+	if _, _, err := net.SplitHostPort(override); err == nil {
+		return override
+	}
+	return net.JoinHostPort(strings.Trim(override, "[]"), port)
+}
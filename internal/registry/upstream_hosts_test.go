@@ -0,0 +1,70 @@
+package registry
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	. "github.com/onsi/gomega"
+)
+
+func TestHostOverrides_Address(t *testing.T) {
+	g := NewWithT(t)
+	overrides := NewHostOverrides()
+
+	dockerHub, err := name.NewRepository("nginx")
+	g.Expect(err).To(BeNil())
+	private, err := name.NewRepository("registry.example.com/team/app")
+	g.Expect(err).To(BeNil())
+
+	overrides.Set("docker.io-library-nginx", "10.0.0.1")
+	overrides.Set("registry.example.com-team-app", "10.0.0.2:5000")
+	g.Expect(overrides.Address(dockerHub)).To(Equal("10.0.0.1"))
+	g.Expect(overrides.Address(private)).To(Equal("10.0.0.2:5000"))
+
+	overrides.Set("docker.io-library-nginx", "")
+	g.Expect(overrides.Address(dockerHub)).To(BeEmpty())
+
+	overrides.Replace(map[string]string{"docker.io-library-nginx": "10.0.0.3", "registry.example.com-team-app": ""})
+	g.Expect(overrides.Address(dockerHub)).To(Equal("10.0.0.3"))
+	g.Expect(overrides.Address(private)).To(BeEmpty())
+}
+
+func TestUpstreamDialContext(t *testing.T) {
+	g := NewWithT(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Host", r.Host)
+	}))
+	defer server.Close()
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	g.Expect(err).To(BeNil())
+
+	defer func(hosts *HostOverrides) { UpstreamHosts = hosts }(UpstreamHosts)
+	UpstreamHosts = NewHostOverrides()
+	UpstreamHosts.Set("registry.invalid-team-app", "127.0.0.1")
+
+	repository, err := name.NewRepository("registry.invalid/team/app")
+	g.Expect(err).To(BeNil())
+	client := &http.Client{Transport: &http.Transport{DialContext: UpstreamDialContext(repository)}}
+
+	response, err := client.Get("http://registry.invalid:" + port + "/v2/")
+	g.Expect(err).To(BeNil())
+	response.Body.Close()
+	g.Expect(response.Header.Get("X-Host")).To(Equal("registry.invalid:" + port))
+
+	// Other hosts, e.g. the storage blobs are redirected to, are not overridden
+	_, err = client.Get("http://storage.invalid:" + port + "/blob")
+	g.Expect(err).ToNot(BeNil())
+}
+
+func TestOverrideAddress(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(overrideAddress("10.0.0.1", "443")).To(Equal("10.0.0.1:443"))
+	g.Expect(overrideAddress("10.0.0.1:5000", "443")).To(Equal("10.0.0.1:5000"))
+	g.Expect(overrideAddress("registry.internal", "443")).To(Equal("registry.internal:443"))
+	g.Expect(overrideAddress("[fd00::1]", "443")).To(Equal("[fd00::1]:443"))
+}
@@ -0,0 +1,180 @@
+package restore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	"github.com/enix/kube-image-keeper/controllers"
+	"github.com/enix/kube-image-keeper/internal/registry"
+)
+
+// Result describes what has been restored for a pod
+type Result struct {
+	// Pod is the list of containers of the pod that have been restored
+	Pod []string
+	// Owner is the kind and name of the owner of the pod, empty if the pod has no supported owner
+	Owner string
+	// OwnerContainers is the list of containers of the pod template of the owner that have been restored
+	OwnerContainers []string
+	// DroppedImagePullSecrets is the list of image pull secrets removed from the pod by the webhook, which can't be
+	// restored since they can't be changed once the pod is created: the pod must be recreated to get them back
+	DroppedImagePullSecrets []string
+}
+
+// RestorePodSpec sets back the original images of the containers of a pod spec, and the ones held by their environment
+// variables or other fields, as stored in the annotations of the given pod by the webhook, and returns the names of the
+// containers that have been changed.
+func RestorePodSpec(spec *corev1.PodSpec, pod *corev1.Pod) []string {
+	restored := restoreContainers(spec.Containers, pod.Annotations, false, true)
+	return append(restored, restoreContainers(spec.InitContainers, pod.Annotations, true, true)...)
+}
+
+// restorePodImages is like RestorePodSpec for the spec of a running pod, whose environment variables and other fields
+// can't be changed
+func restorePodImages(pod *corev1.Pod) []string {
+	restored := restoreContainers(pod.Spec.Containers, pod.Annotations, false, false)
+	return append(restored, restoreContainers(pod.Spec.InitContainers, pod.Annotations, true, false)...)
+}
+
+func restoreContainers(containers []corev1.Container, annotations map[string]string, initContainer bool, restoreEnv bool) []string {
+	restored := []string{}
+	for i := range containers {
+		container := &containers[i]
+		changed := false
+		originalImage, ok := annotations[registry.ContainerAnnotationKey(container.Name, initContainer)]
+		if ok && originalImage != "" && originalImage != container.Image {
+			container.Image = originalImage
+			changed = true
+		}
+		for j := range container.Env {
+			env := &container.Env[j]
+			originalImage, ok := annotations[registry.EnvAnnotationKey(container.Name, env.Name, initContainer)]
+			if restoreEnv && ok && originalImage != "" && originalImage != env.Value {
+				env.Value = originalImage
+				changed = true
+			}
+		}
+		if restoreEnv && restoreFields(container, annotations, initContainer) {
+			changed = true
+		}
+		if changed {
+			restored = append(restored, container.Name)
+		}
+	}
+	return restored
+}
+
+// restoreFields sets back the original images held by the fields of a container selected by the image paths annotation
+// and returns whether the container has been changed
+func restoreFields(container *corev1.Container, annotations map[string]string, initContainer bool) bool {
+	fields, err := controllers.ContainerImageFields(container, annotations[controllers.AnnotationImagePathsName])
+	if err != nil {
+		return false
+	}
+
+	values := map[string]string{}
+	for _, field := range fields {
+		originalImage, ok := annotations[registry.FieldAnnotationKey(container.Name, field.Location, initContainer)]
+		if ok && originalImage != "" && originalImage != field.Value {
+			values[field.Location] = originalImage
+		}
+	}
+	controllers.SetContainerFields(container, values)
+	return len(values) > 0
+}
+
+// Pod restores the original images of a pod and of the pod template of its owner if it references rewritten images.
+// The pod is annotated so that the webhook doesn't rewrite its images again.
+func Pod(ctx context.Context, k8sClient client.Client, pod *corev1.Pod, dryRun bool) (*Result, error) {
+	result := &Result{}
+
+	owner, template, err := getOwner(ctx, k8sClient, pod)
+	if err != nil {
+		return nil, err
+	}
+
+	if owner != nil {
+		gvk, err := apiutil.GVKForObject(owner, k8sClient.Scheme())
+		if err != nil {
+			return nil, err
+		}
+
+		patch := client.MergeFrom(owner.DeepCopyObject().(client.Object))
+		result.Owner = fmt.Sprintf("%s/%s", strings.ToLower(gvk.Kind), owner.GetName())
+		result.OwnerContainers = RestorePodSpec(&template.Spec, pod)
+		if len(result.OwnerContainers) > 0 && !dryRun {
+			if err := k8sClient.Patch(ctx, owner, patch); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	patch := client.MergeFrom(pod.DeepCopy())
+	result.Pod = restorePodImages(pod)
+	if len(result.Pod) > 0 {
+		result.DroppedImagePullSecrets = controllers.DroppedImagePullSecretNames(pod)
+	}
+	if len(result.Pod) > 0 && !dryRun {
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[controllers.AnnotationRewriteImagesName] = "false"
+		if err := k8sClient.Patch(ctx, pod, patch); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// getOwner returns the top level controller of a pod and its pod template, following ReplicaSets up to Deployments
+func getOwner(ctx context.Context, k8sClient client.Client, pod *corev1.Pod) (client.Object, *corev1.PodTemplateSpec, error) {
+	var owner client.Object
+	var template *corev1.PodTemplateSpec
+
+	ownerRef := metav1.GetControllerOf(pod)
+	for ownerRef != nil {
+		key := types.NamespacedName{Namespace: pod.Namespace, Name: ownerRef.Name}
+		var next *metav1.OwnerReference
+
+		switch ownerRef.Kind {
+		case "ReplicaSet":
+			replicaSet := &appsv1.ReplicaSet{}
+			if err := k8sClient.Get(ctx, key, replicaSet); err != nil {
+				return nil, nil, err
+			}
+			owner, template = replicaSet, &replicaSet.Spec.Template
+			next = metav1.GetControllerOf(replicaSet)
+		case "Deployment":
+			deployment := &appsv1.Deployment{}
+			if err := k8sClient.Get(ctx, key, deployment); err != nil {
+				return nil, nil, err
+			}
+			owner, template = deployment, &deployment.Spec.Template
+		case "StatefulSet":
+			statefulSet := &appsv1.StatefulSet{}
+			if err := k8sClient.Get(ctx, key, statefulSet); err != nil {
+				return nil, nil, err
+			}
+			owner, template = statefulSet, &statefulSet.Spec.Template
+		case "DaemonSet":
+			daemonSet := &appsv1.DaemonSet{}
+			if err := k8sClient.Get(ctx, key, daemonSet); err != nil {
+				return nil, nil, err
+			}
+			owner, template = daemonSet, &daemonSet.Spec.Template
+		}
+
+		ownerRef = next
+	}
+
+	return owner, template, nil
+}
@@ -0,0 +1,184 @@
+package restore
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/enix/kube-image-keeper/controllers"
+	"github.com/enix/kube-image-keeper/internal/registry"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+)
+
+var rewrittenPodSpec = corev1.PodSpec{
+	InitContainers: []corev1.Container{
+		{Name: "init", Image: "localhost:7439/busybox"},
+	},
+	Containers: []corev1.Container{
+		{Name: "app", Image: "localhost:7439/nginx:1.25"},
+		{Name: "ignored", Image: "alpine"},
+	},
+}
+
+func newPod(ownerReferences ...metav1.OwnerReference) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod",
+			Namespace: "default",
+			Annotations: map[string]string{
+				registry.ContainerAnnotationKey("init", true): "busybox",
+				registry.ContainerAnnotationKey("app", false): "nginx:1.25",
+				controllers.AnnotationRewriteImagesName:       "true",
+			},
+			OwnerReferences: ownerReferences,
+		},
+		Spec: *rewrittenPodSpec.DeepCopy(),
+	}
+}
+
+func TestRestorePodSpec(t *testing.T) {
+	g := NewWithT(t)
+
+	pod := newPod()
+	restored := RestorePodSpec(&pod.Spec, pod)
+	g.Expect(restored).To(Equal([]string{"app", "init"}))
+	g.Expect(pod.Spec.Containers[0].Image).To(Equal("nginx:1.25"))
+	g.Expect(pod.Spec.Containers[1].Image).To(Equal("alpine"))
+	g.Expect(pod.Spec.InitContainers[0].Image).To(Equal("busybox"))
+
+	g.Expect(RestorePodSpec(&pod.Spec, pod)).To(BeEmpty())
+}
+
+func TestRestorePodSpecEnvVars(t *testing.T) {
+	g := NewWithT(t)
+
+	pod := newPod()
+	pod.Annotations[registry.EnvAnnotationKey("app", "HELPER_IMAGE", false)] = "alpine:3.19"
+	pod.Spec.Containers[0].Env = []corev1.EnvVar{
+		{Name: "HELPER_IMAGE", Value: "localhost:7439/alpine:3.19"},
+		{Name: "OTHER", Value: "value"},
+	}
+	spec := pod.Spec.DeepCopy()
+
+	g.Expect(restorePodImages(pod)).To(Equal([]string{"app", "init"}))
+	g.Expect(pod.Spec.Containers[0].Env[0].Value).To(Equal("localhost:7439/alpine:3.19"))
+
+	g.Expect(RestorePodSpec(spec, pod)).To(Equal([]string{"app", "init"}))
+	g.Expect(spec.Containers[0].Env[0].Value).To(Equal("alpine:3.19"))
+	g.Expect(spec.Containers[0].Env[1].Value).To(Equal("value"))
+}
+
+func TestRestorePodSpecFields(t *testing.T) {
+	g := NewWithT(t)
+
+	pod := newPod()
+	pod.Annotations[controllers.AnnotationImagePathsName] = "{.args[1]}"
+	pod.Annotations[registry.FieldAnnotationKey("app", "args.1", false)] = "alpine:3.19"
+	pod.Spec.Containers[0].Args = []string{"--image", "localhost:7439/alpine:3.19"}
+	spec := pod.Spec.DeepCopy()
+
+	g.Expect(restorePodImages(pod)).To(Equal([]string{"app", "init"}))
+	g.Expect(pod.Spec.Containers[0].Args[1]).To(Equal("localhost:7439/alpine:3.19"))
+
+	g.Expect(RestorePodSpec(spec, pod)).To(Equal([]string{"app", "init"}))
+	g.Expect(spec.Containers[0].Args).To(Equal([]string{"--image", "alpine:3.19"}))
+}
+
+func TestPod(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "deployment", Namespace: "default", UID: "deployment-uid"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{Spec: *rewrittenPodSpec.DeepCopy()},
+		},
+	}
+	replicaSet := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "replicaset",
+			Namespace: "default",
+			UID:       "replicaset-uid",
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "apps/v1", Kind: "Deployment", Name: "deployment", UID: "deployment-uid", Controller: pointer.Bool(true)},
+			},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		pod           *corev1.Pod
+		dryRun        bool
+		expectedOwner string
+		// expectedDroppedImagePullSecrets are set in the annotation of the pod
+		expectedDroppedImagePullSecrets []string
+	}{
+		{
+			name: "Pod without owner",
+			pod:  newPod(),
+		},
+		{
+			name:          "Pod owned by a deployment",
+			pod:           newPod(metav1.OwnerReference{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "replicaset", UID: "replicaset-uid", Controller: pointer.Bool(true)}),
+			expectedOwner: "deployment/deployment",
+		},
+		{
+			name:          "Dry run",
+			pod:           newPod(metav1.OwnerReference{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "replicaset", UID: "replicaset-uid", Controller: pointer.Bool(true)}),
+			dryRun:        true,
+			expectedOwner: "deployment/deployment",
+		},
+		{
+			name:                            "Pod with dropped image pull secrets",
+			pod:                             newPod(),
+			expectedDroppedImagePullSecrets: []string{"docker-hub", "quay"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			if tt.expectedDroppedImagePullSecrets != nil {
+				tt.pod.Annotations[controllers.AnnotationDroppedImagePullSecretsName] = strings.Join(tt.expectedDroppedImagePullSecrets, ",")
+			}
+			k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(tt.pod, deployment.DeepCopy(), replicaSet.DeepCopy()).Build()
+
+			result, err := Pod(context.Background(), k8sClient, tt.pod.DeepCopy(), tt.dryRun)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(result.Pod).To(Equal([]string{"app", "init"}))
+			g.Expect(result.Owner).To(Equal(tt.expectedOwner))
+			if tt.expectedDroppedImagePullSecrets != nil {
+				g.Expect(result.DroppedImagePullSecrets).To(Equal(tt.expectedDroppedImagePullSecrets))
+			} else {
+				g.Expect(result.DroppedImagePullSecrets).To(BeEmpty())
+			}
+
+			var pod corev1.Pod
+			g.Expect(k8sClient.Get(context.Background(), client.ObjectKeyFromObject(tt.pod), &pod)).To(Succeed())
+			var updatedDeployment appsv1.Deployment
+			g.Expect(k8sClient.Get(context.Background(), client.ObjectKeyFromObject(deployment), &updatedDeployment)).To(Succeed())
+
+			if tt.dryRun {
+				g.Expect(pod.Spec.Containers[0].Image).To(Equal("localhost:7439/nginx:1.25"))
+				g.Expect(updatedDeployment.Spec.Template.Spec.Containers[0].Image).To(Equal("localhost:7439/nginx:1.25"))
+				return
+			}
+
+			g.Expect(pod.Spec.Containers[0].Image).To(Equal("nginx:1.25"))
+			g.Expect(pod.Spec.InitContainers[0].Image).To(Equal("busybox"))
+			g.Expect(pod.Annotations[controllers.AnnotationRewriteImagesName]).To(Equal("false"))
+
+			if tt.expectedOwner != "" {
+				g.Expect(result.OwnerContainers).To(Equal([]string{"app", "init"}))
+				g.Expect(updatedDeployment.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.25"))
+			} else {
+				g.Expect(updatedDeployment.Spec.Template.Spec.Containers[0].Image).To(Equal("localhost:7439/nginx:1.25"))
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Severity is the severity of a vulnerability, ordered from the least to the most severe
+type Severity int
+
+const (
+	SeverityUnknown Severity = iota
+	SeverityLow
+	SeverityMedium
+	SeverityHigh
+	SeverityCritical
+)
+
+var severityNames = map[Severity]string{
+	SeverityUnknown:  "UNKNOWN",
+	SeverityLow:      "LOW",
+	SeverityMedium:   "MEDIUM",
+	SeverityHigh:     "HIGH",
+	SeverityCritical: "CRITICAL",
+}
+
+func (s Severity) String() string {
+	return severityNames[s]
+}
+
+// ParseSeverity parses a severity as named by Trivy, e.g. HIGH, case insensitively
+func ParseSeverity(value string) (Severity, error) {
+	for severity, name := range severityNames {
+		if strings.EqualFold(value, name) {
+			return severity, nil
+		}
+	}
+	return SeverityUnknown, fmt.Errorf("invalid severity %q, expected one of UNKNOWN, LOW, MEDIUM, HIGH or CRITICAL", value)
+}
+
+// Summary counts the vulnerabilities found in an image by severity
+type Summary struct {
+	Critical int `json:"critical"`
+	High     int `json:"high"`
+	Medium   int `json:"medium"`
+	Low      int `json:"low"`
+	Unknown  int `json:"unknown"`
+}
+
+// Add counts a vulnerability of the given severity
+func (s *Summary) Add(severity Severity) {
+	switch severity {
+	case SeverityCritical:
+		s.Critical++
+	case SeverityHigh:
+		s.High++
+	case SeverityMedium:
+		s.Medium++
+	case SeverityLow:
+		s.Low++
+	default:
+		s.Unknown++
+	}
+}
+
+// AtLeast returns the number of vulnerabilities of the given severity or more severe
+func (s Summary) AtLeast(severity Severity) int {
+	counts := []int{s.Unknown, s.Low, s.Medium, s.High, s.Critical}
+	total := 0
+	for _, count := range counts[severity:] {
+		total += count
+	}
+	return total
+}
+
+// Image is an image to scan
+type Image struct {
+	// Reference is the reference of the image in the cache registry, pinned to its digest, which is scanned rather
+	// than the upstream image so that scans don't count toward upstream rate limits
+	Reference string `json:"image"`
+	// SourceImage is the upstream image the image has been put in cache from
+	SourceImage string `json:"sourceImage"`
+	Digest      string `json:"digest"`
+}
+
+// Scanner scans images for vulnerabilities
+type Scanner interface {
+	Scan(ctx context.Context, image Image) (*Summary, error)
+}
@@ -0,0 +1,99 @@
+package scan
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestParseSeverity(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(ParseSeverity("critical")).To(Equal(SeverityCritical))
+	g.Expect(ParseSeverity("HIGH")).To(Equal(SeverityHigh))
+	_, err := ParseSeverity("severe")
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestSummary_AtLeast(t *testing.T) {
+	g := NewWithT(t)
+
+	summary := Summary{Critical: 1, High: 2, Medium: 3, Low: 4, Unknown: 5}
+	g.Expect(summary.AtLeast(SeverityCritical)).To(Equal(1))
+	g.Expect(summary.AtLeast(SeverityHigh)).To(Equal(3))
+	g.Expect(summary.AtLeast(SeverityUnknown)).To(Equal(15))
+}
+
+func TestParseTrivyReport(t *testing.T) {
+	g := NewWithT(t)
+
+	summary, err := parseTrivyReport([]byte(`{
+		"Results": [
+			{"Target": "alpine 3.19", "Vulnerabilities": [{"Severity": "CRITICAL"}, {"Severity": "HIGH"}, {"Severity": "HIGH"}]},
+			{"Target": "app", "Vulnerabilities": [{"Severity": "LOW"}, {"Severity": "NEGLIGIBLE"}]},
+			{"Target": "go.sum"}
+		]
+	}`))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(*summary).To(Equal(Summary{Critical: 1, High: 2, Low: 1, Unknown: 1}))
+
+	_, err = parseTrivyReport([]byte("FATAL error"))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestTrivy(t *testing.T) {
+	g := NewWithT(t)
+
+	// Stands for trivy, reporting a vulnerability if the last argument is an exported image
+	path := filepath.Join(t.TempDir(), "trivy")
+	g.Expect(os.WriteFile(path, []byte(`#!/bin/sh
+for arg; do image=$arg; done
+test -f "$image" || { echo "image $image not found" >&2; exit 1; }
+echo '{"Results": [{"Vulnerabilities": [{"Severity": "HIGH"}]}]}'
+`), 0o755)).To(Succeed())
+
+	image := Image{Reference: "kuik-registry:5000/docker.io/library/nginx@sha256:abc", SourceImage: "nginx:1.25", Digest: "sha256:abc"}
+	_, err := (&Trivy{Path: path}).Scan(context.Background(), image)
+	g.Expect(err).To(MatchError(ContainSubstring("image kuik-registry:5000/docker.io/library/nginx@sha256:abc not found")))
+
+	// Images are exported for a cache registry trivy can't reach
+	exported := Image{}
+	trivy := &Trivy{Path: path, Export: func(ctx context.Context, image Image, path string) error {
+		exported = image
+		return os.WriteFile(path, []byte("image"), 0o644)
+	}}
+	summary, err := trivy.Scan(context.Background(), image)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(exported).To(Equal(image))
+	g.Expect(*summary).To(Equal(Summary{High: 1}))
+}
+
+func TestWebhook(t *testing.T) {
+	g := NewWithT(t)
+
+	var received Image
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil || received.SourceImage == "broken:latest" {
+			http.Error(w, "could not scan image", http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(`{"critical": 2, "high": 1}`))
+	}))
+	defer server.Close()
+
+	webhook := &Webhook{URL: server.URL}
+	image := Image{Reference: "kuik-registry:5000/docker.io/library/nginx@sha256:abc", SourceImage: "nginx:1.25", Digest: "sha256:abc"}
+	summary, err := webhook.Scan(context.Background(), image)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(received).To(Equal(image))
+	g.Expect(*summary).To(Equal(Summary{Critical: 2, High: 1}))
+
+	_, err = webhook.Scan(context.Background(), Image{SourceImage: "broken:latest"})
+	g.Expect(err).To(MatchError(ContainSubstring("could not scan image")))
+}
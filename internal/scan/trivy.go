@@ -0,0 +1,82 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Trivy scans images with the trivy command line, see https://trivy.dev
+type Trivy struct {
+	// Path is the path of the trivy binary
+	Path string
+	// Insecure allows Trivy to reach the cache registry over plain HTTP
+	Insecure bool
+	// Export writes images to a tarball at path, which is scanned instead of their reference, for a cache registry
+	// Trivy can't reach, e.g. because it requires client certificates. Images are scanned by reference if nil.
+	Export func(ctx context.Context, image Image, path string) error
+}
+
+// trivyReport is the part of the JSON report of Trivy telling the vulnerabilities found
+type trivyReport struct {
+	Results []struct {
+		Vulnerabilities []struct {
+			Severity string `json:"Severity"`
+		} `json:"Vulnerabilities"`
+	} `json:"Results"`
+}
+
+// Scan implements Scanner
+func (t *Trivy) Scan(ctx context.Context, image Image) (*Summary, error) {
+	args := []string{"image", "--quiet", "--format", "json", "--scanners", "vuln"}
+	if t.Export != nil {
+		dir, err := os.MkdirTemp("", "kuik-scan-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "image.tar")
+		if err := t.Export(ctx, image, path); err != nil {
+			return nil, fmt.Errorf("could not export image to scan: %w", err)
+		}
+		args = append(args, "--input", path)
+	} else {
+		if t.Insecure {
+			args = append(args, "--insecure")
+		}
+		args = append(args, image.Reference)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.Path, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("trivy failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return parseTrivyReport(stdout.Bytes())
+}
+
+// parseTrivyReport summarizes the vulnerabilities of a JSON report of Trivy
+func parseTrivyReport(data []byte) (*Summary, error) {
+	var report trivyReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("invalid trivy report: %w", err)
+	}
+
+	summary := &Summary{}
+	for _, result := range report.Results {
+		for _, vulnerability := range result.Vulnerabilities {
+			severity, _ := ParseSeverity(vulnerability.Severity)
+			summary.Add(severity)
+		}
+	}
+	return summary, nil
+}
@@ -0,0 +1,52 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Webhook scans images by sending them to a scanner webhook, as a JSON Image in the body of a POST request. The
+// webhook answers with a JSON Summary once the image has been scanned.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+// Scan implements Scanner
+func (w *Webhook) Scan(ctx context.Context, image Image) (*Summary, error) {
+	body, err := json.Marshal(image)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("scanner webhook answered %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+
+	summary := &Summary{}
+	if err := json.NewDecoder(resp.Body).Decode(summary); err != nil {
+		return nil, fmt.Errorf("invalid answer of scanner webhook: %w", err)
+	}
+	return summary, nil
+}
@@ -0,0 +1,227 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Format is the format of a snapshot document
+type Format string
+
+const (
+	// SPDX is the JSON serialization of an SPDX 2.3 document, with one package per image
+	SPDX Format = "spdx"
+	// CycloneDX is a CycloneDX 1.5 JSON BOM, with one container component per image
+	CycloneDX Format = "cyclonedx"
+
+	// creator is the tool creating snapshot documents
+	creator = "kube-image-keeper"
+)
+
+func ParseFormat(format string) (Format, error) {
+	switch Format(format) {
+	case SPDX, CycloneDX:
+		return Format(format), nil
+	default:
+		return "", fmt.Errorf("unsupported format %q, use %s or %s", format, SPDX, CycloneDX)
+	}
+}
+
+// ContentType returns the media type of documents of the format
+func (f Format) ContentType() string {
+	if f == CycloneDX {
+		return "application/vnd.cyclonedx+json"
+	}
+	return "application/spdx+json"
+}
+
+// Marshal returns the snapshot as a document of the given format. Pods using an image are listed as annotations of its
+// package in SPDX documents, and as kuik:pod properties of its component in CycloneDX documents.
+func (s *Snapshot) Marshal(format Format) ([]byte, error) {
+	var document any
+	var err error
+	switch format {
+	case SPDX:
+		document, err = s.spdx()
+	case CycloneDX:
+		document, err = s.cycloneDX()
+	default:
+		err = fmt.Errorf("unsupported format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(document, "", "  ")
+}
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string            `json:"name"`
+	SPDXID           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo"`
+	DownloadLocation string            `json:"downloadLocation"`
+	PrimaryPurpose   string            `json:"primaryPackagePurpose"`
+	Checksums        []spdxChecksum    `json:"checksums"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs"`
+	Annotations      []spdxAnnotation  `json:"annotations,omitempty"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxAnnotation struct {
+	AnnotationDate string `json:"annotationDate"`
+	AnnotationType string `json:"annotationType"`
+	Annotator      string `json:"annotator"`
+	Comment        string `json:"comment"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+func (s *Snapshot) spdx() (*spdxDocument, error) {
+	created := s.CreatedAt.Format(time.RFC3339)
+	document := &spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              "kube-image-keeper-images-in-use",
+		DocumentNamespace: "https://github.com/enix/kube-image-keeper/snapshots/" + uuid.NewString(),
+		CreationInfo:      spdxCreationInfo{Created: created, Creators: []string{"Tool: " + creator}},
+		Packages:          []spdxPackage{},
+		Relationships:     []spdxRelationship{},
+	}
+
+	for i, image := range s.Images {
+		purl, err := image.purl()
+		if err != nil {
+			return nil, err
+		}
+
+		id := fmt.Sprintf("SPDXRef-Image-%d", i)
+		pkg := spdxPackage{
+			Name:             image.SourceImage,
+			SPDXID:           id,
+			VersionInfo:      image.Digest.String(),
+			DownloadLocation: "NOASSERTION",
+			PrimaryPurpose:   "CONTAINER",
+			Checksums:        []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: image.Digest.Hex}},
+			ExternalRefs:     []spdxExternalRef{{ReferenceCategory: "PACKAGE-MANAGER", ReferenceType: "purl", ReferenceLocator: purl}},
+		}
+		for _, pod := range image.Pods {
+			pkg.Annotations = append(pkg.Annotations, spdxAnnotation{
+				AnnotationDate: created,
+				AnnotationType: "OTHER",
+				Annotator:      "Tool: " + creator,
+				Comment:        "used by pod " + pod,
+			})
+		}
+
+		document.Packages = append(document.Packages, pkg)
+		document.Relationships = append(document.Relationships, spdxRelationship{
+			SPDXElementID:      document.SPDXID,
+			RelationshipType:   "DESCRIBES",
+			RelatedSPDXElement: id,
+		})
+	}
+
+	return document, nil
+}
+
+type cycloneDXBOM struct {
+	BOMFormat    string               `json:"bomFormat"`
+	SpecVersion  string               `json:"specVersion"`
+	SerialNumber string               `json:"serialNumber"`
+	Version      int                  `json:"version"`
+	Metadata     cycloneDXMetadata    `json:"metadata"`
+	Components   []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXMetadata struct {
+	Timestamp string `json:"timestamp"`
+	Tools     struct {
+		Components []cycloneDXComponent `json:"components"`
+	} `json:"tools"`
+}
+
+type cycloneDXComponent struct {
+	Type       string              `json:"type"`
+	Name       string              `json:"name"`
+	Version    string              `json:"version,omitempty"`
+	PURL       string              `json:"purl,omitempty"`
+	Hashes     []cycloneDXHash     `json:"hashes,omitempty"`
+	Properties []cycloneDXProperty `json:"properties,omitempty"`
+}
+
+type cycloneDXHash struct {
+	Algorithm string `json:"alg"`
+	Content   string `json:"content"`
+}
+
+type cycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func (s *Snapshot) cycloneDX() (*cycloneDXBOM, error) {
+	bom := &cycloneDXBOM{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + uuid.NewString(),
+		Version:      1,
+		Metadata:     cycloneDXMetadata{Timestamp: s.CreatedAt.Format(time.RFC3339)},
+		Components:   []cycloneDXComponent{},
+	}
+	bom.Metadata.Tools.Components = []cycloneDXComponent{{Type: "application", Name: creator}}
+
+	for _, image := range s.Images {
+		purl, err := image.purl()
+		if err != nil {
+			return nil, err
+		}
+
+		component := cycloneDXComponent{
+			Type:    "container",
+			Name:    image.SourceImage,
+			Version: image.Digest.String(),
+			PURL:    purl,
+			Hashes:  []cycloneDXHash{{Algorithm: "SHA-256", Content: image.Digest.Hex}},
+		}
+		for _, pod := range image.Pods {
+			component.Properties = append(component.Properties, cycloneDXProperty{Name: "kuik:pod", Value: pod})
+		}
+		bom.Components = append(bom.Components, component)
+	}
+
+	return bom, nil
+}
@@ -0,0 +1,61 @@
+package snapshot
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// SignatureHeader is the HTTP header holding the base64 encoded signature of snapshot documents
+const SignatureHeader = "X-Kuik-Signature"
+
+// Signer signs snapshot documents with an Ed25519 key, so that auditors can verify them with the public key, e.g. with
+// openssl pkeyutl -verify -rawin
+type Signer struct {
+	key ed25519.PrivateKey
+}
+
+func NewSigner(key ed25519.PrivateKey) *Signer {
+	return &Signer{key: key}
+}
+
+// LoadSigner reads the key of a Signer from a PEM encoded PKCS #8 file, as generated by
+// openssl genpkey -algorithm ed25519
+func LoadSigner(path string) (*Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM encoded key found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ed25519Key, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("snapshot signing key must be an Ed25519 key, got %T", key)
+	}
+
+	return NewSigner(ed25519Key), nil
+}
+
+// Sign returns the signature of a document
+func (s *Signer) Sign(document []byte) []byte {
+	return ed25519.Sign(s.key, document)
+}
+
+// PublicKey returns the PEM encoded public key verifying signatures
+func (s *Signer) PublicKey() ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(s.key.Public())
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
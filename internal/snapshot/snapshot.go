@@ -0,0 +1,87 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"time"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Image is an image stored in cache along with the pods using it
+type Image struct {
+	SourceImage string
+	Digest      v1.Hash
+	// Pods are the pods using the image, as namespace/name
+	Pods []string
+}
+
+// Snapshot is the list of images stored in cache at a given time
+type Snapshot struct {
+	CreatedAt time.Time
+	Images    []Image
+}
+
+// Build lists every image stored in cache, sorted by name, and the pods using them. The digest of each image is read
+// from the cache with imageDigest.
+func Build(ctx context.Context, k8sClient client.Client, imageDigest func(string) (v1.Hash, error)) (*Snapshot, error) {
+	var cachedImages kuikv1alpha1.CachedImageList
+	if err := k8sClient.List(ctx, &cachedImages); err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		Images:    []Image{},
+	}
+	for _, cachedImage := range cachedImages.Items {
+		if !cachedImage.Status.IsCached {
+			continue
+		}
+
+		digest, err := imageDigest(cachedImage.Spec.SourceImage)
+		if err != nil {
+			return nil, fmt.Errorf("could not read digest of image %s from cache: %w", cachedImage.Spec.SourceImage, err)
+		}
+
+		image := Image{
+			SourceImage: cachedImage.Spec.SourceImage,
+			Digest:      digest,
+			Pods:        []string{},
+		}
+		for _, pod := range cachedImage.Status.UsedBy.Pods {
+			image.Pods = append(image.Pods, pod.NamespacedName)
+		}
+		sort.Strings(image.Pods)
+		snapshot.Images = append(snapshot.Images, image)
+	}
+
+	sort.Slice(snapshot.Images, func(i, j int) bool {
+		return snapshot.Images[i].SourceImage < snapshot.Images[j].SourceImage
+	})
+
+	return snapshot, nil
+}
+
+// purl returns the package URL of an image, e.g.
+// pkg:oci/nginx@sha256%3Aabc?repository_url=index.docker.io/library/nginx&tag=1.25
+func (i *Image) purl() (string, error) {
+	ref, err := name.ParseReference(i.SourceImage)
+	if err != nil {
+		return "", err
+	}
+
+	repository := ref.Context()
+	qualifiers := url.Values{"repository_url": {repository.Name()}}
+	if tag, ok := ref.(name.Tag); ok {
+		qualifiers.Set("tag", tag.TagStr())
+	}
+
+	return fmt.Sprintf("pkg:oci/%s@%s?%s", path.Base(repository.RepositoryStr()), url.QueryEscape(i.Digest.String()), qualifiers.Encode()), nil
+}
@@ -0,0 +1,128 @@
+package snapshot
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	kuikv1alpha1 "github.com/enix/kube-image-keeper/api/v1alpha1"
+	"github.com/enix/kube-image-keeper/internal/scheme"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var nginxDigest = v1.Hash{Algorithm: "sha256", Hex: "4c0fdaa8b6341bfdeca5f18f7837462c80cff90527ee35ef185571e1c327beac"}
+
+func cachedImage(sourceImage string, isCached bool, pods ...string) *kuikv1alpha1.CachedImage {
+	cachedImage := &kuikv1alpha1.CachedImage{
+		ObjectMeta: metav1.ObjectMeta{Name: sourceImage},
+		Spec:       kuikv1alpha1.CachedImageSpec{SourceImage: sourceImage},
+		Status:     kuikv1alpha1.CachedImageStatus{IsCached: isCached},
+	}
+	for _, pod := range pods {
+		cachedImage.Status.UsedBy.Pods = append(cachedImage.Status.UsedBy.Pods, kuikv1alpha1.PodReference{NamespacedName: pod})
+	}
+	return cachedImage
+}
+
+func TestBuild(t *testing.T) {
+	g := NewWithT(t)
+
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme.NewScheme()).WithObjects(
+		cachedImage("redis", false, "default/redis"),
+		cachedImage("nginx", true, "prod/web-b", "prod/web-a"),
+		cachedImage("alpine", true),
+	).Build()
+	digests := map[string]v1.Hash{"nginx": nginxDigest, "alpine": {Algorithm: "sha256", Hex: "01"}}
+	imageDigest := func(image string) (v1.Hash, error) {
+		return digests[image], nil
+	}
+
+	snapshot, err := Build(context.Background(), k8sClient, imageDigest)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(snapshot.Images).To(Equal([]Image{
+		{SourceImage: "alpine", Digest: digests["alpine"], Pods: []string{}},
+		{SourceImage: "nginx", Digest: nginxDigest, Pods: []string{"prod/web-a", "prod/web-b"}},
+	}))
+
+	// Images whose digest can't be read make the snapshot incomplete
+	_, err = Build(context.Background(), k8sClient, func(string) (v1.Hash, error) {
+		return v1.Hash{}, errors.New("registry unavailable")
+	})
+	g.Expect(err).To(MatchError(ContainSubstring("registry unavailable")))
+}
+
+func TestMarshal(t *testing.T) {
+	g := NewWithT(t)
+
+	snapshot := &Snapshot{Images: []Image{
+		{SourceImage: "nginx:1.25", Digest: nginxDigest, Pods: []string{"prod/web-a", "prod/web-b"}},
+	}}
+	purl := "pkg:oci/nginx@sha256%3A4c0fdaa8b6341bfdeca5f18f7837462c80cff90527ee35ef185571e1c327beac?repository_url=index.docker.io%2Flibrary%2Fnginx&tag=1.25"
+
+	document, err := snapshot.Marshal(SPDX)
+	g.Expect(err).ToNot(HaveOccurred())
+	spdx := spdxDocument{}
+	g.Expect(json.Unmarshal(document, &spdx)).To(Succeed())
+	g.Expect(spdx.SPDXVersion).To(Equal("SPDX-2.3"))
+	g.Expect(spdx.Packages).To(HaveLen(1))
+	g.Expect(spdx.Packages[0].VersionInfo).To(Equal(nginxDigest.String()))
+	g.Expect(spdx.Packages[0].ExternalRefs[0].ReferenceLocator).To(Equal(purl))
+	g.Expect(spdx.Packages[0].Annotations).To(HaveLen(2))
+	g.Expect(spdx.Packages[0].Annotations[1].Comment).To(Equal("used by pod prod/web-b"))
+	g.Expect(spdx.Relationships).To(Equal([]spdxRelationship{{"SPDXRef-DOCUMENT", "DESCRIBES", "SPDXRef-Image-0"}}))
+
+	document, err = snapshot.Marshal(CycloneDX)
+	g.Expect(err).ToNot(HaveOccurred())
+	bom := cycloneDXBOM{}
+	g.Expect(json.Unmarshal(document, &bom)).To(Succeed())
+	g.Expect(bom.BOMFormat).To(Equal("CycloneDX"))
+	g.Expect(bom.Components).To(Equal([]cycloneDXComponent{{
+		Type:    "container",
+		Name:    "nginx:1.25",
+		Version: nginxDigest.String(),
+		PURL:    purl,
+		Hashes:  []cycloneDXHash{{Algorithm: "SHA-256", Content: nginxDigest.Hex}},
+		Properties: []cycloneDXProperty{
+			{Name: "kuik:pod", Value: "prod/web-a"},
+			{Name: "kuik:pod", Value: "prod/web-b"},
+		},
+	}}))
+
+	_, err = ParseFormat("csv")
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestLoadSigner(t *testing.T) {
+	g := NewWithT(t)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	path := filepath.Join(t.TempDir(), "key.pem")
+	g.Expect(os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)).To(Succeed())
+
+	signer, err := LoadSigner(path)
+	g.Expect(err).ToNot(HaveOccurred())
+	document := []byte(`{"spdxVersion":"SPDX-2.3"}`)
+	g.Expect(ed25519.Verify(publicKey, document, signer.Sign(document))).To(BeTrue())
+
+	encodedPublicKey, err := signer.PublicKey()
+	g.Expect(err).ToNot(HaveOccurred())
+	block, _ := pem.Decode(encodedPublicKey)
+	g.Expect(x509.ParsePKIXPublicKey(block.Bytes)).To(Equal(publicKey))
+
+	g.Expect(os.WriteFile(path, []byte("not a key"), 0600)).To(Succeed())
+	_, err = LoadSigner(path)
+	g.Expect(err).To(HaveOccurred())
+}
@@ -0,0 +1,82 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+var (
+	// MinVersion is the minimum TLS version of listeners and upstream clients, 0 to use the Go default
+	MinVersion uint16
+	// CipherSuites are the cipher suites allowed for TLS 1.0 to 1.2 by listeners and upstream clients, nil to use the
+	// Go default. TLS 1.3 cipher suites are not configurable.
+	CipherSuites []uint16
+)
+
+var versions = map[string]uint16{
+	"VersionTLS10": tls.VersionTLS10,
+	"VersionTLS11": tls.VersionTLS11,
+	"VersionTLS12": tls.VersionTLS12,
+	"VersionTLS13": tls.VersionTLS13,
+}
+
+// SetMinVersion sets MinVersion from its name, e.g. "VersionTLS12", empty to use the Go default
+func SetMinVersion(name string) error {
+	if name == "" {
+		MinVersion = 0
+		return nil
+	}
+
+	version, ok := versions[name]
+	if !ok {
+		return fmt.Errorf("unknown TLS version %q, supported versions are VersionTLS10, VersionTLS11, VersionTLS12 and VersionTLS13", name)
+	}
+
+	MinVersion = version
+	return nil
+}
+
+// SetCipherSuites sets CipherSuites from a comma separated list of IANA cipher suite names, empty to use the Go
+// default. Cipher suites with known security issues are refused.
+func SetCipherSuites(names string) error {
+	if names == "" {
+		CipherSuites = nil
+		return nil
+	}
+
+	supported := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		supported[suite.Name] = suite.ID
+	}
+
+	cipherSuites := []uint16{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := supported[name]
+		if !ok {
+			return fmt.Errorf("unsupported or insecure TLS cipher suite %q", name)
+		}
+		cipherSuites = append(cipherSuites, id)
+	}
+
+	CipherSuites = cipherSuites
+	return nil
+}
+
+// Apply enforces the TLS policy on a TLS configuration
+func Apply(config *tls.Config) {
+	if MinVersion != 0 {
+		config.MinVersion = MinVersion
+	}
+	if CipherSuites != nil {
+		config.CipherSuites = CipherSuites
+	}
+}
+
+// New returns a TLS configuration enforcing the TLS policy
+func New() *tls.Config {
+	config := &tls.Config{}
+	Apply(config)
+	return config
+}
@@ -0,0 +1,29 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestPolicy(t *testing.T) {
+	g := NewWithT(t)
+	defer func() {
+		MinVersion, CipherSuites = 0, nil
+	}()
+
+	g.Expect(SetMinVersion("VersionTLS12")).To(Succeed())
+	g.Expect(SetCipherSuites("TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384")).To(Succeed())
+
+	config := New()
+	g.Expect(config.MinVersion).To(BeEquivalentTo(tls.VersionTLS12))
+	g.Expect(config.CipherSuites).To(Equal([]uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}))
+
+	g.Expect(SetMinVersion("1.2")).ToNot(Succeed())
+	g.Expect(SetCipherSuites("TLS_RSA_WITH_RC4_128_SHA")).ToNot(Succeed())
+
+	g.Expect(SetMinVersion("")).To(Succeed())
+	g.Expect(SetCipherSuites("")).To(Succeed())
+	g.Expect(New()).To(Equal(&tls.Config{}))
+}
@@ -0,0 +1,50 @@
+package registrytest
+
+import (
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+// layerSize is the size in bytes of the random layers of fixture images, kept small so that tests stay fast
+const layerSize = 100
+
+// RandomImage returns an image made of random layers
+func RandomImage(t testing.TB, layers int) v1.Image {
+	image, err := random.Image(layerSize, int64(layers))
+	if err != nil {
+		t.Fatalf("could not generate image: %s", err)
+	}
+	return image
+}
+
+// RandomIndex returns an image index of random images made of random layers
+func RandomIndex(t testing.TB, images int, layers int) v1.ImageIndex {
+	index, err := random.Index(layerSize, int64(layers), int64(images))
+	if err != nil {
+		t.Fatalf("could not generate index: %s", err)
+	}
+	return index
+}
+
+// PlatformIndex returns a multi-arch image index with a random image of one layer per platform, given as os/arch or
+// os/arch/variant, e.g. linux/arm64/v8
+func PlatformIndex(t testing.TB, platforms ...string) v1.ImageIndex {
+	var index v1.ImageIndex = empty.Index
+	for _, platform := range platforms {
+		parts := strings.SplitN(platform, "/", 3)
+		if len(parts) < 2 {
+			t.Fatalf("invalid platform %q, expected os/arch or os/arch/variant", platform)
+		}
+		descriptor := v1.Descriptor{Platform: &v1.Platform{OS: parts[0], Architecture: parts[1]}}
+		if len(parts) == 3 {
+			descriptor.Platform.Variant = parts[2]
+		}
+		index = mutate.AppendManifests(index, mutate.IndexAddendum{Add: RandomImage(t, 1), Descriptor: descriptor})
+	}
+	return index
+}
@@ -0,0 +1,152 @@
+// Package registrytest provides an in-memory OCI registry and fixture images to test code interacting with
+// kube-image-keeper, e.g. automation built on its CRDs, without reaching real registries. Registries can be used as
+// fake upstream registries and fail requests on demand to test error handling.
+package registrytest
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// nopLogger discards the logs of the registry, which would clutter the output of tests
+var nopLogger = log.New(io.Discard, "", 0)
+
+// Failure makes a Registry answer matching requests with an error status instead of serving them
+type Failure struct {
+	// Method of the failing requests, any method if empty
+	Method string
+	// PathContains is a part of the path of the failing requests, e.g. "/manifests/", any path if empty
+	PathContains string
+	// StatusCode is the status failing requests are answered with, e.g. 429 to simulate rate limiting
+	StatusCode int
+	// Times is how many requests fail, every matching request if 0
+	Times int
+}
+
+func (f *Failure) matches(req *http.Request) bool {
+	return (f.Method == "" || f.Method == req.Method) && strings.Contains(req.URL.Path, f.PathContains)
+}
+
+// Registry is an in-memory OCI registry served over HTTP, which records the requests it receives
+type Registry struct {
+	server *httptest.Server
+
+	mutex    sync.Mutex
+	failures []*Failure
+	requests []string
+	headers  http.Header
+}
+
+// New starts a Registry that is closed once the test and its subtests are complete
+func New(t testing.TB) *Registry {
+	r := &Registry{headers: http.Header{}}
+	handler := ggcrregistry.New(ggcrregistry.Logger(nopLogger))
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.writeHeaders(w)
+		if statusCode := r.record(req); statusCode != 0 {
+			w.WriteHeader(statusCode)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	}))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+// record records a request and returns the status it must fail with, or 0 if it must be served
+func (r *Registry) record(req *http.Request) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.requests = append(r.requests, req.Method+" "+req.URL.Path)
+	for i, failure := range r.failures {
+		if !failure.matches(req) {
+			continue
+		}
+		if failure.Times > 0 {
+			failure.Times--
+			if failure.Times == 0 {
+				r.failures = append(r.failures[:i], r.failures[i+1:]...)
+			}
+		}
+		return failure.StatusCode
+	}
+	return 0
+}
+
+func (r *Registry) writeHeaders(w http.ResponseWriter) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for key, values := range r.headers {
+		w.Header()[key] = values
+	}
+}
+
+// Addr returns the host and port of the registry, e.g. 127.0.0.1:38291
+func (r *Registry) Addr() string {
+	return strings.TrimPrefix(r.server.URL, "http://")
+}
+
+// URL returns the base URL of the registry, e.g. http://127.0.0.1:38291
+func (r *Registry) URL() string {
+	return r.server.URL
+}
+
+// Reference returns the reference of an image of the registry, e.g. 127.0.0.1:38291/library/alpine:3.19 for
+// library/alpine:3.19
+func (r *Registry) Reference(t testing.TB, image string) name.Reference {
+	ref, err := name.ParseReference(r.Addr() + "/" + image)
+	if err != nil {
+		t.Fatalf("invalid image %q: %s", image, err)
+	}
+	return ref
+}
+
+// Fail makes the registry fail requests matching failure, before the ones given by previous calls
+func (r *Registry) Fail(failure Failure) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.failures = append([]*Failure{&failure}, r.failures...)
+}
+
+// SetHeader sets a header of every response, e.g. RateLimit-Remaining to advertise a rate limit like Docker Hub does
+func (r *Registry) SetHeader(key string, value string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.headers.Set(key, value)
+}
+
+// Requests returns the requests received by the registry, as method and path, e.g. "HEAD /v2/alpine/manifests/3.19"
+func (r *Registry) Requests() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string{}, r.requests...)
+}
+
+// PushImage pushes an image to the registry and returns its reference
+func (r *Registry) PushImage(t testing.TB, image string, img v1.Image) name.Reference {
+	ref := r.Reference(t, image)
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatalf("could not push image %s: %s", ref, err)
+	}
+	return ref
+}
+
+// PushIndex pushes an image index to the registry and returns its reference
+func (r *Registry) PushIndex(t testing.TB, image string, index v1.ImageIndex) name.Reference {
+	ref := r.Reference(t, image)
+	if err := remote.WriteIndex(ref, index); err != nil {
+		t.Fatalf("could not push index %s: %s", ref, err)
+	}
+	return ref
+}
@@ -0,0 +1,63 @@
+package registrytest
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/onsi/gomega"
+)
+
+func TestRegistry(t *testing.T) {
+	g := NewWithT(t)
+	registry := New(t)
+
+	image := RandomImage(t, 2)
+	ref := registry.PushImage(t, "library/alpine:3.19", image)
+	g.Expect(ref.String()).To(Equal(registry.Addr() + "/library/alpine:3.19"))
+
+	descriptor, err := remote.Head(ref)
+	g.Expect(err).ToNot(HaveOccurred())
+	digest, err := image.Digest()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(descriptor.Digest).To(Equal(digest))
+	g.Expect(registry.Requests()).To(ContainElement("HEAD /v2/library/alpine/manifests/3.19"))
+
+	index := PlatformIndex(t, "linux/amd64", "linux/arm64/v8")
+	ref = registry.PushIndex(t, "library/nginx:1.25", index)
+	pulled, err := remote.Index(ref)
+	g.Expect(err).ToNot(HaveOccurred())
+	manifest, err := pulled.IndexManifest()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(manifest.Manifests).To(HaveLen(2))
+	g.Expect(manifest.Manifests[1].Platform.Variant).To(Equal("v8"))
+}
+
+func TestRegistry_Fail(t *testing.T) {
+	g := NewWithT(t)
+	registry := New(t)
+	registry.PushImage(t, "library/alpine:3.19", RandomImage(t, 1))
+	manifestURL := registry.URL() + "/v2/library/alpine/manifests/3.19"
+
+	status := func(method string) int {
+		req, err := http.NewRequest(method, manifestURL, nil)
+		g.Expect(err).ToNot(HaveOccurred())
+		resp, err := http.DefaultClient.Do(req)
+		g.Expect(err).ToNot(HaveOccurred())
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	registry.Fail(Failure{PathContains: "/manifests/", StatusCode: http.StatusTooManyRequests, Times: 2})
+	g.Expect(status(http.MethodHead)).To(Equal(http.StatusTooManyRequests))
+	g.Expect(status(http.MethodGet)).To(Equal(http.StatusTooManyRequests))
+	g.Expect(status(http.MethodHead)).To(Equal(http.StatusOK))
+
+	// Failures given last take precedence, failing every matching request when Times is 0
+	registry.Fail(Failure{Method: http.MethodGet, StatusCode: http.StatusInternalServerError})
+	registry.Fail(Failure{Method: http.MethodGet, PathContains: "/manifests/", StatusCode: http.StatusUnauthorized})
+	for i := 0; i < 3; i++ {
+		g.Expect(status(http.MethodGet)).To(Equal(http.StatusUnauthorized))
+	}
+	g.Expect(status(http.MethodHead)).To(Equal(http.StatusOK))
+}